@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// AcquisitionOutput placeholder replaced by the path of
+	// the memory image in acquisition tool arguments
+	AcquisitionOutput = "{{output}}"
+)
+
+var (
+	ErrAcquisitionDisabled = errors.New("memory acquisition is disabled")
+	ErrAcquisitionRunning  = errors.New("memory acquisition already running")
+	ErrAcquisitionReplay   = errors.New("memory acquisition command already run")
+)
+
+// AcquisitionChunk is a chunk of memory image uploaded as an artifact
+type AcquisitionChunk struct {
+	Index  int    `json:"index"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// path of the artifact, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact"`
+}
+
+// MemoryAcquisition describes a full physical memory acquisition, it is
+// also uploaded as the manifest of the chunks making the memory image
+type MemoryAcquisition struct {
+	// identifier of the acquisition, artifacts are grouped by acquisition
+	ID       string              `json:"id"`
+	Command  string              `json:"command-uuid"`
+	Running  bool                `json:"running"`
+	Start    time.Time           `json:"start"`
+	End      time.Time           `json:"end,omitempty"`
+	Size     int64               `json:"size"`
+	Sha256   string              `json:"sha256,omitempty"`
+	Chunks   []*AcquisitionChunk `json:"chunks"`
+	Manifest string              `json:"manifest,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// acquisitionArgs returns acquisition tool arguments with
+// output placeholder replaced by output
+func acquisitionArgs(args []string, output string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = strings.ReplaceAll(arg, AcquisitionOutput, output)
+	}
+	return out
+}
+
+// acquisitionChunkSize returns the size of memory image chunks, chunks must
+// be uploadable once compressed, at worst they are not compressible at all
+func (a *Agent) acquisitionChunkSize() int64 {
+	size := a.config.AcqConfig.ChunkSize
+	if max := a.config.FwdConfig.Client.MaxUploadSize; size <= 0 || size > max {
+		size = max
+	}
+	return size
+}
+
+// AcquisitionStatus returns a copy of the status of the last memory acquisition
+func (a *Agent) AcquisitionStatus() *MemoryAcquisition {
+	a.acquisition.Lock()
+	defer a.acquisition.Unlock()
+
+	if a.acquisition.current == nil {
+		return nil
+	}
+
+	acq := *a.acquisition.current
+	return &acq
+}
+
+// startAcquisition starts a full physical memory acquisition in background. It
+// is only started if acquisition is enabled and if command is signed by the
+// manager. Commands can be run only once.
+func (a *Agent) startAcquisition(cmd *api.EndpointCommand) (acq *MemoryAcquisition, err error) {
+	c := a.config.AcqConfig
+
+	if !c.Enable || c.Tool == "" {
+		return nil, ErrAcquisitionDisabled
+	}
+
+	if err = cmd.VerifySignature(c.CommandKey, a.config.FwdConfig.Client.UUID, time.Now()); err != nil {
+		return nil, fmt.Errorf("refusing to acquire memory: %w", err)
+	}
+
+	a.acquisition.Lock()
+	defer a.acquisition.Unlock()
+
+	if a.acquisition.current != nil && a.acquisition.current.Running {
+		return nil, ErrAcquisitionRunning
+	}
+
+	if a.acquisition.done[cmd.UUID] {
+		return nil, ErrAcquisitionReplay
+	}
+	a.acquisition.done[cmd.UUID] = true
+
+	acq = &MemoryAcquisition{
+		ID:      fmt.Sprintf("{%s}", utils.UnsafeUUID()),
+		Command: cmd.UUID,
+		Running: true,
+		Start:   time.Now().UTC(),
+		Chunks:  make([]*AcquisitionChunk, 0),
+	}
+	a.acquisition.current = acq
+
+	go a.runAcquisition(acq)
+
+	status := *acq
+	return &status, nil
+}
+
+// runAcquisition runs acquisition tool then splits memory image into chunks
+// uploaded as artifacts, along with a manifest describing them
+func (a *Agent) runAcquisition(acq *MemoryAcquisition) {
+	var err error
+
+	c := a.config.AcqConfig
+	// raw image is written outside of artifacts directories not to be uploaded
+	image := filepath.Join(a.config.Dump.Dir, fmt.Sprintf("%s.raw", acq.ID))
+
+	defer func() {
+		os.Remove(image)
+
+		a.acquisition.Lock()
+		defer a.acquisition.Unlock()
+
+		acq.Running = false
+		acq.End = time.Now().UTC()
+		if err != nil {
+			acq.Error = err.Error()
+			a.logger.Errorf("memory acquisition %s failed: %s", acq.ID, err)
+		} else {
+			a.logger.Infof("memory acquisition %s done in %s", acq.ID, acq.End.Sub(acq.Start))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(a.ctx, c.Timeout)
+	}
+	defer cancel()
+
+	a.logger.Infof("starting memory acquisition %s with %s", acq.ID, c.Tool)
+	cmd := exec.CommandContext(ctx, c.Tool, acquisitionArgs(c.Args, image)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		a.logger.Errorf("memory acquisition tool failed: %s: %s", err, out)
+	}
+
+	// some tools return non zero exit codes on success so we rely on the image
+	err = a.chunkAcquisition(acq, image)
+}
+
+// chunkAcquisition splits memory image into chunks and collects them as artifacts
+func (a *Agent) chunkAcquisition(acq *MemoryAcquisition, image string) (err error) {
+	var f *os.File
+	var fi os.FileInfo
+	var manifest []byte
+
+	if f, err = os.Open(image); err != nil {
+		return fmt.Errorf("memory image not found: %w", err)
+	}
+	defer f.Close()
+
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+
+	if fi.Size() == 0 {
+		return fmt.Errorf("empty memory image")
+	}
+
+	h := sha256.New()
+	size := a.acquisitionChunkSize()
+	for off, i := int64(0), 0; off < fi.Size(); off, i = off+size, i+1 {
+		chunk := &AcquisitionChunk{Index: i, Offset: off}
+		sr := io.NewSectionReader(f, off, size)
+
+		if chunk.Artifact, chunk.Sha256, err = a.collectArtifact(acq.ID, fmt.Sprintf("memory.raw.%04d", i), io.TeeReader(sr, h)); err != nil {
+			return fmt.Errorf("failed to collect chunk %d: %w", i, err)
+		}
+		chunk.Size = min64(size, fi.Size()-off)
+
+		a.acquisition.Lock()
+		acq.Chunks = append(acq.Chunks, chunk)
+		acq.Size += chunk.Size
+		a.acquisition.Unlock()
+	}
+
+	a.acquisition.Lock()
+	acq.Sha256 = hex.EncodeToString(h.Sum(nil))
+	manifest, err = json.Marshal(acq)
+	a.acquisition.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	// manifest allows to check memory image once chunks are concatenated
+	artifact, _, err := a.collectArtifact(acq.ID, "memory.json", bytes.NewReader(manifest))
+
+	a.acquisition.Lock()
+	acq.Manifest = artifact
+	a.acquisition.Unlock()
+
+	return
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
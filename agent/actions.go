@@ -9,16 +9,18 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/0xrawsec/gene/v2/engine"
-	"github.com/0xrawsec/golang-utils/crypto/file"
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/sync/semaphore"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
 	"github.com/0xrawsec/golang-win32/win32/dbghelp"
 	"github.com/0xrawsec/golang-win32/win32/kernel32"
+	"github.com/0xrawsec/whids/agent/config"
 	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 )
@@ -36,15 +38,20 @@ const (
 	reportFilename   = "report.json"
 	eventFilename    = "event.json"
 	registryFilename = "registry.json"
+	playbookFilename = "playbook.json"
 )
 
 var (
 	AvailableActions = []string{
 		ActionKill,
+		ActionKillTree,
+		ActionSuspend,
 		ActionBlacklist,
 		ActionMemdump,
 		ActionFiledump,
 		ActionRegdump,
+		ActionPcap,
+		ActionIsolateProcess,
 		ActionReport,
 		ActionBrief,
 	}
@@ -92,6 +99,11 @@ func (m *ActionHandler) prepare(e *event.EdrEvent, filename string) string {
 }
 
 func (m *ActionHandler) shouldDump(e *event.EdrEvent) bool {
+	// trusted security tools are never dumped
+	if m.edr.trustedSource(e) {
+		return false
+	}
+
 	guid := sourceGUIDFromEvent(e)
 	return m.edr.tracker.CheckDumpCountOrInc(guid, m.edr.config.Dump.MaxDumps, m.edr.config.Dump.DumpUntracked)
 }
@@ -121,6 +133,9 @@ func (m *ActionHandler) dumpBinFile(e *event.EdrEvent, src string) error {
 func (m *ActionHandler) dumpFile(src, dst string) (err error) {
 	var sha256 string
 
+	// agent might be a WOW64 process
+	src = utils.NativePath(src)
+
 	if !fsutil.IsFile(src) || utils.IsPipePath(src) {
 		return
 	}
@@ -129,7 +144,7 @@ func (m *ActionHandler) dumpFile(src, dst string) (err error) {
 		return err
 	}
 
-	if sha256, err = file.Sha256(src); err != nil {
+	if sha256, err = m.edr.hashCache.Sha256(src); err != nil {
 		return err
 	}
 
@@ -229,14 +244,25 @@ func (m *ActionHandler) filedumpSet(e *event.EdrEvent) *datastructs.Set {
 	return s
 }
 
-func (m *ActionHandler) filedump(e *event.EdrEvent) {
+// filedump dumps all the files related to an event, it returns
+// the last error encountered
+func (m *ActionHandler) filedump(e *event.EdrEvent) (err error) {
 	hash := e.Hash()
+	dumped := make([]string, 0)
 	for _, i := range m.filedumpSet(e).Slice() {
 		filename := i.(string)
-		if err := m.dumpBinFile(e, filename); err != nil {
+		if ferr := m.dumpBinFile(e, filename); ferr != nil {
 			m.edr.logger.Errorf(`Failed to dump file="%s" event=%s`, filename, hash)
+			err = fmt.Errorf("failed to dump file %s: %w", filename, ferr)
+		} else {
+			dumped = append(dumped, filename)
 		}
 	}
+
+	if c := m.edr.config.YaraConfig; c.Enable && c.ScanFiledumps && len(dumped) > 0 {
+		m.yaraScanDumps(e, yaraFilesFilename, dumped...)
+	}
+	return
 }
 
 func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
@@ -250,14 +276,22 @@ func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
 			m.edr.dumping.Add(guid)
 			defer m.edr.dumping.Del(guid)
 
-			dumpFilename := fmt.Sprintf("%s_%d_%d.dmp", filepath.Base(pt.Image), pid, time.Now().UnixNano())
-			dumpPath := m.prepare(e, dumpFilename)
+			// a WOW64 agent cannot dump native processes
+			if utils.IsWow64Self() && !utils.IsWow64Pid(pid) {
+				return fmt.Errorf("cannot dump process event=%s pid=%d image=%s from a WOW64 agent, a native agent build is required", hash, pid, pt.Image)
+			}
+
+			dumpPath := m.prepare(e, memdumpFilename(pt.Image, pid))
 			if err = dbghelp.FullMemoryMiniDump(pid, dumpPath); err != nil {
 				return fmt.Errorf("failed to dump process event=%s pid=%d image=%s: %s", hash, pid, pt.Image, err)
 			} else {
 				// dump was successfull
 				m.edr.memdumped.Add(guid)
 				m.queueCompression(dumpPath)
+				// process is still around (suspended if it is about to be killed)
+				if c := m.edr.config.YaraConfig; c.Enable && c.ScanMemdumps {
+					m.yaraScanDumps(e, yaraMemoryFilename, strconv.Itoa(pid))
+				}
 			}
 		} else {
 			return fmt.Errorf("cannot dump process event=%s pid=%d, process is already terminated", hash, pid)
@@ -269,44 +303,87 @@ func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
 }
 
 type RegDump struct {
-	Path  string `json:"path"`
-	Data  any    `json:"data"`
-	Error string `json:"error"`
+	Path      string `json:"path"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Data      any    `json:"data"`
+	Error     string `json:"error"`
 }
 
-func regDump(path string) (r *RegDump, err error) {
+// regDump dumps a registry value, data bigger than max bytes
+// is truncated (0 means no limit)
+func regDump(path string, max int) (r *RegDump, err error) {
+	var data []byte
+	var dtype uint32
+
 	r = &RegDump{}
 	r.Path = path
-	if r.Data, err = utils.RegValue(path); err != nil {
+	if data, dtype, err = advapi32.RegGetValueFromString(path); err != nil {
+		r.Error = err.Error()
+		return
+	}
+
+	r.Size = len(data)
+	if max > 0 && len(data) > max {
+		// keeps UTF16 strings aligned
+		data = data[:max&^1]
+		r.Truncated = true
+	}
+
+	if r.Data, err = advapi32.ParseRegValue(data, dtype); err != nil {
 		r.Error = err.Error()
 		return
 	}
 	return
 }
 
-func (m *ActionHandler) regdump(e *event.EdrEvent) {
+// dumpRegValue dumps the value set by a Sysmon SetValue event
+func (m *ActionHandler) dumpRegValue(e *event.EdrEvent, targetObject string) {
 	var err error
 	var r *RegDump
 
+	dumpPath := m.prepare(e, registryFilename)
+	// retrieving registry
+	if r, err = regDump(targetObject, m.edr.config.RegistryConfig.MaxDumpSize); err != nil {
+		m.edr.logger.Errorf("failed to retrieve registry value: registry=%s error=%s", targetObject, err)
+	}
+
+	// we continue dumping, if any error is encountered, it will be stored in RegDump struct
+	if err = m.dumpAsJson(dumpPath, r); err != nil {
+		m.edr.logger.Errorf("failed to dump registry: registry=%s error=%s", targetObject, err)
+	}
+
+	m.queueCompression(dumpPath)
+}
+
+// valuedump dumps registry values flagged by hookSetValueSize along with the event
+func (m *ActionHandler) valuedump(e *event.EdrEvent) {
+	if targetObject, ok := e.GetString(pathSysmonTargetObject); ok {
+		m.dumpRegValue(e, targetObject)
+
+		eventDumpPath := m.prepare(e, eventFilename)
+		if err := m.dumpAsJson(eventDumpPath, e); err != nil {
+			m.edr.logger.Errorf("Failed to dump event %s: %s", e.Hash(), err)
+		} else {
+			m.queueCompression(eventDumpPath)
+		}
+	}
+}
+
+func (m *ActionHandler) regdump(e *event.EdrEvent) {
 	if e.Channel() == sysmonChannel {
 		switch e.EventID() {
 		case SysmonRegSetValue:
+			// value already dumped
+			if valueDumpRequested(e) {
+				return
+			}
+
 			if targetObject, ok := e.GetString(pathSysmonTargetObject); ok {
 				if details, ok := e.GetString(pathSysmonDetails); ok {
 					// We dump only if Details is "Binary Data" since the other kinds can be seen in the raw event
 					if details == "Binary Data" {
-						dumpPath := m.prepare(e, registryFilename)
-						// retrieving registry
-						if r, err = regDump(targetObject); err != nil {
-							m.edr.logger.Errorf("failed to retrieve registry value: registry=%s error=%s", targetObject, err)
-						}
-
-						// we continue dumping, if any error is encountered, it will be stored in RegDump struct
-						if err = m.dumpAsJson(dumpPath, r); err != nil {
-							m.edr.logger.Errorf("failed to dump registry: registry=%s error=%s", targetObject, err)
-						}
-
-						m.queueCompression(dumpPath)
+						m.dumpRegValue(e, targetObject)
 					}
 				}
 			}
@@ -315,14 +392,11 @@ func (m *ActionHandler) regdump(e *event.EdrEvent) {
 	}
 }
 
-func (m *ActionHandler) suspend_process(e *event.EdrEvent) {
+func (m *ActionHandler) suspend_process(e *event.EdrEvent) error {
 	if pt := m.edr.tracker.SourceTrackFromEvent(e); !pt.IsZero() {
-		// additional check not to suspend agent
-		if pt.PID != int64(os.Getpid()) {
-			// before we kill we suspend the process
-			kernel32.SuspendProcess(int(pt.PID))
-		}
+		return m.edr.suspendProcess(pt)
 	}
+	return fmt.Errorf("cannot suspend untracked process event=%s", e.Hash())
 }
 
 func (m *ActionHandler) kill_process(e *event.EdrEvent) error {
@@ -338,13 +412,35 @@ func (m *ActionHandler) kill_process(e *event.EdrEvent) error {
 	return nil
 }
 
+func (m *ActionHandler) kill_tree(e *event.EdrEvent) (err error) {
+	if pt := m.edr.tracker.SourceTrackFromEvent(e); !pt.IsZero() {
+		_, err = m.edr.killTree(pt)
+		return
+	}
+	return fmt.Errorf("cannot kill tree of untracked process event=%s", e.Hash())
+}
+
+func (m *ActionHandler) isolate_process(e *event.EdrEvent) (err error) {
+	if pt := m.edr.tracker.SourceTrackFromEvent(e); !pt.IsZero() {
+		_, err = m.edr.isolateProcess(pt)
+		return
+	}
+	return fmt.Errorf("cannot isolate untracked process event=%s", e.Hash())
+}
+
 func (m *ActionHandler) Queue(e *event.EdrEvent) {
-	if !m.edr.IsHIDSEvent(e) && m.edr.config.Endpoint {
+	if !m.edr.IsHIDSEvent(e) && m.edr.config.Endpoint && !m.edr.options.lowPrivilege {
 		if det := e.GetDetection(); det != nil {
-			if det.Actions.Len() > 0 {
+			if det.Actions.Len() > 0 || m.edr.playbooks.ForDetection(det) != nil {
 				m.queue.Push(e)
+				return
 			}
 		}
+
+		// registry values flagged by hooks are dumped even without detection
+		if valueDumpRequested(e) {
+			m.queue.Push(e)
+		}
 	}
 }
 
@@ -352,20 +448,37 @@ func (m *ActionHandler) HandleActions(e *event.EdrEvent) {
 
 	det := e.GetDetection()
 
+	if config.FeatureDump.Compiled() && valueDumpRequested(e) && !m.edr.IsHIDSEvent(e) {
+		m.valuedump(e)
+	}
+
+	// playbooks supersede rule actions
+	if pb := m.edr.playbooks.ForDetection(det); pb != nil && !m.edr.IsHIDSEvent(e) {
+		m.handlePlaybook(pb, e)
+		return
+	}
+
 	if m.shouldDump(e) && !m.edr.IsHIDSEvent(e) && det != nil {
 		hash := e.Hash()
 
-		// Test variables
+		// Test variables, actions not compiled in are ignored
 		report := det.Actions.Contains(ActionReport)
 		brief := det.Actions.Contains(ActionBrief)
-		kill := det.Actions.Contains(ActionKill)
+		killTree := config.FeatureResponse.Compiled() && det.Actions.Contains(ActionKillTree)
+		kill := killTree || config.FeatureResponse.Compiled() && det.Actions.Contains(ActionKill)
+		suspend := config.FeatureResponse.Compiled() && det.Actions.Contains(ActionSuspend)
+		dump := config.FeatureDump.Compiled()
 
 		// handling blacklisting action
-		if det.Actions.Contains(ActionBlacklist) {
+		if config.FeatureResponse.Compiled() && det.Actions.Contains(ActionBlacklist) {
 			if pt := m.edr.tracker.SourceTrackFromEvent(e); !pt.IsZero() {
 				// additional check not to blacklist agent
 				if int(pt.PID) != os.Getpid() {
-					m.edr.tracker.Blacklist(pt.CommandLine)
+					if killTree {
+						m.edr.tracker.BlacklistTree(pt.CommandLine)
+					} else {
+						m.edr.tracker.Blacklist(pt.CommandLine)
+					}
 				}
 			}
 		}
@@ -374,22 +487,38 @@ func (m *ActionHandler) HandleActions(e *event.EdrEvent) {
 			// we suspend process before to kill it so that we can
 			// memdump it
 			m.suspend_process(e)
+		} else if suspend {
+			// process is left suspended for forensic dumping
+			if err := m.suspend_process(e); err != nil {
+				m.edr.logger.Error(err)
+			}
 		}
 
 		// handling report memdumping
-		if det.Actions.Contains(ActionMemdump) {
+		if dump && det.Actions.Contains(ActionMemdump) {
 			if err := m.memdump(e); err != nil {
 				m.edr.logger.Error(err)
 			}
 		}
 
 		// we kill the process after we dumped memory
-		if kill {
+		if killTree {
+			if err := m.kill_tree(e); err != nil {
+				m.edr.logger.Error(err)
+			}
+		} else if kill {
 			if err := m.kill_process(e); err != nil {
 				m.edr.logger.Error(err)
 			}
 		}
 
+		// network isolation of the process instead of the whole host
+		if config.FeatureResponse.Compiled() && !kill && det.Actions.Contains(ActionIsolateProcess) {
+			if err := m.isolate_process(e); err != nil {
+				m.edr.logger.Error(err)
+			}
+		}
+
 		// handling report dumping
 		if (report || brief) && m.edr.config.Report.EnableReporting {
 			reportPath := m.prepare(e, reportFilename)
@@ -401,15 +530,22 @@ func (m *ActionHandler) HandleActions(e *event.EdrEvent) {
 		}
 
 		// handling filedumping
-		if det.Actions.Contains(ActionFiledump) {
+		if dump && det.Actions.Contains(ActionFiledump) {
 			m.filedump(e)
 		}
 
 		// handling regdumping
-		if det.Actions.Contains(ActionRegdump) {
+		if dump && det.Actions.Contains(ActionRegdump) {
 			m.regdump(e)
 		}
 
+		// handling packet capture, runs in background
+		if dump && det.Actions.Contains(ActionPcap) && m.edr.config.PcapConfig.Enable {
+			if err := m.pcap(e); err != nil {
+				m.edr.logger.Error(err)
+			}
+		}
+
 		// dumping the event
 		eventDumpPath := m.prepare(e, eventFilename)
 		if err := m.dumpAsJson(eventDumpPath, e); err != nil {
@@ -437,9 +573,11 @@ func (m *ActionHandler) compressionLoop() {
 		for m.compressionQueue.Len() > 0 {
 			if elt := m.compressionQueue.Pop(); elt != nil {
 				path := elt.Value.(string)
+				start := time.Now()
 				if err := utils.GzipFileBestSpeed(path); err != nil {
 					m.edr.logger.Errorf(`Failed to compress %s: %s`, path, err)
 				}
+				m.edr.perf.dumps.since(start)
 			}
 		}
 		time.Sleep(time.Second)
@@ -454,6 +592,7 @@ func (m *ActionHandler) handleActionsLoop() {
 				m.semJobs.Acquire()
 				go func() {
 					defer m.semJobs.Release()
+					defer m.edr.perf.dumps.since(time.Now())
 					m.HandleActions(evt)
 				}()
 			}
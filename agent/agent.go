@@ -1,3 +1,19 @@
+// Package agent implements the WHIDS endpoint agent. It can be embedded
+// in other Go programs, the lifecycle of an agent being:
+//
+//	a, err := agent.NewAgent(conf, agent.WithLogger(logger))
+//	if err != nil {
+//		return err
+//	}
+//	if err = a.Run(); err != nil {
+//		return err
+//	}
+//	// ... until the agent has to be stopped
+//	a.Stop()
+//	a.Wait()
+//
+// Options replace the package level settings and allow using a custom
+// EventProvider as event source or a custom EventForwarder as output.
 package agent
 
 import (
@@ -9,8 +25,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xrawsec/crony"
@@ -25,15 +43,21 @@ import (
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
+	"github.com/0xrawsec/whids/agent/beacon"
 	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/agent/kerberos"
+	"github.com/0xrawsec/whids/agent/lateral"
 	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/api/client"
 	"github.com/0xrawsec/whids/api/server"
 	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/ioc"
 	"github.com/0xrawsec/whids/los"
 	"github.com/0xrawsec/whids/sysmon"
 	"github.com/0xrawsec/whids/tools"
 	"github.com/0xrawsec/whids/utils"
+	"github.com/0xrawsec/whids/utils/dga"
 )
 
 const (
@@ -72,11 +96,13 @@ type Agent struct {
 	// task scheduler
 	scheduler *crony.Crony
 
-	eventProvider   *etw.Consumer
+	options         options
+	eventProvider   EventProvider
 	stats           *EventStats
 	preHooks        *HookManager
 	postHooks       *HookManager
 	forwarder       *client.Forwarder
+	output          EventForwarder         // where events to forward are sent
 	channels        *datastructs.SyncedSet // Windows log channels to listen to
 	channelsSignals chan bool
 	config          *config.Agent
@@ -84,6 +110,68 @@ type Agent struct {
 
 	flagProcTermEn bool
 	bootCompleted  bool
+	// engine failed to load and only a minimal pipeline runs
+	safeMode bool
+	// containment state of host
+	containment struct {
+		sync.Mutex
+		state *api.ContainmentState
+	}
+	// live event tap
+	tap struct {
+		sync.Mutex
+		eventTap
+	}
+	// full physical memory acquisition
+	acquisition struct {
+		sync.Mutex
+		current *MemoryAcquisition
+		// commands already run
+		done map[string]bool
+	}
+	// packet capture of pcap action, one at a time
+	capture struct {
+		sync.Mutex
+		running bool
+	}
+	// time spent by subsystems
+	perf *perfCounters
+	// agent state stamped on forwarded events (*event.AgentData)
+	metadata atomic.Value
+	// rules and containers usage reported to manager
+	usage struct {
+		sync.Mutex
+		ruleContainers map[string][]string
+		report         *api.UsageReport
+	}
+	// rule tags used to apply maintenance windows
+	maintenance struct {
+		sync.RWMutex
+		ruleTags map[string][]string
+	}
+	// significant errors reported to manager
+	errors struct {
+		sync.Mutex
+		report *api.AgentErrorReport
+	}
+	// detection coverage self-test running
+	selftest struct {
+		sync.RWMutex
+		run *selfTestRun
+	}
+	// etw: channels subscribed to, they can change at runtime
+	subscriptions struct {
+		sync.Mutex
+		channels []string
+	}
+	// telemetry sources missing and fallbacks enabled
+	telemetry *sysinfo.Telemetry
+	// host firewall state and listening ports
+	inventory struct {
+		sync.Mutex
+		firewall *sysinfo.Firewall
+		ports    []*sysinfo.ListeningPort
+	}
 	// Sysmon GUID of HIDS process
 	guid          string
 	tracker       *ActivityTracker
@@ -91,6 +179,29 @@ type Agent struct {
 	memdumped     *datastructs.SyncedSet
 	dumping       *datastructs.SyncedSet
 	filedumped    *datastructs.SyncedSet
+	// GUIDs of the processes whose network traffic is blocked
+	isolated *datastructs.SyncedSet
+	// GUIDs of the processes suspended
+	suspended    *datastructs.SyncedSet
+	suppressions *Suppressions
+	playbooks    *Playbooks
+	trusted      *TrustedProcesses
+	regValues    *RegValueMonitor
+	eventDB      *EventDB
+	bookmarks    *Bookmarks
+	preventer    *Preventer
+	iocs         *ioc.IoCs
+	iocMemory    *IoCMemory
+	filterStats  *FilterStats
+	hashCache    *HashCache
+	dnsCache     *DNSCache
+	dgaModel     *dga.Model
+	watchlists   *Watchlists
+	dllBlocklist *DllBlocklist
+	beacons      *beacon.Analyzer
+	lateral      *lateral.Tracker
+	kerberos     *kerberos.Detector
+	schema       *event.SchemaRegistry
 
 	systemInfo *sysinfo.SystemInfo
 
@@ -100,9 +211,19 @@ type Agent struct {
 	//logger
 	logger *golog.Logger
 
-	Engine   *engine.Engine
-	DryRun   bool
-	PrintAll bool
+	// file the sink writes to
+	sinkFile *os.File
+
+	Engine *engine.Engine
+	DryRun bool
+	// Sink prints events passing through the agent, nil disables it
+	Sink *event.Sink
+	// Replay is a path to a file or named pipe to read canned
+	// events from instead of ETW (i.e. test mode)
+	Replay string
+	// ReplayEvtx are .evtx files, or directories holding such files,
+	// events are read from instead of ETW (i.e. offline analysis)
+	ReplayEvtx []string
 }
 
 func newActionnableEngine(c *config.Agent) (e *engine.Engine) {
@@ -123,10 +244,15 @@ func newActionnableEngine(c *config.Agent) (e *engine.Engine) {
 	return
 }
 
-// NewAgent creates a new Agent object from configuration
-func NewAgent(c *config.Agent) (a *Agent, err error) {
+// NewAgent creates a new Agent object from configuration. The agent
+// lifecycle is NewAgent, Run, Stop then Wait. Agent never exits the
+// program, errors are returned to the caller instead.
+func NewAgent(c *config.Agent, opts ...Option) (a *Agent, err error) {
 
 	a = &Agent{}
+	for _, opt := range opts {
+		opt(&a.options)
+	}
 	a.Initialize()
 
 	err = a.Prepare(c)
@@ -134,29 +260,63 @@ func NewAgent(c *config.Agent) (a *Agent, err error) {
 	return
 }
 
+// Initialize initializes agent's internal structures
 func (a *Agent) Initialize() {
+	parent := a.options.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+
 	// context inititialization
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 
 	a.ctx = ctx
 	a.cancel = cancel
 	a.scheduler = crony.NewWithContext(ctx)
-	a.eventProvider = etw.NewRealTimeConsumer(ctx)
-	a.stats = NewEventStats(MaxEPS, MaxEPSDuration)
+
+	a.eventProvider = a.options.provider
+	if a.eventProvider == nil {
+		a.eventProvider = newEtwProvider(ctx)
+	}
+
+	if a.options.maxEPS > 0 {
+		a.stats = NewEventStats(a.options.maxEPS, a.options.epsDur)
+	} else {
+		a.stats = NewEventStats(MaxEPS, MaxEPSDuration)
+	}
 	a.preHooks = NewHookMan()
 	a.postHooks = NewHookMan()
 	a.channels = datastructs.NewSyncedSet()
 	a.channelsSignals = make(chan bool)
 	a.waitGroup = sync.WaitGroup{}
 	a.tracker = NewActivityTracker()
+	a.containment.state = &api.ContainmentState{}
+	a.acquisition.done = make(map[string]bool)
+	a.perf = &perfCounters{}
+	a.playbooks = NewPlaybooks()
+	a.trusted = NewTrustedProcesses()
+	a.usage.ruleContainers = make(map[string][]string)
+	a.usage.report = api.NewUsageReport()
+	a.errors.report = api.NewAgentErrorReport()
+	a.maintenance.ruleTags = make(map[string][]string)
 	a.memdumped = datastructs.NewSyncedSet()
 	a.dumping = datastructs.NewSyncedSet()
 	a.filedumped = datastructs.NewSyncedSet()
+	a.isolated = datastructs.NewSyncedSet()
+	a.suspended = datastructs.NewSyncedSet()
+	a.iocs = ioc.NewIocs()
+	a.dgaModel = dga.DefaultModel()
+	a.watchlists = NewWatchlists()
+	a.dllBlocklist = NewDllBlocklist()
 	// has to be empty to post structure the first time
 	a.systemInfo = &sysinfo.SystemInfo{}
-	a.logger = golog.FromStdout()
+	a.logger = a.options.logger
+	if a.logger == nil {
+		a.logger = golog.FromStdout()
+	}
 }
 
+// Prepare prepares agent to run with configuration c
 func (a *Agent) Prepare(c *config.Agent) (err error) {
 	// assigning configuration to agent
 	a.config = c
@@ -167,13 +327,28 @@ func (a *Agent) Prepare(c *config.Agent) (err error) {
 	// initializing action manager
 	a.actionHandler = NewActionHandler(a)
 
+	// initializing beaconing analyzer
+	a.beacons = beacon.NewAnalyzer(&c.BeaconingConfig)
+
+	// initializing lateral movement tracker
+	a.lateral = lateral.NewTracker(&c.LateralConfig)
+
+	// initializing Kerberos anomaly detector
+	a.initKerberosDetector()
+
+	// initializing false positive suppressions
+	a.suppressions = NewSuppressions(c.RulesConfig.MaxSuppressions, c.RulesConfig.SuppressMaxCrit)
+
+	// initializing event schema mappings
+	a.initSchema()
+
 	// Creates missing directories
 	if err = c.Prepare(); err != nil {
 		return
 	}
 
 	// Create logfile asap if needed
-	if c.Logfile != "" {
+	if c.Logfile != "" && a.options.logger == nil {
 		if a.logger, err = golog.FromPath(c.Logfile, 0600); err != nil {
 			return
 		}
@@ -189,16 +364,91 @@ func (a *Agent) Prepare(c *config.Agent) (err error) {
 		return
 	}
 
+	// registry values to dump
+	if a.regValues, err = NewRegValueMonitor(&c.RegistryConfig); err != nil {
+		return
+	}
+
+	// local database of filtered events
+	if c.EnableFiltering && c.EventDBConfig.Enable {
+		a.eventDB = NewEventDB(&c.EventDBConfig)
+	}
+
+	// blocking of processes created by high-confidence rules
+	if config.FeatureResponse.Compiled() && c.PrevConfig.Enable {
+		if a.preventer, err = NewPreventer(&c.PrevConfig); err != nil {
+			return
+		}
+	}
+
+	// last events processed to recover the ones missed across restarts
+	if c.BookmarkConfig.Enable {
+		a.bookmarks = NewBookmarks(&c.BookmarkConfig)
+	}
+
+	// IoC hits already alerted
+	if c.IoCMemConfig.Enable {
+		a.iocMemory = NewIoCMemory(&c.IoCMemConfig)
+	}
+
+	// hashes of files shared by hooks and commands
+	if c.HashCacheConfig.Enable {
+		a.hashCache = NewHashCache(&c.HashCacheConfig)
+	}
+
+	// domains recently resolved shared by IoC and container matching
+	if c.DNSCacheConfig.Enable {
+		a.dnsCache = NewDNSCache(&c.DNSCacheConfig)
+	}
+
+	// counts forwarded instead of filtered events
+	if c.FltStatsConfig.Enable {
+		a.filterStats = NewFilterStats(&c.FltStatsConfig)
+	}
+
+	// event sink used for debugging
+	if err = a.initSink(); err != nil {
+		return
+	}
+
 	// loading forwarder config
 	if a.forwarder, err = client.NewForwarder(a.ctx, &a.config.FwdConfig, a.logger); err != nil {
 		return
 	}
 
+	a.output = a.options.output
+	if a.output == nil {
+		a.output = a.forwarder
+	}
+
+	// restoring containment state of previous runs
+	if err := a.initContainment(); err != nil {
+		a.logger.Error(err)
+	}
+
+	// restoring processes isolated by previous runs
+	if config.FeatureResponse.Compiled() {
+		if err := a.initProcessIsolation(); err != nil {
+			a.logger.Error(err)
+		}
+	}
+
+	// restoring tags set by manager commands
+	if err := a.initTags(); err != nil {
+		a.logger.Error(err)
+	}
+
 	// cleaning up previous runs
 	a.cleanup()
 
+	// shipping DGA model as a container so that it can be customized
+	if err := a.shipDGAModel(); err != nil {
+		a.logger.Errorf("Failed to write DGA model container: %s", err)
+	}
+
 	// initialization
 	a.initEnvVariables()
+	a.detectTelemetry()
 	a.initEventProvider()
 	a.initHooks(c.EnableHooks)
 	// schedule tasks
@@ -206,9 +456,11 @@ func (a *Agent) Prepare(c *config.Agent) (err error) {
 	// fixing local audit policies if necessary
 	a.configureAuditPolicies()
 
-	// update and load engine
-	if err = a.update(true); err != nil {
-		return
+	// update and load engine, we do not want the agent to run
+	// blind or not at all if rules are broken so we fall back
+	// to safe mode
+	if err := a.update(true); err != nil {
+		a.enterSafeMode(err)
 	}
 
 	return
@@ -226,27 +478,102 @@ func (a *Agent) initDB() (err error) {
 		return
 	}
 
+	if err = a.db.Create(&ioc.IOC{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Gene containers received from manager
+	if err = a.db.Create(&api.EdrContainer{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// baseline of credential providers and LSA packages registrations
+	if err = a.db.Create(&CredentialRegistration{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// last host firewall and listening ports inventory collected
+	if err = a.db.Create(&InventoryItem{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// tags set with tag commands
+	if err = a.db.Create(&HostTag{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// last events processed per channel
+	if err = a.db.Create(&ChannelBookmark{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// IoC hits already alerted
+	if err = a.db.Create(&IoCHit{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// IoC entries received from manager
+	if err = a.iocs.FromDB(a.db); err != nil {
+		return
+	}
+
+	if a.iocMemory != nil {
+		if err = a.iocMemory.Load(a.db); err != nil {
+			return
+		}
+	}
+
+	// statistics persisted across restarts
+	if err = a.restoreStats(); err != nil {
+		return
+	}
+
 	return
 }
 
+func (a *Agent) initSchema() {
+	build, _ := strconv.Atoi(sysinfo.NewSystemInfo().OS.Build)
+	a.schema = event.NewSchemaRegistry(build)
+
+	for _, m := range a.config.SchemaConfig.Mappings {
+		if !a.schema.Add(m.Channel, m.EventIDs, m.MinBuild, m.MaxBuild, m.Aliases) {
+			a.logger.Debugf("Schema mapping for channel %s not applicable to OS build %d", m.Channel, build)
+		}
+	}
+}
+
 func (a *Agent) initEventProvider() {
+	// ETW configuration does not apply to custom providers
+	p, ok := a.eventProvider.(*etwProvider)
+	if !ok {
+		return
+	}
 
 	// parses the providers and init filters
 	for _, sprov := range a.config.EtwConfig.UnifiedProviders() {
 		if prov, err := etw.ParseProvider(sprov); err != nil {
 			a.logger.Errorf("Error while parsing provider %s: %s", sprov, err)
 		} else {
-			a.eventProvider.Filter.Update(&prov)
+			p.Filter.Update(&prov)
 		}
 	}
 
+	// providers subscribed to through etw: channels
+	if err := a.updateChannels(a.config.EtwConfig.Channels); err != nil {
+		a.logger.Errorf("Error while parsing channels: %s", err)
+	}
+
 	// open traces
-	a.eventProvider.FromTraceNames(a.config.EtwConfig.UnifiedTraces()...)
+	traces := a.config.EtwConfig.UnifiedTraces()
+	if a.fallbackEnabled(TelemetryProcessAudit) {
+		traces = utils.DedupStringSlice(append(traces, securityTraceName))
+	}
+	p.FromTraceNames(traces...)
 
 	// if we have file trace enabled
 	if a.config.EtwConfig.FileTraceEnabled() {
-		a.eventProvider.EventRecordCallback = a.eventRecordCallback
-		a.eventProvider.PreparedCallback = a.preparedCallback
+		p.EventRecordCallback = a.eventRecordCallback
+		p.PreparedCallback = a.preparedCallback
 	}
 
 }
@@ -258,12 +585,34 @@ func (a *Agent) initHooks(advanced bool) {
 	a.preHooks.Hook(hookProcTerm, fltProcTermination)
 	a.preHooks.Hook(hookStats, fltStats)
 	a.preHooks.Hook(hookTrack, fltTrack)
+	// process tracking falls back on Security audit events
+	if a.telemetryMissing(TelemetrySysmon) {
+		a.preHooks.Hook(hookTrackProcessAudit, fltProcessAudit)
+	}
+	// needed by IP IoC matching
+	a.preHooks.Hook(hookCanonicalIPs, fltNetworkConnect)
+	// needed by domain IoC matching
+	a.preHooks.Hook(hookDomainIoC, fltDNS)
+	// correlates network connections with DNS queries, must
+	// run after hookCanonicalIPs as addresses are canonical
+	if a.dnsCache != nil {
+		a.preHooks.Hook(hookDNSCache, fltDNS)
+		a.preHooks.Hook(hookDNSCache, fltDNSClient)
+		a.preHooks.Hook(hookResolvedDomain, fltNetworkConnect)
+	}
+
+	if a.hashCache != nil {
+		a.preHooks.Hook(hookHashCache, fltFileChange)
+	}
 
 	if advanced {
 		// Process terminator hook, terminating blacklisted (by action) processes
-		a.preHooks.Hook(hookTerminator, fltProcessCreate)
+		if config.FeatureResponse.Compiled() {
+			a.preHooks.Hook(hookTerminator, fltProcessCreate)
+		}
 		a.preHooks.Hook(hookImageLoad, fltImageLoad)
 		a.preHooks.Hook(hookSetImageSize, fltImageSize)
+		a.preHooks.Hook(hookSetValueSize, fltRegSetValue)
 		a.preHooks.Hook(hookProcessIntegrityProcTamp, fltImageTampering)
 		a.preHooks.Hook(hookEnrichServices, fltAnySysmon)
 		a.preHooks.Hook(hookClipboardEvents, fltClipboard)
@@ -271,22 +620,63 @@ func (a *Agent) initHooks(advanced bool) {
 		// Must be run the last as it depends on other filters
 		a.preHooks.Hook(hookEnrichAnySysmon, fltAnySysmon)
 		a.preHooks.Hook(hookKernelFiles, fltKernelFile)
+		a.preHooks.Hook(hookDGAScore, fltDNS)
+		a.preHooks.Hook(hookTLSFingerprint, fltAnyEvent)
+
+		// must run after enrichment hooks setting users
+		if a.config.WatchConfig.Enable {
+			a.preHooks.Hook(hookWatchlists, fltAnyEvent)
+		}
+
+		if a.config.DllConfig.Enable {
+			a.preHooks.Hook(hookDllBlocklist, fltImageLoad)
+		}
+
+		if a.config.LateralConfig.Enable {
+			a.preHooks.Hook(hookLateralMovement, fltLateral)
+		}
+
+		// only initialized on domain controllers
+		if a.kerberos != nil {
+			a.preHooks.Hook(hookKerberosAnomalies, fltKerberos)
+		}
+
+		if a.config.BeaconingConfig.Enable {
+			a.preHooks.Hook(hookBeaconingBytes, fltKernelNetworkSend)
+			a.preHooks.Hook(hookBeaconing, fltNetworkConnect)
+		}
 
 		// This hook must run before action handling as we want
 		// the gene score to be set before an eventual reporting
 		a.postHooks.Hook(hookUpdateGeneScore, fltAnyEvent)
 	}
+
+	// hooks on Sysmon events would never run
+	if a.telemetryMissing(TelemetrySysmon) {
+		for _, name := range a.preHooks.Unhook(sysmonChannel) {
+			a.logger.Infof("Disabled hook %s as Sysmon is not installed", name)
+		}
+	}
 }
 
 func (a *Agent) configureAuditPolicies() {
-	c := a.config.AuditConfig
+	// auditing is configured by the collector
+	if a.options.lowPrivilege {
+		return
+	}
+
+	configureAuditPolicies(&a.config.AuditConfig, a.logger)
+}
 
+// configureAuditPolicies enables audit policies and sets audit ACLs
+// to directories according to configuration c
+func configureAuditPolicies(c *config.Audit, l *golog.Logger) {
 	if c.Enable {
 		for _, ap := range c.AuditPolicies {
 			if err := utils.EnableAuditPolicy(ap); err != nil {
-				a.logger.Errorf("Failed to enable audit policy %s: %s", ap, err)
+				l.Errorf("Failed to enable audit policy %s: %s", ap, err)
 			} else {
-				a.logger.Infof("Enabled Audit Policy: %s", ap)
+				l.Infof("Enabled Audit Policy: %s", ap)
 			}
 		}
 	}
@@ -295,22 +685,23 @@ func (a *Agent) configureAuditPolicies() {
 	go func() {
 		dirs := utils.StdDirs(utils.ExpandEnvs(c.AuditDirs...)...)
 		if len(dirs) > 0 {
-			a.logger.Infof("Setting ACLs for directories: %s", strings.Join(dirs, ", "))
+			l.Infof("Setting ACLs for directories: %s", strings.Join(dirs, ", "))
 			if err := utils.SetEDRAuditACL(dirs...); err != nil {
-				a.logger.Errorf("Error while setting configured File System Audit ACLs: %s", err)
+				l.Errorf("Error while setting configured File System Audit ACLs: %s", err)
 			}
-			a.logger.Infof("Finished setting up ACLs for directories: %s", strings.Join(dirs, ", "))
+			l.Infof("Finished setting up ACLs for directories: %s", strings.Join(dirs, ", "))
 		}
 	}()
 }
 
 func (a *Agent) update(force bool) (last error) {
-	var reloadRules, reloadContainers bool
+	var reloadRules, reloadContainers, reloadManagedContainers bool
 
 	// check that we are connected to any manager
 	if a.config.IsForwardingEnabled() {
 		reloadRules = a.needsRulesUpdate()
 		reloadContainers = a.needsIoCsUpdate()
+		reloadManagedContainers = a.needsContainersUpdate()
 	}
 
 	// check if we need rule update
@@ -318,6 +709,7 @@ func (a *Agent) update(force bool) (last error) {
 		a.logger.Info("Updating WHIDS rules")
 		if err := a.fetchRulesFromManager(); err != nil {
 			a.logger.Errorf("Failed to fetch rules from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "rules", err)
 			reloadRules = false
 		}
 	}
@@ -326,14 +718,51 @@ func (a *Agent) update(force bool) (last error) {
 		a.logger.Info("Updating WHIDS containers")
 		if err := a.fetchIoCsFromManager(); err != nil {
 			a.logger.Errorf("Failed to fetch containers from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "iocs", err)
 			reloadContainers = false
 		}
 	}
 
+	if reloadManagedContainers {
+		a.logger.Info("Updating containers managed by manager")
+		if err := a.fetchContainersFromManager(); err != nil {
+			a.logger.Errorf("Failed to fetch managed containers from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "containers", err)
+		} else {
+			reloadContainers = true
+		}
+	}
+
+	if a.needsSuppressionsUpdate() {
+		a.logger.Info("Updating false positive suppressions")
+		if err := a.fetchSuppressionsFromManager(); err != nil {
+			a.logger.Errorf("Failed to fetch suppressions from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "suppressions", err)
+		}
+	}
+
+	if a.needsPlaybooksUpdate() {
+		a.logger.Info("Updating response playbooks")
+		if err := a.fetchPlaybooksFromManager(); err != nil {
+			a.logger.Errorf("Failed to fetch playbooks from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "playbooks", err)
+		}
+	}
+
+	if a.needsTrustedUpdate() {
+		a.logger.Info("Updating trusted processes")
+		if err := a.fetchTrustedFromManager(); err != nil {
+			a.logger.Errorf("Failed to fetch trusted processes from manager: %s", err)
+			a.reportError(api.AgentErrorUpdate, "trusted", err)
+		}
+	}
+
 	a.logger.Debugf("reloading rules:%t containers:%t forced:%t", reloadRules, reloadContainers, force)
 	if reloadRules || reloadContainers || force {
 		// We need to create a new engine if we received a rule/containers update
 		newEngine := newActionnableEngine(a.config)
+		// raw rules are needed to know containers used by rules
+		newEngine.SetDumpRaw(true)
 
 		// containers must be loaded before the rules anyway
 		a.logger.Infof("Loading HIDS containers (used in rules) from: %s", a.config.RulesConfig.ContainersDB)
@@ -342,16 +771,73 @@ func (a *Agent) update(force bool) (last error) {
 			last = err
 		}
 
+		// DGA model may have been updated with containers
+		if err := a.loadDGAModel(); err != nil {
+			a.logger.Errorf("Failed to load DGA model, using default: %s", err)
+		}
+
+		// user watchlists may have been updated with containers
+		if a.config.WatchConfig.Enable {
+			if err := a.loadWatchlists(); err != nil {
+				a.reportError(api.AgentErrorRule, "watchlists", err)
+			}
+		}
+
+		// DLL blocklists may have been updated with containers
+		if a.config.DllConfig.Enable {
+			if err := a.loadDllBlocklists(); err != nil {
+				a.reportError(api.AgentErrorRule, "dll-blocklist", err)
+			}
+		}
+
 		// Loading IOC container rules
 		for _, rule := range IoCRules {
 			if err := newEngine.LoadRule(&rule); err != nil {
 				a.logger.Errorf("Failed to load IoC rule: %s", err)
+				a.reportError(api.AgentErrorRule, rule.Name, err)
+				last = err
+			}
+		}
+
+		// Loading self-test rules
+		for _, rule := range SelfTestRules {
+			if err := newEngine.LoadRule(&rule); err != nil {
+				a.logger.Errorf("Failed to load self-test rule: %s", err)
+				a.reportError(api.AgentErrorRule, rule.Name, err)
+				last = err
+			}
+		}
+
+		// Loading beaconing rule
+		if a.config.BeaconingConfig.Enable {
+			br := a.config.BeaconingConfig.GenRule()
+			if err := newEngine.LoadRule(&br); err != nil {
+				a.logger.Errorf("Failed to load beaconing rule: %s", err)
+				last = err
+			}
+		}
+
+		// Loading Kerberos anomaly rules
+		if a.kerberos != nil {
+			for _, kr := range a.config.KerberosConfig.GenRules() {
+				if err := newEngine.LoadRule(&kr); err != nil {
+					a.logger.Errorf("Failed to load Kerberos anomaly rule: %s", err)
+					last = err
+				}
+			}
+		}
+
+		// Loading log tampering rule
+		if a.config.LogTamperConfig.Enable {
+			lr := a.config.LogTamperConfig.GenRule()
+			if err := newEngine.LoadRule(&lr); err != nil {
+				a.logger.Errorf("Failed to load log tampering rule: %s", err)
 				last = err
 			}
 		}
 
 		// Loading canary rules
-		if a.config.CanariesConfig.Enable {
+		if config.FeatureCanaries.Compiled() && a.config.CanariesConfig.Enable {
 			a.logger.Infof("Loading canary rules")
 			// Sysmon rule
 			sr := a.config.CanariesConfig.GenRuleSysmon()
@@ -379,7 +865,11 @@ func (a *Agent) update(force bool) (last error) {
 		a.logger.Infof("Loading HIDS rules from: %s", a.config.RulesConfig.RulesDB)
 		if err := newEngine.LoadDirectory(a.config.RulesConfig.RulesDB); err != nil {
 			last = fmt.Errorf("failed to load rules: %s", err)
+			a.reportError(api.AgentErrorRule, "rules", err)
 		}
+		// Sigma rules are converted at every engine load
+		a.loadSigmaRules(newEngine)
+
 		a.logger.Infof("Number of rules loaded in engine: %d", newEngine.Count())
 
 		// updating engine if no error
@@ -387,6 +877,17 @@ func (a *Agent) update(force bool) (last error) {
 			// we update engine only if there was no error
 			// no need to lock HIDS as newEngine is ready to use at this point
 			a.Engine = newEngine
+			a.updateEventMetadata()
+			a.setUsageRules(newEngine)
+			a.setMaintenanceRules(newEngine)
+			a.playbooks.SetRuleTags(newEngine)
+			if a.preventer != nil {
+				a.preventer.SetRules(newEngine)
+			}
+			if a.filterStats != nil {
+				a.filterStats.SetRules(newEngine)
+			}
+			a.leaveSafeMode()
 		} else {
 			a.logger.Error("EDR engine not updated:", last)
 		}
@@ -447,6 +948,122 @@ func (a *Agent) needsIoCsUpdate() bool {
 	return localSha256 != remoteSha256
 }
 
+// returns true if containers managed by manager need to be updated
+func (a *Agent) needsContainersUpdate() bool {
+	var remoteSha256 string
+	var objs []sod.Object
+	var err error
+
+	// Don't need update if not connected to a manager
+	if !a.config.IsForwardingEnabled() {
+		return false
+	}
+
+	if remoteSha256, err = a.forwarder.Client.GetContainersSha256(); err != nil {
+		a.logger.Errorf("Failed to fetch containers sha256: %s", err)
+		return false
+	}
+
+	if objs, err = a.db.All(&api.EdrContainer{}); err != nil {
+		a.logger.Errorf("Failed to retrieve local containers: %s", err)
+		return false
+	}
+
+	local := make([]*api.EdrContainer, 0, len(objs))
+	for _, o := range objs {
+		local = append(local, o.(*api.EdrContainer))
+	}
+
+	return api.ContainersSha256(local) != remoteSha256
+}
+
+// returns true if suppressions need to be updated
+func (a *Agent) needsSuppressionsUpdate() bool {
+	var remoteSha256 string
+	var err error
+
+	// Don't need update if not connected to a manager or suppression is disabled
+	if !a.config.IsForwardingEnabled() || a.config.RulesConfig.MaxSuppressions <= 0 {
+		return false
+	}
+
+	if remoteSha256, err = a.forwarder.Client.GetSuppressionsSha256(); err != nil {
+		a.logger.Errorf("Failed to fetch suppressions sha256: %s", err)
+		return false
+	}
+
+	return a.suppressions.Sha256() != remoteSha256
+}
+
+func (a *Agent) fetchSuppressionsFromManager() (err error) {
+	var suppressions []*api.Suppression
+	var sha256 string
+	cl := a.forwarder.Client
+
+	if suppressions, err = cl.GetSuppressions(); err != nil {
+		return
+	}
+
+	if sha256, err = cl.GetSuppressionsSha256(); err != nil {
+		return
+	}
+
+	keys := make([]string, 0, len(suppressions))
+	for _, s := range suppressions {
+		keys = append(keys, s.Key())
+	}
+
+	if utils.Sha256StringSlice(keys) != sha256 {
+		return fmt.Errorf("failed to verify suppressions integrity")
+	}
+
+	a.suppressions.Update(suppressions, sha256)
+	a.logger.Infof("Number of false positive suppressions applied: %d", a.suppressions.Len())
+
+	return
+}
+
+// returns true if playbooks need to be updated
+func (a *Agent) needsPlaybooksUpdate() bool {
+	var remoteSha256 string
+	var err error
+
+	// Don't need update if not connected to a manager
+	if !a.config.IsForwardingEnabled() {
+		return false
+	}
+
+	if remoteSha256, err = a.forwarder.Client.GetPlaybooksSha256(); err != nil {
+		a.logger.Errorf("Failed to fetch playbooks sha256: %s", err)
+		return false
+	}
+
+	return a.playbooks.Sha256() != remoteSha256
+}
+
+func (a *Agent) fetchPlaybooksFromManager() (err error) {
+	var playbooks []*api.Playbook
+	var sha256 string
+	cl := a.forwarder.Client
+
+	if playbooks, err = cl.GetPlaybooks(); err != nil {
+		return
+	}
+
+	if sha256, err = cl.GetPlaybooksSha256(); err != nil {
+		return
+	}
+
+	if api.PlaybooksSha256(playbooks) != sha256 {
+		return fmt.Errorf("failed to verify playbooks integrity")
+	}
+
+	a.playbooks.Update(playbooks, sha256)
+	a.logger.Infof("Number of response playbooks: %d", a.playbooks.Len())
+
+	return
+}
+
 func (a *Agent) fetchRulesFromManager() (err error) {
 	var rules, sha256 string
 
@@ -482,7 +1099,7 @@ func (a *Agent) containerPaths(container string) (path, sha256Path string) {
 }
 
 func (a *Agent) fetchIoCsFromManager() (err error) {
-	var iocs []string
+	var entries []*ioc.IOC
 	cl := a.forwarder.Client
 
 	// if we are not connected to a manager we return
@@ -490,10 +1107,15 @@ func (a *Agent) fetchIoCsFromManager() (err error) {
 		return
 	}
 
-	if iocs, err = cl.GetIoCs(); err != nil {
+	if entries, err = cl.GetIoCEntries(); err != nil {
 		return
 	}
 
+	iocs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		iocs = append(iocs, e.Value)
+	}
+
 	// we compare the integrity of the container received
 	compSha256 := utils.Sha256StringSlice(iocs)
 
@@ -503,8 +1125,89 @@ func (a *Agent) fetchIoCsFromManager() (err error) {
 		return fmt.Errorf("failed to verify container \"%s\" integrity", server.IoCContainerName)
 	}
 
+	// we keep IoC entries for provenance and expiry
+	if err = a.db.DeleteAll(&ioc.IOC{}); err != nil {
+		return
+	}
+
+	if _, err = a.db.InsertOrUpdateMany(sod.ToObjectSlice(entries)...); err != nil {
+		return
+	}
+
+	// expired entries are dropped at this stage
+	a.iocs.Replace(entries...)
+
+	return a.writeContainer(server.IoCContainerName, a.iocs.StringSlice())
+}
+
+func (a *Agent) fetchContainersFromManager() (err error) {
+	var containers []*api.EdrContainer
+	var objs []sod.Object
+	var sha256 string
+	cl := a.forwarder.Client
+
+	// if we are not connected to a manager we return
+	if a.config.FwdConfig.Local {
+		return
+	}
+
+	if containers, err = cl.GetContainers(); err != nil {
+		return
+	}
+
+	if sha256, err = cl.GetContainersSha256(); err != nil {
+		return fmt.Errorf("failed to get containers sha256: %s", err)
+	}
+
+	// we verify integrity of every container and of the whole set
+	for _, c := range containers {
+		if c.Sha256 != utils.Sha256StringSlice(c.Entries) {
+			return fmt.Errorf("failed to verify container \"%s\" integrity", c.Name)
+		}
+	}
+
+	if api.ContainersSha256(containers) != sha256 {
+		return fmt.Errorf("failed to verify containers integrity")
+	}
+
+	// removing containers deleted on manager
+	if objs, err = a.db.All(&api.EdrContainer{}); err != nil {
+		return
+	}
+
+	remote := make(map[string]bool)
+	for _, c := range containers {
+		remote[c.Name] = true
+	}
+
+	for _, o := range objs {
+		if c := o.(*api.EdrContainer); !remote[c.Name] {
+			path, sha256Path := a.containerPaths(c.Name)
+			os.Remove(path)
+			os.Remove(sha256Path)
+		}
+	}
+
+	for _, c := range containers {
+		if err = a.writeContainer(c.Name, c.Entries); err != nil {
+			return
+		}
+	}
+
+	if err = a.db.DeleteAll(&api.EdrContainer{}); err != nil {
+		return
+	}
+
+	_, err = a.db.InsertOrUpdateMany(sod.ToObjectSlice(containers)...)
+	return
+}
+
+// writeContainer dumps values into a container which can be used in rules
+func (a *Agent) writeContainer(container string, values []string) (err error) {
+	compSha256 := utils.Sha256StringSlice(values)
+
 	// we dump the container
-	contPath, contSha256Path := a.containerPaths(server.IoCContainerName)
+	contPath, contSha256Path := a.containerPaths(container)
 	fd, err := utils.HidsCreateFile(contPath)
 	if err != nil {
 		return err
@@ -515,8 +1218,8 @@ func (a *Agent) fetchIoCsFromManager() (err error) {
 	w := gzip.NewWriter(fd)
 	// closing gzip writer
 	defer w.Close()
-	for _, ioc := range iocs {
-		if _, err = w.Write([]byte(fmt.Sprintln(ioc))); err != nil {
+	for _, v := range values {
+		if _, err = w.Write([]byte(fmt.Sprintln(v))); err != nil {
 			return
 		}
 	}
@@ -557,6 +1260,7 @@ func (a *Agent) loadContainers(engine *engine.Engine) (lastErr error) {
 				if err = engine.LoadContainer(cont, r); err != nil {
 					lastErr = fmt.Errorf("failed to load container %s: %s", cont, err)
 					a.logger.Error(lastErr)
+					a.reportError(api.AgentErrorRule, cont, err)
 				}
 				r.Close()
 				fd.Close()
@@ -570,6 +1274,11 @@ func (a *Agent) updateSystemInfo() (err error) {
 	var hnew, hold string
 
 	new := sysinfo.NewSystemInfo()
+	new.Telemetry = a.telemetry
+	a.inventory.Lock()
+	new.Firewall = a.inventory.firewall
+	new.ListeningPorts = a.inventory.ports
+	a.inventory.Unlock()
 	if hnew, err = utils.Sha256Interface(new); err != nil {
 		// we return cause we don't want to overwrite with
 		// a faulty structure
@@ -692,6 +1401,7 @@ func (a *Agent) updateSysmonConfig() (err error) {
 	if err = sysmon.Configure(bytes.NewBuffer(xml)); err != nil {
 		return fmt.Errorf("failed to configure sysmon: %w", err)
 	}
+	a.updateEventMetadata()
 
 	if err = a.updateSystemInfo(); err != nil {
 		err = fmt.Errorf("failed to update system info: %w", err)
@@ -735,6 +1445,16 @@ func (a *Agent) updateAgentConfig() (err error) {
 	// overwrite current configuration
 	newConf.Save(a.config.Path())
 
+	// no need to restart if only subscribed channels changed
+	if a.config.OnlyChannelsDiffer(newConf) {
+		a.logger.Infof("updating subscribed channels without restarting agent")
+		if err = a.updateChannels(newConf.EtwConfig.Channels); err != nil {
+			return fmt.Errorf("failed to update subscribed channels: %w", err)
+		}
+		a.config.EtwConfig.Channels = newConf.EtwConfig.Channels
+		return
+	}
+
 	a.logger.Infof("stopping agent after update")
 	a.Stop()
 	a.Wait()
@@ -801,7 +1521,10 @@ func (a *Agent) IsHIDSEvent(e *event.EdrEvent) bool {
 // Report generate a forensic ready report (meant to be dumped)
 // this method is blocking as it runs commands and wait after those
 func (a *Agent) Report(light bool) (r Report) {
-	r.StartTime = time.Now()
+	// start keeps monotonic clock reading so that report
+	// duration is not affected by wall clock changes
+	start := time.Now()
+	r.StartTime = start.UTC()
 
 	// generate a report for running processes or those terminated still having one child or more
 	// do this step first not to polute report with commands to run
@@ -822,30 +1545,44 @@ func (a *Agent) Report(light bool) (r Report) {
 		}
 	}
 
-	r.StopTime = time.Now()
+	r.StopTime = r.StartTime.Add(time.Since(start))
 	return
 }
 
-func (a *Agent) eventScanRoutine() {
+func (a *Agent) eventScanRoutine(events chan *etw.Event) {
 	var kernelTracked bool
 	var rtlost uint
+	var p *pipeline
 
 	// Trying to raise thread priority
 	if err := kernel32.SetCurrentThreadPriority(win32.THREAD_PRIORITY_ABOVE_NORMAL); err != nil {
 		a.logger.Errorf("Failed to raise IDS thread priority: %s", err)
 	}
 
-	for e := range a.eventProvider.Events {
+	// events are processed concurrently only if configured to do so
+	if workers := a.config.PipelineConfig.Workers; workers > 1 {
+		a.logger.Infof("Processing events with %d workers (ordered by process: %t)", workers, a.config.PipelineConfig.OrderByProcess)
+		p = newPipeline(workers, a.config.PipelineConfig.OrderByProcess, a.processEvent)
+	}
+
+	for e := range events {
 		event := event.NewEdrEvent(e)
+		// all timestamps are handled in UTC
+		event.NormalizeTime()
+
+		if a.bookmarks != nil {
+			a.bookmarks.Update(event)
+		}
 
-		if uint64(a.stats.counter.event)%1000 == 0 && a.eventProvider.LostEvents > 0 {
-			a.logger.Warnf("Received %d RTLostEvent events, if the agent went off for a while this is normal. If you see this message at every boot or more often it is a symptom of a bad ETW configuration (more events are received than the agent can process).", a.eventProvider.LostEvents)
-			if rtlost > 5 {
-				a.logger.Criticalf("Several events lost, something is wrong with ETW configuration")
+		if uint64(a.stats.Events())%1000 == 0 {
+			// counter of lost events is reset not to trigger this all the time
+			if lost := a.eventProvider.LostEvents(); lost > 0 {
+				a.logger.Warnf("Received %d RTLostEvent events, if the agent went off for a while this is normal. If you see this message at every boot or more often it is a symptom of a bad ETW configuration (more events are received than the agent can process).", lost)
+				if rtlost > 5 {
+					a.logger.Criticalf("Several events lost, something is wrong with ETW configuration")
+				}
+				rtlost++
 			}
-			// we reset the counter of lost events not to trigger this all the time
-			a.eventProvider.LostEvents = 0
-			rtlost++
 		}
 
 		if yes, eps := a.stats.HasPerfIssue(); yes {
@@ -866,79 +1603,203 @@ func (a *Agent) eventScanRoutine() {
 		}
 
 		// Warning message in certain circumstances
-		if a.config.EnableHooks && !a.flagProcTermEn && a.stats.Events() > 0 && int64(a.stats.Events())%1000 == 0 {
+		if a.config.EnableHooks && !a.flagProcTermEn && !a.telemetryMissing(TelemetrySysmon) && a.stats.Events() > 0 && int64(a.stats.Events())%1000 == 0 {
 			a.logger.Warn("Sysmon process termination events seem to be missing. WHIDS won't work as expected.")
 		}
 
-		a.RLock()
+		if p != nil {
+			p.Dispatch(event)
+		} else {
+			a.processEvent(event)
+		}
+	}
 
-		// Runs pre detection hooks
-		// putting this before next condition makes the processTracker registering
-		// HIDS events and allows detecting ProcessAccess events from HIDS childs
-		a.preHooks.RunHooksOn(a, event)
+	// waiting for all events to be processed
+	if p != nil {
+		p.Close()
+	}
 
-		// We skip if it is one of IDS event
-		// we keep process termination event because it is used to control if process termination is enabled
-		if a.IsHIDSEvent(event) && !isSysmonProcessTerminate(event) {
-			if a.PrintAll {
-				fmt.Println(utils.JsonStringOrPanic(event))
-			}
-			goto CONTINUE
+	a.logger.Infof("HIDS main loop terminated")
+}
+
+// pipeEvent pipes an event to the forwarder
+func (a *Agent) pipeEvent(e *event.EdrEvent) {
+	defer a.perf.forwarder.since(time.Now())
+
+	if err := a.forward(e); err != nil {
+		a.logger.Errorf("failed to pipe event: %s", err)
+	}
+}
+
+// processEvent runs hooks and detection engine on an event, then pipes
+// it to the forwarder if needed
+func (a *Agent) processEvent(event *event.EdrEvent) {
+	var forwarded bool
+
+	a.RLock()
+	defer a.RUnlock()
+
+	// Maps event fields to their canonical names so that
+	// hooks and rules work across OS versions
+	a.schema.Apply(event)
+
+	// raw events are tapped before any processing
+	a.tapEvent(event)
+
+	// Runs pre detection hooks
+	// putting this before next condition makes the processTracker registering
+	// HIDS events and allows detecting ProcessAccess events from HIDS childs
+	start := time.Now()
+	a.preHooks.RunHooksOn(a, event)
+	a.perf.hooks.since(start)
+
+	// We skip if it is one of IDS event
+	// we keep process termination event because it is used to control if process termination is enabled
+	// self-test events are generated by HIDS child processes
+	if a.IsHIDSEvent(event) && !isSysmonProcessTerminate(event) && !a.selfTestExpects(event) {
+		a.sinkEvent(event)
+		return
+	}
+
+	// if event is skipped we don't log it even in sink
+	if event.IsSkipped() {
+		a.stats.Update(event)
+		return
+	}
+
+	// if the event has matched at least one signature or is filtered
+	start = time.Now()
+	n, crit, filtered := a.Engine.MatchOrFilter(event)
+	a.perf.engine.since(start)
+
+	if len(n) > 0 || filtered {
+		if len(n) > 0 {
+			a.usageHit(n)
 		}
 
-		// if event is skipped we don't log it even with PrintAll
-		if event.IsSkipped() {
-			a.stats.Update(event)
-			goto CONTINUE
+		// in safe mode filtered events are the only visibility we have
+		forwardFiltered := a.config.EnableFiltering || a.safeMode
+
+		// alert criticality depends on the asset the alert is raised on
+		// and on the accounts involved
+		if d := event.GetDetection(); len(n) > 0 && d != nil {
+			d.Criticality = a.config.CritConfig.Recalibrate(d.Criticality)
+			d.Criticality = a.config.WatchConfig.Recalibrate(d.Criticality, eventWatchlists(event)...)
+			crit = d.Criticality
 		}
 
-		// if the event has matched at least one signature or is filtered
-		if n, crit, filtered := a.Engine.MatchOrFilter(event); len(n) > 0 || filtered {
-			switch {
-			case crit >= a.config.CritTresh:
-				if !a.PrintAll && !a.config.LogAll {
-					if err := a.forwarder.PipeEvent(event); err != nil {
-						a.logger.Errorf("failed to pipe event: %s", err)
-					}
-				}
-				// Pipe the event to be sent to the forwarder
-				// Run hooks post detection
-				a.postHooks.RunHooksOn(a, event)
-				a.stats.Update(event)
-			case filtered && a.config.EnableFiltering && !a.PrintAll && !a.config.LogAll:
-				//event.Del(&engine.GeneInfoPath)
-				// we pipe filtered event
-				if err := a.forwarder.PipeEvent(event); err != nil {
-					a.logger.Errorf("failed to pipe event: %s", err)
-				}
+		// log tampering alerts are always reported
+		tamper := a.logTampered(n)
+
+		// alerts raised during maintenance windows, suppressed
+		// alerts are still accounted in statistics
+		if d := event.GetDetection(); len(n) > 0 && d != nil && !tamper {
+			var suppressed bool
+			if d.Criticality, suppressed = a.maintenanceRecalibrate(event, n, d.Criticality); suppressed {
+				event.Event.Detection = nil
+				a.stats.Suppress()
+				crit = 0
+			} else {
+				crit = d.Criticality
 			}
 		}
 
-		// we queue event in action handler
-		a.actionHandler.Queue(event)
+		if d := event.GetDetection(); tamper && d != nil && d.Criticality < a.config.CritTresh {
+			d.Criticality = a.config.CritTresh
+			crit = d.Criticality
+		}
 
-		// Print everything
-		if a.PrintAll {
-			fmt.Println(utils.JsonStringOrPanic(event))
+		// process access heuristics do not apply to trusted security tools
+		if crit >= a.config.CritTresh && isProcessAccess(event) && a.trustedSource(event) {
+			event.Event.Detection = nil
+			crit = 0
 		}
 
-		// We log all events
-		if a.config.LogAll {
-			if err := a.forwarder.PipeEvent(event); err != nil {
-				a.logger.Errorf("failed to pipe event: %s", err)
+		// known false positives are not considered as alerts
+		if crit >= a.config.CritTresh && !tamper && a.suppressions.Suppress(event) {
+			event.Event.Detection = nil
+			crit = 0
+		}
+
+		// objects already alerted on for an IoC are not alerted again
+		if crit >= a.config.CritTresh && a.iocAlerted(event) {
+			event.Event.Detection = nil
+			crit = 0
+		}
+
+		// processes created by rules tagged for prevention are
+		// blocked before the alert goes any further
+		if crit >= a.config.CritTresh {
+			a.prevent(event, n)
+		}
+
+		switch {
+		case crit >= a.config.CritTresh:
+			// we need to enrich the event before it gets piped
+			a.setIoCProvenance(event)
+			// self-test alerts are sent to the manager by the self-test
+			if a.selfTestObserve(event) {
+				forwarded = true
+			} else if !a.config.LogAll {
+				a.pipeEvent(event)
+				forwarded = true
+				// no need to wait for more events to send it
+				if tamper {
+					a.flushEvents()
+				}
+			}
+			// Pipe the event to be sent to the forwarder
+			// Run hooks post detection
+			start = time.Now()
+			a.postHooks.RunHooksOn(a, event)
+			a.perf.hooks.since(start)
+		case filtered && forwardFiltered && !a.config.LogAll:
+			//event.Del(&engine.GeneInfoPath)
+			// filtered events are counted instead of being forwarded,
+			// except in safe mode where they are our only visibility
+			if a.filterStats != nil && !a.safeMode {
+				a.filterStats.Add(event, n)
+			} else {
+				// we pipe filtered event
+				a.pipeEvent(event)
+				forwarded = true
+			}
+			// filtered events are kept locally to be queried
+			if a.eventDB != nil {
+				if err := a.eventDB.Add(event); err != nil {
+					a.logger.Errorf("Failed to add event to event database: %s", err)
+				}
 			}
 		}
+	}
 
-		a.stats.Update(event)
+	// events involving some watched accounts are always forwarded
+	if !forwarded && !a.config.LogAll && a.config.WatchConfig.Forward(eventWatchlists(event)...) {
+		a.pipeEvent(event)
+	}
 
-	CONTINUE:
-		a.RUnlock()
+	// we queue event in action handler
+	a.actionHandler.Queue(event)
+
+	// Write event to sink
+	a.sinkEvent(event)
+
+	// We log all events
+	if a.config.LogAll {
+		a.pipeEvent(event)
 	}
 
-	a.logger.Infof("HIDS main loop terminated")
+	a.stats.Update(event)
 }
 
-// Run starts the WHIDS engine and waits channel listening is stopped
+// replaying returns true if events are read from canned events
+// or saved logs instead of ETW
+func (a *Agent) replaying() bool {
+	return a.Replay != "" || len(a.ReplayEvtx) > 0
+}
+
+// Run starts the agent and returns once events are being processed,
+// Wait must be called to wait for event processing to terminate
 func (a *Agent) Run() (err error) {
 
 	// start task scheduler
@@ -953,12 +1814,54 @@ func (a *Agent) Run() (err error) {
 		for _, trace := range a.config.EtwConfig.UnifiedTraces() {
 			a.logger.Infof("Dry run: would open trace %s", trace)
 		}
+		if providers, err := a.config.EtwConfig.RealTimeProviders(); err == nil {
+			for _, prov := range providers {
+				a.logger.Infof("Dry run: would subscribe to provider %s", prov)
+			}
+		}
 		return
 	}
 
-	// Starting event provider
-	if err = a.eventProvider.Start(); err != nil {
-		return
+	events := a.eventProvider.Events()
+
+	switch {
+	case len(a.ReplayEvtx) > 0:
+		if events, err = a.openEvtxReplay(); err != nil {
+			return
+		}
+	case a.Replay != "":
+		a.logger.Infof("Replaying events from %s", a.Replay)
+		if events, err = a.openReplay(); err != nil {
+			return
+		}
+	default:
+		var gaps map[string]time.Time
+
+		// gaps must be computed before events update bookmarks
+		start := time.Now()
+		if a.bookmarks != nil {
+			if err := a.bookmarks.Load(a.db); err != nil {
+				a.logger.Errorf("Failed to load bookmarks: %s", err)
+			}
+			gaps = a.bookmarks.Gaps(start)
+		}
+
+		// Starting event provider
+		if err = a.eventProvider.Start(); err != nil {
+			return
+		}
+
+		// events generated while agent was not running
+		if len(gaps) > 0 {
+			events = mergeEvents(events, a.recoverEvents(gaps, start))
+		}
+	}
+
+	// events injected by other endpoint software
+	if a.config.SourcesConfig.Enable {
+		if events, err = a.startCustomSources(events); err != nil {
+			return
+		}
 	}
 
 	// start stats monitoring
@@ -967,7 +1870,7 @@ func (a *Agent) Run() (err error) {
 	a.waitGroup.Add(1)
 	go func() {
 		defer a.waitGroup.Done()
-		a.eventScanRoutine()
+		a.eventScanRoutine(events)
 	}()
 
 	// Run bogus command so that at least one Process Terminate
@@ -982,28 +1885,71 @@ func (a *Agent) LogStats() {
 	a.logger.Infof("Count Event Scanned: %.0f", a.stats.Events())
 	a.logger.Infof("Average Event Rate: %.2f EPS", a.stats.EPS())
 	a.logger.Infof("Alerts Reported: %.0f", a.stats.Detections())
+	a.logger.Infof("Alerts Suppressed (maintenance windows): %.0f", a.stats.Suppressed())
 	a.logger.Infof("Count Rules Used (loaded + generated): %d", a.Engine.Count())
+
+	s := a.stats.Snapshot()
+	a.logger.Infof("Cumulative Event Scanned (since %s, %d boots): %d", s.Since.Format(time.RFC3339), s.Boots, s.Cumulative.Events)
+	a.logger.Infof("Cumulative Alerts Reported: %d", s.Cumulative.Detections)
 }
 
-// Stop stops the IDS
+// Stop stops the agent, it can be called only once
 func (a *Agent) Stop() {
 	a.logger.Infof("Stopping HIDS")
 	// cancelling parent context
 	a.cancel()
+	// last counts of filtered events
+	if a.filterStats != nil {
+		a.forwardFilterStats()
+	}
 	// gently close forwarder needs to be done before
 	// stop listening othewise we corrupt local logfiles
 	// because of race condition
 	a.logger.Infof("Closing forwarder")
 	a.forwarder.Close()
+	if a.output != nil && a.output != a.forwarder {
+		a.output.Close()
+	}
+
+	// closing event provider, it is not started when replaying events
+	if !a.replaying() {
+		a.logger.Infof("Closing event provider")
+		if err := a.eventProvider.Stop(); err != nil {
+			a.logger.Errorf("Error while closing event provider: %s", err)
+		}
+	}
+
+	a.closeSink()
+
+	if a.bookmarks != nil {
+		a.logger.Infof("Saving bookmarks")
+		if err := a.bookmarks.Save(a.db); err != nil {
+			a.logger.Errorf("Failed to save bookmarks: %s", err)
+		}
+	}
+
+	if a.iocMemory != nil {
+		if err := a.iocMemory.Save(a.db); err != nil {
+			a.logger.Errorf("Failed to save IoC hits: %s", err)
+		}
+	}
+
+	// writing buffered filtered events
+	if a.eventDB != nil {
+		a.logger.Infof("Closing event database")
+		if err := a.eventDB.Close(); err != nil {
+			a.logger.Errorf("Failed to close event database: %s", err)
+		}
+	}
 
-	// closing event provider
-	a.logger.Infof("Closing event provider")
-	if err := a.eventProvider.Stop(); err != nil {
-		a.logger.Errorf("Error while closing event provider: %s", err)
+	// persisting statistics
+	a.logger.Infof("Saving statistics")
+	if err := a.saveStats(); err != nil {
+		a.logger.Errorf("Failed to save statistics: %s", err)
 	}
 
 	// cleaning canary files
-	if a.config.CanariesConfig.Enable {
+	if config.FeatureCanaries.Compiled() && a.config.CanariesConfig.Enable {
 		a.logger.Infof("Cleaning canaries")
 		a.config.CanariesConfig.Clean()
 	}
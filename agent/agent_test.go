@@ -3,23 +3,36 @@ package agent
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf16"
 
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"github.com/0xrawsec/golang-win32/win32/wevtapi"
 	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/sod"
 	"github.com/0xrawsec/toast"
 	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/agent/sysinfo"
 	"github.com/0xrawsec/whids/api"
 	cconfig "github.com/0xrawsec/whids/api/client/config"
 	"github.com/0xrawsec/whids/api/server"
@@ -500,3 +513,1782 @@ func TestAgent(t *testing.T) {
 	a.logger = golog.Stdout
 	a.LogStats()
 }
+
+func TestAgentReplay(t *testing.T) {
+	var processed int
+
+	tt := toast.FromT(t)
+	tt.FailNow = false
+
+	defer cleanup()
+
+	manager, clConf := prepareManager()
+	manager.Logger.ErrorHandler = tt.CheckErr
+	defer manager.Shutdown()
+
+	tmp, err := utils.HidsMkTmpDir()
+	tt.CheckErr(err)
+	defer os.RemoveAll(tmp)
+
+	// writing canned events
+	count := 100
+	replay := filepath.Join(tmp, "events.json")
+	fd, err := os.Create(replay)
+	tt.CheckErr(err)
+	for i := 0; i < count; i++ {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.EventID = SysmonProcessCreate
+		e.System.TimeCreated.SystemTime = time.Now()
+		e.EventData["ProcessGuid"] = fmt.Sprintf("{%s}", utils.UUIDOrPanic())
+		e.EventData["ProcessId"] = fmt.Sprint(4242 + i)
+		e.EventData["Image"] = `C:\Windows\System32\cmd.exe`
+		e.EventData["CommandLine"] = "cmd.exe /c whoami"
+		// events logged by the forwarder are also accepted
+		if i%2 == 0 {
+			tt.CheckErr(json.NewEncoder(fd).Encode(event.NewEdrEvent(e)))
+		} else {
+			tt.CheckErr(json.NewEncoder(fd).Encode(e))
+		}
+	}
+	fd.Close()
+
+	c := BuildDefaultConfig(tmp)
+	c.Logfile = ""
+	c.FwdConfig.Local = false
+	c.FwdConfig.Client = clConf
+	c.Actions = config.Actions{}
+
+	a, err := NewAgent(c)
+	tt.CheckErr(err)
+	a.Replay = replay
+
+	r := testingRule()
+	r.Actions = []string{}
+	tt.CheckErr(a.Engine.LoadRule(&r))
+
+	a.preHooks.Hook(func(a *Agent, e *event.EdrEvent) {
+		processed++
+	}, fltAnyEvent)
+
+	tt.CheckErr(a.Run())
+	a.WaitWithTimeout(time.Second * 30)
+	a.Stop()
+
+	tt.Assert(processed == count, fmt.Sprintf("processed %d events", processed))
+	tt.Assert(a.stats.Detections() == float64(count))
+}
+
+func TestPreventer(t *testing.T) {
+	tt := toast.FromT(t)
+
+	p, err := NewPreventer(&config.Prevention{AllowList: []string{`(?i:\\Program Files\\Backup\\)`}})
+	tt.CheckErr(err)
+
+	r := testingRule()
+	r.Tags = []string{"Block"}
+	e := engine.NewEngine()
+	// rule tags are read from raw rules
+	e.SetDumpRaw(true)
+	tt.CheckErr(e.LoadRule(&r))
+
+	tt.Assert(!p.Blocks([]string{r.Name}))
+	p.SetRules(e)
+	tt.Assert(p.Blocks([]string{"Unknown", r.Name}))
+	tt.Assert(!p.Blocks([]string{"Unknown"}))
+
+	tt.Assert(p.Allowed(`c:\windows\system32\LSASS.exe`))
+	tt.Assert(p.Allowed(`C:\Program Files\Backup\agent.exe`))
+	tt.Assert(!p.Allowed(`C:\Users\Public\payload.exe`))
+}
+
+func TestDllBlocklist(t *testing.T) {
+	tt := toast.FromT(t)
+
+	b := NewDllBlocklist()
+	b.Add("mimikatz", "A3CB3B02A683275F7E0A0F8A9A5C9E07", "")
+	b.Add("injectors", " 9b6c1ff1dfc1ab3b4c2c1d6d0d2ae6f3d68b6c5e1ab9fdb5c4b8e86c7a1e0f2d ")
+	tt.Assert(b.Len() == 2)
+
+	hash, container, ok := b.Match("SHA1=0000000000000000000000000000000000000000,MD5=a3cb3b02a683275f7e0a0f8a9a5c9e07")
+	tt.Assert(ok && container == "mimikatz" && hash == "a3cb3b02a683275f7e0a0f8a9a5c9e07")
+
+	_, container, ok = b.Match("SHA256=9B6C1FF1DFC1AB3B4C2C1D6D0D2AE6F3D68B6C5E1AB9FDB5C4B8E86C7A1E0F2D")
+	tt.Assert(ok && container == "injectors")
+
+	_, _, ok = b.Match("MD5=00000000000000000000000000000000")
+	tt.Assert(!ok)
+}
+
+func TestFilterStats(t *testing.T) {
+	tt := toast.FromT(t)
+
+	s := NewFilterStats(&config.FilterStats{Enable: true, Interval: time.Minute, MaxRecords: 4})
+
+	r := engine.NewRule()
+	r.Name = "ProcessCreateFilter"
+	r.Meta.Events = map[string][]int64{sysmonChannel: {SysmonProcessCreate}}
+	r.Meta.Filter = true
+	e := engine.NewEngine()
+	tt.CheckErr(e.LoadRule(&r))
+	s.SetRules(e)
+
+	now := time.Now()
+	newEvent := func(computer string, id uint16, ts time.Time) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.Computer = computer
+		e.System.EventID = id
+		e.System.TimeCreated.SystemTime = ts
+		return event.NewEdrEvent(e)
+	}
+
+	// only filters matched, they are found by the aggregator
+	for i := 0; i < 10; i++ {
+		s.Add(newEvent("DESKTOP", SysmonProcessCreate, now.Add(time.Duration(i)*time.Second)), nil)
+	}
+	s.Add(newEvent("LAPTOP", SysmonProcessCreate, now), nil)
+	// rules reported by the engine
+	s.Add(newEvent("DESKTOP", SysmonNetworkConnect, now), []string{"LowCritRule", "NetworkFilter"})
+	tt.Assert(s.Len() == 4)
+
+	// maximum number of counts reached
+	s.Add(newEvent("SERVER", SysmonProcessCreate, now), nil)
+	s.Add(newEvent("SERVER", SysmonProcessCreate, now), nil)
+
+	since, counts := s.Flush(now.Add(time.Minute))
+	tt.Assert(!since.After(now))
+	tt.Assert(len(counts) == 5)
+	tt.Assert(s.Len() == 0)
+
+	m := make(map[string]*FilterCount)
+	for _, c := range counts {
+		m[c.Rule+"/"+c.Computer] = c
+	}
+	tt.Assert(m["ProcessCreateFilter/DESKTOP"].Count == 10)
+	tt.Assert(m["ProcessCreateFilter/DESKTOP"].LastSeen.Sub(m["ProcessCreateFilter/DESKTOP"].FirstSeen) == 9*time.Second)
+	tt.Assert(m["ProcessCreateFilter/LAPTOP"].Count == 1)
+	tt.Assert(m["LowCritRule/DESKTOP"].EventID == SysmonNetworkConnect)
+	tt.Assert(m["NetworkFilter/DESKTOP"].Count == 1)
+	tt.Assert(m[FilterStatsOverflow+"/"].Count == 2)
+
+	ee := filterStatsEvent(since, now, m["ProcessCreateFilter/DESKTOP"])
+	tt.Assert(ee.Channel() == agentChannel)
+	tt.Assert(ee.EventID() == agentFilterStatsEventID)
+	tt.Assert(!ee.IsDetection())
+}
+
+func TestRemoveChannel(t *testing.T) {
+	tt := toast.FromT(t)
+
+	channels := []string{"etw:Microsoft-Windows-DNS-Client:0xff:3006", "etw:Microsoft-Windows-Kernel-Process"}
+
+	tt.Assert(len(removeChannel(channels, "etw:microsoft-windows-dns-client")) == 1)
+	tt.Assert(removeChannel(channels, "etw:Microsoft-Windows-Kernel-Process:0xff")[0] == channels[0])
+	tt.Assert(len(removeChannel(channels, "etw:Unknown")) == 2)
+}
+
+func TestBookmarks(t *testing.T) {
+	tt := toast.FromT(t)
+
+	now := time.Now()
+	b := NewBookmarks(&config.Bookmarks{
+		Channels: []string{sysmonChannel},
+		MaxGap:   time.Hour,
+	})
+
+	// nothing processed yet
+	tt.Assert(len(b.Gaps(now)) == 0)
+
+	for _, channel := range []string{sysmonChannel, "Security"} {
+		e := etw.NewEvent()
+		e.System.Channel = channel
+		e.System.TimeCreated.SystemTime = now.Add(-time.Minute)
+		b.Update(event.NewEdrEvent(e))
+	}
+
+	gaps := b.Gaps(now)
+	tt.Assert(len(gaps) == 1)
+	tt.Assert(gaps[sysmonChannel].Equal(now.Add(-time.Minute)))
+	// gap is bounded
+	tt.Assert(b.Gaps(now.Add(2 * time.Hour))[sysmonChannel].Equal(now.Add(time.Hour)))
+
+	since := time.Date(2022, 3, 14, 10, 21, 4, 123456700, time.UTC)
+	tt.Assert(gapQuery(since, since.Add(time.Second)) == "*[System[TimeCreated[@SystemTime>'2022-03-14T10:21:04.1234567Z' and @SystemTime<='2022-03-14T10:21:05.1234567Z']]]")
+}
+
+func TestIoCMemory(t *testing.T) {
+	tt := toast.FromT(t)
+
+	dbPath := filepath.Join(t.TempDir(), "db")
+	now := time.Now()
+	indicator := strings.Repeat("a", 64)
+	dll := `C:\Users\Public\evil.dll`
+
+	boot := func() (*IoCMemory, *sod.DB) {
+		m := NewIoCMemory(&config.IoCMemory{Enable: true, TTL: time.Hour, MaxEntries: 3})
+		db := sod.Open(dbPath)
+		tt.CheckErr(db.Create(&IoCHit{}, sod.DefaultSchema))
+		tt.CheckErr(m.Load(db))
+		return m, db
+	}
+
+	m, db := boot()
+	tt.Assert(!m.Alerted(indicator, dll, now))
+	// same object is not alerted again, case does not matter
+	tt.Assert(m.Alerted(indicator, strings.ToUpper(dll), now.Add(time.Minute)))
+	// other objects matching the same indicator are
+	tt.Assert(!m.Alerted(indicator, `C:\Windows\Temp\evil.dll`, now))
+	// hit expired
+	tt.Assert(!m.Alerted(indicator, dll, now.Add(2*time.Hour)))
+	tt.Assert(m.Len() == 2)
+
+	// memory is full, new hits are not remembered
+	tt.Assert(!m.Alerted(indicator, `C:\a.dll`, now))
+	tt.Assert(!m.Alerted(indicator, `C:\b.dll`, now))
+	tt.Assert(!m.Alerted(indicator, `C:\b.dll`, now))
+	tt.Assert(m.Len() == 3)
+
+	m.Prune(now.Add(90 * time.Minute))
+	tt.Assert(m.Len() == 1)
+
+	// hits are kept across restarts
+	tt.CheckErr(m.Save(db))
+	tt.CheckErr(db.Close())
+	m, db = boot()
+	defer db.Close()
+	tt.Assert(m.Len() == 1)
+	tt.Assert(m.Alerted(indicator, dll, now.Add(2*time.Hour+time.Minute)))
+}
+
+func TestDNSCache(t *testing.T) {
+	tt := toast.FromT(t)
+
+	now := time.Now()
+	c := NewDNSCache(&config.DNSCache{Enable: true, TTL: time.Hour, MaxEntries: 3})
+
+	results := "type:  5 cdn.evil.com;::ffff:198.51.100.7;2001:0db8::0001;"
+	ips := dnsAnswerIPs(results)
+	tt.Assert(len(ips) == 2)
+	tt.Assert(ips[0] == "198.51.100.7")
+	tt.Assert(ips[1] == "2001:db8::1")
+
+	c.Add(append([]string{"www.evil.com"}, dnsAnswerNames(results)...), ips, now)
+	names := c.Names("198.51.100.7", now.Add(time.Minute))
+	tt.Assert(len(names) == 2)
+	tt.Assert(names[0] == "www.evil.com")
+	tt.Assert(names[1] == "cdn.evil.com")
+	tt.Assert(len(c.Names("2001:db8::1", now)) == 2)
+
+	// most recently resolved first, names are not duplicated
+	c.Add([]string{"fast.flux.net", "WWW.EVIL.COM"}, []string{"198.51.100.7"}, now.Add(time.Minute))
+	names = c.Names("198.51.100.7", now.Add(time.Minute))
+	tt.Assert(len(names) == 3)
+	tt.Assert(names[0] == "fast.flux.net")
+
+	// resolution expired
+	tt.Assert(len(c.Names("2001:db8::1", now.Add(2*time.Hour))) == 0)
+	tt.Assert(len(c.Names("203.0.113.1", now)) == 0)
+
+	// cache is full, expired entries are pruned first
+	c.Add([]string{"a.com"}, []string{"203.0.113.1"}, now)
+	tt.Assert(c.Len() == 3)
+	c.Add([]string{"b.com"}, []string{"203.0.113.2"}, now.Add(61*time.Minute))
+	tt.Assert(c.Len() == 2)
+	tt.Assert(len(c.Names("198.51.100.7", now.Add(61*time.Minute))) == 3)
+
+	// least recently resolved evicted
+	c.Add([]string{"c.com"}, []string{"203.0.113.3"}, now.Add(62*time.Minute))
+	c.Add([]string{"d.com"}, []string{"203.0.113.4"}, now.Add(63*time.Minute))
+	tt.Assert(c.Len() == 3)
+	tt.Assert(len(c.Names("198.51.100.7", now.Add(63*time.Minute))) == 0)
+
+	// nil cache does not resolve anything
+	var nilCache *DNSCache
+	nilCache.Add([]string{"a.com"}, []string{"203.0.113.1"}, now)
+	tt.Assert(len(nilCache.Names("203.0.113.1", now)) == 0)
+	tt.Assert(nilCache.Len() == 0)
+}
+
+func TestIoCRules(t *testing.T) {
+	tt := toast.FromT(t)
+
+	e := engine.NewEngine()
+	tt.CheckErr(e.LoadContainer(server.IoCContainerName, strings.NewReader("evil.com\n")))
+	for _, r := range IoCRules {
+		r := r
+		tt.CheckErr(e.LoadRule(&r))
+	}
+}
+
+func TestEventMetadata(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := &config.Agent{}
+	c.RulesConfig.RulesDB = t.TempDir()
+	a := &Agent{config: c}
+
+	newEvent := func() *event.EdrEvent {
+		e := event.NewEdrEvent(etw.NewEvent())
+		a.decorate(e)
+		return e
+	}
+
+	// no metadata known yet
+	tt.Assert(newEvent().Event.EdrData == nil)
+
+	sysinfo.RegisterEdrInfo(&sysinfo.EdrInfo{Version: "v1.2.3"})
+	defer sysinfo.RegisterEdrInfo(nil)
+
+	path, sha256Path := c.RulesConfig.RulesPaths()
+	tt.CheckErr(os.WriteFile(path, []byte("{}"), 0600))
+	a.updateEventMetadata()
+
+	md := newEvent().Event.EdrData.Agent
+	tt.Assert(md.Version == "v1.2.3")
+	// rules were not pulled from manager
+	tt.Assert(md.RulesSha256 == data.Sha256([]byte("{}")))
+
+	// metadata is shared as long as it does not change
+	a.updateEventMetadata()
+	tt.Assert(newEvent().Event.EdrData.Agent == md)
+
+	tt.CheckErr(os.WriteFile(sha256Path, []byte(strings.Repeat("a", 64)+"\n"), 0600))
+	a.updateEventMetadata()
+	tt.Assert(newEvent().Event.EdrData.Agent.RulesSha256 == strings.Repeat("a", 64))
+	// events already forwarded keep the metadata they were stamped with
+	tt.Assert(md.RulesSha256 == data.Sha256([]byte("{}")))
+}
+
+func TestYaraOutput(t *testing.T) {
+	tt := toast.FromT(t)
+
+	out := []byte(`Mimikatz_Strings [hacktool,credentials] C:\Users\Public\m ka.exe
+CobaltStrike_Beacon [] 4242
+
+garbage
+`)
+
+	matches := parseYaraOutput(out)
+	tt.Assert(len(matches) == 2)
+	tt.Assert(matches[0].Rule == "Mimikatz_Strings")
+	tt.Assert(len(matches[0].Tags) == 2 && matches[0].Tags[1] == "credentials")
+	tt.Assert(matches[0].Target == `C:\Users\Public\m ka.exe`)
+	tt.Assert(matches[1].Rule == "CobaltStrike_Beacon")
+	tt.Assert(len(matches[1].Tags) == 0 && matches[1].Target == "4242")
+
+	c := &config.Yara{Rules: "rules.yarc", Compiled: true}
+	args := yaraArgs(c, t.TempDir())
+	tt.Assert(strings.Join(args[:4], " ") == "-g -w -C -r")
+	tt.Assert(args[len(args)-2] == "rules.yarc")
+	tt.Assert(strings.Join(yaraArgs(&config.Yara{Rules: "rules.yar"}, "4242"), " ") == "-g -w rules.yar 4242")
+}
+
+func TestHashCache(t *testing.T) {
+	tt := toast.FromT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin")
+	tt.CheckErr(os.WriteFile(path, []byte("whids"), 0600))
+
+	c := NewHashCache(&config.HashCache{Enable: true, MaxEntries: 2})
+
+	h, err := c.Hashes(path)
+	tt.CheckErr(err)
+	tt.Assert(h["sha256"] == "242f0879a50181db5cc3477da46d3b33c8c35f1d393d54d6d1cda9ea826308df")
+	tt.Assert(len(h["md5"]) == 32 && len(h["sha1"]) == 40 && len(h["sha512"]) == 128)
+
+	// cache is used whatever the case of the path
+	cached, err := c.Sha256(strings.ToUpper(path))
+	tt.CheckErr(err)
+	tt.Assert(cached == h["sha256"])
+	s := c.Stats()
+	tt.Assert(s.Hits == 1 && s.Misses == 1 && s.BytesSaved == 5 && s.Entries == 1)
+
+	// file modified
+	tt.CheckErr(os.WriteFile(path, []byte("modified"), 0600))
+	modified, err := c.Sha256(path)
+	tt.CheckErr(err)
+	tt.Assert(modified != h["sha256"])
+	tt.Assert(c.Stats().Misses == 2)
+
+	// invalidated file is hashed again
+	c.Invalidate(path)
+	tt.Assert(c.Len() == 0)
+	_, err = c.Sha256(path)
+	tt.CheckErr(err)
+	tt.Assert(c.Stats().Misses == 3)
+
+	// least recently used entries are evicted when cache is full
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name)
+		tt.CheckErr(os.WriteFile(p, []byte(name), 0600))
+		_, err = c.Sha256(p)
+		tt.CheckErr(err)
+	}
+	tt.Assert(c.Len() == 2)
+	_, err = c.Sha256(filepath.Join(dir, "b"))
+	tt.CheckErr(err)
+	tt.Assert(c.Stats().Hits == 2)
+
+	// a nil cache hashes files
+	var nc *HashCache
+	sha256, err := nc.Sha256(path)
+	tt.CheckErr(err)
+	tt.Assert(sha256 == modified)
+	tt.Assert(nc.Len() == 0)
+}
+
+const (
+	// Sysmon process creation rendered as XML by the Windows Event Log API
+	xmlSysmonEvent = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Sysmon' Guid='{5770385f-c22a-43e0-bf4c-06f5698ffbd9}'/><EventID>1</EventID><Version>5</Version><Level>4</Level><Task>1</Task><Opcode>0</Opcode><Keywords>0x8000000000000000</Keywords><TimeCreated SystemTime='2022-03-14T10:21:04.1234567Z'/><EventRecordID>42</EventRecordID><Correlation/><Execution ProcessID='2840' ThreadID='3884'/><Channel>Microsoft-Windows-Sysmon/Operational</Channel><Computer>DESKTOP</Computer><Security UserID='S-1-5-18'/></System><EventData><Data Name='Image'>C:\Windows\System32\cmd.exe</Data><Data Name='CommandLine'>cmd.exe /c echo &quot;a&amp;b&quot;</Data></EventData></Event>`
+)
+
+func TestXMLToEvent(t *testing.T) {
+	tt := toast.FromT(t)
+
+	data := []byte(xmlSysmonEvent)
+
+	e, err := xmlToEvent(data)
+	tt.CheckErr(err)
+	tt.Assert(e.System.Channel == sysmonChannel)
+	tt.Assert(e.System.EventID == SysmonProcessCreate)
+	tt.Assert(e.System.Level.Value == 4)
+	tt.Assert(e.System.Keywords.Value == 0x8000000000000000)
+	tt.Assert(e.System.Execution.ProcessID == 2840)
+	tt.Assert(e.System.TimeCreated.SystemTime.Equal(time.Date(2022, 3, 14, 10, 21, 4, 123456700, time.UTC)))
+	tt.Assert(e.EventData["Image"] == `C:\Windows\System32\cmd.exe`)
+	tt.Assert(e.EventData["CommandLine"] == `cmd.exe /c echo "a&b"`)
+
+	_, err = xmlToEvent([]byte(`<Event><System><EventID>foo</EventID></System></Event>`))
+	tt.Assert(err != nil)
+}
+
+func BenchmarkXMLToEvent(b *testing.B) {
+	data := []byte(xmlSysmonEvent)
+
+	b.Run("Direct", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := xmlToEvent(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// conversion going through a JSON round-trip, as done by wevtapi helpers
+	b.Run("JSONRoundTrip", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var xe wevtapi.XMLEvent
+
+			if err := xml.Unmarshal(data, &xe); err != nil {
+				b.Fatal(err)
+			}
+
+			buf, err := json.Marshal(xe.ToJSONEvent())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if err := json.Unmarshal(buf, etw.NewEvent()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestAgentSafeMode(t *testing.T) {
+	tt := toast.FromT(t)
+
+	defer cleanup()
+
+	tmp, err := utils.HidsMkTmpDir()
+	tt.CheckErr(err)
+	defer os.RemoveAll(tmp)
+
+	c := BuildDefaultConfig(tmp)
+	c.Logfile = ""
+	c.EnableFiltering = false
+	c.FwdConfig.Local = true
+	c.Actions = config.Actions{}
+
+	// broken rule database
+	tt.CheckErr(os.MkdirAll(c.RulesConfig.RulesDB, 0700))
+	broken := filepath.Join(c.RulesConfig.RulesDB, "broken.gen")
+	tt.CheckErr(os.WriteFile(broken, []byte(`{"Name": "Broken", "Condition": "$a and"}`), 0600))
+
+	// agent must start anyway
+	a, err := NewAgent(c)
+	tt.CheckErr(err)
+	tt.Assert(a.IsSafeMode())
+
+	// high value events are filtered and forwarded
+	e := etw.NewEvent()
+	e.System.Channel = sysmonChannel
+	e.System.EventID = SysmonProcessCreate
+	_, _, filtered := a.Engine.MatchOrFilter(event.NewEdrEvent(e))
+	tt.Assert(filtered)
+
+	e.System.EventID = SysmonImageLoad
+	_, _, filtered = a.Engine.MatchOrFilter(event.NewEdrEvent(e))
+	tt.Assert(!filtered)
+
+	// self alert
+	se := a.safeModeEvent(fmt.Errorf("test"))
+	tt.Assert(se.IsDetection())
+	tt.Assert(se.Channel() == agentChannel)
+
+	// fixing rules makes agent leave safe mode
+	tt.CheckErr(os.Remove(broken))
+	tt.CheckErr(a.update(true))
+	tt.Assert(!a.IsSafeMode())
+}
+
+type chanProvider struct {
+	c chan *etw.Event
+}
+
+func (p *chanProvider) Start() error            { return nil }
+func (p *chanProvider) Stop() error             { close(p.c); return nil }
+func (p *chanProvider) Events() chan *etw.Event { return p.c }
+func (p *chanProvider) LostEvents() uint64      { return 0 }
+
+type countingOutput struct {
+	sync.Mutex
+	piped  int
+	closed bool
+}
+
+func (o *countingOutput) PipeEvent(e interface{}) error {
+	o.Lock()
+	defer o.Unlock()
+	o.piped++
+	return nil
+}
+
+func (o *countingOutput) Close() {
+	o.Lock()
+	defer o.Unlock()
+	o.closed = true
+}
+
+func TestAgentEmbedded(t *testing.T) {
+	tt := toast.FromT(t)
+
+	defer cleanup()
+
+	tmp, err := utils.HidsMkTmpDir()
+	tt.CheckErr(err)
+	defer os.RemoveAll(tmp)
+
+	c := BuildDefaultConfig(tmp)
+	c.Logfile = ""
+	c.FwdConfig.Local = true
+	c.Actions = config.Actions{}
+
+	count := 10
+	provider := &chanProvider{make(chan *etw.Event)}
+	output := &countingOutput{}
+
+	a, err := NewAgent(c,
+		WithContext(context.Background()),
+		WithLogger(golog.FromStdout()),
+		WithEventProvider(provider),
+		WithEventForwarder(output),
+	)
+	tt.CheckErr(err)
+
+	r := testingRule()
+	r.Actions = []string{}
+	tt.CheckErr(a.Engine.LoadRule(&r))
+
+	tt.CheckErr(a.Run())
+	for i := 0; i < count; i++ {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.EventID = SysmonProcessCreate
+		e.System.TimeCreated.SystemTime = time.Now()
+		e.EventData["ProcessGuid"] = fmt.Sprintf("{%s}", utils.UUIDOrPanic())
+		e.EventData["Image"] = `C:\Windows\System32\cmd.exe`
+		provider.c <- e
+	}
+
+	// events are processed asynchronously
+	piped := func() int {
+		output.Lock()
+		defer output.Unlock()
+		return output.piped
+	}
+	for start := time.Now(); piped() < count && time.Since(start) < 10*time.Second; {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	a.Stop()
+	a.Wait()
+
+	tt.Assert(output.closed)
+	tt.Assert(piped() == count, fmt.Sprintf("piped %d events", piped()))
+}
+
+func TestCustomSources(t *testing.T) {
+	tt := toast.FromT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := &config.CustomSources{
+		Listen:  "127.0.0.1:1520",
+		Sources: []*config.CustomSource{{Name: "Sensor", Key: "secret"}},
+	}
+
+	tt.CheckErr(validListenAddr(conf.Listen))
+	tt.CheckErr(validListenAddr("localhost:1520"))
+	tt.Assert(validListenAddr("0.0.0.0:1520") != nil)
+
+	s := newCustomSourcesServer(ctx, conf)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	post := func(key, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+CustomSourcesEventsPath, strings.NewReader(body))
+		tt.CheckErr(err)
+		req.Header.Set(api.AuthKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		tt.CheckErr(err)
+		return resp
+	}
+
+	resp := post("bad", `{"event-id":1}`)
+	resp.Body.Close()
+	tt.Assert(resp.StatusCode == http.StatusUnauthorized)
+
+	received := make([]*etw.Event, 0)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			received = append(received, <-s.events)
+		}
+	}()
+
+	resp = post("secret", `{"event-id":1,"data":{"Image":"C:\\sensor.exe"}}
+not json
+{"event-id":2}
+`)
+	defer resp.Body.Close()
+	tt.Assert(resp.StatusCode == http.StatusOK)
+
+	res := CustomIngestion{}
+	tt.CheckErr(json.NewDecoder(resp.Body).Decode(&res))
+	tt.Assert(res.Accepted == 2)
+	tt.Assert(res.Rejected == 1)
+
+	<-done
+	tt.Assert(received[0].System.Channel == CustomChannelPrefix+"Sensor")
+	tt.Assert(received[0].System.EventID == 1)
+	tt.Assert(received[0].EventData["Image"] == `C:\sensor.exe`)
+	tt.Assert(!received[1].System.TimeCreated.SystemTime.IsZero())
+}
+
+func TestHookPanic(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := &Agent{logger: golog.FromStdout()}
+	a.errors.report = api.NewAgentErrorReport()
+
+	calls := 0
+	hm := NewHookMan()
+	hm.Hook(func(*Agent, *event.EdrEvent) { panic("faulty hook") }, fltAnyEvent)
+	hm.Hook(func(*Agent, *event.EdrEvent) { calls++ }, fltAnyEvent)
+
+	for i := 0; i < MaxHookPanics*2; i++ {
+		e := event.NewEdrEvent(etw.NewEvent())
+		// pipeline must survive and next hooks must run
+		tt.Assert(hm.RunHooksOn(a, e))
+	}
+
+	tt.Assert(calls == MaxHookPanics*2)
+	tt.Assert(len(hm.Disabled()) == 1)
+	tt.Assert(len(a.errors.report.Errors) == 1)
+	for _, rec := range a.errors.report.Errors {
+		tt.Assert(rec.Kind == api.AgentErrorHook)
+		// hook is not run anymore once disabled
+		tt.Assert(rec.Count == int64(MaxHookPanics))
+	}
+}
+
+func TestWatchlists(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := &Agent{watchlists: NewWatchlists()}
+	a.watchlists.Add("vip", `CORP\CEO`, "cfo", "")
+	a.watchlists.Add("service-accounts", "svc_backup")
+
+	tt.Assert(a.watchlists.Len() == 3)
+	tt.Assert(len(a.watchlists.Match(`corp\ceo`)) == 1)
+	tt.Assert(len(a.watchlists.Match(`OTHER\CFO`)) == 1)
+	tt.Assert(len(a.watchlists.Match(`OTHER\ceo`)) == 0)
+
+	e := event.NewEdrEvent(etw.NewEvent())
+	e.Set(pathSubjectUserName, "svc_backup")
+	e.Set(pathSubjectDomainName, "CORP")
+	e.Set(pathTargetUserName, "CEO")
+	e.Set(pathTargetDomainName, "CORP")
+
+	hookWatchlists(a, e)
+	tt.Assert(strings.Join(eventWatchlists(e), ",") == "service-accounts,vip")
+
+	// event not involving watched accounts
+	e = event.NewEdrEvent(etw.NewEvent())
+	e.Set(pathSysmonUser, `CORP\john`)
+	hookWatchlists(a, e)
+	tt.Assert(len(eventWatchlists(e)) == 0)
+}
+
+func TestSelfTestRules(t *testing.T) {
+	tt := toast.FromT(t)
+
+	eng := engine.NewEngine()
+	for _, r := range SelfTestRules {
+		tt.CheckErr(eng.LoadRule(&r))
+	}
+	tt.Assert(eng.Count() == len(selfTests))
+
+	a := &Agent{}
+	run := newSelfTestRun()
+	a.selftest.run = run
+
+	newEvent := func(id int64, data map[string]string) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.EventID = uint16(id)
+		for k, v := range data {
+			e.EventData[k] = v
+		}
+		return event.NewEdrEvent(e)
+	}
+
+	cmd := newEvent(SysmonProcessCreate, map[string]string{
+		"Image":       `C:\Windows\System32\cmd.exe`,
+		"CommandLine": "cmd.exe /c echo " + run.marker,
+		"Ancestors":   `C:\Program Files\Whids\whids.exe`,
+	})
+	dns := newEvent(SysmonDNSQuery, map[string]string{
+		"Image":     `C:\Windows\System32\PING.EXE`,
+		"QueryName": run.marker + ".invalid",
+	})
+	// marker of another run
+	other := newEvent(SysmonProcessCreate, map[string]string{
+		"Image":       `C:\Windows\System32\cmd.exe`,
+		"CommandLine": "cmd.exe /c echo whids-selftest-00000000",
+	})
+
+	for _, e := range []*event.EdrEvent{cmd, dns, other} {
+		n, _, _ := eng.MatchOrFilter(e)
+		tt.Assert(len(n) == 1)
+	}
+
+	tt.Assert(a.selfTestExpects(cmd))
+	tt.Assert(a.selfTestObserve(cmd))
+	tt.Assert(a.selfTestObserve(dns))
+	tt.Assert(!a.selfTestExpects(other))
+	tt.Assert(!a.selfTestObserve(other))
+
+	res := run.results[SelfTestRulePrefix+"T1059.003"]
+	tt.Assert(res.Detected && res.Enriched)
+	// DGA score hook did not run
+	res = run.results[SelfTestRulePrefix+"T1071.004"]
+	tt.Assert(res.Detected && !res.Enriched)
+	tt.Assert(!run.results[SelfTestRulePrefix+"T1059.001"].Detected)
+	tt.Assert(len(run.events) == 2)
+}
+
+func TestStatsPersistence(t *testing.T) {
+	tt := toast.FromT(t)
+
+	dbPath := filepath.Join(t.TempDir(), "db")
+
+	newEvent := func(channel string, rule string) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = channel
+		edr := event.NewEdrEvent(e)
+		if rule != "" {
+			d := engine.NewDetection(false, false)
+			d.Signature.Add(rule)
+			edr.SetDetection(d)
+		}
+		return edr
+	}
+
+	boot := func() *Agent {
+		a := &Agent{
+			stats: NewEventStats(MaxEPS, MaxEPSDuration),
+			db:    sod.Open(dbPath),
+		}
+		tt.CheckErr(a.restoreStats())
+		return a
+	}
+
+	a := boot()
+	a.stats.Update(newEvent(sysmonChannel, ""))
+	a.stats.Update(newEvent(sysmonChannel, "SomeRule"))
+	a.stats.Update(newEvent(securityChannel, ""))
+	a.stats.Suppress()
+	since := a.Stats().Since
+	tt.CheckErr(a.saveStats())
+	tt.CheckErr(a.db.Close())
+
+	// agent restarts
+	a = boot()
+	a.stats.Update(newEvent(sysmonChannel, "SomeRule"))
+
+	s := a.Stats()
+	tt.Assert(s.Boots == 2)
+	tt.Assert(s.Since.Equal(since))
+	tt.Assert(s.SinceBoot.Events == 1)
+	tt.Assert(s.SinceBoot.Detections == 1)
+	tt.Assert(s.SinceBoot.Suppressed == 0)
+	tt.Assert(s.Cumulative.Events == 4)
+	tt.Assert(s.Cumulative.Detections == 2)
+	tt.Assert(s.Cumulative.Suppressed == 1)
+	tt.Assert(s.Cumulative.Channels[sysmonChannel] == 3)
+	tt.Assert(s.Cumulative.Channels[securityChannel] == 1)
+	tt.Assert(s.Cumulative.Rules["SomeRule"] == 2)
+}
+
+func TestEventDB(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := config.EventDB{
+		Enable: true,
+		Dir:    t.TempDir(),
+	}
+	db := NewEventDB(&c)
+
+	now := time.Now().UTC()
+	newEvent := func(ts time.Time, image string) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.EventID = SysmonProcessCreate
+		e.System.TimeCreated.SystemTime = ts
+		e.EventData["Image"] = image
+		return event.NewEdrEvent(e)
+	}
+
+	// one event per minute over the last three hours
+	for i := 180; i > 0; i-- {
+		image := `C:\Windows\System32\cmd.exe`
+		if i%10 == 0 {
+			image = `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`
+		}
+		tt.CheckErr(db.Add(newEvent(now.Add(-time.Duration(i)*time.Minute+30*time.Second), image)))
+	}
+
+	// events are queryable before being flushed
+	q, err := ParseEventQuery([]string{"last=30m"}, now)
+	tt.CheckErr(err)
+	r, err := db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) == 30, fmt.Sprintf("got %d events", len(r.Events)))
+	tt.Assert(!r.Truncated)
+	// from the oldest to the newest
+	tt.Assert(r.Events[0].Timestamp().Before(r.Events[len(r.Events)-1].Timestamp()))
+
+	q, err = ParseEventQuery([]string{"last=4h", `Image~=(?i:\\powershell\.exe$)`, "EventID=1"}, now)
+	tt.CheckErr(err)
+	r, err = db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) == 18)
+
+	q, err = ParseEventQuery([]string{"pivot=" + now.Add(-time.Hour).Format(time.RFC3339), "delta=5m", "limit=3"}, now)
+	tt.CheckErr(err)
+	r, err = db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) == 3)
+	tt.Assert(r.Truncated)
+
+	q, err = ParseEventQuery([]string{"last=4h", "Channel=Unknown"}, now)
+	tt.CheckErr(err)
+	r, err = db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) == 0)
+
+	for _, args := range [][]string{{"last=foo"}, {"limit=0"}, {"Image"}, {"Image~=("}, {"since=" + now.Format(time.RFC3339), "until=" + now.Add(-time.Hour).Format(time.RFC3339)}} {
+		_, err = ParseEventQuery(args, now)
+		tt.Assert(err != nil)
+	}
+
+	// age limit removes events by hour
+	c.MaxAge = time.Hour
+	tt.CheckErr(db.Prune(now))
+	q, err = ParseEventQuery([]string{"last=4h", "limit=1000"}, now)
+	tt.CheckErr(err)
+	r, err = db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) > 0 && len(r.Events) <= 120, fmt.Sprintf("got %d events", len(r.Events)))
+	tt.Assert(r.Events[0].Timestamp().After(now.Add(-3 * time.Hour)))
+
+	// size limit removes everything but the newest hour
+	c.MaxAge = 0
+	c.MaxSize = 1
+	tt.CheckErr(db.Prune(now))
+	r, err = db.Query(q)
+	tt.CheckErr(err)
+	tt.Assert(len(r.Events) == 0)
+
+	tt.CheckErr(db.Close())
+}
+
+func TestCredentialRegistrationsDiff(t *testing.T) {
+	tt := toast.FromT(t)
+
+	lsa := `HKLM\SYSTEM\CurrentControlSet\Control\Lsa`
+	cp := `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Authentication\Credential Providers`
+
+	baseline := map[string]string{
+		lsa + `\Authentication Packages`:               "[msv1_0]",
+		lsa + `\Notification Packages`:                 "[scecli]",
+		cp + `\{60b78e88-ead8-445c-9cfd-0b87f74ea6cd}`: `C:\Windows\system32\authui.dll`,
+	}
+
+	tt.Assert(len(diffCredentialRegistrations(baseline, baseline)) == 0)
+
+	current := map[string]string{
+		lsa + `\Authentication Packages`:               "[msv1_0 evil]",
+		cp + `\{60b78e88-ead8-445c-9cfd-0b87f74ea6cd}`: `C:\Windows\system32\authui.dll`,
+		cp + `\{00000000-0000-0000-0000-000000000001}`: `C:\Users\Public\cp.dll`,
+	}
+
+	changes := diffCredentialRegistrations(baseline, current)
+	tt.Assert(len(changes) == 3)
+	for _, c := range changes {
+		switch c.Path {
+		case lsa + `\Authentication Packages`:
+			tt.Assert(c.Change == credChangeModified)
+			tt.Assert(c.Old == "[msv1_0]" && c.New == "[msv1_0 evil]")
+		case lsa + `\Notification Packages`:
+			tt.Assert(c.Change == credChangeRemoved)
+		case cp + `\{00000000-0000-0000-0000-000000000001}`:
+			tt.Assert(c.Change == credChangeAdded)
+			tt.Assert(c.New == `C:\Users\Public\cp.dll`)
+		default:
+			t.Errorf("unexpected change %s", c.Path)
+		}
+	}
+}
+
+func TestInventoryDiff(t *testing.T) {
+	tt := toast.FromT(t)
+
+	r, ok := parseFirewallRule("RemoteDesktop-UserMode-In-TCP",
+		`v2.30|Action=Allow|Active=TRUE|Dir=In|Protocol=6|Profile=Domain|Profile=Private|LPort=3389|App=%SystemRoot%\system32\svchost.exe|Svc=termservice|Name=@FirewallAPI.dll,-28775|`,
+		"local")
+	tt.Assert(ok)
+	tt.Assert(r.Direction == "in")
+	tt.Assert(r.Protocol == "tcp")
+	tt.Assert(r.LocalPorts == "3389")
+	tt.Assert(r.Profiles == "domain,private")
+	tt.Assert(r.Service == "termservice")
+
+	// disabled and block rules are not inventoried
+	_, ok = parseFirewallRule("disabled", `v2.30|Action=Allow|Active=FALSE|Dir=In|Protocol=6|LPort=445|`, "local")
+	tt.Assert(!ok)
+	_, ok = parseFirewallRule("block", `v2.30|Action=Block|Active=TRUE|Dir=In|Protocol=6|LPort=445|`, "local")
+	tt.Assert(!ok)
+
+	ports := listeningPorts([]*Connection{
+		{Protocol: "tcp", LocalAddr: "0.0.0.0", LocalPort: 445, State: "LISTEN", PID: 4, Image: "System"},
+		{Protocol: "tcp", LocalAddr: "10.0.0.1", LocalPort: 49712, State: "ESTABLISHED", PID: 1000},
+		{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 123, PID: 1200, Image: `C:\Windows\System32\svchost.exe`},
+		{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 123, PID: 1200, Image: `C:\Windows\System32\svchost.exe`},
+	})
+	tt.Assert(len(ports) == 2)
+	tt.Assert(ports[0].Port == 123 && ports[1].Port == 445)
+
+	profile := &sysinfo.FirewallProfile{Name: "public", Enabled: true, DefaultInbound: firewallActionBlock, DefaultOutbound: firewallActionAllow}
+	fw := &sysinfo.Firewall{
+		Profiles: []*sysinfo.FirewallProfile{profile},
+		Rules:    []*sysinfo.FirewallRule{r},
+	}
+	baseline := inventoryItems(fw, ports)
+	tt.Assert(len(baseline) == 4)
+	tt.Assert(len(diffInventory(baseline, baseline)) == 0)
+
+	// profile disabled, allow rule added and port closed
+	disabled := *profile
+	disabled.Enabled = false
+	evil := &sysinfo.FirewallRule{ID: "evil", Direction: "in", Protocol: "tcp", LocalPorts: "4444", Policy: "local"}
+	current := inventoryItems(&sysinfo.Firewall{
+		Profiles: []*sysinfo.FirewallProfile{&disabled},
+		Rules:    []*sysinfo.FirewallRule{r, evil},
+	}, ports[:1])
+
+	changes := diffInventory(baseline, current)
+	tt.Assert(len(changes) == 3)
+	for _, c := range changes {
+		switch c.Key {
+		case InventoryFirewallProfile + ":public":
+			tt.Assert(c.Change == credChangeModified)
+			tt.Assert(c.Weakening)
+		case InventoryFirewallRule + ":evil":
+			tt.Assert(c.Change == credChangeAdded)
+			tt.Assert(c.Weakening)
+		case InventoryListeningPort + ":tcp/0.0.0.0:445":
+			tt.Assert(c.Change == credChangeRemoved)
+			tt.Assert(!c.Weakening)
+		default:
+			t.Errorf("unexpected change %s", c.Key)
+		}
+	}
+
+	// profile enabled back is not weakening
+	changes = diffInventory(current, baseline)
+	for _, c := range changes {
+		tt.Assert(!c.Weakening, c.Key)
+	}
+}
+
+func TestNetstatTables(t *testing.T) {
+	tt := toast.FromT(t)
+
+	le := binary.LittleEndian
+
+	// one established connection 192.168.1.10:49712 -> 203.0.113.5:443
+	tcp4 := make([]byte, 4+tcp4RowSize)
+	le.PutUint32(tcp4, 1)
+	le.PutUint32(tcp4[4:], 5)
+	copy(tcp4[8:], []byte{192, 168, 1, 10})
+	le.PutUint32(tcp4[12:], uint32(0x30c2))
+	copy(tcp4[16:], []byte{203, 0, 113, 5})
+	le.PutUint32(tcp4[20:], uint32(0xbb01))
+	le.PutUint32(tcp4[24:], 4242)
+
+	conns, err := parseTCP4Table(tcp4)
+	tt.CheckErr(err)
+	tt.Assert(len(conns) == 1)
+	c := conns[0]
+	tt.Assert(c.Protocol == "tcp" && c.State == "ESTABLISHED")
+	tt.Assert(c.LocalAddr == "192.168.1.10" && c.LocalPort == 49712)
+	tt.Assert(c.RemoteAddr == "203.0.113.5" && c.RemotePort == 443)
+	tt.Assert(c.PID == 4242)
+
+	// one listening socket [::1]:53
+	udp6 := make([]byte, 4+udp6RowSize)
+	le.PutUint32(udp6, 1)
+	copy(udp6[4:], net.ParseIP("::1"))
+	le.PutUint32(udp6[24:], uint32(0x3500))
+	le.PutUint32(udp6[28:], 4)
+
+	conns, err = parseUDP6Table(udp6)
+	tt.CheckErr(err)
+	tt.Assert(len(conns) == 1)
+	tt.Assert(conns[0].Protocol == "udp6" && conns[0].LocalAddr == "::1" && conns[0].LocalPort == 53)
+	tt.Assert(conns[0].PID == 4)
+
+	// truncated tables
+	_, err = parseTCP6Table(tcp4)
+	tt.Assert(err != nil)
+	_, err = parseUDP4Table([]byte{1})
+	tt.Assert(err != nil)
+}
+
+func TestProcessAudit(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := &Agent{tracker: NewActivityTracker(), memdumped: datastructs.NewSyncedSet()}
+
+	auditEvent := func(id uint16, data map[string]string) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = securityChannel
+		e.System.EventID = id
+		e.System.TimeCreated.SystemTime = time.Now()
+		for k, v := range data {
+			e.EventData[k] = v
+		}
+		return event.NewEdrEvent(e)
+	}
+
+	shell := auditEvent(SecurityProcessCreate, map[string]string{
+		"NewProcessId":      "0x1a2c",
+		"NewProcessName":    `C:\Windows\System32\cmd.exe`,
+		"ProcessId":         "0x1f4",
+		"ParentProcessName": `C:\Windows\explorer.exe`,
+		"CommandLine":       "cmd.exe",
+		"SubjectUserName":   "john",
+		"SubjectDomainName": "CORP",
+		"TargetUserName":    "-",
+		"MandatoryLabel":    "S-1-16-8192",
+	})
+	hookTrackProcessAudit(a, shell)
+
+	child := auditEvent(SecurityProcessCreate, map[string]string{
+		"NewProcessId":      "0x2b3c",
+		"NewProcessName":    `C:\Windows\System32\whoami.exe`,
+		"ProcessId":         "0x1a2c",
+		"ParentProcessName": `C:\Windows\System32\cmd.exe`,
+		"CommandLine":       "whoami /all",
+		"MandatoryLabel":    "S-1-16-12288",
+	})
+	hookTrackProcessAudit(a, child)
+
+	cmd := a.tracker.GetByPID(0x1a2c)
+	tt.Assert(!cmd.IsZero())
+	tt.Assert(cmd.User == `CORP\john` && cmd.IntegrityLevel == "Medium")
+
+	whoami := a.tracker.GetByPID(0x2b3c)
+	tt.Assert(!whoami.IsZero())
+	tt.Assert(whoami.ParentProcessGUID == cmd.ProcessGUID)
+	tt.Assert(whoami.ParentCommandLine == "cmd.exe")
+	tt.Assert(whoami.IntegrityLevel == "High" && whoami.ParentUser == `CORP\john`)
+	tt.Assert(strings.Join(whoami.Ancestors, "|") == `C:\Windows\explorer.exe|C:\Windows\System32\cmd.exe`)
+
+	// event is enriched like Sysmon process creation
+	guid, _ := child.GetString(pathSysmonProcessGUID)
+	tt.Assert(guid == whoami.ProcessGUID)
+	pimage, _ := child.GetString(pathSysmonParentImage)
+	tt.Assert(pimage == `C:\Windows\System32\cmd.exe`)
+	ancestors, _ := child.GetString(pathAncestors)
+	tt.Assert(ancestors == strings.Join(whoami.Ancestors, "|"))
+
+	hookTrackProcessAudit(a, auditEvent(SecurityProcessTerminate, map[string]string{
+		"ProcessId":   "0x2b3c",
+		"ProcessName": `C:\Windows\System32\whoami.exe`,
+	}))
+	tt.Assert(a.flagProcTermEn)
+	tt.Assert(a.tracker.GetByGuid(guid).Terminated)
+}
+
+func TestAutoruns(t *testing.T) {
+	tt := toast.FromT(t)
+
+	files := map[string]bool{
+		`C:\Program Files\Vendor\agent.exe`: true,
+		`C:\Windows\System32\svchost.exe`:   true,
+	}
+	exists := func(p string) bool { return files[p] }
+
+	tt.Assert(autorunImage(`"C:\Program Files\Vendor\agent.exe" --background`, exists) == `C:\Program Files\Vendor\agent.exe`)
+	tt.Assert(autorunImage(`C:\Program Files\Vendor\agent.exe --background`, exists) == `C:\Program Files\Vendor\agent.exe`)
+	tt.Assert(autorunImage(`C:\Windows\System32\svchost -k netsvcs`, exists) == `C:\Windows\System32\svchost.exe`)
+	tt.Assert(autorunImage(`C:\Users\Public\missing.exe`, exists) == "")
+	tt.Assert(autorunImage("", exists) == "")
+
+	task := `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Actions Context="Author">
+    <Exec>
+      <Command>C:\Users\Public\updater.exe</Command>
+      <Arguments>-silent</Arguments>
+    </Exec>
+    <Exec>
+      <Command>cmd.exe</Command>
+    </Exec>
+  </Actions>
+</Task>`
+
+	// tasks are stored UTF-16 encoded with a BOM
+	data := []byte{0xff, 0xfe}
+	for _, r := range utf16.Encode([]rune(task)) {
+		data = append(data, byte(r), byte(r>>8))
+	}
+
+	actions, err := taskActions(data)
+	tt.CheckErr(err)
+	tt.Assert(len(actions) == 2)
+	tt.Assert(actions[0] == `C:\Users\Public\updater.exe -silent`)
+	tt.Assert(actions[1] == "cmd.exe")
+
+	_, err = taskActions([]byte("not xml"))
+	tt.Assert(err != nil)
+}
+
+func TestParseTask(t *testing.T) {
+	tt := toast.FromT(t)
+
+	task := `<?xml version="1.0" encoding="UTF-8"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Author>Microsoft Corporation</Author>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger>
+      <Enabled>true</Enabled>
+    </LogonTrigger>
+    <BootTrigger>
+      <Enabled>false</Enabled>
+    </BootTrigger>
+    <CalendarTrigger>
+      <StartBoundary>2022-01-01T09:00:00</StartBoundary>
+    </CalendarTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="LocalSystem">
+      <UserId>S-1-5-18</UserId>
+    </Principal>
+  </Principals>
+  <Settings>
+    <Hidden>true</Hidden>
+  </Settings>
+  <Actions Context="LocalSystem">
+    <Exec>
+      <Command>%windir%\system32\rundll32.exe</Command>
+      <Arguments>C:\Users\Public\payload.dll,Start</Arguments>
+    </Exec>
+    <ComHandler>
+      <ClassId>{47E30D54-DAC1-473A-AFF7-2355BF78881F}</ClassId>
+    </ComHandler>
+  </Actions>
+</Task>`
+
+	st, err := parseTask([]byte(task))
+	tt.CheckErr(err)
+	tt.Assert(st.Enabled)
+	tt.Assert(st.Hidden)
+	tt.Assert(st.Author == "Microsoft Corporation")
+	tt.Assert(st.RunAs == "S-1-5-18")
+	// disabled triggers are not reported
+	tt.Assert(len(st.Triggers) == 2)
+	tt.Assert(st.Triggers[0] == "LogonTrigger" && st.Triggers[1] == "CalendarTrigger")
+	tt.Assert(len(st.Actions) == 2)
+	tt.Assert(st.Actions[0].Type == TaskActionExec)
+	tt.Assert(st.Actions[0].CommandLine() == `%windir%\system32\rundll32.exe C:\Users\Public\payload.dll,Start`)
+	tt.Assert(st.Actions[1].Type == TaskActionComHandler)
+	tt.Assert(st.Actions[1].Command == "{47E30D54-DAC1-473A-AFF7-2355BF78881F}")
+
+	st, err = parseTask([]byte(`<Task><Settings><Enabled>false</Enabled></Settings></Task>`))
+	tt.CheckErr(err)
+	tt.Assert(!st.Enabled)
+	tt.Assert(len(st.Triggers) == 0 && len(st.Actions) == 0)
+}
+
+func TestParseEvtQueryArgs(t *testing.T) {
+	tt := toast.FromT(t)
+
+	channel, query, max, err := parseEvtQueryArgs([]string{"Security", "*[System[EventID=4624]]"})
+	tt.CheckErr(err)
+	tt.Assert(channel == "Security")
+	tt.Assert(query == "*[System[EventID=4624]]")
+	tt.Assert(max == DefaultEvtQueryMax)
+
+	_, _, max, err = parseEvtQueryArgs([]string{"Security", "*", "42"})
+	tt.CheckErr(err)
+	tt.Assert(max == 42)
+
+	_, _, _, err = parseEvtQueryArgs([]string{"Security"})
+	tt.Assert(err != nil)
+	_, _, _, err = parseEvtQueryArgs([]string{"Security", "*", "many"})
+	tt.Assert(err != nil)
+	_, _, _, err = parseEvtQueryArgs([]string{"Security", "*", "0"})
+	tt.Assert(err != nil)
+}
+
+func TestLogTamperRule(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := config.LogTamper{Enable: true, Criticality: 10, Triage: true}
+	r := c.GenRule()
+	e := engine.NewEngine()
+	e.ShowActions = true
+	tt.CheckErr(e.LoadRule(&r))
+
+	newEvent := func(channel string, id uint16) *event.EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = channel
+		e.System.EventID = id
+		return event.NewEdrEvent(e)
+	}
+
+	for _, evt := range []*event.EdrEvent{newEvent(securityChannel, 1102), newEvent("System", 104), newEvent(securityChannel, 4719)} {
+		names, crit, _ := e.MatchOrFilter(evt)
+		tt.Assert(len(names) == 1 && names[0] == config.LogTamperRuleName)
+		tt.Assert(crit == 10)
+		tt.Assert(evt.GetDetection().Actions.Contains(ActionReport))
+	}
+
+	names, _, _ := e.MatchOrFilter(newEvent(securityChannel, SecurityLogon))
+	tt.Assert(len(names) == 0)
+	names, _, _ = e.MatchOrFilter(newEvent(sysmonChannel, 104))
+	tt.Assert(len(names) == 0)
+}
+
+func TestRegExport(t *testing.T) {
+	tt := toast.FromT(t)
+
+	tt.Assert(regCanonicalKey(`hklm\SOFTWARE\Microsoft\`) == `HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft`)
+	tt.Assert(regCanonicalKey(`HKEY_USERS\.DEFAULT`) == `HKEY_USERS\.DEFAULT`)
+	tt.Assert(regCanonicalKey(`HKCR`) == `HKEY_CLASSES_ROOT`)
+
+	sz := []byte{'C', 0, ':', 0, '\\', 0, '"', 0, 'x', 0, '"', 0, 0, 0}
+	tt.Assert(regExportValue("Updater", syscall.REG_SZ, sz) == `"Updater"="C:\\\"x\""`)
+	tt.Assert(regExportValue("", syscall.REG_SZ, sz) == `@="C:\\\"x\""`)
+	tt.Assert(regExportValue("Start", syscall.REG_DWORD, []byte{2, 0, 0, 0}) == `"Start"=dword:00000002`)
+	tt.Assert(regExportValue("Blob", syscall.REG_BINARY, []byte{0xde, 0xad}) == `"Blob"=hex:de,ad`)
+	tt.Assert(regExportValue("Path", syscall.REG_EXPAND_SZ, []byte{'%', 0, 0, 0}) == `"Path"=hex(2):25,00,00,00`)
+	tt.Assert(regExportValue("Time", syscall.REG_QWORD, make([]byte, 8)) == `"Time"=hex(b):00,00,00,00,00,00,00,00`)
+
+	tt.Assert(bytes.Equal(regEncodeExport("A"), []byte{0xff, 0xfe, 'A', 0}))
+}
+
+func TestPrivSepPipe(t *testing.T) {
+	tt := toast.FromT(t)
+
+	tt.Assert(pipeSDDL("S-1-5-19") == "D:P(A;;GA;;;SY)(A;;GR;;;S-1-5-19)")
+
+	buf := new(bytes.Buffer)
+	for i := 0; i < 3; i++ {
+		e := etw.NewEvent()
+		e.System.EventID = uint16(i + 1)
+		e.System.Channel = "Microsoft-Windows-Sysmon/Operational"
+		tt.CheckErr(writeEvent(buf, e))
+	}
+	buf.WriteString("not an event\n")
+
+	c := make(chan *etw.Event, 3)
+	bad, err := readEvents(context.Background(), buf, c)
+	tt.CheckErr(err)
+	tt.Assert(bad == 1)
+	close(c)
+
+	i := 0
+	for e := range c {
+		i++
+		tt.Assert(e.System.EventID == uint16(i))
+		tt.Assert(e.System.Channel == "Microsoft-Windows-Sysmon/Operational")
+	}
+	tt.Assert(i == 3)
+
+	// pipe served by the test process
+	sid, err := processUser(uint32(os.Getpid()))
+	tt.CheckErr(err)
+
+	path := fmt.Sprintf(`\\.\pipe\whids-test-%d`, os.Getpid())
+	h, err := createEventPipe(path, sid)
+	tt.CheckErr(err)
+	defer syscall.CloseHandle(h)
+
+	// pipe cannot be squatted
+	_, err = createEventPipe(path, sid)
+	tt.Assert(err != nil)
+
+	fd, err := os.Open(path)
+	tt.CheckErr(err)
+	defer fd.Close()
+
+	err = verifyPipeServer(syscall.Handle(fd.Fd()))
+	if sid == sidLocalSystem {
+		tt.CheckErr(err)
+	} else {
+		tt.ExpectErr(err, ErrUntrustedPipeServer)
+	}
+}
+
+func TestRawMFT(t *testing.T) {
+	tt := toast.FromT(t)
+
+	boot := make([]byte, 512)
+	copy(boot[3:], ntfsOEMID)
+	binary.LittleEndian.PutUint16(boot[0x0b:], 512)
+	boot[0x0d] = 8
+	binary.LittleEndian.PutUint64(boot[0x30:], 786432)
+	// 1024 bytes file records
+	boot[0x40] = 0xf6
+
+	bs, err := parseNTFSBootSector(boot)
+	tt.CheckErr(err)
+	tt.Assert(bs.ClusterSize == 4096)
+	tt.Assert(bs.MFTCluster == 786432)
+	tt.Assert(bs.RecordSize == 1024)
+
+	_, err = parseNTFSBootSector(make([]byte, 512))
+	tt.Assert(err != nil)
+
+	// second run is 0x10 clusters before the first one, third one is sparse
+	runs, err := parseDataRuns([]byte{0x21, 0x40, 0x00, 0x10, 0x11, 0x20, 0xf0, 0x01, 0x08, 0x00})
+	tt.CheckErr(err)
+	tt.Assert(len(runs) == 3)
+	tt.Assert(runs[0] == dataRun{Cluster: 0x1000, Length: 0x40})
+	tt.Assert(runs[1] == dataRun{Cluster: 0x0ff0, Length: 0x20})
+	tt.Assert(runs[2] == dataRun{Cluster: -1, Length: 8})
+
+	_, err = parseDataRuns([]byte{0x31, 0x40, 0x00})
+	tt.Assert(err != nil)
+
+	// builds $MFT file record with a non resident $DATA attribute
+	rec := make([]byte, 1024)
+	copy(rec, mftRecordSignature)
+	binary.LittleEndian.PutUint16(rec[4:], 0x30)
+	binary.LittleEndian.PutUint16(rec[6:], 3)
+	binary.LittleEndian.PutUint16(rec[0x14:], 0x38)
+	attr := rec[0x38:]
+	binary.LittleEndian.PutUint32(attr, mftAttrData)
+	binary.LittleEndian.PutUint32(attr[4:], 0x48)
+	attr[8] = 1
+	binary.LittleEndian.PutUint16(attr[0x20:], 0x40)
+	binary.LittleEndian.PutUint64(attr[0x30:], 0x40*4096-100)
+	copy(attr[0x40:], []byte{0x21, 0x40, 0x00, 0x10, 0x00})
+	binary.LittleEndian.PutUint32(rec[0x38+0x48:], mftAttrEnd)
+	// update sequence
+	copy(rec[0x30:], []byte{0x01, 0x00, 0xaa, 0xbb, 0xcc, 0xdd})
+	copy(rec[510:], []byte{0x01, 0x00})
+	copy(rec[1022:], []byte{0x01, 0x00})
+
+	tt.CheckErr(mftRecordFixup(rec))
+	tt.Assert(bytes.Equal(rec[510:512], []byte{0xaa, 0xbb}))
+	tt.Assert(bytes.Equal(rec[1022:1024], []byte{0xcc, 0xdd}))
+	// torn record
+	tt.Assert(mftRecordFixup(rec) != nil)
+
+	runs, size, err := mftDataRuns(rec)
+	tt.CheckErr(err)
+	tt.Assert(size == 0x40*4096-100)
+	tt.Assert(len(runs) == 1)
+	tt.Assert(runs[0] == dataRun{Cluster: 0x1000, Length: 0x40})
+
+	tt.Assert(triageArchiveName(`C:\Windows\Prefetch\CMD.EXE-0BD30981.pf`) == "C/Windows/Prefetch/CMD.EXE-0BD30981.pf")
+	tt.Assert(mftPathRe.MatchString(`c:\$mft`))
+	tt.Assert(!mftPathRe.MatchString(`C:\Windows\$MFT`))
+}
+
+func TestContainFilters(t *testing.T) {
+	tt := toast.FromT(t)
+
+	ip4, ip6 := net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")
+	filters := containFilters([]net.IP{ip4, ip6})
+
+	// loopback, allowed IP and block all per layer + DHCP filters
+	tt.Assert(len(filters) == 4*3+2*2)
+
+	permits := make(map[wfpGUID]int)
+	blocks := make(map[wfpGUID]int)
+	for _, f := range filters {
+		switch f.action {
+		case fwpActionPermit:
+			tt.Assert(f.weight > wfpWeightBlock)
+			tt.Assert(len(f.conds) > 0)
+			permits[f.layer]++
+		case fwpActionBlock:
+			tt.Assert(len(f.conds) == 0)
+			blocks[f.layer]++
+		}
+	}
+
+	for _, layer := range wfpContainLayers {
+		tt.Assert(blocks[layer] == 1)
+		tt.Assert(permits[layer] == 3)
+	}
+
+	c := remoteAddrCondition(ip4)
+	tt.Assert(c.typ == fwpUint32)
+	tt.Assert(c.value == 0x0a000001)
+
+	c = remoteAddrCondition(ip6)
+	tt.Assert(c.typ == fwpByteArray16Type)
+	tt.Assert(bytes.Equal(c.addr[:], ip6.To16()))
+
+	// filters of a family only match addresses of that family
+	for _, f := range containFilters([]net.IP{ip4}) {
+		if f.layer == wfpLayerConnectV6 || f.layer == wfpLayerAcceptV6 {
+			for _, c := range f.conds {
+				tt.Assert(c.field != wfpConditionRemoteAddress)
+			}
+		}
+	}
+}
+
+func TestProcessIsolation(t *testing.T) {
+	tt := toast.FromT(t)
+
+	p := &IsolatedProcess{
+		ProcessGUID: "{515cd0d1-8a5e-6221-2700-000000003300}",
+		PID:         4242,
+		Image:       `C:\Program Files\App|x\app.exe`,
+	}
+
+	parsed, err := parseIsolatedProcess(p.desc())
+	tt.CheckErr(err)
+	tt.Assert(*parsed == *p)
+
+	tt.Assert(p.Matches("4242"))
+	tt.Assert(p.Matches(strings.ToUpper(p.ProcessGUID)))
+	tt.Assert(p.Matches(releaseAllProcesses))
+	tt.Assert(!p.Matches("424"))
+	tt.Assert(!p.Matches(""))
+
+	for _, desc := range []string{"", "guid|image", "guid|pid|image"} {
+		_, err = parseIsolatedProcess(desc)
+		tt.Assert(err != nil)
+	}
+
+	filters := processFilters(0x42, "isolate app.exe", p.desc())
+	tt.Assert(len(filters) == len(wfpContainLayers))
+	for _, f := range filters {
+		tt.Assert(f.action == fwpActionBlock)
+		tt.Assert(f.desc == p.desc())
+		tt.Assert(len(f.conds) == 1)
+		tt.Assert(f.conds[0].field == wfpConditionAppID)
+		tt.Assert(f.conds[0].blob == 0x42)
+	}
+}
+
+func TestProcessTree(t *testing.T) {
+	tt := toast.FromT(t)
+
+	pt := NewActivityTracker()
+	// root -> (child -> grandchild), other
+	pt.Add(NewProcessTrack(`C:\root.exe`, "{parent}", "{root}", 10))
+	pt.Add(NewProcessTrack(`C:\child.exe`, "{root}", "{child}", 11))
+	pt.Add(NewProcessTrack(`C:\grandchild.exe`, "{child}", "{grandchild}", 12))
+	pt.Add(NewProcessTrack(`C:\other.exe`, "{root}", "{other}", 13))
+	pt.Add(NewProcessTrack(`C:\unrelated.exe`, "{parent}", "{unrelated}", 14))
+
+	tree := pt.Tree("{root}")
+	tt.Assert(len(tree) == 4)
+	tt.Assert(tree[len(tree)-1].ProcessGUID == "{root}")
+
+	// children must come before their parent
+	pos := make(map[string]int)
+	for i, t := range tree {
+		pos[t.ProcessGUID] = i
+	}
+	tt.Assert(pos["{grandchild}"] < pos["{child}"])
+	tt.Assert(pos["{other}"] < pos["{root}"])
+	_, ok := pos["{unrelated}"]
+	tt.Assert(!ok)
+
+	// terminated processes are not part of the tree
+	pt.Terminate("{child}")
+	tree = pt.Tree("{root}")
+	tt.Assert(len(tree) == 2)
+
+	pt.Terminate("{root}")
+	tt.Assert(len(pt.Tree("{root}")) == 0)
+	tt.Assert(len(pt.Tree("{unknown}")) == 0)
+
+	pt.BlacklistTree("evil.exe")
+	tt.Assert(pt.IsBlacklisted("evil.exe"))
+	tt.Assert(pt.IsTreeBlacklisted("evil.exe"))
+	pt.Blacklist("bad.exe")
+	tt.Assert(!pt.IsTreeBlacklisted("bad.exe"))
+}
+
+func TestPacketCapture(t *testing.T) {
+	tt := toast.FromT(t)
+
+	conns := map[string]*ConStat{
+		"10.0.0.1":    {LastSeen: "2022-10-01 10:00:00.000"},
+		"10.0.0.2":    {LastSeen: "2022-10-01 12:00:00.000"},
+		"127.0.0.1":   {LastSeen: "2022-10-01 13:00:00.000"},
+		"not-an-ip":   {LastSeen: "2022-10-01 13:00:00.000"},
+		"192.168.1.1": {LastSeen: "2022-10-01 11:00:00.000"},
+	}
+
+	hosts := captureHosts("10.0.0.1", conns, 16)
+	tt.Assert(strings.Join(hosts, ",") == "10.0.0.1,10.0.0.2,192.168.1.1")
+	tt.Assert(len(captureHosts("", conns, 2)) == 2)
+	tt.Assert(len(captureHosts("", nil, 2)) == 0)
+	tt.Assert(bpfFilter(hosts[:2]) == "host 10.0.0.1 or host 10.0.0.2")
+
+	args := pcapArgs([]string{"-a", "duration:" + PcapDuration, "-s", PcapSnapLen, "-f", PcapFilter, "-w", PcapOutput},
+		`C:\dumps\capture.pcap`, "host 10.0.0.1", 30*time.Second, 512)
+	tt.Assert(strings.Join(args, " ") == `-a duration:30 -s 512 -f host 10.0.0.1 -w C:\dumps\capture.pcap`)
+
+	// pcap: 24 bytes header and records of 16 + 100 bytes
+	pcap := make([]byte, 24)
+	binary.LittleEndian.PutUint32(pcap, pcapMagic)
+	for i := 0; i < 4; i++ {
+		rec := make([]byte, 116)
+		binary.LittleEndian.PutUint32(rec[8:], 100)
+		pcap = append(pcap, rec...)
+	}
+	size := int64(len(pcap))
+
+	cut, err := captureCut(bytes.NewReader(pcap), size, size)
+	tt.CheckErr(err)
+	tt.Assert(cut == size)
+
+	cut, err = captureCut(bytes.NewReader(pcap), size, 24+2*116+50)
+	tt.CheckErr(err)
+	tt.Assert(cut == 24+2*116)
+
+	// pcapng: section header, interface description and packet blocks
+	block := func(order binary.ByteOrder, typ uint32, length int) []byte {
+		b := make([]byte, length)
+		order.PutUint32(b, typ)
+		order.PutUint32(b[4:], uint32(length))
+		if typ == pcapngSHB {
+			order.PutUint32(b[8:], pcapngByteOrder)
+		}
+		return b
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		ng := append(block(order, pcapngSHB, 28), block(order, 1, 20)...)
+		for i := 0; i < 4; i++ {
+			ng = append(ng, block(order, 6, 132)...)
+		}
+		size = int64(len(ng))
+
+		cut, err = captureCut(bytes.NewReader(ng), size, 48+132+131)
+		tt.CheckErr(err)
+		tt.Assert(cut == 48+132)
+	}
+
+	_, err = captureCut(bytes.NewReader(make([]byte, 64)), 64, 32)
+	tt.Assert(err != nil)
+}
+
+func TestSuspendProcess(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := &Agent{suspended: datastructs.NewSyncedSet()}
+
+	terminated := NewProcessTrack(`C:\evil.exe`, "{parent}", "{terminated}", 4242)
+	terminated.Terminated = true
+	tt.Assert(a.suspendProcess(terminated) != nil)
+
+	self := NewProcessTrack(`C:\whids.exe`, "{parent}", "{self}", int64(os.Getpid()))
+	tt.Assert(a.suspendProcess(self) != nil)
+
+	critical := NewProcessTrack(`C:\Windows\System32\csrss.exe`, "{parent}", "{critical}", 4243)
+	tt.Assert(a.suspendProcess(critical) != nil)
+
+	// only processes suspended by agent can be resumed
+	tt.Assert(a.resumeProcess(NewProcessTrack(`C:\evil.exe`, "{parent}", "{running}", 4244)) != nil)
+	tt.Assert(a.suspended.Len() == 0)
+}
+
+func TestPlaybooks(t *testing.T) {
+	tt := toast.FromT(t)
+
+	pb := &api.Playbook{Name: "ransomware"}
+	p := NewPlaybooks()
+
+	// playbook runs once per process until process terminates
+	tt.Assert(p.once(pb, "{guid}"))
+	tt.Assert(!p.once(pb, "{guid}"))
+	tt.Assert(p.once(&api.Playbook{Name: "other"}, "{guid}"))
+	tt.Assert(p.once(pb, "{other}"))
+	p.forget("{guid}")
+	tt.Assert(len(p.ran) == 1)
+	tt.Assert(p.once(pb, "{guid}"))
+
+	// destructive steps are refused when approvals are required
+	a := &Agent{config: &config.Agent{}}
+	a.config.ResponderConfig.ApprovalKey = strings.Repeat("00", 32)
+	m := &ActionHandler{edr: a}
+	for _, action := range api.DestructivePlaybookSteps {
+		err := m.runPlaybookStep(pb, &api.PlaybookStep{Action: action}, nil)
+		if config.FeatureResponse.Compiled() {
+			tt.ExpectErr(err, api.ErrApprovalRequired)
+		} else {
+			tt.Assert(err != nil)
+		}
+	}
+}
+
+func TestDumpProcessMemory(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := &Agent{tracker: NewActivityTracker()}
+	// parent process is running and can be dumped
+	ppid := os.Getppid()
+	a.tracker.Add(NewProcessTrack(`C:\Windows\explorer.exe`, "{parent}", "{running}", int64(ppid)))
+
+	// resolving by PID and by GUID
+	for _, target := range []string{strconv.Itoa(ppid), "{running}"} {
+		d, err := a.memdumpTarget(target)
+		tt.CheckErr(err)
+		tt.Assert(d.PID == ppid)
+		tt.Assert(d.GUID == "{running}")
+		tt.Assert(d.Image == `C:\Windows\explorer.exe`)
+	}
+
+	// unknown processes
+	_, err := a.memdumpTarget("{unknown}")
+	tt.Assert(err != nil)
+	terminated := NewProcessTrack(`C:\evil.exe`, "{parent}", "{terminated}", 0x7ffffff0)
+	terminated.Terminated = true
+	a.tracker.Add(terminated)
+	_, err = a.memdumpTarget("{terminated}")
+	tt.Assert(err != nil)
+	_, err = a.memdumpTarget(strconv.Itoa(0x7ffffff0))
+	tt.Assert(err != nil)
+
+	// agent never dumps itself
+	_, err = a.memdumpTarget(strconv.Itoa(os.Getpid()))
+	tt.Assert(err != nil)
+
+	// dump must be found by dump uploader as GUID/HASH/FILE
+	d := &MemoryDump{PID: ppid, GUID: "{running}", Image: `C:\Windows\explorer.exe`}
+	path := filepath.Join(t.TempDir(), memdumpArtifact(d, "a7d0e5bb-1d2d-4a0b-8f5c-0e4b7e4f6a3c")+".gz")
+	sp := strings.Split(filepath.Dir(path), string(os.PathSeparator))
+	tt.Assert(sp[len(sp)-2] == "{running}")
+	tt.Assert(sp[len(sp)-1] == "a7d0e5bb1d2d4a0b8f5c0e4b7e4f6a3c")
+	tt.Assert(strings.HasPrefix(filepath.Base(path), "explorer.exe_"))
+	tt.Assert(uploadExts.Contains(filepath.Ext(path)))
+}
+
+func TestTelemetry(t *testing.T) {
+	tt := toast.FromT(t)
+
+	defer func(d, e func() error) { detectSysmon, enableFallbackAudit = d, e }(detectSysmon, enableFallbackAudit)
+
+	errAudit := errors.New("access denied")
+	tests := []struct {
+		sysmonErr error
+		fallback  bool
+		auditErr  error
+		missing   bool
+		enabled   bool
+		errors    int
+	}{
+		// sysmon installed
+		{nil, true, nil, false, false, 0},
+		// sysmon installed but its configuration cannot be read
+		{errors.New("bad config"), true, nil, false, false, 0},
+		// sysmon missing without fallback
+		{sysmon.ErrSysmonNotInstalled, false, nil, true, false, 1},
+		// sysmon missing with fallback
+		{sysmon.ErrSysmonNotInstalled, true, nil, true, true, 1},
+		// sysmon missing and fallback fails
+		{sysmon.ErrSysmonNotInstalled, true, errAudit, true, false, 2},
+	}
+
+	for i, test := range tests {
+		audited := false
+		detectSysmon = func() error { return test.sysmonErr }
+		enableFallbackAudit = func() error { audited = true; return test.auditErr }
+
+		a := &Agent{
+			config:    &config.Agent{},
+			logger:    golog.FromStdout(),
+			preHooks:  NewHookMan(),
+			postHooks: NewHookMan(),
+		}
+		a.errors.report = api.NewAgentErrorReport()
+		a.config.Sysmon.Fallback = test.fallback
+
+		a.detectTelemetry()
+		tt.Assert(a.telemetryMissing(TelemetrySysmon) == test.missing, i)
+		tt.Assert(a.fallbackEnabled(TelemetryProcessAudit) == test.enabled, i)
+		tt.Assert(audited == (test.missing && test.fallback), i)
+		tt.Assert(len(a.errors.report.Errors) == test.errors, i)
+
+		// process tracking falls back on audit events and Sysmon hooks are removed
+		a.initHooks(false)
+		hooks := make(map[string]bool)
+		for _, h := range a.preHooks.Hooks {
+			hooks[hookName(h)] = true
+		}
+		tt.Assert(hooks["hookTrackProcessAudit"] == test.missing, i)
+		for _, f := range a.preHooks.Filters {
+			tt.Assert(!test.missing || f.Channel != sysmonChannel, i)
+		}
+	}
+
+	// no telemetry detected yet
+	a := &Agent{}
+	tt.Assert(!a.telemetryMissing(TelemetrySysmon))
+	tt.Assert(!a.fallbackEnabled(TelemetryProcessAudit))
+}
@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/whids/utils"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	AutorunRunKey        = "run-key"
+	AutorunWinlogon      = "winlogon"
+	AutorunService       = "service"
+	AutorunScheduledTask = "scheduled-task"
+	AutorunWMI           = "wmi"
+	AutorunStartupFolder = "startup-folder"
+
+	pathServicesKey = `HKLM\SYSTEM\CurrentControlSet\Services`
+	pathWinlogonKey = `HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Winlogon`
+
+	// services start types (see winsvc.h)
+	serviceAutoStart = 2
+)
+
+var (
+	// machine wide Run keys
+	autorunMachineRunKeys = []string{
+		`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Run`,
+		`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\RunOnce`,
+		`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Policies\Explorer\Run`,
+		`HKLM\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Run`,
+		`HKLM\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\RunOnce`,
+	}
+
+	// Run keys relative to users' hives
+	autorunUserRunKeys = []string{
+		`Software\Microsoft\Windows\CurrentVersion\Run`,
+		`Software\Microsoft\Windows\CurrentVersion\RunOnce`,
+		`Software\Microsoft\Windows\CurrentVersion\Policies\Explorer\Run`,
+	}
+
+	// Winlogon values starting programs at logon
+	autorunWinlogonValues = []string{"Userinit", "Shell"}
+
+	autorunStartupFolders = []string{
+		`${ProgramData}\Microsoft\Windows\Start Menu\Programs\StartUp`,
+		`${SystemDrive}\Users\*\AppData\Roaming\Microsoft\Windows\Start Menu\Programs\Startup`,
+	}
+
+	// PowerShell script listing WMI event consumers
+	autorunWMIScript = `ConvertTo-Json -Compress -InputObject @(Get-CimInstance -Namespace root/subscription -ClassName __EventConsumer | ` +
+		`Select-Object @{n='Class';e={$_.CimClass.CimClassName}},Name,CommandLineTemplate,ExecutablePath,ScriptFileName,ScriptText)`
+)
+
+// Autorun is a program configured to start automatically
+type Autorun struct {
+	Category string `json:"category"`
+	// registry key, task path, WMI class or folder configuring it
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	// command line, script or file started
+	Value  string            `json:"value"`
+	Image  string            `json:"image,omitempty"`
+	Hashes map[string]string `json:"hashes,omitempty"`
+}
+
+// Autoruns holds the result of the enumeration of persistence locations
+type Autoruns struct {
+	Entries []*Autorun `json:"entries"`
+	// locations which failed to be enumerated
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (a *Autoruns) add(category, location, name, value string) {
+	a.Entries = append(a.Entries, &Autorun{Category: category, Location: location, Name: name, Value: value})
+}
+
+func (a *Autoruns) errorf(format string, args ...interface{}) {
+	a.Errors = append(a.Errors, fmt.Sprintf(format, args...))
+}
+
+// autorunImage returns the executable started by a command line as found
+// in persistence locations, where paths are often not quoted and may contain
+// environment variables or NT specific prefixes. exists is used to find
+// the executable among the prefixes of an unquoted command line.
+func autorunImage(cmdline string, exists func(string) bool) string {
+	var candidates []string
+
+	cmdline = strings.TrimSpace(cmdline)
+	if cmdline == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(cmdline, `"`) {
+		if i := strings.Index(cmdline[1:], `"`); i >= 0 {
+			candidates = []string{cmdline[1 : i+1]}
+		}
+	} else {
+		fields := strings.Fields(cmdline)
+		for i := range fields {
+			candidates = append(candidates, strings.Join(fields[:i+1], " "))
+		}
+	}
+
+	for _, c := range candidates {
+		c = normalizeAutorunPath(strings.TrimRight(c, ","))
+		for _, p := range []string{c, c + ".exe"} {
+			if exists(p) {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+// normalizeAutorunPath converts NT path prefixes and system relative
+// paths, as found in services image paths, into regular paths
+func normalizeAutorunPath(path string) string {
+	root := os.Getenv("SystemRoot")
+	lower := strings.ToLower(path)
+
+	switch {
+	case strings.HasPrefix(path, `\??\`):
+		path = path[4:]
+	case strings.HasPrefix(lower, `\systemroot\`):
+		path = filepath.Join(root, path[len(`\systemroot\`):])
+	case strings.HasPrefix(lower, `system32\`), strings.HasPrefix(lower, `syswow64\`):
+		path = filepath.Join(root, path)
+	}
+
+	if expanded, err := registry.ExpandString(path); err == nil {
+		path = expanded
+	}
+
+	return path
+}
+
+// taskActions returns the command lines of the Exec actions of a scheduled
+// task XML definition, as stored in System32\Tasks (UTF-16 encoded)
+func taskActions(data []byte) (actions []string, err error) {
+	var t *ScheduledTask
+
+	if t, err = parseTask(data); err != nil {
+		return
+	}
+
+	for _, a := range t.Actions {
+		if a.Type == TaskActionExec {
+			actions = append(actions, a.CommandLine())
+		}
+	}
+
+	return
+}
+
+func (a *Autoruns) enumRunKey(key string) {
+	values, err := advapi32.RegEnumValues(key)
+	if err != nil {
+		// most Run keys do not exist
+		return
+	}
+
+	for _, v := range values {
+		a.add(AutorunRunKey, key, v, utils.RegValueToString(key, v))
+	}
+}
+
+func (a *Autoruns) enumRunKeys() {
+	for _, key := range autorunMachineRunKeys {
+		a.enumRunKey(key)
+	}
+
+	sids, err := registry.USERS.ReadSubKeyNames(-1)
+	if err != nil {
+		a.errorf("failed to enumerate users' hives: %s", err)
+		return
+	}
+
+	for _, sid := range sids {
+		// classes hives have no Run keys
+		if strings.HasSuffix(sid, "_Classes") {
+			continue
+		}
+		for _, key := range autorunUserRunKeys {
+			a.enumRunKey(utils.RegJoin("HKU", sid, key))
+		}
+	}
+
+	for _, v := range autorunWinlogonValues {
+		if value := utils.RegValueToString(pathWinlogonKey, v); value != "" {
+			a.add(AutorunWinlogon, pathWinlogonKey, v, value)
+		}
+	}
+}
+
+func (a *Autoruns) enumServices() {
+	services, err := advapi32.RegEnumKeys(pathServicesKey)
+	if err != nil {
+		a.errorf("failed to enumerate services: %s", err)
+		return
+	}
+
+	for _, name := range services {
+		key := utils.RegJoin(pathServicesKey, name)
+
+		start, err := utils.RegValue(utils.RegJoin(key, "Start"))
+		if err != nil {
+			continue
+		}
+
+		// boot, system and automatic start services
+		if s, ok := start.(uint32); !ok || s > serviceAutoStart {
+			continue
+		}
+
+		if image := utils.RegValueToString(key, "ImagePath"); image != "" {
+			a.add(AutorunService, key, name, image)
+		}
+
+		// services hosted by svchost.exe
+		if dll := utils.RegValueToString(key, "Parameters", "ServiceDll"); dll != "" {
+			a.add(AutorunService, utils.RegJoin(key, "Parameters"), name, dll)
+		}
+	}
+}
+
+func (a *Autoruns) enumScheduledTasks() {
+	root := filepath.Join(os.Getenv("SystemRoot"), "System32", "Tasks")
+
+	for wi := range fswalker.Walk(root) {
+		if wi.Err != nil {
+			a.errorf("failed to walk %s: %s", wi.Dirpath, wi.Err)
+			continue
+		}
+
+		for _, fi := range wi.Files {
+			path := filepath.Join(wi.Dirpath, fi.Name())
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				a.errorf("failed to read task %s: %s", path, err)
+				continue
+			}
+
+			actions, err := taskActions(data)
+			if err != nil {
+				a.errorf("failed to parse task %s: %s", path, err)
+				continue
+			}
+
+			for _, action := range actions {
+				a.add(AutorunScheduledTask, strings.TrimPrefix(path, root), fi.Name(), action)
+			}
+		}
+	}
+}
+
+func (a *Autoruns) enumWMI() {
+	var consumers []struct {
+		Class               string
+		Name                string
+		CommandLineTemplate string
+		ExecutablePath      string
+		ScriptFileName      string
+		ScriptText          string
+	}
+
+	out, err := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", autorunWMIScript).Output()
+	if err != nil {
+		a.errorf("failed to list WMI event consumers: %s", err)
+		return
+	}
+
+	if err = json.Unmarshal(bytes.TrimSpace(out), &consumers); err != nil {
+		a.errorf("failed to parse WMI event consumers: %s", err)
+		return
+	}
+
+	for _, c := range consumers {
+		value := c.CommandLineTemplate
+		switch {
+		case value == "" && c.ExecutablePath != "":
+			value = c.ExecutablePath
+		case value == "" && c.ScriptFileName != "":
+			value = c.ScriptFileName
+		case value == "":
+			value = c.ScriptText
+		}
+		a.add(AutorunWMI, c.Class, c.Name, value)
+	}
+}
+
+func (a *Autoruns) enumStartupFolders() {
+	for _, loc := range autorunStartupFolders {
+		dirs, _ := filepath.Glob(os.ExpandEnv(loc))
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+
+			for _, de := range entries {
+				if de.IsDir() || strings.EqualFold(de.Name(), "desktop.ini") {
+					continue
+				}
+				a.add(AutorunStartupFolder, dir, de.Name(), filepath.Join(dir, de.Name()))
+			}
+		}
+	}
+}
+
+// cmdAutoruns enumerates common persistence locations, the executables
+// started are resolved and hashed whenever possible
+func (a *Agent) cmdAutoruns() *Autoruns {
+	ar := &Autoruns{Entries: make([]*Autorun, 0)}
+
+	ar.enumRunKeys()
+	ar.enumServices()
+	ar.enumScheduledTasks()
+	ar.enumWMI()
+	ar.enumStartupFolders()
+
+	for _, e := range ar.Entries {
+		if e.Image = autorunImage(e.Value, fsutil.IsFile); e.Image != "" {
+			e.Hashes, _ = a.hashCache.Hashes(e.Image)
+		}
+	}
+
+	sort.SliceStable(ar.Entries, func(i, j int) bool {
+		return ar.Entries[i].Category < ar.Entries[j].Category
+	})
+
+	return ar
+}
@@ -0,0 +1,241 @@
+package beacon
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+const (
+	// maximum number of samples kept per flow
+	maxSamples = 1024
+)
+
+// Beacon holds information about a beaconing flow
+type Beacon struct {
+	Image       string
+	Destination string
+	Count       int
+	Interval    time.Duration
+	Jitter      float64
+	// mean bytes sent per connection, negative if unknown
+	MeanBytes float64
+}
+
+type sample struct {
+	timestamp time.Time
+	bytes     int64
+}
+
+type flow struct {
+	connections []time.Time
+	sent        []sample
+	lastSeen    time.Time
+	alerted     time.Time
+}
+
+func trim[T any](s []T, keep func(T) bool) []T {
+	i := 0
+	for i < len(s) && !keep(s[i]) {
+		i++
+	}
+	if len(s)-i > maxSamples {
+		i = len(s) - maxSamples
+	}
+	return s[i:]
+}
+
+func (f *flow) trim(since time.Time) {
+	f.connections = trim(f.connections, func(t time.Time) bool { return !t.Before(since) })
+	f.sent = trim(f.sent, func(s sample) bool { return !s.timestamp.Before(since) })
+}
+
+// stats returns mean interval and jitter (coefficient of variation)
+// between consecutive connections
+func (f *flow) stats() (mean, jitter float64) {
+	var sum, sq float64
+
+	n := float64(len(f.connections) - 1)
+	for i := 1; i < len(f.connections); i++ {
+		sum += float64(f.connections[i].Sub(f.connections[i-1]))
+	}
+	mean = sum / n
+
+	for i := 1; i < len(f.connections); i++ {
+		d := float64(f.connections[i].Sub(f.connections[i-1])) - mean
+		sq += d * d
+	}
+
+	if mean > 0 {
+		jitter = math.Sqrt(sq/n) / mean
+	}
+
+	return
+}
+
+// meanBytes returns the mean number of bytes sent per connection
+func (f *flow) meanBytes() float64 {
+	var total int64
+
+	if len(f.sent) == 0 {
+		return -1
+	}
+
+	for _, s := range f.sent {
+		total += s.bytes
+	}
+
+	return float64(total) / float64(len(f.connections))
+}
+
+// Analyzer tracks outbound connections of processes to detect
+// highly periodic, low volume patterns to rare destinations
+type Analyzer struct {
+	sync.Mutex
+	config    *config.Beaconing
+	whitelist map[string]bool
+	flows     map[string]*flow
+	// images contacting destinations
+	prevalence map[string]map[string]time.Time
+}
+
+// NewAnalyzer creates a new Analyzer
+func NewAnalyzer(c *config.Beaconing) *Analyzer {
+	a := &Analyzer{
+		config:     c,
+		whitelist:  make(map[string]bool),
+		flows:      make(map[string]*flow),
+		prevalence: make(map[string]map[string]time.Time),
+	}
+
+	for _, image := range c.Whitelist {
+		a.whitelist[strings.ToLower(image)] = true
+	}
+
+	return a
+}
+
+func key(image, dst string) string {
+	return strings.ToLower(image) + "|" + dst
+}
+
+// Len returns the number of flows tracked
+func (a *Analyzer) Len() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.flows)
+}
+
+// Whitelisted returns true if image is allowed to beacon
+func (a *Analyzer) Whitelisted(image string) bool {
+	return a.whitelist[strings.ToLower(image)]
+}
+
+// AddBytes accounts bytes sent by image to a destination
+func (a *Analyzer) AddBytes(image, dst string, ts time.Time, n int64) {
+	a.Lock()
+	defer a.Unlock()
+
+	if f, ok := a.flows[key(image, dst)]; ok {
+		f.sent = append(f.sent, sample{ts, n})
+	}
+}
+
+func (a *Analyzer) prune(now time.Time) {
+	since := now.Add(-a.config.Window)
+
+	for k, f := range a.flows {
+		if f.lastSeen.Before(since) {
+			delete(a.flows, k)
+		}
+	}
+
+	for dst, images := range a.prevalence {
+		for image, last := range images {
+			if last.Before(since) {
+				delete(images, image)
+			}
+		}
+		if len(images) == 0 {
+			delete(a.prevalence, dst)
+		}
+	}
+}
+
+// Prune removes flows not seen within the analysis window
+func (a *Analyzer) Prune(now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.prune(now)
+}
+
+// Update tracks a new connection made by image to a destination and returns
+// a Beacon if the flow is considered as beaconing. A flow is reported only
+// once per analysis window.
+func (a *Analyzer) Update(image, dst string, ts time.Time) (b *Beacon, ok bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.Whitelisted(image) {
+		return
+	}
+
+	k := key(image, dst)
+	f, found := a.flows[k]
+	if !found {
+		if len(a.flows) >= a.config.MaxFlows {
+			a.prune(ts)
+		}
+		// we do not track new flows if we are still full
+		if len(a.flows) >= a.config.MaxFlows {
+			return
+		}
+		f = &flow{}
+		a.flows[k] = f
+	}
+
+	if _, found = a.prevalence[dst]; !found {
+		a.prevalence[dst] = make(map[string]time.Time)
+	}
+	a.prevalence[dst][strings.ToLower(image)] = ts
+
+	f.connections = append(f.connections, ts)
+	f.lastSeen = ts
+	f.trim(ts.Add(-a.config.Window))
+
+	if len(f.connections) < a.config.MinConnections {
+		return
+	}
+
+	if !f.alerted.IsZero() && ts.Sub(f.alerted) < a.config.Window {
+		return
+	}
+
+	mean, jitter := f.stats()
+	if mean < float64(a.config.MinInterval) || jitter > a.config.MaxJitter {
+		return
+	}
+
+	if len(a.prevalence[dst]) > a.config.MaxPrevalence {
+		return
+	}
+
+	meanBytes := f.meanBytes()
+	if a.config.MaxMeanBytes > 0 && meanBytes > float64(a.config.MaxMeanBytes) {
+		return
+	}
+
+	f.alerted = ts
+
+	return &Beacon{
+		Image:       image,
+		Destination: dst,
+		Count:       len(f.connections),
+		Interval:    time.Duration(mean),
+		Jitter:      jitter,
+		MeanBytes:   meanBytes,
+	}, true
+}
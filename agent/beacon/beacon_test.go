@@ -0,0 +1,108 @@
+package beacon
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+var (
+	beaconingConfig = config.Beaconing{
+		Enable:         true,
+		Window:         6 * time.Hour,
+		MinConnections: 12,
+		MinInterval:    10 * time.Second,
+		MaxJitter:      0.1,
+		MaxMeanBytes:   4096,
+		MaxPrevalence:  2,
+		MaxFlows:       1024,
+		Whitelist:      []string{`C:\Program Files (x86)\Google\Update\GoogleUpdate.exe`},
+	}
+
+	implant  = `C:\Users\Public\implant.exe`
+	c2       = "203.0.113.42:443"
+	interval = time.Minute
+)
+
+// jittered returns d with a random jitter of at most pct
+func jittered(d time.Duration, pct float64) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*pct*float64(d))
+}
+
+func TestBeaconing(t *testing.T) {
+	var b *Beacon
+	var ok bool
+
+	tt := toast.FromT(t)
+	a := NewAnalyzer(&beaconingConfig)
+
+	ts := time.Now()
+	for i := 0; i < beaconingConfig.MinConnections; i++ {
+		b, ok = a.Update(implant, c2, ts)
+		a.AddBytes(implant, c2, ts, 512)
+		ts = ts.Add(jittered(interval, 0.05))
+	}
+
+	tt.Assert(ok)
+	tt.Assert(b.Count == beaconingConfig.MinConnections)
+	tt.Assert(b.Jitter <= beaconingConfig.MaxJitter)
+	tt.Assert(b.Interval > 55*time.Second && b.Interval < 65*time.Second)
+	tt.Assert(b.MeanBytes > 0)
+	t.Logf("%+v", b)
+
+	// flow is reported once per window
+	_, ok = a.Update(implant, c2, ts)
+	tt.Assert(!ok)
+}
+
+func TestBeaconingNoAlert(t *testing.T) {
+	var ok bool
+
+	tt := toast.FromT(t)
+	a := NewAnalyzer(&beaconingConfig)
+
+	// whitelisted updater
+	ts := time.Now()
+	updater := beaconingConfig.Whitelist[0]
+	for i := 0; i < beaconingConfig.MinConnections*2; i++ {
+		_, ok = a.Update(updater, c2, ts)
+		tt.Assert(!ok)
+		ts = ts.Add(interval)
+	}
+
+	// too much jitter
+	ts = time.Now()
+	for i := 0; i < beaconingConfig.MinConnections*2; i++ {
+		_, ok = a.Update(implant, "198.51.100.1:443", ts)
+		tt.Assert(!ok)
+		ts = ts.Add(jittered(interval, 0.9))
+	}
+
+	// destination is not rare
+	ts = time.Now()
+	images := []string{`C:\a.exe`, `C:\b.exe`, `C:\c.exe`}
+	for i := 0; i < beaconingConfig.MinConnections; i++ {
+		for _, image := range images {
+			_, ok = a.Update(image, "198.51.100.2:443", ts)
+			tt.Assert(!ok)
+		}
+		ts = ts.Add(interval)
+	}
+
+	// high volume
+	ts = time.Now()
+	for i := 0; i < beaconingConfig.MinConnections; i++ {
+		a.Update(implant, "198.51.100.3:443", ts)
+		a.AddBytes(implant, "198.51.100.3:443", ts, 1<<20)
+		ts = ts.Add(interval)
+	}
+	_, ok = a.Update(implant, "198.51.100.3:443", ts)
+	tt.Assert(!ok)
+
+	// old flows are pruned
+	a.Prune(ts.Add(beaconingConfig.Window * 2))
+	tt.Assert(a.Len() == 0)
+}
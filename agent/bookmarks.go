@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-win32/win32/wevtapi"
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// format of the times used in event log XPath queries
+	xpathTimeFormat = "2006-01-02T15:04:05.0000000Z"
+)
+
+var (
+	// BookmarkSaveInterval interval at which bookmarks are saved
+	BookmarkSaveInterval = time.Minute
+)
+
+// ChannelBookmark is the time of the last event processed for a channel
+type ChannelBookmark struct {
+	sod.Item
+	Channel   string    `json:"channel" sod:"unique"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bookmarks keeps track of the last event processed per event log
+// channel, so that events generated while the agent was not running
+// can be recovered from the event log at startup
+type Bookmarks struct {
+	sync.Mutex
+	config *config.Bookmarks
+	last   map[string]time.Time
+}
+
+// NewBookmarks creates new Bookmarks from configuration
+func NewBookmarks(c *config.Bookmarks) *Bookmarks {
+	b := &Bookmarks{
+		config: c,
+		last:   make(map[string]time.Time),
+	}
+
+	for _, channel := range c.Channels {
+		b.last[channel] = time.Time{}
+	}
+
+	return b
+}
+
+// Update updates the bookmark of event's channel, if bookmarked
+func (b *Bookmarks) Update(e *event.EdrEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	channel := e.Channel()
+	if last, ok := b.last[channel]; ok {
+		if ts := e.Timestamp(); ts.After(last) {
+			b.last[channel] = ts
+		}
+	}
+}
+
+// Load loads bookmarks saved in database
+func (b *Bookmarks) Load(db *sod.DB) (err error) {
+	var objs []sod.Object
+
+	if objs, err = db.All(&ChannelBookmark{}); err != nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, o := range objs {
+		bm := o.(*ChannelBookmark)
+		if last, ok := b.last[bm.Channel]; ok && bm.Timestamp.After(last) {
+			b.last[bm.Channel] = bm.Timestamp
+		}
+	}
+
+	return
+}
+
+// Save saves bookmarks in database
+func (b *Bookmarks) Save(db *sod.DB) (err error) {
+	b.Lock()
+	bms := make([]*ChannelBookmark, 0, len(b.last))
+	for channel, ts := range b.last {
+		if !ts.IsZero() {
+			bms = append(bms, &ChannelBookmark{Channel: channel, Timestamp: ts})
+		}
+	}
+	b.Unlock()
+
+	if err = db.DeleteAll(&ChannelBookmark{}); err != nil {
+		return
+	}
+
+	_, err = db.InsertOrUpdateMany(sod.ToObjectSlice(bms)...)
+	return
+}
+
+// Gaps returns, for every bookmarked channel, the time since which events
+// must be recovered until now. Channels never bookmarked have no gap.
+func (b *Bookmarks) Gaps(now time.Time) (gaps map[string]time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	gaps = make(map[string]time.Time)
+	for channel, last := range b.last {
+		if last.IsZero() || !last.Before(now) {
+			continue
+		}
+
+		if b.config.MaxGap > 0 && now.Sub(last) > b.config.MaxGap {
+			last = now.Add(-b.config.MaxGap)
+		}
+
+		gaps[channel] = last
+	}
+
+	return
+}
+
+// gapQuery returns the XPath query selecting events generated within ]since; until]
+func gapQuery(since, until time.Time) string {
+	return fmt.Sprintf("*[System[TimeCreated[@SystemTime>'%s' and @SystemTime<='%s']]]",
+		since.UTC().Format(xpathTimeFormat),
+		until.UTC().Format(xpathTimeFormat))
+}
+
+// recoverChannel reads from channel's event log the events generated within
+// ]since; until] and sends them to c, it returns the number of events recovered
+func (a *Agent) recoverChannel(channel string, since, until time.Time, c chan *etw.Event) (n int, err error) {
+	var query wevtapi.EVT_HANDLE
+
+	if query, err = evtQuery(channel, gapQuery(since, until), evtQueryChannelPath); err != nil {
+		return 0, fmt.Errorf("failed to query channel %s: %w", channel, err)
+	}
+	defer wevtapi.EvtClose(query)
+
+	return a.readQuery(query, c)
+}
+
+// recoverEvents recovers, from the event logs, the events generated within
+// gaps (see Bookmarks.Gaps) ending at until. Returned channel is never closed
+// so that it can be merged with other event sources.
+func (a *Agent) recoverEvents(gaps map[string]time.Time, until time.Time) (c chan *etw.Event) {
+	c = make(chan *etw.Event)
+
+	go func() {
+		for channel, since := range gaps {
+			a.logger.Infof("Recovering events of %s since %s", channel, since.Format(time.RFC3339))
+			if n, err := a.recoverChannel(channel, since, until, c); err != nil {
+				a.logger.Errorf("Failed to recover events: %s", err)
+			} else {
+				a.logger.Infof("Recovered %d events of %s", n, channel)
+			}
+		}
+	}()
+
+	return
+}
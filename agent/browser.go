@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0xrawsec/whids/utils"
+	"github.com/0xrawsec/whids/utils/browser"
+)
+
+// BrowserProfileReport holds the artifacts collected from a browser profile
+type BrowserProfileReport struct {
+	*browser.Artifacts
+	// artifacts uploaded to the manager (i.e. raw history databases)
+	Collected []string `json:"collected,omitempty"`
+}
+
+// BrowserReport is the result of browser artifacts collection
+type BrowserReport struct {
+	// identifier of the collection, artifacts are grouped by collection
+	ID       string                  `json:"id"`
+	Profiles []*BrowserProfileReport `json:"profiles"`
+}
+
+// cmdBrowser collects history, downloads and extensions of Chromium based
+// browsers and Firefox for all the users of the endpoint. If retrieve is true,
+// raw browser databases and extensions' manifests are uploaded to the manager
+// as artifacts.
+func (a *Agent) cmdBrowser(retrieve bool) (r *BrowserReport) {
+	r = &BrowserReport{
+		ID:       fmt.Sprintf("{%s}", utils.UnsafeUUID()),
+		Profiles: make([]*BrowserProfileReport, 0),
+	}
+
+	users := filepath.Join(os.Getenv("SystemDrive")+string(os.PathSeparator), "Users")
+
+	for _, p := range browser.Profiles(users) {
+		pr := &BrowserProfileReport{Artifacts: browser.Collect(p)}
+
+		if retrieve {
+			for _, path := range p.Files() {
+				f, err := os.Open(path)
+				if err != nil {
+					// WAL files do not always exist
+					if !os.IsNotExist(err) {
+						pr.Errors = append(pr.Errors, err.Error())
+					}
+					continue
+				}
+
+				if artifact, _, err := a.collectArtifact(r.ID, path, f); err != nil {
+					pr.Errors = append(pr.Errors, fmt.Sprintf("failed to collect %s: %s", path, err))
+				} else {
+					pr.Collected = append(pr.Collected, artifact)
+				}
+				f.Close()
+			}
+		}
+
+		r.Profiles = append(r.Profiles, pr)
+	}
+
+	return
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+// channelProviders parses etw: channels into the providers
+// to enable in the real-time session
+func channelProviders(channels []string) (providers []etw.Provider, err error) {
+	var sprovs []string
+
+	c := config.Etw{Channels: channels}
+	if sprovs, err = c.RealTimeProviders(); err != nil {
+		return
+	}
+
+	providers = make([]etw.Provider, 0, len(sprovs))
+	for _, sprov := range sprovs {
+		var prov etw.Provider
+		if prov, err = etw.ParseProvider(sprov); err != nil {
+			return nil, fmt.Errorf("bad channel provider %s: %w", sprov, err)
+		}
+		providers = append(providers, prov)
+	}
+
+	return
+}
+
+// subscribedChannels returns the etw: channels currently subscribed to
+func (a *Agent) subscribedChannels() []string {
+	a.subscriptions.Lock()
+	defer a.subscriptions.Unlock()
+	return append([]string{}, a.subscriptions.channels...)
+}
+
+// setChannels changes the etw: channels subscribed to, subscriptions
+// lock must be held. Only the real-time subscription is rebuilt, the
+// rest of the pipeline keeps running.
+func (a *Agent) setChannels(channels []string) (err error) {
+	var providers []etw.Provider
+
+	p, ok := a.eventProvider.(*etwProvider)
+	if !ok {
+		return fmt.Errorf("event provider does not support channel subscription")
+	}
+
+	if providers, err = channelProviders(channels); err != nil {
+		return
+	}
+
+	for _, prov := range providers {
+		a.logger.Infof("Subscribing to ETW provider %s", prov.Name)
+	}
+
+	if err = p.UpdateProviders(config.EdrRealTimeTraceName, providers); err != nil {
+		return
+	}
+
+	a.subscriptions.channels = channels
+	return
+}
+
+// updateChannels changes the etw: channels subscribed to
+func (a *Agent) updateChannels(channels []string) (err error) {
+	a.subscriptions.Lock()
+	defer a.subscriptions.Unlock()
+	return a.setChannels(channels)
+}
+
+// channelsCommand lists, adds or removes etw: channels, changes only apply
+// to the running agent and are lost at restart or configuration update
+func (a *Agent) channelsCommand(args []string) (channels []string, err error) {
+	if len(args) == 0 {
+		return a.subscribedChannels(), nil
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing channel")
+	}
+
+	a.subscriptions.Lock()
+	defer a.subscriptions.Unlock()
+
+	channels = append([]string{}, a.subscriptions.channels...)
+	switch args[0] {
+	case "add":
+		for _, arg := range args[1:] {
+			// a provider is subscribed to only once
+			channels = append(removeChannel(channels, arg), arg)
+		}
+	case "remove":
+		for _, arg := range args[1:] {
+			channels = removeChannel(channels, arg)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %s, expecting add or remove", args[0])
+	}
+
+	if err = a.setChannels(channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// channelProviderName returns the name of the provider a channel subscribes to
+func channelProviderName(channel string) string {
+	name := strings.TrimPrefix(channel, config.EtwChannelPrefix)
+	if i := strings.Index(name, ":"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// removeChannel removes from channels the ones subscribing
+// to the same provider as channel
+func removeChannel(channels []string, channel string) (out []string) {
+	out = make([]string, 0, len(channels))
+	for _, c := range channels {
+		if !strings.EqualFold(channelProviderName(c), channelProviderName(channel)) {
+			out = append(out, c)
+		}
+	}
+	return
+}
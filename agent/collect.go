@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/whids/utils"
+)
+
+// artifactName returns a file name usable as artifact name
+func artifactName(path string) string {
+	r := strings.NewReplacer(`\`, "_", "/", "_", ":", "", " ", "_")
+	return fmt.Sprintf("%s.bin", r.Replace(path))
+}
+
+// collectArtifact copies the content of a file, found at path on the endpoint,
+// to the dump directory so that it is uploaded to the manager as any other
+// artifact. Group must be a GUID, artifacts are organized by group and file
+// path. The path of the artifact, relative to endpoint's artifacts
+// directory, is returned along with the sha256 of the file.
+func (a *Agent) collectArtifact(group, path string, src io.Reader) (artifact, sha string, err error) {
+	h := sha256.Sum256([]byte(strings.ToLower(path)))
+	rel := filepath.Join(group, hex.EncodeToString(h[:]), artifactName(path))
+	dst := filepath.Join(a.config.Dump.Dir, rel)
+
+	if err = utils.HidsMkdirAll(filepath.Dir(dst)); err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	if err = utils.HidsWriteReader(dst, io.TeeReader(src, hash), false); err != nil {
+		return
+	}
+
+	sha = hex.EncodeToString(hash.Sum(nil))
+	utils.HidsWriteData(fmt.Sprintf("%s.sha256", dst), []byte(sha))
+
+	// only compressed files are uploaded, so we compress it
+	// even if dump compression is disabled
+	if err = utils.GzipFileBestSpeed(dst); err != nil {
+		os.Remove(dst)
+		return
+	}
+
+	return rel + ".gz", sha, nil
+}
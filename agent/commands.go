@@ -1,13 +1,7 @@
 package agent
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -16,7 +10,6 @@ import (
 
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
 	"github.com/0xrawsec/whids/los"
-	"github.com/0xrawsec/whids/utils"
 )
 
 type FileInfo struct {
@@ -33,38 +26,11 @@ func (fi *FileInfo) Path() string {
 	return filepath.Join(fi.Dir, fi.Name)
 }
 
-func (fi *FileInfo) Hash() error {
-	var buffer [4 * utils.Mega]byte
-
-	file, err := os.Open(fi.Path())
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	fi.Hashes = make(map[string]string)
-
-	md5 := md5.New()
-	sha1 := sha1.New()
-	sha256 := sha256.New()
-	sha512 := sha512.New()
-
-	for read, err := file.Read(buffer[:]); err != io.EOF && read != 0; read, err = file.Read(buffer[:]) {
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return err
-		}
-		md5.Write(buffer[:read])
-		sha1.Write(buffer[:read])
-		sha256.Write(buffer[:read])
-		sha512.Write(buffer[:read])
-	}
-
-	fi.Hashes["md5"] = hex.EncodeToString(md5.Sum(nil))
-	fi.Hashes["sha1"] = hex.EncodeToString(sha1.Sum(nil))
-	fi.Hashes["sha256"] = hex.EncodeToString(sha256.Sum(nil))
-	fi.Hashes["sha512"] = hex.EncodeToString(sha512.Sum(nil))
-
-	return nil
+// Hash computes file hashes, they are taken from cache c if the
+// file did not change since it was last hashed (c may be nil)
+func (fi *FileInfo) Hash(c *HashCache) (err error) {
+	fi.Hashes, err = c.Hashes(fi.Path())
+	return
 }
 
 func (fi *FileInfo) FromFSFileInfo(fsfi fs.FileInfo) {
@@ -105,7 +71,7 @@ func (wi *WalkItem) FromWalkerWalkItem(o fswalker.WalkItem) {
 	}
 }
 
-func cmdHash(path string) (nfi FileInfo, err error) {
+func cmdHash(path string, c *HashCache) (nfi FileInfo, err error) {
 	var fi fs.FileInfo
 
 	if fi, err = os.Stat(path); err != nil {
@@ -119,7 +85,7 @@ func cmdHash(path string) (nfi FileInfo, err error) {
 
 	nfi.Dir = filepath.Dir(path)
 	nfi.FromFSFileInfo(fi)
-	err = nfi.Hash()
+	err = nfi.Hash(c)
 	return
 }
 
@@ -156,7 +122,7 @@ func cmdWalk(path string) []WalkItem {
 	return out
 }
 
-func cmdFind(path string, pattern string, hash bool) (out []FileInfo, err error) {
+func cmdFind(path string, pattern string, hash bool, c *HashCache) (out []FileInfo, err error) {
 	var pr *regexp.Regexp
 
 	out = make([]FileInfo, 0)
@@ -173,7 +139,7 @@ func cmdFind(path string, pattern string, hash bool) (out []FileInfo, err error)
 				nfi.FromFSFileInfo(fi)
 				// we need to hash file
 				if hash {
-					nfi.Err = nfi.Hash()
+					nfi.Err = nfi.Hash(c)
 				}
 				out = append(out, nfi)
 			}
@@ -2,12 +2,15 @@ package agent
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/agent/config"
 	"github.com/0xrawsec/whids/utils"
 )
 
@@ -34,7 +37,7 @@ func TestCmdHash(t *testing.T) {
 
 	tt := toast.FromT(t)
 
-	fi, err := cmdHash(filepath.Join(testDir, testFile))
+	fi, err := cmdHash(filepath.Join(testDir, testFile), nil)
 	tt.CheckErr(err)
 	tt.Assert(fi.Type == "file")
 	t.Log(utils.PrettyJsonOrPanic(fi))
@@ -58,7 +61,7 @@ func TestCmdFind(t *testing.T) {
 
 	tt := toast.FromT(t)
 	rex := regexp.QuoteMeta(format("%c%s", filepath.Separator, testFile))
-	fis, err := cmdFind(testDir, format(`%s$`, rex), true)
+	fis, err := cmdFind(testDir, format(`%s$`, rex), true, nil)
 	tt.CheckErr(err)
 	tt.Assert(len(fis) > 0)
 	for _, fi := range fis {
@@ -87,3 +90,144 @@ func TestCmdWalk(t *testing.T) {
 		tt.Assert(it.Err == "")
 	}
 }
+
+func TestVSSPaths(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	s := ShadowCopy{
+		ID:     "{8a4f9b4e-5b1c-4c84-9f5e-2c3b0a6d7e11}",
+		Device: `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1`,
+		Volume: `C:\`,
+	}
+
+	tt.Assert(s.Path(`C:\Windows\System32\config\SAM`) == `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Windows\System32\config\SAM`)
+	tt.Assert(artifactName(`C:\Windows\NTDS\ntds.dit`) == "C_Windows_NTDS_ntds.dit.bin")
+
+	paths := vssExpand(&s, []string{VSSTargetNTDS, `D:\some\file.txt`})
+	tt.Assert(len(paths) == 2)
+	tt.Assert(paths[0] == `C:\Windows\NTDS\ntds.dit`)
+	tt.Assert(paths[1] == `D:\some\file.txt`)
+}
+
+func TestAcquisitionArgs(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	args := acquisitionArgs([]string{"acquire", "--output={{output}}", AcquisitionOutput}, `C:\Dumps\memory.raw`)
+	tt.Assert(len(args) == 3)
+	tt.Assert(args[0] == "acquire")
+	tt.Assert(args[1] == `--output=C:\Dumps\memory.raw`)
+	tt.Assert(args[2] == `C:\Dumps\memory.raw`)
+}
+
+func TestPerfReport(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	start := perfSample{
+		time:     time.Now(),
+		events:   100,
+		counters: map[string][2]int64{SubsystemEngine: {10, int64(time.Second)}},
+	}
+	start.resources.UserTime = time.Second
+	start.resources.Handles = 100
+
+	end := start
+	end.time = start.time.Add(10 * time.Second)
+	end.events = 1100
+	end.counters = map[string][2]int64{SubsystemEngine: {1010, int64(2 * time.Second)}}
+	end.resources.UserTime = 3 * time.Second
+	end.resources.Handles = 90
+
+	r := perfReport(&start, &end)
+	tt.Assert(r.Events == 1000)
+	tt.Assert(r.EPS == 100)
+	tt.Assert(r.CPU.Percent == 20)
+	tt.Assert(r.Handles.Delta == -10)
+	tt.Assert(r.Subsystems[SubsystemEngine].Calls == 1000)
+	tt.Assert(r.Subsystems[SubsystemEngine].Average == time.Millisecond)
+	tt.Assert(r.Subsystems[SubsystemEngine].Load == 10)
+}
+
+func TestQuarantine(t *testing.T) {
+	tt := toast.FromT(t)
+
+	dir := t.TempDir()
+	defer func(d string) { quarantineDir = d }(quarantineDir)
+	quarantineDir = filepath.Join(dir, "Quarantine")
+
+	path := filepath.Join(dir, "payload.exe")
+	tt.CheckErr(os.WriteFile(path, []byte("MZ payload"), 0600))
+
+	e, err := quarantine(path)
+	tt.CheckErr(err)
+	tt.Assert(e.Path == path)
+	tt.Assert(e.Size == 10)
+	_, err = os.Stat(path)
+	tt.Assert(os.IsNotExist(err))
+
+	entries, err := quarantined()
+	tt.CheckErr(err)
+	tt.Assert(len(entries) == 1)
+	tt.Assert(entries[0].Sha256 == e.Sha256)
+
+	tt.CheckErr(unquarantine(path))
+	b, err := os.ReadFile(path)
+	tt.CheckErr(err)
+	tt.Assert(string(b) == "MZ payload")
+	tt.Assert(unquarantine(path) != nil)
+
+	// same content quarantined from several paths
+	other := filepath.Join(dir, "copy.exe")
+	tt.CheckErr(os.WriteFile(other, []byte("MZ payload"), 0600))
+	_, err = quarantine(path)
+	tt.CheckErr(err)
+	_, err = quarantine(other)
+	tt.CheckErr(err)
+	entries, err = quarantined()
+	tt.CheckErr(err)
+	tt.Assert(len(entries) == 2)
+	tt.CheckErr(unquarantine(path))
+	tt.CheckErr(unquarantine(other))
+	for _, p := range []string{path, other} {
+		b, err = os.ReadFile(p)
+		tt.CheckErr(err)
+		tt.Assert(string(b) == "MZ payload")
+	}
+
+	tt.Assert(validBlockAddr("198.51.100.7"))
+	tt.Assert(validBlockAddr("203.0.113.0/24"))
+	tt.Assert(!validBlockAddr("198.51.100.7; calc.exe"))
+}
+
+func TestCommandEdition(t *testing.T) {
+	tt := toast.FromT(t)
+
+	uploads := [][]string{
+		{"fetch-file", `C:\Windows\System32\cmd.exe`},
+		{"collect"},
+		{"vss-collect", "hives"},
+		{"reg", "export", `HKLM\SOFTWARE`},
+		{"sweep", "retrieve"},
+		{"browser", "retrieve"},
+		{"srum", "168h", "retrieve"},
+	}
+
+	for _, c := range uploads {
+		err := checkCommandCompiled(c[0], c[1:])
+		if config.FeatureDump.Compiled() {
+			tt.CheckErr(err)
+		} else {
+			tt.Assert(err != nil, c)
+		}
+	}
+
+	// commands not uploading anything are available in any edition
+	tt.CheckErr(checkCommandCompiled("reg", []string{"query", `HKLM\SOFTWARE`}))
+	tt.CheckErr(checkCommandCompiled("sweep", nil))
+	tt.CheckErr(checkCommandCompiled("srum", []string{"168h"}))
+}
@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+// Acquisition holds full physical memory acquisition configuration
+type Acquisition struct {
+	Enable     bool          `json:"enable,omitempty" toml:"enable" comment:"Enable full physical memory acquisition, only triggered by commands signed by the manager"`
+	Tool       string        `json:"tool,omitempty" toml:"tool" comment:"Path to the signed acquisition tool loading its driver (i.e. winpmem)"`
+	Args       []string      `json:"args,omitempty" toml:"args" comment:"Arguments of the acquisition tool, {{output}} is replaced by the path of the memory image"`
+	CommandKey string        `json:"command-key,omitempty" toml:"command-key" comment:"Manager's public key (see admin API containment key) used to verify acquisition commands"`
+	ChunkSize  int64         `json:"chunk-size,omitempty" toml:"chunk-size" comment:"Memory image is uploaded to the manager in chunks of this size\n (capped to forwarder's max upload size)"`
+	Timeout    time.Duration `json:"timeout,omitempty" toml:"timeout" comment:"Acquisition tool is killed if it runs longer than this"`
+}
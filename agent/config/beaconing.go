@@ -0,0 +1,40 @@
+package config
+
+import (
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+)
+
+const (
+	// BeaconingRuleName name of the rule generating beaconing alerts
+	BeaconingRuleName = "Builtin:Beaconing"
+)
+
+// Beaconing holds beaconing detection configuration
+type Beaconing struct {
+	Enable         bool          `json:"enable,omitempty" toml:"enable" comment:"Enable beaconing detection"`
+	Window         time.Duration `json:"window,omitempty" toml:"window" comment:"Time window over which outbound connections of a process are analyzed"`
+	MinConnections int           `json:"min-connections,omitempty" toml:"min-connections" comment:"Minimum number of connections to a destination within window before raising an alert"`
+	MinInterval    time.Duration `json:"min-interval,omitempty" toml:"min-interval" comment:"Connections more frequent than this interval are not considered as beaconing"`
+	MaxJitter      float64       `json:"max-jitter,omitempty" toml:"max-jitter" comment:"Maximum jitter (standard deviation of intervals / mean interval) tolerated"`
+	MaxMeanBytes   int64         `json:"max-mean-bytes,omitempty" toml:"max-mean-bytes" comment:"Maximum mean number of bytes sent per connection (0 means no limit)\n NB: only applies if Microsoft-Windows-Kernel-Network ETW provider is enabled"`
+	MaxPrevalence  int           `json:"max-prevalence,omitempty" toml:"max-prevalence" comment:"Maximum number of distinct processes contacting a destination\n for this destination to be considered as rare"`
+	MaxFlows       int           `json:"max-flows,omitempty" toml:"max-flows" comment:"Maximum number of (process, destination) flows tracked"`
+	Criticality    int           `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of beaconing alerts"`
+	Whitelist      []string      `json:"whitelist,omitempty" toml:"whitelist" comment:"Process images (i.e. updaters) allowed to beacon"`
+}
+
+// GenRule generates the rule raising beaconing alerts
+func (c *Beaconing) GenRule() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = BeaconingRuleName
+	// NetworkConnect
+	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {3}}
+	r.Meta.Criticality = c.Criticality
+	r.Matches = []string{
+		"$beacon: Beaconing = 'true'",
+	}
+	r.Condition = "$beacon"
+	return
+}
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// Bookmarks holds configuration of the bookmarks kept per event log
+// channel to recover events generated while the agent was not running
+type Bookmarks struct {
+	Enable   bool          `json:"enable,omitempty" toml:"enable" comment:"Keep track of the last event processed per channel and, at startup,\n read from the event log the events generated while agent was not running"`
+	Channels []string      `json:"channels,omitempty" toml:"channels" comment:"Event log channels bookmarked (events of channels not\n backed by an event log cannot be recovered)"`
+	MaxGap   time.Duration `json:"max-gap,omitempty" toml:"max-gap" comment:"Events older than this are not recovered (0 means no limit)"`
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	collectTargetRe = regexp.MustCompile(`^[a-z0-9\-_]+$`)
+)
+
+// CollectTarget is a set of artifacts collected together by collect command
+type CollectTarget struct {
+	Name  string   `json:"name" toml:"name" comment:"Name of the target, used as argument of collect command"`
+	Paths []string `json:"paths" toml:"paths" comment:"Paths of the artifacts, globs in which environment variables are expanded"`
+}
+
+// Collect holds configuration of triage artifact collection (collect command)
+type Collect struct {
+	MaxSize int64            `json:"max-size,omitempty" toml:"max-size" comment:"Maximum size of the files archived for a target, files above the limit are skipped\n (capped to forwarder's max upload size)"`
+	Targets []*CollectTarget `json:"targets,omitempty" toml:"targets" comment:"Artifact targets, files are read from a VSS snapshot of system drive\n and $MFT of a volume is read from the raw volume"`
+}
+
+// Target returns the target named name, nil if there is none
+func (c *Collect) Target(name string) *CollectTarget {
+	for _, t := range c.Targets {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Verify checks collect configuration is valid
+func (c *Collect) Verify() error {
+	names := make(map[string]bool)
+
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("max-size must be strictly positive")
+	}
+
+	for _, t := range c.Targets {
+		if !collectTargetRe.MatchString(t.Name) {
+			return fmt.Errorf("bad target name %q, must match %s", t.Name, collectTargetRe)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target %s", t.Name)
+		}
+		if len(t.Paths) == 0 {
+			return fmt.Errorf("target %s has no path", t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	return nil
+}
@@ -41,13 +41,17 @@ type Sysmon struct {
 	Bin              string `json:"bin,omitempty" toml:"bin" comment:"Path to Sysmon binary"`
 	ArchiveDirectory string `json:"archive-directory,omitempty" toml:"archive-directory" comment:"Path to Sysmon Archive directory"`
 	CleanArchived    bool   `json:"clean-archived,omitempty" toml:"clean-archived" comment:"Delete files older than 5min archived by Sysmon"`
+	Fallback         bool   `json:"fallback,omitempty" toml:"fallback" comment:"Enable process auditing (Security 4688/4689 with command line)\n when Sysmon is not installed"`
 }
 
 // Rules holds rules configuration
 type Rules struct {
-	RulesDB        string        `json:"rules-db,omitempty" toml:"rules-db" comment:"Path to Gene rules database"`
-	ContainersDB   string        `json:"containers-db,omitempty" toml:"containers-db" comment:"Path to Gene rules containers\n (c.f. Gene documentation)"`
-	UpdateInterval time.Duration `json:"update-interval,omitempty" toml:"update-interval" comment:"Update interval at which rules should be pulled from manager\n NB: only applies if a manager server is configured"`
+	RulesDB         string        `json:"rules-db,omitempty" toml:"rules-db" comment:"Path to Gene rules database"`
+	ContainersDB    string        `json:"containers-db,omitempty" toml:"containers-db" comment:"Path to Gene rules containers\n (c.f. Gene documentation)"`
+	SigmaDB         string        `json:"sigma-db,omitempty" toml:"sigma-db" comment:"Path to a directory of Sigma rules (YAML) converted into Gene rules when\n the engine is loaded, rules which cannot be converted are skipped (empty disables)"`
+	UpdateInterval  time.Duration `json:"update-interval,omitempty" toml:"update-interval" comment:"Update interval at which rules should be pulled from manager\n NB: only applies if a manager server is configured"`
+	MaxSuppressions int           `json:"max-suppressions,omitempty" toml:"max-suppressions" comment:"Maximum number of false positive suppressions pulled from manager\n applied locally (0 disables client-side suppression)"`
+	SuppressMaxCrit int           `json:"suppress-max-criticality,omitempty" toml:"suppress-max-criticality" comment:"Detections above this criticality are never suppressed"`
 }
 
 func (c *Rules) RulesPaths() (path, sha256Path string) {
@@ -69,33 +73,76 @@ type Audit struct {
 type Agent struct {
 	path string
 
-	DatabasePath    string           `json:"db-path,omitempty" toml:"db-path" comment:"Path to local database root directory"`
-	CritTresh       int              `json:"criticality-treshold,omitempty" toml:"criticality-treshold" comment:"Dumps/forward only events above criticality threshold\n or filtered events (i.e. Gene filtering rules)"`
-	EnableHooks     bool             `json:"en-hooks,omitempty" toml:"en-hooks" comment:"Enable enrichment hooks and dump hooks"`
-	EnableFiltering bool             `json:"en-filters,omitempty" toml:"en-filters" comment:"Enable event filtering (log filtered events, not only alerts)\n See documentation: https://github.com/0xrawsec/gene"`
-	Logfile         string           `json:"logfile,omitempty" toml:"logfile" comment:"Logfile used to log messages generated by the engine"` // for WHIDS log messages (not alerts)
-	LogAll          bool             `json:"log-all,omitempty" toml:"log-all" comment:"Log any incoming event passing through the engine"`    // log all events to logfile (used for debugging)
-	Endpoint        bool             `json:"endpoint,omitempty" toml:"endpoint" comment:"True if current host is the endpoint on which logs are generated\n Example: turn this off if running on a WEC"`
-	EtwConfig       Etw              `json:"etw,omitempty" toml:"etw" comment:"ETW configuration"`
-	FwdConfig       config.Forwarder `json:"forwarder,omitempty" toml:"forwarder" comment:"Forwarder configuration"`
-	Sysmon          Sysmon           `json:"sysmon,omitempty" toml:"sysmon" comment:"Sysmon related settings"`
-	Actions         Actions          `json:"actions,omitempty" toml:"actions" comment:"Default actions to apply to events, depending on their criticality"`
-	Dump            Dump             `json:"dump,omitempty" toml:"dump" comment:"Dump related settings"`
-	Report          Report           `json:"report,omitempty" toml:"reporting" comment:"Reporting related settings"`
-	RulesConfig     Rules            `json:"rules,omitempty" toml:"rules" comment:"Gene rules related settings\n Gene repo: https://github.com/0xrawsec/gene\n Gene rules repo: https://github.com/0xrawsec/gene-rules"`
-	AuditConfig     Audit            `json:"audit,omitempty" toml:"audit" comment:"Windows auditing configuration"`
-	CanariesConfig  Canaries         `json:"canaries,omitempty" toml:"canaries" comment:"Canary files configuration"`
+	Profile         string            `json:"profile,omitempty" toml:"profile" comment:"Built-in configuration preset (workstation, server or dc) setting channels,\n audit policies, thresholds and hooks, settings of this file take precedence"`
+	DatabasePath    string            `json:"db-path,omitempty" toml:"db-path" comment:"Path to local database root directory"`
+	CritTresh       int               `json:"criticality-treshold,omitempty" toml:"criticality-treshold" comment:"Dumps/forward only events above criticality threshold\n or filtered events (i.e. Gene filtering rules)"`
+	EnableHooks     bool              `json:"en-hooks,omitempty" toml:"en-hooks" comment:"Enable enrichment hooks and dump hooks"`
+	EnableFiltering bool              `json:"en-filters,omitempty" toml:"en-filters" comment:"Enable event filtering (log filtered events, not only alerts)\n See documentation: https://github.com/0xrawsec/gene"`
+	Logfile         string            `json:"logfile,omitempty" toml:"logfile" comment:"Logfile used to log messages generated by the engine"` // for WHIDS log messages (not alerts)
+	LogAll          bool              `json:"log-all,omitempty" toml:"log-all" comment:"Log any incoming event passing through the engine"`    // log all events to logfile (used for debugging)
+	Endpoint        bool              `json:"endpoint,omitempty" toml:"endpoint" comment:"True if current host is the endpoint on which logs are generated\n Example: turn this off if running on a WEC"`
+	EtwConfig       Etw               `json:"etw,omitempty" toml:"etw" comment:"ETW configuration"`
+	FwdConfig       config.Forwarder  `json:"forwarder,omitempty" toml:"forwarder" comment:"Forwarder configuration"`
+	Sysmon          Sysmon            `json:"sysmon,omitempty" toml:"sysmon" comment:"Sysmon related settings"`
+	Actions         Actions           `json:"actions,omitempty" toml:"actions" comment:"Default actions to apply to events, depending on their criticality"`
+	Dump            Dump              `json:"dump,omitempty" toml:"dump" comment:"Dump related settings"`
+	Report          Report            `json:"report,omitempty" toml:"reporting" comment:"Reporting related settings"`
+	RulesConfig     Rules             `json:"rules,omitempty" toml:"rules" comment:"Gene rules related settings\n Gene repo: https://github.com/0xrawsec/gene\n Gene rules repo: https://github.com/0xrawsec/gene-rules"`
+	AuditConfig     Audit             `json:"audit,omitempty" toml:"audit" comment:"Windows auditing configuration"`
+	CanariesConfig  Canaries          `json:"canaries,omitempty" toml:"canaries" comment:"Canary files configuration"`
+	BeaconingConfig Beaconing         `json:"beaconing,omitempty" toml:"beaconing" comment:"Beaconing detection configuration"`
+	SchemaConfig    Schema            `json:"schema,omitempty" toml:"schema" comment:"Event schema mapping configuration"`
+	PipelineConfig  Pipeline          `json:"pipeline,omitempty" toml:"pipeline" comment:"Event processing pipeline configuration"`
+	CritConfig      Criticality       `json:"criticality,omitempty" toml:"criticality" comment:"Alert criticality recalibration based on host tags"`
+	SinkConfig      Sink              `json:"sink,omitempty" toml:"sink" comment:"Event sink configuration (debugging)"`
+	ContainConfig   Containment       `json:"containment,omitempty" toml:"containment" comment:"Host containment configuration"`
+	AcqConfig       Acquisition       `json:"acquisition,omitempty" toml:"acquisition" comment:"Full physical memory acquisition configuration"`
+	SourcesConfig   CustomSources     `json:"custom-sources,omitempty" toml:"custom-sources" comment:"Local event ingestion API for third-party producers"`
+	WatchConfig     Watchlists        `json:"watchlists,omitempty" toml:"watchlists" comment:"User watchlists raising criticality or forcing forwarding\n of events involving specific accounts"`
+	MaintConfig     Maintenance       `json:"maintenance,omitempty" toml:"maintenance" comment:"Maintenance windows during which alerts are suppressed or their criticality lowered"`
+	RegistryConfig  Registry          `json:"registry,omitempty" toml:"registry" comment:"Registry values monitoring, values set can be dumped\n to catch fileless persistence"`
+	ResponderConfig Responder         `json:"responder,omitempty" toml:"responder" comment:"Destructive commands approval configuration"`
+	EventDBConfig   EventDB           `json:"event-db,omitempty" toml:"event-db" comment:"Local database of filtered events"`
+	CredConfig      CredentialMonitor `json:"credential-monitor,omitempty" toml:"credential-monitor" comment:"Credential providers and LSA packages tamper monitoring"`
+	BookmarkConfig  Bookmarks         `json:"bookmarks,omitempty" toml:"bookmarks" comment:"Recovery of the events generated while agent was not running"`
+	PrevConfig      Prevention        `json:"prevention,omitempty" toml:"prevention" comment:"Blocking of processes created by high-confidence rules"`
+	DllConfig       DllBlocklist      `json:"dll-blocklist,omitempty" toml:"dll-blocklist" comment:"Blocking of known malicious DLLs (credential theft, injection ...)"`
+	LateralConfig   LateralMovement   `json:"lateral-movement,omitempty" toml:"lateral-movement" comment:"Lateral movement (network logon, share access, remote execution) tracking"`
+	KerberosConfig  Kerberos          `json:"kerberos,omitempty" toml:"kerberos" comment:"Kerberos anomaly detection on domain controllers"`
+	IoCMemConfig    IoCMemory         `json:"ioc-memory,omitempty" toml:"ioc-memory" comment:"Memory of IoC hits already alerted"`
+	FltStatsConfig  FilterStats       `json:"filter-stats,omitempty" toml:"filter-stats" comment:"Aggregation of filtered events into periodic counts"`
+	HashCacheConfig HashCache         `json:"hash-cache,omitempty" toml:"hash-cache" comment:"Cache of file hashes shared by hooks and commands"`
+	DNSCacheConfig  DNSCache          `json:"dns-cache,omitempty" toml:"dns-cache" comment:"Cache of recently resolved domains shared by IoC and container matching"`
+	YaraConfig      Yara              `json:"yara,omitempty" toml:"yara" comment:"YARA scanning of files and process memory"`
+	PcapConfig      PacketCapture     `json:"packet-capture,omitempty" toml:"packet-capture" comment:"Packet captures of alerting processes' connections"`
+	FetchFileConfig FetchFile         `json:"fetch-file,omitempty" toml:"fetch-file" comment:"Retrieval of files from endpoint with fetch-file command"`
+	InventoryConfig Inventory         `json:"inventory,omitempty" toml:"inventory" comment:"Host firewall state and listening ports inventory"`
+	LogTamperConfig LogTamper         `json:"log-tamper,omitempty" toml:"log-tamper" comment:"Alerting on event log clearing and auditing configuration changes"`
+	PrivSepConfig   PrivSep           `json:"privilege-separation,omitempty" toml:"privilege-separation" comment:"Privilege separation of agent components"`
+	CollectConfig   Collect           `json:"collect,omitempty" toml:"collect" comment:"Triage artifact collection with collect command"`
 }
 
 // LoadAgentConfig loads a HIDS configuration from a file
 func LoadAgentConfig(path string) (c Agent, err error) {
-	fd, err := os.Open(path)
-	if err != nil {
+	var data []byte
+	var p struct {
+		Profile string `toml:"profile"`
+	}
+
+	if data, err = os.ReadFile(path); err != nil {
+		return
+	}
+
+	// profile preset is applied first so that settings of the file take precedence
+	if err = toml.Unmarshal(data, &p); err != nil {
 		return
 	}
-	defer fd.Close()
-	dec := toml.NewDecoder(fd)
-	err = dec.Decode(&c)
+
+	if err = c.ApplyProfile(p.Profile); err != nil {
+		return
+	}
+
+	err = toml.Unmarshal(data, &c)
 	c.path = path
 	return
 }
@@ -104,6 +151,25 @@ func (c *Agent) Sha256() (string, error) {
 	return utils.Sha256Interface(c)
 }
 
+// OnlyChannelsDiffer returns true if other only differs from c by its
+// etw: channels, which can be changed without restarting the agent
+func (c *Agent) OnlyChannelsDiffer(other *Agent) bool {
+	cp := *other
+	cp.EtwConfig.Channels = c.EtwConfig.Channels
+
+	sha, err := c.Sha256()
+	if err != nil {
+		return false
+	}
+
+	otherSha, err := cp.Sha256()
+	if err != nil {
+		return false
+	}
+
+	return sha == otherSha
+}
+
 // IsForwardingEnabled returns true if a forwarder is actually configured to forward logs
 func (c *Agent) IsForwardingEnabled() bool {
 	return !c.FwdConfig.Local && c.FwdConfig.Client.HasConnectionSettings()
@@ -123,6 +189,12 @@ func (c *Agent) Prepare() (err error) {
 		}
 	}
 
+	if c.RulesConfig.SigmaDB != "" && !fsutil.Exists(c.RulesConfig.SigmaDB) {
+		if err = os.MkdirAll(c.RulesConfig.SigmaDB, 0600); err != nil {
+			return
+		}
+	}
+
 	if !fsutil.Exists(c.Dump.Dir) {
 		if err = os.MkdirAll(c.Dump.Dir, 0600); err != nil {
 			return
@@ -135,6 +207,12 @@ func (c *Agent) Prepare() (err error) {
 		}
 	}
 
+	if c.EventDBConfig.Enable && !fsutil.Exists(c.EventDBConfig.Dir) {
+		if err = os.MkdirAll(c.EventDBConfig.Dir, 0600); err != nil {
+			return
+		}
+	}
+
 	if !fsutil.Exists(filepath.Dir(c.Logfile)) {
 		if err = os.MkdirAll(filepath.Dir(c.Logfile), 0600); err != nil {
 			return
@@ -146,12 +224,48 @@ func (c *Agent) Prepare() (err error) {
 
 // Verify validate HIDS configuration object
 func (c *Agent) Verify() error {
+	if _, ok := Profiles[c.Profile]; c.Profile != "" && !ok {
+		return fmt.Errorf("unknown profile %s", c.Profile)
+	}
+	if err := c.verifyEdition(); err != nil {
+		return fmt.Errorf("option not supported by agent: %w", err)
+	}
 	if !fsutil.IsDir(c.RulesConfig.RulesDB) {
 		return fmt.Errorf("rules database must be a directory")
 	}
 	if !fsutil.IsDir(c.RulesConfig.ContainersDB) {
 		return fmt.Errorf("containers database must be a directory")
 	}
+	if err := c.EtwConfig.Verify(); err != nil {
+		return fmt.Errorf("bad etw configuration: %w", err)
+	}
+	if err := c.MaintConfig.Verify(); err != nil {
+		return fmt.Errorf("bad maintenance configuration: %w", err)
+	}
+	if err := c.RegistryConfig.Verify(); err != nil {
+		return fmt.Errorf("bad registry configuration: %w", err)
+	}
+	if err := c.PrevConfig.Verify(); err != nil {
+		return fmt.Errorf("bad prevention configuration: %w", err)
+	}
+	if err := c.FltStatsConfig.Verify(); err != nil {
+		return fmt.Errorf("bad filter-stats configuration: %w", err)
+	}
+	if err := c.YaraConfig.Verify(); err != nil {
+		return fmt.Errorf("bad yara configuration: %w", err)
+	}
+	if err := c.PcapConfig.Verify(); err != nil {
+		return fmt.Errorf("bad packet-capture configuration: %w", err)
+	}
+	if err := c.FetchFileConfig.Verify(); err != nil {
+		return fmt.Errorf("bad fetch-file configuration: %w", err)
+	}
+	if err := c.PrivSepConfig.Verify(); err != nil {
+		return fmt.Errorf("bad privilege-separation configuration: %w", err)
+	}
+	if err := c.CollectConfig.Verify(); err != nil {
+		return fmt.Errorf("bad collect configuration: %w", err)
+	}
 	return nil
 }
 
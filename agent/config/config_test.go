@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -158,6 +159,23 @@ func TestConfig(t *testing.T) {
 	tt.Assert(len(cfg.EtwConfig.UnifiedTraces()) == goodTracesLen)
 }
 
+func TestOnlyChannelsDiffer(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	cfg := buildDefaultConfig(t.TempDir())
+	other := cfg
+
+	tt.Assert(cfg.OnlyChannelsDiffer(&other))
+
+	other.EtwConfig.Channels = []string{"etw:Microsoft-Windows-DNS-Client"}
+	tt.Assert(cfg.OnlyChannelsDiffer(&other))
+
+	other.EnableHooks = !cfg.EnableHooks
+	tt.Assert(!cfg.OnlyChannelsDiffer(&other))
+}
+
 func TestReporting(t *testing.T) {
 	t.Parallel()
 	tt := toast.FromT(t)
@@ -175,3 +193,280 @@ func TestReporting(t *testing.T) {
 		tt.Assert(cmd.Error == "", cmd.Error)
 	}
 }
+
+func TestCriticality(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Criticality{
+		Tags: []string{"domain-controller"},
+		Modifiers: []CriticalityModifier{
+			{Tag: "domain-controller", Modifier: 2},
+			{Tag: "test-vm", Modifier: -1},
+		},
+	}
+
+	tt.Assert(c.Modifier() == 2)
+	tt.Assert(c.Recalibrate(5) == 7)
+	tt.Assert(c.Recalibrate(9) == 10)
+
+	c.Tags = []string{"test-vm"}
+	tt.Assert(c.Recalibrate(5) == 4)
+	tt.Assert(c.Recalibrate(0) == 0)
+
+	// untagged host
+	c.Tags = nil
+	tt.Assert(c.Recalibrate(5) == 5)
+}
+
+func TestWatchlists(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Watchlists{
+		Enable: true,
+		Lists: []*Watchlist{
+			{Container: "vip", Modifier: 3, Forward: true},
+			{Container: "service-accounts", Modifier: -2},
+		},
+	}
+
+	tt.Assert(c.Recalibrate(5) == 5)
+	tt.Assert(c.Recalibrate(5, "vip") == 8)
+	tt.Assert(c.Recalibrate(9, "vip") == 10)
+	tt.Assert(c.Recalibrate(5, "vip", "service-accounts") == 6)
+	tt.Assert(c.Recalibrate(1, "service-accounts") == 0)
+	// unknown watchlist
+	tt.Assert(c.Recalibrate(5, "unknown") == 5)
+
+	tt.Assert(c.Forward("vip"))
+	tt.Assert(c.Forward("service-accounts", "vip"))
+	tt.Assert(!c.Forward("service-accounts"))
+	tt.Assert(!c.Forward())
+}
+
+func TestMaintenance(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// Tuesday
+	patchNight := time.Date(2022, 2, 1, 23, 0, 0, 0, time.Local)
+
+	c := Maintenance{
+		Enable: true,
+		Windows: []*MaintenanceWindow{
+			{
+				Name:     "patch-night",
+				Days:     []string{"tuesday"},
+				Start:    "22:00",
+				Duration: 4 * time.Hour,
+				RuleTags: []string{"installer"},
+				Suppress: true,
+			},
+			{
+				Name:     "backups",
+				Start:    "01:00",
+				Duration: time.Hour,
+				HostTags: []string{"file-server"},
+				Modifier: -3,
+			},
+		},
+	}
+
+
+	// window active
+	crit, suppressed := c.Recalibrate(patchNight, nil, []string{"installer"}, 8)
+	tt.Assert(suppressed && crit == 0)
+	// window spanning over midnight
+	_, suppressed = c.Recalibrate(patchNight.Add(2*time.Hour), nil, []string{"installer"}, 8)
+	tt.Assert(suppressed)
+	// window over
+	_, suppressed = c.Recalibrate(patchNight.Add(3*time.Hour), nil, []string{"installer"}, 8)
+	tt.Assert(!suppressed)
+	// wrong day
+	_, suppressed = c.Recalibrate(patchNight.AddDate(0, 0, 1), nil, []string{"installer"}, 8)
+	tt.Assert(!suppressed)
+	// rule not concerned
+	crit, suppressed = c.Recalibrate(patchNight, nil, []string{"lateral-movement"}, 8)
+	tt.Assert(!suppressed && crit == 8)
+
+	// criticality lowered on tagged hosts only
+	backups := patchNight.Add(2*time.Hour + 30*time.Minute)
+	crit, suppressed = c.Recalibrate(backups, []string{"file-server"}, nil, 5)
+	tt.Assert(!suppressed && crit == 2)
+	crit, _ = c.Recalibrate(backups, []string{"file-server"}, nil, 1)
+	tt.Assert(crit == 0)
+	crit, _ = c.Recalibrate(backups, []string{"laptop"}, nil, 5)
+	tt.Assert(crit == 5)
+
+	// disabled
+	c.Enable = false
+	_, suppressed = c.Recalibrate(patchNight, nil, []string{"installer"}, 8)
+	tt.Assert(!suppressed)
+
+	// bad windows
+	tt.Assert((&MaintenanceWindow{Start: "25:00", Duration: time.Hour}).Verify() != nil)
+	tt.Assert((&MaintenanceWindow{Start: "22:00", Days: []string{"someday"}, Duration: time.Hour}).Verify() != nil)
+	tt.Assert((&MaintenanceWindow{Start: "22:00"}).Verify() != nil)
+}
+
+func TestRegistry(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Registry{
+		DumpSize: 4096,
+		Patterns: []*RegistryPattern{
+			{
+				Key:   `(?i:\\CurrentVersion\\Run(Once)?\\)`,
+				Value: `[A-Za-z0-9+/]{64,}={0,2}`,
+			},
+		},
+	}
+
+	tt.Assert(c.Enabled())
+
+	key, value, err := c.Patterns[0].Compile()
+	tt.CheckErr(err)
+	tt.Assert(key.MatchString(`HKU\S-1-5-21\Software\Microsoft\Windows\CurrentVersion\Run\Updater`))
+	tt.Assert(!key.MatchString(`HKLM\System\CurrentControlSet\Services\Updater\ImagePath`))
+
+	// empty regexps match anything
+	key, value, err = (&RegistryPattern{}).Compile()
+	tt.CheckErr(err)
+	tt.Assert(key == nil && value == nil)
+
+	// bad patterns
+	c.Patterns = append(c.Patterns, &RegistryPattern{Value: `[A-Z`})
+	tt.Assert(c.Verify() != nil)
+
+	tt.Assert(!(&Registry{}).Enabled())
+}
+
+func TestEffective(t *testing.T) {
+	tt := toast.FromT(t)
+
+	t.Setenv("WHIDS_TEST_DIR", `C:\Test`)
+
+	cfg := buildDefaultConfig(t.TempDir())
+	cfg.FwdConfig.Client.Key = "endpoint-key"
+	cfg.FwdConfig.Client.ServerKey = "server-key"
+	cfg.SourcesConfig.Sources = []*CustomSource{{Name: "av", Key: "source-key"}}
+	cfg.AuditConfig.AuditDirs = []string{`$WHIDS_TEST_DIR\Audit`}
+	cfg.CanariesConfig.Canaries = []*Canary{{Directories: []string{`$WHIDS_TEST_DIR\Canary`}}}
+
+	sha256, err := cfg.Sha256()
+	tt.CheckErr(err)
+
+	m, err := cfg.Masked()
+	tt.CheckErr(err)
+	tt.Assert(m.FwdConfig.Client.Key == SecretMask)
+	tt.Assert(m.FwdConfig.Client.ServerKey == SecretMask)
+	tt.Assert(m.SourcesConfig.Sources[0].Key == SecretMask)
+	tt.Assert(m.AuditConfig.AuditDirs[0] == `$WHIDS_TEST_DIR\Audit`)
+
+	e, err := cfg.Effective()
+	tt.CheckErr(err)
+	tt.Assert(e.FwdConfig.Client.Key == SecretMask)
+	tt.Assert(e.AuditConfig.AuditDirs[0] == `C:\Test\Audit`)
+	tt.Assert(e.CanariesConfig.Canaries[0].Directories[0] == `C:\Test\Canary`)
+	tt.Assert(e.RulesConfig.RulesDB == cfg.RulesConfig.RulesDB)
+
+	// original configuration is left untouched
+	tt.Assert(cfg.FwdConfig.Client.Key == "endpoint-key")
+	tt.Assert(cfg.SourcesConfig.Sources[0].Key == "source-key")
+	tt.Assert(cfg.CanariesConfig.Canaries[0].Directories[0] == `$WHIDS_TEST_DIR\Canary`)
+	copySha256, err := cfg.Sha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == copySha256)
+}
+
+func TestProfile(t *testing.T) {
+	tt := toast.FromT(t)
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	tt.CheckErr(os.WriteFile(path, []byte(`
+profile = "dc"
+criticality-treshold = 3
+
+[audit]
+  audit-policies = ["Logon"]
+`), 0600))
+
+	c, err := LoadAgentConfig(path)
+	tt.CheckErr(err)
+
+	// settings coming from preset
+	tt.Assert(c.Profile == ProfileDC)
+	tt.Assert(c.EnableHooks)
+	tt.Assert(c.AuditConfig.Enable)
+	tt.Assert(c.KerberosConfig.Enable)
+	tt.Assert(c.LateralConfig.Enable)
+	tt.Assert(!c.CanariesConfig.Enable)
+	// settings of the file take precedence
+	tt.Assert(c.CritTresh == 3)
+	tt.Assert(len(c.AuditConfig.AuditPolicies) == 1 && c.AuditConfig.AuditPolicies[0] == "Logon")
+
+	tt.Assert(c.ApplyProfile("laptop") != nil)
+	tt.Assert(c.ApplyProfile("") == nil)
+
+	// configuration updates are fully serialized
+	update := func(profile string) (u *Agent, err error) {
+		b, err := json.Marshal(c)
+		tt.CheckErr(err)
+		u = &Agent{}
+		tt.CheckErr(json.Unmarshal(b, u))
+		u.Profile = profile
+		return u, u.SwitchProfile(&c)
+	}
+
+	// profile unchanged, settings are kept
+	u, err := update(ProfileDC)
+	tt.CheckErr(err)
+	tt.Assert(u.CritTresh == 3)
+	tt.Assert(u.KerberosConfig.Enable)
+
+	// switching profile applies the new preset
+	u, err = update(ProfileWorkstation)
+	tt.CheckErr(err)
+	tt.Assert(u.Profile == ProfileWorkstation)
+	tt.Assert(u.CritTresh == 5)
+	tt.Assert(len(u.AuditConfig.AuditPolicies) == 1 && u.AuditConfig.AuditPolicies[0] == "File System")
+	tt.Assert(!u.KerberosConfig.Enable)
+	tt.Assert(!u.LateralConfig.Enable)
+	tt.Assert(u.CanariesConfig.Enable == FeatureCanaries.Compiled())
+	tt.CheckErr(u.SwitchProfile(nil))
+
+	_, err = update("laptop")
+	tt.Assert(err != nil)
+}
+
+func TestEdition(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Agent{}
+	tt.CheckErr(c.verifyEdition())
+
+	c.Actions.Critical = []string{"report", "memdump", "kill"}
+	c.CanariesConfig.Enable = true
+	c.CanariesConfig.Actions = []string{"blacklist"}
+	c.PrevConfig.Enable = true
+
+	switch Edition {
+	case EditionFull:
+		tt.Assert(FeatureDump.Compiled() && FeatureCanaries.Compiled() && FeatureResponse.Compiled())
+		tt.CheckErr(c.verifyEdition())
+		tt.Assert(len(CompiledActions(c.Actions.Critical)) == 3)
+	case EditionLite:
+		tt.Assert(!FeatureDump.Compiled() && !FeatureCanaries.Compiled() && !FeatureResponse.Compiled())
+		tt.Assert(c.verifyEdition() != nil)
+		tt.Assert(FeatureResponse.Check() != nil)
+		tt.Assert(len(CompiledActions(c.Actions.Critical)) == 1)
+
+		// alert only DLL blocklist does not require response feature
+		c = Agent{}
+		c.DllConfig.Enable = true
+		tt.CheckErr(c.verifyEdition())
+		c.DllConfig.Terminate = true
+		tt.Assert(c.verifyEdition() != nil)
+	}
+
+	// unknown actions are not gated
+	tt.Assert(ActionCompiled("report"))
+}
@@ -0,0 +1,9 @@
+package config
+
+// Containment holds host containment configuration
+type Containment struct {
+	StatePath        string   `json:"state-path,omitempty" toml:"state-path" comment:"Path to the file where containment state is persisted"`
+	ReleaseKey       string   `json:"release-key,omitempty" toml:"release-key" comment:"Manager's containment public key (see admin API) used to verify\n emergency release tokens"`
+	UpdateHosts      []string `json:"update-hosts,omitempty" toml:"update-hosts" comment:"Windows Update servers reachable in soft containment (WSUS server configured\n by policy is always reachable)"`
+	SoftAllowedHosts []string `json:"soft-allowed-hosts,omitempty" toml:"soft-allowed-hosts" comment:"Additional hosts (names or IPs, i.e. AV update servers) reachable in soft containment"`
+}
@@ -0,0 +1,12 @@
+package config
+
+import "time"
+
+// CredentialMonitor holds configuration of the monitoring of credential
+// providers and LSA packages registrations
+type CredentialMonitor struct {
+	Enable      bool          `json:"enable,omitempty" toml:"enable" comment:"Enable monitoring of credential providers and LSA packages registrations"`
+	Interval    time.Duration `json:"interval,omitempty" toml:"interval" comment:"Interval at which registrations are compared to the baseline"`
+	Criticality int           `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of the alerts raised when a registration differs from the baseline"`
+	Keys        []string      `json:"keys,omitempty" toml:"keys" comment:"Additional registry keys to monitor (values and subkeys),\n i.e. HKLM\\SYSTEM\\CurrentControlSet\\Control\\SecurityProviders"`
+}
@@ -0,0 +1,50 @@
+package config
+
+const (
+	minCriticality = 0
+	maxCriticality = 10
+)
+
+// CriticalityModifier modifies the criticality of alerts raised on hosts
+// having a given tag
+type CriticalityModifier struct {
+	Tag      string `json:"tag,omitempty" toml:"tag" comment:"Host tag the modifier applies to"`
+	Modifier int    `json:"modifier,omitempty" toml:"modifier" comment:"Value added to the criticality of alerts (negative values decrease it)"`
+}
+
+// Criticality holds alert criticality recalibration configuration
+type Criticality struct {
+	Tags      []string              `json:"tags,omitempty" toml:"tags" comment:"Tags of the host (i.e. domain-controller, test-vm)"`
+	Modifiers []CriticalityModifier `json:"modifiers,omitempty" toml:"modifiers" comment:"Criticality modifiers applied to alerts depending on host tags\n before threshold checks, whatever the rule matching"`
+}
+
+// Modifier returns the sum of the modifiers applying to the host tags
+func (c *Criticality) Modifier() (m int) {
+	tags := make(map[string]bool, len(c.Tags))
+	for _, t := range c.Tags {
+		tags[t] = true
+	}
+
+	for _, cm := range c.Modifiers {
+		if tags[cm.Tag] {
+			m += cm.Modifier
+		}
+	}
+
+	return
+}
+
+// Recalibrate returns the criticality recalibrated according to
+// host tags, result is always in [0;10]
+func (c *Criticality) Recalibrate(crit int) int {
+	crit += c.Modifier()
+
+	switch {
+	case crit < minCriticality:
+		return minCriticality
+	case crit > maxCriticality:
+		return maxCriticality
+	}
+
+	return crit
+}
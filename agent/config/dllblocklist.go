@@ -0,0 +1,11 @@
+package config
+
+// DllBlocklist holds configuration of the enforcement of DLL
+// blocklists on image load events
+type DllBlocklist struct {
+	Enable      bool     `json:"enable,omitempty" toml:"enable" comment:"Enforce DLL blocklists on image load events"`
+	Containers  []string `json:"containers,omitempty" toml:"containers" comment:"Containers holding the hashes (MD5, SHA1, SHA256 or IMPHASH) of blocked DLLs, one per line"`
+	Terminate   bool     `json:"terminate,omitempty" toml:"terminate" comment:"Terminate processes loading a blocked DLL (critical system processes are never terminated)"`
+	Quarantine  bool     `json:"quarantine,omitempty" toml:"quarantine" comment:"Move blocked DLLs to quarantine (restored with revert command)"`
+	Criticality int      `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of the alerts raised when a blocked DLL is loaded"`
+}
@@ -0,0 +1,10 @@
+package config
+
+import "time"
+
+// DNSCache holds configuration of the cache of recently resolved domains
+type DNSCache struct {
+	Enable     bool          `json:"enable,omitempty" toml:"enable" comment:"Correlate network connections with the domains their destination was resolved from"`
+	TTL        time.Duration `json:"ttl,omitempty" toml:"ttl" comment:"Time during which a resolution is remembered"`
+	MaxEntries int           `json:"max-entries,omitempty" toml:"max-entries" comment:"Maximum number of IP addresses which resolutions are remembered"`
+}
@@ -0,0 +1,128 @@
+package config
+
+import "fmt"
+
+// Feature is a set of agent capabilities which can be left
+// out at compile time (see Edition) to produce minimal agents
+type Feature uint8
+
+const (
+	// FeatureDump file, memory and registry dumps, memory acquisition
+	FeatureDump Feature = 1 << iota
+	// FeatureCanaries canary files
+	FeatureCanaries
+	// FeatureResponse response actions (process termination,
+	// prevention, containment, blocking ...)
+	FeatureResponse
+)
+
+const (
+	// EditionFull agent with all features compiled in, the default
+	EditionFull = "full"
+	// EditionLite agent without dumps, canaries and response actions,
+	// built with lite tag, for servers under strict change control
+	EditionLite = "lite"
+)
+
+var (
+	// rule actions (see agent actions) relying on a feature
+	featureActions = map[string]Feature{
+		"memdump":         FeatureDump,
+		"filedump":        FeatureDump,
+		"regdump":         FeatureDump,
+		"pcap":            FeatureDump,
+		"kill":            FeatureResponse,
+		"kill-tree":       FeatureResponse,
+		"suspend":         FeatureResponse,
+		"blacklist":       FeatureResponse,
+		"isolate-process": FeatureResponse,
+	}
+)
+
+func (f Feature) String() string {
+	switch f {
+	case FeatureDump:
+		return "dump"
+	case FeatureCanaries:
+		return "canaries"
+	case FeatureResponse:
+		return "response"
+	}
+	return fmt.Sprintf("feature(%d)", uint8(f))
+}
+
+// Compiled returns true if feature is compiled in the agent, as it is
+// evaluated at compile time code depending on it is left out otherwise
+func (f Feature) Compiled() bool {
+	return compiledFeatures&f == f
+}
+
+// Check returns an error if feature is not compiled in the agent
+func (f Feature) Check() error {
+	if !f.Compiled() {
+		return fmt.Errorf("%s feature not compiled in %s agent", f, Edition)
+	}
+	return nil
+}
+
+// ActionCompiled returns true if the feature a rule action relies on is
+// compiled in the agent, unknown actions are considered as compiled
+func ActionCompiled(action string) bool {
+	if f, ok := featureActions[action]; ok {
+		return f.Compiled()
+	}
+	return true
+}
+
+// CompiledActions returns the actions compiled in the agent
+func CompiledActions(actions []string) (compiled []string) {
+	compiled = make([]string, 0, len(actions))
+	for _, a := range actions {
+		if ActionCompiled(a) {
+			compiled = append(compiled, a)
+		}
+	}
+	return
+}
+
+// verifyEdition checks configuration does not enable options
+// relying on features not compiled in the agent
+func (c *Agent) verifyEdition() error {
+	options := []struct {
+		name    string
+		feature Feature
+		enabled bool
+	}{
+		{"canaries", FeatureCanaries, c.CanariesConfig.Enable},
+		{"registry", FeatureDump, c.RegistryConfig.Enabled()},
+		{"acquisition", FeatureDump, c.AcqConfig.Enable},
+		{"packet-capture", FeatureDump, c.PcapConfig.Enable},
+		{"prevention", FeatureResponse, c.PrevConfig.Enable},
+		{"dll-blocklist", FeatureResponse, c.DllConfig.Enable && (c.DllConfig.Terminate || c.DllConfig.Quarantine)},
+	}
+
+	for _, o := range options {
+		if o.enabled {
+			if err := o.feature.Check(); err != nil {
+				return fmt.Errorf("%s: %w", o.name, err)
+			}
+		}
+	}
+
+	actions := [][]string{c.Actions.Low, c.Actions.Medium, c.Actions.High, c.Actions.Critical}
+	if c.CanariesConfig.Enable {
+		actions = append(actions, c.CanariesConfig.Actions)
+	}
+
+	for _, list := range actions {
+		for _, a := range list {
+			if f, ok := featureActions[a]; ok {
+				if err := f.Check(); err != nil {
+					return fmt.Errorf("%s action: %w", a, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build lite
+// +build lite
+
+package config
+
+const (
+	// Edition of the agent, selected at compile time with build tags
+	Edition = EditionLite
+
+	compiledFeatures Feature = 0
+)
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"github.com/0xrawsec/whids/utils"
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	// SecretMask replaces secrets in configuration shown to operators
+	SecretMask = "********"
+)
+
+func mask(s *string) {
+	if *s != "" {
+		*s = SecretMask
+	}
+}
+
+// Copy returns a deep copy of the configuration
+func (c *Agent) Copy() (new *Agent, err error) {
+	var b []byte
+
+	if b, err = utils.Toml(c); err != nil {
+		return
+	}
+
+	new = &Agent{path: c.path}
+	err = toml.Unmarshal(b, new)
+	return
+}
+
+// Masked returns a copy of the configuration with secrets (keys
+// agent and custom sources authenticate with) masked
+func (c *Agent) Masked() (m *Agent, err error) {
+	if m, err = c.Copy(); err != nil {
+		return
+	}
+
+	mask(&m.FwdConfig.Client.Key)
+	mask(&m.FwdConfig.Client.ServerKey)
+	mask(&m.FwdConfig.Splunk.Token)
+	mask(&m.FwdConfig.Elastic.Password)
+	mask(&m.FwdConfig.Elastic.APIKey)
+	for _, s := range m.SourcesConfig.Sources {
+		mask(&s.Key)
+	}
+
+	return
+}
+
+// Effective returns a masked copy of the configuration as the agent
+// uses it, environment variables are expanded in the settings the
+// agent expands them
+func (c *Agent) Effective() (e *Agent, err error) {
+	if e, err = c.Masked(); err != nil {
+		return
+	}
+
+	e.AuditConfig.AuditDirs = utils.ExpandEnvs(e.AuditConfig.AuditDirs...)
+	for _, canary := range e.CanariesConfig.Canaries {
+		canary.Directories = utils.ExpandEnvs(canary.Directories...)
+	}
+	for _, t := range e.CollectConfig.Targets {
+		t.Paths = utils.ExpandKnownEnvs(t.Paths...)
+	}
+
+	return
+}
@@ -1,5 +1,15 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// EtwChannelPrefix prefix of channels subscribing directly to an ETW provider
+	EtwChannelPrefix = "etw:"
+)
+
 type TraceFiles struct {
 	Read  bool `json:"en-read" toml:"" comment:"Enable file write tracing events"`
 	Write bool `json:"en-write" toml:"" comment:"Enable file read tracing events"`
@@ -10,8 +20,33 @@ type Etw struct {
 	//enTraceFile bool     `json:"trace-files" toml:"trace-files" comment:"Enable file read/write events via an optimized Microsoft-Windows-Kernel-File provider"`
 	Providers []string `json:"providers" toml:"providers" comment:"ETW providers to enable in the EDR autologger setting"`
 	Traces    []string `json:"traces" toml:"traces" comment:"Additional ETW traces to retrieve events"`
+	Channels  []string `json:"channels,omitempty" toml:"channels" comment:"Additional event sources subscribed to at runtime, without autologger (no reboot needed).\n Entries formatted as etw:<provider> subscribe to an ETW provider (name or GUID) through\n a dedicated real-time session, level and event ids can be given as for providers\n (i.e. etw:Microsoft-Windows-DNS-Client:0xff:3006,3008). Changes are applied without restarting the agent"`
 }
 
 func (e *Etw) FileTraceEnabled() bool {
 	return e.TraceFiles.Read || e.TraceFiles.Write
 }
+
+// RealTimeProviders returns the ETW providers to enable in the real-time
+// session, as parsed from etw: channels
+func (e *Etw) RealTimeProviders() (providers []string, err error) {
+	providers = make([]string, 0, len(e.Channels))
+	for _, c := range e.Channels {
+		if !strings.HasPrefix(c, EtwChannelPrefix) {
+			return nil, fmt.Errorf("unsupported channel %s, expecting %s<provider>", c, EtwChannelPrefix)
+		}
+
+		p := strings.TrimPrefix(c, EtwChannelPrefix)
+		if p == "" {
+			return nil, fmt.Errorf("channel %s: missing provider", c)
+		}
+		providers = append(providers, p)
+	}
+	return
+}
+
+// Verify checks the ETW configuration is valid
+func (e *Etw) Verify() (err error) {
+	_, err = e.RealTimeProviders()
+	return
+}
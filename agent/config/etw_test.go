@@ -59,3 +59,27 @@ func TestEtwTraceFiles(t *testing.T) {
 	c.TraceFiles.Read = true
 	tt.Assert(c.optimizedKernelFileProvider() == KernelFileProviderName+":0xff:12,14,15,16")
 }
+
+func TestEtwChannels(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Etw{
+		Channels: []string{
+			"etw:Microsoft-Windows-Kernel-Process",
+			"etw:{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}:0xff:3006,3008",
+		},
+	}
+
+	providers, err := c.RealTimeProviders()
+	tt.CheckErr(err)
+	tt.Assert(len(providers) == 2)
+	tt.Assert(providers[0] == "Microsoft-Windows-Kernel-Process")
+	tt.Assert(providers[1] == "{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}:0xff:3006,3008")
+	tt.CheckErr(c.Verify())
+
+	c.Channels = append(c.Channels, "Microsoft-Windows-Sysmon/Operational")
+	tt.Assert(c.Verify() != nil)
+
+	c.Channels = []string{"etw:"}
+	tt.Assert(c.Verify() != nil)
+}
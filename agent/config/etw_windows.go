@@ -21,6 +21,9 @@ const (
 	EdrTraceClockTime   = 2   // System Time -> only way to handle time sync properly
 	EdrTraceMaxFileSize = 500 // 500MB of ETW RT session backup file should be enough not to lose event
 
+	// EdrRealTimeTraceName name of the real-time session providers given as etw: channels are enabled in
+	EdrRealTimeTraceName = `EdrRealTime`
+
 	KernelFileProviderName = "Microsoft-Windows-Kernel-File"
 )
 
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// EventDB holds configuration of the local database of filtered events
+type EventDB struct {
+	Enable  bool          `json:"enable,omitempty" toml:"enable" comment:"Keep filtered events (see en-filters) in a local database queryable\n with query-events command"`
+	Dir     string        `json:"dir,omitempty" toml:"dir" comment:"Directory where filtered events are stored"`
+	MaxSize int64         `json:"max-size,omitempty" toml:"max-size" comment:"Maximum size (in bytes) of the database, oldest events are removed once reached"`
+	MaxAge  time.Duration `json:"max-age,omitempty" toml:"max-age" comment:"Events older than this are removed (0 means no age limit)"`
+}
@@ -0,0 +1,17 @@
+package config
+
+import "fmt"
+
+// FetchFile holds fetch-file command configuration
+type FetchFile struct {
+	MaxSize   int64 `json:"max-size,omitempty" toml:"max-size" comment:"Maximum size of a file retrieved with fetch-file command"`
+	ChunkSize int64 `json:"chunk-size,omitempty" toml:"chunk-size" comment:"Files are uploaded to the manager by chunks of this size"`
+}
+
+// Verify checks fetch-file configuration is valid
+func (f *FetchFile) Verify() error {
+	if f.ChunkSize <= 0 {
+		return fmt.Errorf("chunk-size must be strictly positive")
+	}
+	return nil
+}
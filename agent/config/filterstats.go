@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// FilterStats holds configuration of the aggregation of filtered events
+type FilterStats struct {
+	Enable     bool          `json:"enable,omitempty" toml:"enable" comment:"Forward periodic counts of filtered events (per rule, host, channel and event ID)\n instead of the events themselves (see en-filters)"`
+	Interval   time.Duration `json:"interval,omitempty" toml:"interval" comment:"Interval at which counts are forwarded"`
+	MaxRecords int           `json:"max-records,omitempty" toml:"max-records" comment:"Maximum number of counts kept per interval, events beyond are counted together"`
+}
+
+// Verify checks the filtered events aggregation configuration is valid
+func (f *FilterStats) Verify() error {
+	if f.Enable && f.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	return nil
+}
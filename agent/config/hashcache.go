@@ -0,0 +1,8 @@
+package config
+
+// HashCache holds configuration of the cache of file hashes shared
+// by hooks and commands
+type HashCache struct {
+	Enable     bool `json:"enable,omitempty" toml:"enable" comment:"Cache file hashes so that files not modified are not read again"`
+	MaxEntries int  `json:"max-entries,omitempty" toml:"max-entries" comment:"Maximum number of files which hashes are cached"`
+}
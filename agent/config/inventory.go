@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// Inventory holds configuration of the collection of host firewall
+// state and listening ports into endpoint's inventory
+type Inventory struct {
+	Enable      bool          `json:"enable,omitempty" toml:"enable" comment:"Enable collection of firewall profiles, allow rules and listening ports"`
+	Interval    time.Duration `json:"interval,omitempty" toml:"interval" comment:"Interval at which inventory is collected and compared to the previous one"`
+	Criticality int           `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of the alerts raised when a firewall profile gets disabled or\n an allow rule is added or modified (0 only generates change events)"`
+}
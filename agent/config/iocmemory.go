@@ -0,0 +1,10 @@
+package config
+
+import "time"
+
+// IoCMemory holds configuration of the memory of IoC hits already alerted
+type IoCMemory struct {
+	Enable     bool          `json:"enable,omitempty" toml:"enable" comment:"Do not alert again on objects (files, processes) already alerted for the same IoC"`
+	TTL        time.Duration `json:"ttl,omitempty" toml:"ttl" comment:"Time during which an IoC hit is remembered"`
+	MaxEntries int           `json:"max-entries,omitempty" toml:"max-entries" comment:"Maximum number of IoC hits remembered"`
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+)
+
+const (
+	// KerberosRC4RuleName name of the rule raising encryption downgrade alerts
+	KerberosRC4RuleName = "Builtin:KerberosRC4Downgrade"
+	// KerberoastingRuleName name of the rule raising kerberoasting alerts
+	KerberoastingRuleName = "Builtin:Kerberoasting"
+	// KerberosForgedRuleName name of the rule raising forged ticket alerts
+	KerberosForgedRuleName = "Builtin:KerberosForgedTicket"
+)
+
+// Kerberos holds configuration of the detection of Kerberos anomalies,
+// it only applies to agents running on domain controllers
+type Kerberos struct {
+	Enable bool `json:"enable,omitempty" toml:"enable" comment:"Enable Kerberos anomaly detection (on domain controllers only)\n NB: requires Kerberos Authentication Service and Kerberos Service Ticket Operations audit policies"`
+	// RC4 downgrade
+	RC4Downgrade   bool     `json:"rc4-downgrade,omitempty" toml:"rc4-downgrade" comment:"Detect service tickets encrypted with RC4"`
+	RC4AllowedSvcs []string `json:"rc4-allowed-services,omitempty" toml:"rc4-allowed-services" comment:"Legacy services allowed to use RC4 encrypted tickets"`
+	// kerberoasting
+	Kerberoasting bool          `json:"kerberoasting,omitempty" toml:"kerberoasting" comment:"Detect clients requesting tickets for many services"`
+	Window        time.Duration `json:"window,omitempty" toml:"window" comment:"Time window over which service ticket requests of a client are counted"`
+	MaxServices   int           `json:"max-services,omitempty" toml:"max-services" comment:"Maximum number of distinct services a client can request tickets for within window"`
+	MaxClients    int           `json:"max-clients,omitempty" toml:"max-clients" comment:"Maximum number of clients tracked"`
+	// forged tickets
+	ForgedTickets bool          `json:"forged-tickets,omitempty" toml:"forged-tickets" comment:"Detect service tickets requested with forged TGTs"`
+	Domains       []string      `json:"domains,omitempty" toml:"domains" comment:"Domain names (NetBIOS and DNS) expected in service ticket requests,\n tickets requested for other domains are considered forged (empty disables the check)"`
+	RequireTGT    bool          `json:"require-tgt,omitempty" toml:"require-tgt" comment:"Service tickets requested by clients whose TGT was not issued by this DC\n within ticket lifetime are considered forged (only for single DC domains)"`
+	TGTLifetime   time.Duration `json:"tgt-lifetime,omitempty" toml:"tgt-lifetime" comment:"Maximum lifetime of TGTs"`
+	Criticality   int           `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of Kerberos anomaly alerts"`
+}
+
+func (c *Kerberos) genRule(name, field string) (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = name
+	// A Kerberos service ticket was requested
+	r.Meta.Events = map[string][]int64{"Security": {4769}}
+	r.Meta.Criticality = c.Criticality
+	r.Matches = []string{
+		"$anomaly: " + field + " = 'true'",
+	}
+	r.Condition = "$anomaly"
+	return
+}
+
+// GenRules generates the rules raising Kerberos anomaly alerts
+func (c *Kerberos) GenRules() []engine.Rule {
+	return []engine.Rule{
+		c.genRule(KerberosRC4RuleName, "KerberosRC4Downgrade"),
+		c.genRule(KerberoastingRuleName, "Kerberoasting"),
+		c.genRule(KerberosForgedRuleName, "KerberosForgedTicket"),
+	}
+}
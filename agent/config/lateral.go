@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+// LateralMovement holds configuration of the correlation of network logons,
+// share accesses and remote service or scheduled task creations
+type LateralMovement struct {
+	Enable      bool          `json:"enable,omitempty" toml:"enable" comment:"Enable lateral movement tracking\n NB: requires Logon, File Share, Detailed File Share, Security System Extension\n and Other Object Access Events audit policies"`
+	Window      time.Duration `json:"window,omitempty" toml:"window" comment:"Maximum time between a network logon and the last step of a lateral movement"`
+	MaxSources  int           `json:"max-sources,omitempty" toml:"max-sources" comment:"Maximum number of source hosts tracked"`
+	Criticality int           `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of lateral movement alerts"`
+	Whitelist   []string      `json:"whitelist,omitempty" toml:"whitelist" comment:"Source addresses (i.e. management servers) never tracked"`
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"github.com/0xrawsec/gene/v2/engine"
+)
+
+const (
+	// LogTamperRuleName name of the rule raising alerts on event
+	// log clearing and auditing configuration changes
+	LogTamperRuleName = "Builtin:LogTamper"
+)
+
+var (
+	// LogTamperEvents events reporting event logs being cleared or auditing
+	// configuration being modified, by channel
+	LogTamperEvents = map[string][]int64{
+		// 1102: audit log cleared
+		// 4715: audit policy (SACL) on an object changed
+		// 4719: system audit policy changed
+		// 4817: auditing settings on object changed
+		// 4906: CrashOnAuditFail value changed
+		// 4912: per user audit policy changed
+		"Security": {1102, 4715, 4719, 4817, 4906, 4912},
+		// 104: event log cleared
+		"System": {104},
+	}
+)
+
+// LogTamper holds configuration of the detection of
+// event log clearing and auditing changes
+type LogTamper struct {
+	Enable      bool `json:"enable,omitempty" toml:"enable" comment:"Enable alerting on event log clearing and auditing configuration changes"`
+	Criticality int  `json:"criticality,omitempty" toml:"criticality" comment:"Criticality of log tampering alerts, alerts are raised to criticality threshold if needed"`
+	Triage      bool `json:"triage,omitempty" toml:"triage" comment:"Collect a forensic report (report action) when logs are tampered with"`
+}
+
+// GenRule generates the rule raising log tampering alerts
+func (c *LogTamper) GenRule() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = LogTamperRuleName
+	for channel, ids := range LogTamperEvents {
+		r.Meta.Events[channel] = append([]int64{}, ids...)
+	}
+	r.Meta.Criticality = c.Criticality
+	if c.Triage {
+		r.Actions = []string{"report"}
+	}
+	return
+}
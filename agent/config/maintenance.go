@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	maintenanceTimeLayout = "15:04"
+)
+
+// MaintenanceWindow is a recurring period (i.e. patch nights) during which
+// alerts of some rules are suppressed or get their criticality lowered
+type MaintenanceWindow struct {
+	Name     string        `json:"name,omitempty" toml:"name" comment:"Name of the maintenance window"`
+	Days     []string      `json:"days,omitempty" toml:"days" comment:"Days of the week the window starts on (i.e. Tuesday), every day if empty"`
+	Start    string        `json:"start,omitempty" toml:"start" comment:"Time of day (HH:MM, host local time) the window starts at"`
+	Duration time.Duration `json:"duration,omitempty" toml:"duration" comment:"Duration of the window, it may span over midnight"`
+	HostTags []string      `json:"host-tags,omitempty" toml:"host-tags" comment:"Window applies only to hosts having one of these tags (c.f. criticality tags),\n to any host if empty"`
+	RuleTags []string      `json:"rule-tags,omitempty" toml:"rule-tags" comment:"Window applies only to alerts of rules having one of these tags, to any alert if empty"`
+	Modifier int           `json:"modifier,omitempty" toml:"modifier" comment:"Value added to the criticality of alerts (negative values decrease it)"`
+	Suppress bool          `json:"suppress,omitempty" toml:"suppress" comment:"Suppress alerts instead of modifying their criticality"`
+}
+
+func intersect(a, b []string) bool {
+	for _, i := range a {
+		for _, j := range b {
+			if strings.EqualFold(i, j) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify checks the window is valid
+func (w *MaintenanceWindow) Verify() (err error) {
+	if _, err = time.Parse(maintenanceTimeLayout, w.Start); err != nil {
+		return fmt.Errorf("window %s: bad start time: %w", w.Name, err)
+	}
+
+	for _, d := range w.Days {
+		if _, ok := parseWeekday(d); !ok {
+			return fmt.Errorf("window %s: unknown day %s", w.Name, d)
+		}
+	}
+
+	if w.Duration <= 0 {
+		return fmt.Errorf("window %s: duration must be positive", w.Name)
+	}
+
+	return
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) || strings.EqualFold(d.String()[:3], s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// startsOn returns true if the window starts on day d
+func (w *MaintenanceWindow) startsOn(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, s := range w.Days {
+		if wd, ok := parseWeekday(s); ok && wd == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active returns true if t (converted to local time) is within the window
+func (w *MaintenanceWindow) Active(t time.Time) bool {
+	start, err := time.Parse(maintenanceTimeLayout, w.Start)
+	if err != nil {
+		return false
+	}
+
+	t = t.Local()
+	// a window may have started on previous days
+	for day := t; t.Sub(day) <= w.Duration+24*time.Hour; day = day.AddDate(0, 0, -1) {
+		begin := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.Local)
+		if w.startsOn(begin.Weekday()) && !t.Before(begin) && t.Before(begin.Add(w.Duration)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AppliesToHost returns true if the window applies to a host having tags
+func (w *MaintenanceWindow) AppliesToHost(tags []string) bool {
+	return len(w.HostTags) == 0 || intersect(w.HostTags, tags)
+}
+
+// AppliesToRule returns true if the window applies to a rule having tags
+func (w *MaintenanceWindow) AppliesToRule(tags []string) bool {
+	return len(w.RuleTags) == 0 || intersect(w.RuleTags, tags)
+}
+
+// Maintenance holds maintenance windows configuration
+type Maintenance struct {
+	Enable  bool                 `json:"enable,omitempty" toml:"enable" comment:"Enable maintenance windows"`
+	Windows []*MaintenanceWindow `json:"windows,omitempty" toml:"windows" comment:"Maintenance windows"`
+}
+
+// Verify checks all the windows are valid
+func (c *Maintenance) Verify() error {
+	for _, w := range c.Windows {
+		if err := w.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Active returns the windows active at time t on a host having tags
+func (c *Maintenance) Active(t time.Time, hostTags []string) (windows []*MaintenanceWindow) {
+	if !c.Enable {
+		return
+	}
+
+	for _, w := range c.Windows {
+		if w.AppliesToHost(hostTags) && w.Active(t) {
+			windows = append(windows, w)
+		}
+	}
+
+	return
+}
+
+// Recalibrate returns the criticality of an alert raised at time t, on a host
+// having hostTags, by rules having ruleTags, recalibrated according to active
+// windows. Result is always in [0;10], suppressed is true if one of the
+// windows suppresses the alert.
+func (c *Maintenance) Recalibrate(t time.Time, hostTags, ruleTags []string, crit int) (out int, suppressed bool) {
+	out = crit
+
+	for _, w := range c.Active(t, hostTags) {
+		if !w.AppliesToRule(ruleTags) {
+			continue
+		}
+
+		if w.Suppress {
+			return 0, true
+		}
+
+		out += w.Modifier
+	}
+
+	switch {
+	case out < minCriticality:
+		out = minCriticality
+	case out > maxCriticality:
+		out = maxCriticality
+	}
+
+	return
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PacketCapture holds configuration of the packet captures taken by pcap action
+type PacketCapture struct {
+	Enable   bool          `json:"enable,omitempty" toml:"enable" comment:"Enable packet captures of alerting processes' connections (pcap action)"`
+	Tool     string        `json:"tool,omitempty" toml:"tool" comment:"Path to a capture tool relying on a WinPcap compatible driver (i.e. dumpcap.exe),\n built-in ETW packet capture (pktmon) is used if empty"`
+	Args     []string      `json:"args,omitempty" toml:"args" comment:"Arguments of the capture tool, {{output}}, {{filter}} (BPF filter), {{duration}}\n (in seconds) and {{snaplen}} are replaced by capture parameters"`
+	Duration time.Duration `json:"duration,omitempty" toml:"duration" comment:"Duration of a capture"`
+	SnapLen  int           `json:"snaplen,omitempty" toml:"snaplen" comment:"Maximum number of bytes captured per packet"`
+	MaxSize  int64         `json:"max-size,omitempty" toml:"max-size" comment:"Captures are truncated to this size before being uploaded"`
+	MaxHosts int           `json:"max-hosts,omitempty" toml:"max-hosts" comment:"Maximum number of remote hosts, the process most recently connected to, captured"`
+}
+
+// Verify checks packet capture configuration is valid
+func (p *PacketCapture) Verify() error {
+	if !p.Enable {
+		return nil
+	}
+
+	switch {
+	case p.Duration <= 0:
+		return fmt.Errorf("duration must be strictly positive")
+	case p.SnapLen <= 0:
+		return fmt.Errorf("snaplen must be strictly positive")
+	case p.MaxSize <= 0:
+		return fmt.Errorf("max-size must be strictly positive")
+	case p.MaxHosts <= 0:
+		return fmt.Errorf("max-hosts must be strictly positive")
+	case p.Tool != "" && !strings.Contains(strings.Join(p.Args, " "), "{{output}}"):
+		return fmt.Errorf("capture tool arguments must contain {{output}}")
+	}
+
+	return nil
+}
@@ -0,0 +1,7 @@
+package config
+
+// Pipeline holds event processing pipeline configuration
+type Pipeline struct {
+	Workers        int  `json:"workers,omitempty" toml:"workers" comment:"Number of workers processing events concurrently (0 or 1 processes events sequentially)"`
+	OrderByProcess bool `json:"order-by-process,omitempty" toml:"order-by-process" comment:"Shard events by process GUID across workers so that events of a given process\n are always processed in order (by the same worker). Hooks tracking process\n activity (i.e. process tracker, termination) rely on this ordering"`
+}
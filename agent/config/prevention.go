@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Prevention holds configuration of the blocking of processes whose
+// creation matches rules tagged for prevention
+type Prevention struct {
+	Enable        bool     `json:"enable,omitempty" toml:"enable" comment:"Terminate processes as soon as their creation matches a rule tagged block"`
+	ReportOnly    bool     `json:"report-only,omitempty" toml:"report-only" comment:"Only flag processes which would have been blocked (rollout mode)"`
+	SuspendParent bool     `json:"suspend-parent,omitempty" toml:"suspend-parent" comment:"Also suspend the parent of blocked processes"`
+	AllowList     []string `json:"allow-list,omitempty" toml:"allow-list" comment:"Regular expressions matched against the image of processes (and parents)\n which are never blocked (nor suspended). Critical system processes are always allowed."`
+}
+
+// Verify checks the prevention configuration is valid
+func (p *Prevention) Verify() (err error) {
+	for _, s := range p.AllowList {
+		if _, err = regexp.Compile(s); err != nil {
+			return fmt.Errorf("bad allow-list regexp %s: %w", s, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PipePrefix prefix of local named pipes paths
+	PipePrefix = `\\.\pipe\`
+)
+
+var (
+	sidRe = regexp.MustCompile(`^S-1-\d+(-\d+)+$`)
+)
+
+// PrivSep holds configuration of the split of the agent into a
+// privileged collector and a lower-privileged processing component
+type PrivSep struct {
+	Enable  bool   `json:"enable,omitempty" toml:"enable" comment:"Split agent into a privileged collector (ETW, audit policies) and a lower-privileged\n processing component (rules, hooks, forwarding) run with -processor flag"`
+	Pipe    string `json:"pipe,omitempty" toml:"pipe" comment:"Local named pipe events are sent over from the collector to the processing component"`
+	Account string `json:"account,omitempty" toml:"account" comment:"SID of the account the processing component runs as (default is Local Service),\n only this account can connect to the pipe"`
+}
+
+// Verify checks privilege separation configuration is valid
+func (c *PrivSep) Verify() error {
+	if !c.Enable {
+		return nil
+	}
+	if !strings.HasPrefix(c.Pipe, PipePrefix) || len(c.Pipe) == len(PipePrefix) {
+		return fmt.Errorf("pipe must be a local named pipe (%s...)", PipePrefix)
+	}
+	if !sidRe.MatchString(c.Account) {
+		return fmt.Errorf("account must be a SID: %s", c.Account)
+	}
+	return nil
+}
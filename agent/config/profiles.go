@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// ProfileWorkstation preset for user workstations
+	ProfileWorkstation = "workstation"
+	// ProfileServer preset for member servers
+	ProfileServer = "server"
+	// ProfileDC preset for domain controllers
+	ProfileDC = "dc"
+)
+
+var (
+	presetProviders = []string{
+		"Microsoft-Windows-Sysmon",
+		"Microsoft-Windows-Windows Defender",
+		"Microsoft-Windows-PowerShell",
+		"Microsoft-Antimalware-Scan-Interface",
+	}
+
+	// audit policies needed to track lateral movements
+	presetServerPolicies = []string{
+		"File System",
+		"Logon",
+		"File Share",
+		"Detailed File Share",
+		"Security System Extension",
+		"Other Object Access Events",
+	}
+
+	// audit policies needed by Kerberos anomaly detection
+	presetDCPolicies = append(append([]string{}, presetServerPolicies...),
+		"Credential Validation",
+		"Kerberos Authentication Service",
+		"Kerberos Service Ticket Operations",
+	)
+
+	// Profiles built-in configuration presets per machine role, they
+	// set channels, audit policies, thresholds and hooks enabled
+	Profiles = map[string]func(*Agent){
+		ProfileWorkstation: presetWorkstation,
+		ProfileServer:      presetServer,
+		ProfileDC:          presetDC,
+	}
+)
+
+// presetCommon sets the settings common to all presets, features enabled
+// by a single preset are disabled so that switching profile disables them
+func presetCommon(c *Agent) {
+	c.EnableHooks = true
+	c.EtwConfig.Providers = append([]string{}, presetProviders...)
+	c.EtwConfig.Traces = []string{"Eventlog-Security"}
+	c.AuditConfig.Enable = true
+	c.BeaconingConfig.Enable = true
+	c.CredConfig.Enable = true
+	c.CanariesConfig.Enable = false
+	c.LateralConfig.Enable = false
+	c.KerberosConfig.Enable = false
+}
+
+func presetWorkstation(c *Agent) {
+	presetCommon(c)
+	c.CritTresh = 5
+	c.AuditConfig.AuditPolicies = []string{"File System"}
+	// presets must remain valid for lite agents
+	c.CanariesConfig.Enable = FeatureCanaries.Compiled()
+}
+
+func presetServer(c *Agent) {
+	presetCommon(c)
+	// servers run many administrative tools
+	c.CritTresh = 6
+	c.AuditConfig.AuditPolicies = append([]string{}, presetServerPolicies...)
+	c.LateralConfig.Enable = true
+}
+
+func presetDC(c *Agent) {
+	presetServer(c)
+	c.AuditConfig.AuditPolicies = append([]string{}, presetDCPolicies...)
+	c.KerberosConfig.Enable = true
+}
+
+// ProfileNames returns the sorted names of the available profiles
+func ProfileNames() (names []string) {
+	names = make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// ApplyProfile applies the preset of a profile to the configuration,
+// an empty profile leaves configuration untouched
+func (c *Agent) ApplyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	preset, ok := Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expecting one of %v", name, ProfileNames())
+	}
+
+	preset(c)
+	c.Profile = name
+
+	return nil
+}
+
+// SwitchProfile applies the preset of the profile of c if it differs from the
+// profile of prev. Configurations exchanged with the manager are serialized
+// with every setting of the preset, so without it a new profile would have no
+// effect. Settings of the new preset overwrite the ones of c.
+func (c *Agent) SwitchProfile(prev *Agent) error {
+	if prev == nil || c.Profile == prev.Profile {
+		return nil
+	}
+	return c.ApplyProfile(c.Profile)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RegistryPattern selects registry values to dump based on
+// the registry path they are set at and on their content
+type RegistryPattern struct {
+	Key   string `json:"key,omitempty" toml:"key" comment:"Regexp the registry path (i.e. Sysmon TargetObject) must match, any path if empty"`
+	Value string `json:"value,omitempty" toml:"value" comment:"Regexp the value data (string representation) must match, any data if empty"`
+}
+
+// Compile compiles the pattern into key and value regexps,
+// nil regexps match anything
+func (p *RegistryPattern) Compile() (key, value *regexp.Regexp, err error) {
+	if p.Key != "" {
+		if key, err = regexp.Compile(p.Key); err != nil {
+			return nil, nil, fmt.Errorf("bad key regexp: %w", err)
+		}
+	}
+
+	if p.Value != "" {
+		if value, err = regexp.Compile(p.Value); err != nil {
+			return nil, nil, fmt.Errorf("bad value regexp: %w", err)
+		}
+	}
+
+	return
+}
+
+// Registry holds registry value monitoring configuration
+type Registry struct {
+	DumpSize    int                `json:"dump-size,omitempty" toml:"dump-size" comment:"Registry values bigger than this size (in bytes) are dumped (0 disables)"`
+	MaxDumpSize int                `json:"max-dump-size,omitempty" toml:"max-dump-size" comment:"Maximum size (in bytes) of a registry value dump, bigger values are truncated"`
+	RateLimit   time.Duration      `json:"rate-limit,omitempty" toml:"rate-limit" comment:"Minimum time between two dumps of values set under the same registry key"`
+	MaxKeys     int                `json:"max-keys,omitempty" toml:"max-keys" comment:"Maximum number of registry keys tracked for rate limiting,\n no dump happens for new keys once reached"`
+	Patterns    []*RegistryPattern `json:"patterns,omitempty" toml:"patterns" comment:"Registry values matching one of these patterns are dumped\n (i.e. base64 blobs set in Run keys)"`
+}
+
+// Enabled returns true if registry values may be dumped
+func (c *Registry) Enabled() bool {
+	return c.DumpSize > 0 || len(c.Patterns) > 0
+}
+
+// Verify checks all the patterns are valid
+func (c *Registry) Verify() error {
+	for i, p := range c.Patterns {
+		if _, _, err := p.Compile(); err != nil {
+			return fmt.Errorf("pattern %d: %w", i, err)
+		}
+	}
+	return nil
+}
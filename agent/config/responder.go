@@ -0,0 +1,6 @@
+package config
+
+// Responder holds configuration of destructive commands approval
+type Responder struct {
+	ApprovalKey string `json:"approval-key,omitempty" toml:"approval-key" comment:"Manager's containment public key (see admin API) used to verify approvals of\n destructive commands (terminate, suspend, resume, quarantine, contain, uncontain, isolate-process,\n release-process, block, disable-user, revert) issued within responder sessions. When set, destructive commands without valid approval are refused."`
+}
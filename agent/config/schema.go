@@ -0,0 +1,15 @@
+package config
+
+// SchemaMapping holds field aliases applied to events of a channel
+type SchemaMapping struct {
+	Channel  string            `json:"channel,omitempty" toml:"channel" comment:"Channel the mapping applies to"`
+	EventIDs []int64           `json:"event-ids,omitempty" toml:"event-ids" comment:"Event IDs the mapping applies to (empty means any)"`
+	MinBuild int               `json:"min-build,omitempty" toml:"min-build" comment:"Minimum OS build number the mapping applies to (0 means no minimum)"`
+	MaxBuild int               `json:"max-build,omitempty" toml:"max-build" comment:"Maximum OS build number the mapping applies to (0 means no maximum)"`
+	Aliases  map[string]string `json:"aliases,omitempty" toml:"aliases" comment:"Field aliases (alias = canonical field name)"`
+}
+
+// Schema holds event schema mapping configuration
+type Schema struct {
+	Mappings []SchemaMapping `json:"mappings,omitempty" toml:"mappings" comment:"Field mappings applied to events before matching so that a rule\n written against canonical field names works across OS versions"`
+}
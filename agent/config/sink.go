@@ -0,0 +1,12 @@
+package config
+
+// Sink holds configuration of the sink used to print events
+// passing through the agent (i.e. for debugging purposes)
+type Sink struct {
+	Enable         bool     `json:"enable,omitempty" toml:"enable" comment:"Enable event sink"`
+	Path           string   `json:"path,omitempty" toml:"path" comment:"File events are appended to (empty means stdout)"`
+	Format         string   `json:"format,omitempty" toml:"format" comment:"Format of the events written (choices: json, pretty, csv)"`
+	Channels       []string `json:"channels,omitempty" toml:"channels" comment:"Write only events of these channels (empty means any)"`
+	EventIDs       []int64  `json:"event-ids,omitempty" toml:"event-ids" comment:"Write only events with these IDs (empty means any)"`
+	MinCriticality int      `json:"min-criticality,omitempty" toml:"min-criticality" comment:"Write only detections with criticality greater than or equal to this value (0 means any event)"`
+}
@@ -0,0 +1,16 @@
+package config
+
+// CustomSource is a third-party producer allowed to inject events
+type CustomSource struct {
+	Name string `json:"name" toml:"name" comment:"Name of the source, events are injected in channel Custom/NAME"`
+	Key  string `json:"key" toml:"key" comment:"Key the source authenticates with (X-Api-Key header)"`
+}
+
+// CustomSources holds configuration of the local API used by
+// other endpoint software to inject events into the agent
+type CustomSources struct {
+	Enable       bool            `json:"enable,omitempty" toml:"enable" comment:"Enable local event ingestion API"`
+	Listen       string          `json:"listen,omitempty" toml:"listen" comment:"Address the ingestion API listens on (loopback addresses only)"`
+	MaxEventSize int             `json:"max-event-size,omitempty" toml:"max-event-size" comment:"Maximum size in bytes of an event injected"`
+	Sources      []*CustomSource `json:"sources,omitempty" toml:"sources" comment:"Sources allowed to inject events"`
+}
@@ -0,0 +1,59 @@
+package config
+
+// Watchlist is a list of accounts (executives, service accounts ...)
+// distributed as a container, events involving one of these accounts
+// get particular attention
+type Watchlist struct {
+	Container string `json:"container" toml:"container" comment:"Container holding the watched accounts, one per line (DOMAIN\\user or user)"`
+	Modifier  int    `json:"modifier,omitempty" toml:"modifier" comment:"Value added to the criticality of alerts involving a watched account"`
+	Forward   bool   `json:"forward,omitempty" toml:"forward" comment:"Always forward events involving a watched account, even if not matching any rule"`
+}
+
+// Watchlists holds user watchlists configuration
+type Watchlists struct {
+	Enable bool         `json:"enable,omitempty" toml:"enable" comment:"Enable user watchlists"`
+	Lists  []*Watchlist `json:"lists,omitempty" toml:"lists" comment:"User watchlists, watchlists an event's accounts belong to\n are set in the Watchlists field of the event and can be used in rules"`
+}
+
+// Watchlist returns the watchlist using container, nil if there is none
+func (c *Watchlists) Watchlist(container string) *Watchlist {
+	for _, w := range c.Lists {
+		if w.Container == container {
+			return w
+		}
+	}
+	return nil
+}
+
+// Recalibrate returns the criticality recalibrated according to the
+// watchlists matched by an event, result is always in [0;10]
+func (c *Watchlists) Recalibrate(crit int, matched ...string) int {
+	if len(matched) == 0 {
+		return crit
+	}
+
+	for _, name := range matched {
+		if w := c.Watchlist(name); w != nil {
+			crit += w.Modifier
+		}
+	}
+
+	switch {
+	case crit < minCriticality:
+		return minCriticality
+	case crit > maxCriticality:
+		return maxCriticality
+	}
+
+	return crit
+}
+
+// Forward returns true if events matching one of the watchlists must always be forwarded
+func (c *Watchlists) Forward(matched ...string) bool {
+	for _, name := range matched {
+		if w := c.Watchlist(name); w != nil && w.Forward {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Yara holds YARA scanning configuration
+type Yara struct {
+	Enable        bool          `json:"enable,omitempty" toml:"enable" comment:"Enable YARA scanning (yara-scan command and post-detection scans)"`
+	Tool          string        `json:"tool,omitempty" toml:"tool" comment:"Path to the YARA command line scanner (i.e. yara64.exe)"`
+	Rules         string        `json:"rules,omitempty" toml:"rules" comment:"Path to the YARA rules file"`
+	Compiled      bool          `json:"compiled,omitempty" toml:"compiled" comment:"Rules file is compiled with yarac"`
+	ScanFiledumps bool          `json:"scan-filedumps,omitempty" toml:"scan-filedumps" comment:"Scan the files dumped by filedump action"`
+	ScanMemdumps  bool          `json:"scan-memdumps,omitempty" toml:"scan-memdumps" comment:"Scan the memory of the processes dumped by memdump action"`
+	Timeout       time.Duration `json:"timeout,omitempty" toml:"timeout" comment:"YARA scanner is killed if a scan runs longer than this"`
+}
+
+// Verify checks YARA configuration is valid
+func (y *Yara) Verify() error {
+	if y.Enable && (y.Tool == "" || y.Rules == "") {
+		return fmt.Errorf("tool and rules must be set")
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// ContainProfileFull only allows traffic to the manager
+	ContainProfileFull = "full"
+	// ContainProfileSoft allows traffic to the manager, DNS servers,
+	// WSUS/Windows Update and configured update servers
+	ContainProfileSoft = "soft"
+
+	pathWindowsUpdatePolicy = `HKLM\SOFTWARE\Policies\Microsoft\Windows\WindowsUpdate\`
+	pathTcpipInterfaces     = `HKLM\SYSTEM\CurrentControlSet\Services\Tcpip\Parameters\Interfaces\`
+)
+
+var (
+	// SoftContainRefresh interval at which addresses allowed by soft
+	// containment are resolved again as update servers' ones may change
+	SoftContainRefresh = 10 * time.Minute
+	// ContainStateCheck interval at which containment state is reloaded
+	// and auto-release timer checked
+	ContainStateCheck = time.Minute
+
+	ErrNotContained = errors.New("host is not contained")
+)
+
+// wsusHost returns the WSUS server configured by policy if any
+func wsusHost() string {
+	if u, err := url.Parse(utils.RegValueToString(pathWindowsUpdatePolicy, "WUServer")); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// dnsServers returns the DNS servers configured on network interfaces
+func dnsServers() (servers []string) {
+	servers = make([]string, 0)
+
+	itfs, _ := advapi32.RegEnumKeys(pathTcpipInterfaces)
+	for _, itf := range itfs {
+		for _, value := range []string{"NameServer", "DhcpNameServer"} {
+			s := utils.RegValueToString(pathTcpipInterfaces, itf, value)
+			servers = append(servers, strings.FieldsFunc(s, func(r rune) bool {
+				return r == ' ' || r == ','
+			})...)
+		}
+	}
+
+	return
+}
+
+// containAllowedIPs returns the IP addresses reachable for a given containment profile
+func (a *Agent) containAllowedIPs(profile string) (ips []net.IP, err error) {
+	// manager may be reachable over both IPv4 and IPv6
+	ips = append(ips, a.forwarder.Client.ManagerIPs...)
+
+	switch profile {
+	case ContainProfileFull:
+		return
+	case ContainProfileSoft:
+	default:
+		return nil, fmt.Errorf("unknown containment profile %s", profile)
+	}
+
+	hosts := dnsServers()
+	if wsus := wsusHost(); wsus != "" {
+		hosts = append(hosts, wsus)
+	}
+	hosts = append(hosts, a.config.ContainConfig.UpdateHosts...)
+	hosts = append(hosts, a.config.ContainConfig.SoftAllowedHosts...)
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+
+		// we resolve hosts and don't fail on error not to
+		// prevent containment from happening
+		if resolved, err := net.LookupIP(h); err != nil {
+			a.logger.Errorf("failed to resolve host allowed in containment %s: %s", h, err)
+		} else {
+			ips = append(ips, resolved...)
+		}
+	}
+
+	return
+}
+
+// loadContainmentState loads containment state from path, a missing
+// file means host is not contained
+func loadContainmentState(path string) (s *api.ContainmentState, err error) {
+	var b []byte
+
+	s = &api.ContainmentState{}
+	if b, err = os.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	err = json.Unmarshal(b, s)
+	return
+}
+
+func saveContainmentState(path string, s *api.ContainmentState) (err error) {
+	var b []byte
+
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+
+	return utils.HidsWriteData(path, b)
+}
+
+// ContainProfile returns the containment profile currently applied, empty
+// string if host is not contained
+func (a *Agent) ContainProfile() string {
+	a.containment.Lock()
+	defer a.containment.Unlock()
+	return a.containment.state.Profile
+}
+
+// ContainmentState returns a copy of the current containment state
+func (a *Agent) ContainmentState() api.ContainmentState {
+	a.containment.Lock()
+	defer a.containment.Unlock()
+	return *a.containment.state
+}
+
+// updateContainment updates containment state, persists it and makes
+// it reported to the manager. Caller must hold containment lock.
+func (a *Agent) updateContainment(s *api.ContainmentState) (err error) {
+	a.containment.state = s
+
+	if a.forwarder != nil && a.forwarder.Client != nil {
+		a.forwarder.Client.SetContainment(s)
+	}
+
+	if err = saveContainmentState(a.config.ContainConfig.StatePath, s); err != nil {
+		return fmt.Errorf("failed to save containment state: %w", err)
+	}
+
+	return
+}
+
+// removeLegacyContainRule removes the firewall rule used to contain
+// hosts by previous versions, if any
+func removeLegacyContainRule() {
+	exec.Command("netsh.exe", "advfirewall", "firewall", "delete", "rule",
+		fmt.Sprintf("name=%s", ContainRuleName)).Run()
+}
+
+// applyContainment replaces containment filters by the ones of profile,
+// it returns the number of filters applied
+func (a *Agent) applyContainment(profile string) (n int, err error) {
+	var ips []net.IP
+
+	if ips, err = a.containAllowedIPs(profile); err != nil {
+		return
+	}
+
+	removeLegacyContainRule()
+	// filters of any previous containment are replaced not to stack up profiles
+	if n, err = wfpContain(ips); err != nil {
+		return 0, fmt.Errorf("failed to contain host: %w", err)
+	}
+
+	return
+}
+
+// contain isolates host at network level with a given profile, see
+// contain command for details
+func (a *Agent) contain(profile string, release time.Duration) (err error) {
+	var n int
+
+	if n, err = a.applyContainment(profile); err != nil {
+		return
+	}
+
+	a.containment.Lock()
+	defer a.containment.Unlock()
+
+	s := api.NewContainmentState(profile, release)
+	s.Filters = n
+	return a.updateContainment(s)
+}
+
+// uncontain releases host containment
+func (a *Agent) uncontain() (err error) {
+	removeLegacyContainRule()
+	if err = wfpUncontain(); err != nil {
+		return fmt.Errorf("failed to release containment: %w", err)
+	}
+
+	return a.setContainment("", 0)
+}
+
+// setContainment sets the containment profile applied to host, with an
+// auto-release timer if release > 0. An empty profile means host is
+// not contained anymore.
+func (a *Agent) setContainment(profile string, release time.Duration) error {
+	a.containment.Lock()
+	defer a.containment.Unlock()
+
+	return a.updateContainment(api.NewContainmentState(profile, release))
+}
+
+// initContainment restores containment state persisted by a previous run
+func (a *Agent) initContainment() (err error) {
+	var s *api.ContainmentState
+
+	a.containment.Lock()
+	defer a.containment.Unlock()
+
+	if s, err = loadContainmentState(a.config.ContainConfig.StatePath); err != nil {
+		a.containment.state = &api.ContainmentState{}
+		return fmt.Errorf("failed to load containment state: %w", err)
+	}
+
+	a.containment.state = s
+	if a.forwarder != nil && a.forwarder.Client != nil {
+		a.forwarder.Client.SetContainment(s)
+	}
+
+	return
+}
+
+// checkContainment reloads containment state, as it may have been changed
+// by an emergency release, and releases containment if its timer expired
+func (a *Agent) checkContainment() (err error) {
+	var s *api.ContainmentState
+
+	a.containment.Lock()
+	defer a.containment.Unlock()
+
+	if s, err = loadContainmentState(a.config.ContainConfig.StatePath); err != nil {
+		return fmt.Errorf("failed to load containment state: %w", err)
+	}
+
+	if s.Expired(time.Now()) {
+		a.logger.Infof("containment timer expired, releasing host")
+		removeLegacyContainRule()
+		if err = wfpUncontain(); err != nil {
+			return fmt.Errorf("failed to release containment: %w", err)
+		}
+		s = &api.ContainmentState{}
+	}
+
+	// filters may have been removed by tampering with filtering engine, a
+	// host contained by a previous version has no filter to migrate to
+	if s.Contained() {
+		var n int
+
+		if n, err = wfpContainFilters(); err != nil {
+			a.logger.Errorf("failed to check containment filters: %s", err)
+		} else if n < s.Filters || s.Filters == 0 {
+			a.logger.Warnf("%d containment filters in place out of %d, containing host again", n, s.Filters)
+			if s.Filters, err = a.applyContainment(s.Profile); err != nil {
+				a.logger.Error(err)
+			}
+		}
+	}
+
+	return a.updateContainment(s)
+}
+
+// EmergencyRelease releases host containment locally, without requiring
+// the manager to be reachable, given a release token signed by the manager
+func EmergencyRelease(c *config.Agent, token string) (err error) {
+	var s *api.ContainmentState
+
+	if s, err = loadContainmentState(c.ContainConfig.StatePath); err != nil {
+		return fmt.Errorf("failed to load containment state: %w", err)
+	}
+
+	if !s.Contained() {
+		return ErrNotContained
+	}
+
+	if c.ContainConfig.ReleaseKey == "" {
+		return fmt.Errorf("no release key configured")
+	}
+
+	if _, err = api.VerifyReleaseToken(token, c.ContainConfig.ReleaseKey, c.FwdConfig.Client.UUID, time.Now()); err != nil {
+		return
+	}
+
+	removeLegacyContainRule()
+	if err = wfpUncontain(); err != nil {
+		return fmt.Errorf("failed to release containment: %w", err)
+	}
+
+	// running agent picks up the new state when checking containment
+	return saveContainmentState(c.ContainConfig.StatePath, &api.ContainmentState{})
+}
+
+// refreshSoftContainment applies soft containment again with
+// update servers' addresses resolved again
+func (a *Agent) refreshSoftContainment() (err error) {
+	var ips []net.IP
+	var n int
+
+	if a.ContainProfile() != ContainProfileSoft {
+		return
+	}
+
+	if ips, err = a.containAllowedIPs(ContainProfileSoft); err != nil {
+		return
+	}
+
+	if n, err = wfpContain(ips); err != nil {
+		return fmt.Errorf("failed to update containment: %w", err)
+	}
+
+	a.containment.Lock()
+	defer a.containment.Unlock()
+
+	// number of filters changes with the number of addresses resolved
+	s := *a.containment.state
+	s.Filters = n
+	return a.updateContainment(&s)
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// CredentialTamperRuleName name of the detection reported when credential
+	// providers or LSA packages registrations differ from the baseline
+	CredentialTamperRuleName = "Builtin:CredentialTamper"
+
+	// event ID of the event generated on credential registration changes
+	agentCredentialTamperEventID = 3
+
+	// kinds of changes made to a monitored registration
+	credChangeAdded    = "added"
+	credChangeRemoved  = "removed"
+	credChangeModified = "modified"
+
+	// path under which COM servers implementing credential providers are registered
+	pathCLSID = `HKLM\SOFTWARE\Classes\CLSID`
+)
+
+var (
+	// CredentialKeys registry keys monitored by default, values and subkeys
+	// (resolved to the DLL implementing them for COM classes) are baselined
+	CredentialKeys = []string{
+		`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Authentication\Credential Providers`,
+		`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Authentication\Credential Provider Filters`,
+		`HKLM\SYSTEM\CurrentControlSet\Control\Lsa`,
+		`HKLM\SYSTEM\CurrentControlSet\Control\Lsa\OSConfig`,
+		`HKLM\SYSTEM\CurrentControlSet\Control\NetworkProvider\Order`,
+	}
+
+	clsidRe = regexp.MustCompile(`(?i)^\{[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\}$`)
+)
+
+// CredentialRegistration is a baselined registry value or subkey
+// of a monitored credential registration key
+type CredentialRegistration struct {
+	sod.Item
+	Path  string `json:"path" sod:"unique"`
+	Value string `json:"value"`
+}
+
+// CredentialChange is a difference between the baseline and
+// the current state of a credential registration
+type CredentialChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// diffCredentialRegistrations returns the changes between two snapshots
+// of credential registrations, sorted by path
+func diffCredentialRegistrations(baseline, current map[string]string) (changes []*CredentialChange) {
+	changes = make([]*CredentialChange, 0)
+
+	for path, old := range baseline {
+		if new, ok := current[path]; !ok {
+			changes = append(changes, &CredentialChange{Path: path, Change: credChangeRemoved, Old: old})
+		} else if new != old {
+			changes = append(changes, &CredentialChange{Path: path, Change: credChangeModified, Old: old, New: new})
+		}
+	}
+
+	for path, new := range current {
+		if _, ok := baseline[path]; !ok {
+			changes = append(changes, &CredentialChange{Path: path, Change: credChangeAdded, New: new})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return
+}
+
+// credentialKeys returns the registry keys to monitor
+func (a *Agent) credentialKeys() []string {
+	return utils.DedupStringSlice(append(append([]string{}, CredentialKeys...), a.config.CredConfig.Keys...))
+}
+
+// snapshotCredentialRegistrations reads the current state of monitored keys,
+// subkeys being COM classes are resolved to the DLL implementing them
+func (a *Agent) snapshotCredentialRegistrations() (snap map[string]string) {
+	snap = make(map[string]string)
+
+	for _, key := range a.credentialKeys() {
+		if values, err := advapi32.RegEnumValues(key); err == nil {
+			for _, v := range values {
+				snap[utils.RegJoin(key, v)] = utils.RegValueToString(key, v)
+			}
+		}
+
+		if subkeys, err := advapi32.RegEnumKeys(key); err == nil {
+			for _, sk := range subkeys {
+				value := ""
+				if clsidRe.MatchString(sk) {
+					// default value of InprocServer32 is the DLL path
+					if v, err := utils.RegValue(utils.RegJoin(pathCLSID, sk, "InprocServer32") + `\`); err == nil {
+						value = fmt.Sprintf("%v", v)
+					}
+				}
+				snap[utils.RegJoin(key, sk)] = value
+			}
+		}
+	}
+
+	return
+}
+
+// credentialBaseline returns the baseline stored in local database
+func (a *Agent) credentialBaseline() (baseline map[string]string, err error) {
+	var objs []sod.Object
+
+	if objs, err = a.db.All(&CredentialRegistration{}); err != nil {
+		return
+	}
+
+	baseline = make(map[string]string)
+	for _, o := range objs {
+		r := o.(*CredentialRegistration)
+		baseline[r.Path] = r.Value
+	}
+
+	return
+}
+
+// saveCredentialBaseline replaces the baseline stored in local database
+func (a *Agent) saveCredentialBaseline(snap map[string]string) (err error) {
+	regs := make([]*CredentialRegistration, 0, len(snap))
+	for path, value := range snap {
+		regs = append(regs, &CredentialRegistration{Path: path, Value: value})
+	}
+
+	if err = a.db.DeleteAll(&CredentialRegistration{}); err != nil {
+		return
+	}
+
+	_, err = a.db.InsertOrUpdateMany(sod.ToObjectSlice(regs)...)
+	return
+}
+
+// credentialTamperEvent generates the alert sent when a credential
+// registration differs from the baseline
+func (a *Agent) credentialTamperEvent(c *CredentialChange) *event.EdrEvent {
+	e := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	e.System.Channel = agentChannel
+	e.System.Computer = hostname
+	e.System.EventID = agentCredentialTamperEventID
+	e.System.Execution.ProcessID = u32PID
+	e.System.Provider.Name = agentChannel
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData["Path"] = c.Path
+	e.EventData["Change"] = c.Change
+	e.EventData["OldValue"] = c.Old
+	e.EventData["NewValue"] = c.New
+
+	d := engine.NewDetection(false, false)
+	d.Signature.Add(CredentialTamperRuleName)
+	d.Criticality = a.config.CredConfig.Criticality
+
+	edrEvt := event.NewEdrEvent(e)
+	edrEvt.NormalizeTime()
+	edrEvt.SetDetection(d)
+
+	return edrEvt
+}
+
+// checkCredentialRegistrations compares credential registrations to the
+// baseline and raises an alert for every change. Baseline is created at
+// first run and updated once changes have been reported.
+func (a *Agent) checkCredentialRegistrations() (changes []*CredentialChange, err error) {
+	var baseline map[string]string
+
+	if baseline, err = a.credentialBaseline(); err != nil {
+		return
+	}
+
+	snap := a.snapshotCredentialRegistrations()
+
+	if len(baseline) == 0 {
+		a.logger.Infof("Creating credential registrations baseline (%d entries)", len(snap))
+		return nil, a.saveCredentialBaseline(snap)
+	}
+
+	if changes = diffCredentialRegistrations(baseline, snap); len(changes) == 0 {
+		return
+	}
+
+	for _, c := range changes {
+		a.logger.Warnf("Credential registration %s %s: %q -> %q", c.Path, c.Change, c.Old, c.New)
+		if err := a.forward(a.credentialTamperEvent(c)); err != nil {
+			a.logger.Errorf("Failed to pipe credential tamper event: %s", err)
+		}
+	}
+
+	return changes, a.saveCredentialBaseline(snap)
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -15,36 +14,13 @@ import (
 	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
 	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/agent/config"
 	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/api/client"
 	"github.com/0xrawsec/whids/los"
 	"github.com/0xrawsec/whids/tools"
-	"github.com/0xrawsec/whids/utils"
 )
 
-func (a *Agent) containCmd() *exec.Cmd {
-	ip := a.forwarder.Client.ManagerIP
-	// only allow connection to the manager configured
-	return exec.Command("netsh.exe",
-		"advfirewall",
-		"firewall",
-		"add",
-		"rule",
-		fmt.Sprintf("name=%s", ContainRuleName),
-		"dir=out",
-		fmt.Sprintf("remoteip=0.0.0.0-%s,%s-255.255.255.255", utils.PrevIP(ip), utils.NextIP(ip)),
-		"action=block")
-}
-
-func (a *Agent) uncontainCmd() *exec.Cmd {
-	return exec.Command("netsh.exe", "advfirewall",
-		"firewall",
-		"delete",
-		"rule",
-		fmt.Sprintf("name=%s", ContainRuleName),
-	)
-}
-
 func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 
 	// command documentation template:
@@ -58,6 +34,21 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		}
 	*/
 
+	if err := checkCommandCompiled(cmd.Name, cmd.Args); err != nil {
+		a.logger.Errorf("command sent by manager \"%s\": %s", cmd.String(), err)
+		cmd.Unrunnable()
+		cmd.ErrorFrom(err)
+		return
+	}
+
+	// destructive commands must be approved within a responder session
+	if err := a.verifyApproval(cmd); err != nil {
+		a.logger.Errorf("command sent by manager \"%s\": %s", cmd.String(), err)
+		cmd.Unrunnable()
+		cmd.ErrorFrom(err)
+		return
+	}
+
 	// Switch processing the commands
 	switch cmd.Name {
 
@@ -65,12 +56,123 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 	/*
 		@command: {
 				"name": "contain",
-				"description": "Isolate host at network level",
-				"help": "`contain`"
+				"description": "Isolate host at network level. Full containment (default) only allows traffic to the manager, soft containment also allows traffic to DNS servers, WSUS/Windows Update and configured update servers. Both inbound and outbound IPv4 and IPv6 traffic is blocked, except loopback and DHCP, with persistent Windows Filtering Platform filters which are enforced even if Windows Firewall is stopped. An optional duration (Go time.Duration format) can be given to automatically release host when it expires",
+				"help": "`contain [full|soft] [DURATION]`",
+				"example": "`contain soft 4h`"
 			}
 	*/
 	case "contain":
-		cmd.FromExecCmd(a.containCmd())
+		var release time.Duration
+		var err error
+
+		profile := ContainProfileFull
+		if len(cmd.Args) > 0 {
+			profile = cmd.Args[0]
+		}
+
+		if len(cmd.Args) > 1 {
+			if release, err = time.ParseDuration(cmd.Args[1]); err != nil {
+				err = fmt.Errorf("bad containment duration: %w", err)
+			}
+		}
+
+		if err == nil {
+			err = a.contain(profile, release)
+		}
+
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = a.ContainmentState()
+		}
+
+	/*
+		@command: {
+			"name": "tap",
+			"description": "Live stream raw events (before any filtering) of a channel to the manager for a limited time, optionally restricted to processes whose image matches a regex. Number of events streamed per second is capped. `tap off` stops streaming",
+			"help": "`tap CHANNEL|all|off [DURATION] [IMAGE_REGEX]`",
+			"example": "`tap Microsoft-Windows-Sysmon/Operational 10m (?i:powershell\\.exe$)`"
+		}
+	*/
+	case "tap":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing channel"))
+			break
+		}
+
+		if cmd.Args[0] == "off" {
+			a.stopTap()
+			break
+		}
+
+		var err error
+		var image string
+		dur := TapDefaultDuration
+
+		if len(cmd.Args) > 1 {
+			if dur, err = time.ParseDuration(cmd.Args[1]); err != nil {
+				cmd.ErrorFrom(fmt.Errorf("bad tap duration: %w", err))
+				break
+			}
+		}
+
+		if len(cmd.Args) > 2 {
+			image = cmd.Args[2]
+		}
+
+		if status, err := a.startTap(cmd.Args[0], image, dur); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = status
+		}
+
+	/*
+		@command: {
+			"name": "query-events",
+			"description": "Query the local database of filtered events (kept when event filtering and event database are enabled). Time range is given with since/until (RFC3339), last (Go time.Duration format) or pivot (RFC3339) and delta to get events around a given time, last hour is queried by default. Other arguments filter events on their fields, either with case insensitive equality (FIELD=VALUE) or regular expression (FIELD~=REGEX), Channel and EventID are also valid fields. Events are returned from the oldest to the newest, up to limit (1000 by default)",
+			"help": "`query-events [since=TIME] [until=TIME] [last=DURATION] [pivot=TIME] [delta=DURATION] [limit=N] [FIELD=VALUE|FIELD~=REGEX ...]`",
+			"example": "`query-events pivot=2022-06-01T10:00:00Z delta=10m EventID=1 Image~=(?i:\\\\powershell\\.exe$)`"
+		}
+	*/
+	case "query-events":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if a.eventDB == nil {
+			cmd.ErrorFrom(fmt.Errorf("event database is not enabled"))
+			break
+		}
+
+		if q, err := ParseEventQuery(cmd.Args, time.Now()); err != nil {
+			cmd.ErrorFrom(err)
+		} else if out, err := a.eventDB.Query(q); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "channels",
+			"description": "List, add or remove ETW providers subscribed to through etw: channels. Only the real-time subscription is rebuilt, the rest of the pipeline keeps running. Changes are not saved and are lost when agent restarts or its configuration is updated",
+			"help": "`channels [add|remove etw:PROVIDER ...]`",
+			"example": "`channels add etw:Microsoft-Windows-DNS-Client:0xff:3006,3008`"
+		}
+	*/
+	case "channels":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if channels, err := a.channelsCommand(cmd.Args); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = channels
+		}
 
 	/*
 		@command: {
@@ -80,7 +182,64 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		}
 	*/
 	case "uncontain":
-		cmd.FromExecCmd(a.uncontainCmd())
+		cmd.Unrunnable()
+		if err := a.uncontain(); err != nil {
+			cmd.ErrorFrom(err)
+		}
+
+	/*
+		@command: {
+			"name": "isolate-process",
+			"description": "Block inbound and outbound network traffic of a single process, identified by its PID or process GUID, instead of containing the whole host. Traffic is blocked with persistent Windows Filtering Platform filters matching the image of the process, so any process running the same image is isolated as well. Isolation is released when the process terminates. Without argument, lists the processes currently isolated",
+			"help": "`isolate-process [PID|PROCESS_GUID]`",
+			"example": "`isolate-process 4242`"
+		}
+	*/
+	case ActionIsolateProcess:
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if len(cmd.Args) == 0 {
+			if procs, err := isolatedProcesses(); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = procs
+			}
+			break
+		}
+
+		if t, err := a.processTrack(cmd.Args[0]); err != nil {
+			cmd.ErrorFrom(err)
+		} else if p, err := a.isolateProcess(t); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = p
+		}
+
+	/*
+		@command: {
+			"name": "release-process",
+			"description": "Release network isolation of processes isolated with `isolate-process`, identified by PID or process GUID, `all` releases every isolated process",
+			"help": "`release-process PID|PROCESS_GUID|all`",
+			"example": "`release-process all`"
+		}
+	*/
+	case "release-process":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing process"))
+			break
+		}
+
+		if procs, err := a.releaseProcesses(cmd.Args[0]); err != nil {
+			cmd.ErrorFrom(err)
+		} else if len(procs) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("no isolated process matching %s", cmd.Args[0]))
+		} else {
+			cmd.Json = procs
+		}
 
 	/*
 		@command: {
@@ -113,14 +272,26 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 	/*
 		@command: {
 			"name": "terminate",
-			"description": "Terminate a process given its PID",
-			"help": "`terminate PID`",
-			"example": "`terminate 1337`"
+			"description": "Terminate a process given its PID. With kill-tree option, the process and all its children (as tracked by the agent) are suspended and then terminated, children first. Returns the processes terminated when killing a tree",
+			"help": "`terminate PID [kill-tree]`",
+			"example": "`terminate 1337 kill-tree`"
 		}
 	*/
 	case "terminate":
 		cmd.Unrunnable()
-		if len(cmd.Args) > 0 {
+		switch {
+		case len(cmd.Args) > 1 && cmd.Args[1] == optKillTree:
+			cmd.ExpectJSON = true
+			if t, err := a.processTrack(cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(err)
+			} else if killed, err := a.killTree(t); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = killed
+			}
+		case len(cmd.Args) > 1:
+			cmd.ErrorFrom(fmt.Errorf("unknown option %s", cmd.Args[1]))
+		case len(cmd.Args) > 0:
 			spid := cmd.Args[0]
 			if pid, err := strconv.Atoi(spid); err != nil {
 				cmd.ErrorFrom(fmt.Errorf("failed to parse pid: %w", err))
@@ -129,6 +300,42 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 			}
 		}
 
+	/*
+		@command: {
+			"name": "suspend",
+			"description": "Suspend all the threads of a process, given its PID or process GUID, instead of killing it so that its memory is preserved for forensic dumping. Process stays suspended until resumed with `resume` or terminated",
+			"help": "`suspend PID|PROCESS_GUID`",
+			"example": "`suspend 1337`"
+		}
+	*/
+	case ActionSuspend:
+		cmd.Unrunnable()
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing process"))
+		} else if t, err := a.processTrack(cmd.Args[0]); err != nil {
+			cmd.ErrorFrom(err)
+		} else if err := a.suspendProcess(t); err != nil {
+			cmd.ErrorFrom(err)
+		}
+
+	/*
+		@command: {
+			"name": "resume",
+			"description": "Resume a process, given its PID or process GUID, suspended with `suspend` command or rule action",
+			"help": "`resume PID|PROCESS_GUID`",
+			"example": "`resume 1337`"
+		}
+	*/
+	case "resume":
+		cmd.Unrunnable()
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing process"))
+		} else if t, err := a.processTrack(cmd.Args[0]); err != nil {
+			cmd.ErrorFrom(err)
+		} else if err := a.resumeProcess(t); err != nil {
+			cmd.ErrorFrom(err)
+		}
+
 	/*
 		@command: {
 			"name": "hash",
@@ -141,7 +348,7 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
 		if len(cmd.Args) > 0 {
-			if out, err := cmdHash(cmd.Args[0]); err != nil {
+			if out, err := cmdHash(cmd.Args[0], a.hashCache); err != nil {
 				cmd.ErrorFrom(err)
 			} else {
 				cmd.Json = out
@@ -160,7 +367,7 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
 		if len(cmd.Args) == 2 {
-			if out, err := cmdFind(cmd.Args[0], cmd.Args[1], true); err != nil {
+			if out, err := cmdFind(cmd.Args[0], cmd.Args[1], true, a.hashCache); err != nil {
 				cmd.ErrorFrom(err)
 			} else {
 				cmd.Json = out
@@ -232,13 +439,369 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
 		if len(cmd.Args) == 2 {
-			if out, err := cmdFind(cmd.Args[0], cmd.Args[1], false); err != nil {
+			if out, err := cmdFind(cmd.Args[0], cmd.Args[1], false, nil); err != nil {
 				cmd.ErrorFrom(err)
 			} else {
 				cmd.Json = out
 			}
 		}
 
+	/*
+		@command: {
+			"name": "vss-create",
+			"description": "Create a VSS snapshot (shadow copy) of a volume (system drive by default). Snapshot is kept until deleted with vss-delete",
+			"help": "`vss-create [VOLUME]`",
+			"example": "`vss-create C:`"
+		}
+	*/
+	case "vss-create":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		volume := os.Getenv("SystemDrive")
+		if len(cmd.Args) > 0 {
+			volume = cmd.Args[0]
+		}
+		if s, err := vssCreate(volume); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = s
+		}
+
+	/*
+		@command: {
+			"name": "vss-delete",
+			"description": "Delete a VSS snapshot given its ID",
+			"help": "`vss-delete ID`",
+			"example": "`vss-delete {8a4f9b4e-5b1c-4c84-9f5e-2c3b0a6d7e11}`"
+		}
+	*/
+	case "vss-delete":
+		cmd.Unrunnable()
+		if len(cmd.Args) > 0 {
+			if err := vssDelete(cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(err)
+			}
+		}
+
+	/*
+		@command: {
+			"name": "vss-collect",
+			"description": "Collect files locked during normal operation from a temporary VSS snapshot of system drive. Targets are either predefined sets (hives: registry hives, ntds: ntds.dit on domain controllers, ost: Outlook OST files, srum: SRUM database) or file paths (globs supported). Collected files are uploaded to the manager as endpoint artifacts",
+			"help": "`vss-collect TARGET [TARGET...]`",
+			"example": "`vss-collect hives ost`"
+		}
+	*/
+	case "vss-collect":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if out, err := a.cmdVSSCollect(cmd.Args); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = out
+			}
+		}
+
+	/*
+		@command: {
+			"name": "sweep",
+			"description": "Enumerate Recycle Bin items (from $I metadata files) and files in common temp/staging directories and look for files whose hash is found in IOC containers. With retrieve option, Recycle Bin metadata files and files matching IOCs are uploaded to the manager as endpoint artifacts",
+			"help": "`sweep [retrieve]`",
+			"example": "`sweep retrieve`"
+		}
+	*/
+	case "sweep":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		retrieve := len(cmd.Args) > 0 && cmd.Args[0] == "retrieve"
+		if out, err := a.cmdSweep(retrieve); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "browser",
+			"description": "Collect history, downloads and installed extensions of Chromium based browsers (Chrome, Edge, Brave ...) and Firefox for all the users of the endpoint. With retrieve option, raw history databases and extensions' manifests are uploaded to the manager as endpoint artifacts",
+			"help": "`browser [retrieve]`",
+			"example": "`browser retrieve`"
+		}
+	*/
+	case "browser":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		retrieve := len(cmd.Args) > 0 && cmd.Args[0] == "retrieve"
+		cmd.Json = a.cmdBrowser(retrieve)
+
+	/*
+		@command: {
+			"name": "srum",
+			"description": "Parse System Resource Usage Monitor (SRUM) database and return, for every application and user, historical network usage (bytes sent and received), execution times (CPU cycles, face time) and disk usage, sorted by bytes sent. An optional duration (Go time.Duration format) restricts records to the given period. With retrieve option, raw SRUM database is uploaded to the manager as an endpoint artifact",
+			"help": "`srum [DURATION] [retrieve]`",
+			"example": "`srum 168h retrieve`"
+		}
+	*/
+	case "srum":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		var err error
+		var since time.Duration
+		retrieve := false
+
+		for _, arg := range cmd.Args {
+			if arg == "retrieve" {
+				retrieve = true
+			} else if since, err = time.ParseDuration(arg); err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			cmd.ErrorFrom(fmt.Errorf("bad duration: %w", err))
+			break
+		}
+
+		if out, err := a.cmdSRUM(since, retrieve); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "acquire-memory",
+			"description": "Acquire full physical memory with the acquisition tool configured (i.e. winpmem). Acquisition must be enabled in configuration and the command is only run if signed by the manager. Acquisition runs in background, memory image is uploaded to the manager as endpoint artifacts in chunks along with a manifest holding chunks and image hashes. `acquire-memory status` returns the status of the last acquisition",
+			"help": "`acquire-memory [status]`",
+			"example": "`acquire-memory`"
+		}
+	*/
+	case "acquire-memory":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if len(cmd.Args) > 0 && cmd.Args[0] == "status" {
+			cmd.Json = a.AcquisitionStatus()
+			break
+		}
+
+		if out, err := a.startAcquisition(cmd); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "dump-memory",
+			"description": "Dump the memory of a process, identified by PID or process GUID, with the same machinery as memdump action. The dump is uploaded to the manager as an endpoint artifact, the command returns the path of the artifact",
+			"help": "`dump-memory PID|GUID`",
+			"example": "`dump-memory {515cd0d1-7de8-6372-6d00-000000005500}`"
+		}
+	*/
+	case "dump-memory":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if d, err := a.dumpProcessMemory(cmd.UUID, cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = d
+			}
+		}
+
+	/*
+		@command: {
+			"name": "fetch-file",
+			"description": "Upload a file to the manager where it is stored as an endpoint artifact. The file is streamed by chunks, its size must not exceed the limit configured. Returns the size, modification time and hashes of the file along with the path of the artifact. Files locked by other processes must be collected with vss-collect",
+			"help": "`fetch-file FILE`",
+			"example": "`fetch-file C:\\\\Users\\\\Public\\\\implant.exe`"
+		}
+	*/
+	case "fetch-file":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if f, err := a.fetchFile(cmd.UUID, cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = f
+			}
+		}
+
+	/*
+		@command: {
+			"name": "yara-scan",
+			"description": "Scan a file, a directory (recursively) or the memory of a process (by PID) with the YARA scanner and rules configured. YARA scanning must be enabled in configuration. Returns the rules matching along with their tags and the file or process matched",
+			"help": "`yara-scan FILE|DIRECTORY|PID`",
+			"example": "`yara-scan C:\\\\Users\\\\Public`"
+		}
+	*/
+	case "yara-scan":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if scan, err := a.yaraScan(cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = scan
+			}
+		}
+
+	/*
+		@command: {
+			"name": "perf",
+			"description": "Measure agent's own CPU, memory, I/O and handle usage over a sampling period (30s by default, Go time.Duration format) and return it along with the time spent by every agent subsystem (hooks, engine, forwarder, dumps). Subsystem load is the percentage of the sampling period the subsystem was busy, it may exceed 100% when events are processed by several workers. Hooks disabled after panicking too often are listed as well, so are the hits, misses and bytes not read thanks to the file hash cache",
+			"help": "`perf [DURATION]`",
+			"example": "`perf 2m`"
+		}
+	*/
+	case "perf":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		var err error
+		var period time.Duration
+
+		if len(cmd.Args) > 0 {
+			if period, err = time.ParseDuration(cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(fmt.Errorf("bad sampling period: %w", err))
+				break
+			}
+		}
+
+		if out, err := a.cmdPerf(period); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "stats",
+			"description": "Get agent's event processing statistics (events scanned, alerts reported, alerts suppressed, events by channel and alerts by rule) since agent booted and cumulated across agent restarts",
+			"help": "`stats`",
+			"example": "`stats`"
+		}
+	*/
+	case "stats":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = a.Stats()
+
+	/*
+		@command: {
+			"name": "selftest",
+			"description": "Run the self-test pack shipped with the agent: benign triggers of common attack techniques (command shell, PowerShell, file staging, Run key, DNS) are executed and the command returns, for every technique, whether the builtin self-test rule fired, the event got enriched by hooks and the alert reached the manager. Artifacts created by triggers are removed. An optional timeout (Go time.Duration format, 1m by default) sets how long to wait for the events",
+			"help": "`selftest [TIMEOUT]`",
+			"example": "`selftest 2m`"
+		}
+	*/
+	case "selftest":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		var err error
+		var timeout time.Duration
+
+		if len(cmd.Args) > 0 {
+			if timeout, err = time.ParseDuration(cmd.Args[0]); err != nil {
+				cmd.ErrorFrom(fmt.Errorf("bad timeout: %w", err))
+				break
+			}
+		}
+
+		if out, err := a.cmdSelfTest(timeout); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = out
+		}
+
+	/*
+		@command: {
+			"name": "block",
+			"description": "Block inbound and outbound traffic with IP addresses or CIDRs using Windows firewall. Blocks can be reverted with `revert` command",
+			"help": "`block ADDRESS...`",
+			"example": "`block 198.51.100.7 203.0.113.0/24`"
+		}
+	*/
+	case "block":
+		cmd.Unrunnable()
+
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing address to block"))
+			break
+		}
+
+		for _, addr := range cmd.Args {
+			if err := block(addr); err != nil {
+				cmd.ErrorFrom(err)
+				break
+			}
+		}
+
+	/*
+		@command: {
+			"name": "disable-user",
+			"description": "Disable a local user account. User can be enabled again with `revert` command",
+			"help": "`disable-user USER`",
+			"example": "`disable-user bob`"
+		}
+	*/
+	case "disable-user":
+		cmd.Unrunnable()
+
+		if len(cmd.Args) != 1 {
+			cmd.ErrorFrom(fmt.Errorf("disable-user takes exactly one user"))
+			break
+		}
+
+		if err := setUserActive(cmd.Args[0], false); err != nil {
+			cmd.ErrorFrom(err)
+		}
+
+	/*
+		@command: {
+			"name": "quarantine",
+			"description": "Move files to agent's quarantine directory, files are stored by SHA256 and original path. Files can be restored with `revert` command",
+			"help": "`quarantine FILE...`",
+			"example": "`quarantine C:\\\\Users\\\\Public\\\\payload.exe`"
+		}
+	*/
+	case "quarantine":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+
+		if len(cmd.Args) == 0 {
+			cmd.ErrorFrom(fmt.Errorf("missing file to quarantine"))
+			break
+		}
+
+		entries := make([]*QuarantineEntry, 0, len(cmd.Args))
+		for _, path := range cmd.Args {
+			e, err := quarantine(path)
+			if err != nil {
+				cmd.ErrorFrom(err)
+				break
+			}
+			entries = append(entries, e)
+		}
+		cmd.Json = entries
+
+	/*
+		@command: {
+			"name": "revert",
+			"description": "Revert response actions. Actions are specified as `TYPE[:TARGET]` where TYPE is one of `isolation`, `firewall-block`, `disable-user`, `quarantine` or `process-isolation`. This command is sent by the manager to restore endpoints when an incident is closed, a failure does not prevent the other actions from being reverted",
+			"help": "`revert ACTION...`",
+			"example": "`revert isolation firewall-block:198.51.100.7 disable-user:bob`"
+		}
+	*/
+	case api.RevertCommand:
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = a.cmdRevert(cmd.Args)
+
 	/*
 		@command: {
 			"name": "report",
@@ -292,6 +855,164 @@ func (a *Agent) handleManagerCommand(cmd *api.EndpointCommand) {
 		cmd.ExpectJSON = true
 		cmd.Json = a.tracker.Drivers
 		a.tracker.RUnlock()
+
+	/*
+		@command: {
+			"name": "netstat",
+			"description": "Retrieve active TCP and UDP connections (IPv4 and IPv6) along with the PID, process GUID and image of the process owning them. Connections owned by processes tracked from Sysmon logs carry process command line, user, integrity level, services and signature information",
+			"help": "`netstat`"
+		}
+	*/
+	case "netstat":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if conns, err := a.cmdNetstat(); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = conns
+		}
+
+	/*
+		@command: {
+			"name": "autoruns",
+			"description": "Enumerate common persistence locations: Run keys (machine and users), Winlogon Userinit and Shell values, boot, system and automatic start services (including svchost.exe service DLLs), scheduled tasks, WMI event consumers and startup folders. Executables started are resolved and hashed whenever possible. Locations failing to be enumerated are reported along with the entries found",
+			"help": "`autoruns`"
+		}
+	*/
+	case "autoruns":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = a.cmdAutoruns()
+
+	/*
+		@command: {
+			"name": "tasks",
+			"description": "List scheduled tasks with their state (enabled, hidden), author, account they run as, enabled triggers and actions. Images run by actions (executables or COM handlers DLL) are resolved, hashed and their signer, as reported by Sysmon, is looked up",
+			"help": "`tasks`"
+		}
+	*/
+	case "tasks":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = a.cmdTasks()
+
+	/*
+		@command: {
+			"name": "services",
+			"description": "List services with their type, start type, state, PID, account and binary path (and service DLL for services hosted by svchost.exe). Images implementing services are resolved, hashed and their signer, as reported by Sysmon, is looked up",
+			"help": "`services`"
+		}
+	*/
+	case "services":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if services, err := a.cmdServices(); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = services
+		}
+
+	/*
+		@command: {
+			"name": "tag",
+			"description": "Tag the endpoint, tags are persisted across agent restarts and reported to the manager along with every request so that they can be used in dynamic groups queries and to filter endpoints. Tags are case insensitive and made of letters, digits and _.:- characters. Returns endpoint's tags, without argument tags are only listed",
+			"help": "`tag [TAGS...]`",
+			"example": "`tag under-investigation`"
+		}
+	*/
+	case "tag":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if tags, err := a.updateTags(cmd.Args, nil); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = tags
+		}
+
+	/*
+		@command: {
+			"name": "untag",
+			"description": "Remove tags from the endpoint (see tag command). Returns the tags remaining",
+			"help": "`untag TAGS...`",
+			"example": "`untag under-investigation`"
+		}
+	*/
+	case "untag":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if tags, err := a.updateTags(nil, cmd.Args); err != nil {
+				cmd.ErrorFrom(err)
+			} else {
+				cmd.Json = tags
+			}
+		}
+
+	/*
+		@command: {
+			"name": "reg",
+			"description": "Query or export a registry key. The query sub-command returns the values (name, type, size and data) and the subkeys of the key. The export sub-command exports the key and its subkeys, recursively, to a .reg file (regedit format) uploaded to the manager as an endpoint artifact, it returns the path of the artifact along with the number of keys and values exported. Root keys can be abbreviated (HKLM, HKU, HKCR), HKCU is the one of the account the agent runs as",
+			"help": "`reg query|export KEY`",
+			"example": "`reg query HKLM\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\\Run`"
+		}
+	*/
+	case "reg":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) == 2 {
+			switch cmd.Args[0] {
+			case "query":
+				if k, err := cmdRegQuery(cmd.Args[1]); err != nil {
+					cmd.ErrorFrom(err)
+				} else {
+					cmd.Json = k
+				}
+			case "export":
+				if e, err := a.cmdRegExport(cmd.Args[1]); err != nil {
+					cmd.ErrorFrom(err)
+				} else {
+					cmd.Json = e
+				}
+			default:
+				cmd.ErrorFrom(fmt.Errorf("unknown sub-command %s", cmd.Args[0]))
+			}
+		}
+
+	/*
+		@command: {
+			"name": "evtquery",
+			"description": "Run a structured XPath query against an event log channel and return the matching events, the most recent first. At most MAX events (100 by default, 10000 at most) are returned, the result tells whether more events are matching. Queries containing spaces must be quoted",
+			"help": "`evtquery CHANNEL XPATH [MAX]`",
+			"example": "`evtquery Security \"*[System[EventID=4624] and EventData[Data[@Name='TargetUserName']='jdoe']]\" 50`"
+		}
+	*/
+	case "evtquery":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if channel, query, max, err := parseEvtQueryArgs(cmd.Args); err != nil {
+			cmd.ErrorFrom(err)
+		} else if r, err := a.cmdEvtQuery(channel, query, max); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = r
+		}
+
+	/*
+		@command: {
+			"name": "collect",
+			"description": "Collect triage artifacts (event logs, registry hives, prefetch, Amcache, browser histories, $MFT ...) defined by targets of the collect configuration, all targets are collected if none is given. Files are read from a temporary VSS snapshot of system drive and $MFT from the raw volume. Files of a target are archived (zip) and the archive is uploaded to the manager as an endpoint artifact, files making the archive grow above the configured maximum size are skipped",
+			"help": "`collect [TARGET...]`",
+			"example": "`collect evtx prefetch amcache`"
+		}
+	*/
+	case "collect":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if c, err := a.cmdCollect(cmd.Args); err != nil {
+			cmd.ErrorFrom(err)
+		} else {
+			cmd.Json = c
+		}
 	}
 
 	// we finally run the command
@@ -380,6 +1101,8 @@ func (a *Agent) scheduleCleanArchivedTask() error {
 }
 
 func (a *Agent) taskUploadDumps() {
+	defer a.perf.dumps.since(time.Now())
+
 	// Sending dump files over to the manager
 	for wi := range fswalker.Walk(a.config.Dump.Dir) {
 		for _, fi := range wi.Files {
@@ -409,6 +1132,7 @@ func (a *Agent) taskUploadDumps() {
 					for fu := shrink.Next(); fu != nil; fu = shrink.Next() {
 						if err = a.forwarder.Client.PostDump(fu); err != nil {
 							a.logger.Error(err)
+							a.reportError(api.AgentErrorUpload, fi.Name(), err)
 							break
 						}
 					}
@@ -548,6 +1272,17 @@ func (a *Agent) scheduleTasks() {
 			Schedule(inLittleWhile),
 			crony.PrioHigh)
 
+		// pruning expired IoCs
+		a.scheduler.Schedule(crony.NewTask("IoC pruning").
+			Func(func() {
+				task := "[ioc pruning]"
+				if err := a.pruneIoCs(); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(time.Minute).
+			Schedule(inLittleWhile),
+			crony.PrioHigh)
+
 		// command runner routine, we run it only once as it creates a go routine to handle commands
 		a.scheduler.Schedule(
 			crony.NewAsyncTask("Command handler goroutine").
@@ -594,6 +1329,28 @@ func (a *Agent) scheduleTasks() {
 
 		// Low Prio Tasks
 
+		// reporting rules and containers usage
+		a.scheduler.Schedule(crony.NewTask("Usage report").
+			Func(func() {
+				task := "[usage report]"
+				if err := a.reportUsage(); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(UsageReportInterval).
+			Schedule(time.Now().Add(UsageReportInterval)),
+			crony.PrioLow)
+
+		// reporting agent errors
+		a.scheduler.Schedule(crony.NewTask("Error report").
+			Func(func() {
+				task := "[error report]"
+				if err := a.sendErrorReport(); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(ErrorReportInterval).
+			Schedule(time.Now().Add(ErrorReportInterval)),
+			crony.PrioLow)
+
 		// updating system information
 		a.scheduler.Schedule(crony.NewTask("System Info Update").
 			Func(func() {
@@ -618,19 +1375,182 @@ func (a *Agent) scheduleTasks() {
 			a.forwarder.Run()
 		}).Schedule(time.Now()), crony.PrioHigh)
 
+	// routine refreshing agent state stamped on forwarded events
+	a.scheduler.Schedule(crony.NewTask("Event metadata update").
+		Func(a.updateEventMetadata).
+		Ticker(EventMetadataInterval).
+		Schedule(time.Now().Add(EventMetadataInterval)), crony.PrioLow)
+
+	// routine enforcing retention of forwarder's local logs
+	a.scheduler.Schedule(crony.NewTask("Local logs retention").
+		Func(func() {
+			task := "[local logs retention]"
+			if err := a.forwarder.EnforceRetention(); err != nil {
+				a.logger.Error(task, err)
+			}
+		}).Ticker(time.Minute*5).
+		Schedule(inLittleWhile), crony.PrioLow)
+
+	// routine persisting statistics across restarts
+	a.scheduler.Schedule(crony.NewTask("Statistics flush").
+		Func(func() {
+			task := "[statistics flush]"
+			if err := a.saveStats(); err != nil {
+				a.logger.Error(task, err)
+			}
+		}).Ticker(StatsFlushInterval).
+		Schedule(time.Now().Add(StatsFlushInterval)), crony.PrioLow)
+
+	// routine comparing credential providers and LSA packages
+	// registrations to their baseline
+	if a.config.CredConfig.Enable && a.config.CredConfig.Interval > 0 {
+		a.scheduler.Schedule(crony.NewTask("Credential registrations monitoring").
+			Func(func() {
+				task := "[credential registrations monitoring]"
+				if _, err := a.checkCredentialRegistrations(); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(a.config.CredConfig.Interval).
+			Schedule(time.Now()), crony.PrioMedium)
+	}
+
+	// routine collecting host firewall state and listening ports
+	if a.config.InventoryConfig.Enable && a.config.InventoryConfig.Interval > 0 {
+		a.scheduler.Schedule(crony.NewTask("Inventory collection").
+			Func(func() {
+				task := "[inventory collection]"
+				if changes, err := a.collectInventory(); err != nil {
+					a.logger.Error(task, err)
+				} else if len(changes) > 0 && a.config.IsForwardingEnabled() {
+					// pushing inventory right away
+					if err := a.updateSystemInfo(); err != nil {
+						a.logger.Error(task, err)
+					}
+				}
+			}).Ticker(a.config.InventoryConfig.Interval).
+			Schedule(time.Now()), crony.PrioMedium)
+	}
+
+	// routines writing filtered events to local database and
+	// removing the oldest ones
+	if a.eventDB != nil {
+		a.scheduler.Schedule(crony.NewTask("Event database flush").
+			Func(func() {
+				task := "[event database flush]"
+				if dropped, err := a.eventDB.Flush(); err != nil {
+					a.logger.Error(task, err)
+				} else if dropped > 0 {
+					a.logger.Warnf("%s %d filtered events dropped because of a full buffer", task, dropped)
+				}
+			}).Ticker(EventDBFlushInterval).
+			Schedule(time.Now().Add(EventDBFlushInterval)), crony.PrioLow)
+
+		a.scheduler.Schedule(crony.NewTask("Event database pruning").
+			Func(func() {
+				task := "[event database pruning]"
+				if err := a.eventDB.Prune(time.Now()); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(EventDBPruneInterval).
+			Schedule(time.Now()), crony.PrioLow)
+	}
+
+	// routine saving bookmarks of processed events
+	if a.bookmarks != nil {
+		a.scheduler.Schedule(crony.NewTask("Bookmarks save").
+			Func(func() {
+				task := "[bookmarks save]"
+				if err := a.bookmarks.Save(a.db); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(BookmarkSaveInterval).
+			Schedule(time.Now().Add(BookmarkSaveInterval)), crony.PrioLow)
+	}
+
+	// routine forwarding counts of filtered events
+	if a.filterStats != nil {
+		a.scheduler.Schedule(crony.NewTask("Filtered events counts").
+			Func(func() {
+				a.forwardFilterStats()
+			}).Ticker(a.config.FltStatsConfig.Interval).
+			Schedule(time.Now().Add(a.config.FltStatsConfig.Interval)), crony.PrioLow)
+	}
+
+	// routine pruning and saving IoC hits already alerted
+	if a.iocMemory != nil {
+		a.scheduler.Schedule(crony.NewTask("IoC hits save").
+			Func(func() {
+				task := "[ioc hits save]"
+				if err := a.iocMemory.Save(a.db); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Ticker(IoCMemorySaveInterval).
+			Schedule(time.Now().Add(IoCMemorySaveInterval)), crony.PrioLow)
+	}
+
+	// routine refreshing addresses allowed by soft containment
+	a.scheduler.Schedule(crony.NewTask("Soft containment refresh").
+		Func(func() {
+			task := "[soft containment refresh]"
+			if err := a.refreshSoftContainment(); err != nil {
+				a.logger.Error(task, err)
+			}
+		}).Ticker(SoftContainRefresh).
+		Schedule(time.Now()), crony.PrioLow)
+
+	// routine reloading containment state and releasing
+	// host when containment timer expires
+	a.scheduler.Schedule(crony.NewTask("Containment check").
+		Func(func() {
+			task := "[containment check]"
+			if err := a.checkContainment(); err != nil {
+				a.logger.Error(task, err)
+			}
+		}).Ticker(ContainStateCheck).
+		Schedule(time.Now()), crony.PrioHigh)
+
 	// routine managing Sysmon archived files cleanup
 	if err := a.scheduleCleanArchivedTask(); err != nil {
 		a.logger.Error("failed to schedule sysmon archived file cleaning: ", err)
 	}
 
 	// routine creating canary files
-	a.scheduler.Schedule(crony.NewAsyncTask("Canary configuration").
-		Func(func() {
-			task := "[canary configuration]"
-			if err := a.config.CanariesConfig.Configure(); err != nil {
-				a.logger.Error(task, err)
-			}
-		}).Schedule(time.Now()), crony.PrioHigh)
+	if config.FeatureCanaries.Compiled() {
+		a.scheduler.Schedule(crony.NewAsyncTask("Canary configuration").
+			Func(func() {
+				task := "[canary configuration]"
+				if err := a.config.CanariesConfig.Configure(); err != nil {
+					a.logger.Error(task, err)
+				}
+			}).Schedule(time.Now()), crony.PrioHigh)
+	}
+
+	// routine pruning flows tracked by beaconing analyzer
+	if a.config.BeaconingConfig.Enable {
+		a.scheduler.Schedule(crony.NewTask("Beaconing analyzer pruning").
+			Func(func() {
+				a.beacons.Prune(time.Now())
+			}).Ticker(time.Minute*10).
+			Schedule(time.Now()), crony.PrioLow)
+	}
+
+	// routine pruning chains tracked by lateral movement tracker
+	if a.config.LateralConfig.Enable {
+		a.scheduler.Schedule(crony.NewTask("Lateral movement tracker pruning").
+			Func(func() {
+				a.lateral.Prune(time.Now())
+			}).Ticker(time.Minute*10).
+			Schedule(time.Now()), crony.PrioLow)
+	}
+
+	// routine pruning clients tracked by Kerberos anomaly detector
+	if a.kerberos != nil {
+		a.scheduler.Schedule(crony.NewTask("Kerberos anomaly detector pruning").
+			Func(func() {
+				a.kerberos.Prune(time.Now())
+			}).Ticker(time.Minute*10).
+			Schedule(time.Now()), crony.PrioLow)
+	}
 
 	// Action handler scheduling
 	a.scheduler.Schedule(crony.NewAsyncTask("Action Handler").
@@ -4,9 +4,12 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/0xrawsec/golang-utils/fsutil/logfile"
 	"github.com/0xrawsec/whids/agent/config"
 	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/api/client"
 	clientConfig "github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/utils"
 )
 
 func BuildDefaultConfig(root string) *config.Agent {
@@ -14,12 +17,15 @@ func BuildDefaultConfig(root string) *config.Agent {
 	logDir := filepath.Join(root, "Logs")
 	dbDir := filepath.Join(root, "Database")
 
-	return &config.Agent{
+	c := &config.Agent{
 		DatabasePath: filepath.Join(dbDir, "Sod"),
 		RulesConfig: config.Rules{
-			RulesDB:        filepath.Join(dbDir, "Rules"),
-			ContainersDB:   filepath.Join(dbDir, "Containers"),
-			UpdateInterval: 60 * time.Second,
+			RulesDB:         filepath.Join(dbDir, "Rules"),
+			ContainersDB:    filepath.Join(dbDir, "Containers"),
+			SigmaDB:         filepath.Join(dbDir, "Sigma"),
+			UpdateInterval:  60 * time.Second,
+			MaxSuppressions: 1000,
+			SuppressMaxCrit: 7,
 		},
 
 		FwdConfig: clientConfig.Forwarder{
@@ -30,6 +36,168 @@ func BuildDefaultConfig(root string) *config.Agent {
 			Logging: clientConfig.ForwarderLogging{
 				Dir:              filepath.Join(logDir, "Alerts"),
 				RotationInterval: time.Hour * 5,
+				RotationSize:     logfile.MB * 100,
+				MaxSize:          logfile.GB,
+				MaxAge:           time.Hour * 24 * 30,
+				Compress:         true,
+			},
+			File: clientConfig.ForwarderFile{
+				Enable:       false,
+				Path:         filepath.Join(logDir, "Events", "events.json"),
+				RotationSize: logfile.MB * 100,
+			},
+			Syslog: clientConfig.ForwarderSyslog{
+				Enable:   false,
+				Proto:    client.SyslogProtoUDP,
+				Format:   client.SyslogFormatCEF,
+				Facility: "local4",
+			},
+			Splunk: clientConfig.ForwarderSplunk{
+				Enable:        false,
+				Sourcetype:    "whids",
+				BatchSize:     100,
+				FlushInterval: 5 * time.Second,
+				MaxRetries:    5,
+				Mappings:      []*clientConfig.SplunkMapping{},
+			},
+			Elastic: clientConfig.ForwarderElastic{
+				Enable:          false,
+				Index:           "whids",
+				DailyIndices:    true,
+				InstallTemplate: true,
+				BatchSize:       500,
+				FlushInterval:   5 * time.Second,
+				MaxRetries:      5,
+			},
+		},
+		BeaconingConfig: config.Beaconing{
+			Window:         6 * time.Hour,
+			MinConnections: 12,
+			MinInterval:    10 * time.Second,
+			MaxJitter:      0.1,
+			MaxMeanBytes:   4096,
+			MaxPrevalence:  2,
+			MaxFlows:       10000,
+			Criticality:    6,
+			Whitelist: []string{
+				`C:\Program Files (x86)\Google\Update\GoogleUpdate.exe`,
+				`C:\Program Files (x86)\Microsoft\EdgeUpdate\MicrosoftEdgeUpdate.exe`,
+			},
+		},
+		LateralConfig: config.LateralMovement{
+			Enable:      false,
+			Window:      30 * time.Minute,
+			MaxSources:  1000,
+			Criticality: 8,
+			Whitelist:   []string{},
+		},
+		KerberosConfig: config.Kerberos{
+			Enable:         false,
+			RC4Downgrade:   true,
+			RC4AllowedSvcs: []string{},
+			Kerberoasting:  true,
+			Window:         10 * time.Minute,
+			MaxServices:    10,
+			MaxClients:     10000,
+			ForgedTickets:  true,
+			Domains:        []string{},
+			RequireTGT:     false,
+			TGTLifetime:    10 * time.Hour,
+			Criticality:    8,
+		},
+		IoCMemConfig: config.IoCMemory{
+			Enable:     true,
+			TTL:        7 * 24 * time.Hour,
+			MaxEntries: 100000,
+		},
+		FltStatsConfig: config.FilterStats{
+			Enable:     false,
+			Interval:   5 * time.Minute,
+			MaxRecords: 10000,
+		},
+		HashCacheConfig: config.HashCache{
+			Enable:     true,
+			MaxEntries: 50000,
+		},
+		DNSCacheConfig: config.DNSCache{
+			Enable:     true,
+			TTL:        time.Hour,
+			MaxEntries: 50000,
+		},
+		YaraConfig: config.Yara{
+			Enable:        false,
+			ScanFiledumps: true,
+			ScanMemdumps:  true,
+			Timeout:       5 * time.Minute,
+		},
+		PcapConfig: config.PacketCapture{
+			Enable:   false,
+			Args:     []string{"-q", "-a", "duration:" + PcapDuration, "-s", PcapSnapLen, "-f", PcapFilter, "-w", PcapOutput},
+			Duration: 30 * time.Second,
+			SnapLen:  512,
+			MaxSize:  4 * utils.Mega,
+			MaxHosts: 16,
+		},
+		FetchFileConfig: config.FetchFile{
+			MaxSize:   api.DefaultMaxUploadSize,
+			ChunkSize: client.UploadShrinkerBufferSize,
+		},
+		InventoryConfig: config.Inventory{
+			Enable:      true,
+			Interval:    15 * time.Minute,
+			Criticality: 7,
+		},
+		LogTamperConfig: config.LogTamper{
+			Enable:      true,
+			Criticality: 10,
+			Triage:      false,
+		},
+		PrivSepConfig: config.PrivSep{
+			Enable: false,
+			Pipe:   `\\.\pipe\whids-events`,
+			// Local Service
+			Account: "S-1-5-19",
+		},
+		CollectConfig: config.Collect{
+			MaxSize: api.DefaultMaxUploadSize,
+			Targets: []*config.CollectTarget{
+				{
+					Name:  "evtx",
+					Paths: []string{`$SYSTEMROOT\System32\winevt\Logs\*.evtx`},
+				},
+				{
+					Name: "hives",
+					Paths: []string{
+						`$SYSTEMROOT\System32\config\SAM`,
+						`$SYSTEMROOT\System32\config\SECURITY`,
+						`$SYSTEMROOT\System32\config\SOFTWARE`,
+						`$SYSTEMROOT\System32\config\SYSTEM`,
+						`$SYSTEMROOT\System32\config\DEFAULT`,
+						`$SYSTEMDRIVE\Users\*\NTUSER.DAT`,
+						`$SYSTEMDRIVE\Users\*\AppData\Local\Microsoft\Windows\UsrClass.dat`,
+					},
+				},
+				{
+					Name:  "prefetch",
+					Paths: []string{`$SYSTEMROOT\Prefetch\*.pf`},
+				},
+				{
+					Name:  "amcache",
+					Paths: []string{`$SYSTEMROOT\AppCompat\Programs\Amcache.hve`},
+				},
+				{
+					Name: "browsers",
+					Paths: []string{
+						`$SYSTEMDRIVE\Users\*\AppData\Local\Google\Chrome\User Data\*\History`,
+						`$SYSTEMDRIVE\Users\*\AppData\Local\Microsoft\Edge\User Data\*\History`,
+						`$SYSTEMDRIVE\Users\*\AppData\Local\BraveSoftware\Brave-Browser\User Data\*\History`,
+						`$SYSTEMDRIVE\Users\*\AppData\Roaming\Mozilla\Firefox\Profiles\*\places.sqlite`,
+					},
+				},
+				{
+					Name:  "mft",
+					Paths: []string{`$SYSTEMDRIVE\$MFT`},
+				},
 			},
 		},
 		EtwConfig: config.Etw{
@@ -45,6 +213,7 @@ func BuildDefaultConfig(root string) *config.Agent {
 			Bin:              "C:\\Windows\\Sysmon64.exe",
 			ArchiveDirectory: "C:\\Sysmon\\",
 			CleanArchived:    true,
+			Fallback:         true,
 		},
 		Actions: config.Actions{
 			AvailableActions: AvailableActions,
@@ -71,9 +240,38 @@ func BuildDefaultConfig(root string) *config.Agent {
 			}},
 			CommandTimeout: 60 * time.Second,
 		},
+		PipelineConfig: config.Pipeline{
+			Workers:        1,
+			OrderByProcess: true,
+		},
 		AuditConfig: config.Audit{
 			AuditPolicies: []string{"File System"},
 		},
+		ContainConfig: config.Containment{
+			StatePath: filepath.Join(dbDir, "containment.json"),
+			UpdateHosts: []string{
+				"windowsupdate.microsoft.com",
+				"update.microsoft.com",
+				"download.windowsupdate.com",
+				"download.microsoft.com",
+				"ctldl.windowsupdate.com",
+				"fe2.update.microsoft.com",
+				"sls.update.microsoft.com",
+			},
+			SoftAllowedHosts: []string{},
+		},
+		AcqConfig: config.Acquisition{
+			Enable:    false,
+			Args:      []string{AcquisitionOutput},
+			ChunkSize: 64 * utils.Mega,
+			Timeout:   2 * time.Hour,
+		},
+		SourcesConfig: config.CustomSources{
+			Enable:       false,
+			Listen:       "127.0.0.1:1520",
+			MaxEventSize: 64 * utils.Kilo,
+			Sources:      []*config.CustomSource{},
+		},
 		CanariesConfig: config.Canaries{
 			Enable: false,
 			Canaries: []*config.Canary{
@@ -89,10 +287,55 @@ func BuildDefaultConfig(root string) *config.Agent {
 				"C:\\Windows\\explorer.exe",
 			},
 		},
+		RegistryConfig: config.Registry{
+			DumpSize:    4 * utils.Kilo,
+			MaxDumpSize: utils.Mega,
+			RateLimit:   10 * time.Minute,
+			MaxKeys:     1000,
+			Patterns: []*config.RegistryPattern{
+				{
+					Key:   `(?i:\\CurrentVersion\\Run(Once)?\\)`,
+					Value: `[A-Za-z0-9+/]{64,}={0,2}`,
+				},
+			},
+		},
+		EventDBConfig: config.EventDB{
+			Enable:  true,
+			Dir:     filepath.Join(dbDir, "Events"),
+			MaxSize: 256 * utils.Mega,
+			MaxAge:  7 * 24 * time.Hour,
+		},
+		CredConfig: config.CredentialMonitor{
+			Enable:      true,
+			Interval:    5 * time.Minute,
+			Criticality: 8,
+		},
+		BookmarkConfig: config.Bookmarks{
+			Enable: true,
+			Channels: []string{
+				"Microsoft-Windows-Sysmon/Operational",
+				"Security",
+			},
+			MaxGap: 24 * time.Hour,
+		},
+		PrevConfig: config.Prevention{
+			Enable:     false,
+			ReportOnly: true,
+		},
+		DllConfig: config.DllBlocklist{
+			Enable:      false,
+			Containers:  []string{"dll-blocklist"},
+			Terminate:   true,
+			Quarantine:  true,
+			Criticality: 10,
+		},
 		CritTresh:       5,
 		Logfile:         filepath.Join(logDir, "whids.log"),
 		EnableHooks:     true,
 		EnableFiltering: true,
 		Endpoint:        true,
 		LogAll:          false}
+
+	editionDefaults(c)
+	return c
 }
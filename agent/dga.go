@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"compress/gzip"
+	"os"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/whids/utils/dga"
+)
+
+const (
+	// DGAContainerName name of the container holding the n-gram
+	// model used to compute DGA score of DNS queries
+	DGAContainerName = "dga_ngrams"
+)
+
+// shipDGAModel writes the default DGA model container if missing
+func (a *Agent) shipDGAModel() (err error) {
+	if path, _ := a.containerPaths(DGAContainerName); fsutil.IsFile(path) {
+		return
+	}
+
+	return a.writeContainer(DGAContainerName, dga.DefaultNgrams())
+}
+
+// loadDGAModel loads DGA model from its container, it falls
+// back to default model in case of error
+func (a *Agent) loadDGAModel() (err error) {
+	var fd *os.File
+	var r *gzip.Reader
+	var model *dga.Model
+
+	path, _ := a.containerPaths(DGAContainerName)
+
+	// in case of error we use the default model
+	defer func() {
+		if err != nil {
+			a.dgaModel = dga.DefaultModel()
+		}
+	}()
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if r, err = gzip.NewReader(fd); err != nil {
+		return
+	}
+	defer r.Close()
+
+	if model, err = dga.ModelFromReader(r); err != nil {
+		return
+	}
+
+	a.dgaModel = model
+
+	return
+}
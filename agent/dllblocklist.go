@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DllBlocklistRuleName name of the detection reported when
+	// a DLL of a blocklist is loaded
+	DllBlocklistRuleName = "Builtin:DllBlocklist"
+
+	// event ID of the event generated when a blocked DLL is loaded
+	agentDllBlocklistEventID = 4
+
+	// outcomes of the responses to blocked DLL loads
+	dllResponseDone     = "done"
+	dllResponseFailed   = "failed"
+	dllResponseDisabled = "disabled"
+	dllResponseSkipped  = "skipped"
+)
+
+// DllBlocklist maps the hashes of blocked DLLs to the
+// containers (blocklists) they come from
+type DllBlocklist struct {
+	sync.RWMutex
+	hashes map[string]string
+}
+
+// NewDllBlocklist creates an empty DllBlocklist
+func NewDllBlocklist() *DllBlocklist {
+	return &DllBlocklist{hashes: make(map[string]string)}
+}
+
+// Add adds hashes to the blocklist
+func (b *DllBlocklist) Add(container string, hashes ...string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, h := range hashes {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			b.hashes[h] = container
+		}
+	}
+}
+
+// Match returns the hash of the Sysmon Hashes field found in the
+// blocklist and the container it comes from
+func (b *DllBlocklist) Match(hashes string) (hash, container string, ok bool) {
+	b.RLock()
+	defer b.RUnlock()
+
+	for _, h := range sysmonHashesToMap(hashes) {
+		if container, ok = b.hashes[h]; ok {
+			return h, container, true
+		}
+	}
+
+	return
+}
+
+// Len returns the number of blocked hashes
+func (b *DllBlocklist) Len() int {
+	b.RLock()
+	defer b.RUnlock()
+	return len(b.hashes)
+}
+
+// loadDllBlocklist loads the hashes of a blocklist from its container
+func loadDllBlocklist(b *DllBlocklist, name, path string) (err error) {
+	var fd *os.File
+	var r *gzip.Reader
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if r, err = gzip.NewReader(fd); err != nil {
+		return
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		b.Add(name, scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// loadDllBlocklists loads configured DLL blocklists from their containers
+func (a *Agent) loadDllBlocklists() (lastErr error) {
+	b := NewDllBlocklist()
+
+	for _, c := range a.config.DllConfig.Containers {
+		path, _ := a.containerPaths(c)
+		if err := loadDllBlocklist(b, c, path); err != nil {
+			lastErr = fmt.Errorf("failed to load DLL blocklist %s: %w", c, err)
+			a.logger.Error(lastErr)
+		}
+	}
+
+	a.logger.Infof("Number of hashes in DLL blocklists: %d", b.Len())
+	a.dllBlocklist = b
+
+	return
+}
+
+// dllBlockedEvent generates the alert sent when a blocked DLL is loaded
+func (a *Agent) dllBlockedEvent(e *event.EdrEvent, hash, container, termination, quarantine string) *event.EdrEvent {
+	ae := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	ae.System.Channel = agentChannel
+	ae.System.Computer = hostname
+	ae.System.EventID = agentDllBlocklistEventID
+	ae.System.Execution.ProcessID = u32PID
+	ae.System.Provider.Name = agentChannel
+	ae.System.TimeCreated.SystemTime = time.Now()
+
+	for _, p := range []*engine.XPath{pathSysmonImage, pathSysmonProcessId, pathSysmonProcessGUID, pathSysmonImageLoaded, pathSysmonHashes, pathSysmonSignature, pathSysmonSignatureStatus} {
+		if v, ok := e.GetString(p); ok {
+			ae.EventData[p.Last()] = v
+		}
+	}
+	ae.EventData["Hash"] = hash
+	ae.EventData["Blocklist"] = container
+	ae.EventData["Termination"] = termination
+	ae.EventData["Quarantine"] = quarantine
+
+	d := engine.NewDetection(false, false)
+	d.Signature.Add(DllBlocklistRuleName)
+	d.Criticality = a.config.DllConfig.Criticality
+
+	edrEvt := event.NewEdrEvent(ae)
+	edrEvt.NormalizeTime()
+	edrEvt.SetDetection(d)
+
+	return edrEvt
+}
+
+// enforceDllBlocklist terminates the process loading a blocked
+// DLL, quarantines the DLL and reports it to the manager
+func (a *Agent) enforceDllBlocklist(e *event.EdrEvent) {
+	var hashes, image, dll string
+	var pid int64
+	var ok bool
+
+	if a.dllBlocklist == nil {
+		return
+	}
+
+	if hashes, ok = e.GetString(pathSysmonHashes); !ok {
+		return
+	}
+
+	hash, container, blocked := a.dllBlocklist.Match(hashes)
+	if !blocked {
+		return
+	}
+
+	image, _ = e.GetString(pathSysmonImage)
+	dll, _ = e.GetString(pathSysmonImageLoaded)
+	pid, _ = e.GetInt(pathSysmonProcessId)
+
+	a.logger.Warnf("Blocked DLL %s (blocklist %s) loaded by PID=%d Image=%s", dll, container, pid, image)
+
+	termination := dllResponseDisabled
+	if config.FeatureResponse.Compiled() && a.config.DllConfig.Terminate {
+		switch {
+		case pid <= 0 || int(pid) == os.Getpid() || isCriticalImage(image):
+			termination = dllResponseSkipped
+		case terminate(int(pid)) != nil:
+			termination = dllResponseFailed
+		default:
+			termination = dllResponseDone
+		}
+	}
+
+	quarantined := dllResponseDisabled
+	if config.FeatureResponse.Compiled() && a.config.DllConfig.Quarantine {
+		// a DLL still loaded by a process may not be movable
+		if _, err := quarantine(dll); err != nil {
+			a.logger.Errorf("Failed to quarantine blocked DLL %s: %s", dll, err)
+			quarantined = dllResponseFailed
+		} else {
+			quarantined = dllResponseDone
+		}
+	}
+
+	if err := a.forward(a.dllBlockedEvent(e, hash, container, termination, quarantined)); err != nil {
+		a.logger.Errorf("Failed to pipe DLL blocklist event: %s", err)
+	}
+}
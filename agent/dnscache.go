@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+const (
+	// maximum number of names remembered for an IP address
+	dnsCacheMaxNames = 16
+)
+
+// dnsAnswerIPs returns the IP addresses (in canonical form) found in DNS
+// query results, format is the same for Sysmon and DNS-Client events
+func dnsAnswerIPs(results string) (ips []string) {
+	ips = make([]string, 0)
+	for _, r := range strings.Split(results, ";") {
+		if ip := net.ParseIP(strings.TrimSpace(r)); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	return
+}
+
+type dnsEntry struct {
+	// most recently resolved first
+	names    []string
+	lastSeen time.Time
+}
+
+// add adds names in front of the names already known
+func (e *dnsEntry) add(names []string) {
+	merged := make([]string, 0, len(names)+len(e.names))
+	seen := make(map[string]bool)
+
+	for _, list := range [][]string{names, e.names} {
+		for _, n := range list {
+			if key := strings.ToLower(n); n != "" && !seen[key] {
+				seen[key] = true
+				merged = append(merged, n)
+			}
+		}
+	}
+
+	if len(merged) > dnsCacheMaxNames {
+		merged = merged[:dnsCacheMaxNames]
+	}
+
+	e.names = merged
+}
+
+// DNSCache remembers the domains IP addresses were recently resolved from.
+// It is fed by DNS query events (Sysmon and DNS-Client) of all processes
+// so that network connections can be correlated with the domain their
+// destination was resolved from. All methods can be called on a nil DNSCache.
+type DNSCache struct {
+	sync.Mutex
+	config  *config.DNSCache
+	entries map[string]*dnsEntry
+}
+
+// NewDNSCache creates a new DNSCache from configuration
+func NewDNSCache(c *config.DNSCache) *DNSCache {
+	return &DNSCache{
+		config:  c,
+		entries: make(map[string]*dnsEntry),
+	}
+}
+
+func (c *DNSCache) prune(now time.Time) {
+	for ip, e := range c.entries {
+		if now.Sub(e.lastSeen) > c.config.TTL {
+			delete(c.entries, ip)
+		}
+	}
+}
+
+// evict removes the least recently resolved tenth of the entries
+func (c *DNSCache) evict() {
+	ips := make([]string, 0, len(c.entries))
+	for ip := range c.entries {
+		ips = append(ips, ip)
+	}
+
+	sort.Slice(ips, func(i, j int) bool {
+		return c.entries[ips[i]].lastSeen.Before(c.entries[ips[j]].lastSeen)
+	})
+
+	for _, ip := range ips[:len(ips)/10+1] {
+		delete(c.entries, ip)
+	}
+}
+
+// Add remembers that ips were resolved from names at ts, names are
+// the domain queried followed by its aliases (i.e. CNAME)
+func (c *DNSCache) Add(names, ips []string, ts time.Time) {
+	if c == nil || len(names) == 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for _, ip := range ips {
+		e, ok := c.entries[ip]
+		if !ok {
+			if len(c.entries) >= c.config.MaxEntries {
+				c.prune(ts)
+				if len(c.entries) >= c.config.MaxEntries {
+					c.evict()
+				}
+			}
+			e = &dnsEntry{}
+			c.entries[ip] = e
+		}
+
+		e.add(names)
+		if ts.After(e.lastSeen) {
+			e.lastSeen = ts
+		}
+	}
+}
+
+// Names returns the names ip was resolved from within TTL before ts,
+// most recently resolved first
+func (c *DNSCache) Names(ip string, ts time.Time) (names []string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[ip]; ok && ts.Sub(e.lastSeen) <= c.config.TTL {
+		names = make([]string, len(e.names))
+		copy(names, e.names)
+	}
+
+	return
+}
+
+// Len returns the number of IP addresses which resolutions are remembered
+func (c *DNSCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return len(c.entries)
+}
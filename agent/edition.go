@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/api"
+)
+
+var (
+	// manager commands relying on a feature which may not be compiled in
+	commandFeatures = map[string]config.Feature{
+		"acquire-memory":  config.FeatureDump,
+		"dump-memory":     config.FeatureDump,
+		"fetch-file":      config.FeatureDump,
+		"collect":         config.FeatureDump,
+		"vss-collect":     config.FeatureDump,
+		"contain":         config.FeatureResponse,
+		"uncontain":       config.FeatureResponse,
+		"terminate":       config.FeatureResponse,
+		"suspend":         config.FeatureResponse,
+		"resume":          config.FeatureResponse,
+		"block":           config.FeatureResponse,
+		"disable-user":    config.FeatureResponse,
+		"quarantine":      config.FeatureResponse,
+		"isolate-process": config.FeatureResponse,
+		"release-process": config.FeatureResponse,
+		api.RevertCommand: config.FeatureResponse,
+	}
+
+	// manager command options (i.e. uploading artifacts) relying on a
+	// feature which may not be compiled in
+	commandOptionFeatures = map[string]map[string]config.Feature{
+		"sweep":   {"retrieve": config.FeatureDump},
+		"browser": {"retrieve": config.FeatureDump},
+		"srum":    {"retrieve": config.FeatureDump},
+		"reg":     {"export": config.FeatureDump},
+	}
+
+	// playbook steps relying on a feature which may not be compiled in
+	playbookStepFeatures = map[string]config.Feature{
+		api.PlaybookStepMemdump:  config.FeatureDump,
+		api.PlaybookStepFiledump: config.FeatureDump,
+		api.PlaybookStepTriage:   config.FeatureDump,
+		api.PlaybookStepSuspend:  config.FeatureResponse,
+		api.PlaybookStepKill:     config.FeatureResponse,
+		api.PlaybookStepIsolate:  config.FeatureResponse,
+	}
+)
+
+// checkCommandCompiled returns an error if command, or one of
+// its options, relies on a feature not compiled in the agent
+func checkCommandCompiled(name string, args []string) error {
+	if f, ok := commandFeatures[name]; ok {
+		if err := f.Check(); err != nil {
+			return fmt.Errorf("refusing to run command %s: %w", name, err)
+		}
+	}
+
+	for _, arg := range args {
+		if f, ok := commandOptionFeatures[name][arg]; ok {
+			if err := f.Check(); err != nil {
+				return fmt.Errorf("refusing to run command %s %s: %w", name, arg, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkStepCompiled returns an error if playbook step relies
+// on a feature not compiled in the agent
+func checkStepCompiled(action string) error {
+	if f, ok := playbookStepFeatures[action]; ok {
+		return f.Check()
+	}
+	return nil
+}
+
+// editionDefaults disables, in default configuration, the
+// options relying on features not compiled in the agent
+func editionDefaults(c *config.Agent) {
+	c.Actions.AvailableActions = config.CompiledActions(c.Actions.AvailableActions)
+	c.Actions.Low = config.CompiledActions(c.Actions.Low)
+	c.Actions.Medium = config.CompiledActions(c.Actions.Medium)
+	c.Actions.High = config.CompiledActions(c.Actions.High)
+	c.Actions.Critical = config.CompiledActions(c.Actions.Critical)
+	c.CanariesConfig.Actions = config.CompiledActions(c.CanariesConfig.Actions)
+
+	if !config.FeatureDump.Compiled() {
+		c.RegistryConfig.DumpSize = 0
+		c.RegistryConfig.Patterns = []*config.RegistryPattern{}
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+)
+
+var (
+	// ErrorReportInterval interval at which significant errors
+	// are reported to the manager
+	ErrorReportInterval = 5 * time.Minute
+)
+
+// reportError accounts for a significant error to be reported to the manager
+func (a *Agent) reportError(kind, source string, err error) {
+	if err == nil {
+		return
+	}
+
+	a.errors.Lock()
+	defer a.errors.Unlock()
+	a.errors.report.Add(kind, source, err)
+}
+
+// sendErrorReport sends the errors which occurred since last report to the manager
+func (a *Agent) sendErrorReport() (err error) {
+	a.errors.Lock()
+	report := a.errors.report
+	a.errors.report = api.NewAgentErrorReport()
+	a.errors.Unlock()
+
+	if report.Empty() {
+		return
+	}
+
+	report.Until = time.Now().UTC()
+	if err = a.forwarder.Client.PostAgentErrorReport(report); err != nil {
+		// we keep errors for next report
+		a.errors.Lock()
+		a.errors.report.Merge(report)
+		a.errors.Unlock()
+	}
+
+	return
+}
@@ -0,0 +1,410 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/logger"
+)
+
+const (
+	// key under which filtered events are logged
+	eventDBKey = "filtered"
+	// basename of event database logfiles
+	eventDBBase = "events.gz"
+	// logfiles bigger than this are rotated
+	eventDBLogfileSize = 16 * 1024 * 1024
+	// events buffered before being written to disk
+	eventDBMaxBuffer = 4096
+)
+
+var (
+	// EventDBFlushInterval interval at which buffered events are written to disk
+	EventDBFlushInterval = 10 * time.Second
+	// EventDBPruneInterval interval at which oldest events are removed
+	EventDBPruneInterval = 5 * time.Minute
+	// EventQueryDefaultLast period queried if no time range is given
+	EventQueryDefaultLast = time.Hour
+	// EventQueryDefaultLimit maximum number of events returned by default
+	EventQueryDefaultLimit = 1000
+	// EventQueryMaxLimit maximum number of events a query can return
+	EventQueryMaxLimit = 10000
+)
+
+// eventFilter filters events on the value of a field
+type eventFilter struct {
+	field string
+	value string
+	re    *regexp.Regexp
+}
+
+// parseEventFilter parses a filter formatted as FIELD=VALUE (case
+// insensitive equality) or FIELD~=REGEX
+func parseEventFilter(s string) (f *eventFilter, err error) {
+	var i int
+
+	f = &eventFilter{}
+
+	if i = strings.Index(s, "~="); i > 0 {
+		f.field = s[:i]
+		if f.re, err = regexp.Compile(s[i+2:]); err != nil {
+			return nil, fmt.Errorf("bad filter regexp: %w", err)
+		}
+		return
+	}
+
+	if i = strings.Index(s, "="); i > 0 {
+		f.field, f.value = s[:i], s[i+1:]
+		return
+	}
+
+	return nil, fmt.Errorf("bad filter %s, expecting FIELD=VALUE or FIELD~=REGEX", s)
+}
+
+func (f *eventFilter) match(e *event.EdrEvent) bool {
+	var value string
+
+	switch f.field {
+	case "Channel":
+		value = e.Channel()
+	case "EventID":
+		value = strconv.FormatInt(e.EventID(), 10)
+	default:
+		i, ok := e.Event.GetProperty(f.field)
+		if !ok {
+			return false
+		}
+		value = fmt.Sprintf("%v", i)
+	}
+
+	if f.re != nil {
+		return f.re.MatchString(value)
+	}
+
+	return strings.EqualFold(value, f.value)
+}
+
+// EventQuery is a query run against the local database of filtered events
+type EventQuery struct {
+	Start   time.Time `json:"start"`
+	Stop    time.Time `json:"stop"`
+	Limit   int       `json:"limit"`
+	Filters []string  `json:"filters"`
+
+	filters []*eventFilter
+}
+
+// ParseEventQuery parses query-events command arguments. Time range is given
+// with since=TIME, until=TIME (RFC3339), last=DURATION or pivot=TIME and
+// delta=DURATION (events around pivot), other arguments are field filters.
+func ParseEventQuery(args []string, now time.Time) (q *EventQuery, err error) {
+	var last, delta time.Duration
+	var pivot time.Time
+
+	q = &EventQuery{
+		Stop:    now,
+		Limit:   EventQueryDefaultLimit,
+		Filters: make([]string, 0),
+		filters: make([]*eventFilter, 0),
+	}
+
+	for _, arg := range args {
+		key, value := arg, ""
+		if i := strings.Index(arg, "="); i > 0 {
+			key, value = arg[:i], arg[i+1:]
+		}
+
+		switch key {
+		case "since":
+			q.Start, err = time.Parse(time.RFC3339, value)
+		case "until":
+			q.Stop, err = time.Parse(time.RFC3339, value)
+		case "pivot":
+			pivot, err = time.Parse(time.RFC3339, value)
+		case "last":
+			last, err = time.ParseDuration(value)
+		case "delta":
+			delta, err = time.ParseDuration(value)
+		case "limit":
+			q.Limit, err = strconv.Atoi(value)
+		default:
+			var f *eventFilter
+			if f, err = parseEventFilter(arg); err == nil {
+				q.Filters = append(q.Filters, arg)
+				q.filters = append(q.filters, f)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("bad argument %s: %w", arg, err)
+		}
+	}
+
+	switch {
+	case !pivot.IsZero():
+		if delta <= 0 {
+			delta = 5 * time.Minute
+		}
+		q.Start, q.Stop = pivot.Add(-delta), pivot.Add(delta)
+	case last > 0:
+		q.Start = q.Stop.Add(-last)
+	case q.Start.IsZero():
+		q.Start = q.Stop.Add(-EventQueryDefaultLast)
+	}
+
+	if !q.Start.Before(q.Stop) {
+		return nil, fmt.Errorf("query start must be before its end")
+	}
+
+	if q.Limit <= 0 || q.Limit > EventQueryMaxLimit {
+		return nil, fmt.Errorf("query limit must be in ]0; %d]", EventQueryMaxLimit)
+	}
+
+	return
+}
+
+func (q *EventQuery) match(e *event.EdrEvent) bool {
+	for _, f := range q.filters {
+		if !f.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// EventQueryResult is the result of an EventQuery
+type EventQueryResult struct {
+	Query EventQuery `json:"query"`
+	// more events than limit matched the query
+	Truncated bool `json:"truncated"`
+	// events sorted from the oldest to the newest
+	Events []*event.EdrEvent `json:"events"`
+}
+
+// EventDB is a bounded local database of filtered events, oldest
+// events are removed once database size or age limits are reached
+type EventDB struct {
+	sync.Mutex
+	config *config.EventDB
+	logger *logger.EventLogger
+	buffer []*logger.RawEvent
+	// events dropped because buffer was full
+	dropped int
+}
+
+// NewEventDB creates a new EventDB from configuration
+func NewEventDB(c *config.EventDB) *EventDB {
+	return &EventDB{
+		config: c,
+		logger: logger.NewEventLogger(c.Dir, eventDBBase, eventDBLogfileSize),
+		buffer: make([]*logger.RawEvent, 0),
+	}
+}
+
+// Add adds an event to the database, events are buffered until Flush is called
+func (db *EventDB) Add(e *event.EdrEvent) (err error) {
+	var re *logger.RawEvent
+
+	// event is encoded right away as it may be modified
+	// once it has gone through the pipeline
+	if re, err = logger.NewRawEvent(e); err != nil {
+		return
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	if len(db.buffer) >= eventDBMaxBuffer {
+		db.dropped++
+		return
+	}
+
+	db.buffer = append(db.buffer, re)
+	return
+}
+
+func (db *EventDB) flush() (err error) {
+	if len(db.buffer) == 0 {
+		return
+	}
+
+	id := db.logger.InitTransaction()
+	for _, re := range db.buffer {
+		if _, err = db.logger.WriteRawEvent(id, eventDBKey, re); err != nil {
+			break
+		}
+	}
+
+	if cerr := db.logger.CommitTransaction(); err == nil {
+		err = cerr
+	}
+
+	db.buffer = db.buffer[:0]
+	return
+}
+
+// Flush writes buffered events to disk, it returns the number of
+// events dropped since last flush because buffer was full
+func (db *EventDB) Flush() (dropped int, err error) {
+	db.Lock()
+	defer db.Unlock()
+
+	dropped, db.dropped = db.dropped, 0
+	return dropped, db.flush()
+}
+
+// eventDBHour is a directory holding the events of an hour
+type eventDBHour struct {
+	path string
+	time time.Time
+	size int64
+}
+
+func (db *EventDB) hours() (hours []*eventDBHour, err error) {
+	var days, hhs []os.DirEntry
+
+	root := filepath.Join(db.config.Dir, eventDBKey)
+	if days, err = os.ReadDir(root); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	hours = make([]*eventDBHour, 0)
+	for _, day := range days {
+		dayPath := filepath.Join(root, day.Name())
+		if hhs, err = os.ReadDir(dayPath); err != nil {
+			return
+		}
+
+		for _, hh := range hhs {
+			t, err := time.Parse("2006010215", day.Name()+hh.Name())
+			if err != nil {
+				// not a directory created by the database
+				continue
+			}
+
+			h := &eventDBHour{path: filepath.Join(dayPath, hh.Name()), time: t}
+			filepath.Walk(h.path, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() {
+					h.size += info.Size()
+				}
+				return nil
+			})
+			hours = append(hours, h)
+		}
+	}
+
+	sort.Slice(hours, func(i, j int) bool { return hours[i].time.Before(hours[j].time) })
+	return
+}
+
+// Prune removes the oldest events once database size or age limits are
+// reached, events are removed by hour
+func (db *EventDB) Prune(now time.Time) (err error) {
+	var hours []*eventDBHour
+	var size int64
+
+	db.Lock()
+	defer db.Unlock()
+
+	if hours, err = db.hours(); err != nil {
+		return
+	}
+
+	for _, h := range hours {
+		size += h.size
+	}
+
+	for _, h := range hours {
+		expired := db.config.MaxAge > 0 && h.time.Add(time.Hour).Before(now.Add(-db.config.MaxAge))
+		full := db.config.MaxSize > 0 && size > db.config.MaxSize
+
+		if !expired && !full {
+			break
+		}
+
+		if err = os.RemoveAll(h.path); err != nil {
+			return
+		}
+		size -= h.size
+	}
+
+	// removing empty day directories
+	days, _ := os.ReadDir(filepath.Join(db.config.Dir, eventDBKey))
+	for _, day := range days {
+		dayPath := filepath.Join(db.config.Dir, eventDBKey, day.Name())
+		if hhs, err := os.ReadDir(dayPath); err == nil && len(hhs) == 0 {
+			os.Remove(dayPath)
+		}
+	}
+
+	return
+}
+
+// Query runs a query against the database
+func (db *EventDB) Query(q *EventQuery) (r *EventQueryResult, err error) {
+	db.Lock()
+	defer db.Unlock()
+
+	// buffered events must be queryable
+	if err = db.flush(); err != nil {
+		return
+	}
+
+	r = &EventQueryResult{Query: *q, Events: make([]*event.EdrEvent, 0)}
+
+	searcher := logger.NewEventSearcher(db.config.Dir)
+	defer searcher.Close()
+
+	for raw := range searcher.Events(q.Start, q.Stop, eventDBKey, math.MaxInt, 0) {
+		// channel must be drained
+		if r.Truncated {
+			continue
+		}
+
+		e, err := raw.Event()
+		if err != nil || !q.match(e) {
+			continue
+		}
+
+		if len(r.Events) == q.Limit {
+			r.Truncated = true
+			continue
+		}
+
+		r.Events = append(r.Events, e)
+	}
+
+	if err = searcher.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(r.Events, func(i, j int) bool {
+		return r.Events[i].Timestamp().Before(r.Events[j].Timestamp())
+	})
+
+	return
+}
+
+// Close flushes buffered events and closes the database
+func (db *EventDB) Close() (err error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if err = db.flush(); err != nil {
+		return
+	}
+	return db.logger.Close()
+}
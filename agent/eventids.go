@@ -34,6 +34,42 @@ const (
 const (
 	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4663
 	SecurityAccessObject = 4663
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4624
+	SecurityLogon = 4624
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4768
+	SecurityTGTRequest = 4768
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4769
+	SecurityTGSRequest = 4769
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4688
+	SecurityProcessCreate = 4688
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4689
+	SecurityProcessTerminate = 4689
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4697
+	SecurityServiceInstall = 4697
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-4698
+	SecurityTaskCreate = 4698
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-5140
+	SecurityShareAccess = 5140
+	// https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/event-5145
+	SecurityShareObjectAccess = 5145
+)
+
+const (
+	// network logon type of Security logon events
+	LogonTypeNetwork = "3"
+)
+
+// Microsoft-Windows-DNS-Client/Operational
+const (
+	DNSClientQueryCompleted = 3008
+)
+
+// Microsoft-Windows-Kernel-Network/Analytic
+const (
+	KernelNetworkTCPSendIPv4 = 10
+	KernelNetworkTCPSendIPv6 = 26
+	KernelNetworkUDPSendIPv4 = 42
+	KernelNetworkUDPSendIPv6 = 58
 )
 
 // Microsoft-Windows-Kernel-File/Analytic
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-win32/win32"
+	"github.com/0xrawsec/golang-win32/win32/wevtapi"
+)
+
+const (
+	// DefaultEvtQueryMax default number of events returned by evtquery command
+	DefaultEvtQueryMax = 100
+	// MaxEvtQueryMax maximum number of events evtquery command can return
+	MaxEvtQueryMax = 10000
+)
+
+// EvtQueryResult holds the events matching an event log query
+type EvtQueryResult struct {
+	Channel string `json:"channel"`
+	Query   string `json:"query"`
+	// events are sorted from the most recent to the oldest
+	Events []*etw.Event `json:"events"`
+	// true if more events than returned are matching the query
+	Truncated bool `json:"truncated"`
+}
+
+// parseEvtQueryArgs parses the arguments of evtquery command
+func parseEvtQueryArgs(args []string) (channel, query string, max int, err error) {
+	max = DefaultEvtQueryMax
+
+	if len(args) < 2 {
+		err = fmt.Errorf("missing arguments")
+		return
+	}
+
+	channel, query = args[0], args[1]
+
+	if len(args) > 2 {
+		if max, err = strconv.Atoi(args[2]); err != nil {
+			err = fmt.Errorf("bad maximum number of events: %w", err)
+			return
+		}
+		if max <= 0 || max > MaxEvtQueryMax {
+			err = fmt.Errorf("maximum number of events must be within [1; %d]", MaxEvtQueryMax)
+			return
+		}
+	}
+
+	return
+}
+
+// cmdEvtQuery runs a structured XPath query against an event log
+// channel and returns at most max events, the most recent first
+func (a *Agent) cmdEvtQuery(channel, query string, max int) (r *EvtQueryResult, err error) {
+	var h wevtapi.EVT_HANDLE
+
+	if h, err = evtQuery(channel, query, evtQueryChannelPath|evtQueryReverseDirection); err != nil {
+		return nil, fmt.Errorf("failed to query channel %s: %w", channel, err)
+	}
+	defer wevtapi.EvtClose(h)
+
+	r = &EvtQueryResult{
+		Channel: channel,
+		Query:   query,
+		Events:  make([]*etw.Event, 0),
+	}
+
+	for a.ctx.Err() == nil && !r.Truncated {
+		handles, err := wevtapi.EvtNext(h, win32.INFINITE)
+
+		for _, eh := range handles {
+			if len(r.Events) == max {
+				r.Truncated = true
+			} else if data, err := wevtapi.EvtRenderXML(eh); err != nil {
+				a.logger.Errorf("Failed to render event: %s", err)
+			} else if e, err := xmlToEvent(data); err != nil {
+				a.logger.Errorf("Failed to parse event: %s", err)
+			} else {
+				r.Events = append(r.Events, e)
+			}
+			wevtapi.EvtClose(eh)
+		}
+
+		if err != nil {
+			if err == syscall.Errno(win32.ERROR_NO_MORE_ITEMS) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read events of %s: %w", channel, err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-win32/win32"
+	"github.com/0xrawsec/golang-win32/win32/wevtapi"
+)
+
+const (
+	// EvtxExt extension of Windows event log files
+	EvtxExt = ".evtx"
+
+	// EvtQuery flags (see winevt.h)
+	evtQueryChannelPath      = 0x1
+	evtQueryFilePath         = 0x2
+	evtQueryForwardDirection = 0x100
+	evtQueryReverseDirection = 0x200
+)
+
+var (
+	procEvtQuery = syscall.NewLazyDLL("wevtapi.dll").NewProc("EvtQuery")
+)
+
+// evtQuery runs a structured XPath query against a channel or
+// an .evtx file, depending on flags, events are read forward
+// unless evtQueryReverseDirection is set
+func evtQuery(path, query string, flags uintptr) (h wevtapi.EVT_HANDLE, err error) {
+	var ppath, pquery *uint16
+
+	if ppath, err = syscall.UTF16PtrFromString(path); err != nil {
+		return
+	}
+
+	if pquery, err = syscall.UTF16PtrFromString(query); err != nil {
+		return
+	}
+
+	if flags&evtQueryReverseDirection == 0 {
+		flags |= evtQueryForwardDirection
+	}
+
+	r1, _, lastErr := procEvtQuery.Call(
+		0,
+		uintptr(unsafe.Pointer(ppath)),
+		uintptr(unsafe.Pointer(pquery)),
+		flags)
+
+	if r1 == 0 {
+		return 0, lastErr
+	}
+
+	return wevtapi.EVT_HANDLE(r1), nil
+}
+
+// evtxFiles expands paths into the list of .evtx files
+// to replay, directories are walked recursively
+func evtxFiles(paths []string) (files []string, err error) {
+	files = make([]string, 0)
+
+	for _, path := range paths {
+		var fi os.FileInfo
+
+		if fi, err = os.Stat(path); err != nil {
+			return
+		}
+
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && strings.EqualFold(filepath.Ext(p), EvtxExt) {
+				files = append(files, p)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// parseUint parses an unsigned integer of an XML event, hexadecimal
+// values (i.e. keywords) are prefixed with 0x
+func parseUint(s string, bitSize int) (u uint64, err error) {
+	if s == "" {
+		return
+	}
+	if strings.HasPrefix(s, "0x") {
+		return strconv.ParseUint(s[2:], 16, bitSize)
+	}
+	return strconv.ParseUint(s, 10, bitSize)
+}
+
+// xmlToEvent converts an event rendered as XML by the Windows
+// Event Log API into an event processed like any ETW event
+func xmlToEvent(data []byte) (e *etw.Event, err error) {
+	var u uint64
+	var xe wevtapi.XMLEvent
+
+	if err = xml.Unmarshal(data, &xe); err != nil {
+		return
+	}
+
+	e = etw.NewEvent()
+	s := xe.System
+
+	e.System.Channel = s.Channel
+	e.System.Computer = s.Computer
+	e.System.Provider.Name = s.Provider.Name
+	e.System.Provider.Guid = s.Provider.Guid
+
+	if u, err = parseUint(s.EventID, 16); err != nil {
+		return nil, fmt.Errorf("bad EventID: %w", err)
+	}
+	e.System.EventID = uint16(u)
+
+	if u, err = parseUint(s.Level, 8); err != nil {
+		return nil, fmt.Errorf("bad Level: %w", err)
+	}
+	e.System.Level.Value = uint8(u)
+
+	if u, err = parseUint(s.Task, 8); err != nil {
+		return nil, fmt.Errorf("bad Task: %w", err)
+	}
+	e.System.Task.Value = uint8(u)
+
+	if u, err = parseUint(s.Opcode, 8); err != nil {
+		return nil, fmt.Errorf("bad Opcode: %w", err)
+	}
+	e.System.Opcode.Value = uint8(u)
+
+	if u, err = parseUint(s.Keywords, 64); err != nil {
+		return nil, fmt.Errorf("bad Keywords: %w", err)
+	}
+	e.System.Keywords.Value = u
+
+	if u, err = parseUint(s.Execution.ProcessID, 32); err != nil {
+		return nil, fmt.Errorf("bad ProcessID: %w", err)
+	}
+	e.System.Execution.ProcessID = uint32(u)
+
+	if u, err = parseUint(s.Execution.ThreadID, 32); err != nil {
+		return nil, fmt.Errorf("bad ThreadID: %w", err)
+	}
+	e.System.Execution.ThreadID = uint32(u)
+
+	if s.TimeCreated.SystemTime != "" {
+		if e.System.TimeCreated.SystemTime, err = time.Parse(time.RFC3339Nano, s.TimeCreated.SystemTime); err != nil {
+			return nil, fmt.Errorf("bad SystemTime: %w", err)
+		}
+	}
+
+	for i, d := range xe.EventData.Data {
+		name := d.Name
+		// classic events may have unnamed data
+		if name == "" {
+			name = fmt.Sprintf("Data%d", i)
+		}
+		e.EventData[name] = html.UnescapeString(d.Value)
+	}
+
+	if len(xe.UserData) > 0 {
+		e.UserData = map[string]interface{}(xe.UserData)
+	}
+
+	return
+}
+
+// readQuery sends to c the events returned by query, it returns
+// the number of events read
+func (a *Agent) readQuery(query wevtapi.EVT_HANDLE, c chan *etw.Event) (n int, err error) {
+	for a.ctx.Err() == nil {
+		handles, err := wevtapi.EvtNext(query, win32.INFINITE)
+
+		for _, h := range handles {
+			if data, err := wevtapi.EvtRenderXML(h); err != nil {
+				a.logger.Errorf("Failed to render event: %s", err)
+			} else if e, err := xmlToEvent(data); err != nil {
+				a.logger.Errorf("Failed to parse event: %s", err)
+			} else {
+				select {
+				case c <- e:
+					n++
+				case <-a.ctx.Done():
+				}
+			}
+			wevtapi.EvtClose(h)
+		}
+
+		if err != nil {
+			if err == syscall.Errno(win32.ERROR_NO_MORE_ITEMS) {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+
+	return
+}
+
+// readEvtx reads all the events of an .evtx file and sends them to c
+func (a *Agent) readEvtx(path string, c chan *etw.Event) (err error) {
+	var query wevtapi.EVT_HANDLE
+
+	if query, err = evtQuery(path, "*", evtQueryFilePath); err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer wevtapi.EvtClose(query)
+
+	if _, err = a.readQuery(query, c); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return
+}
+
+// openEvtxReplay replays saved .evtx files, events go through the same
+// processing than events received from ETW
+func (a *Agent) openEvtxReplay() (c chan *etw.Event, err error) {
+	var files []string
+
+	if files, err = evtxFiles(a.ReplayEvtx); err != nil {
+		return
+	}
+
+	c = make(chan *etw.Event)
+	go func() {
+		defer close(c)
+		for _, f := range files {
+			a.logger.Infof("Replaying events from %s", f)
+			if err := a.readEvtx(f, c); err != nil {
+				a.logger.Errorf("Failed to replay events: %s", err)
+			}
+		}
+		a.logger.Infof("Done replaying events from %d EVTX files", len(files))
+	}()
+
+	return
+}
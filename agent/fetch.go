@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/api/client"
+)
+
+// FetchedFile describes a file retrieved from the endpoint by the manager
+type FetchedFile struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"modtime"`
+	Hashes  map[string]string `json:"hashes"`
+	Chunks  int               `json:"chunks"`
+	// path of the file, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact"`
+}
+
+// fetchFile streams a file to the manager, by chunks, where it is stored as
+// an endpoint artifact. Files are grouped by command so that several versions
+// of the same file can be told apart.
+func (a *Agent) fetchFile(cmdUUID, path string) (f *FetchedFile, err error) {
+	var fi os.FileInfo
+	var shrink *client.UploadShrinker
+
+	c := a.config.FetchFileConfig
+
+	if fi, err = os.Stat(path); err != nil {
+		return
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file: %s", path)
+	}
+
+	if fi.Size() > c.MaxSize {
+		return nil, fmt.Errorf("file size %d is above allowed limit of %d bytes", fi.Size(), c.MaxSize)
+	}
+
+	f = &FetchedFile{
+		Path:    path,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UTC(),
+	}
+
+	if f.Hashes, err = a.hashCache.Hashes(path); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	// directory layout expected by manager is GUID/HASH/FILE
+	ehash := strings.ReplaceAll(cmdUUID, "-", "")
+	if shrink, err = client.NewUploadShrinkerWithChunkSize(path, nullGUID, ehash, c.ChunkSize); err != nil {
+		return nil, err
+	}
+	defer shrink.Close()
+
+	for fu := shrink.Next(); fu != nil; fu = shrink.Next() {
+		if err = a.forwarder.Client.PostDump(fu); err != nil {
+			a.reportError(api.AgentErrorUpload, filepath.Base(path), err)
+			return nil, fmt.Errorf("failed to upload chunk %d/%d: %w", fu.Chunk, fu.Total, err)
+		}
+		f.Chunks++
+	}
+
+	if err = shrink.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	f.Artifact = filepath.Join(nullGUID, ehash, filepath.Base(path))
+	a.logger.Infof("file %s sent to manager in %d chunks", path, f.Chunks)
+
+	return
+}
@@ -19,9 +19,10 @@ var (
 	fltTrack           = NewFilter([]int64{SysmonProcessCreate, SysmonDriverLoad}, sysmonChannel)
 	fltProcTermination = NewFilter([]int64{SysmonProcessTerminate}, sysmonChannel)
 	fltImageLoad       = NewFilter([]int64{SysmonImageLoad}, sysmonChannel)
+	fltNetworkConnect  = NewFilter([]int64{SysmonNetworkConnect}, sysmonChannel)
 	fltRegSetValue     = NewFilter([]int64{SysmonRegSetValue}, sysmonChannel)
 	//fltNetwork         = NewFilter([]int64{SysmonNetworkConnect, SysmonDNSQuery}, sysmonChannel)
-	//fltDNS             = NewFilter([]int64{SysmonDNSQuery}, sysmonChannel)
+	fltDNS            = NewFilter([]int64{SysmonDNSQuery}, sysmonChannel)
 	fltClipboard      = NewFilter([]int64{SysmonClipboardChange}, sysmonChannel)
 	fltImageTampering = NewFilter([]int64{SysmonProcessTampering}, sysmonChannel)
 
@@ -39,6 +40,13 @@ var (
 		SysmonFileDelete,
 		SysmonFileDeleteDetected},
 		sysmonChannel)
+
+	fltFileChange = NewFilter([]int64{
+		SysmonFileTime,
+		SysmonFileCreate,
+		SysmonFileDelete,
+		SysmonFileDeleteDetected},
+		sysmonChannel)
 )
 
 // Security channel related
@@ -47,6 +55,21 @@ var (
 	securityChannel = "Security"
 	// Security filters
 	fltFSObjectAccess = NewFilter([]int64{SecurityAccessObject}, securityChannel)
+	fltLateral        = NewFilter([]int64{
+		SecurityLogon,
+		SecurityServiceInstall,
+		SecurityTaskCreate,
+		SecurityShareAccess,
+		SecurityShareObjectAccess},
+		securityChannel)
+	fltKerberos     = NewFilter([]int64{SecurityTGTRequest, SecurityTGSRequest}, securityChannel)
+	fltProcessAudit = NewFilter([]int64{SecurityProcessCreate, SecurityProcessTerminate}, securityChannel)
+)
+
+// DNS-Client related
+var (
+	dnsClientChannel = "Microsoft-Windows-DNS-Client/Operational"
+	fltDNSClient     = NewFilter([]int64{DNSClientQueryCompleted}, dnsClientChannel)
 )
 
 // ETW Kernel File related
@@ -63,6 +86,17 @@ var (
 		kernelFileChannel)
 )
 
+// ETW Kernel Network related
+var (
+	kernelNetworkChannel = "Microsoft-Windows-Kernel-Network/Analytic"
+	fltKernelNetworkSend = NewFilter([]int64{
+		KernelNetworkTCPSendIPv4,
+		KernelNetworkTCPSendIPv6,
+		KernelNetworkUDPSendIPv4,
+		KernelNetworkUDPSendIPv6},
+		kernelNetworkChannel)
+)
+
 // Filter structure
 type Filter struct {
 	EventIDs *datastructs.SyncedSet
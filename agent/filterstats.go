@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// FilterStatsOverflow rule name under which are counted the
+	// filtered events exceeding the maximum number of counts
+	FilterStatsOverflow = "Overflow"
+
+	// event ID of the events holding filtered event counts
+	agentFilterStatsEventID = 6
+)
+
+type filterStatsKey struct {
+	rule     string
+	computer string
+	channel  string
+	eventID  int64
+}
+
+// FilterCount is the number of events filtered by a rule within a period
+type FilterCount struct {
+	Rule      string
+	Computer  string
+	Channel   string
+	EventID   int64
+	Count     uint64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// FilterStats aggregates filtered events into counts per rule, host,
+// channel and event ID so that counts can be forwarded instead of events
+type FilterStats struct {
+	sync.Mutex
+	config  *config.FilterStats
+	filters []*engine.CompiledRule
+	counts  map[filterStatsKey]*FilterCount
+	since   time.Time
+}
+
+// NewFilterStats creates a new FilterStats from configuration
+func NewFilterStats(c *config.FilterStats) *FilterStats {
+	return &FilterStats{
+		config:  c,
+		filters: make([]*engine.CompiledRule, 0),
+		counts:  make(map[filterStatsKey]*FilterCount),
+		since:   time.Now(),
+	}
+}
+
+// SetRules must be called when a new engine is loaded so that
+// the filters matching events can be known
+func (s *FilterStats) SetRules(e *engine.Engine) {
+	filters := make([]*engine.CompiledRule, 0)
+	for _, name := range e.GetRuleNames() {
+		if r := e.GetCRuleByName(name); r != nil && r.Filter {
+			filters = append(filters, r)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.filters = filters
+}
+
+// matchingFilters returns the names of the filters matching an event,
+// the engine does not report them when only filters matched
+func (s *FilterStats) matchingFilters(e *event.EdrEvent) (names []string) {
+	names = make([]string, 0)
+	for _, r := range s.filters {
+		if r.Match(e) {
+			names = append(names, r.Name)
+		}
+	}
+	return
+}
+
+// Add accounts a filtered event for every rule it matched, rules
+// are the names returned by the engine (empty if only filters matched)
+func (s *FilterStats) Add(e *event.EdrEvent, rules []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(rules) == 0 {
+		rules = s.matchingFilters(e)
+	}
+
+	ts := e.Timestamp()
+	for _, rule := range rules {
+		k := filterStatsKey{rule, e.Computer(), e.Channel(), e.EventID()}
+
+		c, ok := s.counts[k]
+		if !ok && len(s.counts) >= s.config.MaxRecords {
+			k = filterStatsKey{rule: FilterStatsOverflow}
+			c, ok = s.counts[k]
+		}
+
+		if !ok {
+			c = &FilterCount{
+				Rule:      k.rule,
+				Computer:  k.computer,
+				Channel:   k.channel,
+				EventID:   k.eventID,
+				FirstSeen: ts,
+			}
+			s.counts[k] = c
+		}
+
+		c.Count++
+		if ts.Before(c.FirstSeen) {
+			c.FirstSeen = ts
+		}
+		if ts.After(c.LastSeen) {
+			c.LastSeen = ts
+		}
+	}
+}
+
+// Len returns the number of counts of the current period
+func (s *FilterStats) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.counts)
+}
+
+// Flush returns the counts of the period ending at now, sorted by
+// rule, and starts a new period
+func (s *FilterStats) Flush(now time.Time) (since time.Time, counts []*FilterCount) {
+	s.Lock()
+	defer s.Unlock()
+
+	since = s.since
+	counts = make([]*FilterCount, 0, len(s.counts))
+	for _, c := range s.counts {
+		counts = append(counts, c)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Rule != counts[j].Rule {
+			return counts[i].Rule < counts[j].Rule
+		}
+		return counts[i].Count > counts[j].Count
+	})
+
+	s.counts = make(map[filterStatsKey]*FilterCount)
+	s.since = now
+
+	return
+}
+
+// filterStatsEvent generates the event holding a count of filtered events
+func filterStatsEvent(since, until time.Time, c *FilterCount) *event.EdrEvent {
+	e := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	e.System.Channel = agentChannel
+	e.System.Computer = hostname
+	e.System.EventID = agentFilterStatsEventID
+	e.System.Execution.ProcessID = u32PID
+	e.System.Provider.Name = agentChannel
+	e.System.TimeCreated.SystemTime = until
+	e.EventData["Rule"] = c.Rule
+	e.EventData["FilteredComputer"] = c.Computer
+	e.EventData["FilteredChannel"] = c.Channel
+	e.EventData["FilteredEventID"] = c.EventID
+	e.EventData["Count"] = c.Count
+	e.EventData["FirstSeen"] = c.FirstSeen.UTC().Format(time.RFC3339Nano)
+	e.EventData["LastSeen"] = c.LastSeen.UTC().Format(time.RFC3339Nano)
+	e.EventData["PeriodStart"] = since.UTC().Format(time.RFC3339Nano)
+	e.EventData["PeriodEnd"] = until.UTC().Format(time.RFC3339Nano)
+
+	edrEvt := event.NewEdrEvent(e)
+	edrEvt.NormalizeTime()
+
+	return edrEvt
+}
+
+// forwardFilterStats forwards the counts of filtered events of the last period
+func (a *Agent) forwardFilterStats() {
+	now := time.Now()
+	since, counts := a.filterStats.Flush(now)
+
+	for _, c := range counts {
+		a.pipeEvent(filterStatsEvent(since, now, c))
+	}
+}
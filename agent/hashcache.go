@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// hashFile computes md5, sha1, sha256 and sha512 of a file
+func hashFile(r io.Reader) (hashes map[string]string, err error) {
+	algos := map[string]hash.Hash{
+		"md5":    md5.New(),
+		"sha1":   sha1.New(),
+		"sha256": sha256.New(),
+		"sha512": sha512.New(),
+	}
+
+	writers := make([]io.Writer, 0, len(algos))
+	for _, h := range algos {
+		writers = append(writers, h)
+	}
+
+	if _, err = io.CopyBuffer(io.MultiWriter(writers...), r, make([]byte, 4*utils.Mega)); err != nil {
+		return
+	}
+
+	hashes = make(map[string]string)
+	for name, h := range algos {
+		hashes[name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return
+}
+
+// fileKey identifies a version of a file, if any of its fields
+// changes the file is considered as modified
+type fileKey struct {
+	volume uint32
+	size   int64
+	mtime  int64
+}
+
+// statFile returns the key of an opened file
+func statFile(f *os.File) (k fileKey, err error) {
+	var fi syscall.ByHandleFileInformation
+
+	if err = syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &fi); err != nil {
+		return
+	}
+
+	k.volume = fi.VolumeSerialNumber
+	k.size = int64(fi.FileSizeHigh)<<32 | int64(fi.FileSizeLow)
+	k.mtime = fi.LastWriteTime.Nanoseconds()
+
+	return
+}
+
+type hashEntry struct {
+	key      fileKey
+	hashes   map[string]string
+	lastUsed time.Time
+}
+
+// HashCacheStats holds the statistics of a HashCache
+type HashCacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// bytes not read thanks to the cache
+	BytesSaved uint64 `json:"bytes-saved"`
+}
+
+// HashCache caches the hashes of the files hashed by hooks and commands.
+// Files are identified by path, size, modification time and volume serial
+// number so that a file modified is hashed again. All methods can be
+// called on a nil HashCache, files are then always hashed.
+type HashCache struct {
+	sync.Mutex
+	config  *config.HashCache
+	entries map[string]*hashEntry
+	stats   HashCacheStats
+}
+
+// NewHashCache creates a new HashCache from configuration
+func NewHashCache(c *config.HashCache) *HashCache {
+	return &HashCache{
+		config:  c,
+		entries: make(map[string]*hashEntry),
+	}
+}
+
+func hashCachePath(path string) string {
+	return strings.ToLower(filepath.Clean(path))
+}
+
+// evict removes the least recently used tenth of the entries
+func (c *HashCache) evict() {
+	entries := make([]string, 0, len(c.entries))
+	for path := range c.entries {
+		entries = append(entries, path)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return c.entries[entries[i]].lastUsed.Before(c.entries[entries[j]].lastUsed)
+	})
+
+	for _, path := range entries[:len(entries)/10+1] {
+		delete(c.entries, path)
+	}
+}
+
+func (c *HashCache) get(path string, k fileKey) (hashes map[string]string, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	var e *hashEntry
+
+	if e, ok = c.entries[path]; ok && e.key == k {
+		e.lastUsed = time.Now()
+		c.stats.Hits++
+		c.stats.BytesSaved += uint64(k.size)
+		return e.hashes, true
+	}
+
+	c.stats.Misses++
+	return nil, false
+}
+
+func (c *HashCache) put(path string, k fileKey, hashes map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= c.config.MaxEntries {
+		c.evict()
+	}
+
+	c.entries[path] = &hashEntry{key: k, hashes: hashes, lastUsed: time.Now()}
+}
+
+// Hashes returns the md5, sha1, sha256 and sha512 hashes of a file, they are
+// taken from the cache if the file did not change since it was last hashed
+func (c *HashCache) Hashes(path string) (hashes map[string]string, err error) {
+	var f *os.File
+	var k fileKey
+	var ok bool
+
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	if c == nil {
+		return hashFile(f)
+	}
+
+	if k, err = statFile(f); err != nil {
+		return
+	}
+
+	key := hashCachePath(path)
+	if hashes, ok = c.get(key, k); ok {
+		return
+	}
+
+	if hashes, err = hashFile(f); err != nil {
+		return
+	}
+
+	c.put(key, k, hashes)
+
+	return
+}
+
+// Sha256 returns the sha256 of a file
+func (c *HashCache) Sha256(path string) (string, error) {
+	hashes, err := c.Hashes(path)
+	return hashes["sha256"], err
+}
+
+// Invalidate removes the hashes of a file from the cache
+func (c *HashCache) Invalidate(path string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, hashCachePath(path))
+}
+
+// Len returns the number of files which hashes are cached
+func (c *HashCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return len(c.entries)
+}
+
+// Stats returns the statistics of the cache
+func (c *HashCache) Stats() (s HashCacheStats) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	s = c.stats
+	s.Entries = len(c.entries)
+	return
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math"
 	"net"
@@ -17,6 +18,7 @@ import (
 	"github.com/0xrawsec/golang-win32/win32/kernel32"
 	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
+	"github.com/0xrawsec/whids/utils/ja3"
 )
 
 ////////////////////////////////// Hooks //////////////////////////////////
@@ -53,6 +55,9 @@ func hookSetImageSize(h *Agent, e *event.EdrEvent) {
 		goto RETURN
 	}
 
+	// agent might be a WOW64 process
+	image = utils.NativePath(image)
+
 	if !fsutil.IsFile(image) {
 		goto RETURN
 	}
@@ -66,6 +71,37 @@ RETURN:
 	e.SetIfMissing(imSzPath, unkFieldValue)
 }
 
+// hook applying on Sysmon SetValue events, adding the size of the value set,
+// whether it contains a base64 blob and whether it gets dumped
+
+func hookSetValueSize(h *Agent, e *event.EdrEvent) {
+	var target string
+	var data []byte
+	var dtype uint32
+	var ok bool
+	var err error
+
+	if target, ok = e.GetString(pathSysmonTargetObject); !ok {
+		e.SetIfMissing(pathValueSize, unkFieldValue)
+		return
+	}
+
+	// value might have already been deleted
+	if data, dtype, err = advapi32.RegGetValueFromString(target); err != nil {
+		e.SetIfMissing(pathValueSize, unkFieldValue)
+		return
+	}
+
+	s := regValueString(data, dtype)
+	dump := h.regValues.Match(target, s, len(data)) &&
+		!h.trustedSource(e) &&
+		h.regValues.Allow(regKey(target), time.Now())
+
+	e.Set(pathValueSize, toString(len(data)))
+	e.Set(pathValueBase64, toString(base64BlobRe.MatchString(s)))
+	e.Set(pathValueDumped, toString(dump))
+}
+
 func hookImageLoad(h *Agent, e *event.EdrEvent) {
 	var ok bool
 	var guid string
@@ -236,6 +272,17 @@ func hookTrack(h *Agent, e *event.EdrEvent) {
 	}
 }
 
+// hook tracking processes from Security process audit
+// events, used when Sysmon is not installed
+func hookTrackProcessAudit(h *Agent, e *event.EdrEvent) {
+	switch e.EventID() {
+	case SecurityProcessCreate:
+		h.trackAuditProcessCreate(e)
+	case SecurityProcessTerminate:
+		h.trackAuditProcessTerminate(e)
+	}
+}
+
 // hook managing statistics about some events
 func hookStats(h *Agent, e *event.EdrEvent) {
 	// We do not store stats if process termination is not enabled
@@ -382,6 +429,18 @@ func hookTerminator(h *Agent, e *event.EdrEvent) {
 		return
 	}
 
+	// kill the whole tree as children may have been created
+	// before we processed this event
+	if h.tracker.IsTreeBlacklisted(commandLine) {
+		if t := h.tracker.GetByPID(pid); !t.IsZero() {
+			h.logger.Warnf("Killing tree of blacklisted process PID=%d CommandLine=\"%s\"", pid, commandLine)
+			if _, err := h.killTree(t); err != nil {
+				h.logger.Errorf("Failed to kill tree of process PID=%d: %s", pid, err)
+			}
+			return
+		}
+	}
+
 	// terminate if blacklisted
 	if h.tracker.IsBlacklisted(commandLine) {
 		h.logger.Warnf("Terminating blacklisted  process PID=%d CommandLine=\"%s\"", pid, commandLine)
@@ -407,6 +466,9 @@ func hookProcTerm(h *Agent, e *event.EdrEvent) {
 	// Releasing resources
 	h.tracker.Terminate(guid)
 	h.memdumped.Del(guid)
+	h.suspended.Del(guid)
+	h.playbooks.forget(guid)
+	h.releaseTerminatedProcess(guid)
 }
 
 func hookSelfGUID(h *Agent, e *event.EdrEvent) {
@@ -496,11 +558,24 @@ func hookProcessIntegrityProcTamp(h *Agent, e *event.EdrEvent) {
 		return
 	}
 
+	// integrity checks are expensive and trusted
+	// security tools are known to tamper processes
+	if h.trusted.Trusted(h.tracker.GetByPID(pid)) {
+		return
+	}
+
 	if !kernel32.IsPIDRunning(int(pid)) {
 		h.logger.Errorf("Cannot check process integrity process with PID=%d is stopped", pid)
 		return
 	}
 
+	// image sections of a process with a different architecture (i.e. WOW64)
+	// cannot be reliably compared with the image on disk
+	if !utils.SameArchPid(int(pid)) {
+		h.logger.Debugf("Cannot check integrity of PID=%d running with a different architecture", pid)
+		return
+	}
+
 	if mainTid = kernel32.GetFirstTidOfPid(int(pid)); mainTid < 0 {
 		return
 	}
@@ -867,3 +942,181 @@ func hookKernelFiles(h *Agent, e *event.EdrEvent) {
 		e.Set(pathSysmonEventType, KernelFileOperations[e.EventID()])
 	}*/
 }
+
+// hook computing the likelihood of a queried domain to be generated by
+// a Domain Generation Algorithm (DGA)
+func hookDGAScore(h *Agent, e *event.EdrEvent) {
+	if query, ok := e.GetString(pathQueryName); ok {
+		e.Set(pathDGAScore, toString(h.dgaModel.Score(query)))
+	}
+}
+
+// hookDomainIoC sets the domain IoC matching a DNS query or its
+// answers, IoCs match subdomains so that rotating subdomains of
+// a malicious domain are caught
+func hookDomainIoC(h *Agent, e *event.EdrEvent) {
+	if i, ok := h.domainIoC(e); ok {
+		e.Set(pathDomainIoC, i.Value)
+	}
+}
+
+// hook rewriting IP addresses of network events in their canonical form
+// (i.e. compressed IPv6) so that they can be matched against containers
+// and correlated with addresses coming from other sources
+func hookCanonicalIPs(h *Agent, e *event.EdrEvent) {
+	for _, p := range []*engine.XPath{pathSysmonSourceIP, pathSysmonDestIP} {
+		if ip, ok := e.GetString(p); ok {
+			if c := utils.CanonicalIP(ip); c != ip {
+				e.Set(p, c)
+			}
+		}
+	}
+}
+
+// hookDNSCache remembers the addresses resolved by DNS queries along with
+// the domain queried and its aliases
+func hookDNSCache(h *Agent, e *event.EdrEvent) {
+	if query, ok := e.GetString(pathQueryName); ok {
+		if results, ok := e.GetString(pathQueryResults); ok {
+			names := append([]string{query}, dnsAnswerNames(results)...)
+			h.dnsCache.Add(names, dnsAnswerIPs(results), e.Timestamp())
+		}
+	}
+}
+
+// hookResolvedDomain sets the domain the destination of a network connection
+// was recently resolved from, so that it can be matched against containers,
+// and the domain IoC matching it or one of its aliases. This catches addresses
+// of watched domains not known as IoCs (i.e. fast-flux infrastructure).
+func hookResolvedDomain(h *Agent, e *event.EdrEvent) {
+	if ip, ok := e.GetString(pathSysmonDestIP); ok {
+		names := h.dnsCache.Names(ip, e.Timestamp())
+		if len(names) == 0 {
+			return
+		}
+
+		e.Set(pathResolvedDomain, names[0])
+		for _, name := range names {
+			if i, ok := h.iocs.MatchDomain(name); ok {
+				e.Set(pathDomainIoC, i.Value)
+				return
+			}
+		}
+	}
+}
+
+// hook setting the user watchlists the accounts of an event belong to
+func hookWatchlists(h *Agent, e *event.EdrEvent) {
+	if matched := h.matchWatchlists(e); len(matched) > 0 {
+		e.Set(pathWatchlists, strings.Join(matched, watchlistsSep))
+	}
+}
+
+// hook responding to the load of DLLs found in blocklists
+func hookDllBlocklist(h *Agent, e *event.EdrEvent) {
+	h.enforceDllBlocklist(e)
+}
+
+// hook tracking network logons, share accesses and remote executions
+// to detect lateral movements
+func hookLateralMovement(h *Agent, e *event.EdrEvent) {
+	h.trackLateralMovement(e)
+}
+
+// hook flagging anomalous Kerberos service ticket requests
+func hookKerberosAnomalies(h *Agent, e *event.EdrEvent) {
+	h.detectKerberosAnomalies(e)
+}
+
+// hook tracking outbound connections of processes to detect beaconing
+func hookBeaconing(h *Agent, e *event.EdrEvent) {
+	var image, ip, port string
+	var ok bool
+
+	if initiated, _ := e.GetString(pathSysmonInitiated); initiated != "true" {
+		return
+	}
+
+	if h.trustedSource(e) {
+		return
+	}
+
+	if image, ok = e.GetString(pathSysmonImage); !ok {
+		return
+	}
+
+	if ip, ok = e.GetString(pathSysmonDestIP); !ok {
+		return
+	}
+
+	if port, ok = e.GetString(pathSysmonDestPort); !ok {
+		return
+	}
+
+	if b, ok := h.beacons.Update(image, net.JoinHostPort(ip, port), e.Timestamp()); ok {
+		e.Set(pathBeaconing, "true")
+		e.Set(pathBeaconCount, toString(b.Count))
+		e.Set(pathBeaconInterval, b.Interval.String())
+		e.Set(pathBeaconJitter, fmt.Sprintf("%.3f", b.Jitter))
+		e.SetIfOr(pathBeaconMeanBytes, fmt.Sprintf("%.0f", b.MeanBytes), b.MeanBytes >= 0, unkFieldValue)
+	}
+}
+
+// hook accounting bytes sent by processes, used by beaconing detection
+func hookBeaconingBytes(h *Agent, e *event.EdrEvent) {
+	var pid, size int64
+	var daddr, dport string
+	var ok bool
+
+	if pid, ok = e.GetInt(pathKernelNetworkPID); !ok {
+		return
+	}
+
+	if size, ok = e.GetInt(pathKernelNetworkSize); !ok {
+		return
+	}
+
+	if daddr, ok = e.GetString(pathKernelNetworkDaddr); !ok {
+		return
+	}
+
+	if dport, ok = e.GetString(pathKernelNetworkDport); !ok {
+		return
+	}
+
+	if pt := h.tracker.GetByPID(pid); !pt.IsZero() && !h.trusted.Trusted(pt) {
+		// must be the same form as Sysmon addresses (c.f. hookCanonicalIPs)
+		daddr = utils.CanonicalIP(daddr)
+		h.beacons.AddBytes(pt.Image, net.JoinHostPort(daddr, dport), e.Timestamp(), size)
+	}
+}
+
+// hook computing JA3/JA3S fingerprints of events carrying raw TLS
+// handshake messages (i.e. coming from ETW or a packet tap)
+func hookTLSFingerprint(h *Agent, e *event.EdrEvent) {
+	if s, ok := e.GetString(pathTLSClientHello); ok {
+		if b, err := hex.DecodeString(s); err == nil {
+			if f, err := ja3.ClientHello(b); err == nil {
+				e.Set(pathJA3, f.String)
+				e.Set(pathJA3Hash, f.Hash)
+			}
+		}
+	}
+
+	if s, ok := e.GetString(pathTLSServerHello); ok {
+		if b, err := hex.DecodeString(s); err == nil {
+			if f, err := ja3.ServerHello(b); err == nil {
+				e.Set(pathJA3S, f.String)
+				e.Set(pathJA3SHash, f.Hash)
+			}
+		}
+	}
+}
+
+// hook invalidating cached hashes of the files created, deleted
+// or which creation time changed
+func hookHashCache(h *Agent, e *event.EdrEvent) {
+	if target, ok := e.GetString(pathSysmonTargetFilename); ok {
+		h.hashCache.Invalidate(target)
+	}
+}
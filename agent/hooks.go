@@ -1,18 +1,40 @@
 package agent
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync"
 
+	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/event"
 )
 
 // Hook structure definition
 // hooking functions are supposed to run quickly since it is
 // run synchronously with the Gene scanner. Likewise, the
-// hooking functions should never panic the program.
+// hooking functions should never panic the program, a hook
+// panicking is recovered and disabled after MaxHookPanics panics.
 type Hook func(*Agent, *event.EdrEvent)
 
-type eventMap map[int64][]Hook
+var (
+	// MaxHookPanics number of panics after which a hook gets disabled
+	MaxHookPanics = 3
+)
+
+// hookName returns the name of the function implementing a hook
+func hookName(h Hook) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); f != nil {
+		name := f.Name()
+		return name[strings.LastIndex(name, ".")+1:]
+	}
+	return "unknown"
+}
+
+// eventMap maps event ids to the indexes of the hooks to run
+type eventMap map[int64][]int
 
 type hookCache struct {
 	c map[string]eventMap
@@ -24,7 +46,7 @@ func newHookCache() *hookCache {
 	}
 }
 
-func (h hookCache) get(e *event.EdrEvent) (hooks []Hook, ok bool) {
+func (h hookCache) get(e *event.EdrEvent) (hooks []int, ok bool) {
 	var eventIdsMap eventMap
 
 	if eventIdsMap, ok = h.c[e.Channel()]; !ok {
@@ -35,7 +57,7 @@ func (h hookCache) get(e *event.EdrEvent) (hooks []Hook, ok bool) {
 	return
 }
 
-func (h hookCache) cache(hk Hook, e *event.EdrEvent) {
+func (h hookCache) cache(i int, e *event.EdrEvent) {
 	// create eventMap if necessary
 	if _, ok := h.c[e.Channel()]; !ok {
 		h.c[e.Channel()] = make(eventMap)
@@ -44,12 +66,12 @@ func (h hookCache) cache(hk Hook, e *event.EdrEvent) {
 	// create Hook slice if necessary
 	em := h.c[e.Channel()]
 	if _, ok := em[e.EventID()]; !ok {
-		em[e.EventID()] = make([]Hook, 0, 1)
+		em[e.EventID()] = make([]int, 0, 1)
 	}
 
 	// append the hook to the list of hooks
-	if hk != nil {
-		em[e.EventID()] = append(em[e.EventID()], hk)
+	if i >= 0 {
+		em[e.EventID()] = append(em[e.EventID()], i)
 	}
 }
 
@@ -59,13 +81,16 @@ type HookManager struct {
 	Filters []*Filter
 	Hooks   []Hook
 	cache   *hookCache
+	// number of panics per hook
+	panics []int
 }
 
 // NewHookMan creates a new HookManager structure
 func NewHookMan() *HookManager {
 	return &HookManager{Filters: make([]*Filter, 0),
-		Hooks: make([]Hook, 0),
-		cache: newHookCache(),
+		Hooks:  make([]Hook, 0),
+		cache:  newHookCache(),
+		panics: make([]int, 0),
 	}
 }
 
@@ -76,12 +101,75 @@ func (hm *HookManager) Hook(h Hook, f *Filter) {
 
 	hm.Hooks = append(hm.Hooks, h)
 	hm.Filters = append(hm.Filters, f)
+	hm.panics = append(hm.panics, 0)
+}
+
+// Unhook removes the hooks filtering on channel, names
+// of the hooks removed are returned
+func (hm *HookManager) Unhook(channel string) (names []string) {
+	hm.Lock()
+	defer hm.Unlock()
+
+	names = make([]string, 0)
+	filters, hooks, panics := hm.Filters[:0], hm.Hooks[:0], hm.panics[:0]
+	for i, f := range hm.Filters {
+		if f.Channel == channel {
+			names = append(names, hookName(hm.Hooks[i]))
+			continue
+		}
+		filters = append(filters, f)
+		hooks = append(hooks, hm.Hooks[i])
+		panics = append(panics, hm.panics[i])
+	}
+
+	hm.Filters, hm.Hooks, hm.panics = filters, hooks, panics
+	// cached hook indexes are not valid anymore
+	hm.cache = newHookCache()
+	return
+}
+
+// Disabled returns the names of the hooks disabled because they panicked too often
+func (hm *HookManager) Disabled() (names []string) {
+	hm.RLock()
+	defer hm.RUnlock()
+
+	names = make([]string, 0)
+	for i, n := range hm.panics {
+		if n >= MaxHookPanics {
+			names = append(names, hookName(hm.Hooks[i]))
+		}
+	}
+	return
+}
+
+// runHook runs hook i on event, recovering from any panic so that a
+// faulty hook cannot kill the event processing routine. Returns false
+// if the hook panicked.
+func (hm *HookManager) runHook(i int, h *Agent, e *event.EdrEvent) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			name := hookName(hm.Hooks[i])
+			err := fmt.Errorf("panic: %v", r)
+			hm.panics[i]++
+
+			if h != nil {
+				h.logger.Errorf("Hook %s %s\n%s", name, err, debug.Stack())
+				h.reportError(api.AgentErrorHook, name, err)
+				if hm.panics[i] >= MaxHookPanics {
+					h.logger.Errorf("Hook %s panicked %d times, disabling it", name, hm.panics[i])
+				}
+			}
+		}
+	}()
+
+	hm.Hooks[i](h, e)
+	return true
 }
 
 // RunHooksOn runs the hook on a given event
 func (hm *HookManager) RunHooksOn(h *Agent, e *event.EdrEvent) (ret bool) {
 	var ok bool
-	var hooks []Hook
+	var hooks []int
 
 	hm.Lock()
 	defer hm.Unlock()
@@ -96,23 +184,26 @@ func (hm *HookManager) RunHooksOn(h *Agent, e *event.EdrEvent) (ret bool) {
 	if hooks, ok = hm.cache.get(e); !ok {
 		// we create an empty slice for e in order to return
 		// cache hooks for events with no filters
-		hm.cache.cache(nil, e)
+		hm.cache.cache(-1, e)
 		// we go through all the filters
 		for i, f := range hm.Filters {
 			if f.Match(e) {
-				hm.cache.cache(hm.Hooks[i], e)
+				hm.cache.cache(i, e)
 			}
 		}
 		// we update the list of hooks to apply
 		hooks, _ = hm.cache.get(e)
 	}
 
-	for _, hook := range hooks {
-		// debug hooks
-		//log.Infof("Running hook: %s", getFunctionName(hook))
-		hook(h, e)
+	for _, i := range hooks {
+		// hook panicked too many times
+		if hm.panics[i] >= MaxHookPanics {
+			continue
+		}
 		// We set return value to true if a hook has been applied
-		ret = true
+		if hm.runHook(i, h, e) {
+			ret = true
+		}
 	}
 
 	return
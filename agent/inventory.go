@@ -0,0 +1,403 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// FirewallWeakenedRuleName name of the detection reported when a firewall
+	// profile gets disabled or when an allow rule is added or modified
+	FirewallWeakenedRuleName = "Builtin:FirewallWeakened"
+
+	// event ID of the event generated on inventory changes
+	agentInventoryChangeEventID = 7
+
+	// kinds of inventory items
+	InventoryFirewallProfile = "firewall-profile"
+	InventoryFirewallRule    = "firewall-rule"
+	InventoryListeningPort   = "listening-port"
+
+	// firewall configuration, group policy settings take precedence over local ones
+	pathFirewallPolicy    = `HKLM\SYSTEM\CurrentControlSet\Services\SharedAccess\Parameters\FirewallPolicy`
+	pathFirewallGPOPolicy = `HKLM\SOFTWARE\Policies\Microsoft\WindowsFirewall`
+
+	firewallActionAllow = "allow"
+	firewallActionBlock = "block"
+)
+
+var (
+	// profile names as found in the registry
+	firewallProfiles = []struct {
+		name string
+		key  string
+	}{
+		{"domain", "DomainProfile"},
+		{"private", "StandardProfile"},
+		{"public", "PublicProfile"},
+	}
+
+	firewallProtocols = map[string]string{
+		"1":  "icmp",
+		"6":  "tcp",
+		"17": "udp",
+		"58": "icmpv6",
+	}
+)
+
+// InventoryItem is an inventory entry (firewall profile, allow rule
+// or listening port) stored to detect changes across collections
+type InventoryItem struct {
+	sod.Item
+	Key   string `json:"key" sod:"unique"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	// true if item lets inbound traffic in
+	Permissive bool `json:"permissive"`
+}
+
+// InventoryChange is a difference between two inventory collections
+type InventoryChange struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	// true if the change makes the firewall more permissive
+	Weakening bool `json:"weakening"`
+}
+
+// firewallRuleFields parses the fields of a firewall rule as stored in the registry
+// (i.e. v2.30|Action=Allow|Active=TRUE|Dir=In|Protocol=6|LPort=445|Name=...|),
+// fields may appear several times
+func firewallRuleFields(data string) (fields map[string][]string) {
+	fields = make(map[string][]string)
+
+	for _, f := range strings.Split(data, "|") {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			fields[k] = append(fields[k], v)
+		}
+	}
+
+	return
+}
+
+// parseFirewallRule parses a firewall rule stored in the registry, ok is
+// true only if the rule is an enabled allow rule
+func parseFirewallRule(id, data, policy string) (r *sysinfo.FirewallRule, ok bool) {
+	fields := firewallRuleFields(data)
+	get := func(k string) string { return strings.Join(fields[k], ",") }
+
+	if !strings.EqualFold(get("Action"), "Allow") || !strings.EqualFold(get("Active"), "TRUE") {
+		return nil, false
+	}
+
+	r = &sysinfo.FirewallRule{
+		ID:          id,
+		Name:        get("Name"),
+		Direction:   strings.ToLower(get("Dir")),
+		Protocol:    get("Protocol"),
+		LocalPorts:  get("LPort"),
+		RemotePorts: get("RPort"),
+		RemoteAddrs: strings.Join(append(fields["RA4"], fields["RA6"]...), ","),
+		Application: get("App"),
+		Service:     get("Svc"),
+		Profiles:    strings.ToLower(get("Profile")),
+		Policy:      policy,
+	}
+
+	if p, ok := firewallProtocols[r.Protocol]; ok {
+		r.Protocol = p
+	}
+
+	return r, true
+}
+
+// listeningPorts returns the ports processes listen on out of connections, sorted by port
+func listeningPorts(conns []*Connection) (ports []*sysinfo.ListeningPort) {
+	seen := make(map[string]bool)
+	ports = make([]*sysinfo.ListeningPort, 0)
+
+	for _, c := range conns {
+		switch c.Protocol {
+		case "tcp", "tcp6":
+			if c.State != "LISTEN" {
+				continue
+			}
+		case "udp", "udp6":
+		default:
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s:%d/%d", c.Protocol, c.LocalAddr, c.LocalPort, c.PID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		ports = append(ports, &sysinfo.ListeningPort{
+			Protocol: c.Protocol,
+			Addr:     c.LocalAddr,
+			Port:     c.LocalPort,
+			PID:      c.PID,
+			Image:    c.Image,
+		})
+	}
+
+	sort.SliceStable(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+
+	return
+}
+
+// inventoryItems converts firewall state and listening ports into inventory items
+func inventoryItems(fw *sysinfo.Firewall, ports []*sysinfo.ListeningPort) (items map[string]*InventoryItem) {
+	items = make(map[string]*InventoryItem)
+
+	add := func(kind, id string, value interface{}, permissive bool) {
+		key := fmt.Sprintf("%s:%s", kind, id)
+		items[key] = &InventoryItem{Key: key, Kind: kind, Value: utils.JsonStringOrPanic(value), Permissive: permissive}
+	}
+
+	if fw != nil {
+		for _, p := range fw.Profiles {
+			add(InventoryFirewallProfile, p.Name, p, !p.Enabled || p.DefaultInbound == firewallActionAllow)
+		}
+
+		for _, r := range fw.Rules {
+			add(InventoryFirewallRule, r.ID, r, r.Direction == "in")
+		}
+	}
+
+	for _, p := range ports {
+		// PIDs change across reboots, they are not part of the key
+		add(InventoryListeningPort, fmt.Sprintf("%s/%s:%d", p.Protocol, p.Addr, p.Port), p.Image, false)
+	}
+
+	return
+}
+
+// diffInventory returns the changes between two inventory collections, sorted by key
+func diffInventory(baseline, current map[string]*InventoryItem) (changes []*InventoryChange) {
+	changes = make([]*InventoryChange, 0)
+
+	for key, old := range baseline {
+		if new, ok := current[key]; !ok {
+			changes = append(changes, &InventoryChange{Kind: old.Kind, Key: key, Change: credChangeRemoved, Old: old.Value})
+		} else if new.Value != old.Value {
+			changes = append(changes, &InventoryChange{
+				Kind:   new.Kind,
+				Key:    key,
+				Change: credChangeModified,
+				Old:    old.Value,
+				New:    new.Value,
+				// modified allow rules may let more traffic in
+				Weakening: new.Permissive && (!old.Permissive || new.Kind == InventoryFirewallRule),
+			})
+		}
+	}
+
+	for key, new := range current {
+		if _, ok := baseline[key]; !ok {
+			changes = append(changes, &InventoryChange{
+				Kind:      new.Kind,
+				Key:       key,
+				Change:    credChangeAdded,
+				New:       new.Value,
+				Weakening: new.Permissive,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return
+}
+
+// regDword returns the DWORD value found at path
+func regDword(path string) (dw uint32, ok bool) {
+	if v, err := utils.RegValue(path); err == nil {
+		dw, ok = v.(uint32)
+	}
+	return
+}
+
+// firewallAction converts a default action registry value
+func firewallAction(dw uint32) string {
+	if dw == 1 {
+		return firewallActionBlock
+	}
+	return firewallActionAllow
+}
+
+// collectFirewall reads the state of the Windows Firewall from the registry
+func collectFirewall() *sysinfo.Firewall {
+	fw := &sysinfo.Firewall{
+		Profiles: make([]*sysinfo.FirewallProfile, 0, len(firewallProfiles)),
+		Rules:    make([]*sysinfo.FirewallRule, 0),
+	}
+
+	for _, fp := range firewallProfiles {
+		// defaults when nothing is configured
+		p := &sysinfo.FirewallProfile{Name: fp.name, Enabled: true, DefaultInbound: firewallActionBlock, DefaultOutbound: firewallActionAllow}
+
+		for _, root := range []string{pathFirewallPolicy, pathFirewallGPOPolicy} {
+			key := utils.RegJoin(root, fp.key)
+			if dw, ok := regDword(utils.RegJoin(key, "EnableFirewall")); ok {
+				p.Enabled = dw != 0
+			}
+			if dw, ok := regDword(utils.RegJoin(key, "DefaultInboundAction")); ok {
+				p.DefaultInbound = firewallAction(dw)
+			}
+			if dw, ok := regDword(utils.RegJoin(key, "DefaultOutboundAction")); ok {
+				p.DefaultOutbound = firewallAction(dw)
+			}
+		}
+
+		fw.Profiles = append(fw.Profiles, p)
+	}
+
+	for _, src := range []struct {
+		key    string
+		policy string
+	}{
+		{utils.RegJoin(pathFirewallPolicy, "FirewallRules"), "local"},
+		{utils.RegJoin(pathFirewallGPOPolicy, "FirewallRules"), "gpo"},
+	} {
+		ids, err := advapi32.RegEnumValues(src.key)
+		if err != nil {
+			continue
+		}
+
+		for _, id := range ids {
+			if r, ok := parseFirewallRule(id, utils.RegValueToString(src.key, id), src.policy); ok {
+				fw.Rules = append(fw.Rules, r)
+			}
+		}
+	}
+
+	sort.Slice(fw.Rules, func(i, j int) bool { return fw.Rules[i].ID < fw.Rules[j].ID })
+
+	return fw
+}
+
+// inventoryBaseline returns the last inventory collected stored in local database
+func (a *Agent) inventoryBaseline() (baseline map[string]*InventoryItem, err error) {
+	var objs []sod.Object
+
+	if objs, err = a.db.All(&InventoryItem{}); err != nil {
+		return
+	}
+
+	baseline = make(map[string]*InventoryItem)
+	for _, o := range objs {
+		item := o.(*InventoryItem)
+		baseline[item.Key] = item
+	}
+
+	return
+}
+
+// saveInventoryBaseline replaces the inventory stored in local database
+func (a *Agent) saveInventoryBaseline(items map[string]*InventoryItem) (err error) {
+	s := make([]*InventoryItem, 0, len(items))
+	for _, item := range items {
+		s = append(s, item)
+	}
+
+	if err = a.db.DeleteAll(&InventoryItem{}); err != nil {
+		return
+	}
+
+	_, err = a.db.InsertOrUpdateMany(sod.ToObjectSlice(s)...)
+	return
+}
+
+// inventoryChangeEvent generates the event sent when inventory changes, changes
+// making the firewall more permissive are reported as alerts
+func (a *Agent) inventoryChangeEvent(c *InventoryChange) *event.EdrEvent {
+	e := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	e.System.Channel = agentChannel
+	e.System.Computer = hostname
+	e.System.EventID = agentInventoryChangeEventID
+	e.System.Execution.ProcessID = u32PID
+	e.System.Provider.Name = agentChannel
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData["Kind"] = c.Kind
+	e.EventData["Key"] = c.Key
+	e.EventData["Change"] = c.Change
+	e.EventData["OldValue"] = c.Old
+	e.EventData["NewValue"] = c.New
+
+	edrEvt := event.NewEdrEvent(e)
+	edrEvt.NormalizeTime()
+
+	if c.Weakening && a.config.InventoryConfig.Criticality > 0 {
+		d := engine.NewDetection(false, false)
+		d.Signature.Add(FirewallWeakenedRuleName)
+		d.Criticality = a.config.InventoryConfig.Criticality
+		edrEvt.SetDetection(d)
+	}
+
+	return edrEvt
+}
+
+// collectInventory collects firewall state and listening ports, compares them
+// to the previous collection and generates an event for every change. The
+// first collection only creates the baseline.
+func (a *Agent) collectInventory() (changes []*InventoryChange, err error) {
+	var baseline map[string]*InventoryItem
+	var conns []*Connection
+
+	fw := collectFirewall()
+
+	if conns, err = a.cmdNetstat(); err != nil {
+		return
+	}
+	ports := listeningPorts(conns)
+
+	a.inventory.Lock()
+	a.inventory.firewall = fw
+	a.inventory.ports = ports
+	a.inventory.Unlock()
+
+	if baseline, err = a.inventoryBaseline(); err != nil {
+		return
+	}
+
+	items := inventoryItems(fw, ports)
+
+	if len(baseline) == 0 {
+		a.logger.Infof("Creating inventory baseline (%d entries)", len(items))
+		return nil, a.saveInventoryBaseline(items)
+	}
+
+	if changes = diffInventory(baseline, items); len(changes) == 0 {
+		return
+	}
+
+	for _, c := range changes {
+		if c.Weakening {
+			a.logger.Warnf("Inventory %s %s: %q -> %q", c.Key, c.Change, c.Old, c.New)
+		}
+		a.pipeEvent(a.inventoryChangeEvent(c))
+	}
+
+	return changes, a.saveInventoryBaseline(items)
+}
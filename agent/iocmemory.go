@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+var (
+	// IoCMemorySaveInterval interval at which IoC hits are saved
+	IoCMemorySaveInterval = 5 * time.Minute
+
+	iocRuleNames = map[string]bool{
+		ruleNameHashIoC:   true,
+		ruleNameDomainIoC: true,
+		ruleNameJA3IoC:    true,
+		ruleNameIPIoC:     true,
+	}
+)
+
+// IoCHit is an (indicator, object) pair already alerted on
+type IoCHit struct {
+	sod.Item
+	Indicator string    `json:"indicator"`
+	Object    string    `json:"object"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type iocHitKey struct {
+	indicator string
+	object    string
+}
+
+// IoCMemory remembers the (indicator, object) pairs already alerted on, so
+// that a file matching an IoC does not raise an alert every time it is
+// loaded or executed while other objects matching the IoC still do
+type IoCMemory struct {
+	sync.Mutex
+	config *config.IoCMemory
+	hits   map[iocHitKey]time.Time
+}
+
+// NewIoCMemory creates a new IoCMemory from configuration
+func NewIoCMemory(c *config.IoCMemory) *IoCMemory {
+	return &IoCMemory{
+		config: c,
+		hits:   make(map[iocHitKey]time.Time),
+	}
+}
+
+func (m *IoCMemory) prune(now time.Time) {
+	for k, ts := range m.hits {
+		if now.Sub(ts) > m.config.TTL {
+			delete(m.hits, k)
+		}
+	}
+}
+
+// Prune removes the hits older than TTL
+func (m *IoCMemory) Prune(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.prune(now)
+}
+
+// Len returns the number of hits remembered
+func (m *IoCMemory) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.hits)
+}
+
+// Alerted returns true if indicator was already alerted on for object
+// within TTL, otherwise the hit is remembered and false is returned
+func (m *IoCMemory) Alerted(indicator, object string, ts time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	k := iocHitKey{strings.ToLower(indicator), strings.ToLower(object)}
+
+	if last, ok := m.hits[k]; ok && ts.Sub(last) <= m.config.TTL {
+		return true
+	}
+
+	if len(m.hits) >= m.config.MaxEntries {
+		m.prune(ts)
+		// we do not remember new hits if we are still full
+		if len(m.hits) >= m.config.MaxEntries {
+			return false
+		}
+	}
+
+	m.hits[k] = ts
+	return false
+}
+
+// Load loads hits saved in database
+func (m *IoCMemory) Load(db *sod.DB) (err error) {
+	var objs []sod.Object
+
+	if objs, err = db.All(&IoCHit{}); err != nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, o := range objs {
+		h := o.(*IoCHit)
+		k := iocHitKey{h.Indicator, h.Object}
+		if h.Timestamp.After(m.hits[k]) {
+			m.hits[k] = h.Timestamp
+		}
+	}
+
+	m.prune(time.Now())
+
+	return
+}
+
+// Save prunes and saves hits in database
+func (m *IoCMemory) Save(db *sod.DB) (err error) {
+	m.Lock()
+	m.prune(time.Now())
+	hits := make([]*IoCHit, 0, len(m.hits))
+	for k, ts := range m.hits {
+		hits = append(hits, &IoCHit{Indicator: k.indicator, Object: k.object, Timestamp: ts})
+	}
+	m.Unlock()
+
+	if err = db.DeleteAll(&IoCHit{}); err != nil {
+		return
+	}
+
+	_, err = db.InsertOrUpdateMany(sod.ToObjectSlice(hits)...)
+	return
+}
+
+// iocObject returns the object an IoC detection is about, the file
+// matching a hash IoC or the process matching a network IoC
+func iocObject(e *event.EdrEvent) (object string) {
+	var ok bool
+
+	if object, ok = e.GetString(pathSysmonImageLoaded); ok {
+		return
+	}
+
+	object, _ = e.GetString(pathSysmonImage)
+	return
+}
+
+// iocAlerted returns true if the event is an IoC detection already
+// alerted on for the same object. Detections involving other rules
+// are always alerted.
+func (a *Agent) iocAlerted(e *event.EdrEvent) bool {
+	if a.iocMemory == nil {
+		return false
+	}
+
+	d := e.GetDetection()
+	if d == nil || d.Signature == nil || d.Signature.Len() == 0 {
+		return false
+	}
+
+	for _, name := range d.Names() {
+		if !iocRuleNames[name] {
+			return false
+		}
+	}
+
+	object := iocObject(e)
+	if object == "" {
+		return false
+	}
+
+	for _, v := range iocCandidates(e) {
+		if i, ok := a.iocs.Get(v); ok {
+			return a.iocMemory.Alerted(i.Value, object, e.Timestamp())
+		}
+	}
+
+	return false
+}
@@ -2,21 +2,40 @@ package agent
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/whids/api/server"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/ioc"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	ruleNameHashIoC   = "Builtin:HashIoC"
+	ruleNameDomainIoC = "Builtin:DomainIoC"
+	ruleNameJA3IoC    = "Builtin:JA3IoC"
+	ruleNameIPIoC     = "Builtin:IPIoC"
 )
 
 var (
 	IoCRules = []engine.Rule{
 		ruleHashIoC(),
 		ruleDomainIoC(),
+		ruleJA3IoC(),
+		ruleIPIoC(),
 	}
 )
 
+// iocFieldMatch returns a match statement named name checking
+// if the value of field is in the IoC container
+func iocFieldMatch(name, field string) string {
+	return fmt.Sprintf("$%s: extract('^(?P<%s>.+)$', %s) in %s", name, name, field, server.IoCContainerName)
+}
+
 func ruleHashIoC() (r engine.Rule) {
 	r = engine.NewRule()
-	r.Name = "Builtin:HashIoC"
+	r.Name = ruleNameHashIoC
 	// FileCreate, FileDeleted and FileDeletedDetected
 	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1, 6, 7}}
 	r.Meta.Criticality = 10
@@ -31,15 +50,148 @@ func ruleHashIoC() (r engine.Rule) {
 
 func ruleDomainIoC() (r engine.Rule) {
 	r = engine.NewRule()
-	r.Name = "Builtin:DomainIoC"
-	// FileCreate, FileDeleted and FileDeletedDetected
+	r.Name = ruleNameDomainIoC
+	// DNSQuery, domain IoC matching the query or its answers
+	// (including subdomains and wildcards) is set by hookDomainIoC
 	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {22}}
 	r.Meta.Criticality = 10
 	r.Matches = []string{
-		fmt.Sprintf("$ioc_domain: extract('(?P<dom>\\w+\\.\\w+$)',QueryName) in %s'", server.IoCContainerName),
-		fmt.Sprintf("$ioc_subdomain: extract('(?P<sub>\\w+\\.\\w+\\.\\w+$)',QueryName) in %s'", server.IoCContainerName),
-		fmt.Sprintf("$ioc_hostname: extract('(?P<subsub>\\w+\\.\\w+\\.\\w+\\.\\w+$)',QueryName) in %s'", server.IoCContainerName),
+		iocFieldMatch("ioc_domain", "DomainIoC"),
 	}
-	r.Condition = "$ioc_domain or $ioc_subdomain or $ioc_hostname"
+	r.Condition = "$ioc_domain"
 	return
 }
+
+func ruleJA3IoC() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = ruleNameJA3IoC
+	// TLS fingerprints can be computed on any event carrying TLS handshakes
+	r.Meta.Criticality = 10
+	r.Matches = []string{
+		iocFieldMatch("ioc_ja3", "JA3Hash"),
+		iocFieldMatch("ioc_ja3s", "JA3SHash"),
+	}
+	r.Condition = "$ioc_ja3 or $ioc_ja3s"
+	return
+}
+
+func ruleIPIoC() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = ruleNameIPIoC
+	// NetworkConnect, addresses are in canonical form (c.f. hookCanonicalIPs)
+	// so that IPv6 addresses match whatever their representation. Destination
+	// also matches if it was resolved from a domain IoC (c.f. hookResolvedDomain)
+	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {3}}
+	r.Meta.Criticality = 10
+	r.Matches = []string{
+		iocFieldMatch("ioc_ip", "DestinationIp"),
+		iocFieldMatch("ioc_domain", "DomainIoC"),
+	}
+	r.Condition = "$ioc_ip or $ioc_domain"
+	return
+}
+
+// iocCandidates returns the values of an IoC detection which might be
+// found in the IoC container
+func iocCandidates(e *event.EdrEvent) (values []string) {
+	d := e.GetDetection()
+	values = make([]string, 0)
+
+	if d == nil || d.Signature == nil {
+		return
+	}
+
+	switch {
+	case d.Signature.Contains(ruleNameHashIoC):
+		if hashes, ok := e.GetString(pathSysmonHashes); ok {
+			for _, h := range strings.Split(hashes, ",") {
+				if kv := strings.SplitN(h, "=", 2); len(kv) == 2 {
+					values = append(values, strings.ToLower(kv[1]))
+				}
+			}
+		}
+	case d.Signature.Contains(ruleNameJA3IoC):
+		for _, p := range []*engine.XPath{pathJA3Hash, pathJA3SHash} {
+			if h, ok := e.GetString(p); ok {
+				values = append(values, h)
+			}
+		}
+	case d.Signature.Contains(ruleNameIPIoC):
+		if ip, ok := e.GetString(pathSysmonDestIP); ok {
+			values = append(values, utils.CanonicalIP(ip))
+		}
+		// destination resolved from a domain IoC
+		if value, ok := e.GetString(pathDomainIoC); ok {
+			values = append(values, value)
+		}
+	case d.Signature.Contains(ruleNameDomainIoC):
+		if value, ok := e.GetString(pathDomainIoC); ok {
+			values = append(values, value)
+		}
+	}
+
+	return
+}
+
+// dnsAnswerNames returns the names (i.e. CNAME) found in Sysmon
+// DNS query results, addresses are skipped
+func dnsAnswerNames(results string) (names []string) {
+	names = make([]string, 0)
+	for _, r := range strings.Split(results, ";") {
+		// format is "type:  5 some.domain.name"
+		if fields := strings.Fields(r); len(fields) == 3 && fields[0] == "type:" {
+			names = append(names, fields[2])
+		}
+	}
+	return
+}
+
+// domainIoC returns the domain IoC matching the query or
+// one of the names returned in the answers of a DNS event
+func (a *Agent) domainIoC(e *event.EdrEvent) (i *ioc.IOC, ok bool) {
+	if query, set := e.GetString(pathQueryName); set {
+		if i, ok = a.iocs.MatchDomain(query); ok {
+			return
+		}
+	}
+
+	if results, set := e.GetString(pathQueryResults); set {
+		for _, name := range dnsAnswerNames(results) {
+			if i, ok = a.iocs.MatchDomain(name); ok {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// setIoCProvenance enriches an IoC detection with information
+// about the feed the matching IoC comes from
+func (a *Agent) setIoCProvenance(e *event.EdrEvent) {
+	for _, v := range iocCandidates(e) {
+		if i, ok := a.iocs.Get(v); ok {
+			e.Set(pathIoCValue, i.Value)
+			e.Set(pathIoCSource, i.Source)
+			e.Set(pathIoCConfidence, toString(i.Confidence))
+			return
+		}
+	}
+}
+
+// pruneIoCs removes expired IoCs and reloads the engine if needed
+func (a *Agent) pruneIoCs() (err error) {
+	var expired []*ioc.IOC
+
+	if expired, err = a.iocs.Prune(a.db); err != nil || len(expired) == 0 {
+		return
+	}
+
+	a.logger.Infof("Pruned %d expired IoCs", len(expired))
+
+	if err = a.writeContainer(server.IoCContainerName, a.iocs.StringSlice()); err != nil {
+		return
+	}
+
+	return a.update(true)
+}
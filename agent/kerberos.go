@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/0xrawsec/whids/agent/kerberos"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// product type of domain controllers
+	productTypeDC = "LanmanNt"
+
+	// status of successful Kerberos ticket requests
+	kerberosStatusSuccess = "0x0"
+)
+
+// isDomainController returns true if the system is a domain controller
+func isDomainController() bool {
+	return strings.EqualFold(utils.RegValueToString(`HKLM\SYSTEM\CurrentControlSet\Control\ProductOptions`, "ProductType"), productTypeDC)
+}
+
+// detectKerberosAnomalies feeds the Kerberos anomaly detector with ticket
+// requests and flags service ticket requests found anomalous
+func (a *Agent) detectKerberosAnomalies(e *event.EdrEvent) {
+	if status, _ := e.GetString(pathKerberosStatus); status != kerberosStatusSuccess {
+		return
+	}
+
+	client, _ := e.GetString(pathTargetUserName)
+
+	switch e.EventID() {
+	case SecurityTGTRequest:
+		a.kerberos.TGT(client, e.Timestamp())
+
+	case SecurityTGSRequest:
+		r := &kerberos.Request{Timestamp: e.Timestamp(), Client: client}
+		r.Domain, _ = e.GetString(pathTargetDomainName)
+		r.Service, _ = e.GetString(pathServiceName)
+		r.EncryptionType, _ = e.GetString(pathTicketEncryptionType)
+
+		an := a.kerberos.TGS(r)
+		if an.RC4Downgrade {
+			e.Set(pathKerberosRC4Downgrade, "true")
+		}
+		if an.Kerberoasting {
+			e.Set(pathKerberoasting, "true")
+			e.Set(pathKerberoastingServices, toString(an.Services))
+		}
+		if an.Forged {
+			e.Set(pathKerberosForgedTicket, "true")
+			e.Set(pathKerberosForgedReason, an.ForgedReason)
+		}
+
+		if an.Any() {
+			a.logger.Warnf("Kerberos anomaly in service ticket request client=%s service=%s: %+v", client, r.Service, an)
+		}
+	}
+}
+
+// initKerberosDetector initializes Kerberos anomaly detection,
+// it is only relevant on domain controllers
+func (a *Agent) initKerberosDetector() {
+	if !a.config.KerberosConfig.Enable {
+		return
+	}
+
+	if !isDomainController() {
+		a.logger.Info("Kerberos anomaly detection disabled: not running on a domain controller")
+		return
+	}
+
+	a.kerberos = kerberos.NewDetector(&a.config.KerberosConfig)
+}
@@ -0,0 +1,229 @@
+package kerberos
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+const (
+	// Kerberos ticket encryption types (see RFC 4757 and 3962)
+	EncRC4HMAC    = "0x17"
+	EncRC4HMACExp = "0x18"
+
+	krbtgt = "krbtgt"
+)
+
+// reasons for which a ticket is considered as forged
+const (
+	ForgedUnknownDomain = "unknown domain"
+	ForgedNoTGT         = "no TGT issued"
+)
+
+// Request is a service ticket request (TGS)
+type Request struct {
+	Timestamp time.Time
+	// client account (user or user@DOMAIN)
+	Client string
+	// client account domain
+	Domain         string
+	Service        string
+	EncryptionType string
+}
+
+// Anomalies holds the anomalies found in a service ticket request
+type Anomalies struct {
+	RC4Downgrade  bool
+	Kerberoasting bool
+	// distinct services requested by client within window
+	Services     int
+	Forged       bool
+	ForgedReason string
+}
+
+// Any returns true if any anomaly was found
+func (a Anomalies) Any() bool {
+	return a.RC4Downgrade || a.Kerberoasting || a.Forged
+}
+
+type client struct {
+	services map[string]time.Time
+	lastSeen time.Time
+	alerted  time.Time
+}
+
+// normClient normalizes a client account name, user@DOMAIN
+// and user forms are considered as the same client
+func normClient(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// isUserService returns true if service runs as a user account,
+// those are the ones targeted by kerberoasting attacks
+func isUserService(service string) bool {
+	service = strings.ToLower(service)
+	return service != krbtgt && !strings.HasSuffix(service, "$")
+}
+
+// Detector detects anomalies in Kerberos ticket requests seen by a DC
+type Detector struct {
+	sync.Mutex
+	config     *config.Kerberos
+	started    time.Time
+	rc4Allowed map[string]bool
+	domains    map[string]bool
+	clients    map[string]*client
+	// last TGT issued per client
+	tgts map[string]time.Time
+}
+
+// NewDetector creates a new Detector
+func NewDetector(c *config.Kerberos) *Detector {
+	d := &Detector{
+		config:     c,
+		started:    time.Now(),
+		rc4Allowed: make(map[string]bool),
+		domains:    make(map[string]bool),
+		clients:    make(map[string]*client),
+		tgts:       make(map[string]time.Time),
+	}
+
+	for _, s := range c.RC4AllowedSvcs {
+		d.rc4Allowed[strings.ToLower(s)] = true
+	}
+
+	for _, dom := range c.Domains {
+		d.domains[strings.ToLower(dom)] = true
+	}
+
+	return d
+}
+
+func (d *Detector) prune(now time.Time) {
+	since := now.Add(-d.config.Window)
+	for k, c := range d.clients {
+		if c.lastSeen.Before(since) {
+			delete(d.clients, k)
+		}
+	}
+
+	since = now.Add(-d.config.TGTLifetime)
+	for k, ts := range d.tgts {
+		if ts.Before(since) {
+			delete(d.tgts, k)
+		}
+	}
+}
+
+// Prune removes clients not seen within window and expired TGTs
+func (d *Detector) Prune(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.prune(now)
+}
+
+// Len returns the number of clients tracked
+func (d *Detector) Len() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.clients)
+}
+
+// TGT tracks a TGT issued to a client
+func (d *Detector) TGT(name string, ts time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.config.RequireTGT {
+		return
+	}
+
+	name = normClient(name)
+	if _, ok := d.tgts[name]; !ok && len(d.tgts) >= d.config.MaxClients {
+		d.prune(ts)
+		if len(d.tgts) >= d.config.MaxClients {
+			return
+		}
+	}
+
+	if ts.After(d.tgts[name]) {
+		d.tgts[name] = ts
+	}
+}
+
+func (d *Detector) kerberoasting(r *Request, a *Anomalies) {
+	name := normClient(r.Client)
+
+	c, ok := d.clients[name]
+	if !ok {
+		if len(d.clients) >= d.config.MaxClients {
+			d.prune(r.Timestamp)
+		}
+		// we do not track new clients if we are still full
+		if len(d.clients) >= d.config.MaxClients {
+			return
+		}
+		c = &client{services: make(map[string]time.Time)}
+		d.clients[name] = c
+	}
+
+	since := r.Timestamp.Add(-d.config.Window)
+	for s, ts := range c.services {
+		if ts.Before(since) {
+			delete(c.services, s)
+		}
+	}
+
+	c.services[strings.ToLower(r.Service)] = r.Timestamp
+	c.lastSeen = r.Timestamp
+	a.Services = len(c.services)
+
+	// client is reported only once per window
+	if a.Services > d.config.MaxServices && c.alerted.Before(since) {
+		a.Kerberoasting = true
+		c.alerted = r.Timestamp
+	}
+}
+
+func (d *Detector) forged(r *Request, a *Anomalies) {
+	if len(d.domains) > 0 && !d.domains[strings.ToLower(r.Domain)] {
+		a.Forged, a.ForgedReason = true, ForgedUnknownDomain
+		return
+	}
+
+	// TGTs issued before we started are unknown
+	if d.config.RequireTGT && r.Timestamp.Sub(d.started) > d.config.TGTLifetime {
+		if ts, ok := d.tgts[normClient(r.Client)]; !ok || r.Timestamp.Sub(ts) > d.config.TGTLifetime {
+			a.Forged, a.ForgedReason = true, ForgedNoTGT
+		}
+	}
+}
+
+// TGS analyzes a successful service ticket request
+func (d *Detector) TGS(r *Request) (a Anomalies) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.config.RC4Downgrade {
+		switch strings.ToLower(r.EncryptionType) {
+		case EncRC4HMAC, EncRC4HMACExp:
+			a.RC4Downgrade = !d.rc4Allowed[strings.ToLower(r.Service)]
+		}
+	}
+
+	if d.config.Kerberoasting && isUserService(r.Service) {
+		d.kerberoasting(r, &a)
+	}
+
+	if d.config.ForgedTickets {
+		d.forged(r, &a)
+	}
+
+	return
+}
@@ -0,0 +1,91 @@
+package kerberos
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+var (
+	kerberosConfig = config.Kerberos{
+		Enable:         true,
+		RC4Downgrade:   true,
+		RC4AllowedSvcs: []string{"legacy-app"},
+		Kerberoasting:  true,
+		Window:         10 * time.Minute,
+		MaxServices:    5,
+		MaxClients:     1024,
+		ForgedTickets:  true,
+		Domains:        []string{"CORP", "corp.local"},
+		TGTLifetime:    10 * time.Hour,
+	}
+)
+
+func TestRC4Downgrade(t *testing.T) {
+	tt := toast.FromT(t)
+	d := NewDetector(&kerberosConfig)
+
+	r := &Request{Timestamp: time.Now(), Client: "alice@CORP.LOCAL", Domain: "CORP.LOCAL", Service: "sql-svc", EncryptionType: "0x17"}
+	a := d.TGS(r)
+	tt.Assert(a.RC4Downgrade && a.Any())
+
+	r.Service = "LEGACY-APP"
+	tt.Assert(!d.TGS(r).RC4Downgrade)
+
+	r.Service, r.EncryptionType = "sql-svc", "0x12"
+	tt.Assert(!d.TGS(r).Any())
+}
+
+func TestKerberoasting(t *testing.T) {
+	var a Anomalies
+
+	tt := toast.FromT(t)
+	d := NewDetector(&kerberosConfig)
+
+	ts := time.Now()
+	for i := 0; i <= kerberosConfig.MaxServices; i++ {
+		tt.Assert(!a.Kerberoasting)
+		a = d.TGS(&Request{Timestamp: ts, Client: "mallory@CORP.LOCAL", Domain: "CORP", Service: fmt.Sprintf("svc%d", i), EncryptionType: "0x12"})
+		// machine accounts and krbtgt are not counted
+		d.TGS(&Request{Timestamp: ts, Client: "mallory", Domain: "CORP", Service: fmt.Sprintf("HOST%d$", i), EncryptionType: "0x12"})
+		ts = ts.Add(time.Second)
+	}
+	tt.Assert(a.Kerberoasting)
+	tt.Assert(a.Services == kerberosConfig.MaxServices+1)
+
+	// client is reported once per window
+	a = d.TGS(&Request{Timestamp: ts, Client: "mallory", Domain: "CORP", Service: "another-svc", EncryptionType: "0x12"})
+	tt.Assert(!a.Kerberoasting)
+
+	// requests out of window are forgotten
+	a = d.TGS(&Request{Timestamp: ts.Add(time.Hour), Client: "mallory", Domain: "CORP", Service: "svc0", EncryptionType: "0x12"})
+	tt.Assert(a.Services == 1)
+	tt.Assert(d.Len() == 1)
+	d.Prune(ts.Add(2 * time.Hour))
+	tt.Assert(d.Len() == 0)
+}
+
+func TestForgedTicket(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := kerberosConfig
+	c.RequireTGT = true
+	d := NewDetector(&c)
+
+	ts := time.Now()
+	a := d.TGS(&Request{Timestamp: ts, Client: "alice", Domain: "eo.oe.kiwi : )", Service: "cifs", EncryptionType: "0x12"})
+	tt.Assert(a.Forged && a.ForgedReason == ForgedUnknownDomain)
+
+	// TGTs issued before detector started are unknown
+	tt.Assert(!d.TGS(&Request{Timestamp: ts, Client: "alice", Domain: "corp", Service: "cifs", EncryptionType: "0x12"}).Forged)
+
+	ts = ts.Add(c.TGTLifetime + time.Hour)
+	a = d.TGS(&Request{Timestamp: ts, Client: "alice@CORP.LOCAL", Domain: "CORP.LOCAL", Service: "cifs", EncryptionType: "0x12"})
+	tt.Assert(a.Forged && a.ForgedReason == ForgedNoTGT)
+
+	d.TGT("Alice", ts)
+	tt.Assert(!d.TGS(&Request{Timestamp: ts, Client: "alice@CORP.LOCAL", Domain: "CORP.LOCAL", Service: "cifs", EncryptionType: "0x12"}).Forged)
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+)
+
+const (
+	// ActionKillTree rule action terminating the process and all its children
+	ActionKillTree = "kill-tree"
+
+	// option of terminate command killing the process tree
+	optKillTree = "kill-tree"
+)
+
+// spareFromKill returns true if process must never be killed
+// as part of a process tree
+func (a *Agent) spareFromKill(t *ProcessTrack) bool {
+	return t.PID == int64(os.Getpid()) || a.isSelfImage(t.Image) || isCriticalImage(t.Image)
+}
+
+// killTree terminates the process tree rooted at root. Every process of the
+// tree is suspended before any is terminated so that no new child can escape
+// while the tree is being killed, then children are terminated before their
+// parent. The agent and critical processes found in the tree are spared.
+// It returns the processes terminated.
+func (a *Agent) killTree(root *ProcessTrack) (killed []*ProcessTrack, err error) {
+	switch {
+	case root.Terminated:
+		return nil, fmt.Errorf("process PID=%d is terminated", root.PID)
+	case root.PID == int64(os.Getpid()) || a.isSelfImage(root.Image):
+		return nil, fmt.Errorf("refusing to kill agent")
+	case isCriticalImage(root.Image):
+		return nil, fmt.Errorf("refusing to kill critical process %s", root.Image)
+	}
+
+	// processes may have spawned children until we suspended them
+	// so we walk the tree until no new process is found
+	suspended := make(map[string]bool)
+	for found := true; found; {
+		found = false
+		for _, t := range a.tracker.Tree(root.ProcessGUID) {
+			if _, ok := suspended[t.ProcessGUID]; ok {
+				continue
+			}
+
+			found = true
+			spare := a.spareFromKill(t)
+			suspended[t.ProcessGUID] = !spare
+			if spare {
+				a.logger.Warnf("Sparing process PID=%d Image=%s from tree kill", t.PID, t.Image)
+				continue
+			}
+			kernel32.SuspendProcess(int(t.PID))
+		}
+	}
+
+	killed = make([]*ProcessTrack, 0, len(suspended))
+	failed := 0
+	for _, t := range a.tracker.Tree(root.ProcessGUID) {
+		if !suspended[t.ProcessGUID] {
+			continue
+		}
+
+		if err := terminate(int(t.PID)); err != nil {
+			a.logger.Errorf("Failed to terminate process PID=%d Image=%s: %s", t.PID, t.Image, err)
+			failed++
+			continue
+		}
+		killed = append(killed, t)
+	}
+
+	a.logger.Warnf("Killed process tree of PID=%d GUID=%s Image=%s (%d processes)", root.PID, root.ProcessGUID, root.Image, len(killed))
+
+	if failed > 0 {
+		err = fmt.Errorf("failed to terminate %d processes of the tree", failed)
+	}
+
+	return
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/agent/lateral"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// LateralMovementRuleName name of the detection reported when a network
+	// logon is followed by share accesses and a remote service or task creation
+	LateralMovementRuleName = "Builtin:LateralMovement"
+
+	// event ID of the event generated when a lateral movement is detected
+	agentLateralMovementEventID = 5
+)
+
+// lateralMovementEvent generates the alert sent with the whole chain
+// of events making up a lateral movement
+func (a *Agent) lateralMovementEvent(c *lateral.Chain) *event.EdrEvent {
+	e := etw.NewEvent()
+	hostname, _ := os.Hostname()
+	last := c.Steps[len(c.Steps)-1]
+
+	e.System.Channel = agentChannel
+	e.System.Computer = hostname
+	e.System.EventID = agentLateralMovementEventID
+	e.System.Execution.ProcessID = u32PID
+	e.System.Provider.Name = agentChannel
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData["SourceAddress"] = c.Source
+	e.EventData["Account"] = c.Account
+	e.EventData["Shares"] = strings.Join(c.Objects(lateral.StepShare), ",")
+	e.EventData["ExecutionType"] = last.Kind
+	e.EventData["ExecutionName"] = last.Object
+	e.EventData["ExecutionCommand"] = last.Target
+	e.EventData["FirstSeen"] = c.FirstSeen().UTC().Format(time.RFC3339Nano)
+	e.EventData["Duration"] = c.Duration().String()
+	e.EventData["Chain"] = c.Steps
+
+	d := engine.NewDetection(false, false)
+	d.Signature.Add(LateralMovementRuleName)
+	d.Criticality = a.config.LateralConfig.Criticality
+
+	edrEvt := event.NewEdrEvent(e)
+	edrEvt.NormalizeTime()
+	edrEvt.SetDetection(d)
+
+	return edrEvt
+}
+
+// trackLateralMovement feeds the lateral movement tracker with Security
+// events and raises an alert once a chain is complete
+func (a *Agent) trackLateralMovement(e *event.EdrEvent) {
+	var chain *lateral.Chain
+	var complete bool
+
+	s := &lateral.Step{Timestamp: e.Timestamp(), EventID: e.EventID()}
+	source, _ := e.GetString(pathIpAddress)
+
+	switch e.EventID() {
+	case SecurityLogon:
+		if lt, _ := e.GetString(pathLogonType); lt != LogonTypeNetwork {
+			return
+		}
+		account, _ := eventAccount(e, pathTargetUserName, pathTargetDomainName)
+		s.LogonID, _ = e.GetString(pathTargetLogonId)
+		a.lateral.Logon(source, account, s)
+
+	case SecurityShareAccess, SecurityShareObjectAccess:
+		s.LogonID, _ = e.GetString(pathSubjectLogonId)
+		s.Object, _ = e.GetString(pathShareName)
+		s.Target, _ = e.GetString(pathRelativeTargetName)
+		a.lateral.Share(source, s)
+
+	case SecurityServiceInstall:
+		s.LogonID, _ = e.GetString(pathSubjectLogonId)
+		s.Object, _ = e.GetString(pathServiceName)
+		s.Target, _ = e.GetString(pathServiceFileName)
+		chain, complete = a.lateral.Execution(lateral.StepService, s)
+
+	case SecurityTaskCreate:
+		s.LogonID, _ = e.GetString(pathSubjectLogonId)
+		s.Object, _ = e.GetString(pathTaskName)
+		chain, complete = a.lateral.Execution(lateral.StepTask, s)
+	}
+
+	if !complete {
+		return
+	}
+
+	a.logger.Warnf("Lateral movement from %s (%s): %s %s created after accessing shares %s",
+		chain.Source, chain.Account, s.Kind, s.Object, strings.Join(chain.Objects(lateral.StepShare), ","))
+
+	if err := a.forward(a.lateralMovementEvent(chain)); err != nil {
+		a.logger.Errorf("Failed to pipe lateral movement event: %s", err)
+	}
+}
@@ -0,0 +1,244 @@
+package lateral
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+const (
+	// maximum number of steps kept per chain
+	maxSteps = 64
+)
+
+// kinds of steps of a lateral movement
+const (
+	StepLogon   = "logon"
+	StepShare   = "share"
+	StepService = "service"
+	StepTask    = "task"
+)
+
+// states of the lateral movement state machine
+const (
+	stateLogon = iota
+	stateShare
+)
+
+// Step is an event of a lateral movement chain
+type Step struct {
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	EventID   int64     `json:"event-id"`
+	LogonID   string    `json:"logon-id"`
+	// share, service or task name
+	Object string `json:"object,omitempty"`
+	// file accessed on share or command executed
+	Target string `json:"target,omitempty"`
+}
+
+// Chain is a lateral movement coming from a source host
+type Chain struct {
+	Source  string  `json:"source"`
+	Account string  `json:"account"`
+	Steps   []*Step `json:"steps"`
+	state   int
+	// logon sessions opened by source
+	logons   map[string]bool
+	lastSeen time.Time
+}
+
+// FirstSeen returns the time of the first step of the chain
+func (c *Chain) FirstSeen() time.Time {
+	return c.Steps[0].Timestamp
+}
+
+// Duration returns the time elapsed between the first and last steps
+func (c *Chain) Duration() time.Duration {
+	return c.Steps[len(c.Steps)-1].Timestamp.Sub(c.FirstSeen())
+}
+
+// Objects returns the distinct objects of the steps of a given kind
+func (c *Chain) Objects(kind string) (objects []string) {
+	objects = make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, s := range c.Steps {
+		if s.Kind == kind && s.Object != "" && !seen[s.Object] {
+			seen[s.Object] = true
+			objects = append(objects, s.Object)
+		}
+	}
+
+	return
+}
+
+func (c *Chain) add(s *Step) {
+	if len(c.Steps) < maxSteps {
+		c.Steps = append(c.Steps, s)
+	} else {
+		// the last step must always be known
+		c.Steps[maxSteps-1] = s
+	}
+	c.lastSeen = s.Timestamp
+}
+
+// Tracker correlates, per source host, network logons, share accesses
+// and the creation of services or scheduled tasks within these logon
+// sessions. A chain is complete, and reported once, when a service
+// or a task is created after shares have been accessed.
+type Tracker struct {
+	sync.Mutex
+	config    *config.LateralMovement
+	whitelist map[string]bool
+	chains    map[string]*Chain
+	// logon ID to source host
+	sessions map[string]string
+}
+
+// NewTracker creates a new Tracker
+func NewTracker(c *config.LateralMovement) *Tracker {
+	t := &Tracker{
+		config:    c,
+		whitelist: make(map[string]bool),
+		chains:    make(map[string]*Chain),
+		sessions:  make(map[string]string),
+	}
+
+	for _, src := range c.Whitelist {
+		t.whitelist[strings.ToLower(src)] = true
+	}
+
+	return t
+}
+
+// ignored returns true if source host must not be tracked
+func (t *Tracker) ignored(source string) bool {
+	switch source {
+	case "", "-", "127.0.0.1", "::1":
+		return true
+	}
+	return t.whitelist[strings.ToLower(source)]
+}
+
+func (t *Tracker) expired(c *Chain, ts time.Time) bool {
+	return ts.Sub(c.lastSeen) > t.config.Window
+}
+
+func (t *Tracker) remove(source string) {
+	if c, ok := t.chains[source]; ok {
+		for id := range c.logons {
+			delete(t.sessions, id)
+		}
+		delete(t.chains, source)
+	}
+}
+
+func (t *Tracker) prune(now time.Time) {
+	for source, c := range t.chains {
+		if t.expired(c, now) {
+			t.remove(source)
+		}
+	}
+}
+
+// Prune removes chains without activity within the tracking window
+func (t *Tracker) Prune(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	t.prune(now)
+}
+
+// Len returns the number of source hosts tracked
+func (t *Tracker) Len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.chains)
+}
+
+// Logon tracks a network logon made from source
+func (t *Tracker) Logon(source, account string, s *Step) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.ignored(source) || s.LogonID == "" {
+		return
+	}
+
+	c, ok := t.chains[source]
+	if ok && t.expired(c, s.Timestamp) {
+		t.remove(source)
+		ok = false
+	}
+
+	if !ok {
+		if len(t.chains) >= t.config.MaxSources {
+			t.prune(s.Timestamp)
+		}
+		// we do not track new sources if we are still full
+		if len(t.chains) >= t.config.MaxSources {
+			return
+		}
+		c = &Chain{Source: source, Account: account, logons: make(map[string]bool)}
+		t.chains[source] = c
+	}
+
+	s.Kind = StepLogon
+	c.add(s)
+	c.logons[s.LogonID] = true
+	t.sessions[s.LogonID] = source
+}
+
+// chain returns the chain a step belongs to, source is optional
+func (t *Tracker) chain(source string, s *Step) (c *Chain, ok bool) {
+	if source == "" {
+		if source, ok = t.sessions[s.LogonID]; !ok {
+			return
+		}
+	}
+
+	if c, ok = t.chains[source]; !ok {
+		return
+	}
+
+	if t.expired(c, s.Timestamp) {
+		t.remove(source)
+		return nil, false
+	}
+
+	return c, c.logons[s.LogonID]
+}
+
+// Share tracks a share access made within a network logon session
+func (t *Tracker) Share(source string, s *Step) {
+	t.Lock()
+	defer t.Unlock()
+
+	if c, ok := t.chain(source, s); ok {
+		s.Kind = StepShare
+		c.add(s)
+		c.state = stateShare
+	}
+}
+
+// Execution tracks the creation of a service or a scheduled task (kind)
+// within a network logon session, the chain is returned if it is complete
+func (t *Tracker) Execution(kind string, s *Step) (chain *Chain, ok bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	var c *Chain
+
+	if c, ok = t.chain("", s); !ok || c.state != stateShare {
+		return nil, false
+	}
+
+	s.Kind = kind
+	c.add(s)
+	// chain is reported only once
+	t.remove(c.Source)
+
+	return c, true
+}
@@ -0,0 +1,81 @@
+package lateral
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/agent/config"
+)
+
+var (
+	lateralConfig = config.LateralMovement{
+		Enable:     true,
+		Window:     30 * time.Minute,
+		MaxSources: 2,
+		Whitelist:  []string{"10.0.0.10"},
+	}
+
+	source  = "10.0.0.42"
+	logonID = "0x1a2b3c"
+)
+
+func TestLateralMovement(t *testing.T) {
+	tt := toast.FromT(t)
+	tr := NewTracker(&lateralConfig)
+
+	ts := time.Now()
+	tr.Logon(source, `CORP\admin`, &Step{Timestamp: ts, EventID: 4624, LogonID: logonID})
+	tt.Assert(tr.Len() == 1)
+
+	// execution without share access is not a complete chain
+	_, ok := tr.Execution(StepService, &Step{Timestamp: ts, EventID: 4697, LogonID: logonID, Object: "updater"})
+	tt.Assert(!ok)
+
+	ts = ts.Add(time.Second)
+	tr.Share(source, &Step{Timestamp: ts, EventID: 5140, LogonID: logonID, Object: `\\*\ADMIN$`})
+	tr.Share(source, &Step{Timestamp: ts, EventID: 5145, LogonID: logonID, Object: `\\*\ADMIN$`, Target: "PSEXESVC.exe"})
+	// share access made in another session
+	tr.Share(source, &Step{Timestamp: ts, EventID: 5140, LogonID: "0xdead", Object: `\\*\C$`})
+
+	ts = ts.Add(time.Second)
+	c, ok := tr.Execution(StepService, &Step{Timestamp: ts, EventID: 4697, LogonID: logonID, Object: "PSEXESVC", Target: `%SystemRoot%\PSEXESVC.exe`})
+	tt.Assert(ok)
+	tt.Assert(c.Source == source)
+	tt.Assert(c.Account == `CORP\admin`)
+	tt.Assert(len(c.Steps) == 4)
+	tt.Assert(len(c.Objects(StepShare)) == 1)
+	tt.Assert(c.Duration() == 2*time.Second)
+
+	// chain is reported only once
+	tt.Assert(tr.Len() == 0)
+	_, ok = tr.Execution(StepService, &Step{Timestamp: ts, EventID: 4697, LogonID: logonID})
+	tt.Assert(!ok)
+}
+
+func TestLateralMovementNoAlert(t *testing.T) {
+	tt := toast.FromT(t)
+	tr := NewTracker(&lateralConfig)
+
+	// whitelisted and local sources are not tracked
+	ts := time.Now()
+	for _, src := range []string{"10.0.0.10", "127.0.0.1", "-"} {
+		tr.Logon(src, "admin", &Step{Timestamp: ts, EventID: 4624, LogonID: logonID})
+	}
+	tt.Assert(tr.Len() == 0)
+
+	// chain expired
+	tr.Logon(source, "admin", &Step{Timestamp: ts, EventID: 4624, LogonID: logonID})
+	tr.Share(source, &Step{Timestamp: ts, EventID: 5140, LogonID: logonID, Object: `\\*\ADMIN$`})
+	_, ok := tr.Execution(StepTask, &Step{Timestamp: ts.Add(time.Hour), EventID: 4698, LogonID: logonID})
+	tt.Assert(!ok)
+	tt.Assert(tr.Len() == 0)
+
+	// maximum number of sources reached
+	for i, src := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		tr.Logon(src, "admin", &Step{Timestamp: ts.Add(time.Duration(i) * time.Second), EventID: 4624, LogonID: src})
+	}
+	tt.Assert(tr.Len() == lateralConfig.MaxSources)
+	tr.Prune(ts.Add(time.Hour))
+	tt.Assert(tr.Len() == 0)
+}
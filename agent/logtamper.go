@@ -0,0 +1,26 @@
+package agent
+
+import "github.com/0xrawsec/whids/agent/config"
+
+// logTampered returns true if rules contains the log tampering rule
+func (a *Agent) logTampered(rules []string) bool {
+	if !a.config.LogTamperConfig.Enable {
+		return false
+	}
+
+	for _, r := range rules {
+		if r == config.LogTamperRuleName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flushEvents makes the forwarder send the events piped so far
+// without waiting for its batching thresholds to be reached
+func (a *Agent) flushEvents() {
+	if a.forwarder != nil && a.output == a.forwarder {
+		a.forwarder.Flush()
+	}
+}
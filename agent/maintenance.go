@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+// setMaintenanceRules must be called when a new engine is loaded so
+// that maintenance windows can be applied depending on rule tags
+func (a *Agent) setMaintenanceRules(e *engine.Engine) {
+	m := ruleTagsMap(e)
+
+	a.maintenance.Lock()
+	defer a.maintenance.Unlock()
+	a.maintenance.ruleTags = m
+}
+
+// maintenanceRecalibrate returns criticality of an alert raised by rules
+// recalibrated according to active maintenance windows, suppressed is
+// true if the alert must be suppressed
+func (a *Agent) maintenanceRecalibrate(e *event.EdrEvent, rules []string, crit int) (int, bool) {
+	if !a.config.MaintConfig.Enable {
+		return crit, false
+	}
+
+	a.maintenance.RLock()
+	tags := make([]string, 0)
+	for _, r := range rules {
+		tags = append(tags, a.maintenance.ruleTags[r]...)
+	}
+	a.maintenance.RUnlock()
+
+	return a.config.MaintConfig.Recalibrate(e.Timestamp(), a.config.CritConfig.Tags, tags, crit)
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-win32/win32/dbghelp"
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// MemoryDump describes a process memory dump requested by the manager
+type MemoryDump struct {
+	PID   int    `json:"pid"`
+	GUID  string `json:"guid"`
+	Image string `json:"image"`
+	Size  int64  `json:"size"`
+	// path of the dump, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact"`
+}
+
+// memdumpFilename returns the name of the memory dump file of a process
+func memdumpFilename(image string, pid int) string {
+	return fmt.Sprintf("%s_%d_%d.dmp", filepath.Base(image), pid, time.Now().UnixNano())
+}
+
+// memdumpArtifact returns the path, relative to dump directory, of the memory
+// dump of a process. Directory layout expected by dump uploader is GUID/HASH/FILE,
+// command UUID is used as hash so that dumps are grouped by command.
+func memdumpArtifact(d *MemoryDump, cmdUUID string) string {
+	return filepath.Join(d.GUID, strings.ReplaceAll(cmdUUID, "-", ""), memdumpFilename(d.Image, d.PID))
+}
+
+// memdumpTarget resolves the process, identified by PID or by process GUID,
+// to dump and checks its memory can be dumped
+func (a *Agent) memdumpTarget(target string) (d *MemoryDump, err error) {
+	d = &MemoryDump{GUID: nullGUID}
+
+	if pid, err := strconv.Atoi(target); err == nil {
+		d.PID = pid
+		if pt := a.tracker.GetByPID(int64(pid)); !pt.IsZero() && !pt.Terminated {
+			d.GUID, d.Image = pt.ProcessGUID, pt.Image
+		} else {
+			d.Image, _ = kernel32.GetModuleFilenameFromPID(pid)
+		}
+	} else {
+		pt := a.tracker.GetByGuid(target)
+		if pt.IsZero() {
+			return nil, fmt.Errorf("unknown process guid=%s", target)
+		}
+		d.PID, d.GUID, d.Image = int(pt.PID), pt.ProcessGUID, pt.Image
+	}
+
+	switch {
+	case d.PID == os.Getpid():
+		return nil, fmt.Errorf("refusing to dump agent's memory")
+	case !kernel32.IsPIDRunning(d.PID):
+		return nil, fmt.Errorf("cannot dump process pid=%d, process is not running", d.PID)
+	case utils.IsWow64Self() && !utils.IsWow64Pid(d.PID):
+		return nil, fmt.Errorf("cannot dump process pid=%d image=%s from a WOW64 agent, a native agent build is required", d.PID, d.Image)
+	}
+
+	return
+}
+
+// dumpProcessMemory dumps the memory of a process, identified by PID or by
+// process GUID, into the dump directory so that it is uploaded to the manager
+// as any other dump. Dumps are grouped by command so that several dumps of
+// the same process can be told apart.
+func (a *Agent) dumpProcessMemory(cmdUUID, target string) (d *MemoryDump, err error) {
+	var dumpPath string
+
+	if d, err = a.memdumpTarget(target); err != nil {
+		return
+	}
+
+	// a dump triggered by an alert might be running
+	if d.GUID != nullGUID {
+		if a.dumping.Contains(d.GUID) {
+			return nil, fmt.Errorf("process guid=%s is already being dumped", d.GUID)
+		}
+		a.dumping.Add(d.GUID)
+		defer a.dumping.Del(d.GUID)
+	}
+
+	rel := memdumpArtifact(d, cmdUUID)
+	dumpPath = filepath.Join(a.config.Dump.Dir, rel)
+
+	if err = utils.HidsMkdirAll(filepath.Dir(dumpPath)); err != nil {
+		return nil, err
+	}
+
+	if err = dbghelp.FullMemoryMiniDump(d.PID, dumpPath); err != nil {
+		return nil, fmt.Errorf("failed to dump process pid=%d image=%s: %w", d.PID, d.Image, err)
+	}
+
+	// only compressed files are uploaded, so we compress it
+	// even if dump compression is disabled
+	if err = utils.GzipFileBestSpeed(dumpPath); err != nil {
+		os.Remove(dumpPath)
+		return nil, err
+	}
+
+	if fi, err := os.Stat(dumpPath + ".gz"); err == nil {
+		d.Size = fi.Size()
+	}
+	d.Artifact = rel + ".gz"
+
+	a.logger.Infof("memory of process pid=%d image=%s dumped to %s", d.PID, d.Image, d.Artifact)
+
+	return
+}
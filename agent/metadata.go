@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/sysmon"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	// EventMetadataInterval interval at which the Sysmon configuration
+	// hash stamped on forwarded events is refreshed
+	EventMetadataInterval = time.Minute
+)
+
+// rulesSha256 returns the sha256 of the rule database, the one of the
+// manager if rules were pulled from it
+func (a *Agent) rulesSha256() string {
+	path, sha256Path := a.config.RulesConfig.RulesPaths()
+
+	if sha256, err := utils.ReadFileAsString(sha256Path); err == nil {
+		return strings.TrimSpace(sha256)
+	}
+
+	sha256, _ := a.hashCache.Sha256(path)
+	return sha256
+}
+
+// updateEventMetadata updates the agent state stamped on forwarded events,
+// it must be called whenever rules or Sysmon configuration change
+func (a *Agent) updateEventMetadata() {
+	md := &event.AgentData{
+		RulesSha256: a.rulesSha256(),
+	}
+
+	if i := sysinfo.RegisteredEdrInfo(); i != nil {
+		md.Version = i.Version
+	}
+
+	if hash, err := sysmon.CurrentConfigHash(); err == nil {
+		md.SysmonConfigHash = hash
+	}
+
+	// we keep the same pointer if nothing changed
+	if old, ok := a.metadata.Load().(*event.AgentData); ok && *old == *md {
+		return
+	}
+
+	a.metadata.Store(md)
+}
+
+// decorate stamps an event with the state of the agent
+func (a *Agent) decorate(e *event.EdrEvent) {
+	if md, ok := a.metadata.Load().(*event.AgentData); ok {
+		if e.Event.EdrData == nil {
+			e.InitEdrData()
+		}
+		e.Event.EdrData.Agent = md
+	}
+}
+
+// forward stamps an event with agent state and sends it to the output
+func (a *Agent) forward(e *event.EdrEvent) error {
+	a.decorate(e)
+	return a.output.PipeEvent(e)
+}
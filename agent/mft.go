@@ -0,0 +1,293 @@
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	ntfsOEMID = "NTFS    "
+
+	mftRecordSignature = "FILE"
+	// update sequence (fixup) stride, independent of sector size
+	mftFixupStride = 512
+
+	mftAttrData = 0x80
+	mftAttrEnd  = 0xffffffff
+
+	// size of the reads made on the raw volume
+	mftReadSize = 1 << 20
+)
+
+// ntfsBootSector holds the fields of an NTFS boot sector needed to locate $MFT
+type ntfsBootSector struct {
+	SectorSize  int64
+	ClusterSize int64
+	MFTCluster  int64
+	RecordSize  int64
+}
+
+// parseNTFSBootSector parses the boot sector of an NTFS volume
+func parseNTFSBootSector(b []byte) (bs *ntfsBootSector, err error) {
+	if len(b) < 512 || string(b[3:11]) != ntfsOEMID {
+		return nil, fmt.Errorf("not an NTFS volume")
+	}
+
+	bs = &ntfsBootSector{SectorSize: int64(binary.LittleEndian.Uint16(b[0x0b:]))}
+	if bs.SectorSize < 512 || bs.SectorSize > 4096 || bs.SectorSize&(bs.SectorSize-1) != 0 {
+		return nil, fmt.Errorf("bad sector size: %d", bs.SectorSize)
+	}
+
+	// above 0x80, sectors per cluster is encoded as a negative power of two
+	if spc := b[0x0d]; spc <= 0x80 {
+		bs.ClusterSize = bs.SectorSize * int64(spc)
+	} else {
+		bs.ClusterSize = bs.SectorSize << (256 - int(spc))
+	}
+	if bs.ClusterSize == 0 {
+		return nil, fmt.Errorf("bad cluster size")
+	}
+
+	bs.MFTCluster = int64(binary.LittleEndian.Uint64(b[0x30:]))
+
+	// negative clusters per record means record size is 2^-value bytes
+	if cpr := int8(b[0x40]); cpr > 0 {
+		bs.RecordSize = int64(cpr) * bs.ClusterSize
+	} else {
+		bs.RecordSize = 1 << -int(cpr)
+	}
+	if bs.RecordSize < mftFixupStride {
+		return nil, fmt.Errorf("bad file record size: %d", bs.RecordSize)
+	}
+
+	return
+}
+
+// mftRecordFixup checks and applies the update sequence of an MFT record
+func mftRecordFixup(rec []byte) error {
+	if len(rec) < 8 || string(rec[:4]) != mftRecordSignature {
+		return fmt.Errorf("bad file record signature")
+	}
+
+	off := int(binary.LittleEndian.Uint16(rec[4:]))
+	count := int(binary.LittleEndian.Uint16(rec[6:]))
+	if count == 0 || off+2*count > len(rec) || (count-1)*mftFixupStride > len(rec) {
+		return fmt.Errorf("bad update sequence")
+	}
+
+	usn := rec[off : off+2]
+	for i := 1; i < count; i++ {
+		end := i*mftFixupStride - 2
+		if rec[end] != usn[0] || rec[end+1] != usn[1] {
+			return fmt.Errorf("torn file record")
+		}
+		copy(rec[end:end+2], rec[off+2*i:off+2*i+2])
+	}
+
+	return nil
+}
+
+// dataRun is an extent of non-resident data, Cluster is -1 for sparse runs
+type dataRun struct {
+	Cluster int64
+	Length  int64
+}
+
+// leInt decodes a little endian signed integer of len(b) bytes
+func leInt(b []byte) (v int64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | int64(b[i])
+	}
+	if n := len(b); n > 0 && n < 8 && b[n-1]&0x80 != 0 {
+		v -= 1 << (8 * n)
+	}
+	return
+}
+
+// parseDataRuns decodes the run list of a non-resident attribute
+func parseDataRuns(b []byte) (runs []dataRun, err error) {
+	var cluster int64
+
+	runs = make([]dataRun, 0)
+	for i := 0; i < len(b) && b[i] != 0; {
+		lenSize, offSize := int(b[i]&0x0f), int(b[i]>>4)
+		i++
+
+		if lenSize == 0 || lenSize > 8 || offSize > 8 || i+lenSize+offSize > len(b) {
+			return nil, fmt.Errorf("bad data run at offset %d", i-1)
+		}
+
+		length := leInt(b[i : i+lenSize])
+		i += lenSize
+		if length <= 0 {
+			return nil, fmt.Errorf("bad data run length: %d", length)
+		}
+
+		if offSize == 0 {
+			runs = append(runs, dataRun{Cluster: -1, Length: length})
+			continue
+		}
+
+		// cluster is relative to the one of the previous run
+		cluster += leInt(b[i : i+offSize])
+		i += offSize
+		runs = append(runs, dataRun{Cluster: cluster, Length: length})
+	}
+
+	return
+}
+
+// mftDataRuns returns the runs and the size of the unnamed $DATA
+// attribute of an MFT record, fixups must have been applied
+func mftDataRuns(rec []byte) (runs []dataRun, size int64, err error) {
+	if len(rec) < 0x18 {
+		return nil, 0, fmt.Errorf("file record too short")
+	}
+
+	for off := int(binary.LittleEndian.Uint16(rec[0x14:])); off+8 <= len(rec); {
+		atype := binary.LittleEndian.Uint32(rec[off:])
+		if atype == mftAttrEnd {
+			break
+		}
+
+		length := int(binary.LittleEndian.Uint32(rec[off+4:]))
+		if length < 0x18 || off+length > len(rec) {
+			return nil, 0, fmt.Errorf("bad attribute length at offset %d", off)
+		}
+
+		// non resident unnamed attribute
+		if atype == mftAttrData && rec[off+9] == 0 {
+			if rec[off+8] == 0 || length < 0x40 {
+				return nil, 0, fmt.Errorf("unexpected resident data")
+			}
+
+			runOff := int(binary.LittleEndian.Uint16(rec[off+0x20:]))
+			if runOff >= length {
+				return nil, 0, fmt.Errorf("bad run list offset")
+			}
+
+			size = int64(binary.LittleEndian.Uint64(rec[off+0x30:]))
+			runs, err = parseDataRuns(rec[off+runOff : off+length])
+			return
+		}
+
+		off += length
+	}
+
+	return nil, 0, fmt.Errorf("data attribute not found")
+}
+
+// rawMFT reads the $MFT of an NTFS volume from the raw volume, as it
+// cannot be opened as a regular file. $MFT whose data does not fit in
+// its first file record (attribute list) is not supported.
+type rawMFT struct {
+	vol  *os.File
+	bs   *ntfsBootSector
+	runs []dataRun
+	Size int64
+}
+
+// openRawMFT opens the $MFT of volume (i.e. C:)
+func openRawMFT(volume string) (m *rawMFT, err error) {
+	var clusters int64
+
+	m = &rawMFT{}
+	if m.vol, err = os.Open(fmt.Sprintf(`\\.\%s`, volume)); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			m.vol.Close()
+		}
+	}()
+
+	// reads on raw volumes must be sector aligned
+	boot := make([]byte, 4096)
+	if _, err = m.vol.ReadAt(boot, 0); err != nil {
+		return nil, fmt.Errorf("failed to read boot sector: %w", err)
+	}
+
+	if m.bs, err = parseNTFSBootSector(boot); err != nil {
+		return nil, err
+	}
+
+	// both sizes are powers of two so the greatest is a multiple of the other
+	rec := make([]byte, m.bs.ClusterSize)
+	if m.bs.RecordSize > m.bs.ClusterSize {
+		rec = make([]byte, m.bs.RecordSize)
+	}
+
+	if _, err = m.vol.ReadAt(rec, m.bs.MFTCluster*m.bs.ClusterSize); err != nil {
+		return nil, fmt.Errorf("failed to read $MFT file record: %w", err)
+	}
+	rec = rec[:m.bs.RecordSize]
+
+	if err = mftRecordFixup(rec); err != nil {
+		return nil, err
+	}
+
+	if m.runs, m.Size, err = mftDataRuns(rec); err != nil {
+		return nil, err
+	}
+
+	for _, r := range m.runs {
+		clusters += r.Length
+	}
+
+	if clusters*m.bs.ClusterSize < m.Size {
+		return nil, fmt.Errorf("$MFT data spans several file records, not supported")
+	}
+
+	return
+}
+
+// WriteTo writes the content of $MFT to w
+func (m *rawMFT) WriteTo(w io.Writer) (n int64, err error) {
+	// both sizes are powers of two so reads stay cluster aligned
+	chunk := int64(mftReadSize)
+	if m.bs.ClusterSize > chunk {
+		chunk = m.bs.ClusterSize
+	}
+	buf := make([]byte, chunk)
+
+	for _, r := range m.runs {
+		size := r.Length * m.bs.ClusterSize
+		for done := int64(0); done < size && n < m.Size; {
+			b := buf
+			if size-done < chunk {
+				b = buf[:size-done]
+			}
+
+			if r.Cluster < 0 {
+				for i := range b {
+					b[i] = 0
+				}
+			} else if _, err = m.vol.ReadAt(b, r.Cluster*m.bs.ClusterSize+done); err != nil {
+				return
+			}
+			done += int64(len(b))
+
+			// last cluster is only partially used
+			if rem := m.Size - n; int64(len(b)) > rem {
+				b = b[:rem]
+			}
+
+			var written int
+			written, err = w.Write(b)
+			n += int64(written)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Close closes the raw volume
+func (m *rawMFT) Close() error {
+	return m.vol.Close()
+}
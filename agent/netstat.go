@@ -0,0 +1,306 @@
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+	"unsafe"
+
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+)
+
+const (
+	// GetExtended{Tcp,Udp}Table parameters (see iphlpapi.h)
+	afInet                = 2
+	afInet6               = 23
+	tcpTableOwnerPIDAll   = 5
+	udpTableOwnerPID      = 1
+	errInsufficientBuffer = syscall.Errno(122)
+
+	// size of MIB_{TCP,TCP6,UDP,UDP6}ROW_OWNER_PID structures
+	tcp4RowSize = 24
+	tcp6RowSize = 56
+	udp4RowSize = 12
+	udp6RowSize = 28
+)
+
+var (
+	iphlpapi                = syscall.NewLazyDLL("iphlpapi.dll")
+	procGetExtendedTcpTable = iphlpapi.NewProc("GetExtendedTcpTable")
+	procGetExtendedUdpTable = iphlpapi.NewProc("GetExtendedUdpTable")
+
+	tcpStates = map[uint32]string{
+		1:  "CLOSED",
+		2:  "LISTEN",
+		3:  "SYN_SENT",
+		4:  "SYN_RCVD",
+		5:  "ESTABLISHED",
+		6:  "FIN_WAIT1",
+		7:  "FIN_WAIT2",
+		8:  "CLOSE_WAIT",
+		9:  "CLOSING",
+		10: "LAST_ACK",
+		11: "TIME_WAIT",
+		12: "DELETE_TCB",
+	}
+)
+
+// Connection is a TCP or UDP endpoint owned by a process, enriched
+// with the information tracked from Sysmon events about the process
+type Connection struct {
+	Protocol   string `json:"protocol"`
+	LocalAddr  string `json:"local-addr"`
+	LocalPort  uint16 `json:"local-port"`
+	RemoteAddr string `json:"remote-addr,omitempty"`
+	RemotePort uint16 `json:"remote-port,omitempty"`
+	State      string `json:"state,omitempty"`
+	PID        int    `json:"pid"`
+	// process information
+	ProcessGUID     string `json:"process-guid"`
+	Image           string `json:"image"`
+	CommandLine     string `json:"command-line,omitempty"`
+	User            string `json:"user,omitempty"`
+	IntegrityLevel  string `json:"integrity-lvl,omitempty"`
+	Services        string `json:"services,omitempty"`
+	Signature       string `json:"signature,omitempty"`
+	SignatureStatus string `json:"signature-status,omitempty"`
+	Signed          bool   `json:"signed"`
+	// true if the process is tracked from Sysmon events
+	Tracked bool `json:"tracked"`
+}
+
+// ntohs converts a port stored in network byte order in a DWORD
+func ntohs(dw uint32) uint16 {
+	return uint16(dw&0xff)<<8 | uint16(dw>>8&0xff)
+}
+
+// ipv4 converts an IPv4 address stored in network byte order in a DWORD
+func ipv4(dw uint32) net.IP {
+	return net.IPv4(byte(dw), byte(dw>>8), byte(dw>>16), byte(dw>>24))
+}
+
+// tableRows returns the rows of a MIB_*TABLE_OWNER_PID table, the
+// number of entries is followed by rows of size rowSize
+func tableRows(table []byte, rowSize int) (rows [][]byte, err error) {
+	if len(table) < 4 {
+		return nil, fmt.Errorf("table too short")
+	}
+
+	n := int(binary.LittleEndian.Uint32(table))
+	if 4+n*rowSize > len(table) {
+		return nil, fmt.Errorf("table too short for %d entries", n)
+	}
+
+	rows = make([][]byte, n)
+	for i := range rows {
+		off := 4 + i*rowSize
+		rows[i] = table[off : off+rowSize]
+	}
+
+	return
+}
+
+// parseTCP4Table parses a MIB_TCPTABLE_OWNER_PID table
+func parseTCP4Table(table []byte) (conns []*Connection, err error) {
+	var rows [][]byte
+
+	if rows, err = tableRows(table, tcp4RowSize); err != nil {
+		return
+	}
+
+	conns = make([]*Connection, 0, len(rows))
+	for _, r := range rows {
+		conns = append(conns, &Connection{
+			Protocol:   "tcp",
+			State:      tcpStates[binary.LittleEndian.Uint32(r)],
+			LocalAddr:  ipv4(binary.LittleEndian.Uint32(r[4:])).String(),
+			LocalPort:  ntohs(binary.LittleEndian.Uint32(r[8:])),
+			RemoteAddr: ipv4(binary.LittleEndian.Uint32(r[12:])).String(),
+			RemotePort: ntohs(binary.LittleEndian.Uint32(r[16:])),
+			PID:        int(binary.LittleEndian.Uint32(r[20:])),
+		})
+	}
+
+	return
+}
+
+// parseTCP6Table parses a MIB_TCP6TABLE_OWNER_PID table
+func parseTCP6Table(table []byte) (conns []*Connection, err error) {
+	var rows [][]byte
+
+	if rows, err = tableRows(table, tcp6RowSize); err != nil {
+		return
+	}
+
+	conns = make([]*Connection, 0, len(rows))
+	for _, r := range rows {
+		conns = append(conns, &Connection{
+			Protocol:   "tcp6",
+			LocalAddr:  net.IP(r[0:16]).String(),
+			LocalPort:  ntohs(binary.LittleEndian.Uint32(r[20:])),
+			RemoteAddr: net.IP(r[24:40]).String(),
+			RemotePort: ntohs(binary.LittleEndian.Uint32(r[44:])),
+			State:      tcpStates[binary.LittleEndian.Uint32(r[48:])],
+			PID:        int(binary.LittleEndian.Uint32(r[52:])),
+		})
+	}
+
+	return
+}
+
+// parseUDP4Table parses a MIB_UDPTABLE_OWNER_PID table
+func parseUDP4Table(table []byte) (conns []*Connection, err error) {
+	var rows [][]byte
+
+	if rows, err = tableRows(table, udp4RowSize); err != nil {
+		return
+	}
+
+	conns = make([]*Connection, 0, len(rows))
+	for _, r := range rows {
+		conns = append(conns, &Connection{
+			Protocol:  "udp",
+			LocalAddr: ipv4(binary.LittleEndian.Uint32(r)).String(),
+			LocalPort: ntohs(binary.LittleEndian.Uint32(r[4:])),
+			PID:       int(binary.LittleEndian.Uint32(r[8:])),
+		})
+	}
+
+	return
+}
+
+// parseUDP6Table parses a MIB_UDP6TABLE_OWNER_PID table
+func parseUDP6Table(table []byte) (conns []*Connection, err error) {
+	var rows [][]byte
+
+	if rows, err = tableRows(table, udp6RowSize); err != nil {
+		return
+	}
+
+	conns = make([]*Connection, 0, len(rows))
+	for _, r := range rows {
+		conns = append(conns, &Connection{
+			Protocol:  "udp6",
+			LocalAddr: net.IP(r[0:16]).String(),
+			LocalPort: ntohs(binary.LittleEndian.Uint32(r[20:])),
+			PID:       int(binary.LittleEndian.Uint32(r[24:])),
+		})
+	}
+
+	return
+}
+
+// extendedTable calls GetExtendedTcpTable or GetExtendedUdpTable
+// until the buffer is large enough to hold the table
+func extendedTable(proc *syscall.LazyProc, af, class uint32) (table []byte, err error) {
+	size := uint32(0)
+
+	// table may grow between calls
+	for i := 0; i < 5; i++ {
+		var p unsafe.Pointer
+
+		if size > 0 {
+			table = make([]byte, size)
+			p = unsafe.Pointer(&table[0])
+		}
+
+		r1, _, _ := proc.Call(
+			uintptr(p),
+			uintptr(unsafe.Pointer(&size)),
+			0,
+			uintptr(af),
+			uintptr(class),
+			0)
+
+		switch syscall.Errno(r1) {
+		case 0:
+			return table[:size], nil
+		case errInsufficientBuffer:
+			continue
+		default:
+			return nil, syscall.Errno(r1)
+		}
+	}
+
+	return nil, fmt.Errorf("%s: table keeps growing", proc.Name)
+}
+
+// netstat returns the TCP and UDP endpoints of the system
+func netstat() (conns []*Connection, err error) {
+	conns = make([]*Connection, 0)
+
+	for _, q := range []struct {
+		proc  *syscall.LazyProc
+		af    uint32
+		class uint32
+		parse func([]byte) ([]*Connection, error)
+	}{
+		{procGetExtendedTcpTable, afInet, tcpTableOwnerPIDAll, parseTCP4Table},
+		{procGetExtendedTcpTable, afInet6, tcpTableOwnerPIDAll, parseTCP6Table},
+		{procGetExtendedUdpTable, afInet, udpTableOwnerPID, parseUDP4Table},
+		{procGetExtendedUdpTable, afInet6, udpTableOwnerPID, parseUDP6Table},
+	} {
+		var table []byte
+		var c []*Connection
+
+		if table, err = extendedTable(q.proc, q.af, q.class); err != nil {
+			return
+		}
+
+		if c, err = q.parse(table); err != nil {
+			return
+		}
+
+		conns = append(conns, c...)
+	}
+
+	return
+}
+
+// enrichConnection sets information about the process owning a connection
+func (pt *ActivityTracker) enrichConnection(c *Connection) {
+	c.ProcessGUID = nullGUID
+
+	if t := pt.GetByPID(int64(c.PID)); !t.IsZero() && !t.Terminated {
+		c.Tracked = true
+		c.ProcessGUID = t.ProcessGUID
+		c.Image = t.Image
+		c.CommandLine = t.CommandLine
+		c.User = t.User
+		c.IntegrityLevel = t.IntegrityLevel
+		c.Services = t.Services
+		c.Signature = t.Signature
+		c.SignatureStatus = t.SignatureStatus
+		c.Signed = t.Signed
+		return
+	}
+
+	switch c.PID {
+	case 0:
+		c.Image = "System Idle Process"
+	case 4:
+		c.Image = "System"
+	default:
+		c.Image, _ = kernel32.GetModuleFilenameFromPID(c.PID)
+	}
+}
+
+// cmdNetstat returns the TCP and UDP endpoints of the system
+// along with the information about the processes owning them
+func (a *Agent) cmdNetstat() (conns []*Connection, err error) {
+	if conns, err = netstat(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve connections: %w", err)
+	}
+
+	for _, c := range conns {
+		a.tracker.enrichConnection(c)
+	}
+
+	sort.SliceStable(conns, func(i, j int) bool {
+		return conns[i].PID < conns[j].PID
+	})
+
+	return
+}
@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golog"
+)
+
+// EventProvider is the interface of the source of the events
+// processed by the agent, ETW real-time consumer by default
+type EventProvider interface {
+	// Start starts providing events
+	Start() error
+	// Stop stops providing events, channel returned by Events must be closed
+	Stop() error
+	// Events returns the channel events are received from
+	Events() chan *etw.Event
+	// LostEvents returns the number of events lost since last call
+	LostEvents() uint64
+}
+
+// EventForwarder is the interface of the output events to be
+// forwarded are sent to, manager's forwarder by default
+type EventForwarder interface {
+	// PipeEvent sends an event to the output
+	PipeEvent(e interface{}) error
+	// Close flushes pending events and closes the output
+	Close()
+}
+
+// etwProvider makes an ETW consumer an EventProvider. Besides traces
+// the consumer opens, it can manage a real-time session, consumed
+// separately, which can be rebuilt while the provider is running
+type etwProvider struct {
+	sync.Mutex
+	*etw.Consumer
+	ctx       context.Context
+	started   bool
+	name      string
+	providers []etw.Provider
+	// real-time session and its consumer, rebuilt when providers change
+	session    *etw.RealTimeSession
+	rtConsumer *etw.Consumer
+}
+
+func newEtwProvider(ctx context.Context) *etwProvider {
+	return &etwProvider{ctx: ctx, Consumer: etw.NewRealTimeConsumer(ctx)}
+}
+
+// startRealTime starts the real-time session, if any provider is
+// enabled, and the consumer sending its events along the others
+func (p *etwProvider) startRealTime() (err error) {
+	if len(p.providers) == 0 {
+		return
+	}
+
+	s := etw.NewRealTimeSession(p.name)
+	for _, prov := range p.providers {
+		if err = s.EnableProvider(prov); err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to enable provider %s: %w", prov.Name, err)
+		}
+	}
+
+	c := etw.NewRealTimeConsumer(p.ctx)
+	c.EventCallback = p.Consumer.DefaultEventCallback
+	c.FromSessions(s)
+	if err = c.Start(); err != nil {
+		c.Stop()
+		s.Stop()
+		return
+	}
+
+	p.session, p.rtConsumer = s, c
+	return
+}
+
+// stopRealTime stops the real-time consumer and session, if any
+func (p *etwProvider) stopRealTime() (err error) {
+	if p.rtConsumer != nil {
+		err = p.rtConsumer.Stop()
+		p.Consumer.LostEvents += p.rtConsumer.LostEvents
+	}
+
+	if p.session != nil && p.session.IsStarted() {
+		if serr := p.session.Stop(); err == nil {
+			err = serr
+		}
+	}
+
+	p.session, p.rtConsumer = nil, nil
+	return
+}
+
+// Providers returns the providers enabled in the real-time session
+func (p *etwProvider) Providers() []etw.Provider {
+	p.Lock()
+	defer p.Unlock()
+	return append([]etw.Provider{}, p.providers...)
+}
+
+// UpdateProviders replaces the providers of the real-time session named
+// name. If the provider is started only the real-time session is rebuilt,
+// events of the other traces keep flowing.
+func (p *etwProvider) UpdateProviders(name string, providers []etw.Provider) (err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.name = name
+	p.providers = providers
+
+	if !p.started {
+		return
+	}
+
+	if err = p.stopRealTime(); err != nil {
+		return fmt.Errorf("failed to stop real-time session: %w", err)
+	}
+
+	return p.startRealTime()
+}
+
+// Start starts the consumer and the real-time session, if any
+func (p *etwProvider) Start() (err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if err = p.Consumer.Start(); err != nil {
+		return
+	}
+
+	if err = p.startRealTime(); err != nil {
+		p.Consumer.Stop()
+		return
+	}
+
+	p.started = true
+	return
+}
+
+// Stop stops the real-time session, if any, and the consumer
+func (p *etwProvider) Stop() (err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	// real-time consumer must be stopped before events channel is closed
+	err = p.stopRealTime()
+	if cerr := p.Consumer.Stop(); err == nil {
+		err = cerr
+	}
+	p.started = false
+	return
+}
+
+func (p *etwProvider) Events() chan *etw.Event {
+	return p.Consumer.Events
+}
+
+func (p *etwProvider) LostEvents() (n uint64) {
+	p.Lock()
+	defer p.Unlock()
+	n = p.Consumer.LostEvents
+	p.Consumer.LostEvents = 0
+	if p.rtConsumer != nil {
+		n += p.rtConsumer.LostEvents
+		p.rtConsumer.LostEvents = 0
+	}
+	return
+}
+
+type options struct {
+	ctx          context.Context
+	logger       *golog.Logger
+	provider     EventProvider
+	output       EventForwarder
+	maxEPS       float64
+	epsDur       time.Duration
+	lowPrivilege bool
+}
+
+// Option customizes an Agent when it is created, so that it can be
+// embedded in other programs without modifying package globals
+type Option func(*options)
+
+// WithContext sets the parent context of the agent, cancelling
+// it stops all the agent's routines
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithLogger sets the logger used by the agent, it takes
+// precedence over the logfile set in configuration
+func WithLogger(l *golog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithEventProvider replaces the ETW consumer events are received from,
+// ETW configuration is ignored when a custom provider is used
+func WithEventProvider(p EventProvider) Option {
+	return func(o *options) {
+		o.provider = p
+	}
+}
+
+// WithEventForwarder sends the events to be forwarded to a custom output
+// instead of the manager, the agent still uses the manager for its other
+// communications (rules, commands ...) if one is configured
+func WithEventForwarder(f EventForwarder) Option {
+	return func(o *options) {
+		o.output = f
+	}
+}
+
+// WithMaxEPS sets the event rate above which the agent reports
+// performance issues, averaged over duration
+func WithMaxEPS(eps float64, duration time.Duration) Option {
+	return func(o *options) {
+		o.maxEPS = eps
+		o.epsDur = duration
+	}
+}
+
+// WithLowPrivilege runs the agent as the processing component of privilege
+// separation (see Collector), tasks requiring privileges (audit policies
+// configuration, response actions) are not performed
+func WithLowPrivilege() Option {
+	return func(o *options) {
+		o.lowPrivilege = true
+	}
+}
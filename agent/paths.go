@@ -45,8 +45,16 @@ var (
 
 	// EventID 3: NetworkConnect
 	pathSysmonDestIP       = EventDataPath("DestinationIp")
+	pathSysmonSourceIP     = EventDataPath("SourceIp")
 	pathSysmonDestPort     = EventDataPath("DestinationPort")
 	pathSysmonDestHostname = EventDataPath("DestinationHostname")
+	pathSysmonInitiated    = EventDataPath("Initiated")
+
+	// Microsoft-Windows-Kernel-Network
+	pathKernelNetworkPID   = EventDataPath("PID")
+	pathKernelNetworkSize  = EventDataPath("size")
+	pathKernelNetworkDaddr = EventDataPath("daddr")
+	pathKernelNetworkDport = EventDataPath("dport")
 
 	// EventID 6/7
 	pathSysmonFileVersion      = engine.Path(eventData + "FileVersion")
@@ -122,6 +130,10 @@ var (
 
 	// Use to store value size by hooking on SetValue events
 	pathValueSize = EventDataPath("ValueSize")
+	// set to true if the value set contains a base64 blob
+	pathValueBase64 = EventDataPath("ValueBase64")
+	// set to true if the value set is dumped
+	pathValueDumped = EventDataPath("ValueDumped")
 
 	// Use to store parent image and command line in image load events
 	pathImageLoadParentImage       = EventDataPath("ParentImage")
@@ -152,6 +164,77 @@ var (
 	pathFileExtension  = EventDataPath("Extension")
 	pathFileFrequency  = EventDataPath("FrequencyEps")
 
+	// Used to store the domain IoC matched by a DNS query or one of its answers,
+	// or by the domain the destination of a network connection was resolved from
+	pathDomainIoC = EventDataPath("DomainIoC")
+	// Used to store the domain the destination of a network connection was resolved from
+	pathResolvedDomain = EventDataPath("ResolvedDomain")
+
+	// Used to store the outcome of process creation blocking
+	pathPrevention = EventDataPath("Prevention")
+
+	// Used to store provenance of IoC matching a detection
+	pathIoCValue      = EventDataPath("IoCValue")
+	pathIoCSource     = EventDataPath("IoCSource")
+	pathIoCConfidence = EventDataPath("IoCConfidence")
+
+	// Used to store DGA score of DNS queries
+	pathDGAScore = EventDataPath("DGAScore")
+
+	// Used to find accounts in Security events
+	pathSubjectUserName   = EventDataPath("SubjectUserName")
+	pathSubjectDomainName = EventDataPath("SubjectDomainName")
+	pathTargetUserName    = EventDataPath("TargetUserName")
+	pathTargetDomainName  = EventDataPath("TargetDomainName")
+
+	// Used to track lateral movements in Security events
+	pathLogonType          = EventDataPath("LogonType")
+	pathTargetLogonId      = EventDataPath("TargetLogonId")
+	pathSubjectLogonId     = EventDataPath("SubjectLogonId")
+	pathIpAddress          = EventDataPath("IpAddress")
+	pathShareName          = EventDataPath("ShareName")
+	pathRelativeTargetName = EventDataPath("RelativeTargetName")
+	pathServiceName        = EventDataPath("ServiceName")
+	pathServiceFileName    = EventDataPath("ServiceFileName")
+	pathTaskName           = EventDataPath("TaskName")
+
+	// Used to track processes from Security process audit events
+	pathNewProcessId      = EventDataPath("NewProcessId")
+	pathNewProcessName    = EventDataPath("NewProcessName")
+	pathAuditProcessId    = EventDataPath("ProcessId")
+	pathAuditProcessName  = EventDataPath("ProcessName")
+	pathParentProcessName = EventDataPath("ParentProcessName")
+	pathMandatoryLabel    = EventDataPath("MandatoryLabel")
+
+	// Used to detect Kerberos anomalies in Security events
+	pathKerberosStatus       = EventDataPath("Status")
+	pathTicketEncryptionType = EventDataPath("TicketEncryptionType")
+	// Used to store Kerberos anomalies
+	pathKerberosRC4Downgrade  = EventDataPath("KerberosRC4Downgrade")
+	pathKerberoasting         = EventDataPath("Kerberoasting")
+	pathKerberoastingServices = EventDataPath("KerberoastingServices")
+	pathKerberosForgedTicket  = EventDataPath("KerberosForgedTicket")
+	pathKerberosForgedReason  = EventDataPath("KerberosForgedReason")
+
+	// Used to store user watchlists accounts of an event belong to
+	pathWatchlists = EventDataPath("Watchlists")
+
+	// Raw TLS handshake messages (hex encoded) found in network telemetry
+	pathTLSClientHello = EventDataPath("TlsClientHello")
+	pathTLSServerHello = EventDataPath("TlsServerHello")
+	// Used to store TLS fingerprints
+	pathJA3      = EventDataPath("JA3")
+	pathJA3Hash  = EventDataPath("JA3Hash")
+	pathJA3S     = EventDataPath("JA3S")
+	pathJA3SHash = EventDataPath("JA3SHash")
+
+	// Used to store beaconing information in NetworkConnect events
+	pathBeaconing       = EventDataPath("Beaconing")
+	pathBeaconCount     = EventDataPath("BeaconCount")
+	pathBeaconInterval  = EventDataPath("BeaconInterval")
+	pathBeaconJitter    = EventDataPath("BeaconJitter")
+	pathBeaconMeanBytes = EventDataPath("BeaconMeanBytes")
+
 	// ProcessProtectionLevel
 	pathProtectionLevel       = EventDataPath("ProtectionLevel")
 	pathSourceProtectionLevel = EventDataPath("SourceProtectionLevel")
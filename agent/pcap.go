@@ -0,0 +1,328 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// ActionPcap rule action capturing the traffic of the alerting process
+	ActionPcap = "pcap"
+
+	// placeholders replaced in capture tool arguments
+	PcapOutput   = "{{output}}"
+	PcapFilter   = "{{filter}}"
+	PcapDuration = "{{duration}}"
+	PcapSnapLen  = "{{snaplen}}"
+
+	pcapFilename = "capture.pcap"
+
+	// built-in ETW packet capture tool
+	pktmon = "pktmon.exe"
+	// time given to capture tools to stop and write capture
+	pcapGrace = time.Minute
+
+	pcapMagic       = 0xa1b2c3d4
+	pcapNanoMagic   = 0xa1b23c4d
+	pcapngSHB       = 0x0a0d0d0a
+	pcapngByteOrder = 0x1a2b3c4d
+)
+
+var (
+	ErrPcapDisabled = errors.New("packet capture is disabled")
+	ErrPcapRunning  = errors.New("packet capture already running")
+	ErrPcapNoHost   = errors.New("no connection to capture")
+)
+
+// captureHosts returns the remote hosts to capture traffic with, ip (i.e. the
+// destination of the alerting connection) first then the hosts the process
+// most recently connected to, at most max hosts are returned
+func captureHosts(ip string, conns map[string]*ConStat, max int) (hosts []string) {
+	hosts = make([]string, 0)
+	seen := make(map[string]bool)
+
+	ips := make([]string, 0, len(conns))
+	for ip := range conns {
+		ips = append(ips, ip)
+	}
+
+	// timestamps are UTC and sort lexicographically
+	sort.Slice(ips, func(i, j int) bool {
+		return conns[ips[i]].LastSeen > conns[ips[j]].LastSeen
+	})
+
+	for _, s := range append([]string{ip}, ips...) {
+		addr := net.ParseIP(s)
+		if addr == nil || addr.IsLoopback() || addr.IsUnspecified() {
+			continue
+		}
+
+		if h := addr.String(); !seen[h] && len(hosts) < max {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+
+	return
+}
+
+// bpfFilter returns a BPF filter matching traffic with hosts
+func bpfFilter(hosts []string) string {
+	filters := make([]string, len(hosts))
+	for i, h := range hosts {
+		filters[i] = fmt.Sprintf("host %s", h)
+	}
+	return strings.Join(filters, " or ")
+}
+
+// pcapArgs returns capture tool arguments with placeholders replaced
+func pcapArgs(args []string, output, filter string, duration time.Duration, snaplen int) []string {
+	r := strings.NewReplacer(
+		PcapOutput, output,
+		PcapFilter, filter,
+		PcapDuration, strconv.Itoa(int(duration.Seconds())),
+		PcapSnapLen, strconv.Itoa(snaplen),
+	)
+
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = r.Replace(arg)
+	}
+	return out
+}
+
+// captureCut returns the offset at which a pcap or pcapng capture of
+// size bytes must be cut not to exceed max bytes, so that the capture
+// is made of whole records
+func captureCut(r io.ReaderAt, size, max int64) (cut int64, err error) {
+	var order binary.ByteOrder
+	var off int64
+
+	if size <= max {
+		return size, nil
+	}
+
+	buf := make([]byte, 16)
+	if _, err = r.ReadAt(buf[:4], 0); err != nil {
+		return 0, fmt.Errorf("failed to read capture header: %w", err)
+	}
+
+	// pcap is a header followed by records with a 16 bytes header
+	for _, order = range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if m := order.Uint32(buf); m == pcapMagic || m == pcapNanoMagic {
+			for off = 24; off <= max; {
+				cut = off
+				if _, err = r.ReadAt(buf, off); err != nil {
+					return cut, nil
+				}
+				off += 16 + int64(order.Uint32(buf[8:]))
+			}
+			return
+		}
+	}
+
+	if binary.LittleEndian.Uint32(buf) != pcapngSHB {
+		return 0, fmt.Errorf("unknown capture format")
+	}
+
+	// pcapng is made of blocks, byte order is given by section header blocks
+	for off = 0; off <= max; {
+		cut = off
+		if _, err = r.ReadAt(buf[:12], off); err != nil {
+			return cut, nil
+		}
+
+		if binary.LittleEndian.Uint32(buf) == pcapngSHB {
+			order = binary.LittleEndian
+			if binary.BigEndian.Uint32(buf[8:]) == pcapngByteOrder {
+				order = binary.BigEndian
+			}
+		}
+
+		length := int64(order.Uint32(buf[4:]))
+		if length < 12 {
+			return cut, fmt.Errorf("bad pcapng block length at offset %d", off)
+		}
+		off += length
+	}
+
+	return
+}
+
+// truncateCapture truncates capture at path to at most max bytes
+func truncateCapture(path string, max int64) (err error) {
+	var fd *os.File
+	var stat os.FileInfo
+	var cut int64
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if stat, err = fd.Stat(); err != nil {
+		return
+	}
+
+	if cut, err = captureCut(fd, stat.Size(), max); err != nil {
+		return
+	}
+
+	if cut == 0 {
+		return fmt.Errorf("capture header is bigger than %d bytes", max)
+	}
+
+	if cut < stat.Size() {
+		fd.Close()
+		return os.Truncate(path, cut)
+	}
+
+	return
+}
+
+func pktmonCmd(args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pcapGrace)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, pktmon, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pktmon %s failed: %w: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// pktmonCapture captures traffic with hosts with built-in ETW packet capture,
+// pktmon filters are global so any pktmon filter configured is removed
+func (a *Agent) pktmonCapture(hosts []string, output string) (err error) {
+	c := a.config.PcapConfig
+	etl := strings.TrimSuffix(output, filepath.Ext(output)) + ".etl"
+	defer os.Remove(etl)
+
+	if err = pktmonCmd("filter", "remove"); err != nil {
+		return
+	}
+	defer pktmonCmd("filter", "remove")
+
+	for i, h := range hosts {
+		if err = pktmonCmd("filter", "add", fmt.Sprintf("whids-%d", i), "-i", h); err != nil {
+			return
+		}
+	}
+
+	// etl is bigger than the capture extracted from it
+	mb := strconv.FormatInt(c.MaxSize/utils.Mega+1, 10)
+	if err = pktmonCmd("start", "--capture", "--pkt-size", strconv.Itoa(c.SnapLen), "--file-name", etl, "--file-size", mb); err != nil {
+		return
+	}
+
+	select {
+	case <-time.After(c.Duration):
+	case <-a.ctx.Done():
+	}
+
+	if err = pktmonCmd("stop"); err != nil {
+		return
+	}
+
+	return pktmonCmd("etl2pcap", etl, "--out", output)
+}
+
+// toolCapture captures traffic with hosts with the capture tool configured
+func (a *Agent) toolCapture(hosts []string, output string) error {
+	c := a.config.PcapConfig
+
+	ctx, cancel := context.WithTimeout(a.ctx, c.Duration+pcapGrace)
+	defer cancel()
+
+	args := pcapArgs(c.Args, output, bpfFilter(hosts), c.Duration, c.SnapLen)
+	if out, err := exec.CommandContext(ctx, c.Tool, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("capture tool failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// packetCapture captures, during configured duration, the traffic exchanged
+// with hosts. Capture is written to output truncated to configured size.
+func (a *Agent) packetCapture(hosts []string, output string) (err error) {
+	c := a.config.PcapConfig
+
+	if !c.Enable {
+		return ErrPcapDisabled
+	}
+
+	if len(hosts) == 0 {
+		return ErrPcapNoHost
+	}
+
+	a.capture.Lock()
+	if a.capture.running {
+		a.capture.Unlock()
+		return ErrPcapRunning
+	}
+	a.capture.running = true
+	a.capture.Unlock()
+
+	defer func() {
+		a.capture.Lock()
+		defer a.capture.Unlock()
+		a.capture.running = false
+	}()
+
+	if c.Tool == "" {
+		err = a.pktmonCapture(hosts, output)
+	} else {
+		err = a.toolCapture(hosts, output)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to capture traffic: %w", err)
+	}
+
+	return truncateCapture(output, c.MaxSize)
+}
+
+// pcap captures in background the traffic of the process an
+// event comes from, capture is dumped with the other dumps
+func (m *ActionHandler) pcap(e *event.EdrEvent) error {
+	hash := e.Hash()
+
+	pt := m.edr.tracker.SourceTrackFromEvent(e)
+	if pt.IsZero() {
+		return fmt.Errorf("cannot capture traffic of untracked process event=%s", hash)
+	}
+
+	ip, _ := e.GetString(pathSysmonDestIP)
+	hosts := captureHosts(ip, pt.Stats.Connections, m.edr.config.PcapConfig.MaxHosts)
+	if len(hosts) == 0 {
+		return fmt.Errorf("cannot capture traffic of process event=%s pid=%d: %w", hash, pt.PID, ErrPcapNoHost)
+	}
+
+	output := m.prepare(e, pcapFilename)
+	go func() {
+		m.edr.logger.Infof("Capturing traffic of process PID=%d Image=%s with %d hosts", pt.PID, pt.Image, len(hosts))
+		if err := m.edr.packetCapture(hosts, output); err != nil {
+			m.edr.logger.Errorf("event=%s: %s", hash, err)
+			os.Remove(output)
+			return
+		}
+		m.queueCompression(output)
+	}()
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// agent subsystems for which time spent is accounted
+	SubsystemHooks     = "hooks"
+	SubsystemEngine    = "engine"
+	SubsystemForwarder = "forwarder"
+	SubsystemDumps     = "dumps"
+)
+
+var (
+	// PerfDefaultDuration default sampling period of performance reports
+	PerfDefaultDuration = 30 * time.Second
+	// PerfMaxDuration maximum sampling period of performance reports
+	PerfMaxDuration = 10 * time.Minute
+
+	ErrPerfRunning = errors.New("performance report already running")
+)
+
+// perfCounter accounts for the time spent in a subsystem
+type perfCounter struct {
+	calls int64
+	nanos int64
+}
+
+// since accounts for a call started at start
+func (c *perfCounter) since(start time.Time) {
+	atomic.AddInt64(&c.calls, 1)
+	atomic.AddInt64(&c.nanos, int64(time.Since(start)))
+}
+
+func (c *perfCounter) load() (calls int64, d time.Duration) {
+	return atomic.LoadInt64(&c.calls), time.Duration(atomic.LoadInt64(&c.nanos))
+}
+
+// perfCounters must be allocated so that counters are 64-bit
+// aligned for atomic operations
+type perfCounters struct {
+	hooks     perfCounter
+	engine    perfCounter
+	forwarder perfCounter
+	dumps     perfCounter
+	// non zero if a report is running
+	running int32
+}
+
+// SubsystemPerf holds the time spent in a subsystem during the sampling period
+type SubsystemPerf struct {
+	Calls int64 `json:"calls"`
+	// time spent in the subsystem, several calls may run concurrently
+	Time time.Duration `json:"time"`
+	// average time per call
+	Average time.Duration `json:"average"`
+	// percentage of sampling period the subsystem was busy
+	Load float64 `json:"load"`
+}
+
+func newSubsystemPerf(calls int64, d, period time.Duration) *SubsystemPerf {
+	s := &SubsystemPerf{Calls: calls, Time: d}
+	if calls > 0 {
+		s.Average = d / time.Duration(calls)
+	}
+	if period > 0 {
+		s.Load = float64(d) * 100 / float64(period)
+	}
+	return s
+}
+
+// PerfReport is a self assessment of the resources used by the agent
+type PerfReport struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	CPU      struct {
+		User   time.Duration `json:"user"`
+		Kernel time.Duration `json:"kernel"`
+		// percentage of one CPU used
+		Percent float64 `json:"percent"`
+		// percentage of all the CPUs of the endpoint used
+		PercentTotal float64 `json:"percent-total"`
+		NumCPU       int     `json:"num-cpu"`
+	} `json:"cpu"`
+	Memory struct {
+		WorkingSet     uint64 `json:"working-set"`
+		PeakWorkingSet uint64 `json:"peak-working-set"`
+		PrivateBytes   uint64 `json:"private-bytes"`
+		PageFaults     uint32 `json:"page-faults"`
+		HeapAlloc      uint64 `json:"heap-alloc"`
+		HeapSys        uint64 `json:"heap-sys"`
+		GC             uint32 `json:"gc"`
+	} `json:"memory"`
+	IO struct {
+		ReadOps    uint64 `json:"read-ops"`
+		WriteOps   uint64 `json:"write-ops"`
+		ReadBytes  uint64 `json:"read-bytes"`
+		WriteBytes uint64 `json:"write-bytes"`
+	} `json:"io"`
+	Handles struct {
+		Start uint32 `json:"start"`
+		End   uint32 `json:"end"`
+		Delta int64  `json:"delta"`
+	} `json:"handles"`
+	Goroutines int     `json:"goroutines"`
+	Events     int64   `json:"events"`
+	EPS        float64 `json:"eps"`
+	// time spent by agent's subsystems
+	Subsystems map[string]*SubsystemPerf `json:"subsystems"`
+	// hooks disabled after panicking too often
+	DisabledHooks []string `json:"disabled-hooks"`
+	// file hash cache usage during the sampling period
+	HashCache *HashCacheStats `json:"hash-cache,omitempty"`
+}
+
+// perfSample is a snapshot of agent's resources and subsystems counters
+type perfSample struct {
+	time       time.Time
+	resources  utils.ProcessResources
+	mem        runtime.MemStats
+	events     float64
+	counters   map[string][2]int64
+	forwarding time.Duration
+	hashCache  HashCacheStats
+}
+
+func (a *Agent) perfSample() (s perfSample, err error) {
+	s.time = time.Now()
+	if s.resources, err = utils.SelfResources(); err != nil {
+		return
+	}
+	runtime.ReadMemStats(&s.mem)
+	s.events = a.stats.Events()
+	s.counters = make(map[string][2]int64)
+	for name, c := range map[string]*perfCounter{
+		SubsystemHooks:     &a.perf.hooks,
+		SubsystemEngine:    &a.perf.engine,
+		SubsystemForwarder: &a.perf.forwarder,
+		SubsystemDumps:     &a.perf.dumps,
+	} {
+		calls, d := c.load()
+		s.counters[name] = [2]int64{calls, int64(d)}
+	}
+	if a.forwarder != nil {
+		s.forwarding = a.forwarder.Busy()
+	}
+	s.hashCache = a.hashCache.Stats()
+	return
+}
+
+// perfReport computes a performance report out of two samples
+func perfReport(start, end *perfSample) (r *PerfReport) {
+	r = &PerfReport{
+		Start:      start.time.UTC(),
+		End:        end.time.UTC(),
+		Duration:   end.time.Sub(start.time),
+		Goroutines: runtime.NumGoroutine(),
+		Events:     int64(end.events - start.events),
+		Subsystems: make(map[string]*SubsystemPerf),
+	}
+
+	r.CPU.User = end.resources.UserTime - start.resources.UserTime
+	r.CPU.Kernel = end.resources.KernelTime - start.resources.KernelTime
+	r.CPU.NumCPU = runtime.NumCPU()
+	if r.Duration > 0 {
+		r.CPU.Percent = float64(r.CPU.User+r.CPU.Kernel) * 100 / float64(r.Duration)
+		r.CPU.PercentTotal = r.CPU.Percent / float64(r.CPU.NumCPU)
+		r.EPS = float64(r.Events) / r.Duration.Seconds()
+	}
+
+	r.Memory.WorkingSet = end.resources.WorkingSet
+	r.Memory.PeakWorkingSet = end.resources.PeakWorkingSet
+	r.Memory.PrivateBytes = end.resources.PrivateBytes
+	r.Memory.PageFaults = end.resources.PageFaults - start.resources.PageFaults
+	r.Memory.HeapAlloc = end.mem.HeapAlloc
+	r.Memory.HeapSys = end.mem.HeapSys
+	r.Memory.GC = end.mem.NumGC - start.mem.NumGC
+
+	r.IO.ReadOps = end.resources.ReadOps - start.resources.ReadOps
+	r.IO.WriteOps = end.resources.WriteOps - start.resources.WriteOps
+	r.IO.ReadBytes = end.resources.ReadBytes - start.resources.ReadBytes
+	r.IO.WriteBytes = end.resources.WriteBytes - start.resources.WriteBytes
+
+	r.Handles.Start = start.resources.Handles
+	r.Handles.End = end.resources.Handles
+	r.Handles.Delta = int64(end.resources.Handles) - int64(start.resources.Handles)
+
+	for name, e := range end.counters {
+		s := start.counters[name]
+		d := time.Duration(e[1] - s[1])
+		// forwarder also spends time sending events in background
+		if name == SubsystemForwarder {
+			d += end.forwarding - start.forwarding
+		}
+		r.Subsystems[name] = newSubsystemPerf(e[0]-s[0], d, r.Duration)
+	}
+
+	return
+}
+
+// cmdPerf measures resources used by the agent and time spent
+// by its subsystems over a sampling period
+func (a *Agent) cmdPerf(period time.Duration) (r *PerfReport, err error) {
+	var start, end perfSample
+
+	if period <= 0 {
+		period = PerfDefaultDuration
+	}
+
+	if period > PerfMaxDuration {
+		return nil, fmt.Errorf("sampling period cannot exceed %s", PerfMaxDuration)
+	}
+
+	if !atomic.CompareAndSwapInt32(&a.perf.running, 0, 1) {
+		return nil, ErrPerfRunning
+	}
+	defer atomic.StoreInt32(&a.perf.running, 0)
+
+	if start, err = a.perfSample(); err != nil {
+		return nil, fmt.Errorf("failed to sample agent resources: %w", err)
+	}
+
+	select {
+	case <-time.After(period):
+	case <-a.ctx.Done():
+		return nil, a.ctx.Err()
+	}
+
+	if end, err = a.perfSample(); err != nil {
+		return nil, fmt.Errorf("failed to sample agent resources: %w", err)
+	}
+
+	r = perfReport(&start, &end)
+	r.DisabledHooks = append(a.preHooks.Disabled(), a.postHooks.Disabled()...)
+	if a.hashCache != nil {
+		r.HashCache = &HashCacheStats{
+			Entries:    end.hashCache.Entries,
+			Hits:       end.hashCache.Hits - start.hashCache.Hits,
+			Misses:     end.hashCache.Misses - start.hashCache.Misses,
+			BytesSaved: end.hashCache.BytesSaved - start.hashCache.BytesSaved,
+		}
+	}
+
+	return
+}
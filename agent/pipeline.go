@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// size of the queue of every pipeline worker
+	pipelineQueueSize = 1024
+)
+
+// partitionKey returns the key used to dispatch an event to a pipeline
+// worker, events sharing the same key are processed in order
+func partitionKey(e *event.EdrEvent) string {
+	if guid, ok := e.GetString(pathSysmonProcessGUID); ok {
+		return guid
+	}
+
+	// ProcessAccess and CreateRemoteThread events
+	if guid, ok := e.GetString(pathSysmonSourceProcessGUID); ok {
+		return guid
+	}
+
+	// non Sysmon events are keyed by the PID of the process generating them
+	return strconv.FormatUint(uint64(e.Event.System.Execution.ProcessID), 10)
+}
+
+// fnv32a returns the FNV-1a hash of s, unlike hash/fnv it does
+// not allocate as it is computed for every event dispatched
+func fnv32a(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// pipeline dispatches events to a set of workers. If ordered, events
+// are sharded by process so that every event of a given process
+// is processed by the same worker, otherwise they are dispatched
+// in a round robin fashion
+type pipeline struct {
+	ordered bool
+	next    int
+	workers []chan *event.EdrEvent
+	wg      sync.WaitGroup
+}
+
+// newPipeline creates a new pipeline of n workers (at least one)
+// running process on every event dispatched
+func newPipeline(n int, ordered bool, process func(*event.EdrEvent)) (p *pipeline) {
+	if n < 1 {
+		n = 1
+	}
+
+	p = &pipeline{
+		ordered: ordered,
+		workers: make([]chan *event.EdrEvent, n),
+	}
+
+	for i := range p.workers {
+		c := make(chan *event.EdrEvent, pipelineQueueSize)
+		p.workers[i] = c
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for e := range c {
+				process(e)
+			}
+		}()
+	}
+
+	return
+}
+
+// worker returns the index of the worker an event has to be dispatched to
+func (p *pipeline) worker(e *event.EdrEvent) (i int) {
+	if len(p.workers) == 1 {
+		return 0
+	}
+
+	if p.ordered {
+		return int(fnv32a(partitionKey(e)) % uint32(len(p.workers)))
+	}
+
+	i = p.next
+	p.next = (p.next + 1) % len(p.workers)
+	return
+}
+
+// Dispatch queues an event to be processed, it must not be
+// called concurrently
+func (p *pipeline) Dispatch(e *event.EdrEvent) {
+	p.workers[p.worker(e)] <- e
+}
+
+// Close waits for all the events dispatched to be processed
+func (p *pipeline) Close() {
+	for _, c := range p.workers {
+		close(c)
+	}
+	p.wg.Wait()
+}
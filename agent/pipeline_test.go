@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"testing"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/event"
+)
+
+func TestPipelineOrdering(t *testing.T) {
+	var processed int
+
+	tt := toast.FromT(t)
+
+	nproc, count := 16, 1000
+	mut := sync.Mutex{}
+	last := make(map[string]int)
+	ordered := true
+
+	p := newPipeline(4, true, func(e *event.EdrEvent) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		guid := partitionKey(e)
+		seq := int(e.Event.System.EventID)
+		if seq < last[guid] {
+			ordered = false
+		}
+		last[guid] = seq
+		processed++
+	})
+
+	for i := 0; i < count; i++ {
+		e := etw.NewEvent()
+		e.System.Channel = sysmonChannel
+		e.System.EventID = uint16(i)
+		e.EventData["ProcessGuid"] = fmt.Sprintf("{%d}", i%nproc)
+		p.Dispatch(event.NewEdrEvent(e))
+	}
+	p.Close()
+
+	tt.Assert(processed == count)
+	tt.Assert(len(last) == nproc)
+	tt.Assert(ordered)
+}
+
+func TestPartitionKey(t *testing.T) {
+	tt := toast.FromT(t)
+
+	e := etw.NewEvent()
+	e.System.Execution.ProcessID = 4242
+	tt.Assert(partitionKey(event.NewEdrEvent(e)) == "4242")
+
+	e.EventData["SourceProcessGUID"] = "{source}"
+	tt.Assert(partitionKey(event.NewEdrEvent(e)) == "{source}")
+
+	e.EventData["ProcessGuid"] = "{guid}"
+	tt.Assert(partitionKey(event.NewEdrEvent(e)) == "{guid}")
+}
+
+func TestFnv32a(t *testing.T) {
+	tt := toast.FromT(t)
+
+	for _, s := range []string{"", "4242", "{515cd0d1-2921-6152-721b-000000008200}"} {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		tt.Assert(fnv32a(s) == h.Sum32())
+	}
+}
+
+func BenchmarkPipelineWorker(b *testing.B) {
+	e := etw.NewEvent()
+	e.System.Channel = sysmonChannel
+	e.EventData["ProcessGuid"] = "{515cd0d1-2921-6152-721b-000000008200}"
+	ee := event.NewEdrEvent(e)
+
+	p := &pipeline{ordered: true, workers: make([]chan *event.EdrEvent, 4)}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.worker(ee)
+	}
+}
@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// PlaybookRuleName name of the detection reported to the manager
+	// by playbook notify steps
+	PlaybookRuleName = "Builtin:Playbook"
+
+	// event ID of the event generated by playbook notify steps
+	agentPlaybookEventID = 2
+)
+
+// Playbooks holds response playbooks pulled from manager
+type Playbooks struct {
+	sync.RWMutex
+	list   []*api.Playbook
+	sha256 string
+	// maps rule names to their tags
+	ruleTags map[string][]string
+	// playbooks already run, by process, entries are
+	// released when the process terminates
+	ran map[string]map[string]bool
+}
+
+// NewPlaybooks creates a new Playbooks structure
+func NewPlaybooks() *Playbooks {
+	return &Playbooks{
+		list:     make([]*api.Playbook, 0),
+		ruleTags: make(map[string][]string),
+		ran:      make(map[string]map[string]bool),
+	}
+}
+
+// ruleTagsMap maps the rules loaded in an engine to their tags
+func ruleTagsMap(e *engine.Engine) (m map[string][]string) {
+	m = make(map[string][]string)
+	for _, name := range e.GetRuleNames() {
+		r := engine.Rule{}
+		if err := json.Unmarshal([]byte(e.GetRawRuleByName(name)), &r); err == nil && len(r.Tags) > 0 {
+			m[name] = r.Tags
+		}
+	}
+	return
+}
+
+// Update updates playbooks
+func (p *Playbooks) Update(list []*api.Playbook, sha256 string) {
+	p.Lock()
+	defer p.Unlock()
+	p.list = list
+	p.sha256 = sha256
+}
+
+// SetRuleTags must be called when a new engine is loaded so
+// that playbooks can be bound to detections
+func (p *Playbooks) SetRuleTags(e *engine.Engine) {
+	m := ruleTagsMap(e)
+
+	p.Lock()
+	defer p.Unlock()
+	p.ruleTags = m
+}
+
+// Sha256 returns the sha256 of the playbooks as computed by manager
+func (p *Playbooks) Sha256() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.sha256
+}
+
+// Len returns the number of playbooks
+func (p *Playbooks) Len() int {
+	p.RLock()
+	defer p.RUnlock()
+	return len(p.list)
+}
+
+// ForDetection returns the first playbook bound to the tags of
+// the rules which matched, nil if there is none
+func (p *Playbooks) ForDetection(d *engine.Detection) *api.Playbook {
+	if d == nil || d.Signature == nil {
+		return nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	if len(p.list) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0)
+	for _, s := range d.Signature.Slice() {
+		tags = append(tags, p.ruleTags[s.(string)]...)
+	}
+
+	for _, pb := range p.list {
+		if pb.Match(tags) {
+			return pb
+		}
+	}
+
+	return nil
+}
+
+// once returns true only the first time a playbook is run for a given key
+func (p *Playbooks) once(pb *api.Playbook, key string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	ran, ok := p.ran[key]
+	if !ok {
+		ran = make(map[string]bool)
+		p.ran[key] = ran
+	}
+
+	if ran[pb.Name] {
+		return false
+	}
+	ran[pb.Name] = true
+	return true
+}
+
+// forget releases the playbooks run for key, it must be
+// called when the process identified by key terminates
+func (p *Playbooks) forget(key string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.ran, key)
+}
+
+// playbookNotifyEvent generates the event sent to the manager by a notify step
+func (a *Agent) playbookNotifyEvent(pb *api.Playbook, e *event.EdrEvent, message string) *event.EdrEvent {
+	n := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	n.System.Channel = agentChannel
+	n.System.Computer = hostname
+	n.System.EventID = agentPlaybookEventID
+	n.System.Execution.ProcessID = u32PID
+	n.System.Provider.Name = agentChannel
+	n.System.TimeCreated.SystemTime = time.Now()
+	n.EventData["Playbook"] = pb.Name
+	n.EventData["Message"] = message
+	n.EventData["EventHash"] = e.Hash()
+
+	d := engine.NewDetection(false, false)
+	d.Signature.Add(PlaybookRuleName)
+	if det := e.GetDetection(); det != nil && det.Signature != nil {
+		n.EventData["Signature"] = strings.Join(detectionSignatures(det), ",")
+		d.Criticality = det.Criticality
+	}
+
+	edrEvt := event.NewEdrEvent(n)
+	edrEvt.NormalizeTime()
+	edrEvt.SetDetection(d)
+
+	return edrEvt
+}
+
+// runPlaybookStep runs a single playbook step on the event which triggered the playbook
+func (m *ActionHandler) runPlaybookStep(pb *api.Playbook, s *api.PlaybookStep, e *event.EdrEvent) (err error) {
+	if err = checkStepCompiled(s.Action); err != nil {
+		return
+	}
+
+	// playbooks are not approved within responder sessions
+	if m.edr.config.ResponderConfig.ApprovalKey != "" && api.IsDestructivePlaybookStep(s.Action) {
+		return fmt.Errorf("refusing to run %s step: %w", s.Action, api.ErrApprovalRequired)
+	}
+
+	switch s.Action {
+	case api.PlaybookStepSuspend:
+		return m.suspend_process(e)
+
+	case api.PlaybookStepKill:
+		return m.kill_process(e)
+
+	case api.PlaybookStepMemdump:
+		return m.memdump(e)
+
+	case api.PlaybookStepFiledump:
+		return m.filedump(e)
+
+	case api.PlaybookStepTriage:
+		if !m.edr.config.Report.EnableReporting {
+			return fmt.Errorf("reporting is disabled")
+		}
+
+		reportPath := m.prepare(e, reportFilename)
+		if err = m.dumpAsJson(reportPath, m.edr.Report(false)); err != nil {
+			return fmt.Errorf("failed to dump report: %w", err)
+		}
+		m.queueCompression(reportPath)
+
+		return m.filedump(e)
+
+	case api.PlaybookStepIsolate:
+		var release time.Duration
+
+		profile := ContainProfileFull
+		if len(s.Args) > 0 {
+			profile = s.Args[0]
+		}
+
+		if len(s.Args) > 1 {
+			if release, err = time.ParseDuration(s.Args[1]); err != nil {
+				return fmt.Errorf("bad containment duration: %w", err)
+			}
+		}
+
+		return m.edr.contain(profile, release)
+
+	case api.PlaybookStepNotify:
+		message := strings.Join(s.Args, " ")
+		return m.edr.forward(m.edr.playbookNotifyEvent(pb, e, message))
+	}
+
+	return fmt.Errorf("unknown playbook action: %s", s.Action)
+}
+
+// runPlaybook runs playbook steps in order, stopping at the first
+// failing step unless it is allowed to fail
+func (m *ActionHandler) runPlaybook(pb *api.Playbook, e *event.EdrEvent) (run *api.PlaybookRun) {
+	run = api.NewPlaybookRun(pb.Name)
+	run.EventHash = e.Hash()
+	if d := e.GetDetection(); d != nil && d.Signature != nil {
+		run.Signature = detectionSignatures(d)
+	}
+
+	failed := false
+	for _, s := range pb.Steps {
+		r := &api.PlaybookStepResult{Action: s.Action, Start: time.Now().UTC()}
+		run.Steps = append(run.Steps, r)
+
+		if failed {
+			r.Skipped = true
+			continue
+		}
+
+		if err := m.runPlaybookStep(pb, s, e); err != nil {
+			r.Error = err.Error()
+			failed = !s.ContinueOnError
+			m.edr.logger.Errorf("playbook %s step %s failed on event=%s: %s", pb.Name, s.Action, run.EventHash, err)
+		} else {
+			r.Success = true
+		}
+		r.Duration = time.Since(r.Start)
+	}
+
+	run.Done()
+	m.edr.logger.Infof("playbook %s run on event=%s success=%t", pb.Name, run.EventHash, run.Success)
+
+	return
+}
+
+// handlePlaybook runs a playbook, at most once per process at the origin
+// of the alerts, and reports its run to the manager
+func (m *ActionHandler) handlePlaybook(pb *api.Playbook, e *event.EdrEvent) {
+	if !m.edr.playbooks.once(pb, sourceGUIDFromEvent(e)) {
+		return
+	}
+
+	run := m.runPlaybook(pb, e)
+
+	// we keep track of the run along with the other dumps
+	runPath := m.prepare(e, playbookFilename)
+	if err := m.dumpAsJson(runPath, run); err != nil {
+		m.edr.logger.Errorf("Failed to dump playbook run for event %s: %s", run.EventHash, err)
+	} else {
+		m.queueCompression(runPath)
+	}
+
+	if m.edr.config.IsForwardingEnabled() {
+		if err := m.edr.forwarder.Client.PostPlaybookRun(run); err != nil {
+			m.edr.logger.Errorf("Failed to report playbook run: %s", err)
+		}
+	}
+}
+
+// detectionSignatures returns the sorted signatures of a detection
+func detectionSignatures(d *engine.Detection) (sigs []string) {
+	sigs = make([]string, 0, d.Signature.Len())
+	for _, s := range d.Signature.Slice() {
+		sigs = append(sigs, s.(string))
+	}
+	sort.Strings(sigs)
+	return
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// PreventionTag tag of the rules blocking the processes they match
+	PreventionTag = "block"
+
+	// outcomes of process creation blocking
+	preventionBlocked    = "blocked"
+	preventionFailed     = "failed"
+	preventionReportOnly = "report-only"
+	preventionAllowed    = "allowed"
+)
+
+var (
+	// processes never blocked nor suspended, whatever the configuration
+	criticalImages = []string{
+		`C:\Windows\System32\csrss.exe`,
+		`C:\Windows\System32\lsass.exe`,
+		`C:\Windows\System32\services.exe`,
+		`C:\Windows\System32\smss.exe`,
+		`C:\Windows\System32\wininit.exe`,
+		`C:\Windows\System32\winlogon.exe`,
+	}
+)
+
+// Preventer decides which processes are blocked at creation
+type Preventer struct {
+	sync.RWMutex
+	allow []*regexp.Regexp
+	// rules tagged for prevention
+	rules map[string]bool
+}
+
+// NewPreventer creates a new Preventer from configuration
+func NewPreventer(c *config.Prevention) (p *Preventer, err error) {
+	p = &Preventer{
+		allow: make([]*regexp.Regexp, 0, len(c.AllowList)),
+		rules: make(map[string]bool),
+	}
+
+	for _, s := range c.AllowList {
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(s); err != nil {
+			return
+		}
+		p.allow = append(p.allow, re)
+	}
+
+	return
+}
+
+// SetRules must be called when a new engine is loaded so that
+// rules tagged for prevention are known
+func (p *Preventer) SetRules(e *engine.Engine) {
+	rules := make(map[string]bool)
+	for name, tags := range ruleTagsMap(e) {
+		for _, t := range tags {
+			if strings.EqualFold(t, PreventionTag) {
+				rules[name] = true
+			}
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.rules = rules
+}
+
+// Blocks returns true if one of the rules is tagged for prevention
+func (p *Preventer) Blocks(rules []string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, r := range rules {
+		if p.rules[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// isCriticalImage returns true if image is a critical system process
+func isCriticalImage(image string) bool {
+	for _, c := range criticalImages {
+		if strings.EqualFold(filepath.Clean(image), c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if image must never be blocked nor suspended
+func (p *Preventer) Allowed(image string) bool {
+	if isCriticalImage(image) {
+		return true
+	}
+
+	for _, re := range p.allow {
+		if re.MatchString(image) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prevent terminates the process created, and optionally suspends its
+// parent, if the alert was raised by a rule tagged for prevention. The
+// outcome is stored in the event so that it is known by the manager.
+func (a *Agent) prevent(e *event.EdrEvent, rules []string) {
+	var pid, ppid int64
+	var ok bool
+
+	if a.preventer == nil || e.EventID() != SysmonProcessCreate || !a.preventer.Blocks(rules) {
+		return
+	}
+
+	if pid, ok = e.GetInt(pathSysmonProcessId); !ok {
+		return
+	}
+
+	image, _ := e.GetString(pathSysmonImage)
+
+	switch {
+	case a.preventer.Allowed(image) || a.trustedSource(e):
+		e.Set(pathPrevention, preventionAllowed)
+
+	case a.config.PrevConfig.ReportOnly:
+		a.logger.Warnf("Prevention (report only): would block process PID=%d Image=%s", pid, image)
+		e.Set(pathPrevention, preventionReportOnly)
+
+	default:
+		if err := terminate(int(pid)); err != nil {
+			a.logger.Errorf("Prevention: failed to block process PID=%d Image=%s: %s", pid, image, err)
+			e.Set(pathPrevention, preventionFailed)
+		} else {
+			a.logger.Warnf("Prevention: blocked process PID=%d Image=%s", pid, image)
+			e.Set(pathPrevention, preventionBlocked)
+		}
+
+		if !a.config.PrevConfig.SuspendParent {
+			return
+		}
+
+		parent, _ := e.GetString(pathSysmonParentImage)
+		if ppid, ok = e.GetInt(pathSysmonParentProcessId); ok && int(ppid) != os.Getpid() && !a.preventer.Allowed(parent) {
+			a.logger.Warnf("Prevention: suspending parent process PID=%d Image=%s", ppid, parent)
+			kernel32.SuspendProcess(int(ppid))
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// CreateNamedPipe open and pipe modes (see winbase.h)
+	pipeAccessOutbound        = 0x2
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+
+	errorPipeConnected = 535
+	sddlRevision1      = 1
+
+	processQueryLimitedInformation = 0x1000
+	// SID of the account the collector runs as
+	sidLocalSystem = "S-1-5-18"
+
+	// size of the pipe output buffer
+	pipeBufferSize = 1 << 16
+	// interval at which the processing component tries
+	// to connect to the collector
+	pipeRetryInterval = time.Second
+)
+
+var (
+	procCreateNamedPipeW    = syscall.NewLazyDLL("kernel32.dll").NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = syscall.NewLazyDLL("kernel32.dll").NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = syscall.NewLazyDLL("kernel32.dll").NewProc("DisconnectNamedPipe")
+	procConvertStringSD     = syscall.NewLazyDLL("advapi32.dll").NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	procGetPipeServerPid    = syscall.NewLazyDLL("kernel32.dll").NewProc("GetNamedPipeServerProcessId")
+
+	ErrUntrustedPipeServer = errors.New("pipe server not running as SYSTEM")
+)
+
+// pipeSDDL returns the security descriptor of the event pipe, only SYSTEM
+// and the account the processing component runs as can access it
+func pipeSDDL(account string) string {
+	return fmt.Sprintf("D:P(A;;GA;;;SY)(A;;GR;;;%s)", account)
+}
+
+// createEventPipe creates the server end of the event pipe, creation fails
+// if the pipe already exists so that it cannot be squatted
+func createEventPipe(path, account string) (h syscall.Handle, err error) {
+	var ppath, psddl *uint16
+	var sd uintptr
+
+	if ppath, err = syscall.UTF16PtrFromString(path); err != nil {
+		return
+	}
+
+	if psddl, err = syscall.UTF16PtrFromString(pipeSDDL(account)); err != nil {
+		return
+	}
+
+	if r1, _, lastErr := procConvertStringSD.Call(uintptr(unsafe.Pointer(psddl)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r1 == 0 {
+		return syscall.InvalidHandle, fmt.Errorf("bad pipe security descriptor: %w", lastErr)
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	sa := syscall.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(sa))
+
+	r1, _, lastErr := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(ppath)),
+		pipeAccessOutbound|fileFlagFirstPipeInstance,
+		pipeRejectRemoteClients,
+		1,
+		pipeBufferSize,
+		0,
+		0,
+		uintptr(unsafe.Pointer(&sa)))
+
+	if h = syscall.Handle(r1); h == syscall.InvalidHandle {
+		return h, lastErr
+	}
+
+	return
+}
+
+// connectPipe waits for a client to connect to the pipe
+func connectPipe(h syscall.Handle) error {
+	if r1, _, lastErr := procConnectNamedPipe.Call(uintptr(h), 0); r1 == 0 {
+		// client connected between pipe creation and this call
+		if lastErr == syscall.Errno(errorPipeConnected) {
+			return nil
+		}
+		return lastErr
+	}
+	return nil
+}
+
+// disconnectPipe disconnects the client of the pipe
+func disconnectPipe(h syscall.Handle) {
+	procDisconnectNamedPipe.Call(uintptr(h))
+}
+
+// processUser returns the SID of the user a process runs as
+func processUser(pid uint32) (sid string, err error) {
+	var ph syscall.Handle
+	var token syscall.Token
+	var user *syscall.Tokenuser
+
+	if ph, err = syscall.OpenProcess(processQueryLimitedInformation, false, pid); err != nil {
+		return
+	}
+	defer syscall.CloseHandle(ph)
+
+	if err = syscall.OpenProcessToken(ph, syscall.TOKEN_QUERY, &token); err != nil {
+		return
+	}
+	defer token.Close()
+
+	if user, err = token.GetTokenUser(); err != nil {
+		return
+	}
+
+	return user.User.Sid.String()
+}
+
+// verifyPipeServer checks that the server end of the pipe h is connected to
+// is held by SYSTEM, any process could otherwise create the pipe while the
+// collector is not running and feed events to the processing component
+func verifyPipeServer(h syscall.Handle) error {
+	var pid uint32
+
+	if r1, _, lastErr := procGetPipeServerPid.Call(uintptr(h), uintptr(unsafe.Pointer(&pid))); r1 == 0 {
+		return fmt.Errorf("failed to get pipe server process: %w", lastErr)
+	}
+
+	sid, err := processUser(pid)
+	if err != nil {
+		return fmt.Errorf("failed to get pipe server user: %w", err)
+	}
+
+	if sid != sidLocalSystem {
+		return fmt.Errorf("%w: PID=%d user=%s", ErrUntrustedPipeServer, pid, sid)
+	}
+
+	return nil
+}
+
+// pipeWriter writes to the server end of a pipe
+type pipeWriter syscall.Handle
+
+func (w pipeWriter) Write(b []byte) (int, error) {
+	return syscall.Write(syscall.Handle(w), b)
+}
+
+// writeEvent writes an event to w as a line of JSON
+func writeEvent(w io.Writer, e *etw.Event) (err error) {
+	var b []byte
+
+	enc := event.GetEncoder()
+	defer event.PutEncoder(enc)
+
+	if b, err = enc.Encode(e); err != nil {
+		return
+	}
+
+	_, err = w.Write(b)
+	return
+}
+
+// readEvents reads from r events written by writeEvent and sends them to c
+// until r is consumed or ctx is done, it returns the number of events which
+// failed to be decoded
+func readEvents(ctx context.Context, r io.Reader, c chan *etw.Event) (bad uint64, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), maxReplayEventSize)
+
+	for s.Scan() && ctx.Err() == nil {
+		e := etw.NewEvent()
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			bad++
+			continue
+		}
+
+		select {
+		case c <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	return bad, s.Err()
+}
+
+// Collector is the privileged component of the agent when privileges are
+// separated, it consumes ETW and sends the events over a local named pipe
+// to the processing component running with lower privileges
+type Collector struct {
+	sync.Mutex
+	// events dropped while no processing component was connected,
+	// first field to be 64-bit aligned for atomic operations
+	dropped uint64
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	config    *config.Agent
+	logger    *golog.Logger
+	provider  *etwProvider
+	pipe      syscall.Handle
+	connected bool
+	lost      chan bool
+}
+
+// NewCollector creates a new collector
+func NewCollector(c *config.Agent, l *golog.Logger) (*Collector, error) {
+	if err := c.PrivSepConfig.Verify(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	col := &Collector{
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   c,
+		logger:   l,
+		provider: newEtwProvider(ctx),
+		pipe:     syscall.InvalidHandle,
+		lost:     make(chan bool, 1),
+	}
+
+	for _, sprov := range c.EtwConfig.UnifiedProviders() {
+		if prov, err := etw.ParseProvider(sprov); err != nil {
+			l.Errorf("Error while parsing provider %s: %s", sprov, err)
+		} else {
+			col.provider.Filter.Update(&prov)
+		}
+	}
+
+	providers, err := channelProviders(c.EtwConfig.Channels)
+	if err != nil {
+		l.Errorf("Error while parsing channels: %s", err)
+	}
+	col.provider.UpdateProviders(config.EdrRealTimeTraceName, providers)
+	col.provider.FromTraceNames(c.EtwConfig.UnifiedTraces()...)
+
+	return col, nil
+}
+
+func (c *Collector) setConnected(connected bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.connected = connected
+}
+
+func (c *Collector) isConnected() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.connected
+}
+
+// accept serves the processing component, one at a time
+func (c *Collector) accept() {
+	defer c.wg.Done()
+
+	for c.ctx.Err() == nil {
+		if err := connectPipe(c.pipe); err != nil {
+			c.logger.Errorf("Failed to wait for processing component: %s", err)
+			time.Sleep(pipeRetryInterval)
+			continue
+		}
+
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		if n := atomic.SwapUint64(&c.dropped, 0); n > 0 {
+			c.logger.Warnf("Dropped %d events while processing component was not connected", n)
+		}
+		c.logger.Infof("Processing component connected")
+		c.setConnected(true)
+
+		select {
+		case <-c.lost:
+			c.logger.Warnf("Processing component disconnected")
+		case <-c.ctx.Done():
+		}
+
+		c.setConnected(false)
+		disconnectPipe(c.pipe)
+	}
+}
+
+// pump sends the events collected to the processing component
+func (c *Collector) pump() {
+	defer c.wg.Done()
+
+	w := pipeWriter(c.pipe)
+	for e := range c.provider.Events() {
+		if !c.isConnected() {
+			atomic.AddUint64(&c.dropped, 1)
+			continue
+		}
+
+		if err := writeEvent(w, e); err != nil {
+			atomic.AddUint64(&c.dropped, 1)
+			c.setConnected(false)
+			select {
+			case c.lost <- true:
+			default:
+			}
+		}
+	}
+}
+
+// Run configures auditing and starts collecting events
+func (c *Collector) Run() (err error) {
+	configureAuditPolicies(&c.config.AuditConfig, c.logger)
+
+	if c.pipe, err = createEventPipe(c.config.PrivSepConfig.Pipe, c.config.PrivSepConfig.Account); err != nil {
+		return fmt.Errorf("failed to create pipe %s: %w", c.config.PrivSepConfig.Pipe, err)
+	}
+
+	if err = c.provider.Start(); err != nil {
+		syscall.CloseHandle(c.pipe)
+		return fmt.Errorf("failed to start event provider: %w", err)
+	}
+
+	c.logger.Infof("Collector sending events over %s", c.config.PrivSepConfig.Pipe)
+
+	c.wg.Add(2)
+	go c.accept()
+	go c.pump()
+
+	return
+}
+
+// Stop stops collecting events
+func (c *Collector) Stop() {
+	c.logger.Infof("Stopping collector")
+	c.cancel()
+
+	if err := c.provider.Stop(); err != nil {
+		c.logger.Errorf("Error while closing event provider: %s", err)
+	}
+
+	// unblocks accept routine waiting for a client
+	if fd, err := os.Open(c.config.PrivSepConfig.Pipe); err == nil {
+		fd.Close()
+	}
+}
+
+// Wait waits for the collector to be stopped
+func (c *Collector) Wait() {
+	c.wg.Wait()
+	if c.pipe != syscall.InvalidHandle {
+		syscall.CloseHandle(c.pipe)
+	}
+}
+
+// pipeProvider is the EventProvider of the processing component when
+// privileges are separated, it receives events from the collector
+type pipeProvider struct {
+	sync.Mutex
+	// events which failed to be decoded, first field to be
+	// 64-bit aligned for atomic operations
+	lost uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	path   string
+	logger *golog.Logger
+	events chan *etw.Event
+	fd     *os.File
+}
+
+// NewPipeProvider returns an EventProvider receiving events
+// from a collector over the named pipe at path
+func NewPipeProvider(path string, l *golog.Logger) EventProvider {
+	return &pipeProvider{
+		path:   path,
+		logger: l,
+		events: make(chan *etw.Event),
+	}
+}
+
+func (p *pipeProvider) setFile(fd *os.File) {
+	p.Lock()
+	defer p.Unlock()
+	p.fd = fd
+}
+
+// read reads events from the collector, it reconnects to
+// the collector until the provider is stopped
+func (p *pipeProvider) read() {
+	defer p.wg.Done()
+	defer close(p.events)
+
+	var failing bool
+
+	for p.ctx.Err() == nil {
+		fd, err := os.Open(p.path)
+		if err == nil {
+			if err = verifyPipeServer(syscall.Handle(fd.Fd())); err != nil {
+				fd.Close()
+			}
+		}
+
+		if err != nil {
+			// collector may not be started yet
+			if !failing {
+				p.logger.Errorf("Failed to connect to collector, retrying: %s", err)
+				failing = true
+			}
+			select {
+			case <-time.After(pipeRetryInterval):
+			case <-p.ctx.Done():
+			}
+			continue
+		}
+
+		failing = false
+		p.logger.Infof("Connected to collector %s", p.path)
+		p.setFile(fd)
+
+		bad, err := readEvents(p.ctx, fd, p.events)
+		atomic.AddUint64(&p.lost, bad)
+		if err != nil && p.ctx.Err() == nil {
+			p.logger.Errorf("Connection to collector lost: %s", err)
+		}
+
+		p.setFile(nil)
+		fd.Close()
+	}
+}
+
+// Start starts receiving events from the collector
+func (p *pipeProvider) Start() error {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.wg.Add(1)
+	go p.read()
+	return nil
+}
+
+// Stop stops receiving events, events channel gets closed
+func (p *pipeProvider) Stop() error {
+	if p.cancel == nil {
+		return nil
+	}
+
+	p.cancel()
+
+	// unblocks pending read
+	p.Lock()
+	if p.fd != nil {
+		p.fd.Close()
+	}
+	p.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+func (p *pipeProvider) Events() chan *etw.Event {
+	return p.events
+}
+
+func (p *pipeProvider) LostEvents() uint64 {
+	return atomic.SwapUint64(&p.lost, 0)
+}
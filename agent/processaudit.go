@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+var (
+	// integrity levels as reported by Sysmon
+	// https://docs.microsoft.com/en-us/windows/security/identity-protection/access-control/security-identifiers
+	mandatoryLabels = map[string]string{
+		"S-1-16-0":     "Untrusted",
+		"S-1-16-4096":  "Low",
+		"S-1-16-8192":  "Medium",
+		"S-1-16-8448":  "Medium",
+		"S-1-16-12288": "High",
+		"S-1-16-16384": "System",
+		"S-1-16-20480": "Protected",
+	}
+)
+
+// auditPID parses a PID found in Security process audit events
+// where it is formatted as an hexadecimal number (i.e. 0x1a2c)
+func auditPID(s string) (int64, bool) {
+	pid, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+	return pid, err == nil
+}
+
+// auditProcessGUID returns a process GUID, formatted as Sysmon ones, identifying a
+// process seen in Security process audit events. Sysmon GUIDs are not available
+// so it is derived from the PID and the time the process has been created.
+func auditProcessGUID(pid int64, created time.Time) string {
+	h := md5.Sum([]byte(fmt.Sprintf("%d:%d", pid, created.UnixNano())))
+	return fmt.Sprintf("{%x-%x-%x-%x-%x}", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// auditUser returns the account a process created runs as, the target
+// account is only available in recent versions of Windows
+func auditUser(e *event.EdrEvent) (user string, ok bool) {
+	if user, ok = eventAccount(e, pathTargetUserName, pathTargetDomainName); ok {
+		return
+	}
+	return eventAccount(e, pathSubjectUserName, pathSubjectDomainName)
+}
+
+// trackAuditProcessCreate builds a process track from a process creation
+// audit event (Security 4688), it is the counterpart of Sysmon process
+// tracking for hosts where Sysmon is not installed. The event is enriched
+// with Sysmon like fields so that ancestry based features and rules work.
+func (a *Agent) trackAuditProcessCreate(e *event.EdrEvent) {
+	var ok bool
+	var pid, ppid int64
+	var s, image string
+
+	if s, ok = e.GetString(pathNewProcessId); !ok {
+		return
+	}
+
+	if pid, ok = auditPID(s); !ok {
+		return
+	}
+
+	if image, ok = e.GetString(pathNewProcessName); !ok {
+		return
+	}
+
+	if s, ok = e.GetString(pathAuditProcessId); !ok {
+		return
+	}
+
+	if ppid, ok = auditPID(s); !ok {
+		return
+	}
+
+	parent := a.tracker.GetByPID(ppid)
+	if parent.IsZero() || parent.Terminated {
+		// process created before the agent started or before auditing got enabled,
+		// we track it so that other processes it creates are linked to it
+		pimage, _ := e.GetString(pathParentProcessName)
+		parent = NewProcessTrack(pimage, nullGUID, auditProcessGUID(ppid, time.Time{}), ppid)
+		a.tracker.Add(parent)
+	}
+
+	track := NewProcessTrack(image, parent.ProcessGUID, auditProcessGUID(pid, e.Timestamp()), pid)
+	track.ParentImage = parent.Image
+	track.CommandLine, _ = e.GetString(pathSysmonCommandLine)
+	track.ParentCommandLine = parent.CommandLine
+	track.User, _ = auditUser(e)
+	if label, ok := e.GetString(pathMandatoryLabel); ok {
+		track.IntegrityLevel = mandatoryLabels[label]
+	}
+	track.Ancestors = append(append(track.Ancestors, parent.Ancestors...), parent.Image)
+	track.ParentUser = parent.User
+	track.ParentIntegrityLevel = parent.IntegrityLevel
+	track.ParentServices = parent.Services
+	track.ParentCurrentDirectory = parent.CurrentDirectory
+	track.ParentProtectionLevel = parent.ProtectionLevel
+
+	a.tracker.Add(track)
+
+	e.SetIfMissing(pathSysmonProcessGUID, track.ProcessGUID)
+	e.SetIfMissing(pathSysmonImage, track.Image)
+	e.SetIfMissing(pathSysmonParentProcessGUID, track.ParentProcessGUID)
+	e.SetIfMissing(pathSysmonParentProcessId, toString(ppid))
+	e.SetIfMissing(pathSysmonParentImage, track.ParentImage)
+	e.SetIfMissing(pathSysmonParentCommandLine, track.ParentCommandLine)
+	e.SetIfMissing(pathAncestors, strings.Join(track.Ancestors, "|"))
+	if track.User != "" {
+		e.SetIfMissing(pathSysmonUser, track.User)
+	}
+	if track.IntegrityLevel != "" {
+		e.SetIfMissing(pathSysmonIntegrityLevel, track.IntegrityLevel)
+	}
+	if track.ParentUser != "" {
+		e.SetIfMissing(pathParentUser, track.ParentUser)
+	}
+	if track.ParentIntegrityLevel != "" {
+		e.SetIfMissing(pathParentIntegrityLevel, track.ParentIntegrityLevel)
+	}
+}
+
+// trackAuditProcessTerminate terminates the track of a process
+// from a process termination audit event (Security 4689)
+func (a *Agent) trackAuditProcessTerminate(e *event.EdrEvent) {
+	var ok bool
+	var pid int64
+	var s string
+
+	if s, ok = e.GetString(pathAuditProcessId); !ok {
+		return
+	}
+
+	if pid, ok = auditPID(s); !ok {
+		return
+	}
+
+	// tracking processes from audit events makes sense only
+	// because we know termination events are available
+	a.flagProcTermEn = true
+
+	if t := a.tracker.GetByPID(pid); !t.IsZero() && !t.Terminated {
+		e.SetIfMissing(pathSysmonProcessGUID, t.ProcessGUID)
+		a.tracker.Terminate(t.ProcessGUID)
+		a.memdumped.Del(t.ProcessGUID)
+		a.suspended.Del(t.ProcessGUID)
+		a.playbooks.forget(t.ProcessGUID)
+		a.releaseTerminatedProcess(t.ProcessGUID)
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ActionIsolateProcess rule action and command blocking the
+	// network traffic of a process instead of containing the host
+	ActionIsolateProcess = "isolate-process"
+
+	// target releasing all isolated processes
+	releaseAllProcesses = "all"
+)
+
+// IsolatedProcess is a process whose network traffic is blocked. WFP matches
+// applications by path so any process running the same image is isolated.
+type IsolatedProcess struct {
+	ProcessGUID string `json:"process-guid"`
+	PID         int64  `json:"pid"`
+	Image       string `json:"image"`
+}
+
+// desc returns the description of the filters isolating the process
+func (p *IsolatedProcess) desc() string {
+	return fmt.Sprintf("%s|%d|%s", p.ProcessGUID, p.PID, p.Image)
+}
+
+// parseIsolatedProcess parses the description of process isolation filters
+func parseIsolatedProcess(desc string) (p *IsolatedProcess, err error) {
+	sp := strings.SplitN(desc, "|", 3)
+	if len(sp) != 3 {
+		return nil, fmt.Errorf("bad process isolation filter description: %q", desc)
+	}
+
+	p = &IsolatedProcess{ProcessGUID: sp[0], Image: sp[2]}
+	if p.PID, err = strconv.ParseInt(sp[1], 10, 64); err != nil {
+		return nil, fmt.Errorf("bad process isolation filter description: %q", desc)
+	}
+
+	return
+}
+
+// Matches returns true if target (process GUID, PID or all) designates the process
+func (p *IsolatedProcess) Matches(target string) bool {
+	return target == releaseAllProcesses ||
+		strings.EqualFold(target, p.ProcessGUID) ||
+		target == strconv.FormatInt(p.PID, 10)
+}
+
+// isolatedProcesses returns the processes currently isolated
+func isolatedProcesses() (procs []*IsolatedProcess, err error) {
+	var descs []string
+
+	if descs, err = wfpIsolatedProcesses(); err != nil {
+		return
+	}
+
+	procs = make([]*IsolatedProcess, 0, len(descs))
+	for _, d := range descs {
+		// filters we do not know about are left alone
+		if p, err := parseIsolatedProcess(d); err == nil {
+			procs = append(procs, p)
+		}
+	}
+
+	return
+}
+
+// initProcessIsolation restores the processes isolated by a previous run
+// so that their isolation is released when they terminate
+func (a *Agent) initProcessIsolation() error {
+	procs, err := isolatedProcesses()
+	if err != nil {
+		return fmt.Errorf("failed to list isolated processes: %w", err)
+	}
+
+	for _, p := range procs {
+		a.isolated.Add(p.ProcessGUID)
+	}
+
+	return nil
+}
+
+// processTrack returns the process designated by target, a PID or a process GUID
+func (a *Agent) processTrack(target string) (t *ProcessTrack, err error) {
+	if pid, err := strconv.ParseInt(target, 10, 64); err == nil {
+		t = a.tracker.GetByPID(pid)
+	} else {
+		t = a.tracker.GetByGuid(target)
+	}
+
+	if t.IsZero() {
+		return nil, fmt.Errorf("untracked process %s", target)
+	}
+
+	return
+}
+
+// isolateProcess blocks network traffic of a tracked process
+func (a *Agent) isolateProcess(t *ProcessTrack) (p *IsolatedProcess, err error) {
+	switch {
+	case t.Terminated:
+		return nil, fmt.Errorf("process PID=%d is terminated", t.PID)
+	case t.Image == "":
+		return nil, fmt.Errorf("process PID=%d image is unknown", t.PID)
+	case t.PID == int64(os.Getpid()) || a.isSelfImage(t.Image):
+		return nil, fmt.Errorf("refusing to isolate agent")
+	case isCriticalImage(t.Image):
+		return nil, fmt.Errorf("refusing to isolate critical process %s", t.Image)
+	}
+
+	p = &IsolatedProcess{ProcessGUID: t.ProcessGUID, PID: t.PID, Image: t.Image}
+	if a.isolated.Contains(p.ProcessGUID) {
+		return
+	}
+
+	name := fmt.Sprintf("isolate %s (PID=%d)", filepath.Base(p.Image), p.PID)
+	if err = wfpIsolateProcess(p.Image, name, p.desc()); err != nil {
+		return nil, fmt.Errorf("failed to isolate process: %w", err)
+	}
+
+	a.isolated.Add(p.ProcessGUID)
+	a.logger.Warnf("Isolated process PID=%d GUID=%s Image=%s", p.PID, p.ProcessGUID, p.Image)
+
+	return
+}
+
+// isSelfImage returns true if image is the one of the agent
+func (a *Agent) isSelfImage(image string) bool {
+	self, err := os.Executable()
+	return err == nil && strings.EqualFold(filepath.Clean(self), filepath.Clean(image))
+}
+
+// releaseProcesses releases the isolation of the processes designated by
+// target (process GUID, PID or all), it returns the processes released
+func (a *Agent) releaseProcesses(target string) (released []*IsolatedProcess, err error) {
+	var procs []*IsolatedProcess
+
+	if procs, err = isolatedProcesses(); err != nil {
+		return
+	}
+
+	released = make([]*IsolatedProcess, 0)
+	descs := make(map[string]bool)
+	for _, p := range procs {
+		if p.Matches(target) {
+			released = append(released, p)
+			descs[p.desc()] = true
+		}
+	}
+
+	if len(released) == 0 {
+		return
+	}
+
+	if _, err = wfpReleaseProcesses(func(desc string) bool { return descs[desc] }); err != nil {
+		return nil, fmt.Errorf("failed to release process isolation: %w", err)
+	}
+
+	for _, p := range released {
+		a.isolated.Del(p.ProcessGUID)
+		a.logger.Infof("Released isolation of process PID=%d GUID=%s Image=%s", p.PID, p.ProcessGUID, p.Image)
+	}
+
+	return
+}
+
+// releaseTerminatedProcess releases isolation of a process once terminated,
+// otherwise new processes running the same image would be isolated
+func (a *Agent) releaseTerminatedProcess(guid string) {
+	if !a.isolated.Contains(guid) {
+		return
+	}
+
+	// not to block event processing
+	go func() {
+		if _, err := a.releaseProcesses(guid); err != nil {
+			a.logger.Error(err)
+		}
+	}()
+}
@@ -264,6 +264,13 @@ func (i *ModuleInfo) UpdateStatistics(other *ModuleInfo) {
 	i.LoadCount++
 }
 
+// ImageSigner holds signature information of an image
+type ImageSigner struct {
+	Signature       string `json:"signature"`
+	SignatureStatus string `json:"signature-status"`
+	Signed          bool   `json:"signed"`
+}
+
 type DriverInfo struct {
 	/* Private */
 	hashes string
@@ -356,7 +363,9 @@ type ActivityTracker struct {
 	rpids       map[int64]*ProcessTrack // for running processes
 	tpids       map[int64]*ProcessTrack // for terminated processes
 	blacklisted *datastructs.SyncedSet
-	free        *datastructs.Fifo
+	// blacklisted command lines whose process tree must be killed
+	blacklistedTrees *datastructs.SyncedSet
+	free             *datastructs.Fifo
 	// Kernel-Files
 	files map[uint64]*KernelFile
 	// modules loaded
@@ -368,14 +377,15 @@ type ActivityTracker struct {
 func NewActivityTracker() *ActivityTracker {
 	pt := &ActivityTracker{
 		//pguids:      make(map[string]int),
-		guids:       make(map[string]*ProcessTrack),
-		rpids:       make(map[int64]*ProcessTrack),
-		tpids:       make(map[int64]*ProcessTrack),
-		blacklisted: datastructs.NewSyncedSet(),
-		free:        &datastructs.Fifo{},
-		files:       make(map[uint64]*KernelFile),
-		modules:     make(map[string]*ModuleInfo),
-		Drivers:     make([]DriverInfo, 0),
+		guids:            make(map[string]*ProcessTrack),
+		rpids:            make(map[int64]*ProcessTrack),
+		tpids:            make(map[int64]*ProcessTrack),
+		blacklisted:      datastructs.NewSyncedSet(),
+		blacklistedTrees: datastructs.NewSyncedSet(),
+		free:             &datastructs.Fifo{},
+		files:            make(map[uint64]*KernelFile),
+		modules:          make(map[string]*ModuleInfo),
+		Drivers:          make([]DriverInfo, 0),
 	}
 	// startup the routine to free resources
 	pt.freeRtn()
@@ -520,6 +530,54 @@ func (pt *ActivityTracker) IsBlacklisted(cmdLine string) bool {
 	return pt.blacklisted.Contains(cmdLine)
 }
 
+// BlacklistTree blacklists a command line so that the whole
+// tree of the processes running it gets killed
+func (pt *ActivityTracker) BlacklistTree(cmdLine string) {
+	pt.blacklisted.Add(cmdLine)
+	pt.blacklistedTrees.Add(cmdLine)
+}
+
+func (pt *ActivityTracker) IsTreeBlacklisted(cmdLine string) bool {
+	return pt.blacklistedTrees.Contains(cmdLine)
+}
+
+// Tree returns the running processes of the tree rooted at guid, root
+// included. Children come before their parent so that the tree can be
+// terminated bottom up.
+func (pt *ActivityTracker) Tree(guid string) (tree []*ProcessTrack) {
+	pt.RLock()
+	defer pt.RUnlock()
+
+	tree = make([]*ProcessTrack, 0)
+	root := pt.getByGuid(guid)
+	if root.IsZero() || root.Terminated {
+		return
+	}
+
+	children := make(map[string][]*ProcessTrack)
+	for _, t := range pt.guids {
+		if !t.Terminated {
+			children[t.ParentProcessGUID] = append(children[t.ParentProcessGUID], t)
+		}
+	}
+
+	// GUIDs are unique but we never want to loop forever
+	seen := make(map[string]bool)
+	var walk func(*ProcessTrack)
+	walk = func(t *ProcessTrack) {
+		seen[t.ProcessGUID] = true
+		for _, c := range children[t.ProcessGUID] {
+			if !seen[c.ProcessGUID] {
+				walk(c)
+			}
+		}
+		tree = append(tree, t)
+	}
+	walk(root)
+
+	return
+}
+
 func (pt *ActivityTracker) SourceTrackFromEvent(e *event.EdrEvent) (t *ProcessTrack) {
 	pt.RLock()
 	defer pt.RUnlock()
@@ -610,6 +668,34 @@ func (pt *ActivityTracker) Modules() (s []ModuleInfo) {
 	return
 }
 
+// ImageSigner returns signature information of an image as reported by Sysmon
+// in process creation, image load or driver load events, nil if image was never seen
+func (pt *ActivityTracker) ImageSigner(image string) *ImageSigner {
+	pt.RLock()
+	defer pt.RUnlock()
+
+	for _, m := range pt.modules {
+		if strings.EqualFold(m.Image, image) {
+			return &ImageSigner{Signature: m.Signature, SignatureStatus: m.SignatureStatus, Signed: m.Signed}
+		}
+	}
+
+	for _, d := range pt.Drivers {
+		if strings.EqualFold(d.Image, image) {
+			return &ImageSigner{Signature: d.Signature, SignatureStatus: d.SignatureStatus, Signed: d.Signed}
+		}
+	}
+
+	for _, t := range pt.guids {
+		// signature information of processes comes from the image load of their own image
+		if strings.EqualFold(t.Image, image) && t.Signature != "?" {
+			return &ImageSigner{Signature: t.Signature, SignatureStatus: t.SignatureStatus, Signed: t.Signed}
+		}
+	}
+
+	return nil
+}
+
 func (pt *ActivityTracker) AddKernelFile(f *KernelFile) {
 	pt.Lock()
 	defer pt.Unlock()
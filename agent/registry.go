@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+var (
+	// base64 blobs long enough not to match regular strings (paths, command lines ...)
+	base64BlobRe = regexp.MustCompile(`[A-Za-z0-9+/]{64,}={0,2}`)
+)
+
+// regValuePattern is a compiled config.RegistryPattern
+type regValuePattern struct {
+	key   *regexp.Regexp
+	value *regexp.Regexp
+}
+
+func (p *regValuePattern) match(path, data string) bool {
+	if p.key != nil && !p.key.MatchString(path) {
+		return false
+	}
+	return p.value == nil || p.value.MatchString(data)
+}
+
+// RegValueMonitor decides which registry values set must be dumped
+type RegValueMonitor struct {
+	sync.Mutex
+	config   *config.Registry
+	patterns []*regValuePattern
+	// last dump time by registry key
+	last map[string]time.Time
+}
+
+// NewRegValueMonitor creates a new RegValueMonitor from configuration
+func NewRegValueMonitor(c *config.Registry) (m *RegValueMonitor, err error) {
+	m = &RegValueMonitor{
+		config:   c,
+		patterns: make([]*regValuePattern, 0, len(c.Patterns)),
+		last:     make(map[string]time.Time),
+	}
+
+	for _, p := range c.Patterns {
+		var rp regValuePattern
+		if rp.key, rp.value, err = p.Compile(); err != nil {
+			return
+		}
+		m.patterns = append(m.patterns, &rp)
+	}
+
+	return
+}
+
+// Match returns true if a value set at path, of a given size and
+// with data as string representation, must be dumped
+func (m *RegValueMonitor) Match(path, data string, size int) bool {
+	if m.config.DumpSize > 0 && size > m.config.DumpSize {
+		return true
+	}
+
+	for _, p := range m.patterns {
+		if p.match(path, data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allow returns true if a value set under key can be dumped at
+// time now, according to rate limiting
+func (m *RegValueMonitor) Allow(key string, now time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	key = strings.ToLower(key)
+
+	if last, ok := m.last[key]; ok && now.Sub(last) < m.config.RateLimit {
+		return false
+	}
+
+	if _, ok := m.last[key]; !ok && m.config.MaxKeys > 0 && len(m.last) >= m.config.MaxKeys {
+		// we make room removing keys not rate limited anymore
+		for k, last := range m.last {
+			if now.Sub(last) >= m.config.RateLimit {
+				delete(m.last, k)
+			}
+		}
+
+		if len(m.last) >= m.config.MaxKeys {
+			return false
+		}
+	}
+
+	m.last[key] = now
+	return true
+}
+
+// regKey returns the key a registry value path belongs to
+func regKey(path string) string {
+	if i := strings.LastIndex(path, `\`); i > 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// regValueString returns the string representation of registry value data
+func regValueString(data []byte, dtype uint32) string {
+	i, err := advapi32.ParseRegValue(data, dtype)
+	if err != nil {
+		return ""
+	}
+
+	switch v := i.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, "\n")
+	case []byte:
+		return string(v)
+	}
+
+	return fmt.Sprintf("%v", i)
+}
+
+// valueDumpRequested returns true if a registry value set has
+// been flagged to be dumped by hookSetValueSize
+func valueDumpRequested(e *event.EdrEvent) bool {
+	dumped, ok := e.GetBool(pathValueDumped)
+	return ok && dumped
+}
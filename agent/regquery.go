@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/golang-win32/win32"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// MaxRegExportKeys maximum number of keys exported by reg export command
+	MaxRegExportKeys = 100000
+
+	regExportHeader = "Windows Registry Editor Version 5.00"
+)
+
+var (
+	regRootKeys = map[string]string{
+		"HKLM": "HKEY_LOCAL_MACHINE",
+		"HKU":  "HKEY_USERS",
+		"HKCR": "HKEY_CLASSES_ROOT",
+		"HKCU": "HKEY_CURRENT_USER",
+	}
+
+	regValueTypes = map[uint32]string{
+		syscall.REG_NONE:                       "REG_NONE",
+		syscall.REG_SZ:                         "REG_SZ",
+		syscall.REG_EXPAND_SZ:                  "REG_EXPAND_SZ",
+		syscall.REG_BINARY:                     "REG_BINARY",
+		syscall.REG_DWORD:                      "REG_DWORD",
+		syscall.REG_DWORD_BIG_ENDIAN:           "REG_DWORD_BIG_ENDIAN",
+		syscall.REG_LINK:                       "REG_LINK",
+		syscall.REG_MULTI_SZ:                   "REG_MULTI_SZ",
+		syscall.REG_RESOURCE_LIST:              "REG_RESOURCE_LIST",
+		syscall.REG_FULL_RESOURCE_DESCRIPTOR:   "REG_FULL_RESOURCE_DESCRIPTOR",
+		syscall.REG_RESOURCE_REQUIREMENTS_LIST: "REG_RESOURCE_REQUIREMENTS_LIST",
+		syscall.REG_QWORD:                      "REG_QWORD",
+	}
+)
+
+// RegKeyValue is a value of a registry key
+type RegKeyValue struct {
+	// empty for the default value of the key
+	Name string      `json:"name"`
+	Type string      `json:"type"`
+	Size int         `json:"size"`
+	Data interface{} `json:"data"`
+}
+
+// RegKey holds the values and subkeys of a registry key
+type RegKey struct {
+	Path    string         `json:"path"`
+	Values  []*RegKeyValue `json:"values"`
+	Subkeys []string       `json:"subkeys"`
+	// values which failed to be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// RegExport is the result of a registry key export
+type RegExport struct {
+	Path   string `json:"path"`
+	Keys   int    `json:"keys"`
+	Values int    `json:"values"`
+	// true if more than MaxRegExportKeys keys had to be exported
+	Truncated bool `json:"truncated"`
+	// path of the .reg file, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact"`
+	Sha256   string `json:"sha256"`
+	// keys and values which failed to be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// regCanonicalKey returns the path of a registry key with its
+// root key in long form (i.e. HKLM -> HKEY_LOCAL_MACHINE)
+func regCanonicalKey(key string) string {
+	key = strings.Trim(key, `\`)
+	sp := strings.SplitN(key, `\`, 2)
+	if root, ok := regRootKeys[strings.ToUpper(sp[0])]; ok {
+		sp[0] = root
+	} else {
+		sp[0] = strings.ToUpper(sp[0])
+	}
+	return strings.Join(sp, `\`)
+}
+
+// regValueType returns the name of a registry value type
+func regValueType(dtype uint32) string {
+	if t, ok := regValueTypes[dtype]; ok {
+		return t
+	}
+	return fmt.Sprintf("0x%x", dtype)
+}
+
+// regExportString escapes a string as in .reg files
+func regExportString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// regExportHex formats data as hex values of .reg files
+func regExportHex(data []byte) string {
+	hex := make([]string, len(data))
+	for i, b := range data {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ",")
+}
+
+// regExportValue formats a registry value as a line of a .reg file
+func regExportValue(name string, dtype uint32, data []byte) string {
+	var value string
+
+	switch {
+	case dtype == syscall.REG_SZ:
+		value = regExportString(win32.UTF16BytesToString(data))
+	case dtype == syscall.REG_DWORD && len(data) == 4:
+		value = fmt.Sprintf("dword:%08x", binary.LittleEndian.Uint32(data))
+	case dtype == syscall.REG_BINARY:
+		value = "hex:" + regExportHex(data)
+	default:
+		value = fmt.Sprintf("hex(%x):%s", dtype, regExportHex(data))
+	}
+
+	if name == "" {
+		return "@=" + value
+	}
+
+	return regExportString(name) + "=" + value
+}
+
+// regValue reads a value of a registry key
+func regValue(key, name string) (data []byte, dtype uint32, err error) {
+	// default value is accessed with a trailing backslash
+	return advapi32.RegGetValueFromString(key + `\` + name)
+}
+
+// cmdRegQuery returns the values and subkeys of a registry key
+func cmdRegQuery(key string) (k *RegKey, err error) {
+	var names []string
+
+	key = regCanonicalKey(key)
+	k = &RegKey{Path: key, Values: make([]*RegKeyValue, 0)}
+
+	if k.Subkeys, err = advapi32.RegEnumKeys(key); err != nil {
+		return nil, fmt.Errorf("failed to open key %s: %w", key, err)
+	}
+	sort.Strings(k.Subkeys)
+
+	if names, err = advapi32.RegEnumValues(key); err != nil {
+		return nil, fmt.Errorf("failed to enumerate values of %s: %w", key, err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, dtype, err := regValue(key, name)
+		if err != nil {
+			k.Errors = append(k.Errors, fmt.Sprintf("failed to read value %s: %s", name, err))
+			continue
+		}
+
+		v := &RegKeyValue{Name: name, Type: regValueType(dtype), Size: len(data)}
+		if v.Data, err = advapi32.ParseRegValue(data, dtype); err != nil {
+			// unknown types are returned raw
+			v.Data = data
+		}
+		k.Values = append(k.Values, v)
+	}
+
+	return k, nil
+}
+
+// exportKey writes key, its values and its subkeys, recursively, to w in .reg format
+func (e *RegExport) exportKey(w *strings.Builder, key string) {
+	if e.Keys == MaxRegExportKeys {
+		e.Truncated = true
+		return
+	}
+
+	subkeys, err := advapi32.RegEnumKeys(key)
+	if err != nil {
+		e.Errors = append(e.Errors, fmt.Sprintf("failed to open key %s: %s", key, err))
+		return
+	}
+	sort.Strings(subkeys)
+
+	e.Keys++
+	fmt.Fprintf(w, "\r\n[%s]\r\n", key)
+
+	if names, err := advapi32.RegEnumValues(key); err != nil {
+		e.Errors = append(e.Errors, fmt.Sprintf("failed to enumerate values of %s: %s", key, err))
+	} else {
+		sort.Strings(names)
+		for _, name := range names {
+			if data, dtype, err := regValue(key, name); err != nil {
+				e.Errors = append(e.Errors, fmt.Sprintf("failed to read value %s of %s: %s", name, key, err))
+			} else {
+				w.WriteString(regExportValue(name, dtype, data) + "\r\n")
+				e.Values++
+			}
+		}
+	}
+
+	for _, sk := range subkeys {
+		e.exportKey(w, utils.RegJoin(key, sk))
+	}
+}
+
+// regEncodeExport encodes the content of a .reg file as
+// regedit does, in UTF-16 little endian with BOM
+func regEncodeExport(s string) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, append([]uint16{0xfeff}, utf16.Encode([]rune(s))...))
+	return buf.Bytes()
+}
+
+// cmdRegExport exports a registry key and its subkeys to a .reg
+// file which is uploaded to the manager as an artifact
+func (a *Agent) cmdRegExport(key string) (e *RegExport, err error) {
+	w := new(strings.Builder)
+	key = regCanonicalKey(key)
+	e = &RegExport{Path: key}
+
+	w.WriteString(regExportHeader + "\r\n")
+	e.exportKey(w, key)
+	if e.Keys == 0 {
+		return nil, fmt.Errorf("failed to export %s: %s", key, strings.Join(e.Errors, ", "))
+	}
+	w.WriteString("\r\n")
+
+	group := fmt.Sprintf("{%s}", utils.UnsafeUUID())
+	if e.Artifact, e.Sha256, err = a.collectArtifact(group, key+".reg", bytes.NewReader(regEncodeExport(w.String()))); err != nil {
+		return nil, fmt.Errorf("failed to collect registry export: %w", err)
+	}
+
+	return
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// maximum size of a replayed event
+	maxReplayEventSize = 1 << 20
+)
+
+// replayEvents reads canned events from r, one JSON event per line, and
+// sends them over the returned channel. Both raw ETW events and events
+// logged by the forwarder are accepted. Channel is closed when r is consumed.
+func (a *Agent) replayEvents(r io.Reader) (c chan *etw.Event) {
+	c = make(chan *etw.Event)
+
+	go func() {
+		defer close(c)
+
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 4096), maxReplayEventSize)
+
+		for s.Scan() && a.ctx.Err() == nil {
+			line := s.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+
+			// event logged by the forwarder
+			edr := event.EdrEvent{}
+			if err := json.Unmarshal(line, &edr); err == nil && edr.Event.Event != nil {
+				c <- edr.Event.Event
+				continue
+			}
+
+			e := etw.NewEvent()
+			if err := json.Unmarshal(line, e); err != nil {
+				a.logger.Errorf("Failed to decode replayed event: %s", err)
+				continue
+			}
+			c <- e
+		}
+
+		if err := s.Err(); err != nil {
+			a.logger.Errorf("Failed to read replayed events: %s", err)
+		}
+	}()
+
+	return
+}
+
+// openReplay opens a file or a named pipe to replay events from
+func (a *Agent) openReplay() (c chan *etw.Event, err error) {
+	var fd *os.File
+
+	if fd, err = os.Open(a.Replay); err != nil {
+		return
+	}
+
+	c = make(chan *etw.Event)
+	go func() {
+		defer fd.Close()
+		defer close(c)
+		for e := range a.replayEvents(fd) {
+			c <- e
+		}
+		a.logger.Infof("Done replaying events from %s", a.Replay)
+	}()
+
+	return
+}
@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+)
+
+// verifyApproval verifies that a destructive command carries a valid approval
+// issued by the manager within a responder session. Approvals are only
+// checked if an approval key is configured.
+func (a *Agent) verifyApproval(cmd *api.EndpointCommand) (err error) {
+	var approval *api.ResponderApproval
+
+	key := a.config.ResponderConfig.ApprovalKey
+	if key == "" || !api.IsDestructiveCommand(cmd.Name) {
+		return
+	}
+
+	if approval, err = cmd.VerifyApproval(key, a.config.FwdConfig.Client.UUID, time.Now()); err != nil {
+		return fmt.Errorf("refusing to run destructive command: %w", err)
+	}
+
+	a.logger.Infof("destructive command %s approved for user %s within responder session %s", cmd.Name, approval.User, approval.Session)
+	return
+}
@@ -0,0 +1,300 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/crypto/file"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// BlockRulePrefix prefix of the names of the firewall
+	// rules created by the block command
+	BlockRulePrefix = "EDR block "
+
+	quarantineMetaExt = ".json"
+)
+
+var (
+	quarantineDir = utils.BinRelativePath("Quarantine")
+)
+
+// QuarantineEntry describes a file moved to quarantine
+type QuarantineEntry struct {
+	Path      string    `json:"path"`
+	Sha256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+	// name of the file in quarantine directory
+	File string `json:"file,omitempty"`
+}
+
+// quarantineFile returns the name of the file in quarantine directory,
+// entries with an empty name were stored by sha256 only
+func (e *QuarantineEntry) quarantineFile() string {
+	if e.File == "" {
+		return e.Sha256
+	}
+	return e.File
+}
+
+// blockRuleName returns the name of the firewall rule blocking addr
+func blockRuleName(addr string) string {
+	return BlockRulePrefix + addr
+}
+
+// validBlockAddr returns true if addr is an IP address or a CIDR
+func validBlockAddr(addr string) bool {
+	if net.ParseIP(addr) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(addr)
+	return err == nil
+}
+
+// runCmd runs a command and returns an error embedding its output on failure
+func runCmd(c *exec.Cmd) error {
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// block blocks inbound and outbound traffic with an address
+func block(addr string) (err error) {
+	if !validBlockAddr(addr) {
+		return fmt.Errorf("invalid address to block: %s", addr)
+	}
+
+	// rule is deleted first not to stack up identical rules
+	unblock(addr)
+
+	for _, dir := range []string{"in", "out"} {
+		if err = runCmd(exec.Command("netsh.exe",
+			"advfirewall",
+			"firewall",
+			"add",
+			"rule",
+			fmt.Sprintf("name=%s", blockRuleName(addr)),
+			fmt.Sprintf("dir=%s", dir),
+			fmt.Sprintf("remoteip=%s", addr),
+			"action=block")); err != nil {
+			return fmt.Errorf("failed to block %s: %w", addr, err)
+		}
+	}
+
+	return
+}
+
+// unblock removes the firewall rules created to block an address
+func unblock(addr string) error {
+	// deletes rules in both directions as they share the same name
+	if err := runCmd(exec.Command("netsh.exe",
+		"advfirewall",
+		"firewall",
+		"delete",
+		"rule",
+		fmt.Sprintf("name=%s", blockRuleName(addr)))); err != nil {
+		return fmt.Errorf("failed to unblock %s: %w", addr, err)
+	}
+	return nil
+}
+
+// setUserActive enables or disables a local user account
+func setUserActive(user string, active bool) error {
+	flag := "/active:no"
+	if active {
+		flag = "/active:yes"
+	}
+
+	if err := runCmd(exec.Command("net.exe", "user", user, flag)); err != nil {
+		return fmt.Errorf("failed to set user %s %s: %w", user, flag, err)
+	}
+
+	return nil
+}
+
+// moveFile moves a file, copying it if it cannot be renamed (i.e. across volumes)
+func moveFile(src, dst string) (err error) {
+	var in, out *os.File
+
+	if err = os.Rename(src, dst); err == nil {
+		return
+	}
+
+	if in, err = os.Open(src); err != nil {
+		return
+	}
+	defer in.Close()
+
+	if out, err = os.Create(dst); err != nil {
+		return
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return
+	}
+
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
+
+// quarantine moves a file to the quarantine directory, files are stored by
+// sha256 and original path so that the same content quarantined from several
+// paths can be restored to every path
+func quarantine(path string) (e *QuarantineEntry, err error) {
+	var stat os.FileInfo
+	var meta []byte
+
+	if stat, err = os.Stat(path); err != nil {
+		return
+	}
+
+	if stat.IsDir() {
+		return nil, fmt.Errorf("cannot quarantine a directory: %s", path)
+	}
+
+	e = &QuarantineEntry{
+		Path:      path,
+		Size:      stat.Size(),
+		Timestamp: time.Now().UTC(),
+	}
+
+	if e.Sha256, err = file.Sha256(path); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	// paths are case insensitive on Windows
+	e.File = fmt.Sprintf("%s-%s", e.Sha256, data.Sha256([]byte(strings.ToLower(path)))[:16])
+
+	if err = utils.HidsMkdirAll(quarantineDir); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	dst := filepath.Join(quarantineDir, e.File)
+	if meta, err = json.Marshal(e); err != nil {
+		return
+	}
+
+	if err = moveFile(path, dst); err != nil {
+		return nil, fmt.Errorf("failed to move file to quarantine: %w", err)
+	}
+
+	if err = utils.HidsWriteData(dst+quarantineMetaExt, meta); err != nil {
+		return nil, fmt.Errorf("failed to write quarantine metadata: %w", err)
+	}
+
+	return
+}
+
+// quarantined returns the entries of the files in quarantine
+func quarantined() (entries []*QuarantineEntry, err error) {
+	var metas []string
+
+	if metas, err = filepath.Glob(filepath.Join(quarantineDir, "*"+quarantineMetaExt)); err != nil {
+		return
+	}
+
+	entries = make([]*QuarantineEntry, 0, len(metas))
+	for _, m := range metas {
+		var b []byte
+
+		e := &QuarantineEntry{}
+		if b, err = os.ReadFile(m); err != nil {
+			return
+		}
+		if err = json.Unmarshal(b, e); err != nil {
+			return nil, fmt.Errorf("bad quarantine metadata %s: %w", m, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return
+}
+
+// unquarantine restores a file in quarantine to its original path
+func unquarantine(path string) (err error) {
+	var entries []*QuarantineEntry
+
+	if entries, err = quarantined(); err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if !strings.EqualFold(e.Path, path) {
+			continue
+		}
+
+		if _, err = os.Stat(e.Path); err == nil {
+			return fmt.Errorf("cannot restore file, path already exists: %s", e.Path)
+		}
+
+		src := filepath.Join(quarantineDir, e.quarantineFile())
+		if err = moveFile(src, e.Path); err != nil {
+			return fmt.Errorf("failed to restore file from quarantine: %w", err)
+		}
+
+		return os.Remove(src + quarantineMetaExt)
+	}
+
+	return fmt.Errorf("file not in quarantine: %s", path)
+}
+
+// revert reverts a response action given its specification
+func (a *Agent) revert(spec string) (err error) {
+	typ, target := api.ParseActionSpec(spec)
+
+	switch typ {
+	case api.ActionIsolation:
+		return a.uncontain()
+	case api.ActionFirewallBlock:
+		return unblock(target)
+	case api.ActionDisableUser:
+		return setUserActive(target, true)
+	case api.ActionQuarantine:
+		return unquarantine(target)
+	case api.ActionProcessIsolation:
+		// isolation is released when process terminates
+		_, err = a.releaseProcesses(target)
+		return
+	case api.ActionProcessSuspension:
+		var t *ProcessTrack
+		if t, err = a.processTrack(target); err != nil {
+			return
+		}
+		return a.resumeProcess(t)
+	}
+
+	return fmt.Errorf("unknown response action: %s", typ)
+}
+
+// cmdRevert reverts a list of response actions, an error
+// reverting one action does not prevent the others to be run
+func (a *Agent) cmdRevert(specs []string) (results []*api.RevertResult) {
+	results = make([]*api.RevertResult, 0, len(specs))
+	for _, spec := range specs {
+		r := &api.RevertResult{Action: spec}
+		if err := a.revert(spec); err != nil {
+			r.Error = err.Error()
+			a.logger.Errorf("failed to revert action %s: %s", spec, err)
+		}
+		results = append(results, r)
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"os"
+	"time"
+
+	"github.com/0xrawsec/crony"
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// SafeModeRuleName name of the builtin filter rule used in safe mode
+	// and of the detection reported to the manager when entering safe mode
+	SafeModeRuleName = "Builtin:SafeMode"
+
+	// channel of the events generated by the agent itself
+	agentChannel = "EDR-Agent"
+	// event ID of the event generated when entering safe mode
+	agentSafeModeEventID = 1
+)
+
+var (
+	// SafeModeMinRetry initial delay before retrying to load rules in safe mode
+	SafeModeMinRetry = 30 * time.Second
+	// SafeModeMaxRetry maximum delay between two rule loading attempts
+	SafeModeMaxRetry = time.Hour
+	// SafeModeEventIDs high value Sysmon events forwarded in safe mode
+	SafeModeEventIDs = []int64{
+		SysmonProcessCreate,
+		SysmonDriverLoad,
+		SysmonCreateRemoteThread,
+		SysmonRawAccessRead,
+		SysmonServiceConfigurationChange,
+		SysmonWMIFilter,
+		SysmonWMIConsumer,
+		SysmonWMIBinding,
+		SysmonProcessTampering,
+	}
+)
+
+// ruleSafeMode generates the filter rule forwarding high value
+// Sysmon events when the agent runs in safe mode
+func ruleSafeMode() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = SafeModeRuleName
+	r.Meta.Events = map[string][]int64{sysmonChannel: SafeModeEventIDs}
+	r.Meta.Filter = true
+	return
+}
+
+// safeModeEvent generates the self alert sent to the manager so that
+// it knows the endpoint runs in a degraded state
+func (a *Agent) safeModeEvent(reason error) *event.EdrEvent {
+	e := etw.NewEvent()
+	hostname, _ := os.Hostname()
+
+	e.System.Channel = agentChannel
+	e.System.Computer = hostname
+	e.System.EventID = agentSafeModeEventID
+	e.System.Execution.ProcessID = u32PID
+	e.System.Provider.Name = agentChannel
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData["Reason"] = reason.Error()
+	e.EventData["RulesDB"] = a.config.RulesConfig.RulesDB
+	e.EventData["ContainersDB"] = a.config.RulesConfig.ContainersDB
+
+	d := engine.NewDetection(false, false)
+	d.Signature.Add(SafeModeRuleName)
+	d.Criticality = 10
+
+	edrEvt := event.NewEdrEvent(e)
+	edrEvt.NormalizeTime()
+	edrEvt.SetDetection(d)
+
+	return edrEvt
+}
+
+// IsSafeMode returns true if the agent runs in safe mode, meaning
+// detection rules failed to load
+func (a *Agent) IsSafeMode() bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.safeMode
+}
+
+// enterSafeMode switches the agent to a minimal pipeline only forwarding
+// high value events, notifies manager and retries loading rules
+// with an exponential backoff until it succeeds
+func (a *Agent) enterSafeMode(reason error) {
+	a.logger.Criticalf("Failed to load engine, entering safe mode: %s", reason)
+
+	safeEngine := newActionnableEngine(a.config)
+	sr := ruleSafeMode()
+	if err := safeEngine.LoadRule(&sr); err != nil {
+		a.logger.Errorf("Failed to load safe mode rule: %s", err)
+	}
+
+	a.Lock()
+	a.Engine = safeEngine
+	a.safeMode = true
+	a.Unlock()
+
+	if err := a.forward(a.safeModeEvent(reason)); err != nil {
+		a.logger.Errorf("Failed to pipe safe mode event: %s", err)
+	}
+
+	a.scheduler.Schedule(crony.NewAsyncTask("Safe mode rule reload").
+		Func(func() {
+			task := "[safe mode rule reload]"
+			delay := SafeModeMinRetry
+			for a.IsSafeMode() {
+				a.logger.Infof("%s next attempt in %s", task, delay)
+				select {
+				case <-a.ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+
+				if err := a.update(true); err != nil {
+					a.logger.Error(task, err)
+				}
+
+				if delay *= 2; delay > SafeModeMaxRetry {
+					delay = SafeModeMaxRetry
+				}
+			}
+		}).Schedule(time.Now()), crony.PrioHigh)
+}
+
+// leaveSafeMode must be called once a fully functional engine is loaded
+func (a *Agent) leaveSafeMode() {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.safeMode {
+		a.safeMode = false
+		a.logger.Info("Engine successfully loaded, leaving safe mode")
+	}
+}
@@ -0,0 +1,418 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// SelfTestRulePrefix prefix of the builtin rules validating detection coverage
+	SelfTestRulePrefix = "Builtin:SelfTest:"
+
+	// string found in all the events generated by self-tests
+	selfTestMarker   = "whids-selftest-"
+	selfTestMarkerRe = `(?i:whids-selftest-[0-9a-f]{8})`
+)
+
+var (
+	// SelfTestTimeout default time waited for self-test events
+	SelfTestTimeout = time.Minute
+	// SelfTestMaxTimeout maximum time waited for self-test events
+	SelfTestMaxTimeout = 10 * time.Minute
+
+	ErrSelfTestRunning = errors.New("self-test already running")
+
+	// paths of the fields self-tests markers can be found in
+	selfTestMarkerPaths = []*engine.XPath{
+		pathSysmonCommandLine,
+		pathSysmonTargetFilename,
+		pathSysmonTargetObject,
+		pathQueryName,
+	}
+
+	// SelfTestRules builtin rules firing on self-tests events only
+	SelfTestRules = selfTestRules()
+)
+
+// selfTest is a benign trigger of an attack technique
+type selfTest struct {
+	Technique string
+	Name      string
+	// Sysmon event expected
+	EventID int64
+	// event field carrying the marker
+	Field string
+	// regex the image generating the event must match
+	Image string
+	// field expected to be set by hooks on the event
+	Hook *engine.XPath
+	// commands triggering the technique
+	Trigger func(marker string) [][]string
+	// commands cleaning up after trigger
+	Cleanup func(marker string) [][]string
+}
+
+func (t *selfTest) ruleName() string {
+	return SelfTestRulePrefix + t.Technique
+}
+
+func (t *selfTest) rule() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = t.ruleName()
+	r.Meta.Events = map[string][]int64{sysmonChannel: {t.EventID}}
+	r.Meta.Criticality = 10
+	r.Meta.Attack = append(r.Meta.Attack, engine.Attack{ID: t.Technique, Description: t.Name})
+	r.Matches = []string{
+		fmt.Sprintf("$marker: %s ~= '%s'", t.Field, selfTestMarkerRe),
+	}
+	r.Condition = "$marker"
+
+	if t.Image != "" {
+		r.Matches = append(r.Matches, fmt.Sprintf("$image: Image ~= '%s'", t.Image))
+		r.Condition = "$marker and $image"
+	}
+	return
+}
+
+func selfTestRunKey() string {
+	return `HKCU\Software\Microsoft\Windows\CurrentVersion\Run`
+}
+
+func selfTestFile(marker string) string {
+	return filepath.Join(os.TempDir(), marker+".txt")
+}
+
+var (
+	selfTests = []*selfTest{
+		{
+			Technique: "T1059.003",
+			Name:      "Windows Command Shell",
+			EventID:   SysmonProcessCreate,
+			Field:     "CommandLine",
+			Image:     `(?i:\\cmd\.exe$)`,
+			Hook:      pathAncestors,
+			Trigger: func(marker string) [][]string {
+				return [][]string{{"cmd.exe", "/c", "echo", marker}}
+			},
+		},
+		{
+			Technique: "T1059.001",
+			Name:      "PowerShell",
+			EventID:   SysmonProcessCreate,
+			Field:     "CommandLine",
+			Image:     `(?i:\\powershell\.exe$)`,
+			Hook:      pathAncestors,
+			Trigger: func(marker string) [][]string {
+				return [][]string{{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", fmt.Sprintf("Write-Output '%s'", marker)}}
+			},
+		},
+		{
+			Technique: "T1074.001",
+			Name:      "Local Data Staging",
+			EventID:   SysmonFileCreate,
+			Field:     "TargetFilename",
+			Hook:      pathFileCount,
+			Trigger: func(marker string) [][]string {
+				return [][]string{{"cmd.exe", "/c", fmt.Sprintf("echo %s> %s", marker, selfTestFile(marker))}}
+			},
+			Cleanup: func(marker string) [][]string {
+				return [][]string{{"cmd.exe", "/c", "del", "/f", "/q", selfTestFile(marker)}}
+			},
+		},
+		{
+			Technique: "T1547.001",
+			Name:      "Registry Run Keys",
+			EventID:   SysmonRegSetValue,
+			Field:     "TargetObject",
+			Hook:      pathImageHashes,
+			Trigger: func(marker string) [][]string {
+				return [][]string{{"reg.exe", "add", selfTestRunKey(), "/v", marker, "/t", "REG_SZ", "/d", "cmd.exe /c exit", "/f"}}
+			},
+			Cleanup: func(marker string) [][]string {
+				return [][]string{{"reg.exe", "delete", selfTestRunKey(), "/v", marker, "/f"}}
+			},
+		},
+		{
+			Technique: "T1071.004",
+			Name:      "DNS",
+			EventID:   SysmonDNSQuery,
+			Field:     "QueryName",
+			Hook:      pathDGAScore,
+			Trigger: func(marker string) [][]string {
+				// .invalid TLD is reserved and never resolves
+				return [][]string{{"ping.exe", "-n", "1", marker + ".invalid"}}
+			},
+		},
+	}
+)
+
+func selfTestRules() (rules []engine.Rule) {
+	for _, t := range selfTests {
+		rules = append(rules, t.rule())
+	}
+	return
+}
+
+// SelfTestResult is the coverage result of a technique
+type SelfTestResult struct {
+	Technique string `json:"technique"`
+	Name      string `json:"name"`
+	Rule      string `json:"rule"`
+	// trigger has been run
+	Triggered bool `json:"triggered"`
+	// rule fired locally
+	Detected bool `json:"detected"`
+	// hooks enriched the event
+	Enriched bool `json:"enriched"`
+	// alert has been received by the manager
+	Delivered bool   `json:"delivered"`
+	Pass      bool   `json:"pass"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SelfTestReport is the detection coverage report of a self-test
+type SelfTestReport struct {
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Results []*SelfTestResult `json:"results"`
+}
+
+// selfTestRun holds the state of a running self-test
+type selfTestRun struct {
+	sync.Mutex
+	marker  string
+	results map[string]*SelfTestResult
+	tests   map[string]*selfTest
+	events  []*event.EdrEvent
+}
+
+func newSelfTestRun() *selfTestRun {
+	u := utils.UnsafeUUID()
+
+	r := &selfTestRun{
+		marker:  selfTestMarker + hex.EncodeToString(u[:4]),
+		results: make(map[string]*SelfTestResult),
+		tests:   make(map[string]*selfTest),
+		events:  make([]*event.EdrEvent, 0),
+	}
+
+	for _, t := range selfTests {
+		r.tests[t.ruleName()] = t
+		r.results[t.ruleName()] = &SelfTestResult{
+			Technique: t.Technique,
+			Name:      t.Name,
+			Rule:      t.ruleName(),
+		}
+	}
+
+	return r
+}
+
+// expects returns true if the event has been generated by the self-test
+func (r *selfTestRun) expects(e *event.EdrEvent) bool {
+	for _, p := range selfTestMarkerPaths {
+		if s, ok := e.GetString(p); ok && strings.Contains(strings.ToLower(s), r.marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe accounts for an event detected by self-test rules, it returns
+// true if the event has been generated by the self-test
+func (r *selfTestRun) observe(e *event.EdrEvent) (ok bool) {
+	d := e.GetDetection()
+	if d == nil || d.Signature == nil || !r.expects(e) {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for name, res := range r.results {
+		if !d.Signature.Contains(name) {
+			continue
+		}
+
+		ok = true
+		res.Detected = true
+		if v, set := e.GetString(r.tests[name].Hook); set && v != unkFieldValue {
+			res.Enriched = true
+		}
+	}
+
+	if ok {
+		r.events = append(r.events, e)
+	}
+
+	return
+}
+
+// done returns true if all the triggered techniques have been detected
+func (r *selfTestRun) done() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, res := range r.results {
+		if res.Triggered && !res.Detected {
+			return false
+		}
+	}
+	return true
+}
+
+// selfTestExpects returns true if the event is generated by a running self-test
+func (a *Agent) selfTestExpects(e *event.EdrEvent) bool {
+	a.selftest.RLock()
+	defer a.selftest.RUnlock()
+	return a.selftest.run != nil && a.selftest.run.expects(e)
+}
+
+// selfTestObserve accounts for an event detected while a self-test is running,
+// it returns true if the event has been generated by the self-test
+func (a *Agent) selfTestObserve(e *event.EdrEvent) bool {
+	a.selftest.RLock()
+	defer a.selftest.RUnlock()
+	return a.selftest.run != nil && a.selftest.run.observe(e)
+}
+
+func runSelfTestCommands(commands [][]string) (err error) {
+	for _, c := range commands {
+		if err = exec.Command(c[0], c[1:]...).Run(); err != nil {
+			// command ran but exited with an error (i.e. name resolution failure)
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				err = nil
+				continue
+			}
+			return
+		}
+	}
+	return
+}
+
+// deliverSelfTest sends the alerts generated by a self-test to the manager
+func (a *Agent) deliverSelfTest(run *selfTestRun) (err error) {
+	buf := new(bytes.Buffer)
+
+	if !a.config.IsForwardingEnabled() {
+		return fmt.Errorf("no manager configured")
+	}
+
+	if len(run.events) == 0 {
+		return
+	}
+
+	for _, e := range run.events {
+		var b []byte
+		if b, err = utils.Json(e); err != nil {
+			return
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	return a.forwarder.Client.PostLogs(buf)
+}
+
+// cmdSelfTest runs the self-test pack and returns a coverage report
+func (a *Agent) cmdSelfTest(timeout time.Duration) (r *SelfTestReport, err error) {
+	if timeout <= 0 {
+		timeout = SelfTestTimeout
+	}
+
+	if timeout > SelfTestMaxTimeout {
+		timeout = SelfTestMaxTimeout
+	}
+
+	run := newSelfTestRun()
+
+	a.selftest.Lock()
+	if a.selftest.run != nil {
+		a.selftest.Unlock()
+		return nil, ErrSelfTestRunning
+	}
+	a.selftest.run = run
+	a.selftest.Unlock()
+
+	defer func() {
+		a.selftest.Lock()
+		a.selftest.run = nil
+		a.selftest.Unlock()
+	}()
+
+	r = &SelfTestReport{Start: time.Now().UTC(), Results: make([]*SelfTestResult, 0, len(selfTests))}
+
+	for _, t := range selfTests {
+		res := run.results[t.ruleName()]
+		err := runSelfTestCommands(t.Trigger(run.marker))
+
+		run.Lock()
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to trigger: %s", err)
+		} else {
+			res.Triggered = true
+		}
+		run.Unlock()
+
+		r.Results = append(r.Results, res)
+	}
+
+	// waiting for events to be processed
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline) && !run.done(); {
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	for _, t := range selfTests {
+		if t.Cleanup != nil {
+			if err := runSelfTestCommands(t.Cleanup(run.marker)); err != nil {
+				a.logger.Errorf("Failed to cleanup self-test %s: %s", t.Technique, err)
+			}
+		}
+	}
+
+	run.Lock()
+	defer run.Unlock()
+
+	derr := a.deliverSelfTest(run)
+
+	for _, res := range r.Results {
+		res.Delivered = res.Detected && derr == nil
+
+		switch {
+		case !res.Triggered:
+		case !res.Detected:
+			res.Error = "rule did not fire"
+		case !res.Enriched:
+			res.Error = "event not enriched by hooks"
+		case !res.Delivered:
+			res.Error = fmt.Sprintf("alert not delivered to manager: %s", derr)
+		default:
+			res.Pass = true
+		}
+
+		if res.Pass {
+			r.Passed++
+		} else {
+			r.Failed++
+		}
+	}
+
+	r.End = time.Now().UTC()
+
+	return
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/whids/utils"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+var (
+	serviceStartTypes = map[uint32]string{
+		windows.SERVICE_BOOT_START:   "boot",
+		windows.SERVICE_SYSTEM_START: "system",
+		windows.SERVICE_AUTO_START:   "auto",
+		windows.SERVICE_DEMAND_START: "manual",
+		windows.SERVICE_DISABLED:     "disabled",
+	}
+
+	serviceStates = map[svc.State]string{
+		svc.Stopped:         "stopped",
+		svc.StartPending:    "start-pending",
+		svc.StopPending:     "stop-pending",
+		svc.Running:         "running",
+		svc.ContinuePending: "continue-pending",
+		svc.PausePending:    "pause-pending",
+		svc.Paused:          "paused",
+	}
+)
+
+// serviceType returns a readable service type out of SERVICE_* type flags
+func serviceType(t uint32) string {
+	switch {
+	case t&windows.SERVICE_KERNEL_DRIVER != 0:
+		return "kernel-driver"
+	case t&windows.SERVICE_FILE_SYSTEM_DRIVER != 0:
+		return "file-system-driver"
+	case t&windows.SERVICE_WIN32_OWN_PROCESS != 0:
+		return "own-process"
+	case t&windows.SERVICE_WIN32_SHARE_PROCESS != 0:
+		return "share-process"
+	}
+	return fmt.Sprintf("0x%x", t)
+}
+
+// ServiceInfo describes a service registered on the system
+type ServiceInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display-name"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	StartType   string `json:"start-type"`
+	Delayed     bool   `json:"delayed,omitempty"`
+	State       string `json:"state"`
+	PID         int    `json:"pid,omitempty"`
+	Account     string `json:"account,omitempty"`
+	// command line of the service
+	BinaryPath string `json:"binary-path"`
+	// DLL implementing services hosted by svchost.exe
+	ServiceDll string            `json:"service-dll,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	Hashes     map[string]string `json:"hashes,omitempty"`
+	Signer     *ImageSigner      `json:"signer,omitempty"`
+}
+
+// Services holds the result of the enumeration of services
+type Services struct {
+	Services []*ServiceInfo `json:"services"`
+	// services which failed to be queried
+	Errors []string `json:"errors,omitempty"`
+}
+
+// queryService returns information about a service
+func queryService(m *mgr.Mgr, name string) (si *ServiceInfo, err error) {
+	var s *mgr.Service
+	var c mgr.Config
+	var status svc.Status
+
+	if s, err = m.OpenService(name); err != nil {
+		return
+	}
+	defer s.Close()
+
+	if c, err = s.Config(); err != nil {
+		return
+	}
+
+	si = &ServiceInfo{
+		Name:        name,
+		DisplayName: c.DisplayName,
+		Description: c.Description,
+		Type:        serviceType(c.ServiceType),
+		StartType:   serviceStartTypes[c.StartType],
+		Delayed:     c.DelayedAutoStart,
+		Account:     c.ServiceStartName,
+		BinaryPath:  c.BinaryPathName,
+		ServiceDll:  utils.RegValueToString(pathServicesKey, name, "Parameters", "ServiceDll"),
+	}
+
+	if status, err = s.Query(); err != nil {
+		return
+	}
+
+	si.State = serviceStates[status.State]
+	si.PID = int(status.ProcessId)
+
+	return
+}
+
+// cmdServices lists services registered on the system, images implementing
+// services are resolved, hashed and their signer looked up
+func (a *Agent) cmdServices() (services *Services, err error) {
+	var m *mgr.Mgr
+	var names []string
+
+	if m, err = mgr.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if names, err = m.ListServices(); err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	services = &Services{Services: make([]*ServiceInfo, 0, len(names))}
+	for _, name := range names {
+		si, err := queryService(m, name)
+		if err != nil {
+			services.Errors = append(services.Errors, fmt.Sprintf("failed to query service %s: %s", name, err))
+			if si == nil {
+				continue
+			}
+		}
+
+		if si.ServiceDll != "" {
+			si.Image = normalizeAutorunPath(si.ServiceDll)
+		} else {
+			si.Image = autorunImage(si.BinaryPath, fsutil.IsFile)
+		}
+
+		if si.Image != "" {
+			si.Hashes, _ = a.hashCache.Hashes(si.Image)
+			si.Signer = a.tracker.ImageSigner(si.Image)
+		}
+
+		services.Services = append(services.Services, si)
+	}
+
+	sort.Slice(services.Services, func(i, j int) bool {
+		return services.Services[i].Name < services.Services[j].Name
+	})
+
+	return
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"errors"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/whids/agent/sigma"
+	"github.com/0xrawsec/whids/api"
+)
+
+// loadSigmaRules converts the Sigma rules of the configured directory and
+// loads them into engine. Rules failing to convert or to load are skipped,
+// they must not prevent the engine from being updated.
+func (a *Agent) loadSigmaRules(e *engine.Engine) {
+	dir := a.config.RulesConfig.SigmaDB
+
+	if dir == "" || !fsutil.IsDir(dir) {
+		return
+	}
+
+	a.logger.Infof("Loading Sigma rules from: %s", dir)
+	rules, errs := sigma.LoadDirectory(dir)
+
+	skipped := 0
+	for _, err := range errs {
+		skipped++
+		// many rules of public repositories are expected not to be supported
+		if errors.Is(err, sigma.ErrUnsupported) {
+			a.logger.Debugf("Skipping Sigma rule: %s", err)
+		} else {
+			a.logger.Errorf("Failed to convert Sigma rule: %s", err)
+		}
+	}
+
+	loaded := 0
+	for _, r := range rules {
+		if err := e.LoadRule(&r); err != nil {
+			skipped++
+			a.logger.Errorf("Failed to load Sigma rule %s: %s", r.Name, err)
+			a.reportError(api.AgentErrorRule, r.Name, err)
+			continue
+		}
+		loaded++
+	}
+
+	a.logger.Infof("Number of Sigma rules loaded: %d skipped: %d", loaded, skipped)
+}
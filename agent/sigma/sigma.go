@@ -0,0 +1,628 @@
+// Package sigma converts Sigma rules (https://github.com/SigmaHQ/sigma)
+// into Gene rules so that existing Sigma repositories can be loaded
+// in the agent's engine without manual translation. Only Windows rules
+// of the log source categories backed by Sysmon events are supported.
+package sigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RuleNamePrefix prefix of the names of the rules converted from Sigma
+	RuleNamePrefix = "Sigma:"
+	// Tag set to all the rules converted from Sigma
+	Tag = "sigma"
+	// DefaultCriticality criticality of the rules without level
+	DefaultCriticality = 5
+
+	sysmonChannel = "Microsoft-Windows-Sysmon/Operational"
+)
+
+var (
+	// ErrUnsupported returned when a rule uses a Sigma feature which cannot
+	// be converted, such rules are skipped
+	ErrUnsupported = errors.New("unsupported")
+
+	// Sysmon event IDs of the supported log source categories
+	categories = map[string][]int64{
+		"process_creation":     {1},
+		"network_connection":   {3},
+		"driver_load":          {6},
+		"image_load":           {7},
+		"create_remote_thread": {8},
+		"process_access":       {10},
+		"file_event":           {11},
+		"registry_event":       {12, 13, 14},
+		"registry_add":         {12},
+		"registry_delete":      {12},
+		"registry_set":         {13},
+		"registry_rename":      {14},
+		"dns_query":            {22},
+	}
+
+	levels = map[string]int{
+		"informational": 1,
+		"low":           3,
+		"medium":        5,
+		"high":          8,
+		"critical":      10,
+	}
+
+	conditionTokenRe = regexp.MustCompile(`\(|\)|[^\s()]+`)
+	fieldRe          = regexp.MustCompile(`^\w+$`)
+	attackRe         = regexp.MustCompile(`^attack\.(t\d{4}(\.\d{3})?)$`)
+	// quotes cannot appear in Gene match values
+	quoteEscaper = strings.NewReplacer(`'`, `\x27`, `"`, `\x22`)
+)
+
+func unsupported(format string, a ...interface{}) error {
+	return fmt.Errorf("%w %s", ErrUnsupported, fmt.Sprintf(format, a...))
+}
+
+// LogSource is the log source section of a Sigma rule
+type LogSource struct {
+	Category string `yaml:"category"`
+	Product  string `yaml:"product"`
+	Service  string `yaml:"service"`
+}
+
+// Rule is a Sigma rule
+type Rule struct {
+	Title       string                 `yaml:"title"`
+	ID          string                 `yaml:"id"`
+	Status      string                 `yaml:"status"`
+	Description string                 `yaml:"description"`
+	Level       string                 `yaml:"level"`
+	Tags        []string               `yaml:"tags"`
+	LogSource   LogSource              `yaml:"logsource"`
+	Detection   map[string]interface{} `yaml:"detection"`
+}
+
+// Parse parses the Sigma rules of a YAML document stream
+func Parse(r io.Reader) (rules []*Rule, err error) {
+	rules = make([]*Rule, 0)
+	dec := yaml.NewDecoder(r)
+
+	for {
+		sr := &Rule{}
+		if err = dec.Decode(sr); err == io.EOF {
+			return rules, nil
+		} else if err != nil {
+			return
+		}
+		rules = append(rules, sr)
+	}
+}
+
+// expr is a node of the condition of a rule, negations are
+// only applied to matches as Gene cannot negate groups
+type expr struct {
+	op    string
+	match string
+	neg   bool
+	args  []*expr
+}
+
+func group(op string, args []*expr) *expr {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return &expr{op: op, args: args}
+}
+
+func (e *expr) negate() *expr {
+	switch e.op {
+	case "and", "or":
+		op := "and"
+		if e.op == "and" {
+			op = "or"
+		}
+		args := make([]*expr, len(e.args))
+		for i, a := range e.args {
+			args[i] = a.negate()
+		}
+		return &expr{op: op, args: args}
+	default:
+		return &expr{match: e.match, neg: !e.neg}
+	}
+}
+
+func (e *expr) String() string {
+	switch e.op {
+	case "and", "or":
+		s := make([]string, len(e.args))
+		for i, a := range e.args {
+			s[i] = a.String()
+		}
+		return "(" + strings.Join(s, " "+e.op+" ") + ")"
+	default:
+		if e.neg {
+			return "!" + e.match
+		}
+		return e.match
+	}
+}
+
+// converter converts the detection section of a Sigma rule
+type converter struct {
+	detection  map[string]interface{}
+	selections map[string]*expr
+	matches    []string
+	tokens     []string
+	i          int
+}
+
+func newConverter(detection map[string]interface{}) *converter {
+	return &converter{
+		detection:  detection,
+		selections: make(map[string]*expr),
+		matches:    make([]string, 0),
+	}
+}
+
+func (c *converter) addMatch(field, value string) *expr {
+	name := fmt.Sprintf("$m%d", len(c.matches))
+	c.matches = append(c.matches, fmt.Sprintf("%s: %s ~= '%s'", name, field, quoteEscaper.Replace(value)))
+	return &expr{match: name}
+}
+
+// pattern converts a Sigma value into a regular expression
+func pattern(value interface{}, modifiers map[string]bool) (string, error) {
+	if value == nil {
+		return "", unsupported("null value")
+	}
+
+	s := fmt.Sprint(value)
+	if modifiers["re"] {
+		return s, nil
+	}
+
+	// wildcards are converted, other characters escaped
+	b := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.ContainsRune(`*?\`, rune(s[i+1])):
+			b.WriteString(regexp.QuoteMeta(s[i+1 : i+2]))
+			i++
+		case s[i] == '*':
+			b.WriteString(".*")
+		case s[i] == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		}
+	}
+
+	p := b.String()
+	switch {
+	case modifiers["contains"]:
+	case modifiers["startswith"]:
+		p = "^" + p
+	case modifiers["endswith"]:
+		p = p + "$"
+	default:
+		p = "^" + p + "$"
+	}
+
+	if !modifiers["cased"] {
+		p = "(?i)" + p
+	}
+
+	return p, nil
+}
+
+func (c *converter) field(key string, value interface{}) (*expr, error) {
+	parts := strings.Split(key, "|")
+	field := parts[0]
+	modifiers := make(map[string]bool)
+
+	if field == "" {
+		return nil, unsupported("keyword search")
+	}
+
+	if !fieldRe.MatchString(field) {
+		field = `"` + field + `"`
+	}
+
+	for _, m := range parts[1:] {
+		switch m {
+		case "contains", "startswith", "endswith", "all", "re", "cased":
+			modifiers[m] = true
+		default:
+			return nil, unsupported("modifier %s", m)
+		}
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no value for field %s", field)
+	}
+
+	args := make([]*expr, 0, len(values))
+	for _, v := range values {
+		p, err := pattern(v, modifiers)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, c.addMatch(field, p))
+	}
+
+	if modifiers["all"] {
+		return group("and", args), nil
+	}
+	return group("or", args), nil
+}
+
+func (c *converter) fields(m map[string]interface{}) (*expr, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]*expr, 0, len(keys))
+	for _, k := range keys {
+		e, err := c.field(k, m[k])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty selection")
+	}
+	return group("and", args), nil
+}
+
+// selection converts a search identifier, a map of fields being
+// a conjunction and a list of maps a disjunction
+func (c *converter) selection(name string) (e *expr, err error) {
+	var ok bool
+
+	if e, ok = c.selections[name]; ok {
+		return
+	}
+
+	switch s := c.detection[name].(type) {
+	case map[string]interface{}:
+		e, err = c.fields(s)
+	case []interface{}:
+		args := make([]*expr, 0, len(s))
+		for _, i := range s {
+			m, ok := i.(map[string]interface{})
+			if !ok {
+				return nil, unsupported("keyword search")
+			}
+			a, err := c.fields(m)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty selection %s", name)
+		}
+		e = group("or", args)
+	default:
+		return nil, fmt.Errorf("unknown search identifier %s", name)
+	}
+
+	if err == nil {
+		c.selections[name] = e
+	}
+
+	return
+}
+
+// identifiers returns the search identifiers matching a pattern,
+// those starting with an underscore are not part of "them"
+func (c *converter) identifiers(pattern string) (names []string, err error) {
+	names = make([]string, 0)
+	for name := range c.detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+
+		if pattern == "them" {
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+		} else if ok, err := path.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no search identifier matching %s", pattern)
+	}
+
+	sort.Strings(names)
+	return
+}
+
+func (c *converter) next() string {
+	if c.i < len(c.tokens) {
+		c.i++
+		return c.tokens[c.i-1]
+	}
+	return ""
+}
+
+func (c *converter) peek() string {
+	if c.i < len(c.tokens) {
+		return c.tokens[c.i]
+	}
+	return ""
+}
+
+func (c *converter) parseOr() (*expr, error) {
+	args := make([]*expr, 0)
+	for {
+		e, err := c.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+
+		if c.peek() != "or" {
+			return group("or", args), nil
+		}
+		c.next()
+	}
+}
+
+func (c *converter) parseAnd() (*expr, error) {
+	args := make([]*expr, 0)
+	for {
+		e, err := c.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+
+		if c.peek() != "and" {
+			return group("and", args), nil
+		}
+		c.next()
+	}
+}
+
+func (c *converter) parseNot() (*expr, error) {
+	if c.peek() == "not" {
+		c.next()
+		e, err := c.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return e.negate(), nil
+	}
+	return c.parsePrimary()
+}
+
+func (c *converter) parsePrimary() (*expr, error) {
+	tok := c.next()
+
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case "(":
+		e, err := c.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis in condition")
+		}
+		return e, nil
+	case "1", "any", "all":
+		if c.next() != "of" {
+			return nil, fmt.Errorf("expecting \"of\" after %s in condition", tok)
+		}
+
+		names, err := c.identifiers(c.next())
+		if err != nil {
+			return nil, err
+		}
+
+		args := make([]*expr, 0, len(names))
+		for _, name := range names {
+			e, err := c.selection(name)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, e)
+		}
+
+		if tok == "all" {
+			return group("and", args), nil
+		}
+		return group("or", args), nil
+	case "|":
+		return nil, unsupported("aggregation")
+	case ")", "and", "or", "of", "near":
+		return nil, unsupported("condition token %s", tok)
+	default:
+		return c.selection(tok)
+	}
+}
+
+// condition converts a Sigma condition
+func (c *converter) condition(condition string) (*expr, error) {
+	c.tokens = conditionTokenRe.FindAllString(condition, -1)
+	c.i = 0
+
+	e, err := c.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := c.peek(); tok == "|" {
+		return nil, unsupported("aggregation")
+	} else if tok != "" {
+		return nil, fmt.Errorf("unexpected token %s in condition", tok)
+	}
+
+	return e, nil
+}
+
+// Gene converts a Sigma rule into a Gene rule
+func (r *Rule) Gene() (gr engine.Rule, err error) {
+	var events []int64
+	var ok bool
+	var conditions []string
+	var e *expr
+
+	gr = engine.NewRule()
+
+	if r.Title == "" {
+		return gr, fmt.Errorf("rule without title")
+	}
+
+	if strings.ToLower(r.LogSource.Product) != "windows" {
+		return gr, unsupported("product %q", r.LogSource.Product)
+	}
+
+	if events, ok = categories[strings.ToLower(r.LogSource.Category)]; !ok {
+		return gr, unsupported("log source category %q", r.LogSource.Category)
+	}
+
+	switch cond := r.Detection["condition"].(type) {
+	case string:
+		conditions = []string{cond}
+	case []interface{}:
+		for _, c := range cond {
+			conditions = append(conditions, fmt.Sprint(c))
+		}
+	default:
+		return gr, fmt.Errorf("rule without condition")
+	}
+
+	c := newConverter(r.Detection)
+	args := make([]*expr, 0, len(conditions))
+	for _, cond := range conditions {
+		if e, err = c.condition(cond); err != nil {
+			return
+		}
+		args = append(args, e)
+	}
+
+	gr.Name = RuleNamePrefix + r.Title
+	gr.Tags = append(gr.Tags, Tag)
+	gr.Meta.Events = map[string][]int64{sysmonChannel: events}
+	gr.Meta.Criticality = DefaultCriticality
+	if crit, ok := levels[strings.ToLower(r.Level)]; ok {
+		gr.Meta.Criticality = crit
+	}
+	gr.Meta.Disable = strings.ToLower(r.Status) == "deprecated"
+
+	for _, t := range r.Tags {
+		gr.Tags = append(gr.Tags, t)
+		if m := attackRe.FindStringSubmatch(strings.ToLower(t)); m != nil {
+			gr.Meta.Attack = append(gr.Meta.Attack, engine.Attack{
+				ID:          strings.ToUpper(m[1]),
+				Description: r.Title,
+			})
+		}
+	}
+
+	gr.Matches = c.matches
+	gr.Condition = group("or", args).String()
+
+	return
+}
+
+// Convert converts the Sigma rules of a YAML document stream
+func Convert(data []byte) (rules []engine.Rule, err error) {
+	var srules []*Rule
+
+	if srules, err = Parse(bytes.NewReader(data)); err != nil {
+		return
+	}
+
+	rules = make([]engine.Rule, 0, len(srules))
+	for _, sr := range srules {
+		r, err := sr.Gene()
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", sr.Title, err)
+		}
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+// LoadDirectory converts the Sigma rules (.yml or .yaml files) found
+// recursively in a directory. Rules which cannot be converted are
+// skipped, errors are returned along with the rules converted.
+func LoadDirectory(dir string) (rules []engine.Rule, errs []error) {
+	rules = make([]engine.Rule, 0)
+	errs = make([]error, 0)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		var f *os.File
+		var srules []*Rule
+
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".yml", ".yaml":
+		default:
+			return nil
+		}
+
+		if f, err = os.Open(p); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		defer f.Close()
+
+		if srules, err = Parse(f); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			return nil
+		}
+
+		for _, sr := range srules {
+			if r, err := sr.Gene(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: rule %q: %w", p, sr.Title, err))
+			} else {
+				rules = append(rules, r)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return
+}
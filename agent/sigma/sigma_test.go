@@ -0,0 +1,237 @@
+package sigma
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	processCreation = `
+title: Suspicious Encoded PowerShell
+id: 9a3a0f0c-6a1b-4a2e-9d4b-3f6d2f1b8c11
+status: experimental
+level: high
+tags:
+  - attack.execution
+  - attack.t1059.001
+logsource:
+  category: process_creation
+  product: windows
+detection:
+  selection_img:
+    - Image|endswith: '\powershell.exe'
+    - OriginalFileName: PowerShell.EXE
+  selection_cli:
+    CommandLine|contains:
+      - ' -enc '
+      - ' -EncodedCommand '
+  filter_parent:
+    ParentImage|startswith: 'C:\Program Files\'
+  condition: all of selection_* and not filter_parent
+`
+
+	registry = `
+title: Run Key Persistence
+level: medium
+logsource:
+  category: registry_set
+  product: windows
+detection:
+  selection:
+    TargetObject|contains|all:
+      - '\CurrentVersion\'
+      - '\Run\'
+    Details|contains: '\AppData\'
+  condition: selection
+---
+title: Network Connection To Suspicious Port
+logsource:
+  category: network_connection
+  product: windows
+detection:
+  selection:
+    Initiated: 'true'
+    DestinationPort:
+      - 4444
+      - 1337
+  filter:
+    - DestinationIp|startswith: '10.'
+    - Image: C:\Windows\System32\svchost.exe
+  condition: selection and not (filter)
+`
+)
+
+func sysmonEvent(id uint16, data map[string]string) *event.EdrEvent {
+	e := etw.NewEvent()
+	e.System.Channel = sysmonChannel
+	e.System.EventID = id
+	for k, v := range data {
+		e.EventData[k] = v
+	}
+	return event.NewEdrEvent(e)
+}
+
+func loadEngine(t *testing.T, data string) *engine.Engine {
+	tt := toast.FromT(t)
+
+	rules, err := Convert([]byte(data))
+	tt.CheckErr(err)
+
+	e := engine.NewEngine()
+	for _, r := range rules {
+		tt.CheckErr(e.LoadRule(&r))
+	}
+
+	return e
+}
+
+func matches(e *engine.Engine, evt *event.EdrEvent) []string {
+	names, _, _ := e.MatchOrFilter(evt)
+	return names
+}
+
+func TestConvert(t *testing.T) {
+	tt := toast.FromT(t)
+
+	rules, err := Convert([]byte(processCreation))
+	tt.CheckErr(err)
+	tt.Assert(len(rules) == 1)
+
+	r := rules[0]
+	tt.Assert(r.Name == RuleNamePrefix+"Suspicious Encoded PowerShell")
+	tt.Assert(r.Meta.Criticality == 8)
+	tt.Assert(len(r.Meta.Events[sysmonChannel]) == 1 && r.Meta.Events[sysmonChannel][0] == 1)
+	tt.Assert(len(r.Meta.Attack) == 1 && r.Meta.Attack[0].ID == "T1059.001")
+	tt.Assert(r.Tags[0] == Tag)
+	t.Log(r.JSON())
+}
+
+func TestProcessCreation(t *testing.T) {
+	tt := toast.FromT(t)
+	e := loadEngine(t, processCreation)
+
+	tt.Assert(len(matches(e, sysmonEvent(1, map[string]string{
+		"Image":       `C:\Windows\System32\WindowsPowerShell\v1.0\POWERSHELL.EXE`,
+		"CommandLine": `powershell.exe -enc SQBFAFgA`,
+		"ParentImage": `C:\Windows\explorer.exe`,
+	}))) == 1)
+
+	// matched by original file name
+	tt.Assert(len(matches(e, sysmonEvent(1, map[string]string{
+		"Image":            `C:\Users\Public\p.exe`,
+		"OriginalFileName": "PowerShell.EXE",
+		"CommandLine":      `p.exe -EncodedCommand SQBFAFgA`,
+		"ParentImage":      `C:\Windows\explorer.exe`,
+	}))) == 1)
+
+	// filtered parent
+	tt.Assert(len(matches(e, sysmonEvent(1, map[string]string{
+		"Image":       `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+		"CommandLine": `powershell.exe -enc SQBFAFgA`,
+		"ParentImage": `C:\Program Files\Tool\tool.exe`,
+	}))) == 0)
+
+	// not encoded
+	tt.Assert(len(matches(e, sysmonEvent(1, map[string]string{
+		"Image":       `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+		"CommandLine": `powershell.exe -File script.ps1`,
+		"ParentImage": `C:\Windows\explorer.exe`,
+	}))) == 0)
+
+	// other event
+	tt.Assert(len(matches(e, sysmonEvent(3, map[string]string{
+		"Image":       `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+		"CommandLine": `powershell.exe -enc SQBFAFgA`,
+	}))) == 0)
+}
+
+func TestRegistryAndNetwork(t *testing.T) {
+	tt := toast.FromT(t)
+	e := loadEngine(t, registry)
+
+	tt.Assert(len(matches(e, sysmonEvent(13, map[string]string{
+		"TargetObject": `HKU\S-1-5-21\Software\Microsoft\Windows\CurrentVersion\Run\updater`,
+		"Details":      `C:\Users\bob\AppData\Roaming\updater.exe`,
+	}))) == 1)
+
+	// both values must be contained
+	tt.Assert(len(matches(e, sysmonEvent(13, map[string]string{
+		"TargetObject": `HKU\S-1-5-21\Software\Microsoft\Windows\CurrentVersion\RunOnce\updater`,
+		"Details":      `C:\Users\bob\AppData\Roaming\updater.exe`,
+	}))) == 0)
+
+	tt.Assert(len(matches(e, sysmonEvent(3, map[string]string{
+		"Initiated":       "true",
+		"DestinationPort": "4444",
+		"DestinationIp":   "203.0.113.5",
+		"Image":           `C:\Users\Public\implant.exe`,
+	}))) == 1)
+
+	// excluded by any of the filters
+	tt.Assert(len(matches(e, sysmonEvent(3, map[string]string{
+		"Initiated":       "true",
+		"DestinationPort": "1337",
+		"DestinationIp":   "10.0.0.5",
+		"Image":           `C:\Users\Public\implant.exe`,
+	}))) == 0)
+	tt.Assert(len(matches(e, sysmonEvent(3, map[string]string{
+		"Initiated":       "true",
+		"DestinationPort": "1337",
+		"DestinationIp":   "203.0.113.5",
+		"Image":           `C:\Windows\System32\svchost.exe`,
+	}))) == 0)
+
+	// wrong port
+	tt.Assert(len(matches(e, sysmonEvent(3, map[string]string{
+		"Initiated":       "true",
+		"DestinationPort": "44444",
+		"DestinationIp":   "203.0.113.5",
+		"Image":           `C:\Users\Public\implant.exe`,
+	}))) == 0)
+}
+
+func TestUnsupported(t *testing.T) {
+	tt := toast.FromT(t)
+
+	for _, data := range []string{
+		// log source
+		"title: t\nlogsource:\n  product: linux\n  category: process_creation\ndetection:\n  s:\n    Image: x\n  condition: s\n",
+		"title: t\nlogsource:\n  product: windows\n  service: security\ndetection:\n  s:\n    EventID: 4624\n  condition: s\n",
+		// aggregation
+		"title: t\nlogsource:\n  product: windows\n  category: process_creation\ndetection:\n  s:\n    Image: x\n  condition: s | count() > 5\n",
+		// modifier
+		"title: t\nlogsource:\n  product: windows\n  category: process_creation\ndetection:\n  s:\n    CommandLine|base64offset|contains: x\n  condition: s\n",
+		// keywords
+		"title: t\nlogsource:\n  product: windows\n  category: process_creation\ndetection:\n  keywords:\n    - mimikatz\n  condition: keywords\n",
+	} {
+		_, err := Convert([]byte(data))
+		tt.Assert(errors.Is(err, ErrUnsupported))
+	}
+
+	// unknown search identifier
+	_, err := Convert([]byte("title: t\nlogsource:\n  product: windows\n  category: process_creation\ndetection:\n  s:\n    Image: x\n  condition: t\n"))
+	tt.Assert(err != nil && !errors.Is(err, ErrUnsupported))
+}
+
+func TestLoadDirectory(t *testing.T) {
+	tt := toast.FromT(t)
+
+	dir := t.TempDir()
+	tt.CheckErr(os.MkdirAll(filepath.Join(dir, "windows"), 0700))
+	tt.CheckErr(os.WriteFile(filepath.Join(dir, "windows", "powershell.yml"), []byte(processCreation), 0600))
+	tt.CheckErr(os.WriteFile(filepath.Join(dir, "windows", "persistence.yaml"), []byte(registry), 0600))
+	tt.CheckErr(os.WriteFile(filepath.Join(dir, "aggregation.yml"), []byte("title: t\nlogsource:\n  product: windows\n  category: process_creation\ndetection:\n  s:\n    Image: x\n  condition: s | count() > 5\n"), 0600))
+	tt.CheckErr(os.WriteFile(filepath.Join(dir, "README.md"), []byte("# rules"), 0600))
+
+	rules, errs := LoadDirectory(dir)
+	tt.Assert(len(rules) == 3)
+	tt.Assert(len(errs) == 1)
+	tt.Assert(errors.Is(errs[0], ErrUnsupported))
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"io"
+	"os"
+
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// initSink creates the event sink if enabled in configuration
+func (a *Agent) initSink() (err error) {
+	var w io.Writer = os.Stdout
+
+	c := a.config.SinkConfig
+	if !c.Enable {
+		return
+	}
+
+	if c.Path != "" {
+		if a.sinkFile, err = os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, utils.DefaultFilePerm); err != nil {
+			return
+		}
+		w = a.sinkFile
+	}
+
+	a.Sink, err = event.NewSink(w, c.Format, event.SinkFilter{
+		Channels:       c.Channels,
+		EventIDs:       c.EventIDs,
+		MinCriticality: c.MinCriticality,
+	})
+
+	return
+}
+
+// sinkEvent writes an event to the sink if any
+func (a *Agent) sinkEvent(e *event.EdrEvent) {
+	if a.Sink != nil {
+		if err := a.Sink.Write(e); err != nil {
+			a.logger.Errorf("Failed to write event to sink: %s", err)
+		}
+	}
+}
+
+// closeSink closes the file the sink writes to if any
+func (a *Agent) closeSink() {
+	if a.sinkFile != nil {
+		if err := a.sinkFile.Close(); err != nil {
+			a.logger.Errorf("Failed to close sink: %s", err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/api"
+)
+
+const (
+	// CustomChannelPrefix prefix of the channel of the events
+	// injected by custom sources, followed by source's name
+	CustomChannelPrefix = "Custom/"
+
+	// CustomSourcesEventsPath path of the ingestion API events are posted to
+	CustomSourcesEventsPath = "/events"
+
+	// default maximum size of an event injected by a custom source
+	defaultCustomEventSize = 64 * 1024
+)
+
+// CustomEvent is an event injected by a custom source
+type CustomEvent struct {
+	EventID   uint16                 `json:"event-id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// CustomIngestion is the result returned to a custom source posting events
+type CustomIngestion struct {
+	Accepted int      `json:"accepted"`
+	Rejected int      `json:"rejected"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// customSourcesServer receives events from other endpoint software and
+// sends them to the agent's pipeline, subject to rules and forwarding
+type customSourcesServer struct {
+	ctx      context.Context
+	conf     *config.CustomSources
+	hostname string
+	events   chan *etw.Event
+}
+
+func newCustomSourcesServer(ctx context.Context, conf *config.CustomSources) *customSourcesServer {
+	hostname, _ := os.Hostname()
+	return &customSourcesServer{
+		ctx:      ctx,
+		conf:     conf,
+		hostname: hostname,
+		events:   make(chan *etw.Event),
+	}
+}
+
+// validListenAddr returns an error if addr is not a loopback address
+// as the ingestion API must not be reachable from the network
+func validListenAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("not a loopback address: %s", addr)
+	}
+
+	return nil
+}
+
+// toEvent converts an event injected by source into an ETW event
+func (s *customSourcesServer) toEvent(source *config.CustomSource, ce *CustomEvent) *etw.Event {
+	e := etw.NewEvent()
+
+	e.System.Channel = CustomChannelPrefix + source.Name
+	e.System.Provider.Name = source.Name
+	e.System.Computer = s.hostname
+	e.System.EventID = ce.EventID
+	e.System.TimeCreated.SystemTime = ce.Timestamp
+	if ce.Timestamp.IsZero() {
+		e.System.TimeCreated.SystemTime = time.Now()
+	}
+
+	for k, v := range ce.Data {
+		e.EventData[k] = v
+	}
+
+	return e
+}
+
+// ServeHTTP handles events posted by custom sources, one JSON event per line
+func (s *customSourcesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != CustomSourcesEventsPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source := s.authenticate(r.Header.Get(api.AuthKeyHeader))
+	if source == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	res := CustomIngestion{Errors: make([]string, 0)}
+	sc := bufio.NewScanner(r.Body)
+	max := s.conf.MaxEventSize
+	if max <= 0 {
+		max = defaultCustomEventSize
+	}
+	sc.Buffer(make([]byte, 0, 4096), max)
+
+	for line := 1; sc.Scan(); line++ {
+		ce := CustomEvent{}
+
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(sc.Bytes(), &ce); err != nil {
+			res.Rejected++
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: %s", line, err))
+			continue
+		}
+
+		select {
+		case s.events <- s.toEvent(source, &ce):
+			res.Accepted++
+		case <-s.ctx.Done():
+			http.Error(w, "agent is stopping", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		res.Errors = append(res.Errors, fmt.Sprintf("failed to read events: %s", err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// authenticate returns the source authenticating with key
+func (s *customSourcesServer) authenticate(key string) *config.CustomSource {
+	for _, src := range s.conf.Sources {
+		if src.Key != "" && subtle.ConstantTimeCompare([]byte(src.Key), []byte(key)) == 1 {
+			return src
+		}
+	}
+	return nil
+}
+
+// mergeEvents merges custom sources' events into the events coming from the
+// event provider, returned channel is closed when events is closed
+func mergeEvents(events, custom chan *etw.Event) (c chan *etw.Event) {
+	c = make(chan *etw.Event)
+
+	go func() {
+		defer close(c)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				c <- e
+			case e := <-custom:
+				c <- e
+			}
+		}
+	}()
+
+	return
+}
+
+// startCustomSources starts the local ingestion API of custom sources and
+// returns the channel events are merged into
+func (a *Agent) startCustomSources(events chan *etw.Event) (c chan *etw.Event, err error) {
+	var l net.Listener
+
+	conf := &a.config.SourcesConfig
+	if err = validListenAddr(conf.Listen); err != nil {
+		return nil, fmt.Errorf("bad custom sources listening address: %w", err)
+	}
+
+	if l, err = net.Listen("tcp", conf.Listen); err != nil {
+		return nil, fmt.Errorf("custom sources failed to listen: %w", err)
+	}
+
+	s := newCustomSourcesServer(a.ctx, conf)
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			a.logger.Errorf("Custom sources server failed: %s", err)
+		}
+	}()
+
+	go func() {
+		<-a.ctx.Done()
+		srv.Close()
+	}()
+
+	a.logger.Infof("Custom sources ingestion API listening on %s", conf.Listen)
+
+	return mergeEvents(events, s.events), nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0xrawsec/whids/utils"
+	"github.com/0xrawsec/whids/utils/ese"
+	"github.com/0xrawsec/whids/utils/srum"
+)
+
+const (
+	// environment variables are expanded
+	srumPath = `${SystemRoot}\System32\sru\SRUDB.dat`
+)
+
+// SRUMReport is the result of SRUM database parsing
+type SRUMReport struct {
+	*srum.Report
+	// true if database has been read from a shadow copy
+	Snapshot bool `json:"snapshot"`
+	// path of the raw database artifact, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact,omitempty"`
+}
+
+// cmdSRUM parses SRUM database and returns network and resource usage of
+// applications over the period since. SRUM database is locked by the DPS
+// service so it is read from a shadow copy if needed. If retrieve is true
+// the raw database is uploaded to the manager as an artifact.
+func (a *Agent) cmdSRUM(since time.Duration, retrieve bool) (r *SRUMReport, err error) {
+	var db *ese.DB
+
+	path := os.ExpandEnv(srumPath)
+	src := path
+	group := fmt.Sprintf("{%s}", utils.UnsafeUUID())
+	r = &SRUMReport{}
+
+	if db, err = ese.Open(src); err != nil {
+		var s *ShadowCopy
+
+		if s, err = vssCreate(path); err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			if err := vssDelete(s.ID); err != nil {
+				a.logger.Errorf("failed to delete shadow copy %s: %s", s.ID, err)
+			}
+		}()
+
+		src = s.Path(path)
+		group = s.ID
+		r.Snapshot = true
+		if db, err = ese.Open(src); err != nil {
+			return nil, fmt.Errorf("failed to open SRUM database: %w", err)
+		}
+	}
+	defer db.Close()
+
+	// SRUM records older than a month are generally purged
+	from := time.Time{}
+	if since > 0 {
+		from = time.Now().Add(-since)
+	}
+
+	if r.Report, err = srum.Parse(db, from); err != nil {
+		return nil, fmt.Errorf("failed to parse SRUM database: %w", err)
+	}
+
+	if retrieve {
+		var f *os.File
+
+		if f, err = os.Open(src); err != nil {
+			return
+		}
+		defer f.Close()
+
+		if r.Artifact, _, err = a.collectArtifact(group, path, f); err != nil {
+			return nil, fmt.Errorf("failed to collect SRUM database: %w", err)
+		}
+	}
+
+	return
+}
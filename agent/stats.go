@@ -1,23 +1,40 @@
 package agent
 
 import (
+	"sync"
 	"time"
 
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/event"
 )
 
 var (
 	CriticalityFactor float64 = 2
 	MaxIssuesInARow   uint    = 10
+
+	// StatsFlushInterval interval at which statistics are persisted
+	StatsFlushInterval = time.Minute
 )
 
 type EventStats struct {
+	// stats may be updated by several pipeline workers
+	sync.Mutex
 	start   time.Time
 	counter struct {
 		channels  map[string]float64
+		rules     map[string]float64
 		event     float64
 		detection float64
-		dynamic   float64
+		// detections suppressed by maintenance windows
+		suppressed float64
+		dynamic    float64
+	}
+	// counters persisted across restarts
+	persisted struct {
+		since    time.Time
+		boots    int
+		counters api.StatsCounters
 	}
 	// for performance issue detection
 	row       uint
@@ -28,12 +45,17 @@ type EventStats struct {
 
 func NewEventStats(tresh float64, dur time.Duration) *EventStats {
 	now := time.Now()
-	return &EventStats{
+	s := &EventStats{
 		start:     now,
 		notified:  now,
 		threshold: tresh,
 		duration:  dur,
 	}
+	s.counter.channels = make(map[string]float64)
+	s.counter.rules = make(map[string]float64)
+	s.persisted.since = now
+	s.persisted.counters = api.NewStatsCounters()
+	return s
 }
 
 func (m *EventStats) SinceStart() time.Duration {
@@ -41,6 +63,8 @@ func (m *EventStats) SinceStart() time.Duration {
 }
 
 func (m *EventStats) Start() {
+	m.Lock()
+	defer m.Unlock()
 	m.start = time.Now()
 	m.notified = time.Now()
 }
@@ -54,22 +78,92 @@ func (m *EventStats) Duration() time.Duration {
 }
 
 func (m *EventStats) Update(e *event.EdrEvent) {
+	m.Lock()
+	defer m.Unlock()
 	m.counter.event++
 	m.counter.dynamic++
+	m.counter.channels[e.Channel()]++
 	if e.IsDetection() {
 		m.counter.detection++
+		if d := e.GetDetection(); d.Signature != nil {
+			for _, s := range d.Signature.Slice() {
+				m.counter.rules[s.(string)]++
+			}
+		}
 	}
 }
 
 func (m *EventStats) Events() float64 {
+	m.Lock()
+	defer m.Unlock()
 	return m.counter.event
 }
 
 func (m *EventStats) Detections() float64 {
+	m.Lock()
+	defer m.Unlock()
 	return m.counter.detection
 }
 
+// Suppress accounts for a detection suppressed by a maintenance window
+func (m *EventStats) Suppress() {
+	m.Lock()
+	defer m.Unlock()
+	m.counter.suppressed++
+}
+
+func (m *EventStats) Suppressed() float64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.counter.suppressed
+}
+
+// Restore restores counters persisted before agent restarted
+func (m *EventStats) Restore(s *api.AgentStats) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.persisted.since = s.Since
+	m.persisted.boots = s.Boots
+	m.persisted.counters = api.NewStatsCounters()
+	m.persisted.counters.Merge(&s.Cumulative)
+}
+
+func (m *EventStats) sinceBoot() (c api.StatsCounters) {
+	c = api.NewStatsCounters()
+	c.Events = int64(m.counter.event)
+	c.Detections = int64(m.counter.detection)
+	c.Suppressed = int64(m.counter.suppressed)
+	for channel, n := range m.counter.channels {
+		c.Channels[channel] = int64(n)
+	}
+	for rule, n := range m.counter.rules {
+		c.Rules[rule] = int64(n)
+	}
+	return
+}
+
+// Snapshot returns statistics since boot and cumulated across restarts
+func (m *EventStats) Snapshot() *api.AgentStats {
+	m.Lock()
+	defer m.Unlock()
+
+	s := &api.AgentStats{
+		Since:      m.persisted.since.UTC(),
+		Boot:       m.start.UTC(),
+		Boots:      m.persisted.boots + 1,
+		SinceBoot:  m.sinceBoot(),
+		Cumulative: api.NewStatsCounters(),
+	}
+	s.Cumulative.Merge(&m.persisted.counters)
+	s.Cumulative.Merge(&s.SinceBoot)
+
+	return s
+}
+
 func (m *EventStats) EPS() float64 {
+	m.Lock()
+	defer m.Unlock()
 	delta := time.Since(m.start).Seconds()
 	if delta > 0 {
 		return m.counter.event / delta
@@ -82,6 +176,12 @@ func (m *EventStats) CriticalEPS() float64 {
 }
 
 func (m *EventStats) DynEPS() float64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.dynEPS()
+}
+
+func (m *EventStats) dynEPS() float64 {
 	delta := time.Since(m.notified).Seconds()
 	if delta > 0 {
 		return m.counter.dynamic / delta
@@ -90,8 +190,11 @@ func (m *EventStats) DynEPS() float64 {
 }
 
 func (m *EventStats) HasPerfIssue() (bool, float64) {
-	eps := m.DynEPS()
-	if m.DynEPS() >= m.threshold {
+	m.Lock()
+	defer m.Unlock()
+
+	eps := m.dynEPS()
+	if eps >= m.threshold {
 		if time.Since(m.notified) > m.duration {
 			m.row++
 			m.notified = time.Now()
@@ -110,5 +213,39 @@ func (m *EventStats) HasPerfIssue() (bool, float64) {
 }
 
 func (m *EventStats) HasCriticalPerfIssue() bool {
+	m.Lock()
+	defer m.Unlock()
 	return m.row > uint(MaxIssuesInARow)
 }
+
+// restoreStats restores statistics persisted in local database
+func (a *Agent) restoreStats() (err error) {
+	var objs []sod.Object
+
+	if err = a.db.Create(&api.AgentStats{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	if objs, err = a.db.All(&api.AgentStats{}); err != nil {
+		return
+	}
+
+	if len(objs) > 0 {
+		a.stats.Restore(objs[0].(*api.AgentStats))
+	}
+
+	return
+}
+
+// saveStats persists statistics in local database
+func (a *Agent) saveStats() (err error) {
+	if err = a.db.DeleteAll(&api.AgentStats{}); err != nil {
+		return
+	}
+	return a.db.InsertOrUpdate(a.stats.Snapshot())
+}
+
+// Stats returns agent's statistics since boot and cumulated across restarts
+func (a *Agent) Stats() *api.AgentStats {
+	return a.stats.Snapshot()
+}
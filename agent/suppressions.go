@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+// Suppressions holds false positive suppressions pulled from manager
+type Suppressions struct {
+	sync.RWMutex
+	list   []*api.Suppression
+	sha256 string
+	// maximum number of suppressions applied
+	max int
+	// detections above this criticality are never suppressed
+	maxCrit int
+}
+
+// NewSuppressions creates a new Suppressions structure
+func NewSuppressions(max, maxCrit int) *Suppressions {
+	return &Suppressions{
+		list:    make([]*api.Suppression, 0),
+		max:     max,
+		maxCrit: maxCrit,
+	}
+}
+
+// Update updates suppressions, only the first max suppressions are kept
+func (s *Suppressions) Update(list []*api.Suppression, sha256 string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(list) > s.max {
+		list = list[:s.max]
+	}
+
+	s.list = list
+	s.sha256 = sha256
+}
+
+// Sha256 returns the sha256 of the suppressions as computed by manager
+func (s *Suppressions) Sha256() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.sha256
+}
+
+// Len returns the number of suppressions applied
+func (s *Suppressions) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.list)
+}
+
+// Suppress returns true if the detection is a known false positive
+func (s *Suppressions) Suppress(e *event.EdrEvent) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	d := e.GetDetection()
+	if d == nil || d.Criticality > s.maxCrit {
+		return false
+	}
+
+	for _, sup := range s.list {
+		if sup.Match(e) {
+			return true
+		}
+	}
+
+	return false
+}
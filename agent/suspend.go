@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+)
+
+const (
+	// ActionSuspend rule action and command suspending all the threads
+	// of a process, memory is preserved for forensic dumping
+	ActionSuspend = "suspend"
+)
+
+// suspendProcess suspends all the threads of a tracked process. Thread
+// suspension is counted so a process is never suspended twice by the agent.
+func (a *Agent) suspendProcess(t *ProcessTrack) error {
+	switch {
+	case t.Terminated:
+		return fmt.Errorf("process PID=%d is terminated", t.PID)
+	case t.PID == int64(os.Getpid()) || a.isSelfImage(t.Image):
+		return fmt.Errorf("refusing to suspend agent")
+	case isCriticalImage(t.Image):
+		return fmt.Errorf("refusing to suspend critical process %s", t.Image)
+	}
+
+	if a.suspended.Contains(t.ProcessGUID) {
+		return nil
+	}
+
+	kernel32.SuspendProcess(int(t.PID))
+	a.suspended.Add(t.ProcessGUID)
+	a.logger.Warnf("Suspended process PID=%d GUID=%s Image=%s", t.PID, t.ProcessGUID, t.Image)
+
+	return nil
+}
+
+// resumeProcess resumes a process suspended by the agent
+func (a *Agent) resumeProcess(t *ProcessTrack) error {
+	switch {
+	case t.Terminated:
+		return fmt.Errorf("process PID=%d is terminated", t.PID)
+	case !a.suspended.Contains(t.ProcessGUID):
+		return fmt.Errorf("process PID=%d has not been suspended by agent", t.PID)
+	}
+
+	kernel32.ResumeProcess(int(t.PID))
+	a.suspended.Del(t.ProcessGUID)
+	a.logger.Infof("Resumed process PID=%d GUID=%s Image=%s", t.PID, t.ProcessGUID, t.Image)
+
+	return nil
+}
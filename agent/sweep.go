@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/api/server"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	// SweepMaxFiles maximum number of files hashed during a sweep
+	SweepMaxFiles = 10000
+	// SweepMaxFileSize files bigger than this are not hashed
+	SweepMaxFileSize = int64(100 * utils.Mega)
+
+	// common temp and staging locations swept, environment
+	// variables are expanded and globs resolved
+	sweepLocations = []string{
+		`${SystemRoot}\Temp`,
+		`${SystemDrive}\Users\*\AppData\Local\Temp`,
+		`${SystemDrive}\Users\Public`,
+		`${SystemDrive}\PerfLogs`,
+		`${ProgramData}`,
+	}
+)
+
+// SweepHit is a file whose hash is found in IOC containers
+type SweepHit struct {
+	FileInfo
+	Containers []string `json:"containers"`
+	Artifact   string   `json:"artifact,omitempty"`
+}
+
+// RecycleBinItem is a file found in the Recycle Bin
+type RecycleBinItem struct {
+	utils.RecycleBinIndex
+	// path of the $I file
+	Index string `json:"index"`
+	// path of the $R file holding deleted file content
+	Data     string `json:"data"`
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SweepReport is the result of a sweep
+type SweepReport struct {
+	// identifier of the sweep, artifacts are grouped by sweep
+	ID         string            `json:"id"`
+	RecycleBin []*RecycleBinItem `json:"recycle-bin"`
+	Hits       []*SweepHit       `json:"hits"`
+	Scanned    int               `json:"scanned"`
+	// true if the maximum number of files to scan was reached
+	Truncated bool `json:"truncated"`
+}
+
+// sweepIoCs returns IOC values mapped to the containers they are in
+func (a *Agent) sweepIoCs() (iocs map[string][]string, err error) {
+	var objs []sod.Object
+
+	iocs = make(map[string][]string)
+	for _, v := range a.iocs.StringSlice() {
+		iocs[v] = append(iocs[v], server.IoCContainerName)
+	}
+
+	// containers managed by the manager
+	if objs, err = a.db.All(&api.EdrContainer{}); err != nil {
+		return
+	}
+
+	for _, o := range objs {
+		c := o.(*api.EdrContainer)
+		for _, v := range c.Entries {
+			v = strings.ToLower(v)
+			iocs[v] = append(iocs[v], c.Name)
+		}
+	}
+
+	return
+}
+
+// sweepMatch hashes a file and returns the containers it is found in
+func sweepMatch(fi *FileInfo, iocs map[string][]string, c *HashCache) (containers []string) {
+	if fi.Size > SweepMaxFileSize {
+		return
+	}
+
+	if err := fi.Hash(c); err != nil {
+		fi.Err = err
+		return
+	}
+
+	for _, h := range fi.Hashes {
+		containers = append(containers, iocs[h]...)
+	}
+
+	return
+}
+
+// sweepRecycleBin enumerates Recycle Bin items of all users
+func sweepRecycleBin(drive string) (items []*RecycleBinItem) {
+	items = make([]*RecycleBinItem, 0)
+
+	indexes, _ := filepath.Glob(filepath.Join(drive+`\`, `$Recycle.Bin`, "*", `$I*`))
+	for _, index := range indexes {
+		item := &RecycleBinItem{
+			Index: index,
+			Data:  filepath.Join(filepath.Dir(index), "$R"+strings.TrimPrefix(filepath.Base(index), "$I")),
+		}
+		items = append(items, item)
+
+		if b, err := os.ReadFile(index); err != nil {
+			item.Error = err.Error()
+		} else if idx, err := utils.ParseRecycleBinIndex(b); err != nil {
+			item.Error = err.Error()
+		} else {
+			item.RecycleBinIndex = *idx
+		}
+	}
+
+	return
+}
+
+// sweepCollect collects a file as an artifact of a sweep
+func (a *Agent) sweepCollect(id, path string) (artifact string, err error) {
+	var f *os.File
+
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	artifact, _, err = a.collectArtifact(id, path, f)
+	return
+}
+
+// cmdSweep enumerates Recycle Bin items and temp/staging locations looking for
+// files found in IOC containers. If retrieve is true, Recycle Bin metadata and
+// files matching IOCs are uploaded to the manager as artifacts.
+func (a *Agent) cmdSweep(retrieve bool) (r *SweepReport, err error) {
+	var iocs map[string][]string
+
+	if iocs, err = a.sweepIoCs(); err != nil {
+		return nil, fmt.Errorf("failed to load IOCs: %w", err)
+	}
+
+	r = &SweepReport{
+		ID:   fmt.Sprintf("{%s}", utils.UnsafeUUID()),
+		Hits: make([]*SweepHit, 0),
+	}
+
+	drive := os.Getenv("SystemDrive")
+
+	hit := func(fi FileInfo) *SweepHit {
+		r.Scanned++
+		if containers := sweepMatch(&fi, iocs, a.hashCache); len(containers) > 0 {
+			h := &SweepHit{FileInfo: fi, Containers: containers}
+			r.Hits = append(r.Hits, h)
+			return h
+		}
+		return nil
+	}
+
+	// Recycle Bin
+	r.RecycleBin = sweepRecycleBin(drive)
+	for _, item := range r.RecycleBin {
+		// $R may be a directory if a directory was deleted
+		if st, err := os.Stat(item.Data); err == nil && st.Mode().IsRegular() {
+			fi := FileInfo{Dir: filepath.Dir(item.Data)}
+			fi.FromFSFileInfo(st)
+			if h := hit(fi); h != nil && retrieve {
+				if h.Artifact, err = a.sweepCollect(r.ID, item.Data); err != nil {
+					a.logger.Errorf("failed to collect %s: %s", item.Data, err)
+				}
+			}
+		}
+
+		if retrieve && item.Error == "" {
+			if item.Artifact, err = a.sweepCollect(r.ID, item.Index); err != nil {
+				item.Error = err.Error()
+			}
+		}
+	}
+	err = nil
+
+	// temp and staging locations
+	for _, loc := range sweepLocations {
+		dirs, _ := filepath.Glob(os.ExpandEnv(loc))
+		for _, dir := range dirs {
+			for wi := range fswalker.Walk(dir) {
+				for _, fsfi := range wi.Files {
+					if r.Scanned >= SweepMaxFiles {
+						r.Truncated = true
+						return
+					}
+
+					fi := FileInfo{Dir: wi.Dirpath}
+					fi.FromFSFileInfo(fsfi)
+					if h := hit(fi); h != nil && retrieve {
+						if h.Artifact, err = a.sweepCollect(r.ID, fi.Path()); err != nil {
+							a.logger.Errorf("failed to collect %s: %s", fi.Path(), err)
+						}
+						err = nil
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
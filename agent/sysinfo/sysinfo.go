@@ -14,12 +14,69 @@ var (
 type EdrInfo struct {
 	Version string `json:"version"`
 	Commit  string `json:"commit"`
+	// architecture the EDR is built for
+	Arch string `json:"arch"`
+	// features compiled in (full or lite)
+	Edition string `json:"edition,omitempty"`
 }
 
 func RegisterEdrInfo(i *EdrInfo) {
 	edrInfo = i
 }
 
+// RegisteredEdrInfo returns the EDR information registered by main
+// package, nil if none was registered
+func RegisteredEdrInfo() *EdrInfo {
+	return edrInfo
+}
+
+// Telemetry describes the telemetry sources missing on the
+// endpoint and the fallback sources enabled to compensate them
+type Telemetry struct {
+	Missing   []string `json:"missing,omitempty"`
+	Fallbacks []string `json:"fallbacks,omitempty"`
+}
+
+// FirewallProfile is the state of a Windows Firewall profile
+type FirewallProfile struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// default actions (allow or block)
+	DefaultInbound  string `json:"default-inbound"`
+	DefaultOutbound string `json:"default-outbound"`
+}
+
+// FirewallRule is an enabled Windows Firewall allow rule
+type FirewallRule struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Direction   string `json:"direction"`
+	Protocol    string `json:"protocol,omitempty"`
+	LocalPorts  string `json:"local-ports,omitempty"`
+	RemotePorts string `json:"remote-ports,omitempty"`
+	RemoteAddrs string `json:"remote-addrs,omitempty"`
+	Application string `json:"application,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Profiles    string `json:"profiles,omitempty"`
+	// local or group policy rule
+	Policy string `json:"policy"`
+}
+
+// Firewall describes the state of the host firewall
+type Firewall struct {
+	Profiles []*FirewallProfile `json:"profiles"`
+	Rules    []*FirewallRule    `json:"rules"`
+}
+
+// ListeningPort is a TCP or UDP port a process listens on
+type ListeningPort struct {
+	Protocol string `json:"protocol"`
+	Addr     string `json:"addr"`
+	Port     uint16 `json:"port"`
+	PID      int    `json:"pid"`
+	Image    string `json:"image"`
+}
+
 type SystemInfo struct {
 	Edr *EdrInfo `json:"edr"`
 
@@ -51,6 +108,8 @@ type SystemInfo struct {
 		Product string `json:"product"`
 		// CompositionEditionID
 		Edition string `json:"edition"`
+		// native architecture of the system
+		Arch string `json:"arch"`
 	} `json:"os"`
 
 	CPU struct {
@@ -62,6 +121,11 @@ type SystemInfo struct {
 
 	Sysmon *sysmon.Info `json:"sysmon"`
 
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+
+	Firewall       *Firewall        `json:"firewall,omitempty"`
+	ListeningPorts []*ListeningPort `json:"listening-ports,omitempty"`
+
 	Error string `json:"error"`
 }
 
@@ -50,6 +50,7 @@ func NewSystemInfo() (info *SystemInfo) {
 	info.OS.Version = version
 	info.OS.Product = utils.RegValueToString(pathBuildInfo, "ProductName")
 	info.OS.Edition = utils.RegValueToString(pathBuildInfo, "CompositionEditionID")
+	info.OS.Arch = utils.NativeArch()
 
 	info.CPU.Name = utils.RegValueToString(pathProcInfo, "0", "ProcessorNameString")
 	// counting the number of processors
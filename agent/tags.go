@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
+)
+
+// HostTag is a tag set on the endpoint with tag commands (i.e. under-investigation),
+// tags are persisted across restarts and reported to the manager with every request
+type HostTag struct {
+	sod.Item
+	Name string `json:"name" sod:"unique"`
+}
+
+// normalizeTags normalizes and validates tags
+func normalizeTags(tags []string) (out []string, err error) {
+	out = make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t, err = api.NormalizeTag(t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return
+}
+
+// Tags returns the tags set on the endpoint, sorted
+func (a *Agent) Tags() (tags []string, err error) {
+	var objs []sod.Object
+
+	if objs, err = a.db.All(&HostTag{}); err != nil {
+		return
+	}
+
+	tags = make([]string, 0, len(objs))
+	for _, o := range objs {
+		tags = append(tags, o.(*HostTag).Name)
+	}
+	sort.Strings(tags)
+
+	return
+}
+
+// saveTags replaces the tags set on the endpoint and
+// makes them reported to the manager
+func (a *Agent) saveTags(set map[string]bool) (tags []string, err error) {
+	objs := make([]*HostTag, 0, len(set))
+	for t := range set {
+		objs = append(objs, &HostTag{Name: t})
+	}
+
+	if err = a.db.DeleteAll(&HostTag{}); err != nil {
+		return
+	}
+
+	if _, err = a.db.InsertOrUpdateMany(sod.ToObjectSlice(objs)...); err != nil {
+		return
+	}
+
+	if tags, err = a.Tags(); err != nil {
+		return
+	}
+
+	a.reportTags(tags)
+
+	return
+}
+
+// reportTags makes tags reported to the manager along with every request
+func (a *Agent) reportTags(tags []string) {
+	if a.forwarder != nil && a.forwarder.Client != nil {
+		a.forwarder.Client.SetTags(tags)
+	}
+}
+
+// initTags restores the tags set by previous runs
+func (a *Agent) initTags() (err error) {
+	var tags []string
+
+	if tags, err = a.Tags(); err != nil {
+		return fmt.Errorf("failed to load tags: %w", err)
+	}
+
+	a.reportTags(tags)
+
+	return
+}
+
+// updateTags adds and removes tags set on the endpoint, it returns the
+// resulting tags. Tags are validated before any modification is made.
+func (a *Agent) updateTags(add, del []string) (tags []string, err error) {
+	set := make(map[string]bool)
+
+	if add, err = normalizeTags(add); err != nil {
+		return
+	}
+
+	if del, err = normalizeTags(del); err != nil {
+		return
+	}
+
+	if tags, err = a.Tags(); err != nil {
+		return
+	}
+
+	for _, t := range tags {
+		set[t] = true
+	}
+
+	for _, t := range add {
+		set[t] = true
+	}
+
+	for _, t := range del {
+		delete(set, t)
+	}
+
+	return a.saveTags(set)
+}
@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// TapAnyChannel channel name used to tap events of all channels
+	TapAnyChannel = "all"
+)
+
+var (
+	// TapDefaultDuration duration of a tap if none is specified
+	TapDefaultDuration = 5 * time.Minute
+	// TapMaxDuration maximum duration of a tap, so that
+	// it cannot be forgotten turned on
+	TapMaxDuration = time.Hour
+	// TapMaxRate maximum number of events tapped per second
+	TapMaxRate = 50
+	// TapFlushInterval interval at which tapped events are sent to the manager
+	TapFlushInterval = 2 * time.Second
+)
+
+// TapStatus is the status of the live event tap
+type TapStatus struct {
+	Channel string    `json:"channel"`
+	Image   string    `json:"image,omitempty"`
+	Until   time.Time `json:"until"`
+	Rate    int       `json:"max-rate"`
+}
+
+// eventTap streams raw events (before any filtering) to the manager
+type eventTap struct {
+	channel string
+	image   *regexp.Regexp
+	until   time.Time
+	running bool
+	// rate limiting
+	window  int64
+	count   int
+	dropped int
+	buffer  bytes.Buffer
+}
+
+func (t *eventTap) active(now time.Time) bool {
+	return now.Before(t.until)
+}
+
+func (t *eventTap) match(e *event.EdrEvent) bool {
+	if t.channel != TapAnyChannel && !strings.EqualFold(t.channel, e.Channel()) {
+		return false
+	}
+
+	if t.image != nil {
+		image, ok := e.GetString(pathSysmonImage)
+		return ok && t.image.MatchString(image)
+	}
+
+	return true
+}
+
+// startTap turns on live event tap of events from channel (and process image
+// matching image regex if not empty) for a given duration
+func (a *Agent) startTap(channel, image string, d time.Duration) (s TapStatus, err error) {
+	var re *regexp.Regexp
+
+	if a.forwarder == nil || a.config.FwdConfig.Local {
+		return s, fmt.Errorf("cannot tap events without a manager")
+	}
+
+	if d <= 0 || d > TapMaxDuration {
+		return s, fmt.Errorf("tap duration must be in ]0, %s]", TapMaxDuration)
+	}
+
+	if image != "" {
+		if re, err = regexp.Compile(image); err != nil {
+			return s, fmt.Errorf("bad image regex: %w", err)
+		}
+	}
+
+	a.tap.Lock()
+	defer a.tap.Unlock()
+
+	a.tap.channel = channel
+	a.tap.image = re
+	a.tap.until = time.Now().Add(d)
+
+	if !a.tap.running {
+		a.tap.running = true
+		go a.runTap()
+	}
+
+	return TapStatus{channel, image, a.tap.until.UTC(), TapMaxRate}, nil
+}
+
+// stopTap turns off live event tap
+func (a *Agent) stopTap() {
+	a.tap.Lock()
+	defer a.tap.Unlock()
+	a.tap.until = time.Time{}
+}
+
+// tapEvent sends event to the manager if it is tapped
+func (a *Agent) tapEvent(e *event.EdrEvent) {
+	a.tap.Lock()
+	defer a.tap.Unlock()
+
+	now := time.Now()
+	if !a.tap.active(now) || !a.tap.match(e) {
+		return
+	}
+
+	if sec := now.Unix(); sec != a.tap.window {
+		a.tap.window = sec
+		a.tap.count = 0
+	}
+
+	if a.tap.count >= TapMaxRate {
+		a.tap.dropped++
+		return
+	}
+	a.tap.count++
+
+	// event is serialized right away as it is modified further in the pipeline
+	if b, err := json.Marshal(e); err != nil {
+		a.logger.Errorf("failed to serialize tapped event: %s", err)
+	} else {
+		a.tap.buffer.Write(b)
+		a.tap.buffer.WriteByte('\n')
+	}
+}
+
+// runTap periodically sends tapped events to the manager until tap expires
+func (a *Agent) runTap() {
+	for {
+		time.Sleep(TapFlushInterval)
+
+		a.tap.Lock()
+		data := make([]byte, a.tap.buffer.Len())
+		copy(data, a.tap.buffer.Bytes())
+		a.tap.buffer.Reset()
+		active := a.tap.active(time.Now())
+		if !active {
+			a.tap.running = false
+			if a.tap.dropped > 0 {
+				a.logger.Infof("live event tap dropped %d events exceeding rate", a.tap.dropped)
+			}
+			a.tap.dropped = 0
+		}
+		a.tap.Unlock()
+
+		if len(data) > 0 {
+			if err := a.forwarder.Client.PostTap(bytes.NewReader(data)); err != nil {
+				a.logger.Errorf("failed to send tapped events: %s", err)
+			}
+		}
+
+		if !active {
+			a.logger.Info("live event tap terminated")
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	TaskActionExec       = "exec"
+	TaskActionComHandler = "com-handler"
+)
+
+// taskDefinition is the part of a scheduled task XML definition we are interested in
+type taskDefinition struct {
+	RegistrationInfo struct {
+		Author string `xml:"Author"`
+	} `xml:"RegistrationInfo"`
+	Triggers struct {
+		Triggers []struct {
+			XMLName xml.Name
+			Enabled string `xml:"Enabled"`
+		} `xml:",any"`
+	} `xml:"Triggers"`
+	Principals struct {
+		Principal []struct {
+			UserId  string `xml:"UserId"`
+			GroupId string `xml:"GroupId"`
+		} `xml:"Principal"`
+	} `xml:"Principals"`
+	Settings struct {
+		Enabled string `xml:"Enabled"`
+		Hidden  string `xml:"Hidden"`
+	} `xml:"Settings"`
+	Actions struct {
+		Exec []struct {
+			Command   string `xml:"Command"`
+			Arguments string `xml:"Arguments"`
+		} `xml:"Exec"`
+		ComHandler []struct {
+			ClassId string `xml:"ClassId"`
+			Data    string `xml:"Data"`
+		} `xml:"ComHandler"`
+	} `xml:"Actions"`
+}
+
+// TaskAction is an action run by a scheduled task
+type TaskAction struct {
+	Type string `json:"type"`
+	// command or COM class ID
+	Command   string            `json:"command"`
+	Arguments string            `json:"arguments,omitempty"`
+	Image     string            `json:"image,omitempty"`
+	Hashes    map[string]string `json:"hashes,omitempty"`
+	Signer    *ImageSigner      `json:"signer,omitempty"`
+}
+
+// CommandLine returns the command line of an exec action
+func (a *TaskAction) CommandLine() string {
+	return strings.TrimSpace(a.Command + " " + a.Arguments)
+}
+
+// ScheduledTask is a scheduled task registered on the system
+type ScheduledTask struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+	Hidden  bool   `json:"hidden"`
+	Author  string `json:"author,omitempty"`
+	RunAs   string `json:"run-as,omitempty"`
+	// kinds of enabled triggers (i.e. LogonTrigger, BootTrigger ...)
+	Triggers []string      `json:"triggers"`
+	Actions  []*TaskAction `json:"actions"`
+}
+
+// ScheduledTasks holds the result of the enumeration of scheduled tasks
+type ScheduledTasks struct {
+	Tasks []*ScheduledTask `json:"tasks"`
+	// tasks which failed to be parsed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// xmlBool parses a boolean of a task definition, def is returned if s is empty
+func xmlBool(s string, def bool) bool {
+	if s = strings.TrimSpace(s); s == "" {
+		return def
+	}
+	return strings.EqualFold(s, "true") || s == "1"
+}
+
+// parseTask parses a scheduled task XML definition, as stored in System32\Tasks (UTF-16 encoded)
+func parseTask(data []byte) (t *ScheduledTask, err error) {
+	var def taskDefinition
+
+	// UTF-16 little endian with BOM
+	if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+		u16 := make([]uint16, (len(data)-2)/2)
+		binary.Read(bytes.NewReader(data[2:]), binary.LittleEndian, u16)
+		data = []byte(string(utf16.Decode(u16)))
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// content has already been converted to UTF-8
+	dec.CharsetReader = func(label string, r io.Reader) (io.Reader, error) { return r, nil }
+
+	if err = dec.Decode(&def); err != nil {
+		return
+	}
+
+	t = &ScheduledTask{
+		Enabled:  xmlBool(def.Settings.Enabled, true),
+		Hidden:   xmlBool(def.Settings.Hidden, false),
+		Author:   def.RegistrationInfo.Author,
+		Triggers: make([]string, 0),
+		Actions:  make([]*TaskAction, 0),
+	}
+
+	for _, p := range def.Principals.Principal {
+		if t.RunAs = p.UserId; t.RunAs == "" {
+			t.RunAs = p.GroupId
+		}
+		break
+	}
+
+	for _, tr := range def.Triggers.Triggers {
+		if xmlBool(tr.Enabled, true) {
+			t.Triggers = append(t.Triggers, tr.XMLName.Local)
+		}
+	}
+
+	for _, e := range def.Actions.Exec {
+		t.Actions = append(t.Actions, &TaskAction{
+			Type:      TaskActionExec,
+			Command:   strings.TrimSpace(e.Command),
+			Arguments: strings.TrimSpace(e.Arguments),
+		})
+	}
+
+	for _, c := range def.Actions.ComHandler {
+		t.Actions = append(t.Actions, &TaskAction{
+			Type:      TaskActionComHandler,
+			Command:   strings.TrimSpace(c.ClassId),
+			Arguments: strings.TrimSpace(c.Data),
+		})
+	}
+
+	return
+}
+
+// comServerImage returns the DLL implementing a COM class
+func comServerImage(clsid string) string {
+	if v, err := utils.RegValue(utils.RegJoin(pathCLSID, clsid, "InprocServer32") + `\`); err == nil {
+		return normalizeAutorunPath(fmt.Sprintf("%v", v))
+	}
+	return ""
+}
+
+// cmdTasks lists scheduled tasks registered on the system, images
+// run by tasks' actions are resolved, hashed and their signer looked up
+func (a *Agent) cmdTasks() *ScheduledTasks {
+	st := &ScheduledTasks{Tasks: make([]*ScheduledTask, 0)}
+	root := filepath.Join(os.Getenv("SystemRoot"), "System32", "Tasks")
+
+	for wi := range fswalker.Walk(root) {
+		if wi.Err != nil {
+			st.Errors = append(st.Errors, fmt.Sprintf("failed to walk %s: %s", wi.Dirpath, wi.Err))
+			continue
+		}
+
+		for _, fi := range wi.Files {
+			path := filepath.Join(wi.Dirpath, fi.Name())
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				st.Errors = append(st.Errors, fmt.Sprintf("failed to read task %s: %s", path, err))
+				continue
+			}
+
+			t, err := parseTask(data)
+			if err != nil {
+				st.Errors = append(st.Errors, fmt.Sprintf("failed to parse task %s: %s", path, err))
+				continue
+			}
+
+			t.Name = fi.Name()
+			t.Path = strings.TrimPrefix(path, root)
+
+			for _, action := range t.Actions {
+				switch action.Type {
+				case TaskActionExec:
+					action.Image = autorunImage(action.CommandLine(), fsutil.IsFile)
+				case TaskActionComHandler:
+					action.Image = comServerImage(action.Command)
+				}
+
+				if action.Image != "" {
+					action.Hashes, _ = a.hashCache.Hashes(action.Image)
+					action.Signer = a.tracker.ImageSigner(action.Image)
+				}
+			}
+
+			st.Tasks = append(st.Tasks, t)
+		}
+	}
+
+	sort.Slice(st.Tasks, func(i, j int) bool {
+		return st.Tasks[i].Path < st.Tasks[j].Path
+	})
+
+	return st
+}
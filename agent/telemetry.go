@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/sysmon"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// TelemetrySysmon Sysmon events
+	TelemetrySysmon = "sysmon"
+	// TelemetryProcessAudit process creation/termination audit events
+	TelemetryProcessAudit = "process-audit"
+
+	securityTraceName = "Eventlog-Security"
+)
+
+var (
+	// audit policies generating Security 4688/4689 events
+	processAuditPolicies = []string{
+		"Process Creation",
+		"Process Termination",
+	}
+
+	// capability detection and fallback enablement, replaced in tests
+	detectSysmon = func() error {
+		_, err := sysmon.NewSysmonInfo()
+		return err
+	}
+	enableFallbackAudit = enableProcessAudit
+)
+
+// enableProcessAudit enables the audit policies needed to get
+// process creation/termination events with command line
+func enableProcessAudit() error {
+	for _, ap := range processAuditPolicies {
+		if err := utils.EnableAuditPolicy(ap); err != nil {
+			return fmt.Errorf("failed to enable audit policy %s: %w", ap, err)
+		}
+	}
+
+	if err := utils.EnableProcessCmdLineAudit(); err != nil {
+		return fmt.Errorf("failed to enable command line auditing: %w", err)
+	}
+
+	return nil
+}
+
+// detectTelemetry detects the telemetry sources missing on the endpoint,
+// reports them to the manager and enables fallback sources if configured
+func (a *Agent) detectTelemetry() {
+	a.telemetry = &sysinfo.Telemetry{}
+
+	if err := detectSysmon(); !errors.Is(err, sysmon.ErrSysmonNotInstalled) {
+		return
+	}
+
+	a.logger.Warn("Sysmon is not installed, most of the detection capabilities are degraded")
+	a.telemetry.Missing = append(a.telemetry.Missing, TelemetrySysmon)
+	a.reportError(api.AgentErrorTelemetry, TelemetrySysmon, sysmon.ErrSysmonNotInstalled)
+
+	if !a.config.Sysmon.Fallback {
+		return
+	}
+
+	if err := enableFallbackAudit(); err != nil {
+		a.logger.Errorf("Failed to enable process auditing fallback: %s", err)
+		a.reportError(api.AgentErrorTelemetry, TelemetryProcessAudit, err)
+		return
+	}
+
+	a.logger.Info("Enabled process auditing as Sysmon fallback")
+	a.telemetry.Fallbacks = append(a.telemetry.Fallbacks, TelemetryProcessAudit)
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// telemetryMissing returns true if telemetry source is missing on the endpoint
+func (a *Agent) telemetryMissing(source string) bool {
+	return a.telemetry != nil && containsString(a.telemetry.Missing, source)
+}
+
+// fallbackEnabled returns true if fallback telemetry source is enabled
+func (a *Agent) fallbackEnabled(source string) bool {
+	return a.telemetry != nil && containsString(a.telemetry.Fallbacks, source)
+}
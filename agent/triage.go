@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	mftPathRe = regexp.MustCompile(`(?i:^([a-z]:)\\\$MFT$)`)
+)
+
+// TriageFile is a file archived by collect command
+type TriageFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TriageArchive is the archive of the files of a collection target
+type TriageArchive struct {
+	Target string        `json:"target"`
+	Files  []*TriageFile `json:"files"`
+	// number of bytes archived
+	Size int64 `json:"size"`
+	// path of the archive, relative to endpoint's artifacts directory
+	Artifact string `json:"artifact,omitempty"`
+	Sha256   string `json:"sha256,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TriageCollection is the result of collect command
+type TriageCollection struct {
+	// ID of the snapshot files are read from
+	Snapshot string           `json:"snapshot,omitempty"`
+	Archives []*TriageArchive `json:"archives"`
+	Errors   []string         `json:"errors,omitempty"`
+}
+
+// triageArchiveName returns the name of a file in a triage archive,
+// files are organized by drive (i.e. C:\Windows\x -> C/Windows/x)
+func triageArchiveName(path string) string {
+	return strings.TrimLeft(strings.ReplaceAll(strings.Replace(path, ":", "", 1), `\`, "/"), "/")
+}
+
+// triageSource returns the path files are read from, the one in the
+// snapshot if the file is located on the snapshot volume
+func triageSource(s *ShadowCopy, path string) string {
+	if s != nil && strings.EqualFold(filepath.VolumeName(path), filepath.VolumeName(s.Volume)) {
+		return s.Path(path)
+	}
+	return path
+}
+
+// triageExpand expands the paths of a target into the list of files to collect
+func triageExpand(s *ShadowCopy, patterns []string) (paths []string) {
+	seen := make(map[string]bool)
+	paths = make([]string, 0)
+
+	add := func(p string) {
+		if !seen[strings.ToLower(p)] {
+			seen[strings.ToLower(p)] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, p := range utils.ExpandKnownEnvs(patterns...) {
+		if mftPathRe.MatchString(p) || !strings.Contains(p, "*") {
+			add(p)
+			continue
+		}
+
+		src := triageSource(s, p)
+		matches, _ := filepath.Glob(src)
+		for _, m := range matches {
+			if src != p {
+				m = filepath.Join(s.Volume, strings.TrimPrefix(m, s.Device))
+			}
+			add(m)
+		}
+	}
+
+	return
+}
+
+// archiveFile adds a file to a triage archive if it does not make the archive
+// grow above max bytes, it returns the number of bytes archived
+func (a *Agent) archiveFile(zw *zip.Writer, s *ShadowCopy, f *TriageFile, max int64) (n int64, err error) {
+	var w io.Writer
+	var hdr *zip.FileHeader
+	var copyTo func(io.Writer) (int64, error)
+
+	if sub := mftPathRe.FindStringSubmatch(f.Path); sub != nil {
+		var m *rawMFT
+
+		if m, err = openRawMFT(sub[1]); err != nil {
+			return
+		}
+		defer m.Close()
+
+		f.Size = m.Size
+		copyTo = m.WriteTo
+		hdr = &zip.FileHeader{Modified: time.Now()}
+	} else {
+		var fd *os.File
+		var fi os.FileInfo
+
+		if fd, err = os.Open(triageSource(s, f.Path)); err != nil {
+			return
+		}
+		defer fd.Close()
+
+		if fi, err = fd.Stat(); err != nil {
+			return
+		}
+
+		if !fi.Mode().IsRegular() {
+			return 0, fmt.Errorf("not a regular file")
+		}
+
+		f.Size = fi.Size()
+		copyTo = func(w io.Writer) (int64, error) { return io.Copy(w, fd) }
+		if hdr, err = zip.FileInfoHeader(fi); err != nil {
+			return
+		}
+	}
+
+	if f.Size > max {
+		return 0, fmt.Errorf("archive size limit reached")
+	}
+
+	hdr.Name = triageArchiveName(f.Path)
+	// archive is compressed when collected as an artifact
+	hdr.Method = zip.Store
+	if w, err = zw.CreateHeader(hdr); err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	n, err = copyTo(io.MultiWriter(w, hash))
+	if err == nil {
+		f.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	return
+}
+
+// archiveTarget archives the files of a target and collects the archive
+func (a *Agent) archiveTarget(group string, s *ShadowCopy, t *config.CollectTarget, max int64) (ar *TriageArchive) {
+	var err error
+
+	ar = &TriageArchive{Target: t.Name, Files: make([]*TriageFile, 0)}
+
+	paths := triageExpand(s, t.Paths)
+	if len(paths) == 0 {
+		ar.Error = "no file matching target"
+		return
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan bool)
+
+	go func() {
+		defer close(done)
+
+		zw := zip.NewWriter(pw)
+		for _, path := range paths {
+			f := &TriageFile{Path: path}
+			ar.Files = append(ar.Files, f)
+
+			n, err := a.archiveFile(zw, s, f, max-ar.Size)
+			ar.Size += n
+			if err != nil {
+				f.Error = err.Error()
+			}
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+
+	ar.Artifact, ar.Sha256, err = a.collectArtifact(group, t.Name+".zip", pr)
+	// unblocks archiving routine if collection failed
+	pr.CloseWithError(err)
+	<-done
+
+	if err != nil {
+		ar.Error = fmt.Sprintf("failed to collect archive: %s", err)
+	}
+
+	return
+}
+
+// cmdCollect collects the files of triage targets (all targets configured by
+// default) from a temporary VSS snapshot of system drive. Files are archived by
+// target and archives are uploaded to the manager as endpoint artifacts.
+func (a *Agent) cmdCollect(names []string) (c *TriageCollection, err error) {
+	var s *ShadowCopy
+
+	conf := a.config.CollectConfig
+	targets := conf.Targets
+
+	if len(names) > 0 {
+		targets = make([]*config.CollectTarget, 0, len(names))
+		for _, name := range names {
+			t := conf.Target(name)
+			if t == nil {
+				return nil, fmt.Errorf("unknown target %s", name)
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no target to collect")
+	}
+
+	// archives are uploaded as a whole
+	max := conf.MaxSize
+	if m := a.config.FwdConfig.Client.MaxUploadSize; m < max {
+		max = m
+	}
+
+	start := time.Now()
+	c = &TriageCollection{Archives: make([]*TriageArchive, 0, len(targets))}
+
+	// locked files cannot be read from live system
+	if s, err = vssCreate(os.Getenv("SystemDrive")); err != nil {
+		c.Errors = append(c.Errors, fmt.Sprintf("files are read from live system: %s", err))
+		s = nil
+	} else {
+		c.Snapshot = s.ID
+		defer func() {
+			if err := vssDelete(s.ID); err != nil {
+				a.logger.Errorf("failed to delete shadow copy %s: %s", s.ID, err)
+			}
+		}()
+	}
+
+	group := fmt.Sprintf("{%s}", utils.UnsafeUUID())
+	for _, t := range targets {
+		c.Archives = append(c.Archives, a.archiveTarget(group, s, t, max))
+	}
+
+	a.logger.Infof("collected %d triage targets in %s", len(targets), time.Since(start))
+
+	return c, nil
+}
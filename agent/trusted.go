@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// signature status of an image with a valid signature
+	validSignatureStatus = "Valid"
+)
+
+// TrustedProcesses holds trusted processes pulled from manager
+type TrustedProcesses struct {
+	sync.RWMutex
+	list   []*api.TrustedProcess
+	sha256 string
+}
+
+// NewTrustedProcesses creates a new TrustedProcesses structure
+func NewTrustedProcesses() *TrustedProcesses {
+	return &TrustedProcesses{
+		list: make([]*api.TrustedProcess, 0),
+	}
+}
+
+// Update updates trusted processes
+func (t *TrustedProcesses) Update(list []*api.TrustedProcess, sha256 string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.list = list
+	t.sha256 = sha256
+}
+
+// Sha256 returns the sha256 of the trusted processes as computed by manager
+func (t *TrustedProcesses) Sha256() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.sha256
+}
+
+// Len returns the number of trusted processes
+func (t *TrustedProcesses) Len() int {
+	t.RLock()
+	defer t.RUnlock()
+	return len(t.list)
+}
+
+// Trusted returns true if the process is trusted, the image of the process
+// must have a valid signature to be trusted
+func (t *TrustedProcesses) Trusted(pt *ProcessTrack) bool {
+	if pt.IsZero() || !pt.Signed || pt.SignatureStatus != validSignatureStatus {
+		return false
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, tp := range t.list {
+		if tp.Match(pt.Image, pt.Signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustedSource returns true if the source process of the event is trusted
+func (a *Agent) trustedSource(e *event.EdrEvent) bool {
+	return a.trusted.Trusted(a.tracker.SourceTrackFromEvent(e))
+}
+
+// returns true if trusted processes need to be updated
+func (a *Agent) needsTrustedUpdate() bool {
+	var remoteSha256 string
+	var err error
+
+	// Don't need update if not connected to a manager
+	if !a.config.IsForwardingEnabled() {
+		return false
+	}
+
+	if remoteSha256, err = a.forwarder.Client.GetTrustedProcessesSha256(); err != nil {
+		a.logger.Errorf("Failed to fetch trusted processes sha256: %s", err)
+		return false
+	}
+
+	return a.trusted.Sha256() != remoteSha256
+}
+
+func (a *Agent) fetchTrustedFromManager() (err error) {
+	var trusted []*api.TrustedProcess
+	var sha256 string
+	cl := a.forwarder.Client
+
+	if trusted, err = cl.GetTrustedProcesses(); err != nil {
+		return
+	}
+
+	if sha256, err = cl.GetTrustedProcessesSha256(); err != nil {
+		return
+	}
+
+	if api.TrustedProcessesSha256(trusted) != sha256 {
+		return fmt.Errorf("failed to verify trusted processes integrity")
+	}
+
+	a.trusted.Update(trusted, sha256)
+	a.logger.Infof("Number of trusted processes: %d", a.trusted.Len())
+
+	return
+}
+
+// isProcessAccess returns true if the event is a Sysmon ProcessAccess
+// or CreateRemoteThread event
+func isProcessAccess(e *event.EdrEvent) bool {
+	if e.Channel() != sysmonChannel {
+		return false
+	}
+
+	switch e.EventID() {
+	case SysmonAccessProcess, SysmonCreateRemoteThread:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/api"
+)
+
+var (
+	// UsageReportInterval interval at which rules and containers
+	// usage is reported to the manager
+	UsageReportInterval = 5 * time.Minute
+)
+
+// ruleContainersMap maps the rules loaded in an engine to the containers they use
+func ruleContainersMap(e *engine.Engine) (m map[string][]string) {
+	m = make(map[string][]string)
+	for _, name := range e.GetRuleNames() {
+		r := engine.Rule{}
+		if err := json.Unmarshal([]byte(e.GetRawRuleByName(name)), &r); err == nil {
+			if containers := api.RuleContainers(&r); len(containers) > 0 {
+				m[name] = containers
+			}
+		}
+	}
+	return
+}
+
+// setUsageRules must be called when a new engine is loaded so that
+// container hits are accounted for
+func (a *Agent) setUsageRules(e *engine.Engine) {
+	m := ruleContainersMap(e)
+
+	a.usage.Lock()
+	defer a.usage.Unlock()
+	a.usage.ruleContainers = m
+}
+
+// usageHit accounts for rules matching an event
+func (a *Agent) usageHit(rules []string) {
+	a.usage.Lock()
+	defer a.usage.Unlock()
+
+	for _, r := range rules {
+		a.usage.report.Hit(r, a.usage.ruleContainers[r]...)
+	}
+}
+
+// reportUsage sends rules and containers usage to the manager
+func (a *Agent) reportUsage() (err error) {
+	a.usage.Lock()
+	report := a.usage.report
+	a.usage.report = api.NewUsageReport()
+	a.usage.Unlock()
+
+	// statistics are sent along with usage
+	report.Stats = a.Stats()
+	report.Until = time.Now().UTC()
+	if err = a.forwarder.Client.PostUsageReport(report); err != nil {
+		// we keep usage for next report
+		a.usage.Lock()
+		a.usage.report.Merge(report)
+		a.usage.Unlock()
+	}
+
+	return
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// VSS collection targets
+	VSSTargetHives = "hives"
+	VSSTargetNTDS  = "ntds"
+	VSSTargetOST   = "ost"
+	VSSTargetSRUM  = "srum"
+
+	vssCreateScript = `$r = (Get-WmiObject -List Win32_ShadowCopy).Create('%s', 'ClientAccessible');` +
+		`if ($r.ReturnValue -ne 0) { Write-Error "failed to create shadow copy: $($r.ReturnValue)"; exit 1 };` +
+		`Get-WmiObject Win32_ShadowCopy | Where-Object { $_.ID -eq $r.ShadowID } | ` +
+		`Select-Object ID,DeviceObject,VolumeName | ConvertTo-Json`
+)
+
+var (
+	vssIDRe = regexp.MustCompile(`(?i:^\{[a-f0-9]{8}-([a-f0-9]{4}-){3}[a-f0-9]{12}\}$)`)
+
+	// locked files collected for VSS targets, relative to system drive
+	vssTargets = map[string][]string{
+		VSSTargetHives: {
+			`Windows\System32\config\SAM`,
+			`Windows\System32\config\SECURITY`,
+			`Windows\System32\config\SOFTWARE`,
+			`Windows\System32\config\SYSTEM`,
+			`Windows\System32\config\DEFAULT`,
+			`Users\*\NTUSER.DAT`,
+			`Users\*\AppData\Local\Microsoft\Windows\UsrClass.dat`,
+		},
+		// only exists on domain controllers
+		VSSTargetNTDS: {
+			`Windows\NTDS\ntds.dit`,
+		},
+		VSSTargetOST: {
+			`Users\*\AppData\Local\Microsoft\Outlook\*.ost`,
+		},
+		VSSTargetSRUM: {
+			`Windows\System32\sru\SRUDB.dat`,
+		},
+	}
+)
+
+// ShadowCopy describes a VSS snapshot
+type ShadowCopy struct {
+	ID string `json:"id"`
+	// device path used to access snapshot files
+	Device string `json:"device"`
+	Volume string `json:"volume"`
+}
+
+// Path returns the path of a file in the snapshot given its path on the volume
+func (s *ShadowCopy) Path(path string) string {
+	return s.Device + `\` + strings.TrimLeft(strings.TrimPrefix(path, filepath.VolumeName(path)), `\`)
+}
+
+// VSSCollectedFile is a file collected from a snapshot
+type VSSCollectedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+	// path of the artifact, relative to the endpoint artifacts directory
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VSSCollection is the result of a collection from a snapshot
+type VSSCollection struct {
+	Snapshot ShadowCopy          `json:"snapshot"`
+	Files    []*VSSCollectedFile `json:"files"`
+}
+
+func vssCreate(volume string) (s *ShadowCopy, err error) {
+	var out []byte
+
+	if filepath.VolumeName(volume) == "" {
+		return nil, fmt.Errorf("bad volume: %s", volume)
+	}
+	volume = filepath.VolumeName(volume) + `\`
+
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", fmt.Sprintf(vssCreateScript, volume))
+	if out, err = cmd.Output(); err != nil {
+		return nil, fmt.Errorf("failed to create shadow copy of %s: %w", volume, err)
+	}
+
+	raw := struct {
+		ID           string
+		DeviceObject string
+	}{}
+
+	if err = json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse shadow copy: %w", err)
+	}
+
+	return &ShadowCopy{ID: raw.ID, Device: raw.DeviceObject, Volume: volume}, nil
+}
+
+func vssDelete(id string) error {
+	if !vssIDRe.MatchString(id) {
+		return fmt.Errorf("bad shadow copy id: %s", id)
+	}
+	return exec.Command("vssadmin.exe", "delete", "shadows", fmt.Sprintf("/shadow=%s", id), "/quiet").Run()
+}
+
+// vssExpand expands collection targets (or paths) to the list of paths
+// (with globs resolved) to collect from a snapshot
+func vssExpand(s *ShadowCopy, targets []string) (paths []string) {
+	paths = make([]string, 0)
+
+	for _, t := range targets {
+		patterns, ok := vssTargets[strings.ToLower(t)]
+		if !ok {
+			patterns = []string{t}
+		}
+
+		for _, p := range patterns {
+			if filepath.VolumeName(p) == "" {
+				p = filepath.Join(s.Volume, p)
+			}
+
+			if !strings.Contains(p, "*") {
+				paths = append(paths, p)
+				continue
+			}
+
+			// globs are resolved against the snapshot
+			matches, _ := filepath.Glob(s.Path(p))
+			for _, m := range matches {
+				paths = append(paths, filepath.Join(s.Volume, strings.TrimPrefix(m, s.Device)))
+			}
+		}
+	}
+
+	return
+}
+
+// collectFromVSS copies files from snapshot to the dump directory so that
+// they are uploaded to the manager as any other artifact
+func (a *Agent) collectFromVSS(s *ShadowCopy, targets []string) (c *VSSCollection) {
+	c = &VSSCollection{Snapshot: *s, Files: make([]*VSSCollectedFile, 0)}
+
+	for _, path := range vssExpand(s, targets) {
+		cf := &VSSCollectedFile{Path: path}
+		c.Files = append(c.Files, cf)
+
+		if err := a.collectFileFromVSS(s, cf); err != nil {
+			cf.Error = err.Error()
+		}
+	}
+
+	return
+}
+
+func (a *Agent) collectFileFromVSS(s *ShadowCopy, cf *VSSCollectedFile) (err error) {
+	var src *os.File
+	var fi os.FileInfo
+
+	if src, err = os.Open(s.Path(cf.Path)); err != nil {
+		return
+	}
+	defer src.Close()
+
+	if fi, err = src.Stat(); err != nil {
+		return
+	}
+
+	if cf.Size = fi.Size(); cf.Size > a.config.FwdConfig.Client.MaxUploadSize {
+		return fmt.Errorf("file is above allowed upload limit")
+	}
+
+	// artifacts are grouped by snapshot
+	cf.Artifact, cf.Sha256, err = a.collectArtifact(s.ID, cf.Path, src)
+	return
+}
+
+// cmdVSSCollect creates a snapshot of system drive, collects targets
+// from it and deletes the snapshot
+func (a *Agent) cmdVSSCollect(targets []string) (c *VSSCollection, err error) {
+	var s *ShadowCopy
+
+	start := time.Now()
+	if s, err = vssCreate(os.Getenv("SystemDrive")); err != nil {
+		return
+	}
+
+	defer func() {
+		if err := vssDelete(s.ID); err != nil {
+			a.logger.Errorf("failed to delete shadow copy %s: %s", s.ID, err)
+		}
+	}()
+
+	c = a.collectFromVSS(s, targets)
+	a.logger.Infof("collected %d files from shadow copy %s in %s", len(c.Files), s.ID, time.Since(start))
+
+	return
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+// watchlistsSep separates watchlists names in Watchlists field
+const watchlistsSep = ","
+
+// Watchlists maps watched accounts to the watchlists they belong to
+type Watchlists struct {
+	sync.RWMutex
+	accounts map[string][]string
+}
+
+// NewWatchlists creates an empty Watchlists structure
+func NewWatchlists() *Watchlists {
+	return &Watchlists{accounts: make(map[string][]string)}
+}
+
+// normAccount normalizes an account so that DOMAIN\user matching is case insensitive
+func normAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// Add adds accounts to a watchlist
+func (w *Watchlists) Add(watchlist string, accounts ...string) {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, a := range accounts {
+		if a = normAccount(a); a == "" {
+			continue
+		}
+		w.accounts[a] = append(w.accounts[a], watchlist)
+	}
+}
+
+// Match returns the watchlists an account belongs to, both DOMAIN\user
+// and user forms are looked up
+func (w *Watchlists) Match(account string) (watchlists []string) {
+	w.RLock()
+	defer w.RUnlock()
+
+	account = normAccount(account)
+	watchlists = append(watchlists, w.accounts[account]...)
+	if i := strings.LastIndex(account, `\`); i >= 0 {
+		watchlists = append(watchlists, w.accounts[account[i+1:]]...)
+	}
+	return
+}
+
+// Len returns the number of watched accounts
+func (w *Watchlists) Len() int {
+	w.RLock()
+	defer w.RUnlock()
+	return len(w.accounts)
+}
+
+// loadWatchlist loads the accounts of a watchlist from its container
+func loadWatchlist(w *Watchlists, name, path string) (err error) {
+	var fd *os.File
+	var r *gzip.Reader
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if r, err = gzip.NewReader(fd); err != nil {
+		return
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		w.Add(name, scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// loadWatchlists loads configured user watchlists from their containers
+func (a *Agent) loadWatchlists() (lastErr error) {
+	w := NewWatchlists()
+
+	for _, l := range a.config.WatchConfig.Lists {
+		path, _ := a.containerPaths(l.Container)
+		if err := loadWatchlist(w, l.Container, path); err != nil {
+			lastErr = fmt.Errorf("failed to load watchlist %s: %w", l.Container, err)
+			a.logger.Error(lastErr)
+		}
+	}
+
+	a.logger.Infof("Number of accounts in user watchlists: %d", w.Len())
+	a.watchlists = w
+
+	return
+}
+
+// eventAccount returns the account found at user path, domain
+// path is optional and used to build DOMAIN\user accounts
+func eventAccount(e *event.EdrEvent, user, domain *engine.XPath) (account string, ok bool) {
+	if account, ok = e.GetString(user); !ok || account == "" || account == "-" {
+		return "", false
+	}
+
+	if domain != nil {
+		if d, ok := e.GetString(domain); ok && d != "" && d != "-" {
+			account = fmt.Sprintf(`%s\%s`, d, account)
+		}
+	}
+
+	return
+}
+
+// matchWatchlists returns the sorted list of watchlists the accounts of an event belong to
+func (a *Agent) matchWatchlists(e *event.EdrEvent) (matched []string) {
+	set := make(map[string]bool)
+
+	for _, p := range [][2]*engine.XPath{
+		{pathSysmonUser, nil},
+		{pathParentUser, nil},
+		{pathSourceUser, nil},
+		{pathTargetUser, nil},
+		{pathSubjectUserName, pathSubjectDomainName},
+		{pathTargetUserName, pathTargetDomainName},
+	} {
+		if account, ok := eventAccount(e, p[0], p[1]); ok {
+			for _, w := range a.watchlists.Match(account) {
+				set[w] = true
+			}
+		}
+	}
+
+	for w := range set {
+		matched = append(matched, w)
+	}
+	sort.Strings(matched)
+
+	return
+}
+
+// eventWatchlists returns the watchlists set in an event by hookWatchlists
+func eventWatchlists(e *event.EdrEvent) []string {
+	if s, ok := e.GetString(pathWatchlists); ok && s != "" {
+		return strings.Split(s, watchlistsSep)
+	}
+	return nil
+}
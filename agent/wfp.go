@@ -0,0 +1,687 @@
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Windows Filtering Platform (WFP) bindings used to contain hosts. Containment
+// filters are persistent, they are enforced by the Base Filtering Engine even
+// if Windows Firewall service is stopped or its rules are reset, and they
+// survive reboots. Structure layouts match the amd64 ones of fwpmtypes.h.
+
+const (
+	rpcCAuthnWinNT = 10
+
+	fwpmFlagPersistent = 0x1
+
+	// FWP_DATA_TYPE values
+	fwpUint8           = 1
+	fwpUint16          = 2
+	fwpUint32          = 3
+	fwpByteArray16Type = 11
+	fwpByteBlobType    = 12
+
+	// FWP_MATCH_TYPE values
+	fwpMatchEqual       = 0
+	fwpMatchFlagsAllSet = 6
+
+	fwpActionBlock  = 0x1001
+	fwpActionPermit = 0x1002
+
+	fwpConditionFlagIsLoopback  = 0x1
+	fwpFilterEnumFullyContained = 0
+
+	errFwpFilterNotFound   = 0x80320003
+	errFwpProviderNotFound = 0x80320005
+	errFwpSublayerNotFound = 0x80320007
+	errFwpAlreadyExists    = 0x80320009
+	errFwpInUse            = 0x8032000a
+
+	ipProtoUDP = 17
+
+	// weights of the filters within containment sublayer
+	wfpWeightPermit = 15
+	wfpWeightBlock  = 0
+
+	// number of filters enumerated at once
+	wfpEnumBatch = 64
+)
+
+var (
+	fwpuclnt = syscall.NewLazyDLL("fwpuclnt.dll")
+
+	procFwpmEngineOpen0              = fwpuclnt.NewProc("FwpmEngineOpen0")
+	procFwpmEngineClose0             = fwpuclnt.NewProc("FwpmEngineClose0")
+	procFwpmTransactionBegin0        = fwpuclnt.NewProc("FwpmTransactionBegin0")
+	procFwpmTransactionCommit0       = fwpuclnt.NewProc("FwpmTransactionCommit0")
+	procFwpmTransactionAbort0        = fwpuclnt.NewProc("FwpmTransactionAbort0")
+	procFwpmProviderAdd0             = fwpuclnt.NewProc("FwpmProviderAdd0")
+	procFwpmProviderDeleteByKey0     = fwpuclnt.NewProc("FwpmProviderDeleteByKey0")
+	procFwpmSubLayerAdd0             = fwpuclnt.NewProc("FwpmSubLayerAdd0")
+	procFwpmSubLayerDeleteByKey0     = fwpuclnt.NewProc("FwpmSubLayerDeleteByKey0")
+	procFwpmFilterAdd0               = fwpuclnt.NewProc("FwpmFilterAdd0")
+	procFwpmFilterDeleteById0        = fwpuclnt.NewProc("FwpmFilterDeleteById0")
+	procFwpmFilterCreateEnumHandle0  = fwpuclnt.NewProc("FwpmFilterCreateEnumHandle0")
+	procFwpmFilterEnum0              = fwpuclnt.NewProc("FwpmFilterEnum0")
+	procFwpmFilterDestroyEnumHandle0 = fwpuclnt.NewProc("FwpmFilterDestroyEnumHandle0")
+	procFwpmFreeMemory0              = fwpuclnt.NewProc("FwpmFreeMemory0")
+	procFwpmGetAppIdFromFileName0    = fwpuclnt.NewProc("FwpmGetAppIdFromFileName0")
+)
+
+// wfpGUID is a Windows GUID
+type wfpGUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+var (
+	// provider of agent's filters and sublayers owning containment
+	// and process isolation filters
+	wfpProviderKey        = wfpGUID{0xe7de8cc6, 0xf595, 0x401d, [8]byte{0xae, 0xf4, 0xe7, 0xf9, 0x08, 0x7d, 0xd5, 0x4e}}
+	wfpSublayerKey        = wfpGUID{0xcc73585e, 0x23d7, 0x47aa, [8]byte{0x9e, 0x2d, 0xad, 0x1c, 0x1a, 0x19, 0x53, 0x02}}
+	wfpProcessSublayerKey = wfpGUID{0x283201c4, 0x04eb, 0x482c, [8]byte{0x94, 0xac, 0x73, 0x18, 0x74, 0xe3, 0x03, 0xa3}}
+
+	// outbound connections and inbound accepts
+	wfpLayerConnectV4 = wfpGUID{0xc38d57d1, 0x05a7, 0x4c33, [8]byte{0x90, 0x4f, 0x7f, 0xbc, 0xee, 0xe6, 0x0e, 0x82}}
+	wfpLayerConnectV6 = wfpGUID{0x4a72393b, 0x319f, 0x44bc, [8]byte{0x84, 0xc3, 0xba, 0x54, 0xdc, 0xb3, 0xb6, 0xb4}}
+	wfpLayerAcceptV4  = wfpGUID{0xe1cd9fe7, 0xf4b5, 0x4273, [8]byte{0x96, 0xc0, 0x59, 0x2e, 0x48, 0x7b, 0x86, 0x50}}
+	wfpLayerAcceptV6  = wfpGUID{0xa3b42c97, 0x9f04, 0x4672, [8]byte{0xb8, 0x7e, 0xce, 0xe9, 0xc4, 0x83, 0x25, 0x7f}}
+
+	wfpContainLayers = []wfpGUID{wfpLayerConnectV4, wfpLayerAcceptV4, wfpLayerConnectV6, wfpLayerAcceptV6}
+
+	wfpConditionRemoteAddress = wfpGUID{0xb235ae9a, 0x1d64, 0x49b8, [8]byte{0xa4, 0x4c, 0x5f, 0xf3, 0xd9, 0x09, 0x50, 0x45}}
+	wfpConditionRemotePort    = wfpGUID{0xc35a604d, 0xd22b, 0x4e1a, [8]byte{0x91, 0xb4, 0x68, 0xf6, 0x74, 0xee, 0x67, 0x4b}}
+	wfpConditionLocalPort     = wfpGUID{0x0c1ba1af, 0x5765, 0x453f, [8]byte{0xaf, 0x22, 0xa8, 0xf7, 0x91, 0xac, 0x77, 0x5b}}
+	wfpConditionProtocol      = wfpGUID{0x3971ef2b, 0x623e, 0x4f9a, [8]byte{0x8c, 0xb1, 0x6e, 0x79, 0xb8, 0x06, 0xb9, 0xa7}}
+	wfpConditionAppID         = wfpGUID{0xd78e1e87, 0x8644, 0x4ea5, [8]byte{0x94, 0x37, 0xd8, 0x09, 0xec, 0xef, 0xc9, 0x71}}
+	wfpConditionFlags         = wfpGUID{0x632ce23b, 0x5167, 0x435c, [8]byte{0x86, 0xd7, 0xe9, 0x03, 0x68, 0x4a, 0xa8, 0x0c}}
+)
+
+// wfpCondition is a condition of a filter, addr is only used by IPv6
+// address conditions and blob by byte blob ones (i.e. application IDs)
+type wfpCondition struct {
+	field wfpGUID
+	match uint32
+	typ   uint32
+	value uint32
+	addr  *[16]byte
+	blob  uintptr
+}
+
+// wfpFilter is the specification of a filter
+type wfpFilter struct {
+	name   string
+	desc   string
+	layer  wfpGUID
+	action uint32
+	weight uint8
+	conds  []wfpCondition
+}
+
+// remoteAddrCondition returns a condition matching remote address ip
+func remoteAddrCondition(ip net.IP) wfpCondition {
+	// IPv4 addresses are matched in host byte order
+	if ip4 := ip.To4(); ip4 != nil {
+		return wfpCondition{field: wfpConditionRemoteAddress, match: fwpMatchEqual, typ: fwpUint32, value: binary.BigEndian.Uint32(ip4)}
+	}
+
+	addr := new([16]byte)
+	copy(addr[:], ip.To16())
+	return wfpCondition{field: wfpConditionRemoteAddress, match: fwpMatchEqual, typ: fwpByteArray16Type, addr: addr}
+}
+
+// containFilters returns the filters blocking all traffic but loopback,
+// DHCP and connections with allowed IPs, both inbound and outbound
+func containFilters(allowed []net.IP) (filters []*wfpFilter) {
+	filters = make([]*wfpFilter, 0)
+
+	for _, v6 := range []bool{false, true} {
+		connect, accept := wfpLayerConnectV4, wfpLayerAcceptV4
+		// DHCP server and client ports
+		server, client := uint32(67), uint32(68)
+		if v6 {
+			connect, accept = wfpLayerConnectV6, wfpLayerAcceptV6
+			server, client = 547, 546
+		}
+
+		for _, layer := range []wfpGUID{connect, accept} {
+			filters = append(filters, &wfpFilter{
+				name:   "permit loopback",
+				layer:  layer,
+				action: fwpActionPermit,
+				weight: wfpWeightPermit,
+				conds:  []wfpCondition{{field: wfpConditionFlags, match: fwpMatchFlagsAllSet, typ: fwpUint32, value: fwpConditionFlagIsLoopback}},
+			})
+
+			for _, ip := range allowed {
+				if (ip.To4() == nil) != v6 {
+					continue
+				}
+				filters = append(filters, &wfpFilter{
+					name:   fmt.Sprintf("permit %s", ip),
+					layer:  layer,
+					action: fwpActionPermit,
+					weight: wfpWeightPermit,
+					conds:  []wfpCondition{remoteAddrCondition(ip)},
+				})
+			}
+
+			filters = append(filters, &wfpFilter{
+				name:   "block all",
+				layer:  layer,
+				action: fwpActionBlock,
+				weight: wfpWeightBlock,
+			})
+		}
+
+		// host must be able to renew its lease not to lose connectivity with manager
+		udp := wfpCondition{field: wfpConditionProtocol, match: fwpMatchEqual, typ: fwpUint8, value: ipProtoUDP}
+		filters = append(filters,
+			&wfpFilter{
+				name:   "permit DHCP requests",
+				layer:  connect,
+				action: fwpActionPermit,
+				weight: wfpWeightPermit,
+				conds:  []wfpCondition{udp, {field: wfpConditionRemotePort, match: fwpMatchEqual, typ: fwpUint16, value: server}},
+			},
+			&wfpFilter{
+				name:   "permit DHCP replies",
+				layer:  accept,
+				action: fwpActionPermit,
+				weight: wfpWeightPermit,
+				conds:  []wfpCondition{udp, {field: wfpConditionLocalPort, match: fwpMatchEqual, typ: fwpUint16, value: client}},
+			})
+	}
+
+	return
+}
+
+// processFilters returns the filters blocking all the traffic of an
+// application, given its ID as returned by FwpmGetAppIdFromFileName0
+func processFilters(appID uintptr, name, desc string) (filters []*wfpFilter) {
+	filters = make([]*wfpFilter, 0, len(wfpContainLayers))
+	for _, layer := range wfpContainLayers {
+		filters = append(filters, &wfpFilter{
+			name:   name,
+			desc:   desc,
+			layer:  layer,
+			action: fwpActionBlock,
+			weight: wfpWeightBlock,
+			conds:  []wfpCondition{{field: wfpConditionAppID, match: fwpMatchEqual, typ: fwpByteBlobType, blob: appID}},
+		})
+	}
+	return
+}
+
+type fwpmDisplayData0 struct {
+	Name        *uint16
+	Description *uint16
+}
+
+type fwpByteBlob struct {
+	Size uint32
+	Data *uint8
+}
+
+type fwpValue0 struct {
+	Type uint32
+	// union of the values, pointers or values on 64 bits at most
+	Value uintptr
+}
+
+type fwpmProvider0 struct {
+	ProviderKey  wfpGUID
+	DisplayData  fwpmDisplayData0
+	Flags        uint32
+	ProviderData fwpByteBlob
+	ServiceName  *uint16
+}
+
+type fwpmSublayer0 struct {
+	SubLayerKey  wfpGUID
+	DisplayData  fwpmDisplayData0
+	Flags        uint32
+	ProviderKey  *wfpGUID
+	ProviderData fwpByteBlob
+	Weight       uint16
+}
+
+type fwpmFilterCondition0 struct {
+	FieldKey       wfpGUID
+	MatchType      uint32
+	ConditionValue fwpValue0
+}
+
+type fwpmAction0 struct {
+	Type uint32
+	// union of filter type and callout key
+	Key wfpGUID
+}
+
+type fwpmFilter0 struct {
+	FilterKey           wfpGUID
+	DisplayData         fwpmDisplayData0
+	Flags               uint32
+	ProviderKey         *wfpGUID
+	ProviderData        fwpByteBlob
+	LayerKey            wfpGUID
+	SubLayerKey         wfpGUID
+	Weight              fwpValue0
+	NumFilterConditions uint32
+	FilterCondition     *fwpmFilterCondition0
+	Action              fwpmAction0
+	// union of a raw context (UINT64) and a GUID, 8 bytes aligned
+	ProviderContextKey [2]uint64
+	Reserved           *wfpGUID
+	FilterID           uint64
+	EffectiveWeight    fwpValue0
+}
+
+type fwpmFilterEnumTemplate0 struct {
+	ProviderKey             *wfpGUID
+	LayerKey                wfpGUID
+	EnumType                uint32
+	Flags                   uint32
+	ProviderContextTemplate uintptr
+	NumFilterConditions     uint32
+	FilterCondition         uintptr
+	ActionMask              uint32
+	CalloutKey              *wfpGUID
+}
+
+// wfpError is an error code returned by WFP functions
+type wfpError uint32
+
+func (e wfpError) Error() string {
+	return fmt.Sprintf("WFP error 0x%08x", uint32(e))
+}
+
+// wfpCall calls a WFP function, they all return an error code
+func wfpCall(p *syscall.LazyProc, args ...uintptr) error {
+	if r1, _, _ := p.Call(args...); r1 != 0 {
+		return wfpError(r1)
+	}
+	return nil
+}
+
+// wfpIgnore returns nil if err is one of the codes
+func wfpIgnore(err error, codes ...wfpError) error {
+	for _, c := range codes {
+		if err == c {
+			return nil
+		}
+	}
+	return err
+}
+
+func wfpDisplayData(name, desc string) (d fwpmDisplayData0) {
+	d.Name, _ = syscall.UTF16PtrFromString(name)
+	if desc != "" {
+		d.Description, _ = syscall.UTF16PtrFromString(desc)
+	}
+	return
+}
+
+// utf16PtrToString converts a NUL terminated UTF-16 string allocated by WFP
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+
+	n := 0
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; n++ {
+		ptr = unsafe.Add(ptr, 2)
+	}
+
+	return syscall.UTF16ToString(unsafe.Slice(p, n))
+}
+
+// wfpEngine is a session opened with the Base Filtering Engine
+type wfpEngine struct {
+	h uintptr
+}
+
+func openWfpEngine() (e *wfpEngine, err error) {
+	e = &wfpEngine{}
+	if err = wfpCall(procFwpmEngineOpen0, 0, rpcCAuthnWinNT, 0, 0, uintptr(unsafe.Pointer(&e.h))); err != nil {
+		return nil, fmt.Errorf("failed to open filtering engine: %w", err)
+	}
+	return
+}
+
+// transaction runs f within a transaction, changes are only applied if f succeeds
+func (e *wfpEngine) transaction(f func() error) (err error) {
+	if err = wfpCall(procFwpmTransactionBegin0, e.h, 0); err != nil {
+		return
+	}
+
+	if err = f(); err != nil {
+		procFwpmTransactionAbort0.Call(e.h)
+		return
+	}
+
+	return wfpCall(procFwpmTransactionCommit0, e.h)
+}
+
+// addSublayer adds agent's provider and a sublayer if they do not exist
+func (e *wfpEngine) addSublayer(key wfpGUID, name string, weight uint16) (err error) {
+	p := fwpmProvider0{
+		ProviderKey: wfpProviderKey,
+		DisplayData: wfpDisplayData("EDR", ""),
+		Flags:       fwpmFlagPersistent,
+	}
+
+	if err = wfpIgnore(wfpCall(procFwpmProviderAdd0, e.h, uintptr(unsafe.Pointer(&p)), 0), errFwpAlreadyExists); err != nil {
+		return fmt.Errorf("failed to add provider: %w", err)
+	}
+
+	s := fwpmSublayer0{
+		SubLayerKey: key,
+		DisplayData: wfpDisplayData(name, ""),
+		Flags:       fwpmFlagPersistent,
+		ProviderKey: &wfpProviderKey,
+		Weight:      weight,
+	}
+
+	if err = wfpIgnore(wfpCall(procFwpmSubLayerAdd0, e.h, uintptr(unsafe.Pointer(&s)), 0), errFwpAlreadyExists); err != nil {
+		return fmt.Errorf("failed to add sublayer: %w", err)
+	}
+
+	return
+}
+
+// deleteSublayer deletes a sublayer, and agent's provider if no other
+// sublayer uses it, filters of the sublayer must have been removed
+func (e *wfpEngine) deleteSublayer(key wfpGUID) (err error) {
+	if err = wfpIgnore(wfpCall(procFwpmSubLayerDeleteByKey0, e.h, uintptr(unsafe.Pointer(&key))), errFwpSublayerNotFound); err != nil {
+		return fmt.Errorf("failed to delete sublayer: %w", err)
+	}
+
+	if err = wfpIgnore(wfpCall(procFwpmProviderDeleteByKey0, e.h, uintptr(unsafe.Pointer(&wfpProviderKey))), errFwpProviderNotFound, errFwpInUse); err != nil {
+		return fmt.Errorf("failed to delete provider: %w", err)
+	}
+
+	return
+}
+
+// addFilter adds a filter to a sublayer
+func (e *wfpEngine) addFilter(sublayer wfpGUID, f *wfpFilter) (err error) {
+	var id uint64
+
+	conds := make([]fwpmFilterCondition0, len(f.conds))
+	for i := range f.conds {
+		c := &f.conds[i]
+		conds[i] = fwpmFilterCondition0{
+			FieldKey:       c.field,
+			MatchType:      c.match,
+			ConditionValue: fwpValue0{Type: c.typ, Value: uintptr(c.value)},
+		}
+		switch c.typ {
+		case fwpByteArray16Type:
+			conds[i].ConditionValue.Value = uintptr(unsafe.Pointer(c.addr))
+		case fwpByteBlobType:
+			conds[i].ConditionValue.Value = c.blob
+		}
+	}
+
+	filter := fwpmFilter0{
+		DisplayData: wfpDisplayData(fmt.Sprintf("%s: %s", ContainRuleName, f.name), f.desc),
+		Flags:       fwpmFlagPersistent,
+		ProviderKey: &wfpProviderKey,
+		LayerKey:    f.layer,
+		SubLayerKey: sublayer,
+		Weight:      fwpValue0{Type: fwpUint8, Value: uintptr(f.weight)},
+		Action:      fwpmAction0{Type: f.action},
+	}
+
+	if len(conds) > 0 {
+		filter.NumFilterConditions = uint32(len(conds))
+		filter.FilterCondition = &conds[0]
+	}
+
+	err = wfpCall(procFwpmFilterAdd0, e.h, uintptr(unsafe.Pointer(&filter)), 0, uintptr(unsafe.Pointer(&id)))
+	// IPv6 addresses are only referenced through uintptr
+	runtime.KeepAlive(f)
+
+	if err != nil {
+		return fmt.Errorf("failed to add filter %q: %w", f.name, err)
+	}
+
+	return
+}
+
+// filters calls fn with the filters of a sublayer in a given layer
+func (e *wfpEngine) filters(sublayer, layer wfpGUID, fn func(f *fwpmFilter0)) (err error) {
+	var enum uintptr
+
+	tmpl := fwpmFilterEnumTemplate0{
+		ProviderKey: &wfpProviderKey,
+		LayerKey:    layer,
+		EnumType:    fwpFilterEnumFullyContained,
+		ActionMask:  0xffffffff,
+	}
+
+	if err = wfpCall(procFwpmFilterCreateEnumHandle0, e.h, uintptr(unsafe.Pointer(&tmpl)), uintptr(unsafe.Pointer(&enum))); err != nil {
+		return
+	}
+	defer procFwpmFilterDestroyEnumHandle0.Call(e.h, enum)
+
+	for {
+		var entries **fwpmFilter0
+		var n uint32
+
+		if err = wfpCall(procFwpmFilterEnum0, e.h, enum, wfpEnumBatch, uintptr(unsafe.Pointer(&entries)), uintptr(unsafe.Pointer(&n))); err != nil {
+			return
+		}
+
+		if n == 0 {
+			return
+		}
+
+		for _, f := range unsafe.Slice(entries, n) {
+			if f.SubLayerKey == sublayer {
+				fn(f)
+			}
+		}
+		procFwpmFreeMemory0.Call(uintptr(unsafe.Pointer(&entries)))
+
+		if n < wfpEnumBatch {
+			return
+		}
+	}
+}
+
+// removeFilters removes the filters of a sublayer for which match returns true
+func (e *wfpEngine) removeFilters(sublayer wfpGUID, match func(f *fwpmFilter0) bool) (n int, err error) {
+	for _, layer := range wfpContainLayers {
+		ids := make([]uint64, 0)
+
+		if err = e.filters(sublayer, layer, func(f *fwpmFilter0) {
+			if match(f) {
+				ids = append(ids, f.FilterID)
+			}
+		}); err != nil {
+			return n, fmt.Errorf("failed to enumerate filters: %w", err)
+		}
+
+		for _, id := range ids {
+			if err = wfpIgnore(wfpCall(procFwpmFilterDeleteById0, e.h, uintptr(id)), errFwpFilterNotFound); err != nil {
+				return n, fmt.Errorf("failed to delete filter %d: %w", id, err)
+			}
+			n++
+		}
+	}
+	return
+}
+
+// Close closes the session
+func (e *wfpEngine) Close() error {
+	return wfpCall(procFwpmEngineClose0, e.h)
+}
+
+func anyFilter(*fwpmFilter0) bool {
+	return true
+}
+
+// wfpContain replaces containment filters by the ones allowing traffic with
+// allowed IPs only, filters are replaced within a transaction so that host
+// is never left uncontained. It returns the number of filters added.
+func wfpContain(allowed []net.IP) (n int, err error) {
+	var e *wfpEngine
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	filters := containFilters(allowed)
+	err = e.transaction(func() error {
+		// evaluated before other sublayers
+		if err := e.addSublayer(wfpSublayerKey, ContainRuleName, 0xffff); err != nil {
+			return err
+		}
+
+		if _, err := e.removeFilters(wfpSublayerKey, anyFilter); err != nil {
+			return err
+		}
+
+		for _, f := range filters {
+			if err := e.addFilter(wfpSublayerKey, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(filters), nil
+}
+
+// wfpUncontain removes containment filters
+func wfpUncontain() (err error) {
+	var e *wfpEngine
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	return e.transaction(func() error {
+		if _, err := e.removeFilters(wfpSublayerKey, anyFilter); err != nil {
+			return err
+		}
+		return e.deleteSublayer(wfpSublayerKey)
+	})
+}
+
+// wfpContainFilters returns the number of containment filters in place
+func wfpContainFilters() (n int, err error) {
+	var e *wfpEngine
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	for _, layer := range wfpContainLayers {
+		if err = e.filters(wfpSublayerKey, layer, func(*fwpmFilter0) { n++ }); err != nil {
+			return 0, fmt.Errorf("failed to enumerate filters: %w", err)
+		}
+	}
+
+	return
+}
+
+// wfpIsolateProcess blocks all the traffic of the processes running image,
+// desc is the description of the filters, used to release isolation
+func wfpIsolateProcess(image, name, desc string) (err error) {
+	var e *wfpEngine
+	var pimage *uint16
+	var appID uintptr
+
+	if pimage, err = syscall.UTF16PtrFromString(image); err != nil {
+		return
+	}
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	if err = wfpCall(procFwpmGetAppIdFromFileName0, uintptr(unsafe.Pointer(pimage)), uintptr(unsafe.Pointer(&appID))); err != nil {
+		return fmt.Errorf("failed to get application ID of %s: %w", image, err)
+	}
+	defer procFwpmFreeMemory0.Call(uintptr(unsafe.Pointer(&appID)))
+
+	return e.transaction(func() error {
+		// evaluated right after containment sublayer
+		if err := e.addSublayer(wfpProcessSublayerKey, ContainRuleName+" (processes)", 0xfffe); err != nil {
+			return err
+		}
+
+		for _, f := range processFilters(appID, name, desc) {
+			if err := e.addFilter(wfpProcessSublayerKey, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// wfpReleaseProcesses removes the process isolation filters whose
+// description matches, it returns the number of filters removed
+func wfpReleaseProcesses(match func(desc string) bool) (n int, err error) {
+	var e *wfpEngine
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	err = e.transaction(func() (err error) {
+		n, err = e.removeFilters(wfpProcessSublayerKey, func(f *fwpmFilter0) bool {
+			return match(utf16PtrToString(f.DisplayData.Description))
+		})
+		return
+	})
+
+	return
+}
+
+// wfpIsolatedProcesses returns the descriptions of process isolation filters,
+// without duplicates as processes are isolated by several filters
+func wfpIsolatedProcesses() (descs []string, err error) {
+	var e *wfpEngine
+
+	if e, err = openWfpEngine(); err != nil {
+		return
+	}
+	defer e.Close()
+
+	seen := make(map[string]bool)
+	descs = make([]string, 0)
+	for _, layer := range wfpContainLayers {
+		if err = e.filters(wfpProcessSublayerKey, layer, func(f *fwpmFilter0) {
+			if d := utf16PtrToString(f.DisplayData.Description); !seen[d] {
+				seen[d] = true
+				descs = append(descs, d)
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("failed to enumerate filters: %w", err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	yaraFilesFilename  = "yara-files.json"
+	yaraMemoryFilename = "yara-memory.json"
+)
+
+var (
+	ErrYaraDisabled = errors.New("yara scanning is disabled")
+)
+
+// YaraMatch is a YARA rule matching a target
+type YaraMatch struct {
+	Rule   string   `json:"rule"`
+	Tags   []string `json:"tags,omitempty"`
+	Target string   `json:"target"`
+}
+
+// YaraScan holds the result of a YARA scan of a file,
+// a directory (scanned recursively) or a process
+type YaraScan struct {
+	Target  string       `json:"target"`
+	Start   time.Time    `json:"start"`
+	End     time.Time    `json:"end"`
+	Matches []*YaraMatch `json:"matches"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// yaraArgs returns the arguments of the YARA scanner to scan target
+func yaraArgs(c *config.Yara, target string) []string {
+	// print tags and no warnings
+	args := []string{"-g", "-w"}
+
+	if c.Compiled {
+		args = append(args, "-C")
+	}
+
+	if fsutil.IsDir(target) {
+		args = append(args, "-r")
+	}
+
+	return append(args, c.Rules, target)
+}
+
+// parseYaraOutput parses the output of the YARA scanner
+// run with tags printed, lines are like: RULE [TAG,...] TARGET
+func parseYaraOutput(out []byte) (matches []*YaraMatch) {
+	matches = make([]*YaraMatch, 0)
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		rule, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		m := &YaraMatch{Rule: rule}
+		if strings.HasPrefix(rest, "[") {
+			tags, target, ok := strings.Cut(rest[1:], "]")
+			if !ok {
+				continue
+			}
+			if tags != "" {
+				m.Tags = strings.Split(tags, ",")
+			}
+			rest = target
+		}
+
+		if m.Target = strings.TrimSpace(rest); m.Target == "" {
+			continue
+		}
+
+		matches = append(matches, m)
+	}
+
+	return
+}
+
+// yaraScan scans a file, a directory or the memory of a process
+// (target is a PID) with the YARA scanner configured
+func (a *Agent) yaraScan(target string) (scan *YaraScan, err error) {
+	var stdout, stderr bytes.Buffer
+
+	c := &a.config.YaraConfig
+
+	if !c.Enable {
+		return nil, ErrYaraDisabled
+	}
+
+	if pid, err := strconv.Atoi(target); err == nil && pid == os.Getpid() {
+		return nil, fmt.Errorf("refusing to scan agent's memory")
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(a.ctx, c.Timeout)
+	}
+	defer cancel()
+
+	scan = &YaraScan{Target: target, Start: time.Now().UTC()}
+	defer func() {
+		scan.End = time.Now().UTC()
+	}()
+
+	cmd := exec.CommandContext(ctx, c.Tool, yaraArgs(c, target)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	scan.Matches = parseYaraOutput(stdout.Bytes())
+
+	if err != nil {
+		err = fmt.Errorf("yara scan of %s failed: %w: %s", target, err, strings.TrimSpace(stderr.String()))
+		scan.Error = err.Error()
+	}
+
+	return
+}
+
+// yaraScanDumps scans the targets dumped for an event, the
+// results are dumped in filename along with the other dumps
+func (m *ActionHandler) yaraScanDumps(e *event.EdrEvent, filename string, targets ...string) {
+	scans := make([]*YaraScan, 0, len(targets))
+	hash := e.Hash()
+
+	for _, target := range targets {
+		scan, err := m.edr.yaraScan(target)
+		if err != nil {
+			m.edr.logger.Errorf("event=%s: %s", hash, err)
+			if scan == nil {
+				continue
+			}
+		}
+
+		for _, match := range scan.Matches {
+			m.edr.logger.Warnf("YARA rule %s matched event=%s target=%s", match.Rule, hash, match.Target)
+		}
+		scans = append(scans, scan)
+	}
+
+	if len(scans) == 0 {
+		return
+	}
+
+	path := m.prepare(e, filename)
+	if err := m.dumpAsJson(path, scans); err != nil {
+		m.edr.logger.Errorf("Failed to dump YARA results for event %s: %s", hash, err)
+	} else {
+		m.queueCompression(path)
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/sod"
+)
+
+const (
+	// AgentErrorRule rule or container failing to compile
+	AgentErrorRule = "rule"
+	// AgentErrorHook hook panic recovered by the agent
+	AgentErrorHook = "hook"
+	// AgentErrorUpload file failing to upload to the manager
+	AgentErrorUpload = "upload"
+	// AgentErrorUpdate rules, IoCs, suppressions ... failing to update from manager
+	AgentErrorUpdate = "update"
+	// AgentErrorTelemetry telemetry source missing on the endpoint
+	AgentErrorTelemetry = "telemetry"
+
+	// MaxAgentErrorSamples maximum number of error messages
+	// kept as samples for a given error
+	MaxAgentErrorSamples = 5
+)
+
+// AgentErrorRecord aggregates occurrences of a given agent error
+type AgentErrorRecord struct {
+	Kind string `json:"kind"`
+	// what failed (rule name, hook, file ...)
+	Source  string    `json:"source"`
+	Count   int64     `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Samples []string  `json:"samples"`
+}
+
+func agentErrorKey(kind, source string) string {
+	return fmt.Sprintf("%s|%s", kind, source)
+}
+
+// addSamples adds samples, keeping only the most recent distinct ones
+func (r *AgentErrorRecord) addSamples(samples ...string) {
+	for _, s := range samples {
+		for i, old := range r.Samples {
+			if old == s {
+				r.Samples = append(r.Samples[:i], r.Samples[i+1:]...)
+				break
+			}
+		}
+		r.Samples = append(r.Samples, s)
+		if len(r.Samples) > MaxAgentErrorSamples {
+			r.Samples = r.Samples[len(r.Samples)-MaxAgentErrorSamples:]
+		}
+	}
+}
+
+// update updates record with other occurrences of the same error
+func (r *AgentErrorRecord) update(other *AgentErrorRecord) {
+	if r.Count == 0 || other.First.Before(r.First) {
+		r.First = other.First
+	}
+	if other.Last.After(r.Last) {
+		r.Last = other.Last
+	}
+	r.Count += other.Count
+	r.addSamples(other.Samples...)
+}
+
+// AgentErrorReport is sent by endpoints to report the
+// significant errors which occurred since last report
+type AgentErrorReport struct {
+	Since  time.Time                    `json:"since"`
+	Until  time.Time                    `json:"until"`
+	Errors map[string]*AgentErrorRecord `json:"errors"`
+}
+
+// NewAgentErrorReport creates a new empty AgentErrorReport starting now
+func NewAgentErrorReport() *AgentErrorReport {
+	return &AgentErrorReport{
+		Since:  time.Now().UTC(),
+		Errors: make(map[string]*AgentErrorRecord),
+	}
+}
+
+// Add accounts for an error of a given kind
+func (r *AgentErrorReport) Add(kind, source string, err error) {
+	now := time.Now().UTC()
+	key := agentErrorKey(kind, source)
+
+	rec, ok := r.Errors[key]
+	if !ok {
+		rec = &AgentErrorRecord{Kind: kind, Source: source, First: now}
+		r.Errors[key] = rec
+	}
+
+	rec.Count++
+	rec.Last = now
+	rec.addSamples(err.Error())
+}
+
+// Merge merges other report into r
+func (r *AgentErrorReport) Merge(other *AgentErrorReport) {
+	if other.Since.Before(r.Since) {
+		r.Since = other.Since
+	}
+	for key, o := range other.Errors {
+		rec, ok := r.Errors[key]
+		if !ok {
+			rec = &AgentErrorRecord{Kind: o.Kind, Source: o.Source}
+			r.Errors[key] = rec
+		}
+		rec.update(o)
+	}
+}
+
+// Empty returns true if nothing has been reported
+func (r *AgentErrorReport) Empty() bool {
+	return len(r.Errors) == 0
+}
+
+// AgentError is the error history of an endpoint stored by the manager
+type AgentError struct {
+	sod.Item
+	Endpoint string    `json:"endpoint-uuid" sod:"index"`
+	Kind     string    `json:"kind" sod:"index"`
+	Source   string    `json:"source"`
+	Count    int64     `json:"count"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Samples  []string  `json:"samples"`
+}
+
+// Validate overwrite sod.Item function
+func (e *AgentError) Validate() error {
+	switch e.Kind {
+	case AgentErrorRule, AgentErrorHook, AgentErrorUpload, AgentErrorUpdate:
+	default:
+		return fmt.Errorf("unknown agent error kind %s", e.Kind)
+	}
+	return nil
+}
+
+// Key returns a key uniquely identifying an error of an endpoint
+func (e *AgentError) Key() string {
+	return fmt.Sprintf("%s|%s", e.Endpoint, agentErrorKey(e.Kind, e.Source))
+}
+
+// Update updates error history with occurrences reported by endpoint
+func (e *AgentError) Update(rec *AgentErrorRecord) {
+	r := AgentErrorRecord{
+		Kind:    e.Kind,
+		Source:  e.Source,
+		Count:   e.Count,
+		First:   e.First,
+		Last:    e.Last,
+		Samples: e.Samples,
+	}
+	r.update(rec)
+	e.Count, e.First, e.Last, e.Samples = r.Count, r.First, r.Last, r.Samples
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/google/uuid"
+)
+
+// ArchivedArtifact is the hot index entry of artifacts collected for an alert
+// (i.e. alert bundle) moved to cold storage by the manager
+type ArchivedArtifact struct {
+	sod.Item
+	Endpoint    string `json:"endpoint-uuid" sod:"index"`
+	ProcessGUID string `json:"process-guid"`
+	EventHash   string `json:"event-hash" sod:"index"`
+	// names of the files of the bundle
+	Files []string `json:"files"`
+	Size  int64    `json:"size"`
+	// last modification of the bundle's files
+	Modification time.Time `json:"modification"`
+	Archived     time.Time `json:"archived"`
+	// set when the bundle is brought back from cold storage
+	Restored time.Time `json:"restored,omitempty"`
+	// true if the bundle is in cold storage
+	Cold bool `json:"cold"`
+}
+
+// NewArchivedArtifact creates a new ArchivedArtifact, its uuid is
+// derived from the location of the bundle
+func NewArchivedArtifact(euuid, pguid, ehash string) *ArchivedArtifact {
+	a := &ArchivedArtifact{
+		Endpoint:    euuid,
+		ProcessGUID: pguid,
+		EventHash:   ehash,
+		Files:       make([]string, 0),
+	}
+	a.Initialize(ArchivedArtifactUUID(euuid, pguid, ehash))
+	return a
+}
+
+// ArchivedArtifactUUID returns the uuid of the index entry of a bundle,
+// always the same for a given bundle
+func ArchivedArtifactUUID(euuid, pguid, ehash string) string {
+	name := fmt.Sprintf("%s|%s|%s", euuid, pguid, ehash)
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+}
+
+// Validate overwrite sod.Item function
+func (a *ArchivedArtifact) Validate() error {
+	if a.Endpoint == "" || a.ProcessGUID == "" || a.EventHash == "" {
+		return fmt.Errorf("endpoint, process guid and event hash are mandatory")
+	}
+	return nil
+}
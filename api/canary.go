@@ -0,0 +1,68 @@
+package api
+
+import "time"
+
+const (
+	// CanaryRulePrefix prefix of the names of the rules generated
+	// by endpoints to detect accesses to canary files
+	CanaryRulePrefix = "Builtin:Canary"
+)
+
+// CanaryTrigger aggregates the alerts raised because a
+// process accessed a canary file of an endpoint
+type CanaryTrigger struct {
+	Endpoint  string    `json:"endpoint"`
+	Hostname  string    `json:"hostname"`
+	Canary    string    `json:"canary"`
+	Image     string    `json:"image"`
+	Rules     []string  `json:"rules"`
+	Hits      int       `json:"hits"`
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+}
+
+// HasRule returns true if the trigger has been raised by rule
+func (t *CanaryTrigger) HasRule(rule string) bool {
+	for _, r := range t.Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// CanaryHits summarizes the alerts raised for a canary
+// file, an endpoint or a process accessing canary files
+type CanaryHits struct {
+	Value    string `json:"value"`
+	Hostname string `json:"hostname,omitempty"`
+	Hits     int    `json:"hits"`
+	// number of distinct endpoints alerts were raised on
+	Endpoints int       `json:"endpoints"`
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+}
+
+// CanaryReport aggregates the canary alerts raised across
+// the fleet between Start and End
+type CanaryReport struct {
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Alerts    int              `json:"alerts"`
+	Triggers  []*CanaryTrigger `json:"triggers"`
+	Canaries  []*CanaryHits    `json:"canaries"`
+	Endpoints []*CanaryHits    `json:"endpoints"`
+	Processes []*CanaryHits    `json:"processes"`
+}
+
+// NewCanaryReport creates a new empty CanaryReport over [start; end]
+func NewCanaryReport(start, end time.Time) *CanaryReport {
+	return &CanaryReport{
+		Start:     start,
+		End:       end,
+		Triggers:  make([]*CanaryTrigger, 0),
+		Canaries:  make([]*CanaryHits, 0),
+		Endpoints: make([]*CanaryHits, 0),
+		Processes: make([]*CanaryHits, 0),
+	}
+}
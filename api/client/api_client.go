@@ -3,15 +3,17 @@ package client
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/crypto/data"
@@ -20,6 +22,7 @@ import (
 	"github.com/0xrawsec/whids/agent/sysinfo"
 	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/ioc"
 	"github.com/0xrawsec/whids/los"
 	"github.com/0xrawsec/whids/sysmon"
 	"github.com/0xrawsec/whids/tools"
@@ -73,7 +76,19 @@ type ManagerClient struct {
 	Config *config.Client
 
 	ManagerIP  net.IP
+	ManagerIPs []net.IP
 	HTTPClient http.Client
+
+	// containment state reported to the manager
+	containment struct {
+		sync.RWMutex
+		header string
+	}
+	tags struct {
+		sync.RWMutex
+		header string
+		set    bool
+	}
 }
 
 // NewManagerClient creates a new Client to interface with the manager
@@ -84,6 +99,7 @@ func NewManagerClient(c *config.Client) (*ManagerClient, error) {
 	mc := &ManagerClient{
 		Config:     c,
 		ManagerIP:  c.ManagerIP(),
+		ManagerIPs: c.ManagerIPs(),
 		HTTPClient: http.Client{Transport: tpt},
 	}
 
@@ -123,9 +139,39 @@ func (m *ManagerClient) Prepare(method, url string, body io.Reader) (r *http.Req
 	r.Header.Add(api.EndpointUUIDHeader, m.Config.UUID)
 	r.Header.Add(api.AuthKeyHeader, m.Config.Key)
 
+	m.containment.RLock()
+	if m.containment.header != "" {
+		r.Header.Add(api.EndpointContainmentHeader, m.containment.header)
+	}
+	m.containment.RUnlock()
+
+	m.tags.RLock()
+	if m.tags.set {
+		// an empty header means endpoint has no tag
+		r.Header.Add(api.EndpointTagsHeader, m.tags.header)
+	}
+	m.tags.RUnlock()
+
 	return
 }
 
+// SetContainment sets the containment state reported to the manager
+// along with every request
+func (m *ManagerClient) SetContainment(s *api.ContainmentState) {
+	m.containment.Lock()
+	defer m.containment.Unlock()
+	m.containment.header = s.Header()
+}
+
+// SetTags sets the endpoint tags reported to the manager
+// along with every request
+func (m *ManagerClient) SetTags(tags []string) {
+	m.tags.Lock()
+	defer m.tags.Unlock()
+	m.tags.header = api.FormatTagsHeader(tags)
+	m.tags.set = true
+}
+
 func (m *ManagerClient) PrepareAndDo(method, url string, body io.Reader) (resp *http.Response, err error) {
 	var req *http.Request
 
@@ -151,11 +197,10 @@ func (m *ManagerClient) PrepareGzip(method, url string, body io.Reader) (*http.R
 	// Prepare gzip content
 	compBody := new(bytes.Buffer)
 	w := gzip.NewWriter(compBody)
-	b, err := ioutil.ReadAll(body)
-	if err != nil {
+	// body is streamed not to hold uncompressed content in memory
+	if _, err := io.Copy(w, body); err != nil {
 		return nil, fmt.Errorf("PostLogs failed to prepare body")
 	}
-	w.Write(b)
 	w.Close()
 
 	r, err := m.Prepare(method, url, bytes.NewBuffer(compBody.Bytes()))
@@ -216,11 +261,7 @@ func (m *ManagerClient) AuthenticateServer() (err error) {
 
 func (m *ManagerClient) buildURI(url string) string {
 	url = strings.Trim(url, "/")
-	network := m.Config.Host
-	if m.Config.Port != 0 {
-		network = fmt.Sprintf("%s:%d", network, m.Config.Port)
-	}
-	return fmt.Sprintf("%s://%s/%s", m.Config.Proto, network, url)
+	return fmt.Sprintf("%s://%s/%s", m.Config.Proto, m.Config.Address(), url)
 }
 
 // GetRulesSha256 returns the sha256 string of the latest batch of rules available on the server
@@ -270,6 +311,33 @@ func (m *ManagerClient) GetIoCs() (iocs []string, err error) {
 	return
 }
 
+// GetIoCEntries retrieves IoCs with their provenance and expiry information
+func (m *ManagerClient) GetIoCEntries() (iocs []*ioc.IOC, err error) {
+	var resp *http.Response
+
+	iocs = make([]*ioc.IOC, 0)
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPIIoCsEntriesPath, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&iocs); err != nil {
+		return
+	}
+
+	return
+}
+
 // GetIoCsSha256 retrieves a sha256 from the IoCs available in the manager
 func (m *ManagerClient) GetIoCsSha256() (sha string, err error) {
 	var resp *http.Response
@@ -290,6 +358,215 @@ func (m *ManagerClient) GetIoCsSha256() (sha string, err error) {
 	return respBodyAsString(resp)
 }
 
+// GetSuppressions retrieves false positive suppressions enabled on the manager
+func (m *ManagerClient) GetSuppressions() (suppressions []*api.Suppression, err error) {
+	var resp *http.Response
+
+	suppressions = make([]*api.Suppression, 0)
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPISuppressionsPath, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&suppressions); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetSuppressionsSha256 retrieves a sha256 from the suppressions available in the manager
+func (m *ManagerClient) GetSuppressionsSha256() (sha string, err error) {
+	var resp *http.Response
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPISuppressionsSha256Path, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	return respBodyAsString(resp)
+}
+
+// GetPlaybooks retrieves response playbooks enabled on the manager
+func (m *ManagerClient) GetPlaybooks() (playbooks []*api.Playbook, err error) {
+	var resp *http.Response
+
+	playbooks = make([]*api.Playbook, 0)
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPIPlaybooksPath, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&playbooks); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetPlaybooksSha256 retrieves a sha256 from the playbooks available in the manager
+func (m *ManagerClient) GetPlaybooksSha256() (sha string, err error) {
+	var resp *http.Response
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPIPlaybooksSha256Path, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	return respBodyAsString(resp)
+}
+
+// GetTrustedProcesses retrieves trusted processes enabled on the manager
+func (m *ManagerClient) GetTrustedProcesses() (trusted []*api.TrustedProcess, err error) {
+	var resp *http.Response
+
+	trusted = make([]*api.TrustedProcess, 0)
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPITrustedPath, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&trusted); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetTrustedProcessesSha256 retrieves a sha256 from the trusted processes available in the manager
+func (m *ManagerClient) GetTrustedProcessesSha256() (sha string, err error) {
+	var resp *http.Response
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPITrustedSha256Path, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	return respBodyAsString(resp)
+}
+
+// PostPlaybookRun reports the run of a playbook to the manager
+func (m *ManagerClient) PostPlaybookRun(r *api.PlaybookRun) (err error) {
+	var resp *http.Response
+	var data []byte
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if data, err = json.Marshal(r); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDoGzip("POST", api.EptAPIPostPlaybookRunPath, bytes.NewBuffer(data)); err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	return ValidateResponse(resp, http.StatusOK)
+}
+
+// GetContainers retrieves the Gene containers managed by the manager
+func (m *ManagerClient) GetContainers() (containers []*api.EdrContainer, err error) {
+	var resp *http.Response
+
+	containers = make([]*api.EdrContainer, 0)
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPIContainersPath, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err = dec.Decode(&containers); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetContainersSha256 retrieves a sha256 from the containers available in the manager
+func (m *ManagerClient) GetContainersSha256() (sha string, err error) {
+	var resp *http.Response
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDo("GET", api.EptAPIContainersSha256Path, nil); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if err = ValidateResponse(resp, http.StatusOK); err != nil {
+		return
+	}
+
+	return respBodyAsString(resp)
+}
+
 // GetRules retrieve the latest batch of Gene rules available on the server
 func (m *ManagerClient) GetRules() (rules string, err error) {
 	var resp *http.Response
@@ -349,13 +626,24 @@ func (m *ManagerClient) PostDump(f *FileUpload) (err error) {
 
 // PostLogs posts logs to be collected
 func (m *ManagerClient) PostLogs(r io.Reader) (err error) {
+	var req *http.Request
 	var resp *http.Response
 
 	if err = m.AuthenticateServer(); err != nil {
 		return
 	}
 
-	if resp, err = m.PrepareAndDoGzip("POST", api.EptAPIPostLogsPath, r); err != nil {
+	// batch is identified by its content so that the manager does not
+	// ingest it twice if we send it again, content is hashed while
+	// it is being compressed
+	h := sha256.New()
+	if req, err = m.PrepareGzip("POST", api.EptAPIPostLogsPath, io.TeeReader(r, h)); err != nil {
+		return
+	}
+
+	req.Header.Add(api.BatchIDHeader, hex.EncodeToString(h.Sum(nil)))
+
+	if resp, err = m.HTTPClient.Do(req); err != nil {
 		return
 	}
 
@@ -367,6 +655,22 @@ func (m *ManagerClient) PostLogs(r io.Reader) (err error) {
 	return
 }
 
+// PostTap sends events live tapped, r must contain one JSON event per line
+func (m *ManagerClient) PostTap(r io.Reader) (err error) {
+	var resp *http.Response
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDoGzip("POST", api.EptAPIPostTapPath, r); err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	return ValidateResponse(resp, http.StatusOK)
+}
+
 var ()
 
 func (m *ManagerClient) PostCommand(command *api.EndpointCommand) (err error) {
@@ -459,6 +763,48 @@ func (m *ManagerClient) PostSystemInfo(info *sysinfo.SystemInfo) (err error) {
 	return
 }
 
+// PostUsageReport sends rules and containers usage to the manager
+func (m *ManagerClient) PostUsageReport(r *api.UsageReport) (err error) {
+	var resp *http.Response
+	var data []byte
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if data, err = json.Marshal(r); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDoGzip("POST", api.EptAPIPostUsagePath, bytes.NewBuffer(data)); err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	return ValidateResponse(resp, http.StatusOK)
+}
+
+// PostAgentErrorReport sends agent errors to the manager
+func (m *ManagerClient) PostAgentErrorReport(r *api.AgentErrorReport) (err error) {
+	var resp *http.Response
+	var data []byte
+
+	if err = m.AuthenticateServer(); err != nil {
+		return
+	}
+
+	if data, err = json.Marshal(r); err != nil {
+		return
+	}
+
+	if resp, err = m.PrepareAndDoGzip("POST", api.EptAPIPostErrorsPath, bytes.NewBuffer(data)); err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	return ValidateResponse(resp, http.StatusOK)
+}
+
 func (m *ManagerClient) GetSysmonConfigSha256(schemaVersion string) (sha256 string, err error) {
 	var req *http.Request
 	var resp *http.Response
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/golog"
+)
+
+const (
+	// timeout of the requests made by batching outputs
+	batchTimeout = 30 * time.Second
+	// maximum time between two retries
+	batchMaxBackoff = time.Minute
+)
+
+var (
+	// BatchBackoff time waited before the first retry of a batch,
+	// doubled at every retry
+	BatchBackoff = time.Second
+)
+
+// httpError is an error status returned by the server an output sends to
+type httpError struct {
+	output string
+	status int
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.output, e.status)
+}
+
+// retryable returns true if the request can be sent again
+func (e *httpError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// newHTTPClient returns an HTTP client for outputs
+func newHTTPClient(unsafe bool) *http.Client {
+	return &http.Client{
+		Timeout: batchTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: unsafe},
+		},
+	}
+}
+
+// batcher queues serialized events and sends them in batches, retrying
+// with an exponential backoff. It is shared by HTTP based outputs.
+type batcher struct {
+	sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	// name of the output
+	name     string
+	size     int
+	interval time.Duration
+	retries  int
+	// sends a batch of n events
+	send   func(batch []byte, n int) error
+	logger *golog.Logger
+	queue  chan []byte
+	// number of events dropped, for atomic operations
+	dropped uint64
+}
+
+func newBatcher(ctx context.Context, name string, size int, interval time.Duration, retries int, l *golog.Logger) (b *batcher, err error) {
+	if size <= 0 || interval <= 0 {
+		return nil, fmt.Errorf("batch size and flush interval must be positive")
+	}
+
+	b = &batcher{
+		name:     name,
+		size:     size,
+		interval: interval,
+		retries:  retries,
+		logger:   l,
+		queue:    make(chan []byte, size*10),
+	}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+
+	return
+}
+
+// Name implements Output interface
+func (b *batcher) Name() string {
+	return b.name
+}
+
+// Dropped returns the number of events which could not be sent
+func (b *batcher) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (b *batcher) drop(n int) {
+	atomic.AddUint64(&b.dropped, uint64(n))
+}
+
+// add queues a serialized event, it is dropped if the queue is full
+func (b *batcher) add(data []byte) error {
+	select {
+	case b.queue <- data:
+		return nil
+	default:
+		b.drop(1)
+		return fmt.Errorf("%s queue is full", b.name)
+	}
+}
+
+// flush sends a batch of n events, retrying with an exponential backoff
+// as long as the error is not definitive. Retries go on while the output is
+// being closed so that events queued are not lost on a transient error.
+func (b *batcher) flush(batch []byte, n int) {
+	backoff := BatchBackoff
+
+	for retry := 0; ; retry++ {
+		err := b.send(batch, n)
+		if err == nil {
+			return
+		}
+
+		herr, ok := err.(*httpError)
+		if (ok && !herr.retryable()) || retry >= b.retries {
+			b.logger.Errorf("Dropping %d events not sent to %s: %s", n, b.name, err)
+			b.drop(n)
+			return
+		}
+
+		b.logger.Errorf("Failed to send events to %s, retrying in %s: %s", b.name, backoff, err)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > batchMaxBackoff {
+			backoff = batchMaxBackoff
+		}
+	}
+}
+
+// run starts the routine sending queued events
+func (b *batcher) run() {
+	b.Add(1)
+	go func() {
+		defer b.Done()
+
+		batch := new(bytes.Buffer)
+		n := 0
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		flush := func() {
+			if n > 0 {
+				b.flush(batch.Bytes(), n)
+				batch.Reset()
+				n = 0
+			}
+		}
+
+		add := func(data []byte) {
+			batch.Write(data)
+			if n++; n >= b.size {
+				flush()
+			}
+		}
+
+		for {
+			select {
+			case data := <-b.queue:
+				add(data)
+			case <-ticker.C:
+				flush()
+			case <-b.ctx.Done():
+				// sending the events still queued
+				for len(b.queue) > 0 {
+					add(<-b.queue)
+				}
+				flush()
+				return
+			}
+		}
+	}()
+}
+
+// close sends the events queued and stops the routine, it
+// may block while the server is retried
+func (b *batcher) close() {
+	b.cancel()
+	b.Wait()
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/crypto/data"
@@ -31,19 +33,46 @@ func (c *Client) HasConnectionSettings() bool {
 	return c.Proto != "" && c.Host != "" && c.UUID != "" && c.Key != ""
 }
 
+// hostname returns Host without the brackets an IPv6 address may be enclosed in
+func (c *Client) hostname() string {
+	return strings.TrimSuffix(strings.TrimPrefix(c.Host, "["), "]")
+}
+
 // ManagerIP returns the IP address of the manager if any, returns nil otherwise
 func (c *Client) ManagerIP() net.IP {
-	if ip := net.ParseIP(c.Host); ip != nil {
-		return ip
+	if ips := c.ManagerIPs(); len(ips) > 0 {
+		return ips[0]
 	}
+	return nil
+}
 
-	if ips, err := net.LookupIP(c.Host); err == nil {
-		return ips[0]
+// ManagerIPs returns all the IP addresses (IPv4 and IPv6) of the manager
+func (c *Client) ManagerIPs() []net.IP {
+	if ip := net.ParseIP(c.hostname()); ip != nil {
+		return []net.IP{ip}
+	}
+
+	if ips, err := net.LookupIP(c.hostname()); err == nil {
+		return ips
 	}
 
 	return nil
 }
 
+// Address returns the address (host:port) of the manager, Port
+// is omitted if not set. IPv6 addresses are enclosed in brackets.
+func (c *Client) Address() string {
+	if c.Port != 0 {
+		return net.JoinHostPort(c.hostname(), strconv.Itoa(c.Port))
+	}
+
+	if ip := net.ParseIP(c.hostname()); ip != nil && ip.To4() == nil {
+		return fmt.Sprintf("[%s]", c.hostname())
+	}
+
+	return c.hostname()
+}
+
 func (c *Client) DialContext(ctx context.Context, network, addr string) (con net.Conn, err error) {
 	dialer := net.Dialer{
 		Timeout:   30 * time.Second,
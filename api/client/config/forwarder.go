@@ -8,11 +8,86 @@ import (
 type ForwarderLogging struct {
 	Dir              string        `json:"dir,omitempty" toml:"dir" comment:"Directory used to store logs"`
 	RotationInterval time.Duration `json:"rotation-interval,omitempty" toml:"rotation-interval" comment:"Logfile rotation interval"`
+	RotationSize     int64         `json:"rotation-size,omitempty" toml:"rotation-size" comment:"Rotate logfile when its size reaches this value in bytes\n (0 disables size based rotation)"`
+	MaxSize          int64         `json:"max-size,omitempty" toml:"max-size" comment:"Maximum disk space in bytes taken by local logs, older logs are deleted first\n (0 defaults to 1GB)"`
+	MaxAge           time.Duration `json:"max-age,omitempty" toml:"max-age" comment:"Local logs older than this are deleted (0 keeps logs whatever their age)"`
+	Compress         bool          `json:"compress,omitempty" toml:"compress" comment:"Compress all rotated logs, by default the latest rotated log is kept uncompressed"`
+}
+
+// ForwarderProjection structure to encode field projection applied to forwarded events
+type ForwarderProjection struct {
+	Enable      bool     `json:"enable,omitempty" toml:"enable" comment:"Enable field projection of forwarded events"`
+	Fields      []string `json:"fields,omitempty" toml:"fields" comment:"EventData/UserData fields to forward (a core set of fields is always forwarded)\n an empty list means all fields are forwarded"`
+	StripATTACK bool     `json:"strip-attack,omitempty" toml:"strip-attack" comment:"Strip ATT&CK information from detections"`
+	StripExtra  bool     `json:"strip-extra,omitempty" toml:"strip-extra" comment:"Strip redundant ETW extended data"`
+}
+
+// ForwarderSyslog structure to encode syslog output configuration of the forwarder
+type ForwarderSyslog struct {
+	Enable   bool   `json:"enable,omitempty" toml:"enable" comment:"Send alerts to a syslog server"`
+	Proto    string `json:"proto,omitempty" toml:"proto" comment:"Protocol used to reach syslog server (udp, tcp or tls)"`
+	Address  string `json:"address,omitempty" toml:"address" comment:"Address (host:port) of the syslog server"`
+	Format   string `json:"format,omitempty" toml:"format" comment:"Format alerts are rendered in (cef for ArcSight or leef for QRadar)"`
+	Facility string `json:"facility,omitempty" toml:"facility" comment:"Syslog facility of the messages (kern, user, daemon, auth, local0 ... local7)"`
+	Unsafe   bool   `json:"unsafe,omitempty" toml:"unsafe" comment:"Do not verify syslog server certificate (tls only)"`
+}
+
+// SplunkMapping maps the events of a channel to a Splunk index and sourcetype
+type SplunkMapping struct {
+	Channel    string `json:"channel" toml:"channel" comment:"Channel of the events mapped"`
+	Index      string `json:"index,omitempty" toml:"index" comment:"Index events are sent to (empty for the default index)"`
+	Sourcetype string `json:"sourcetype,omitempty" toml:"sourcetype" comment:"Sourcetype of the events (empty for the default sourcetype)"`
+}
+
+// ForwarderFile structure to encode file output configuration of the forwarder
+type ForwarderFile struct {
+	Enable       bool   `json:"enable,omitempty" toml:"enable" comment:"Write events to a local file (one JSON event per line)"`
+	Path         string `json:"path,omitempty" toml:"path" comment:"Path of the file"`
+	AlertsOnly   bool   `json:"alerts-only,omitempty" toml:"alerts-only" comment:"Only write alerts"`
+	RotationSize int64  `json:"rotation-size,omitempty" toml:"rotation-size" comment:"Rotate (and compress) file when its size reaches this value in bytes"`
+}
+
+// ForwarderSplunk structure to encode Splunk HTTP Event Collector output configuration of the forwarder
+type ForwarderSplunk struct {
+	Enable        bool             `json:"enable,omitempty" toml:"enable" comment:"Send events to a Splunk HTTP Event Collector"`
+	URL           string           `json:"url,omitempty" toml:"url" comment:"URL of the HTTP Event Collector (i.e. https://splunk.corp:8088)"`
+	Token         string           `json:"token,omitempty" toml:"token" comment:"HTTP Event Collector token"`
+	AlertsOnly    bool             `json:"alerts-only,omitempty" toml:"alerts-only" comment:"Only send alerts"`
+	Index         string           `json:"index,omitempty" toml:"index" comment:"Default index (empty for the default index of the token)"`
+	Sourcetype    string           `json:"sourcetype,omitempty" toml:"sourcetype" comment:"Default sourcetype"`
+	Mappings      []*SplunkMapping `json:"mappings,omitempty" toml:"mappings" comment:"Index and sourcetype of the events of a channel"`
+	BatchSize     int              `json:"batch-size,omitempty" toml:"batch-size" comment:"Maximum number of events sent in a single request"`
+	FlushInterval time.Duration    `json:"flush-interval,omitempty" toml:"flush-interval" comment:"Maximum time events are kept before being sent"`
+	MaxRetries    int              `json:"max-retries,omitempty" toml:"max-retries" comment:"Maximum number of retries (with exponential backoff) before dropping events"`
+	Unsafe        bool             `json:"unsafe,omitempty" toml:"unsafe" comment:"Do not verify HTTP Event Collector certificate"`
+}
+
+// ForwarderElastic structure to encode Elasticsearch output configuration of the forwarder
+type ForwarderElastic struct {
+	Enable          bool          `json:"enable,omitempty" toml:"enable" comment:"Index events in Elasticsearch"`
+	URL             string        `json:"url,omitempty" toml:"url" comment:"URL of the Elasticsearch cluster (i.e. https://elastic.corp:9200)"`
+	Username        string        `json:"username,omitempty" toml:"username" comment:"Username for basic authentication"`
+	Password        string        `json:"password,omitempty" toml:"password" comment:"Password for basic authentication"`
+	APIKey          string        `json:"api-key,omitempty" toml:"api-key" comment:"API key (base64 encoded id:key) used instead of basic authentication"`
+	AlertsOnly      bool          `json:"alerts-only,omitempty" toml:"alerts-only" comment:"Only index alerts"`
+	Index           string        `json:"index,omitempty" toml:"index" comment:"Name, or prefix if daily indices are used, of the index events are sent to"`
+	DailyIndices    bool          `json:"daily-indices,omitempty" toml:"daily-indices" comment:"Index events in daily indices (index-YYYY.MM.DD)"`
+	InstallTemplate bool          `json:"install-template,omitempty" toml:"install-template" comment:"Install the index template mapping WHIDS fields"`
+	ILMPolicy       string        `json:"ilm-policy,omitempty" toml:"ilm-policy" comment:"Index lifecycle management policy set in index template (empty for none)"`
+	BatchSize       int           `json:"batch-size,omitempty" toml:"batch-size" comment:"Maximum number of events sent in a single bulk request"`
+	FlushInterval   time.Duration `json:"flush-interval,omitempty" toml:"flush-interval" comment:"Maximum time events are kept before being sent"`
+	MaxRetries      int           `json:"max-retries,omitempty" toml:"max-retries" comment:"Maximum number of retries (with exponential backoff) before dropping events"`
+	Unsafe          bool          `json:"unsafe,omitempty" toml:"unsafe" comment:"Do not verify Elasticsearch certificate"`
 }
 
 // Forwarder config structure definition
 type Forwarder struct {
-	Local   bool             `json:"local,omitempty" toml:"local" comment:"If forwarder is local (this setting equals true)\n neither alerts nor dumps will be forwarded to manager"`
-	Client  Client           `json:"manager,omitempty" toml:"manager" comment:"Configure connection to the manager"`
-	Logging ForwarderLogging `json:"logging,omitempty" toml:"logging" comment:"Forwarder's logging configuration"`
+	Local      bool                `json:"local,omitempty" toml:"local" comment:"If forwarder is local (this setting equals true)\n neither alerts nor dumps will be forwarded to manager"`
+	Client     Client              `json:"manager,omitempty" toml:"manager" comment:"Configure connection to the manager"`
+	Logging    ForwarderLogging    `json:"logging,omitempty" toml:"logging" comment:"Forwarder's logging configuration"`
+	Projection ForwarderProjection `json:"projection,omitempty" toml:"projection" comment:"Field projection applied to events before being forwarded"`
+	File       ForwarderFile       `json:"file,omitempty" toml:"file" comment:"File output, events written in addition to being forwarded"`
+	Syslog     ForwarderSyslog     `json:"syslog,omitempty" toml:"syslog" comment:"Syslog output of alerts rendered in CEF or LEEF (ArcSight, QRadar ...)"`
+	Splunk     ForwarderSplunk     `json:"splunk,omitempty" toml:"splunk" comment:"Splunk HTTP Event Collector output"`
+	Elastic    ForwarderElastic    `json:"elasticsearch,omitempty" toml:"elasticsearch" comment:"Elasticsearch bulk indexing output"`
 }
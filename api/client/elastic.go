@@ -0,0 +1,329 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// ElasticBulkPath path of Elasticsearch bulk API
+	ElasticBulkPath = "/_bulk"
+	// ElasticTemplatePath path of Elasticsearch index template API
+	ElasticTemplatePath = "/_index_template/"
+
+	elasticOutput = "elasticsearch"
+	// format of the suffix of daily indices
+	elasticDayFormat = "2006.01.02"
+	// maximum size of the responses read
+	elasticMaxResponse = 16 * 1024 * 1024
+)
+
+// elasticGene holds Gene detection information under a stable
+// mapping, whatever the engine version the event comes from
+type elasticGene struct {
+	Alert       bool     `json:"alert"`
+	Criticality int      `json:"criticality"`
+	Signatures  []string `json:"signatures"`
+	ATTACK      []string `json:"attack"`
+}
+
+// elasticDoc is the document indexed for an event
+type elasticDoc struct {
+	Timestamp time.Time        `json:"@timestamp"`
+	Host      string           `json:"host"`
+	Channel   string           `json:"channel"`
+	EventID   int64            `json:"event_id"`
+	Gene      elasticGene      `json:"gene"`
+	Event     event.InnerEvent `json:"Event"`
+}
+
+// elasticAction is the action line preceding a document in a bulk request
+type elasticAction struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id,omitempty"`
+	} `json:"index"`
+}
+
+// elasticBulkResponse is the response to a bulk request
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+// ElasticTemplate returns the index template mapping the fields set by
+// the Elasticsearch output. Strings are mapped as keywords so that event
+// fields are aggregatable without any mapping conflict across channels.
+func ElasticTemplate(patterns []string, ilmPolicy string) map[string]interface{} {
+	settings := map[string]interface{}{}
+	if ilmPolicy != "" {
+		settings["index.lifecycle.name"] = ilmPolicy
+	}
+
+	keyword := map[string]interface{}{"type": "keyword"}
+
+	return map[string]interface{}{
+		"index_patterns": patterns,
+		"priority":       200,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings_as_keywords": map[string]interface{}{
+							"match_mapping_type": "string",
+							"mapping":            map[string]interface{}{"type": "keyword", "ignore_above": 8192},
+						},
+					},
+				},
+				"properties": map[string]interface{}{
+					"@timestamp": map[string]interface{}{"type": "date"},
+					"host":       keyword,
+					"channel":    keyword,
+					"event_id":   map[string]interface{}{"type": "long"},
+					"gene": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"alert":       map[string]interface{}{"type": "boolean"},
+							"criticality": map[string]interface{}{"type": "integer"},
+							"signatures":  keyword,
+							"attack":      keyword,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Elastic indexes events, with the bulk API, in Elasticsearch
+type Elastic struct {
+	*batcher
+	config    *config.ForwarderElastic
+	url       string
+	client    *http.Client
+	templated bool
+}
+
+// NewElastic creates a new Elasticsearch output from configuration
+func NewElastic(ctx context.Context, c *config.ForwarderElastic, l *golog.Logger) (e *Elastic, err error) {
+	var u *url.URL
+
+	if u, err = url.Parse(c.URL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad Elasticsearch URL: %s", c.URL)
+	}
+
+	if c.Index == "" || strings.ToLower(c.Index) != c.Index {
+		return nil, fmt.Errorf("index name must be set and lowercase")
+	}
+
+	e = &Elastic{
+		config: c,
+		url:    strings.TrimSuffix(c.URL, "/"),
+		client: newHTTPClient(c.Unsafe),
+		// nothing to install
+		templated: !c.InstallTemplate,
+	}
+
+	if e.batcher, err = newBatcher(ctx, elasticOutput, c.BatchSize, c.FlushInterval, c.MaxRetries, l); err != nil {
+		return nil, err
+	}
+	e.batcher.send = e.bulk
+
+	return
+}
+
+// IndexName returns the name of the index an event
+// timestamped with ts is sent to
+func (e *Elastic) IndexName(ts time.Time) string {
+	if e.config.DailyIndices {
+		return fmt.Sprintf("%s-%s", e.config.Index, ts.UTC().Format(elasticDayFormat))
+	}
+	return e.config.Index
+}
+
+// indexPatterns returns the patterns of the indices events are sent to
+func (e *Elastic) indexPatterns() []string {
+	if e.config.DailyIndices {
+		return []string{e.config.Index + "-*"}
+	}
+	return []string{e.config.Index}
+}
+
+func (e *Elastic) document(ee *event.EdrEvent) *elasticDoc {
+	signatures, attack, crit := detectionInfo(ee)
+
+	return &elasticDoc{
+		Timestamp: ee.Timestamp().UTC(),
+		Host:      ee.Computer(),
+		Channel:   ee.Channel(),
+		EventID:   ee.EventID(),
+		Gene: elasticGene{
+			Alert:       ee.IsDetection(),
+			Criticality: crit,
+			Signatures:  signatures,
+			ATTACK:      attack,
+		},
+		Event: ee.Event,
+	}
+}
+
+// Send queues an event to be indexed, event is serialized before this
+// function returns. Events are dropped if the queue is full.
+func (e *Elastic) Send(ee *event.EdrEvent) (err error) {
+	var action, doc []byte
+
+	if e.config.AlertsOnly && !ee.IsDetection() {
+		return
+	}
+
+	a := elasticAction{}
+	a.Index.Index = e.IndexName(ee.Timestamp())
+	// documents get an ID unique per agent so that
+	// retried bulk requests do not duplicate them
+	if d := ee.Event.EdrData; d != nil && d.Event.Stream != "" {
+		a.Index.ID = fmt.Sprintf("%s-%d", d.Event.Stream, d.Event.Sequence)
+	}
+
+	enc := event.GetEncoder()
+	defer event.PutEncoder(enc)
+
+	// action and document are both encoded followed by a newline,
+	// action is small and has to be copied before encoding document
+	if action, err = enc.Encode(a); err != nil {
+		return
+	}
+	action = append([]byte(nil), action...)
+
+	if doc, err = enc.Encode(e.document(ee)); err != nil {
+		return
+	}
+
+	b := make([]byte, 0, len(action)+len(doc))
+	b = append(append(b, action...), doc...)
+
+	return e.add(b)
+}
+
+func (e *Elastic) request(method, path, contentType string, body []byte) (resp *http.Response, err error) {
+	var req *http.Request
+
+	if req, err = http.NewRequest(method, e.url+path, bytes.NewReader(body)); err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	switch {
+	case e.config.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.config.APIKey)
+	case e.config.Username != "":
+		req.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+
+	return e.client.Do(req)
+}
+
+// installTemplate installs the index template, named after the index
+func (e *Elastic) installTemplate() (err error) {
+	var body []byte
+	var resp *http.Response
+
+	if body, err = utils.Json(ElasticTemplate(e.indexPatterns(), e.config.ILMPolicy)); err != nil {
+		return
+	}
+
+	if resp, err = e.request(http.MethodPut, ElasticTemplatePath+e.config.Index, "application/json", body); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpError{elasticOutput, resp.StatusCode}
+	}
+
+	e.templated = true
+	return
+}
+
+// bulk sends a batch of n events with the bulk API, only failures of
+// the whole batch or of some documents because of cluster load are retried
+func (e *Elastic) bulk(batch []byte, n int) (err error) {
+	var resp *http.Response
+	var body []byte
+
+	if !e.templated {
+		if err = e.installTemplate(); err != nil {
+			return fmt.Errorf("failed to install index template: %w", err)
+		}
+	}
+
+	if resp, err = e.request(http.MethodPost, ElasticBulkPath, "application/x-ndjson", batch); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpError{elasticOutput, resp.StatusCode}
+	}
+
+	if body, err = io.ReadAll(io.LimitReader(resp.Body, elasticMaxResponse)); err != nil {
+		return
+	}
+
+	r := elasticBulkResponse{}
+	if err = json.Unmarshal(body, &r); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+
+	if !r.Errors {
+		return
+	}
+
+	failed := 0
+	var reason json.RawMessage
+	for _, item := range r.Items {
+		for _, res := range item {
+			if res.Status == http.StatusTooManyRequests || res.Status >= 500 {
+				// documents have IDs so that the batch can be sent again
+				return &httpError{elasticOutput, res.Status}
+			}
+			if res.Status >= 300 {
+				failed++
+				reason = res.Error
+			}
+		}
+	}
+
+	if failed > 0 {
+		e.logger.Errorf("Elasticsearch output: %d documents rejected: %s", failed, reason)
+		e.drop(failed)
+	}
+
+	return
+}
+
+// Run starts the routine sending queued events
+func (e *Elastic) Run() {
+	e.run()
+}
+
+// Close sends the events queued and stops the output, it may
+// block while Elasticsearch is retried
+func (e *Elastic) Close() {
+	e.close()
+}
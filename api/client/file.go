@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/fsutil/logfile"
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	fileOutput = "file"
+	// events written at once
+	fileBatchSize = 100
+	// maximum time events are kept before being written
+	fileFlushInterval = time.Second
+)
+
+// File writes events, one JSON event per line, to a local file
+type File struct {
+	*batcher
+	config *config.ForwarderFile
+	writer io.WriteCloser
+}
+
+// NewFile creates a new File output from configuration
+func NewFile(ctx context.Context, c *config.ForwarderFile, l *golog.Logger) (f *File, err error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("missing file path")
+	}
+
+	f = &File{config: c}
+
+	if err = os.MkdirAll(filepath.Dir(c.Path), utils.DefaultFilePerm); err != nil {
+		return nil, err
+	}
+
+	if c.RotationSize > 0 {
+		f.writer, err = logfile.OpenSizeRotateLogFile(c.Path, utils.DefaultFilePerm, c.RotationSize)
+	} else {
+		f.writer, err = os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, utils.DefaultFilePerm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// write errors are not retried
+	if f.batcher, err = newBatcher(ctx, fileOutput, fileBatchSize, fileFlushInterval, 0, l); err != nil {
+		f.writer.Close()
+		return nil, err
+	}
+	f.batcher.send = f.write
+
+	return
+}
+
+// Send queues an event to be written, event is serialized before this
+// function returns. Events are dropped if the queue is full.
+func (f *File) Send(e *event.EdrEvent) (err error) {
+	var b []byte
+
+	if f.config.AlertsOnly && !e.IsDetection() {
+		return
+	}
+
+	enc := event.GetEncoder()
+	defer event.PutEncoder(enc)
+
+	if b, err = enc.Encode(e); err != nil {
+		return
+	}
+
+	// encoded data is only valid until encoder is put back
+	return f.add(append(make([]byte, 0, len(b)), b...))
+}
+
+func (f *File) write(batch []byte, n int) (err error) {
+	_, err = f.writer.Write(batch)
+	return
+}
+
+// Run starts the routine writing queued events
+func (f *File) Run() {
+	f.run()
+}
+
+// Close writes the events queued and closes the file
+func (f *File) Close() {
+	f.close()
+	f.writer.Close()
+}
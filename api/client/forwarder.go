@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/fileutils"
@@ -17,6 +18,7 @@ import (
 	"github.com/0xrawsec/golang-utils/fsutil/logfile"
 	"github.com/0xrawsec/golog"
 	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 )
 
@@ -29,14 +31,32 @@ const (
 	MinRotationInterval = time.Minute
 )
 
+var (
+	// OutputReportInterval interval at which events dropped by outputs are reported
+	OutputReportInterval = time.Minute
+)
+
 // Forwarder structure definition
 type Forwarder struct {
+	// time spent sending events, first field to be 64-bit aligned
+	// for atomic operations
+	busy int64
+	// sequence number of the last event piped
+	sequence uint64
+	// set to 1 to send piped events at next iteration
+	flush int32
+	// sequence stream identifier
+	stream string
 	sync.Mutex
 	sync.WaitGroup
-	ctx       context.Context
-	cancel    context.CancelFunc
-	fwdConfig *config.Forwarder
-	logfile   logfile.LogFile
+	ctx        context.Context
+	cancel     context.CancelFunc
+	fwdConfig  *config.Forwarder
+	logfile    logfile.LogFile
+	projection *event.Projection
+	outputs    []Output
+	// events dropped per output at last report
+	dropped map[string]uint64
 
 	Logger      *golog.Logger
 	Client      *ManagerClient
@@ -67,6 +87,22 @@ func NewForwarder(ctx context.Context, c *config.Forwarder, l *golog.Logger) (*F
 		EventTresh: 500,
 		Pipe:       new(bytes.Buffer),
 		Local:      c.Local,
+		stream:     utils.UnsafeUUID().String(),
+	}
+
+	if c.Projection.Enable {
+		co.projection = event.NewProjection(c.Projection.Fields)
+		co.projection.StripATTACK = c.Projection.StripATTACK
+		co.projection.StripExtra = c.Projection.StripExtra
+	}
+
+	if co.outputs, err = NewOutputs(cctx, c, l); err != nil {
+		return nil, err
+	}
+
+	co.dropped = make(map[string]uint64)
+	for _, o := range co.outputs {
+		o.Run()
 	}
 
 	if !co.Local {
@@ -117,17 +153,48 @@ func (f *Forwarder) ArchiveLogs() {
 }
 
 // PipeEvent pipes an event to be sent through the forwarder
-func (f *Forwarder) PipeEvent(event interface{}) (err error) {
+func (f *Forwarder) PipeEvent(e interface{}) (err error) {
 	var b []byte
 
 	f.Lock()
 	defer f.Unlock()
 
-	if b, err = utils.Json(event); err != nil {
+	if ee, ok := e.(*event.EdrEvent); ok {
+		// sequence numbers allow the manager to detect lost
+		// and duplicated events
+		if ee.Event.EdrData == nil {
+			ee.InitEdrData()
+		}
+		f.sequence++
+		ee.Event.EdrData.Event.Stream = f.stream
+		ee.Event.EdrData.Event.Sequence = f.sequence
+
+		// we apply projection on EDR events only
+		if f.projection != nil {
+			ee = f.projection.Project(ee)
+			e = ee
+			// outputs do not keep references to events so projected
+			// event can be released once encoded
+			defer f.projection.Release(ee)
+		}
+
+		// events are fanned out to the other outputs, failures
+		// are accounted by outputs and reported periodically
+		for _, o := range f.outputs {
+			if err := o.Send(ee); err != nil {
+				f.Logger.Debugf("Failed to send event to %s output: %s", o.Name(), err)
+			}
+		}
+	}
+
+	// encoding buffers are reused as we pipe every single event
+	enc := event.GetEncoder()
+	defer event.PutEncoder(enc)
+
+	if b, err = enc.Encode(e); err != nil {
 		return err
 	}
 
-	b = append(b, '\n')
 	if _, err = f.Pipe.Write(b); err != nil {
 		return
 	}
@@ -137,14 +204,32 @@ func (f *Forwarder) PipeEvent(event interface{}) (err error) {
 	return
 }
 
+// Flush makes the events piped so far sent at next forwarding
+// iteration, regardless of the event and time thresholds
+func (f *Forwarder) Flush() {
+	atomic.StoreInt32(&f.flush, 1)
+}
+
+// Stream returns the identifier of the stream of events forwarded
+func (f *Forwarder) Stream() string {
+	return f.stream
+}
+
+// Sequence returns the sequence number of the last event piped
+func (f *Forwarder) Sequence() uint64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.sequence
+}
+
 // Save save the piped events to the disks
 func (f *Forwarder) Save() (err error) {
 	f.Logger.Debugf("Collector saved logs to be sent later on")
 
 	// Clean queued files if needed
-	if f.DiskSpaceQueue() > DiskSpaceThreshold {
+	if max := f.maxDiskSpace(); f.DiskSpaceQueue() > max {
 		f.Logger.Infof("Disk space taken by queued events reached %dMB threshold, need cleanup",
-			DiskSpaceThreshold/logfile.MB)
+			max/logfile.MB)
 		if err := f.CleanOlderQueued(); err != nil {
 			f.Logger.Errorf("Error attempting to remove older queue file: %s", err)
 		}
@@ -160,7 +245,82 @@ func (f *Forwarder) Save() (err error) {
 			return
 		}
 	}
-	_, err = f.logfile.Write(f.Pipe.Bytes())
+	if _, err = f.logfile.Write(f.Pipe.Bytes()); err != nil {
+		return
+	}
+
+	// size based rotation
+	if rs := f.fwdConfig.Logging.RotationSize; rs > 0 {
+		if fi, err := os.Stat(f.logfile.Path()); err == nil && fi.Size() >= rs {
+			f.Logger.Infof("Logfile reached %d bytes, rotating", fi.Size())
+			return f.logfile.Rotate()
+		}
+	}
+
+	return
+}
+
+// maxDiskSpace returns the maximum disk space local logs can take
+func (f *Forwarder) maxDiskSpace() int64 {
+	if f.fwdConfig.Logging.MaxSize > 0 {
+		return f.fwdConfig.Logging.MaxSize
+	}
+	return DiskSpaceThreshold
+}
+
+// EnforceRetention enforces local logs retention policy. Logs older than
+// the maximum age configured are deleted, rotated logs are compressed if
+// configured and older logs are deleted until disk space taken by local
+// logs is under the configured limit. The logfile in use is never affected.
+func (f *Forwarder) EnforceRetention() (err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	c := f.fwdConfig.Logging
+	current, _ := filepath.Abs(f.LogfilePath())
+
+	files := make([]os.FileInfo, 0)
+	for wi := range fswalker.Walk(c.Dir) {
+		files = append(files, wi.Files...)
+	}
+
+	for _, fi := range files {
+		fp, _ := filepath.Abs(filepath.Join(c.Dir, fi.Name()))
+
+		// file in use or being compressed
+		if fp == current || strings.HasSuffix(fp, ".part") {
+			continue
+		}
+
+		if c.MaxAge > 0 && time.Since(fi.ModTime()) > c.MaxAge {
+			f.Logger.Infof("Deleting local log older than %s: %s", c.MaxAge, fp)
+			if err := os.Remove(fp); err != nil {
+				f.Logger.Errorf("Failed to delete local log: %s", err)
+			}
+			continue
+		}
+
+		// logfile rotation may happen concurrently so we don't fail
+		if c.Compress && !strings.HasSuffix(fp, ".gz") {
+			f.Logger.Infof("Compressing local log: %s", fp)
+			if err := fileutils.GzipFile(fp); err != nil {
+				f.Logger.Errorf("Failed to compress local log: %s", err)
+			}
+		}
+	}
+
+	for max := f.maxDiskSpace(); f.DiskSpaceQueue() > max; {
+		older := f.olderQueued()
+		if older == "" {
+			return
+		}
+
+		f.Logger.Infof("Disk space taken by local logs above %dMB, deleting: %s", max/logfile.MB, older)
+		if err = os.Remove(older); err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -174,34 +334,38 @@ func (f *Forwarder) HasQueuedEvents() bool {
 	return false
 }
 
-// CleanOlderQueued cleans up the older queue file
-func (f *Forwarder) CleanOlderQueued() error {
-	var older string
+// olderQueued returns the path of the older queue file which is not
+// the logfile in use, it returns an empty string if there is none
+func (f *Forwarder) olderQueued() (older string) {
 	var olderTime time.Time
-	empty := time.Time{}
+
+	absLogfile, _ := filepath.Abs(f.LogfilePath())
 	for wi := range fswalker.Walk(f.fwdConfig.Logging.Dir) {
 		for _, fi := range wi.Files {
-			// initialization
-			if olderTime == empty {
-				olderTime = fi.ModTime()
-				older = filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
+			fp, _ := filepath.Abs(filepath.Join(f.fwdConfig.Logging.Dir, fi.Name()))
+			// prevent from deleting the current file we are working on
+			if fp == absLogfile {
 				continue
 			}
 			// check if we have an older file
-			if fi.ModTime().Before(olderTime) {
+			if older == "" || fi.ModTime().Before(olderTime) {
 				olderTime = fi.ModTime()
-				older = filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
+				older = fp
 			}
 		}
 	}
-	absOlder, _ := filepath.Abs(older)
-	absLogfile, _ := filepath.Abs(f.LogfilePath())
-	// prevent from deleting the current file we are working on
-	if absLogfile != absOlder {
-		f.Logger.Infof("Attempt to delete older queue file to make more space: %s", absOlder)
-		return os.Remove(absOlder)
+
+	return
+}
+
+// CleanOlderQueued cleans up the older queue file
+func (f *Forwarder) CleanOlderQueued() error {
+	older := f.olderQueued()
+	if older == "" {
+		return nil
 	}
-	return nil
+	f.Logger.Infof("Attempt to delete older queue file to make more space: %s", older)
+	return os.Remove(older)
 }
 
 // DiskSpaceQueue compute the disk space (in bytes) taken by queued events
@@ -294,6 +458,12 @@ func (f *Forwarder) ProcessQueue() {
 	}
 }
 
+// Busy returns the time spent by the forwarder processing and
+// sending events since it started
+func (f *Forwarder) Busy() time.Duration {
+	return time.Duration(atomic.LoadInt64(&f.busy))
+}
+
 // Reset resets the forwarder
 func (f *Forwarder) Reset() {
 	f.Pipe.Reset()
@@ -326,6 +496,26 @@ func (f *Forwarder) Collect() {
 	}
 }
 
+// OutputsDropped returns the number of events dropped per output
+func (f *Forwarder) OutputsDropped() (dropped map[string]uint64) {
+	dropped = make(map[string]uint64)
+	for _, o := range f.outputs {
+		dropped[o.Name()] = o.Dropped()
+	}
+	return
+}
+
+// reportDropped logs the number of events dropped by
+// outputs since last report
+func (f *Forwarder) reportDropped() {
+	for name, n := range f.OutputsDropped() {
+		if n > f.dropped[name] {
+			f.Logger.Errorf("Output %s dropped %d events", name, n-f.dropped[name])
+		}
+		f.dropped[name] = n
+	}
+}
+
 // Run starts the Forwarder worker function
 func (f *Forwarder) Run() {
 	f.Add(1)
@@ -334,7 +524,10 @@ func (f *Forwarder) Run() {
 		defer f.Done()
 
 		timer := time.Now()
+		report := time.Now()
 		for f.ctx.Err() == nil {
+			start := time.Now()
+
 			// We have queued events so we try to send them before sending pending events
 			// We check if server is up not to close the current logfile if not needed
 			if f.HasQueuedEvents() {
@@ -342,7 +535,8 @@ func (f *Forwarder) Run() {
 			}
 
 			// Sending piped events
-			if f.EventsPiped >= f.EventTresh || time.Now().After(timer.Add(f.TimeTresh)) || f.Local {
+			flush := atomic.CompareAndSwapInt32(&f.flush, 1, 0)
+			if flush || f.EventsPiped >= f.EventTresh || time.Now().After(timer.Add(f.TimeTresh)) || f.Local {
 				// Send out events if there are pending events
 				if f.EventsPiped > 0 {
 					f.Collect()
@@ -351,6 +545,13 @@ func (f *Forwarder) Run() {
 				timer = time.Now()
 			}
 
+			atomic.AddInt64(&f.busy, int64(time.Since(start)))
+
+			if time.Since(report) >= OutputReportInterval {
+				f.reportDropped()
+				report = time.Now()
+			}
+
 			time.Sleep(f.Sleep)
 		}
 	}()
@@ -379,6 +580,12 @@ func (f *Forwarder) Close() {
 		f.logfile.Close()
 	}
 
+	// queued events are sent before closing
+	for _, o := range f.outputs {
+		o.Close()
+	}
+	f.reportDropped()
+
 	// Close idle connections if not local
 	if !f.Local {
 		defer f.Client.Close()
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+// Output is a destination the events piped to the forwarder are fanned
+// out to, in addition to the manager (or local logs). Every output has
+// its own queue so that a failing output does not affect the others.
+type Output interface {
+	// Name returns the name of the output
+	Name() string
+	// Run starts the routine sending queued events
+	Run()
+	// Send queues an event, it must neither block nor keep a reference
+	// to the event. Events not handled by the output are ignored.
+	Send(*event.EdrEvent) error
+	// Dropped returns the number of events which could not be sent
+	Dropped() uint64
+	// Close sends the events queued and stops the output
+	Close()
+}
+
+// NewOutputs creates the outputs enabled in forwarder configuration
+func NewOutputs(ctx context.Context, c *config.Forwarder, l *golog.Logger) (outputs []Output, err error) {
+	var o Output
+
+	outputs = make([]Output, 0)
+
+	closeAll := func() {
+		for _, o := range outputs {
+			o.Close()
+		}
+	}
+
+	add := func(name string, new func() (Output, error)) error {
+		if o, err = new(); err != nil {
+			closeAll()
+			return fmt.Errorf("failed to initialize %s output: %w", name, err)
+		}
+		outputs = append(outputs, o)
+		return nil
+	}
+
+	if c.File.Enable {
+		if err = add(fileOutput, func() (Output, error) { return NewFile(ctx, &c.File, l) }); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Syslog.Enable {
+		if err = add("syslog", func() (Output, error) { return NewSyslog(ctx, &c.Syslog, l) }); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Splunk.Enable {
+		if err = add(splunkOutput, func() (Output, error) { return NewSplunk(ctx, &c.Splunk, l) }); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Elastic.Enable {
+		if err = add(elasticOutput, func() (Output, error) { return NewElastic(ctx, &c.Elastic, l) }); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// SplunkHECPath path of the HTTP Event Collector event endpoint
+	SplunkHECPath = "/services/collector/event"
+
+	splunkOutput = "splunk"
+)
+
+// hecEvent is an event in HTTP Event Collector format
+type hecEvent struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Sourcetype string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      *event.EdrEvent `json:"event"`
+}
+
+// Splunk sends events, in batches, to a Splunk HTTP Event Collector
+type Splunk struct {
+	*batcher
+	config   *config.ForwarderSplunk
+	url      string
+	client   *http.Client
+	mappings map[string]*config.SplunkMapping
+}
+
+// NewSplunk creates a new Splunk output from configuration
+func NewSplunk(ctx context.Context, c *config.ForwarderSplunk, l *golog.Logger) (s *Splunk, err error) {
+	var u *url.URL
+
+	if u, err = url.Parse(c.URL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad HTTP Event Collector URL: %s", c.URL)
+	}
+
+	if c.Token == "" {
+		return nil, fmt.Errorf("missing HTTP Event Collector token")
+	}
+
+	s = &Splunk{
+		config:   c,
+		url:      strings.TrimSuffix(c.URL, "/") + SplunkHECPath,
+		client:   newHTTPClient(c.Unsafe),
+		mappings: make(map[string]*config.SplunkMapping),
+	}
+
+	if s.batcher, err = newBatcher(ctx, splunkOutput, c.BatchSize, c.FlushInterval, c.MaxRetries, l); err != nil {
+		return nil, err
+	}
+	s.batcher.send = s.post
+
+	for _, m := range c.Mappings {
+		s.mappings[m.Channel] = m
+	}
+
+	return
+}
+
+// hecEvent converts an event into an HTTP Event Collector event,
+// index and sourcetype are mapped from event's channel
+func (s *Splunk) hecEvent(e *event.EdrEvent) *hecEvent {
+	he := &hecEvent{
+		Time:       float64(e.Timestamp().UnixNano()) / float64(time.Second),
+		Host:       e.Computer(),
+		Source:     e.Channel(),
+		Sourcetype: s.config.Sourcetype,
+		Index:      s.config.Index,
+		Event:      e,
+	}
+
+	if m, ok := s.mappings[e.Channel()]; ok {
+		if m.Index != "" {
+			he.Index = m.Index
+		}
+		if m.Sourcetype != "" {
+			he.Sourcetype = m.Sourcetype
+		}
+	}
+
+	return he
+}
+
+// Send queues an event to be sent, event is serialized before this
+// function returns. Events are dropped if the queue is full.
+func (s *Splunk) Send(e *event.EdrEvent) (err error) {
+	var b []byte
+
+	if s.config.AlertsOnly && !e.IsDetection() {
+		return
+	}
+
+	if b, err = utils.Json(s.hecEvent(e)); err != nil {
+		return
+	}
+
+	return s.add(b)
+}
+
+// post sends a batch of events to the HTTP Event Collector
+func (s *Splunk) post(batch []byte, n int) (err error) {
+	var req *http.Request
+	var resp *http.Response
+
+	if req, err = http.NewRequest(http.MethodPost, s.url, bytes.NewReader(batch)); err != nil {
+		return
+	}
+
+	req.Header.Set("Authorization", "Splunk "+s.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	if resp, err = s.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpError{splunkOutput, resp.StatusCode}
+	}
+
+	return
+}
+
+// Run starts the routine sending queued events
+func (s *Splunk) Run() {
+	s.run()
+}
+
+// Close sends the events queued and stops the output, it may
+// block while the HTTP Event Collector is retried
+func (s *Splunk) Close() {
+	s.close()
+}
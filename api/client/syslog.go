@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/golog"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// syslog output formats
+	SyslogFormatCEF  = "cef"
+	SyslogFormatLEEF = "leef"
+
+	// syslog output protocols
+	SyslogProtoUDP = "udp"
+	SyslogProtoTCP = "tcp"
+	SyslogProtoTLS = "tls"
+
+	syslogVendor  = "0xrawsec"
+	syslogProduct = "WHIDS"
+	syslogTag     = "whids"
+
+	syslogTimeout = 10 * time.Second
+	// maximum number of messages waiting to be sent
+	syslogQueueSize = 1000
+	// time during which no connection is attempted after a failure
+	syslogBackoff = time.Minute
+	// format of LEEF devTime attribute
+	leefTimeFormat = "Jan 02 2006 15:04:05.000 MST"
+)
+
+var (
+	// ProductVersion version reported in CEF and LEEF headers,
+	// it must be set by main package
+	ProductVersion = "unknown"
+
+	syslogFacilities = map[string]int{
+		"kern":   0,
+		"user":   1,
+		"daemon": 3,
+		"auth":   4,
+		"local0": 16,
+		"local1": 17,
+		"local2": 18,
+		"local3": 19,
+		"local4": 20,
+		"local5": 21,
+		"local6": 22,
+		"local7": 23,
+	}
+
+	// characters not allowed in extension keys
+	syslogKeyRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	leefValueEscaper = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+)
+
+// syslogSeverity converts a criticality into a syslog severity
+func syslogSeverity(criticality int) int {
+	switch {
+	case criticality >= 8:
+		// critical
+		return 2
+	case criticality >= 6:
+		// error
+		return 3
+	case criticality >= 4:
+		// warning
+		return 4
+	default:
+		// notice
+		return 5
+	}
+}
+
+// Syslog sends alerts, rendered in CEF or LEEF, to a syslog server
+type Syslog struct {
+	sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+	config   *config.ForwarderSyslog
+	logger   *golog.Logger
+	facility int
+	hostname string
+	queue    chan string
+	conn     net.Conn
+	failed   time.Time
+	// number of alerts which could not be sent, for atomic operations
+	dropped uint64
+}
+
+// NewSyslog creates a new Syslog output from configuration
+func NewSyslog(ctx context.Context, c *config.ForwarderSyslog, l *golog.Logger) (s *Syslog, err error) {
+	var ok bool
+
+	s = &Syslog{
+		config: c,
+		logger: l,
+		queue:  make(chan string, syslogQueueSize),
+	}
+
+	switch c.Proto {
+	case SyslogProtoUDP, SyslogProtoTCP, SyslogProtoTLS:
+	default:
+		return nil, fmt.Errorf("unknown syslog protocol: %s", c.Proto)
+	}
+
+	switch c.Format {
+	case SyslogFormatCEF, SyslogFormatLEEF:
+	default:
+		return nil, fmt.Errorf("unknown syslog format: %s", c.Format)
+	}
+
+	if s.facility, ok = syslogFacilities[strings.ToLower(c.Facility)]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", c.Facility)
+	}
+
+	if _, _, err = net.SplitHostPort(c.Address); err != nil {
+		return nil, fmt.Errorf("bad syslog server address: %w", err)
+	}
+
+	s.hostname, _ = os.Hostname()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	return
+}
+
+// Name implements Output interface
+func (s *Syslog) Name() string {
+	return "syslog"
+}
+
+// Dropped returns the number of alerts which could not be sent
+func (s *Syslog) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// syslogExtension returns the fields of an alert, sorted by key
+func syslogExtension(e *event.EdrEvent) (keys []string, fields map[string]string) {
+	fields = make(map[string]string)
+
+	for k, v := range e.Event.EventData {
+		fields[syslogKeyRe.ReplaceAllString(k, "_")] = fmt.Sprint(v)
+	}
+
+	if e.Event.EdrData != nil {
+		fields["EndpointUUID"] = e.Event.EdrData.Endpoint.UUID
+	}
+
+	keys = make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return
+}
+
+// detectionInfo returns the sorted signatures and ATT&CK IDs of an alert
+func detectionInfo(e *event.EdrEvent) (signatures, attack []string, criticality int) {
+	signatures, attack = make([]string, 0), make([]string, 0)
+
+	if d := e.GetDetection(); d != nil {
+		signatures = d.Names()
+		for _, a := range d.ATTACK {
+			attack = append(attack, a.ID)
+		}
+		criticality = d.Criticality
+	}
+
+	sort.Strings(signatures)
+	return
+}
+
+// CEF renders an alert in ArcSight Common Event Format
+func (s *Syslog) CEF(e *event.EdrEvent) string {
+	signatures, attack, crit := detectionInfo(e)
+	name := strings.Join(signatures, ", ")
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		syslogVendor,
+		syslogProduct,
+		cefHeaderEscaper.Replace(ProductVersion),
+		cefHeaderEscaper.Replace(fmt.Sprintf("%s:%d", e.Channel(), e.EventID())),
+		cefHeaderEscaper.Replace(name),
+		crit)
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Timestamp().UnixMilli(), 10),
+		"dvchost=" + cefValueEscaper.Replace(e.Computer()),
+		"cat=" + cefValueEscaper.Replace(e.Channel()),
+		"cs1Label=Signatures",
+		"cs1=" + cefValueEscaper.Replace(name),
+		"cs2Label=ATTACK",
+		"cs2=" + cefValueEscaper.Replace(strings.Join(attack, ",")),
+	}
+
+	keys, fields := syslogExtension(e)
+	for _, k := range keys {
+		ext = append(ext, k+"="+cefValueEscaper.Replace(fields[k]))
+	}
+
+	b.WriteString(strings.Join(ext, " "))
+	return b.String()
+}
+
+// LEEF renders an alert in QRadar Log Event Extended Format
+func (s *Syslog) LEEF(e *event.EdrEvent) string {
+	signatures, attack, crit := detectionInfo(e)
+	name := strings.Join(signatures, ", ")
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "LEEF:1.0|%s|%s|%s|%s|",
+		syslogVendor,
+		syslogProduct,
+		cefHeaderEscaper.Replace(ProductVersion),
+		cefHeaderEscaper.Replace(fmt.Sprintf("%s:%d", e.Channel(), e.EventID())))
+
+	attrs := []string{
+		"devTime=" + e.Timestamp().UTC().Format(leefTimeFormat),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss.SSS z",
+		"sev=" + strconv.Itoa(crit),
+		"cat=" + leefValueEscaper.Replace(e.Channel()),
+		"identHostName=" + leefValueEscaper.Replace(e.Computer()),
+		"Signatures=" + leefValueEscaper.Replace(name),
+		"ATTACK=" + leefValueEscaper.Replace(strings.Join(attack, ",")),
+	}
+
+	keys, fields := syslogExtension(e)
+	for _, k := range keys {
+		attrs = append(attrs, k+"="+leefValueEscaper.Replace(fields[k]))
+	}
+
+	b.WriteString(strings.Join(attrs, "\t"))
+	return b.String()
+}
+
+// Render renders an alert as a syslog message (RFC 3164)
+func (s *Syslog) Render(e *event.EdrEvent) string {
+	var msg string
+
+	_, _, crit := detectionInfo(e)
+	if s.config.Format == SyslogFormatLEEF {
+		msg = s.LEEF(e)
+	} else {
+		msg = s.CEF(e)
+	}
+
+	return fmt.Sprintf("<%d>%s %s %s: %s",
+		s.facility*8+syslogSeverity(crit),
+		time.Now().Format(time.Stamp),
+		s.hostname,
+		syslogTag,
+		msg)
+}
+
+func (s *Syslog) connect() (err error) {
+	var conn net.Conn
+
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+
+	switch s.config.Proto {
+	case SyslogProtoTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.config.Address, &tls.Config{InsecureSkipVerify: s.config.Unsafe})
+	default:
+		conn, err = dialer.Dial(s.config.Proto, s.config.Address)
+	}
+
+	if err == nil {
+		s.conn = conn
+	}
+
+	return
+}
+
+func (s *Syslog) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// write sends a message to the syslog server, connection is re-opened
+// if needed. Messages are dropped while the server cannot be reached,
+// failures are logged once per backoff period.
+func (s *Syslog) write(msg string) (err error) {
+	if s.conn == nil {
+		if time.Since(s.failed) < syslogBackoff {
+			return fmt.Errorf("syslog server unreachable")
+		}
+
+		if err = s.connect(); err != nil {
+			s.failed = time.Now()
+			s.logger.Errorf("Failed to connect to syslog server: %s", err)
+			return
+		}
+	}
+
+	// octet stream protocols need a message delimiter
+	if s.config.Proto != SyslogProtoUDP {
+		msg += "\n"
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err = s.conn.Write([]byte(msg)); err != nil {
+		s.close()
+		s.failed = time.Now()
+		s.logger.Errorf("Failed to send alert to syslog server: %s", err)
+		return
+	}
+
+	return
+}
+
+func (s *Syslog) send(msg string) {
+	if err := s.write(msg); err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Send queues an alert to be sent, other events are ignored. Alert is
+// rendered before this function returns and dropped if the queue is full.
+func (s *Syslog) Send(e *event.EdrEvent) error {
+	if !e.IsDetection() {
+		return nil
+	}
+
+	select {
+	case s.queue <- s.Render(e):
+		return nil
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return fmt.Errorf("syslog queue is full")
+	}
+}
+
+// Run starts the routine sending queued alerts
+func (s *Syslog) Run() {
+	s.Add(1)
+	go func() {
+		defer s.Done()
+		defer s.close()
+
+		for {
+			select {
+			case msg := <-s.queue:
+				s.send(msg)
+			case <-s.ctx.Done():
+				// sending the alerts still queued
+				for len(s.queue) > 0 {
+					s.send(<-s.queue)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// Close sends the alerts queued and closes connection to the syslog server
+func (s *Syslog) Close() {
+	s.cancel()
+	s.Wait()
+}
@@ -35,19 +35,30 @@ type UploadShrinker struct {
 
 // NewUploadShrinker creates a new object to shrink files to be uploaded to the manager
 func NewUploadShrinker(path, guid, ehash string) (it *UploadShrinker, err error) {
+	return NewUploadShrinkerWithChunkSize(path, guid, ehash, UploadShrinkerBufferSize)
+}
+
+// NewUploadShrinkerWithChunkSize creates a new object to shrink files to be uploaded
+// to the manager in chunks of at most chunkSize bytes
+func NewUploadShrinkerWithChunkSize(path, guid, ehash string, chunkSize int64) (it *UploadShrinker, err error) {
 	var fd *os.File
 	var stat fs.FileInfo
 
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("bad chunk size: %d", chunkSize)
+	}
+
 	if fd, err = os.Open(path); err != nil {
 		return
 	}
 
 	if stat, err = fd.Stat(); err != nil {
+		fd.Close()
 		return
 	}
 
 	size := stat.Size()
-	total := int(size/UploadShrinkerBufferSize) + 1
+	total := int(size/chunkSize) + 1
 
 	it = &UploadShrinker{
 		name: filepath.Base(path),
@@ -58,7 +69,7 @@ func NewUploadShrinker(path, guid, ehash string) (it *UploadShrinker, err error)
 			EventHash: ehash,
 			Total:     total,
 		},
-		buff:  make([]byte, UploadShrinkerBufferSize),
+		buff:  make([]byte, chunkSize),
 		chunk: 1,
 		total: total,
 		size:  size,
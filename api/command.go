@@ -14,6 +14,44 @@ import (
 	"github.com/google/shlex"
 )
 
+var (
+	ErrBadCommandSignature = errors.New("malformed command signature")
+	ErrCommandSignature    = errors.New("bad command signature")
+	ErrCommandSigExpired   = errors.New("command signature expired")
+	ErrCommandSigMismatch  = errors.New("command signature does not match command")
+)
+
+// CommandSignature is the content of a command signed by the manager. Only
+// command identifier, name and arguments are signed so files to drop cannot
+// be trusted.
+type CommandSignature struct {
+	Purpose  string    `json:"purpose"`
+	Endpoint string    `json:"endpoint-uuid"`
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Args     []string  `json:"args"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (s *CommandSignature) matches(c *EndpointCommand) bool {
+	return s.UUID == c.UUID && s.Name == c.Name && sameArgs(s.Args, c.Args)
+}
+
+// sameArgs returns true if signed arguments are the ones of a command
+func sameArgs(signed, args []string) bool {
+	if len(signed) != len(args) {
+		return false
+	}
+
+	for i := range signed {
+		if signed[i] != args[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // EndpointFile describes a File to drop or fetch from the endpoint
 type EndpointFile struct {
 	UUID  string `json:"uuid"`
@@ -42,6 +80,10 @@ type EndpointCommand struct {
 	ExpectJSON bool          `json:"expect-json"`
 	Timeout    time.Duration `json:"timeout"`
 	SentTime   time.Time     `json:"sent-time"`
+	// signature of the command by the manager, required by privileged commands
+	Signature string `json:"signature,omitempty"`
+	// approval issued within a responder session, required by destructive commands
+	Approval string `json:"approval,omitempty"`
 
 	runnable bool
 }
@@ -208,6 +250,45 @@ func (c *EndpointCommand) Run() (err error) {
 	return
 }
 
+// Sign signs command for endpoint, signature expires at expires
+func (c *EndpointCommand) Sign(k *ContainmentKey, endpoint string, expires time.Time) (err error) {
+	s := CommandSignature{
+		Purpose:  PurposeCommand,
+		Endpoint: endpoint,
+		UUID:     c.UUID,
+		Name:     c.Name,
+		Args:     c.Args,
+		Expires:  expires.UTC(),
+	}
+
+	c.Signature, err = k.sign(&s)
+	return
+}
+
+// VerifySignature verifies command signature against manager's public key
+// (hex encoded) and checks it has been issued for endpoint and is not expired
+func (c *EndpointCommand) VerifySignature(pubkey, endpoint string, now time.Time) (err error) {
+	s := CommandSignature{}
+
+	if c.Signature == "" {
+		return ErrBadCommandSignature
+	}
+
+	if err = verifySigned(c.Signature, pubkey, PurposeCommand, &s, ErrBadCommandSignature, ErrCommandSignature); err != nil {
+		return
+	}
+
+	if s.Endpoint != endpoint || !s.matches(c) {
+		return ErrCommandSigMismatch
+	}
+
+	if now.After(s.Expires) {
+		return ErrCommandSigExpired
+	}
+
+	return
+}
+
 func (c *EndpointCommand) ErrorFrom(err error) {
 	c.Error = err.Error()
 }
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// ContainerTypeAny container entries are not validated
+	ContainerTypeAny    = ""
+	ContainerTypeMd5    = "md5"
+	ContainerTypeSha1   = "sha1"
+	ContainerTypeSha256 = "sha256"
+	ContainerTypeDomain = "domain"
+	ContainerTypeIP     = "ip"
+)
+
+var (
+	// container names end up in file names on endpoints
+	reContainerName = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+	reDomain        = regexp.MustCompile(`^(?i:[a-z0-9_\-]+(\.[a-z0-9_\-]+)*\.?)$`)
+
+	containerTypeRe = map[string]*regexp.Regexp{
+		ContainerTypeMd5:    regexp.MustCompile(`^[a-f0-9]{32}$`),
+		ContainerTypeSha1:   regexp.MustCompile(`^[a-f0-9]{40}$`),
+		ContainerTypeSha256: regexp.MustCompile(`^[a-f0-9]{64}$`),
+		ContainerTypeDomain: reDomain,
+	}
+)
+
+// EdrContainer is a Gene container managed by the manager and
+// pushed to endpoints so that it can be used in rules
+type EdrContainer struct {
+	sod.Item
+	Name string `json:"name" sod:"unique"`
+	// Type of the entries, used to validate them
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Entries     []string  `json:"entries"`
+	Sha256      string    `json:"sha256"`
+	LastUpdate  time.Time `json:"last-update"`
+}
+
+// normalizeEntry normalizes a container entry according to container type
+func (c *EdrContainer) normalizeEntry(e string) string {
+	e = strings.TrimSpace(e)
+	switch c.Type {
+	case ContainerTypeMd5, ContainerTypeSha1, ContainerTypeSha256, ContainerTypeDomain:
+		return strings.ToLower(e)
+	case ContainerTypeIP:
+		// IPv6 addresses have several representations
+		return utils.CanonicalIP(e)
+	}
+	return e
+}
+
+// ValidateEntry returns an error if entry is not valid for container type
+func (c *EdrContainer) ValidateEntry(e string) error {
+	if e == "" {
+		return fmt.Errorf("empty entry")
+	}
+
+	// entries are written one per line on endpoints
+	if strings.ContainsAny(e, "\r\n") {
+		return fmt.Errorf("entry %q must not contain new lines", e)
+	}
+
+	switch c.Type {
+	case ContainerTypeAny:
+	case ContainerTypeIP:
+		if net.ParseIP(e) == nil {
+			return fmt.Errorf("entry %q is not a valid IP", e)
+		}
+	default:
+		if !containerTypeRe[c.Type].MatchString(e) {
+			return fmt.Errorf("entry %q is not a valid %s", e, c.Type)
+		}
+	}
+
+	return nil
+}
+
+// Update normalizes, deduplicates and sorts entries then recomputes sha256
+func (c *EdrContainer) Update() {
+	set := make(map[string]bool, len(c.Entries))
+	entries := make([]string, 0, len(c.Entries))
+
+	for _, e := range c.Entries {
+		e = c.normalizeEntry(e)
+		if !set[e] {
+			set[e] = true
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Strings(entries)
+	c.Entries = entries
+	c.Sha256 = utils.Sha256StringSlice(c.Entries)
+	c.LastUpdate = time.Now().UTC()
+}
+
+// Edit adds and removes entries from container and returns the entries actually
+// added and removed. Container is updated and entries validated.
+func (c *EdrContainer) Edit(add, remove []string) (added, removed []string, err error) {
+	set := make(map[string]bool, len(c.Entries))
+	for _, e := range c.Entries {
+		set[e] = true
+	}
+
+	added, removed = make([]string, 0), make([]string, 0)
+
+	for _, e := range remove {
+		if e = c.normalizeEntry(e); set[e] {
+			delete(set, e)
+			removed = append(removed, e)
+		}
+	}
+
+	for _, e := range add {
+		e = c.normalizeEntry(e)
+		if err = c.ValidateEntry(e); err != nil {
+			return nil, nil, err
+		}
+		if !set[e] {
+			set[e] = true
+			added = append(added, e)
+		}
+	}
+
+	c.Entries = make([]string, 0, len(set))
+	for e := range set {
+		c.Entries = append(c.Entries, e)
+	}
+
+	c.Update()
+	return
+}
+
+// Validate overwrite sod.Item function
+func (c *EdrContainer) Validate() error {
+	if !reContainerName.MatchString(c.Name) {
+		return fmt.Errorf("container name must only contain alphanumeric characters, - or _")
+	}
+
+	if _, ok := containerTypeRe[c.Type]; !ok && c.Type != ContainerTypeAny && c.Type != ContainerTypeIP {
+		return fmt.Errorf("unknown container type %s", c.Type)
+	}
+
+	for _, e := range c.Entries {
+		if err := c.ValidateEntry(e); err != nil {
+			return err
+		}
+	}
+
+	if c.Sha256 != utils.Sha256StringSlice(c.Entries) {
+		return fmt.Errorf("container sha256 does not match its entries")
+	}
+
+	return nil
+}
+
+// ContainersSha256 computes a sha256 out of a set of containers,
+// used by endpoints to know if containers need to be updated
+func ContainersSha256(containers []*EdrContainer) string {
+	keys := make([]string, 0, len(containers))
+	for _, c := range containers {
+		keys = append(keys, fmt.Sprintf("%s:%s", c.Name, c.Sha256))
+	}
+	sort.Strings(keys)
+	return utils.Sha256StringSlice(keys)
+}
+
+// ContainerEdit describes a change to apply to a container
+type ContainerEdit struct {
+	Name    string   `json:"name"`
+	Add     []string `json:"add,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// ContainerChange is a change made to a container, used
+// to keep history of container changes
+type ContainerChange struct {
+	sod.Item
+	Container string    `json:"container" sod:"index"`
+	User      string    `json:"user"`
+	Timestamp time.Time `json:"timestamp" sod:"index"`
+	Action    string    `json:"action"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	// sha256 of the container after the change
+	Sha256 string `json:"sha256"`
+}
+
+// NewContainerChange creates a new ContainerChange of container c
+func NewContainerChange(c *EdrContainer, user, action string) *ContainerChange {
+	return &ContainerChange{
+		Container: c.Name,
+		User:      user,
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Sha256:    c.Sha256,
+	}
+}
+
+// DiffEntries returns the entries added and removed between old and new
+func DiffEntries(old, new []string) (added, removed []string) {
+	set := make(map[string]bool, len(old))
+	for _, e := range old {
+		set[e] = true
+	}
+
+	added, removed = make([]string, 0), make([]string, 0)
+	for _, e := range new {
+		if set[e] {
+			delete(set, e)
+		} else {
+			added = append(added, e)
+		}
+	}
+
+	for _, e := range old {
+		if set[e] {
+			removed = append(removed, e)
+		}
+	}
+
+	return
+}
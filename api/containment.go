@@ -0,0 +1,203 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+)
+
+const (
+	// purposes of the payloads signed with a ContainmentKey, a payload
+	// signed for a purpose is never accepted for another one
+	PurposeReleaseToken = "release-token"
+	PurposeApproval     = "responder-approval"
+	PurposeCommand      = "command-signature"
+)
+
+var (
+	ErrBadReleaseToken      = errors.New("malformed release token")
+	ErrReleaseTokenSig      = errors.New("bad release token signature")
+	ErrReleaseTokenExpired  = errors.New("release token expired")
+	ErrReleaseTokenEndpoint = errors.New("release token issued for another endpoint")
+)
+
+// ContainmentState holds the network containment state of an endpoint
+type ContainmentState struct {
+	// containment profile applied, empty if endpoint is not contained
+	Profile string    `json:"profile"`
+	Since   time.Time `json:"since,omitempty"`
+	// zero value means containment is never released automatically
+	ReleaseAt time.Time `json:"release-at,omitempty"`
+	// number of network filters enforcing containment
+	Filters int `json:"filters,omitempty"`
+}
+
+// NewContainmentState creates a new ContainmentState starting now, with
+// an optional auto-release timer if release > 0
+func NewContainmentState(profile string, release time.Duration) *ContainmentState {
+	now := time.Now().UTC()
+	s := &ContainmentState{Profile: profile, Since: now}
+	if release > 0 {
+		s.ReleaseAt = now.Add(release)
+	}
+	return s
+}
+
+// ParseContainmentState parses a ContainmentState as encoded by
+// the Header method
+func ParseContainmentState(header string) (s *ContainmentState, err error) {
+	s = &ContainmentState{}
+	err = json.Unmarshal([]byte(header), s)
+	return
+}
+
+// Contained returns true if endpoint is contained
+func (s *ContainmentState) Contained() bool {
+	return s.Profile != ""
+}
+
+// Expired returns true if containment must be automatically released
+func (s *ContainmentState) Expired(now time.Time) bool {
+	return s.Contained() && !s.ReleaseAt.IsZero() && now.After(s.ReleaseAt)
+}
+
+// Header encodes state to be sent in a HTTP header
+func (s *ContainmentState) Header() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// ContainmentKey is the key pair used by the manager to sign
+// emergency containment release tokens and privileged commands
+type ContainmentKey struct {
+	sod.Item
+	PrivateKey []byte `json:"private-key"`
+}
+
+// NewContainmentKey generates a new ContainmentKey
+func NewContainmentKey() (k *ContainmentKey, err error) {
+	var priv ed25519.PrivateKey
+
+	if _, priv, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		return
+	}
+
+	return &ContainmentKey{PrivateKey: priv}, nil
+}
+
+// Validate overwrite sod.Item function
+func (k *ContainmentKey) Validate() error {
+	if len(k.PrivateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("bad private key size")
+	}
+	return nil
+}
+
+// PublicKey returns the public key, hex encoded, to configure on
+// endpoints to verify release tokens and signed commands
+func (k *ContainmentKey) PublicKey() string {
+	return hex.EncodeToString(ed25519.PrivateKey(k.PrivateKey).Public().(ed25519.PublicKey))
+}
+
+// sign signs the JSON encoding of v, the output is the JSON payload
+// and its signature, both base64 encoded and separated by a dot
+func (k *ContainmentKey) sign(v interface{}) (signed string, err error) {
+	var payload []byte
+
+	if payload, err = json.Marshal(v); err != nil {
+		return
+	}
+
+	sig := ed25519.Sign(ed25519.PrivateKey(k.PrivateKey), payload)
+	enc := base64.RawURLEncoding
+	return fmt.Sprintf("%s.%s", enc.EncodeToString(payload), enc.EncodeToString(sig)), nil
+}
+
+// verifySigned verifies a payload signed with ContainmentKey.sign against
+// a public key (hex encoded) and decodes it into v. Error errBad is returned
+// if signed payload is malformed or has not been signed for purpose and
+// errSig if signature is not valid.
+func verifySigned(signed, pubkey, purpose string, v interface{}, errBad, errSig error) (err error) {
+	var pub, payload, sig []byte
+	var p struct {
+		Purpose string `json:"purpose"`
+	}
+
+	if pub, err = hex.DecodeString(pubkey); err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad manager public key")
+	}
+
+	enc := base64.RawURLEncoding
+	parts := strings.Split(strings.TrimSpace(signed), ".")
+	if len(parts) != 2 {
+		return errBad
+	}
+
+	if payload, err = enc.DecodeString(parts[0]); err != nil {
+		return errBad
+	}
+
+	if sig, err = enc.DecodeString(parts[1]); err != nil {
+		return errBad
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pub), payload, sig) {
+		return errSig
+	}
+
+	if err = json.Unmarshal(payload, &p); err != nil {
+		return errBad
+	}
+
+	if p.Purpose != purpose {
+		return fmt.Errorf("%w: not signed as %s", errBad, purpose)
+	}
+
+	if err = json.Unmarshal(payload, v); err != nil {
+		return errBad
+	}
+
+	return
+}
+
+// ReleaseToken is a token, signed offline by the manager, allowing to
+// release containment of an endpoint when the manager is unreachable
+type ReleaseToken struct {
+	Purpose  string    `json:"purpose"`
+	Endpoint string    `json:"endpoint-uuid"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Sign signs a release token, the output is the token to hand
+// over to the endpoint
+func (t *ReleaseToken) Sign(k *ContainmentKey) (token string, err error) {
+	t.Purpose = PurposeReleaseToken
+	return k.sign(t)
+}
+
+// VerifyReleaseToken verifies a release token against a public key (hex encoded)
+// and checks it has been issued for endpoint and is not expired
+func VerifyReleaseToken(token, pubkey, endpoint string, now time.Time) (t *ReleaseToken, err error) {
+	t = &ReleaseToken{}
+	if err = verifySigned(token, pubkey, PurposeReleaseToken, t, ErrBadReleaseToken, ErrReleaseTokenSig); err != nil {
+		return nil, err
+	}
+
+	if t.Endpoint != endpoint {
+		return nil, ErrReleaseTokenEndpoint
+	}
+
+	if now.After(t.Expires) {
+		return nil, ErrReleaseTokenExpired
+	}
+
+	return
+}
@@ -2,6 +2,9 @@ package api
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/0xrawsec/sod"
@@ -9,6 +12,38 @@ import (
 	"github.com/0xrawsec/whids/agent/sysinfo"
 )
 
+var (
+	tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,63}$`)
+)
+
+// NormalizeTag returns tag trimmed and lowercased, an error
+// is returned if tag contains unsupported characters
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !tagRe.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %q", tag)
+	}
+	return tag, nil
+}
+
+// FormatTagsHeader encodes tags to be sent in a HTTP header
+func FormatTagsHeader(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// ParseTagsHeader decodes tags sent in a HTTP header, invalid tags are
+// dropped and the ones returned are sorted
+func ParseTagsHeader(h string) (tags []string) {
+	tags = make([]string, 0)
+	for _, t := range strings.Split(h, ",") {
+		if t, err := NormalizeTag(t); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	return
+}
+
 // Endpoint structure used to track and interact with endpoints
 type Endpoint struct {
 	sod.Item
@@ -16,13 +51,17 @@ type Endpoint struct {
 	Hostname       string              `json:"hostname"`
 	IP             string              `json:"ip"`
 	Group          string              `json:"group"`
+	DynamicGroups  []string            `json:"dynamic-groups,omitempty"`
+	Tags           []string            `json:"tags,omitempty"`
 	Criticality    int                 `json:"criticality"`
 	Key            string              `json:"key,omitempty"`
 	Command        *EndpointCommand    `json:"command,omitempty"`
 	Score          float64             `json:"score"`
 	Status         string              `json:"status"`
+	Containment    *ContainmentState   `json:"containment,omitempty"`
 	SystemInfo     *sysinfo.SystemInfo `json:"system-info,omitempty"`
 	Config         *config.Agent       `json:"config,omitempty"`
+	Stats          *AgentStats         `json:"stats,omitempty"`
 	LastEvent      time.Time           `json:"last-event"`
 	LastDetection  time.Time           `json:"last-detection"`
 	LastConnection time.Time           `json:"last-connection"`
@@ -49,6 +88,32 @@ func (e *Endpoint) Copy() *Endpoint {
 	return &new
 }
 
+// InGroup returns true if the endpoint is in group, either
+// statically or dynamically
+func (e *Endpoint) InGroup(group string) bool {
+	if e.Group == group {
+		return true
+	}
+
+	for _, g := range e.DynamicGroups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasTag returns true if the endpoint has been tagged with tag
+func (e *Endpoint) HasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
 // UpdateLastConnection updates the LastConnection member of Endpoint structure
 func (e *Endpoint) UpdateLastConnection() {
 	e.LastConnection = time.Now().UTC()
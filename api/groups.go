@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils/query"
+)
+
+// EndpointGroup is a dynamic group of endpoints, an endpoint belongs
+// to the group as long as it matches group's query
+type EndpointGroup struct {
+	sod.Item
+	Name        string `json:"name" sod:"unique"`
+	Query       string `json:"query"`
+	Description string `json:"description"`
+
+	query *query.Query
+}
+
+// Validate overwrite sod.Item function
+func (g *EndpointGroup) Validate() (err error) {
+	if g.Name == "" {
+		return fmt.Errorf("name field is mandatory")
+	}
+
+	if g.query, err = query.Parse(g.Query); err != nil {
+		return fmt.Errorf("bad query: %w", err)
+	}
+
+	return
+}
+
+// Match returns true if endpoint document matches group's query
+func (g *EndpointGroup) Match(d query.Document) bool {
+	if g.query == nil {
+		if err := g.Validate(); err != nil {
+			return false
+		}
+	}
+	return g.query.Match(d)
+}
+
+// endpointDocument is the query.Document of an Endpoint
+type endpointDocument struct {
+	fields map[string]string
+	tags   map[string]bool
+}
+
+func (d *endpointDocument) Field(name string) (value string, ok bool) {
+	value, ok = d.fields[strings.ToLower(name)]
+	return
+}
+
+func (d *endpointDocument) HasTag(tag string) bool {
+	return d.tags[strings.ToLower(tag)]
+}
+
+func (d *endpointDocument) flatten(prefix string, i interface{}) {
+	switch v := i.(type) {
+	case map[string]interface{}:
+		for k, sub := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			d.flatten(k, sub)
+		}
+	case string:
+		d.fields[strings.ToLower(prefix)] = v
+	case float64:
+		d.fields[strings.ToLower(prefix)] = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		d.fields[strings.ToLower(prefix)] = strconv.FormatBool(v)
+	}
+}
+
+// Document returns a query.Document used to evaluate dynamic groups queries.
+// Fields are the ones of the JSON representation of the endpoint (i.e. hostname,
+// system-info.os.build). System information fields can also be accessed without
+// system-info prefix (i.e. os.build). Tags are the ones of endpoint configuration
+// and the ones set with tag commands.
+func (e *Endpoint) Document() query.Document {
+	var m map[string]interface{}
+
+	d := &endpointDocument{
+		fields: make(map[string]string),
+		tags:   make(map[string]bool),
+	}
+
+	if e.Config != nil {
+		for _, t := range e.Config.CritConfig.Tags {
+			d.tags[strings.ToLower(t)] = true
+		}
+	}
+
+	for _, t := range e.Tags {
+		d.tags[strings.ToLower(t)] = true
+	}
+
+	// sensitive and irrelevant fields are removed
+	c := e.Copy()
+	c.Key = ""
+	c.Command = nil
+	c.Config = nil
+	c.DynamicGroups = nil
+
+	if b, err := json.Marshal(c); err == nil {
+		if err = json.Unmarshal(b, &m); err == nil {
+			d.flatten("", m)
+			if info, ok := m["system-info"]; ok {
+				d.flatten("", info)
+			}
+		}
+	}
+
+	return d
+}
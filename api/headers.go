@@ -7,4 +7,11 @@ const (
 	EndpointUUIDHeader     = "X-Endpoint-Uuid"
 	EndpointIPHeader       = "X-Endpoint-IP"
 	EndpointHostnameHeader = "X-Endpoint-Hostname"
+	// containment state of the endpoint, sent with every request
+	EndpointContainmentHeader = "X-Endpoint-Containment"
+	// tags set on the endpoint with tag commands, sent with every request
+	EndpointTagsHeader = "X-Endpoint-Tags"
+
+	// BatchIDHeader identifies a batch of events sent by an endpoint
+	BatchIDHeader = "X-Batch-Id"
 )
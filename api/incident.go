@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	IncidentOpen   = "open"
+	IncidentClosed = "closed"
+
+	// ActionIsolation network containment of an endpoint
+	ActionIsolation = "isolation"
+	// ActionFirewallBlock traffic to/from an address blocked by firewall
+	ActionFirewallBlock = "firewall-block"
+	// ActionDisableUser local user account disabled
+	ActionDisableUser = "disable-user"
+	// ActionQuarantine file moved to agent's quarantine
+	ActionQuarantine = "quarantine"
+	// ActionProcessIsolation network traffic of a process blocked
+	ActionProcessIsolation = "process-isolation"
+	// ActionProcessSuspension threads of a process suspended
+	ActionProcessSuspension = "process-suspension"
+
+	// response action is in effect on endpoint
+	ActionStatusActive = "active"
+	// revert command has been sent to endpoint
+	ActionStatusReverting = "reverting"
+	// action has been reverted on endpoint
+	ActionStatusReverted = "reverted"
+	// endpoint failed at reverting action
+	ActionStatusFailed = "failed"
+
+	// RevertCommand name of the endpoint command reverting response actions
+	RevertCommand = "revert"
+)
+
+var (
+	// commands whose effect can be reverted
+	reversibleCommands = map[string]string{
+		"contain":         ActionIsolation,
+		"block":           ActionFirewallBlock,
+		"disable-user":    ActionDisableUser,
+		"quarantine":      ActionQuarantine,
+		"isolate-process": ActionProcessIsolation,
+		"suspend":         ActionProcessSuspension,
+	}
+)
+
+// ResponseAction is a reversible response action run on an endpoint
+type ResponseAction struct {
+	UUID         string    `json:"uuid"`
+	Type         string    `json:"type"`
+	EndpointUUID string    `json:"endpoint-uuid"`
+	Target       string    `json:"target,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	Status       string    `json:"status"`
+	// uuid of the command which ran the action
+	Command string `json:"command"`
+	// uuid of the command reverting the action
+	RevertCommand string `json:"revert-command,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Spec returns the specification of the action as expected
+// by the revert endpoint command
+func (a *ResponseAction) Spec() string {
+	if a.Target == "" {
+		return a.Type
+	}
+	return fmt.Sprintf("%s:%s", a.Type, a.Target)
+}
+
+// ParseActionSpec parses an action specification as returned by
+// ResponseAction.Spec
+func ParseActionSpec(spec string) (typ, target string) {
+	sp := strings.SplitN(spec, ":", 2)
+	typ = sp[0]
+	if len(sp) > 1 {
+		target = sp[1]
+	}
+	return
+}
+
+// ActionsFromCommand returns the reversible response actions run by an
+// endpoint command, nil if the command is not reversible
+func ActionsFromCommand(euuid string, c *EndpointCommand) (actions []*ResponseAction) {
+	typ, ok := reversibleCommands[c.Name]
+	if !ok {
+		return
+	}
+
+	targets := c.Args
+	// isolation has no target
+	if typ == ActionIsolation {
+		targets = []string{""}
+	}
+
+	for _, t := range targets {
+		actions = append(actions, &ResponseAction{
+			UUID:         utils.UnsafeUUID().String(),
+			Type:         typ,
+			EndpointUUID: euuid,
+			Target:       t,
+			Command:      c.UUID,
+			Timestamp:    time.Now().UTC(),
+			Status:       ActionStatusActive,
+		})
+	}
+
+	return
+}
+
+// RevertResult is the result of the revert of a response action
+// returned by endpoints
+type RevertResult struct {
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// IncidentRestore reports the revert commands sent to endpoints
+// to restore them once an incident is closed
+type IncidentRestore struct {
+	Incident *Incident `json:"incident"`
+	// endpoints a revert command has been sent to
+	Reverting []string `json:"reverting"`
+	// endpoints with a command already pending, restore
+	// must be run again once it is completed
+	Busy []string `json:"busy"`
+	// endpoints which cannot be restored
+	Errors map[string]string `json:"errors"`
+}
+
+// NewIncidentRestore creates a new IncidentRestore
+func NewIncidentRestore(i *Incident) *IncidentRestore {
+	return &IncidentRestore{
+		Incident:  i,
+		Reverting: make([]string, 0),
+		Busy:      make([]string, 0),
+		Errors:    make(map[string]string),
+	}
+}
+
+// Incident groups the response actions taken on endpoints during
+// an investigation so that they can be reverted once it is closed
+type Incident struct {
+	sod.Item
+	Uuid        string            `json:"uuid" sod:"unique"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Status      string            `json:"status" sod:"index"`
+	Created     time.Time         `json:"created"`
+	Closed      time.Time         `json:"closed,omitempty"`
+	Actions     []*ResponseAction `json:"actions"`
+}
+
+// NewIncident creates a new open incident
+func NewIncident(title string) *Incident {
+	i := &Incident{
+		Uuid:    utils.UnsafeUUID().String(),
+		Title:   title,
+		Status:  IncidentOpen,
+		Created: time.Now().UTC(),
+		Actions: make([]*ResponseAction, 0),
+	}
+	i.Initialize(i.Uuid)
+	return i
+}
+
+// Validate overwrite sod.Item function
+func (i *Incident) Validate() error {
+	if i.Title == "" {
+		return fmt.Errorf("title field is mandatory")
+	}
+
+	if i.Status != IncidentOpen && i.Status != IncidentClosed {
+		return fmt.Errorf("unknown incident status: %s", i.Status)
+	}
+
+	return nil
+}
+
+// IsClosed returns true if incident is closed
+func (i *Incident) IsClosed() bool {
+	return i.Status == IncidentClosed
+}
+
+// Close closes incident
+func (i *Incident) Close() {
+	if !i.IsClosed() {
+		i.Status = IncidentClosed
+		i.Closed = time.Now().UTC()
+	}
+}
+
+// ToRevert returns, by endpoint, the actions not reverted yet
+func (i *Incident) ToRevert() (m map[string][]*ResponseAction) {
+	m = make(map[string][]*ResponseAction)
+	for _, a := range i.Actions {
+		if a.Status == ActionStatusActive || a.Status == ActionStatusFailed {
+			m[a.EndpointUUID] = append(m[a.EndpointUUID], a)
+		}
+	}
+	return
+}
+
+// Restored returns true if all the actions of the incident are reverted
+func (i *Incident) Restored() bool {
+	for _, a := range i.Actions {
+		if a.Status != ActionStatusReverted {
+			return false
+		}
+	}
+	return true
+}
+
+// AddActions adds response actions to the incident
+func (i *Incident) AddActions(actions ...*ResponseAction) {
+	i.Actions = append(i.Actions, actions...)
+}
+
+// CommandCompleted updates incident's actions given a command completed
+// by an endpoint. Actions of a failed command are dropped as they never
+// took effect and the status of the actions reverted by a revert command
+// is updated. It returns true if the incident got modified.
+func (i *Incident) CommandCompleted(c *EndpointCommand) (updated bool) {
+	if c.Name == RevertCommand {
+		return i.updateReverted(c)
+	}
+
+	if c.Error == "" {
+		return
+	}
+
+	actions := make([]*ResponseAction, 0, len(i.Actions))
+	for _, a := range i.Actions {
+		if a.Command == c.UUID {
+			updated = true
+			continue
+		}
+		actions = append(actions, a)
+	}
+	i.Actions = actions
+
+	return
+}
+
+func (i *Incident) updateReverted(c *EndpointCommand) (updated bool) {
+	var results []*RevertResult
+
+	errors := make(map[string]string)
+	// command output is unmarshalled as a generic interface
+	if b, err := json.Marshal(c.Json); err == nil {
+		if err = json.Unmarshal(b, &results); err == nil {
+			for _, r := range results {
+				errors[r.Action] = r.Error
+			}
+		}
+	}
+
+	for _, a := range i.Actions {
+		if a.RevertCommand != c.UUID || a.Status != ActionStatusReverting {
+			continue
+		}
+
+		updated = true
+		if err, ok := errors[a.Spec()]; !ok {
+			a.Status = ActionStatusFailed
+			a.Error = "action not reverted by endpoint"
+			if c.Error != "" {
+				a.Error = c.Error
+			}
+		} else if err != "" {
+			a.Status = ActionStatusFailed
+			a.Error = err
+		} else {
+			a.Status = ActionStatusReverted
+			a.Error = ""
+		}
+	}
+
+	return
+}
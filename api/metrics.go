@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/event"
+)
+
+// MetricsRollup holds daily telemetry statistics of an endpoint
+type MetricsRollup struct {
+	sod.Item
+	Endpoint   string    `json:"endpoint-uuid" sod:"index"`
+	Day        time.Time `json:"day" sod:"index"`
+	Events     int64     `json:"events"`
+	Detections int64     `json:"detections"`
+	// events received by the manager but which could not be processed
+	Dropped int64 `json:"dropped"`
+	// events forwarded by the agent never received by the manager,
+	// detected from gaps in event sequence numbers
+	Lost int64 `json:"lost"`
+	// events received several times
+	Duplicates int64            `json:"duplicates"`
+	EPS        float64          `json:"eps"`
+	Signatures map[string]int64 `json:"signatures"`
+}
+
+// MetricsDay returns the day (in UTC) a timestamp belongs to
+func MetricsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// NewMetricsRollup creates a new MetricsRollup for an endpoint and
+// the day t belongs to
+func NewMetricsRollup(endpoint string, t time.Time) *MetricsRollup {
+	return &MetricsRollup{
+		Endpoint:   endpoint,
+		Day:        MetricsDay(t),
+		Signatures: make(map[string]int64),
+	}
+}
+
+// Validate overwrite sod.Item function
+func (r *MetricsRollup) Validate() error {
+	if !r.Day.Equal(MetricsDay(r.Day)) {
+		return fmt.Errorf("day field must be a UTC day")
+	}
+	return nil
+}
+
+// Key returns a key uniquely identifying a rollup
+func (r *MetricsRollup) Key() string {
+	return fmt.Sprintf("%s|%d", r.Endpoint, r.Day.Unix())
+}
+
+// Update updates rollup with an event received
+func (r *MetricsRollup) Update(e *event.EdrEvent) {
+	r.Events++
+	if d := e.GetDetection(); d != nil {
+		r.Detections++
+		for _, s := range d.Names() {
+			r.Signatures[s]++
+		}
+	}
+}
+
+// Merge merges the counters of other into rollup
+func (r *MetricsRollup) Merge(other *MetricsRollup) {
+	if r.Signatures == nil {
+		r.Signatures = make(map[string]int64)
+	}
+
+	r.Events += other.Events
+	r.Detections += other.Detections
+	r.Dropped += other.Dropped
+	r.Lost += other.Lost
+	r.Duplicates += other.Duplicates
+	for s, n := range other.Signatures {
+		r.Signatures[s] += n
+	}
+}
+
+// UpdateEPS computes the average number of events per second over the
+// day, the current day being only accounted up to now
+func (r *MetricsRollup) UpdateEPS(now time.Time) {
+	span := 24 * time.Hour
+
+	if elapsed := now.Sub(r.Day); elapsed < span {
+		span = elapsed
+	}
+
+	r.EPS = 0
+	if span > 0 {
+		r.EPS = float64(r.Events) / span.Seconds()
+	}
+}
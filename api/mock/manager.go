@@ -0,0 +1,652 @@
+// Package mock implements an in-memory manager serving the endpoint API so
+// that agent behaviour (rule updates, command handling, uploads ...) can be
+// exercised without deploying a real manager.
+package mock
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	aconfig "github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/api/client"
+	"github.com/0xrawsec/whids/api/client/config"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/ioc"
+	"github.com/0xrawsec/whids/utils"
+	"github.com/gorilla/mux"
+)
+
+// Manager is an in-memory manager serving the endpoint API to a single
+// endpoint. All its methods are safe for concurrent use.
+type Manager struct {
+	sync.RWMutex
+
+	server    *httptest.Server
+	uuid      string
+	key       string
+	serverKey string
+
+	rules        string
+	containers   []*api.EdrContainer
+	iocs         *ioc.IoCs
+	suppressions []*api.Suppression
+	playbooks    []*api.Playbook
+	trusted      []*api.TrustedProcess
+	agentConfig  *aconfig.Agent
+
+	// commands not sent to endpoint yet
+	queued []*api.EndpointCommand
+	// command sent to endpoint waiting for completion
+	running *api.EndpointCommand
+	// commands completed by endpoint
+	completed []*api.EndpointCommand
+
+	events       []*event.EdrEvent
+	tapped       []*event.EdrEvent
+	uploads      []*client.FileUpload
+	playbookRuns []*api.PlaybookRun
+	systemInfo   *sysinfo.SystemInfo
+	usage        *api.UsageReport
+	errors       *api.AgentErrorReport
+}
+
+// NewManager creates a new mock Manager, it must be started
+// with Start before agents can connect to it
+func NewManager() *Manager {
+	return &Manager{
+		uuid:         utils.UnsafeUUID().String(),
+		key:          utils.NewKeyOrPanic(api.DefaultKeySize),
+		serverKey:    utils.NewKeyOrPanic(api.DefaultKeySize),
+		containers:   make([]*api.EdrContainer, 0),
+		iocs:         ioc.NewIocs(),
+		suppressions: make([]*api.Suppression, 0),
+		playbooks:    make([]*api.Playbook, 0),
+		trusted:      make([]*api.TrustedProcess, 0),
+		queued:       make([]*api.EndpointCommand, 0),
+		completed:    make([]*api.EndpointCommand, 0),
+		events:       make([]*event.EdrEvent, 0),
+		tapped:       make([]*event.EdrEvent, 0),
+		uploads:      make([]*client.FileUpload, 0),
+		playbookRuns: make([]*api.PlaybookRun, 0),
+	}
+}
+
+// Start starts serving the endpoint API on a random local port
+func (m *Manager) Start() {
+	m.server = httptest.NewServer(m.router())
+}
+
+// Close stops serving the endpoint API
+func (m *Manager) Close() {
+	if m.server != nil {
+		m.server.Close()
+	}
+}
+
+// ClientConfig returns the configuration an agent must use
+// to connect to the mock manager
+func (m *Manager) ClientConfig() config.Client {
+	c := config.Client{
+		Proto:     "http",
+		UUID:      m.uuid,
+		Key:       m.key,
+		ServerKey: m.serverKey,
+	}
+
+	if m.server != nil {
+		addr := m.server.Listener.Addr().(*net.TCPAddr)
+		c.Host = addr.IP.String()
+		c.Port = addr.Port
+	}
+
+	return c
+}
+
+func (m *Manager) router() http.Handler {
+	rt := mux.NewRouter()
+
+	rt.Use(m.authorizationMiddleware)
+	rt.Use(gunzipMiddleware)
+
+	rt.HandleFunc(api.EptAPIServerKeyPath, m.serverKeyHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIRulesPath, m.rulesHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIRulesSha256Path, m.rulesSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPIIoCsPath, m.iocsHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIIoCsEntriesPath, m.iocEntriesHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIIoCsSha256Path, m.iocsSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPIContainersPath, m.containersHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIContainersSha256Path, m.containersSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPISuppressionsPath, m.suppressionsHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPISuppressionsSha256Path, m.suppressionsSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPIPlaybooksPath, m.playbooksHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIPlaybooksSha256Path, m.playbooksSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPITrustedPath, m.trustedHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPITrustedSha256Path, m.trustedSha256Handler).Methods("GET")
+	rt.HandleFunc(api.EptAPISysmonConfigPath, noContentHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPISysmonConfigSha256Path, noContentHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPITools, m.toolsHandler).Methods("GET")
+	rt.HandleFunc(api.EptAPIConfigSha256Path, m.configSha256Handler).Methods("GET")
+
+	rt.HandleFunc(api.EptAPIPostLogsPath, m.logsHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostTapPath, m.tapHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostDumpPath, m.dumpHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostSystemInfo, m.systemInfoHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostUsagePath, m.usageHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostErrorsPath, m.errorsHandler).Methods("POST")
+	rt.HandleFunc(api.EptAPIPostPlaybookRunPath, m.playbookRunHandler).Methods("POST")
+
+	rt.HandleFunc(api.EptAPICommandPath, m.commandHandler).Methods("GET", "POST")
+	rt.HandleFunc(api.EptAPIConfigPath, m.configHandler).Methods("GET", "POST")
+
+	return rt
+}
+
+/////////////////// Manager state
+
+// AddRules adds Gene rules served to the endpoint
+func (m *Manager) AddRules(rules ...*engine.Rule) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, r := range rules {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		m.rules += string(b) + "\n"
+	}
+
+	return nil
+}
+
+// ClearRules removes all the rules served to the endpoint
+func (m *Manager) ClearRules() {
+	m.Lock()
+	defer m.Unlock()
+	m.rules = ""
+}
+
+// AddContainers adds Gene containers served to the endpoint
+func (m *Manager) AddContainers(containers ...*api.EdrContainer) {
+	m.Lock()
+	defer m.Unlock()
+	m.containers = append(m.containers, containers...)
+}
+
+// AddIoCs adds IoCs served to the endpoint
+func (m *Manager) AddIoCs(iocs ...*ioc.IOC) {
+	m.iocs.Add(iocs...)
+}
+
+// AddSuppressions adds false positive suppressions served to the endpoint
+func (m *Manager) AddSuppressions(suppressions ...*api.Suppression) {
+	m.Lock()
+	defer m.Unlock()
+	m.suppressions = append(m.suppressions, suppressions...)
+}
+
+// AddPlaybooks adds response playbooks served to the endpoint
+func (m *Manager) AddPlaybooks(playbooks ...*api.Playbook) {
+	m.Lock()
+	defer m.Unlock()
+	m.playbooks = append(m.playbooks, playbooks...)
+}
+
+// AddTrustedProcesses adds trusted processes served to the endpoint
+func (m *Manager) AddTrustedProcesses(trusted ...*api.TrustedProcess) {
+	m.Lock()
+	defer m.Unlock()
+	m.trusted = append(m.trusted, trusted...)
+}
+
+// SetAgentConfig sets the configuration served to the endpoint
+func (m *Manager) SetAgentConfig(c *aconfig.Agent) {
+	m.Lock()
+	defer m.Unlock()
+	m.agentConfig = c
+}
+
+// AgentConfig returns the configuration of the endpoint,
+// as set by SetAgentConfig or posted by the endpoint
+func (m *Manager) AgentConfig() *aconfig.Agent {
+	m.RLock()
+	defer m.RUnlock()
+	return m.agentConfig
+}
+
+// QueueCommand queues a command to be run by the endpoint,
+// commands are sent one at a time in the order they are queued
+func (m *Manager) QueueCommand(c *api.EndpointCommand) {
+	m.Lock()
+	defer m.Unlock()
+	m.queued = append(m.queued, c)
+}
+
+// Completed returns the commands completed by the endpoint
+func (m *Manager) Completed() []*api.EndpointCommand {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]*api.EndpointCommand{}, m.completed...)
+}
+
+// Pending returns the number of commands not completed yet
+func (m *Manager) Pending() (n int) {
+	m.RLock()
+	defer m.RUnlock()
+	n = len(m.queued)
+	if m.running != nil {
+		n++
+	}
+	return
+}
+
+// Events returns the events forwarded by the endpoint
+func (m *Manager) Events() []*event.EdrEvent {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]*event.EdrEvent{}, m.events...)
+}
+
+// Detections returns the detections forwarded by the endpoint
+func (m *Manager) Detections() (detections []*event.EdrEvent) {
+	m.RLock()
+	defer m.RUnlock()
+	detections = make([]*event.EdrEvent, 0)
+	for _, e := range m.events {
+		if e.IsDetection() {
+			detections = append(detections, e)
+		}
+	}
+	return
+}
+
+// Tapped returns the events live tapped on the endpoint
+func (m *Manager) Tapped() []*event.EdrEvent {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]*event.EdrEvent{}, m.tapped...)
+}
+
+// Uploads returns the file chunks uploaded by the endpoint
+func (m *Manager) Uploads() []*client.FileUpload {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]*client.FileUpload{}, m.uploads...)
+}
+
+// PlaybookRuns returns the playbook runs reported by the endpoint
+func (m *Manager) PlaybookRuns() []*api.PlaybookRun {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]*api.PlaybookRun{}, m.playbookRuns...)
+}
+
+// SystemInfo returns the last system information posted by the endpoint
+func (m *Manager) SystemInfo() *sysinfo.SystemInfo {
+	m.RLock()
+	defer m.RUnlock()
+	return m.systemInfo
+}
+
+// Usage returns the last usage report posted by the endpoint
+func (m *Manager) Usage() *api.UsageReport {
+	m.RLock()
+	defer m.RUnlock()
+	return m.usage
+}
+
+// Errors returns all the errors reported by the endpoint merged into a single report
+func (m *Manager) Errors() *api.AgentErrorReport {
+	m.RLock()
+	defer m.RUnlock()
+	return m.errors
+}
+
+/////////////////// Middlewares
+
+func (m *Manager) authorizationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(api.EndpointUUIDHeader) != m.uuid || r.Header.Get(api.AuthKeyHeader) != m.key {
+			http.Error(w, "Not Authorized", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func gunzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			var err error
+			if r.Body, err = gzip.NewReader(r.Body); err != nil {
+				http.Error(w, "Cannot create gzip reader", http.StatusInternalServerError)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/////////////////// Handlers
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if b, err := json.Marshal(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Write(b)
+	}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func noContentHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) serverKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(m.serverKey))
+}
+
+func (m *Manager) rulesHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	w.Write([]byte(m.rules))
+}
+
+func (m *Manager) rulesSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	w.Write([]byte(data.Sha256([]byte(m.rules))))
+}
+
+func (m *Manager) iocsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, m.iocs.StringSlice())
+}
+
+func (m *Manager) iocEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, m.iocs.Entries())
+}
+
+func (m *Manager) iocsSha256Handler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(m.iocs.Hash()))
+}
+
+func (m *Manager) containersHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	writeJSON(w, m.containers)
+}
+
+func (m *Manager) containersSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	w.Write([]byte(api.ContainersSha256(m.containers)))
+}
+
+func (m *Manager) suppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	writeJSON(w, m.suppressions)
+}
+
+func (m *Manager) suppressionsSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := make([]string, 0, len(m.suppressions))
+	for _, s := range m.suppressions {
+		keys = append(keys, s.Key())
+	}
+	w.Write([]byte(utils.Sha256StringSlice(keys)))
+}
+
+func (m *Manager) playbooksHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	writeJSON(w, m.playbooks)
+}
+
+func (m *Manager) playbooksSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	w.Write([]byte(api.PlaybooksSha256(m.playbooks)))
+}
+
+func (m *Manager) trustedHandler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	writeJSON(w, m.trusted)
+}
+
+func (m *Manager) trustedSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	w.Write([]byte(api.TrustedProcessesSha256(m.trusted)))
+}
+
+func (m *Manager) toolsHandler(w http.ResponseWriter, r *http.Request) {
+	// no tool is ever served
+	w.Write([]byte("{}"))
+}
+
+func (m *Manager) configSha256Handler(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.agentConfig == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if sha256, err := m.agentConfig.Sha256(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Write([]byte(sha256))
+	}
+}
+
+func (m *Manager) configHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m.RLock()
+		defer m.RUnlock()
+		if m.agentConfig == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, m.agentConfig)
+
+	case "POST":
+		c := aconfig.Agent{}
+		if err := readJSON(r, &c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.SetAgentConfig(&c)
+	}
+}
+
+// readEvents reads events sent one JSON event per line
+func readEvents(r io.Reader) (events []*event.EdrEvent, err error) {
+	events = make([]*event.EdrEvent, 0)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), 10*client.Mega)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		e := event.EdrEvent{}
+		if err = json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+		e.NormalizeTime()
+		events = append(events, &e)
+	}
+
+	return events, s.Err()
+}
+
+func (m *Manager) logsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	events, err := readEvents(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.events = append(m.events, events...)
+}
+
+func (m *Manager) tapHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	events, err := readEvents(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.tapped = append(m.tapped, events...)
+}
+
+func (m *Manager) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	fu := client.FileUpload{}
+
+	if err := readJSON(r, &fu); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := fu.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.uploads = append(m.uploads, &fu)
+}
+
+func (m *Manager) systemInfoHandler(w http.ResponseWriter, r *http.Request) {
+	info := sysinfo.SystemInfo{}
+
+	if err := readJSON(r, &info); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.systemInfo = &info
+}
+
+func (m *Manager) usageHandler(w http.ResponseWriter, r *http.Request) {
+	report := api.UsageReport{}
+
+	if err := readJSON(r, &report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.usage = &report
+}
+
+func (m *Manager) errorsHandler(w http.ResponseWriter, r *http.Request) {
+	report := api.AgentErrorReport{}
+
+	if err := readJSON(r, &report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.errors == nil {
+		m.errors = &report
+		return
+	}
+	m.errors.Merge(&report)
+}
+
+func (m *Manager) playbookRunHandler(w http.ResponseWriter, r *http.Request) {
+	run := api.PlaybookRun{}
+
+	if err := readJSON(r, &run); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.playbookRuns = append(m.playbookRuns, &run)
+}
+
+func (m *Manager) commandHandler(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	switch r.Method {
+	case "GET":
+		// a single command runs at a time
+		if m.running != nil || len(m.queued) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		m.running, m.queued = m.queued[0], m.queued[1:]
+		m.running.Sent = true
+		writeJSON(w, m.running)
+
+	case "POST":
+		defer r.Body.Close()
+
+		if m.running == nil {
+			http.Error(w, "no command running", http.StatusBadRequest)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rcmd := api.EndpointCommand{}
+		if err = json.Unmarshal(body, &rcmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = m.running.Complete(&rcmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m.completed = append(m.completed, m.running)
+		m.running = nil
+	}
+}
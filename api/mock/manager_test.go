@@ -0,0 +1,175 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/api/client"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/ioc"
+	"github.com/0xrawsec/whids/utils"
+)
+
+func prepareTest(t *testing.T) (*Manager, *client.ManagerClient) {
+	m := NewManager()
+	m.Start()
+	t.Cleanup(m.Close)
+
+	conf := m.ClientConfig()
+	mc, err := client.NewManagerClient(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, mc
+}
+
+func TestMockAuthentication(t *testing.T) {
+	tt := toast.FromT(t)
+
+	_, mc := prepareTest(t)
+	tt.CheckErr(mc.AuthenticateServer())
+
+	mc.Config.Key = "bad key"
+	_, err := mc.GetRulesSha256()
+	tt.ExpectErr(err, client.ErrServerUnauthenticated)
+}
+
+func TestMockUpdates(t *testing.T) {
+	tt := toast.FromT(t)
+
+	m, mc := prepareTest(t)
+
+	// rules
+	r := engine.NewRule()
+	r.Name = "Mock:Rule"
+	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}}
+	tt.CheckErr(m.AddRules(&r))
+
+	rules, err := mc.GetRules()
+	tt.CheckErr(err)
+	sha256, err := mc.GetRulesSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == data.Sha256([]byte(rules)))
+
+	eng := engine.NewEngine()
+	tt.CheckErr(eng.LoadReader(strings.NewReader(rules)))
+	tt.Assert(eng.Count() == 1)
+
+	// iocs
+	m.AddIoCs(&ioc.IOC{Uuid: utils.UnsafeUUID().String(), Value: "evil.com", Type: "domain"})
+	entries, err := mc.GetIoCEntries()
+	tt.CheckErr(err)
+	tt.Assert(len(entries) == 1)
+	sha256, err = mc.GetIoCsSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == utils.Sha256StringSlice([]string{"evil.com"}))
+
+	// containers
+	m.AddContainers(&api.EdrContainer{Name: "blacklist", Entries: []string{"a", "b"}})
+	containers, err := mc.GetContainers()
+	tt.CheckErr(err)
+	sha256, err = mc.GetContainersSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == api.ContainersSha256(containers))
+
+	// playbooks
+	m.AddPlaybooks(&api.Playbook{Name: "isolate"})
+	playbooks, err := mc.GetPlaybooks()
+	tt.CheckErr(err)
+	sha256, err = mc.GetPlaybooksSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == api.PlaybooksSha256(playbooks))
+
+	// trusted processes
+	m.AddTrustedProcesses(&api.TrustedProcess{Name: "av", Signer: "Contoso", Path: `C:\Program Files\Contoso\`})
+	trusted, err := mc.GetTrustedProcesses()
+	tt.CheckErr(err)
+	tt.Assert(len(trusted) == 1)
+	sha256, err = mc.GetTrustedProcessesSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == api.TrustedProcessesSha256(trusted))
+
+	// no agent configuration
+	_, err = mc.GetAgentConfig()
+	tt.ExpectErr(err, client.ErrNoAgentConfig)
+}
+
+func TestMockCommands(t *testing.T) {
+	tt := toast.FromT(t)
+
+	m, mc := prepareTest(t)
+
+	_, err := mc.FetchCommand()
+	tt.ExpectErr(err, client.ErrNothingToDo)
+
+	for _, cl := range []string{"hostname", "whoami"} {
+		cmd := api.NewEndpointCommand()
+		tt.CheckErr(cmd.SetCommandLine(cl))
+		m.QueueCommand(cmd)
+	}
+	tt.Assert(m.Pending() == 2)
+
+	for i := 0; i < 2; i++ {
+		cmd, err := mc.FetchCommand()
+		tt.CheckErr(err)
+
+		// command is not sent again until completed
+		_, err = mc.FetchCommand()
+		tt.ExpectErr(err, client.ErrNothingToDo)
+
+		cmd.Stdout = []byte(cmd.Name)
+		tt.CheckErr(mc.PostCommand(cmd))
+	}
+
+	tt.Assert(m.Pending() == 0)
+	completed := m.Completed()
+	tt.Assert(len(completed) == 2)
+	tt.Assert(completed[0].Completed)
+	tt.Assert(string(completed[1].Stdout) == "whoami")
+}
+
+func TestMockUploads(t *testing.T) {
+	tt := toast.FromT(t)
+
+	m, mc := prepareTest(t)
+
+	// events
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for i := 0; i < 10; i++ {
+		e := etw.NewEvent()
+		e.System.EventID = uint16(i)
+		edr := event.NewEdrEvent(e)
+		if i%2 == 0 {
+			d := engine.NewDetection(false, false)
+			d.Signature.Add("Mock:Rule")
+			edr.SetDetection(d)
+		}
+		tt.CheckErr(enc.Encode(edr))
+	}
+	tt.CheckErr(mc.PostLogs(buf))
+	tt.Assert(len(m.Events()) == 10)
+	tt.Assert(len(m.Detections()) == 5)
+
+	// dumps
+	fu := &client.FileUpload{
+		Name:      "memdump.dmp",
+		GUID:      "{" + utils.UnsafeUUID().String() + "}",
+		EventHash: data.Md5([]byte("event")),
+		Content:   []byte("content"),
+		Chunk:     1,
+		Total:     1,
+	}
+	tt.CheckErr(mc.PostDump(fu))
+	uploads := m.Uploads()
+	tt.Assert(len(uploads) == 1)
+	tt.Assert(string(uploads[0].Content) == "content")
+}
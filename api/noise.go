@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/google/uuid"
+)
+
+const (
+	// NoiseSuggestionSuppression suggests suppressing alerts of a rule
+	// having a field equal to a value
+	NoiseSuggestionSuppression = "suppression"
+	// NoiseSuggestionRefinement suggests refining a rule on a field
+	// taking many distinct benign values
+	NoiseSuggestionRefinement = "refinement"
+)
+
+// FieldNoise holds statistics about the values an event field
+// takes in the alerts of a rule
+type FieldNoise struct {
+	Field string `json:"field"`
+	// number of distinct values
+	Cardinality int `json:"cardinality"`
+	// more distinct values than the ones tracked
+	Saturated bool   `json:"saturated"`
+	TopValue  string `json:"top-value"`
+	TopHits   int    `json:"top-hits"`
+}
+
+// RuleNoise holds base rates of a rule over the alerts learnt from
+type RuleNoise struct {
+	Signature string `json:"signature"`
+	Hits      int    `json:"hits"`
+	// hits by endpoint
+	Endpoints map[string]int `json:"endpoints"`
+	// mean number of hits per endpoint and per day
+	DailyRate      float64       `json:"daily-rate"`
+	TruePositives  int           `json:"true-positives"`
+	FalsePositives int           `json:"false-positives"`
+	Fields         []*FieldNoise `json:"fields"`
+}
+
+// NoiseSuggestion is a tuning suggestion made out of alerts base rates
+type NoiseSuggestion struct {
+	Uuid      string `json:"uuid"`
+	Kind      string `json:"kind"`
+	Signature string `json:"signature"`
+	Field     string `json:"field"`
+	Value     string `json:"value,omitempty"`
+	// alerts concerned by the suggestion
+	Hits int `json:"hits"`
+	// ratio of the alerts of the rule concerned by the suggestion
+	Ratio float64 `json:"ratio"`
+	// number of endpoints concerned by the suggestion
+	Endpoints int    `json:"endpoints"`
+	Reason    string `json:"reason"`
+}
+
+// NewNoiseSuggestion creates a new suggestion, suggestion uuid
+// is always the same for a given kind, signature, field and value
+func NewNoiseSuggestion(kind, signature, field, value string) *NoiseSuggestion {
+	s := &NoiseSuggestion{
+		Kind:      kind,
+		Signature: signature,
+		Field:     field,
+		Value:     value,
+	}
+	name := strings.ToLower(fmt.Sprintf("%s|%s|%s|%s", kind, signature, field, value))
+	s.Uuid = uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+	return s
+}
+
+// Suppression returns the suppression to create if the suggestion is
+// accepted, nil if the suggestion cannot be turned into a suppression
+func (s *NoiseSuggestion) Suppression() *Suppression {
+	if s.Kind != NoiseSuggestionSuppression {
+		return nil
+	}
+
+	return &Suppression{
+		Signature: s.Signature,
+		Field:     s.Field,
+		Value:     s.Value,
+	}
+}
+
+// NoiseReport is the result of alerts noise modeling, it holds
+// rules base rates and tuning suggestions
+type NoiseReport struct {
+	sod.Item
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Generated   time.Time          `json:"generated"`
+	Alerts      int                `json:"alerts"`
+	Rules       []*RuleNoise       `json:"rules"`
+	Suggestions []*NoiseSuggestion `json:"suggestions"`
+}
+
+// NewNoiseReport creates a new empty NoiseReport over [start; end]
+func NewNoiseReport(start, end time.Time) *NoiseReport {
+	return &NoiseReport{
+		Start:       start,
+		End:         end,
+		Rules:       make([]*RuleNoise, 0),
+		Suggestions: make([]*NoiseSuggestion, 0),
+	}
+}
+
+// Suggestion returns the suggestion identified by uuid
+func (r *NoiseReport) Suggestion(uuid string) (s *NoiseSuggestion, ok bool) {
+	for _, s = range r.Suggestions {
+		if s.Uuid == uuid {
+			return s, true
+		}
+	}
+	return nil, false
+}
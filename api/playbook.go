@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// PlaybookStepSuspend suspends the process at the origin of the alert
+	PlaybookStepSuspend = "suspend"
+	// PlaybookStepKill kills the process at the origin of the alert
+	PlaybookStepKill = "kill"
+	// PlaybookStepMemdump dumps the memory of the process at the origin of the alert
+	PlaybookStepMemdump = "memdump"
+	// PlaybookStepFiledump dumps the files related to the alert
+	PlaybookStepFiledump = "filedump"
+	// PlaybookStepTriage collects a full IR report along with the files related to the alert
+	PlaybookStepTriage = "triage"
+	// PlaybookStepIsolate contains the endpoint, arguments are
+	// the containment profile and an optional duration
+	PlaybookStepIsolate = "isolate"
+	// PlaybookStepNotify sends a notification to the manager, arguments
+	// are concatenated to build the message
+	PlaybookStepNotify = "notify"
+)
+
+var (
+	PlaybookSteps = []string{
+		PlaybookStepSuspend,
+		PlaybookStepKill,
+		PlaybookStepMemdump,
+		PlaybookStepFiledump,
+		PlaybookStepTriage,
+		PlaybookStepIsolate,
+		PlaybookStepNotify,
+	}
+
+	// DestructivePlaybookSteps playbook steps as destructive as destructive
+	// commands, playbooks are not approved within responder sessions so those
+	// are refused by endpoints requiring approvals
+	DestructivePlaybookSteps = []string{
+		PlaybookStepSuspend,
+		PlaybookStepKill,
+		PlaybookStepIsolate,
+	}
+)
+
+// IsDestructivePlaybookStep returns true if action is a destructive playbook step
+func IsDestructivePlaybookStep(action string) bool {
+	for _, d := range DestructivePlaybookSteps {
+		if action == d {
+			return true
+		}
+	}
+	return false
+}
+
+// PlaybookStep is a response step of a playbook
+type PlaybookStep struct {
+	Action string   `json:"action"`
+	Args   []string `json:"args,omitempty"`
+	// by default a playbook stops at the first step failing
+	ContinueOnError bool `json:"continue-on-error,omitempty"`
+}
+
+// Validate checks that the step is valid
+func (s *PlaybookStep) Validate() error {
+	for _, a := range PlaybookSteps {
+		if s.Action == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown playbook action: %s", s.Action)
+}
+
+// Playbook is an ordered list of response steps defined on the manager
+// and run by endpoints when an alert is raised by a rule having one
+// of playbook's tags. A playbook replaces rule's own actions.
+type Playbook struct {
+	sod.Item
+	Name        string `json:"name" sod:"unique"`
+	Description string `json:"description"`
+	// rule tags the playbook is bound to
+	Tags  []string        `json:"tags"`
+	Steps []*PlaybookStep `json:"steps"`
+	// only enabled playbooks are pushed to endpoints
+	Enabled bool `json:"enabled"`
+}
+
+// Validate overwrite sod.Item function
+func (p *Playbook) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name field is mandatory")
+	}
+
+	if len(p.Tags) == 0 {
+		return fmt.Errorf("playbook must be bound to at least one rule tag")
+	}
+
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("playbook must have at least one step")
+	}
+
+	for i, s := range p.Steps {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Destructive returns true if playbook has a destructive step
+func (p *Playbook) Destructive() bool {
+	for _, s := range p.Steps {
+		if IsDestructivePlaybookStep(s.Action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if playbook is bound to one of the tags
+func (p *Playbook) Match(tags []string) bool {
+	for _, pt := range p.Tags {
+		for _, t := range tags {
+			if strings.EqualFold(pt, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Key returns a key identifying a playbook and its content
+func (p *Playbook) Key() string {
+	// marshalling cannot fail on this structure
+	steps, _ := json.Marshal(p.Steps)
+	return fmt.Sprintf("%s|%s|%s", p.Name, strings.Join(p.Tags, ","), steps)
+}
+
+// PlaybooksSha256 computes the sha256 of a list of playbooks, the
+// same for a given set of playbooks whatever their order
+func PlaybooksSha256(playbooks []*Playbook) string {
+	keys := make([]string, 0, len(playbooks))
+	for _, p := range playbooks {
+		keys = append(keys, p.Key())
+	}
+	sort.Strings(keys)
+	return utils.Sha256StringSlice(keys)
+}
+
+// PlaybookStepResult is the result of a playbook step run on an endpoint
+type PlaybookStepResult struct {
+	Action   string        `json:"action"`
+	Success  bool          `json:"success"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// PlaybookRun reports the run of a playbook on an endpoint
+type PlaybookRun struct {
+	sod.Item
+	Playbook     string                `json:"playbook" sod:"index"`
+	EndpointUUID string                `json:"endpoint-uuid" sod:"index"`
+	EventHash    string                `json:"event-hash"`
+	Signature    []string              `json:"signature"`
+	Start        time.Time             `json:"start" sod:"index"`
+	End          time.Time             `json:"end"`
+	Success      bool                  `json:"success"`
+	Steps        []*PlaybookStepResult `json:"steps"`
+}
+
+// NewPlaybookRun creates a new playbook run
+func NewPlaybookRun(playbook string) *PlaybookRun {
+	return &PlaybookRun{
+		Playbook: playbook,
+		Start:    time.Now().UTC(),
+		Steps:    make([]*PlaybookStepResult, 0),
+	}
+}
+
+// Done must be called once all the steps are run
+func (r *PlaybookRun) Done() {
+	r.End = time.Now().UTC()
+	r.Success = true
+	for _, s := range r.Steps {
+		if !s.Success {
+			r.Success = false
+			return
+		}
+	}
+}
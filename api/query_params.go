@@ -30,4 +30,18 @@ const (
 	QpOS          = "os"
 	QpBinary      = "binary"
 	QpHash        = "hash"
+	QpPropose     = "propose"
+	QpShare       = "share"
+	QpAggregate   = "aggregate"
+	QpValidity    = "validity"
+	QpDead        = "dead"
+	QpIncident    = "incident"
+	QpKind        = "kind"
+	QpNewTOTP     = "newtotp"
+	QpTOTP        = "totp"
+	QpSession     = "session"
+	QpFrom        = "from"
+	QpTo          = "to"
+	QpTag         = "tag"
+	QpBucket      = "bucket"
 )
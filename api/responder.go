@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	ErrBadApproval      = errors.New("malformed responder approval")
+	ErrApprovalSig      = errors.New("bad responder approval signature")
+	ErrApprovalExpired  = errors.New("responder approval expired")
+	ErrApprovalMismatch = errors.New("responder approval does not match command")
+	ErrApprovalRequired = errors.New("destructive command requires a responder approval")
+)
+
+var (
+	// DestructiveCommands commands requiring an approval issued
+	// within a responder session
+	DestructiveCommands = []string{
+		"terminate",
+		"suspend",
+		"resume",
+		"quarantine",
+		"contain",
+		"uncontain",
+		"isolate-process",
+		"release-process",
+		"block",
+		"disable-user",
+		// reverts any of the above
+		RevertCommand,
+	}
+)
+
+// IsDestructiveCommand returns true if command name is a destructive command
+func IsDestructiveCommand(name string) bool {
+	for _, d := range DestructiveCommands {
+		if name == d {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponderSession is a short-lived session opened by a manager user,
+// after role and second factor checks, within which destructive commands
+// can be sent to a restricted set of endpoints
+type ResponderSession struct {
+	sod.Item
+	Uuid      string    `json:"uuid" sod:"unique"`
+	User      string    `json:"user" sod:"index"`
+	Endpoints []string  `json:"endpoints"`
+	Reason    string    `json:"reason"`
+	Issued    time.Time `json:"issued"`
+	Expires   time.Time `json:"expires"`
+}
+
+// NewResponderSession creates a new responder session starting now
+func NewResponderSession(user string, endpoints []string, reason string, validity time.Duration) *ResponderSession {
+	now := time.Now().UTC()
+	s := &ResponderSession{
+		Uuid:      utils.UnsafeUUID().String(),
+		User:      user,
+		Endpoints: endpoints,
+		Reason:    reason,
+		Issued:    now,
+		Expires:   now.Add(validity),
+	}
+	s.Initialize(s.Uuid)
+	return s
+}
+
+// Covers returns true if session allows sending destructive
+// commands to endpoint at time now
+func (s *ResponderSession) Covers(endpoint string, now time.Time) bool {
+	if now.After(s.Expires) {
+		return false
+	}
+
+	for _, e := range s.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResponderApproval is the content of an approval signed by the manager
+// allowing an endpoint to run a destructive command with its arguments
+type ResponderApproval struct {
+	Purpose  string    `json:"purpose"`
+	Session  string    `json:"session"`
+	User     string    `json:"user"`
+	Endpoint string    `json:"endpoint-uuid"`
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Args     []string  `json:"args"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Approve attaches to the command an approval, issued within session s,
+// allowing endpoint to run it until the session expires
+func (c *EndpointCommand) Approve(k *ContainmentKey, s *ResponderSession, endpoint string) (err error) {
+	a := ResponderApproval{
+		Purpose:  PurposeApproval,
+		Session:  s.Uuid,
+		User:     s.User,
+		Endpoint: endpoint,
+		UUID:     c.UUID,
+		Name:     c.Name,
+		Args:     c.Args,
+		Expires:  s.Expires.UTC(),
+	}
+
+	c.Approval, err = k.sign(&a)
+	return
+}
+
+// VerifyApproval verifies command approval against manager's public key
+// (hex encoded) and checks it has been issued for endpoint and is not expired
+func (c *EndpointCommand) VerifyApproval(pubkey, endpoint string, now time.Time) (a *ResponderApproval, err error) {
+	a = &ResponderApproval{}
+
+	if c.Approval == "" {
+		return nil, ErrApprovalRequired
+	}
+
+	if err = verifySigned(c.Approval, pubkey, PurposeApproval, a, ErrBadApproval, ErrApprovalSig); err != nil {
+		return nil, err
+	}
+
+	if a.Endpoint != endpoint || a.UUID != c.UUID || a.Name != c.Name || !sameArgs(a.Args, c.Args) {
+		return nil, ErrApprovalMismatch
+	}
+
+	if now.After(a.Expires) {
+		return nil, ErrApprovalExpired
+	}
+
+	return
+}
@@ -22,8 +22,26 @@ const (
 	EptAPIIoCsPath = "/iocs"
 	// EptAPIIoCsSha256Path API route used to serve sha256 of IOC container
 	EptAPIIoCsSha256Path = "/iocs/sha256"
+	// EptAPIIoCsEntriesPath API route used to serve IOC entries with provenance information
+	EptAPIIoCsEntriesPath = "/iocs/entries"
 	// EptAPITools API route used to update local tools
 	EptAPITools = "/tools"
+	// EptAPIContainersPath API route used to serve Gene containers managed by the manager
+	EptAPIContainersPath = "/containers"
+	// EptAPIContainersSha256Path API route used to serve sha256 of containers
+	EptAPIContainersSha256Path = "/containers/sha256"
+	// EptAPISuppressionsPath API route used to serve false positive suppressions
+	EptAPISuppressionsPath = "/suppressions"
+	// EptAPISuppressionsSha256Path API route used to serve sha256 of suppressions
+	EptAPISuppressionsSha256Path = "/suppressions/sha256"
+	// EptAPIPlaybooksPath API route used to serve response playbooks
+	EptAPIPlaybooksPath = "/playbooks"
+	// EptAPIPlaybooksSha256Path API route used to serve sha256 of playbooks
+	EptAPIPlaybooksSha256Path = "/playbooks/sha256"
+	// EptAPITrustedPath API route used to serve trusted processes
+	EptAPITrustedPath = "/trusted"
+	// EptAPITrustedSha256Path API route used to serve sha256 of trusted processes
+	EptAPITrustedSha256Path = "/trusted/sha256"
 
 	// POST based API routes
 
@@ -33,6 +51,14 @@ const (
 	EptAPIPostDumpPath = "/upload/dumps"
 	// EptAPIPostSystemInfo API route used to send system information
 	EptAPIPostSystemInfo = "/info/system"
+	// EptAPIPostUsagePath API route used to report rules and containers usage
+	EptAPIPostUsagePath = "/usage"
+	// EptAPIPostErrorsPath API route used to report agent errors
+	EptAPIPostErrorsPath = "/errors"
+	// EptAPIPostTapPath API route used to post events live tapped
+	EptAPIPostTapPath = "/tap"
+	// EptAPIPostPlaybookRunPath API route used to report playbook runs
+	EptAPIPostPlaybookRunPath = "/playbooks/runs"
 
 	// GET and POST routes
 
@@ -46,6 +72,10 @@ var (
 		EptAPICommandPath,
 		EptAPIRulesSha256Path,
 		EptAPIIoCsSha256Path,
+		EptAPISuppressionsSha256Path,
+		EptAPIContainersSha256Path,
+		EptAPIPlaybooksSha256Path,
+		EptAPITrustedSha256Path,
 	}
 )
 
@@ -57,9 +87,57 @@ const (
 	AdmAPIUserByID = AdmAPIUsers + "/{uuuid:" + uuidRe + "}"
 
 	AdmAPIStatsPath     = "/stats"
+	AdmAPIMetricsPath   = "/metrics"
 	AdmAPIIocsPath      = "/iocs"
 	AdmAPIRulesPath     = "/rules"
 	AdmAPIEndpointsPath = "/endpoints"
+	AdmAPIGroupsPath    = "/groups"
+
+	// Gene containers related
+	AdmAPIContainersPath        = "/containers"
+	AdmAPIContainersEditPath    = AdmAPIContainersPath + "/edit"
+	AdmAPIContainersHistoryPath = AdmAPIContainersPath + "/history"
+
+	// Containment related
+	AdmAPIContainmentKeyPath = "/containment/key"
+
+	// Responder sessions related
+	AdmAPIResponderSessionsPath = "/responder/sessions"
+
+	// Rules rollout related
+	AdmAPICandidateRulesPath = AdmAPIRulesPath + "/candidate"
+	AdmAPIPromoteRulesPath   = AdmAPICandidateRulesPath + "/promote"
+	AdmAPIRulesRolloutPath   = AdmAPIRulesPath + "/rollout"
+	// Rules and containers usage
+	AdmAPIRulesEffectivenessPath = AdmAPIRulesPath + "/effectiveness"
+	// Rules and containers deployed to endpoints or rule sets
+	AdmAPIRulesDiffPath = AdmAPIRulesPath + "/diff"
+	// Timeline of the hits of a rule across endpoints
+	AdmAPIRuleHitsPath = AdmAPIRulesPath + "/{name}/hits"
+
+	// Alert verdicts related
+	AdmAPIVerdictsPath     = "/verdicts"
+	AdmAPISuppressionsPath = "/suppressions"
+
+	// Alerts noise modeling related
+	AdmAPINoisePath       = "/noise"
+	AdmAPINoiseAcceptPath = AdmAPINoisePath + "/accept"
+
+	// Canary triggers related
+	AdmAPICanariesPath = "/canaries"
+
+	// Response playbooks related
+	AdmAPIPlaybooksPath    = "/playbooks"
+	AdmAPIPlaybookRunsPath = AdmAPIPlaybooksPath + "/runs"
+
+	// Trusted processes related
+	AdmAPITrustedPath = "/trusted"
+
+	// Incidents related
+	AdmAPIIncidentsPath         = "/incidents"
+	AdmAPIIncidentByIDPath      = AdmAPIIncidentsPath + "/{iuuid:" + uuidRe + "}"
+	AdmAPIIncidentRestoreSuffix = "/restore"
+	AdmAPIIncidentRestorePath   = AdmAPIIncidentByIDPath + AdmAPIIncidentRestoreSuffix
 
 	AdmAPIEndpointsOSPath = AdmAPIEndpointsPath + `/{os:\w+}`
 
@@ -86,6 +164,10 @@ const (
 	AdmAPIEndpointLogsPath       = AdmAPIEndpointsByIDPath + AdmAPILogsSuffix
 	AdmAPIDetectionSuffix        = "/detections"
 	AdmAPIEndpointDetectionsPath = AdmAPIEndpointsByIDPath + AdmAPIDetectionSuffix
+	AdmAPIDetectionsExportPath   = AdmAPIDetectionSuffix + "/export"
+	// Evidence bundle of an alert identified by its hash
+	AdmAPIEvidenceSuffix                = "/evidence"
+	AdmAPIEndpointDetectionEvidencePath = AdmAPIEndpointDetectionsPath + "/{ehash:[[:xdigit:]]+}" + AdmAPIEvidenceSuffix
 	// Reports related
 	AdmAPIReportSuffix              = "/report"
 	AdmAPIEndpointsReportsPath      = AdmAPIEndpointsPath + "/reports"
@@ -97,6 +179,21 @@ const (
 	AdmAPIEndpointsArtifactsPath = AdmAPIEndpointsPath + AdmAPIArticfactsSuffix
 	AdmAPIEndpointArtifacts      = AdmAPIEndpointsByIDPath + AdmAPIArticfactsSuffix
 	AdmAPIEndpointArtifact       = AdmAPIEndpointArtifacts + "/{pguid:" + uuidRe + "}/{ehash:[[:xdigit:]]+}/{fname:.*}"
+	// Artifacts cold storage
+	AdmAPIArtifactsArchivePath = AdmAPIEndpointsArtifactsPath + "/archive"
+	AdmAPIArtifactsRestorePath = AdmAPIArtifactsArchivePath + "/restore"
+	// Sysmon config recommendations related
+	AdmAPISysmonRecommendationsSuffix       = "/sysmon/recommendations"
+	AdmAPIEndpointSysmonRecommendationsPath = AdmAPIEndpointsByIDPath + AdmAPISysmonRecommendationsSuffix
+	// Containment emergency release related
+	AdmAPIReleaseTokenSuffix       = "/containment/release-token"
+	AdmAPIEndpointReleaseTokenPath = AdmAPIEndpointsByIDPath + AdmAPIReleaseTokenSuffix
+
+	AdmAPITapSuffix       = "/tap"
+	AdmAPIEndpointTapPath = AdmAPIEndpointsByIDPath + AdmAPITapSuffix
+
+	AdmAPIErrorsSuffix       = "/errors"
+	AdmAPIEndpointErrorsPath = AdmAPIEndpointsByIDPath + AdmAPIErrorsSuffix
 
 	//Websockets
 	AdmAPIStreamEvents     = "/stream/events"
@@ -0,0 +1,33 @@
+package api
+
+import "time"
+
+// RuleHitsBucket holds the hits of a rule within a time bucket
+type RuleHitsBucket struct {
+	Start time.Time `json:"start"`
+	Hits  int64     `json:"hits"`
+	// hits by endpoint UUID, endpoints without hit are omitted
+	Endpoints map[string]int64 `json:"endpoints"`
+}
+
+// RuleHitsEndpoint holds the hits of a rule on an endpoint
+type RuleHitsEndpoint struct {
+	Endpoint string    `json:"endpoint"`
+	Hostname string    `json:"hostname"`
+	Hits     int64     `json:"hits"`
+	FirstHit time.Time `json:"first-hit"`
+	LastHit  time.Time `json:"last-hit"`
+}
+
+// RuleHits is the timeline of the alerts raised by a rule
+// across the fleet between Start and End
+type RuleHits struct {
+	Rule  string    `json:"rule"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// size of the buckets (Go time.Duration format)
+	Bucket    string              `json:"bucket"`
+	Hits      int64               `json:"hits"`
+	Buckets   []*RuleHitsBucket   `json:"buckets"`
+	Endpoints []*RuleHitsEndpoint `json:"endpoints"`
+}
@@ -1,11 +1,220 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
 )
 
 type EdrRule struct {
 	sod.Item
 	engine.Rule
 }
+
+// EdrCandidateRule is a rule staged for a rollout, it is
+// only pushed to endpoints part of the candidate cohort
+type EdrCandidateRule struct {
+	sod.Item
+	engine.Rule
+}
+
+const (
+	CohortStable    = "stable"
+	CohortCandidate = "candidate"
+)
+
+// RulesRollout structure used to configure the staged
+// rollout of candidate rules
+type RulesRollout struct {
+	sod.Item
+	// percentage of endpoints receiving candidate rules
+	Percentage int `json:"percentage"`
+	// groups of endpoints always receiving candidate rules
+	Groups []string `json:"groups"`
+}
+
+// Validate overwrite sod.Item function
+func (r *RulesRollout) Validate() error {
+	if r.Percentage < 0 || r.Percentage > 100 {
+		return fmt.Errorf("percentage field must be in [0;100]")
+	}
+	return nil
+}
+
+// Cohort returns the rollout cohort an endpoint belongs to. Cohort
+// assignment is deterministic so that an endpoint always stays in
+// the same cohort for a given percentage.
+func (r *RulesRollout) Cohort(e *Endpoint) string {
+	for _, g := range r.Groups {
+		if e.InGroup(g) {
+			return CohortCandidate
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.Uuid))
+	if int(h.Sum32()%100) < r.Percentage {
+		return CohortCandidate
+	}
+
+	return CohortStable
+}
+
+// CohortStats holds detection statistics of a rollout cohort
+type CohortStats struct {
+	Endpoints      int               `json:"endpoints"`
+	Events         uint64            `json:"events"`
+	Detections     uint64            `json:"detections"`
+	TruePositives  uint64            `json:"true-positives"`
+	FalsePositives uint64            `json:"false-positives"`
+	Signatures     map[string]uint64 `json:"signatures"`
+}
+
+// NewCohortStats creates a new CohortStats
+func NewCohortStats() *CohortStats {
+	return &CohortStats{Signatures: make(map[string]uint64)}
+}
+
+// RolloutStats comparative statistics of rollout cohorts
+type RolloutStats struct {
+	Since     time.Time               `json:"since"`
+	Rollout   *RulesRollout           `json:"rollout"`
+	Candidate []string                `json:"candidate-rules"`
+	Cohorts   map[string]*CohortStats `json:"cohorts"`
+}
+
+// NewRolloutStats creates a new RolloutStats
+func NewRolloutStats() *RolloutStats {
+	return &RolloutStats{
+		Since: time.Now().UTC(),
+		Cohorts: map[string]*CohortStats{
+			CohortStable:    NewCohortStats(),
+			CohortCandidate: NewCohortStats(),
+		},
+	}
+}
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// RuleDiff is a rule differing between two rule sets
+type RuleDiff struct {
+	Name   string       `json:"name"`
+	Change string       `json:"change"`
+	From   *engine.Rule `json:"from,omitempty"`
+	To     *engine.Rule `json:"to,omitempty"`
+}
+
+// ContainerDiff is a container differing between two sets of containers
+type ContainerDiff struct {
+	Name    string   `json:"name"`
+	Change  string   `json:"change"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// RulesDiff holds the differences between the rules and
+// containers deployed to two endpoints or rule sets
+type RulesDiff struct {
+	From                 string           `json:"from"`
+	To                   string           `json:"to"`
+	FromRulesSha256      string           `json:"from-rules-sha256"`
+	ToRulesSha256        string           `json:"to-rules-sha256"`
+	FromContainersSha256 string           `json:"from-containers-sha256"`
+	ToContainersSha256   string           `json:"to-containers-sha256"`
+	Rules                []*RuleDiff      `json:"rules"`
+	Containers           []*ContainerDiff `json:"containers"`
+}
+
+// parseRawRules parses rules as served to endpoints, one JSON rule per line
+func parseRawRules(raw string) (rules map[string]*engine.Rule, err error) {
+	rules = make(map[string]*engine.Rule)
+
+	dec := json.NewDecoder(strings.NewReader(raw))
+	for dec.More() {
+		r := &engine.Rule{}
+		if err = dec.Decode(r); err != nil {
+			return nil, fmt.Errorf("failed to parse rule: %w", err)
+		}
+		rules[r.Name] = r
+	}
+
+	return
+}
+
+// DiffRawRules returns the rules added, removed and modified between two
+// sets of rules formatted as served to endpoints, sorted by rule name
+func DiffRawRules(from, to string) (diffs []*RuleDiff, err error) {
+	var fromRules, toRules map[string]*engine.Rule
+
+	if fromRules, err = parseRawRules(from); err != nil {
+		return
+	}
+
+	if toRules, err = parseRawRules(to); err != nil {
+		return
+	}
+
+	diffs = make([]*RuleDiff, 0)
+	for name, f := range fromRules {
+		t, ok := toRules[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, &RuleDiff{Name: name, Change: DiffRemoved, From: f})
+		case !bytes.Equal(utils.JsonOrPanic(f), utils.JsonOrPanic(t)):
+			diffs = append(diffs, &RuleDiff{Name: name, Change: DiffModified, From: f, To: t})
+		}
+	}
+
+	for name, t := range toRules {
+		if _, ok := fromRules[name]; !ok {
+			diffs = append(diffs, &RuleDiff{Name: name, Change: DiffAdded, To: t})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return
+}
+
+// DiffContainers returns the containers added, removed and
+// modified between two sets of containers, sorted by name
+func DiffContainers(from, to []*EdrContainer) (diffs []*ContainerDiff) {
+	toByName := make(map[string]*EdrContainer, len(to))
+	for _, c := range to {
+		toByName[c.Name] = c
+	}
+
+	fromByName := make(map[string]*EdrContainer, len(from))
+	diffs = make([]*ContainerDiff, 0)
+	for _, f := range from {
+		fromByName[f.Name] = f
+		t, ok := toByName[f.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, &ContainerDiff{Name: f.Name, Change: DiffRemoved, Removed: f.Entries})
+		case f.Sha256 != t.Sha256:
+			added, removed := DiffEntries(f.Entries, t.Entries)
+			diffs = append(diffs, &ContainerDiff{Name: f.Name, Change: DiffModified, Added: added, Removed: removed})
+		}
+	}
+
+	for _, t := range to {
+		if _, ok := fromByName[t.Name]; !ok {
+			diffs = append(diffs, &ContainerDiff{Name: t.Name, Change: DiffAdded, Added: t.Entries})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return
+}
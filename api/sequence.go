@@ -0,0 +1,127 @@
+package api
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// MaxSequenceStreams maximum number of event streams tracked by
+	// endpoint, events queued by a previous agent run may still be
+	// received after a new stream started
+	MaxSequenceStreams = 4
+	// MaxSequenceMissing maximum number of missing sequence numbers
+	// tracked by stream, gaps beyond that are kept as untracked ranges
+	MaxSequenceMissing = 100000
+	// MaxSequenceUntracked maximum number of untracked ranges kept by
+	// stream, the oldest ranges are forgotten first
+	MaxSequenceUntracked = 16
+)
+
+type seqRange struct {
+	from uint64
+	to   uint64
+}
+
+type sequenceStream struct {
+	last      uint64
+	missing   map[uint64]bool
+	untracked []seqRange
+	seen      time.Time
+}
+
+// untrack records sequence numbers from to to (included) as not tracked
+func (st *sequenceStream) untrack(from, to uint64) {
+	if from > to {
+		return
+	}
+
+	st.untracked = append(st.untracked, seqRange{from, to})
+	if len(st.untracked) > MaxSequenceUntracked {
+		st.untracked = st.untracked[1:]
+	}
+}
+
+// isUntracked returns true if seq is within an untracked range
+func (st *sequenceStream) isUntracked(seq uint64) bool {
+	for _, r := range st.untracked {
+		if seq >= r.from && seq <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// EventSequence tracks the sequence numbers of the events forwarded
+// by an agent to detect lost and duplicated events
+type EventSequence struct {
+	streams map[string]*sequenceStream
+}
+
+// NewEventSequence creates a new EventSequence
+func NewEventSequence() *EventSequence {
+	return &EventSequence{streams: make(map[string]*sequenceStream)}
+}
+
+// evict removes the least recently seen streams
+func (s *EventSequence) evict() {
+	if len(s.streams) <= MaxSequenceStreams {
+		return
+	}
+
+	ids := make([]string, 0, len(s.streams))
+	for id := range s.streams {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return s.streams[ids[i]].seen.After(s.streams[ids[j]].seen) })
+
+	for _, id := range ids[MaxSequenceStreams:] {
+		delete(s.streams, id)
+	}
+}
+
+// Update accounts for an event with sequence number seq in stream and
+// returns the variation of the number of events lost and the number of
+// duplicates. Lost events are decremented when a missing event is
+// received late. As sequence of an unknown stream may have started before
+// the manager started, the first event received does not account for any
+// event lost. Events whose sequence number is not tracked, because they
+// precede the first event received or belong to a gap too large to be
+// tracked, are neither accounted as lost nor as duplicates.
+func (s *EventSequence) Update(stream string, seq uint64) (lost, duplicates int64) {
+	// events not sequenced
+	if stream == "" || seq == 0 {
+		return
+	}
+
+	st, ok := s.streams[stream]
+	if !ok {
+		st = &sequenceStream{last: seq - 1, missing: make(map[uint64]bool), seen: time.Now()}
+		st.untrack(1, seq-1)
+		s.streams[stream] = st
+		s.evict()
+	}
+	st.seen = time.Now()
+
+	switch {
+	case seq > st.last:
+		gap := seq - st.last - 1
+		if len(st.missing)+int(gap) <= MaxSequenceMissing {
+			for i := st.last + 1; i < seq; i++ {
+				st.missing[i] = true
+			}
+		} else {
+			st.untrack(st.last+1, seq-1)
+		}
+		lost = int64(gap)
+		st.last = seq
+	case st.missing[seq]:
+		delete(st.missing, seq)
+		lost = -1
+	case st.isUntracked(seq):
+	default:
+		duplicates = 1
+	}
+
+	return
+}
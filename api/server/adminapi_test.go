@@ -3,17 +3,27 @@ package server
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/agent/config"
+	"github.com/0xrawsec/whids/agent/sysinfo"
 	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/api/client"
 	"github.com/0xrawsec/whids/event"
@@ -593,3 +603,1906 @@ func TestEventStream(t *testing.T) {
 	t.Logf("Average %.1f EPS/client", sumEps/nclients)
 
 }
+
+func TestRulesRolloutCohort(t *testing.T) {
+	tt := toast.FromT(t)
+
+	r := api.RulesRollout{Percentage: 50, Groups: []string{"canary"}}
+
+	endpt := api.NewEndpoint(utils.UnsafeUUID().String(), "")
+	endpt.Group = "canary"
+	tt.Assert(r.Cohort(endpt) == api.CohortCandidate)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		endpt := api.NewEndpoint(utils.UnsafeUUID().String(), "")
+		cohort := r.Cohort(endpt)
+		// cohort assignment must be stable
+		tt.Assert(cohort == r.Cohort(endpt))
+		counts[cohort]++
+	}
+
+	tt.Assert(counts[api.CohortCandidate] > 400 && counts[api.CohortStable] > 400)
+
+	r.Percentage = 0
+	tt.Assert(r.Cohort(api.NewEndpoint(utils.UnsafeUUID().String(), "")) == api.CohortStable)
+	tt.CheckErr(r.Validate())
+
+	r.Percentage = 101
+	tt.Assert(r.Validate() != nil)
+}
+
+func TestAdminAPIDetectionsExport(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	n, ndet := 1000, 100
+	for e := range emitMixedEvents(n, ndet) {
+		r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBuffer(utils.JsonOrPanic(e)))
+		tt.CheckErr(err)
+		_, err = mc.HTTPClient.Do(r)
+		tt.CheckErr(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	export := func(params map[string]string) (resp *http.Response, body []byte) {
+		var err error
+
+		cl := http.Client{Transport: cconf.Transport()}
+		resp, err = cl.Do(prepare("GET", api.AdmAPIDetectionsExportPath, nil, params))
+		tt.CheckErr(err)
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+		tt.CheckErr(err)
+		return
+	}
+
+	// default format is CSV
+	resp, body := export(map[string]string{api.QpLast: "1d"})
+	tt.Assert(resp.Header.Get("Content-Type") == "text/csv")
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	tt.CheckErr(err)
+	// header + detections
+	tt.Assert(len(records) == ndet+1, format("Wrong number of records %d instead of %d", len(records), ndet+1))
+	tt.Assert(records[0][0] == "Timestamp")
+	for _, r := range records[1:] {
+		tt.Assert(r[1] == mc.Config.UUID)
+	}
+
+	// filtering on endpoint and criticality
+	resp, body = export(map[string]string{
+		api.QpLast:        "1d",
+		api.QpUuid:        mc.Config.UUID,
+		api.QpCriticality: "11"})
+	records, err = csv.NewReader(bytes.NewReader(body)).ReadAll()
+	tt.CheckErr(err)
+	tt.Assert(len(records) == 1)
+
+	// parquet format
+	resp, body = export(map[string]string{api.QpLast: "1d", api.QpFormat: ExportFormatParquet})
+	tt.Assert(resp.Header.Get("Content-Type") == "application/vnd.apache.parquet")
+	tt.Assert(bytes.HasPrefix(body, []byte("PAR1")))
+	tt.Assert(bytes.HasSuffix(body, []byte("PAR1")))
+
+	// unknown format
+	_, body = export(map[string]string{api.QpFormat: "xlsx"})
+	r := AdminAPIResponse{}
+	tt.CheckErr(json.Unmarshal(body, &r))
+	tt.Assert(r.Err() != nil)
+}
+
+func TestAdminAPIDetectionEvidence(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	defer func(c EvidenceConfig) { m.Config.Evidence = c }(m.Config.Evidence)
+	m.Config.Evidence = EvidenceConfig{Drop: []string{"Hashes"}, Salt: "s3cr3t"}
+
+	var alert event.EdrEvent
+	for _, e := range events {
+		if e.IsDetection() {
+			alert = e
+			break
+		}
+	}
+
+	d := engine.NewDetection(true, false)
+	d.Signature.Add("SuspiciousBinary")
+	d.Criticality = 7
+	d.ATTACK = append(d.ATTACK, engine.Attack{ID: "T1059.001", Tactic: "execution"})
+
+	e := etw.NewEvent()
+	e.System = alert.Event.System
+	e.System.Computer = "WKS-ALICE"
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData["User"] = `CORP\alice`
+	e.EventData["Image"] = `C:\Users\alice\AppData\Local\Temp\evil.exe`
+	e.EventData["CurrentDirectory"] = `C:\Users\Public\`
+	e.EventData["Hashes"] = "SHA1=DEADBEEF"
+	alert = *event.NewEdrEvent(e)
+	alert.SetDetection(d)
+
+	r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBuffer(utils.JsonOrPanic(alert)))
+	tt.CheckErr(err)
+	_, err = mc.HTTPClient.Do(r)
+	tt.CheckErr(err)
+
+	time.Sleep(1 * time.Second)
+
+	evidencePath := func(hash string) string {
+		return api.AdmAPIEndpointsPath + "/" + cconf.UUID + api.AdmAPIDetectionSuffix + "/" + hash + api.AdmAPIEvidenceSuffix
+	}
+
+	resp := get(evidencePath(alert.Hash()) + "?" + api.QpLast + "=1h")
+	tt.CheckErr(resp.Err())
+
+	b := EvidenceBundle{}
+	tt.CheckErr(resp.UnmarshalData(&b))
+	tt.Assert(b.Format == EvidenceFormat)
+	tt.Assert(b.Level == "high")
+	tt.Assert(b.Title == "SuspiciousBinary")
+	tt.Assert(len(b.Tags) == 2 && b.Tags[0] == "attack.execution" && b.Tags[1] == "attack.t1059.001", b.Tags)
+	tt.Assert(b.LogSource.Product == "windows")
+	tt.Assert(b.Event.EventID == alert.EventID())
+
+	// identifying information must not be shared
+	raw := string(utils.JsonOrPanic(b))
+	tt.Assert(!strings.Contains(strings.ToLower(raw), "alice"), raw)
+	tt.Assert(!strings.Contains(raw, cconf.UUID), raw)
+	tt.Assert(strings.HasPrefix(b.Event.Computer, evidencePseudoPrefix))
+	tt.Assert(strings.HasPrefix(b.Event.Fields["User"].(string), evidencePseudoPrefix))
+	tt.Assert(strings.HasPrefix(b.Event.Fields["Image"].(string), `C:\Users\`+evidencePseudoPrefix))
+	tt.Assert(strings.HasSuffix(b.Event.Fields["Image"].(string), `\AppData\Local\Temp\evil.exe`))
+	tt.Assert(b.Event.Fields["CurrentDirectory"] == `C:\Users\Public\`)
+	tt.Assert(b.Redacted[0] == "User")
+	// dropped field
+	_, ok := b.Event.Fields["Hashes"]
+	tt.Assert(!ok)
+	tt.Assert(len(b.Dropped) == 1 && b.Dropped[0] == "Hashes")
+
+	// pseudonyms are stable so that evidences can be correlated
+	resp = get(evidencePath(alert.Hash()) + "?" + api.QpLast + "=1h")
+	tt.CheckErr(resp.Err())
+	other := EvidenceBundle{}
+	tt.CheckErr(resp.UnmarshalData(&other))
+	tt.Assert(other.Event.Fields["User"] == b.Event.Fields["User"])
+	tt.Assert(other.ID == b.ID)
+
+	// unknown alert
+	resp = get(evidencePath("deadbeef") + "?" + api.QpLast + "=1h")
+	tt.Assert(resp.Err() != nil)
+}
+
+func TestAdminAPIMetrics(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+
+	n, ndet := 1000, 100
+	for e := range emitMixedEvents(n, ndet) {
+		r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBuffer(utils.JsonOrPanic(e)))
+		tt.CheckErr(err)
+		_, err = mc.HTTPClient.Do(r)
+		tt.CheckErr(err)
+	}
+
+	// an event the manager cannot decode
+	r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBufferString("{not an event"))
+	tt.CheckErr(err)
+	_, err = mc.HTTPClient.Do(r)
+	tt.CheckErr(err)
+
+	time.Sleep(1 * time.Second)
+
+	v := url.Values{}
+	v.Set(api.QpUuid, euuid)
+	resp := get(api.AdmAPIMetricsPath + "?" + v.Encode())
+	tt.CheckErr(resp.Err())
+
+	rollups := make([]*api.MetricsRollup, 0)
+	tt.CheckErr(resp.UnmarshalData(&rollups))
+	tt.Assert(len(rollups) == 1)
+	tt.Assert(rollups[0].Endpoint == euuid)
+	tt.Assert(rollups[0].Day.Equal(api.MetricsDay(time.Now())))
+	// emitMixedEvents emits n events and ndet detections
+	tt.Assert(rollups[0].Events == int64(n+ndet), format("Wrong number of events %d instead of %d", rollups[0].Events, n+ndet))
+	tt.Assert(rollups[0].Detections == int64(ndet))
+	tt.Assert(rollups[0].Dropped == 1)
+	tt.Assert(rollups[0].EPS > 0)
+	tt.Assert(len(rollups[0].Signatures) > 0)
+
+	// rollups are merged into the ones in database
+	for e := range emitMixedEvents(n, ndet) {
+		r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBuffer(utils.JsonOrPanic(e)))
+		tt.CheckErr(err)
+		_, err = mc.HTTPClient.Do(r)
+		tt.CheckErr(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	v = url.Values{}
+	v.Set(api.QpAggregate, "true")
+	resp = get(api.AdmAPIMetricsPath + "?" + v.Encode())
+	tt.CheckErr(resp.Err())
+
+	rollups = make([]*api.MetricsRollup, 0)
+	tt.CheckErr(resp.UnmarshalData(&rollups))
+	tt.Assert(len(rollups) == 1)
+	tt.Assert(rollups[0].Endpoint == "")
+	tt.Assert(rollups[0].Events == int64(2*(n+ndet)))
+	tt.Assert(rollups[0].Detections == int64(2*ndet))
+
+	// unknown group
+	v = url.Values{}
+	v.Set(api.QpGroup, "unknown")
+	resp = get(api.AdmAPIMetricsPath + "?" + v.Encode())
+	tt.CheckErr(resp.Err())
+	rollups = make([]*api.MetricsRollup, 0)
+	tt.CheckErr(resp.UnmarshalData(&rollups))
+	tt.Assert(len(rollups) == 0)
+}
+
+func TestAdminAPISequenceMetrics(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	send := func(stream string, seqs ...uint64) {
+		for _, seq := range seqs {
+			e := <-emitEvents(1, false)
+			e.Event.EdrData = &event.EdrData{}
+			e.Event.EdrData.Event.Stream = stream
+			e.Event.EdrData.Event.Sequence = seq
+			r, err := mc.PrepareGzip("POST", api.EptAPIPostLogsPath, bytes.NewBuffer(utils.JsonOrPanic(e)))
+			tt.CheckErr(err)
+			_, err = mc.HTTPClient.Do(r)
+			tt.CheckErr(err)
+		}
+	}
+
+	first, second := utils.UnsafeUUID().String(), utils.UnsafeUUID().String()
+	// 4 and 5 missing, 7 duplicated
+	send(first, 1, 2, 3, 6, 7, 7)
+	// agent restarted
+	send(second, 1, 2)
+	// 4 received late from previous run
+	send(first, 4)
+
+	v := url.Values{}
+	v.Set(api.QpUuid, mc.Config.UUID)
+	resp := get(api.AdmAPIMetricsPath + "?" + v.Encode())
+	tt.CheckErr(resp.Err())
+
+	rollups := make([]*api.MetricsRollup, 0)
+	tt.CheckErr(resp.UnmarshalData(&rollups))
+	tt.Assert(len(rollups) == 1)
+	// duplicated event is not ingested
+	tt.Assert(rollups[0].Events == 8)
+	tt.Assert(rollups[0].Lost == 1, format("Wrong number of lost events %d", rollups[0].Lost))
+	tt.Assert(rollups[0].Duplicates == 1)
+}
+
+func TestAdminAPIIngestionDedup(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	buf := new(bytes.Buffer)
+	for e := range emitMixedEvents(10, 10) {
+		buf.Write(utils.JsonOrPanic(e))
+		buf.WriteByte('\n')
+	}
+	batch := buf.Bytes()
+
+	// batch sent again after a timeout
+	tt.CheckErr(mc.PostLogs(bytes.NewBuffer(batch)))
+	tt.CheckErr(mc.PostLogs(bytes.NewBuffer(batch)))
+
+	v := url.Values{}
+	v.Set(api.QpUuid, mc.Config.UUID)
+	resp := get(api.AdmAPIMetricsPath + "?" + v.Encode())
+	tt.CheckErr(resp.Err())
+
+	rollups := make([]*api.MetricsRollup, 0)
+	tt.CheckErr(resp.UnmarshalData(&rollups))
+	tt.Assert(len(rollups) == 1)
+	tt.Assert(rollups[0].Events == 20, format("Wrong number of events %d", rollups[0].Events))
+	tt.Assert(rollups[0].Detections == 10)
+}
+
+func TestAdminAPIDynamicGroups(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+
+	info := &sysinfo.SystemInfo{}
+	info.OS.Build = "22621"
+	tt.CheckErr(mc.PostSystemInfo(info))
+
+	conf := &config.Agent{}
+	conf.CritConfig.Tags = []string{"laptop"}
+	tt.CheckErr(mc.PostAgentConfig(conf))
+
+	members := func(group string) (uuids []string) {
+		endpts := make([]*api.Endpoint, 0)
+		r := get(api.AdmAPIEndpointsPath + "?" + url.Values{api.QpGroup: {group}}.Encode())
+		tt.CheckErr(r.Err())
+		tt.CheckErr(r.UnmarshalData(&endpts))
+		for _, e := range endpts {
+			uuids = append(uuids, e.Uuid)
+		}
+		return
+	}
+
+	groups := []*api.EndpointGroup{
+		{Name: "recent-laptops", Query: "os.build >= 22000 AND tag:laptop"},
+		{Name: "servers", Query: "tag:server OR os.build < 22000"},
+	}
+
+	r := post(api.AdmAPIGroupsPath, JSON(groups))
+	tt.CheckErr(r.Err())
+
+	tt.Assert(len(members("recent-laptops")) == 1)
+	tt.Assert(members("recent-laptops")[0] == euuid)
+	tt.Assert(len(members("servers")) == 0)
+
+	endpt, ok := m.Endpoint(euuid)
+	tt.Assert(ok)
+	tt.Assert(endpt.InGroup("recent-laptops"))
+
+	// group is re-evaluated when endpoint state changes
+	info.OS.Build = "19044"
+	tt.CheckErr(mc.PostSystemInfo(info))
+	tt.Assert(len(members("recent-laptops")) == 0)
+	tt.Assert(len(members("servers")) == 1)
+
+	// updating a group
+	groups[1].Query = "tag:server"
+	r = post(api.AdmAPIGroupsPath, JSON(groups[1:]))
+	tt.CheckErr(r.Err())
+	tt.Assert(len(members("servers")) == 0)
+
+	r = get(api.AdmAPIGroupsPath)
+	tt.CheckErr(r.Err())
+	tt.Assert(len(r.Data.([]interface{})) == 2)
+
+	// invalid query
+	r = post(api.AdmAPIGroupsPath, JSON([]*api.EndpointGroup{{Name: "invalid", Query: "os.build >="}}))
+	tt.Assert(r.Err() != nil)
+
+	// deleting a group
+	r = do(prepare("DELETE", api.AdmAPIGroupsPath, nil, map[string]string{api.QpName: "servers"}))
+	tt.CheckErr(r.Err())
+	r = get(api.AdmAPIGroupsPath)
+	tt.CheckErr(r.Err())
+	tt.Assert(len(r.Data.([]interface{})) == 1)
+}
+
+func TestAdminAPIEndpointTags(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+
+	tagged := func(tag string) (uuids []string) {
+		endpts := make([]*api.Endpoint, 0)
+		r := get(api.AdmAPIEndpointsPath + "?" + url.Values{api.QpTag: {tag}}.Encode())
+		tt.CheckErr(r.Err())
+		tt.CheckErr(r.UnmarshalData(&endpts))
+		for _, e := range endpts {
+			uuids = append(uuids, e.Uuid)
+		}
+		return
+	}
+
+	tt.Assert(len(tagged("under-investigation")) == 0)
+
+	r := post(api.AdmAPIGroupsPath, JSON([]*api.EndpointGroup{{Name: "investigated", Query: "tag:under-investigation"}}))
+	tt.CheckErr(r.Err())
+
+	// tags are reported with every request
+	mc.SetTags([]string{"under-investigation", "vip"})
+	_, err := mc.GetRulesSha256()
+	tt.CheckErr(err)
+
+	tt.Assert(len(tagged("under-investigation")) == 1)
+	tt.Assert(tagged("Under-Investigation")[0] == euuid)
+	tt.Assert(len(tagged("vip")) == 1)
+
+	endpt, ok := m.Endpoint(euuid)
+	tt.Assert(ok)
+	tt.Assert(endpt.InGroup("investigated"))
+
+	// removing all tags
+	mc.SetTags(nil)
+	_, err = mc.GetRulesSha256()
+	tt.CheckErr(err)
+
+	tt.Assert(len(tagged("under-investigation")) == 0)
+	endpt, ok = m.Endpoint(euuid)
+	tt.Assert(ok)
+	tt.Assert(len(endpt.Tags) == 0)
+	tt.Assert(!endpt.InGroup("investigated"))
+
+	// invalid tags are dropped
+	tt.Assert(len(api.ParseTagsHeader("ok,not a tag,,UPPER")) == 2)
+}
+
+func TestAdminAPIPlaybooks(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	empty, err := mc.GetPlaybooksSha256()
+	tt.CheckErr(err)
+
+	playbooks := []*api.Playbook{
+		{
+			Name: "ransomware",
+			Tags: []string{"ransomware"},
+			Steps: []*api.PlaybookStep{
+				{Action: api.PlaybookStepSuspend},
+				{Action: api.PlaybookStepMemdump, ContinueOnError: true},
+				{Action: api.PlaybookStepIsolate, Args: []string{"soft", "4h"}},
+				{Action: api.PlaybookStepNotify, Args: []string{"ransomware", "contained"}},
+			},
+			Enabled: true,
+		},
+		{
+			Name:  "disabled",
+			Tags:  []string{"lateral-movement"},
+			Steps: []*api.PlaybookStep{{Action: api.PlaybookStepTriage}},
+		},
+	}
+
+	r := post(api.AdmAPIPlaybooksPath, JSON(playbooks))
+	tt.CheckErr(r.Err())
+
+	// only enabled playbooks are pushed to endpoints
+	pushed, err := mc.GetPlaybooks()
+	tt.CheckErr(err)
+	tt.Assert(len(pushed) == 1)
+	tt.Assert(pushed[0].Name == "ransomware")
+	tt.Assert(pushed[0].Match([]string{"Ransomware"}))
+
+	sha256, err := mc.GetPlaybooksSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 != empty)
+	tt.Assert(sha256 == api.PlaybooksSha256(pushed))
+
+	// invalid playbooks
+	r = post(api.AdmAPIPlaybooksPath, JSON([]*api.Playbook{{Name: "unknown", Tags: []string{"foo"}, Steps: []*api.PlaybookStep{{Action: "reboot"}}}}))
+	tt.Assert(r.Err() != nil)
+	r = post(api.AdmAPIPlaybooksPath, JSON([]*api.Playbook{{Name: "untagged", Steps: []*api.PlaybookStep{{Action: api.PlaybookStepKill}}}}))
+	tt.Assert(r.Err() != nil)
+
+	// destructive playbooks are refused when responder sessions are enforced
+	func() {
+		defer func(c ResponderConfig) { m.Config.Responder = c }(m.Config.Responder)
+		m.Config.Responder.Enforce = true
+		r = post(api.AdmAPIPlaybooksPath, JSON(playbooks[:1]))
+		tt.Assert(r.Err() != nil)
+		r = post(api.AdmAPIPlaybooksPath, JSON(playbooks[1:]))
+		tt.CheckErr(r.Err())
+	}()
+
+	// reporting a run
+	run := api.NewPlaybookRun("ransomware")
+	run.EventHash = "d41d8cd98f00b204e9800998ecf8427e"
+	run.Signature = []string{"RansomwareRule"}
+	run.Steps = append(run.Steps,
+		&api.PlaybookStepResult{Action: api.PlaybookStepSuspend, Success: true},
+		&api.PlaybookStepResult{Action: api.PlaybookStepMemdump, Error: "access denied"},
+		&api.PlaybookStepResult{Action: api.PlaybookStepIsolate, Success: true},
+		&api.PlaybookStepResult{Action: api.PlaybookStepNotify, Success: true},
+	)
+	run.Done()
+	tt.Assert(!run.Success)
+	tt.CheckErr(mc.PostPlaybookRun(run))
+
+	runs := make([]*api.PlaybookRun, 0)
+	r = get(api.AdmAPIPlaybookRunsPath + "?" + url.Values{api.QpUuid: {mc.Config.UUID}}.Encode())
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&runs))
+	tt.Assert(len(runs) == 1)
+	tt.Assert(runs[0].EndpointUUID == mc.Config.UUID)
+	tt.Assert(len(runs[0].Steps) == 4)
+	tt.Assert(runs[0].Steps[1].Error == "access denied")
+
+	r = get(api.AdmAPIPlaybookRunsPath + "?" + url.Values{api.QpName: {"disabled"}}.Encode())
+	tt.CheckErr(r.Err())
+	tt.Assert(len(r.Data.([]interface{})) == 0)
+
+	// deleting a playbook
+	r = do(prepare("DELETE", api.AdmAPIPlaybooksPath, nil, map[string]string{api.QpName: "ransomware"}))
+	tt.CheckErr(r.Err())
+	pushed, err = mc.GetPlaybooks()
+	tt.CheckErr(err)
+	tt.Assert(len(pushed) == 0)
+}
+
+func TestAdminAPITrusted(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	empty, err := mc.GetTrustedProcessesSha256()
+	tt.CheckErr(err)
+
+	trusted := []*api.TrustedProcess{
+		{
+			Name:    "antivirus",
+			Signer:  "Contoso Corporation",
+			Path:    `C:\Program Files\Contoso\`,
+			Enabled: true,
+		},
+		{
+			Name:   "backup",
+			Signer: "Fabrikam",
+			Path:   `C:\Backup\agent.exe`,
+		},
+	}
+
+	r := post(api.AdmAPITrustedPath, JSON(trusted))
+	tt.CheckErr(r.Err())
+
+	// only enabled trusted processes are pushed to endpoints
+	pushed, err := mc.GetTrustedProcesses()
+	tt.CheckErr(err)
+	tt.Assert(len(pushed) == 1)
+	tt.Assert(pushed[0].Match(`c:\program files\contoso\scan.exe`, "contoso corporation"))
+	tt.Assert(!pushed[0].Match(`C:\Program Files\Contoso\scan.exe`, "Evil Corp"))
+	tt.Assert(!pushed[0].Match(`C:\Users\Public\Contoso\scan.exe`, "Contoso Corporation"))
+
+	sha256, err := mc.GetTrustedProcessesSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 != empty)
+	tt.Assert(sha256 == api.TrustedProcessesSha256(pushed))
+
+	// trusting by signer only is not allowed
+	r = post(api.AdmAPITrustedPath, JSON([]*api.TrustedProcess{{Name: "signer", Signer: "Contoso Corporation"}}))
+	tt.Assert(r.Err() != nil)
+
+	// updating an existing trusted process
+	trusted[1].Enabled = true
+	r = post(api.AdmAPITrustedPath, JSON(trusted[1:]))
+	tt.CheckErr(r.Err())
+	pushed, err = mc.GetTrustedProcesses()
+	tt.CheckErr(err)
+	tt.Assert(len(pushed) == 2)
+
+	// deleting a trusted process
+	r = do(prepare("DELETE", api.AdmAPITrustedPath, nil, map[string]string{api.QpName: "antivirus"}))
+	tt.CheckErr(r.Err())
+	pushed, err = mc.GetTrustedProcesses()
+	tt.CheckErr(err)
+	tt.Assert(len(pushed) == 1)
+	tt.Assert(pushed[0].Name == "backup")
+}
+
+func TestAdminAPIArtifactsArchive(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	mconf.Archive = ArchiveConfig{Dir: "./data/archive", MaxAge: 30}
+	defer func() {
+		os.RemoveAll(mconf.Archive.Dir)
+		mconf.Archive = ArchiveConfig{}
+	}()
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	pguid := utils.UnsafeUUID().String()
+	for _, name := range []string{"foo.txt", "bar.txt"} {
+		tt.CheckErr(mc.PostDump(&client.FileUpload{
+			Name:      name,
+			GUID:      fmt.Sprintf("{%s}", pguid),
+			EventHash: eventHash,
+			Content:   []byte("Blah"),
+			Chunk:     1,
+			Total:     1,
+		}))
+	}
+
+	// recent artifacts are not archived
+	n, err := m.ArchiveArtifacts()
+	tt.CheckErr(err)
+	tt.Assert(n == 0)
+
+	dir := bundleDir(mconf.DumpDir, mc.Config.UUID, pguid, eventHash)
+	old := time.Now().Add(-31 * 24 * time.Hour)
+	entries, err := os.ReadDir(dir)
+	tt.CheckErr(err)
+	for _, e := range entries {
+		tt.CheckErr(os.Chtimes(filepath.Join(dir, e.Name()), old, old))
+	}
+
+	n, err = m.ArchiveArtifacts()
+	tt.CheckErr(err)
+	tt.Assert(n == 1)
+	tt.Assert(!fsutil.Exists(dir))
+
+	// archived artifact cannot be retrieved
+	r := get(format("%s/%s%s/%s/%s/foo.txt", api.AdmAPIEndpointsPath, mc.Config.UUID, api.AdmAPIArticfactsSuffix, pguid, eventHash))
+	tt.Assert(r.Err() != nil)
+
+	// index is kept hot
+	archived := make([]*api.ArchivedArtifact, 0)
+	r = get(api.AdmAPIArtifactsArchivePath + "?" + url.Values{api.QpUuid: {mc.Config.UUID}}.Encode())
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&archived))
+	tt.Assert(len(archived) == 1)
+	tt.Assert(archived[0].Cold)
+	tt.Assert(archived[0].Size == 8)
+	tt.Assert(len(archived[0].Files) == 2)
+
+	// restore needs a selection
+	r = post(api.AdmAPIArtifactsRestorePath, nil)
+	tt.Assert(r.Err() != nil)
+
+	// restoring artifacts of the endpoints involved in an incident
+	incident := api.NewIncident("old compromise")
+	incident.AddActions(&api.ResponseAction{EndpointUUID: mc.Config.UUID, Type: api.ActionIsolation})
+	tt.CheckErr(m.db.InsertOrUpdate(incident))
+
+	r = post(api.AdmAPIArtifactsRestorePath+"?"+url.Values{api.QpIncident: {incident.Uuid}}.Encode(), nil)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&archived))
+	tt.Assert(len(archived) == 1)
+	tt.Assert(!archived[0].Cold)
+	tt.Assert(fsutil.Exists(dir))
+
+	r = get(format("%s/%s%s/%s/%s/foo.txt", api.AdmAPIEndpointsPath, mc.Config.UUID, api.AdmAPIArticfactsSuffix, pguid, eventHash))
+	tt.CheckErr(r.Err())
+
+	// restored artifacts are not archived again straight away
+	n, err = m.ArchiveArtifacts()
+	tt.CheckErr(err)
+	tt.Assert(n == 0)
+}
+
+func TestAdminAPIIncidents(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+	cmdPath := format("%s/%s/command", api.AdmAPIEndpointsPath, euuid)
+
+	getIncident := func(iuuid string) (i *api.Incident) {
+		i = &api.Incident{}
+		r := get(format("%s/%s", api.AdmAPIIncidentsPath, iuuid))
+		tt.CheckErr(r.Err())
+		tt.CheckErr(r.UnmarshalData(i))
+		return
+	}
+
+	// title is mandatory
+	r := post(api.AdmAPIIncidentsPath, JSON(api.Incident{Description: "no title"}))
+	tt.Assert(r.Err() != nil)
+
+	incident := &api.Incident{}
+	r = post(api.AdmAPIIncidentsPath, JSON(api.Incident{Title: "Ransomware on finance"}))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(incident))
+	tt.Assert(incident.Status == api.IncidentOpen)
+	iuuid := incident.Uuid
+
+	// non reversible commands cannot be linked to incidents
+	r = do(prepare("POST", cmdPath, JSON(CommandAPI{CommandLine: "processes"}), map[string]string{api.QpIncident: iuuid}))
+	tt.Assert(r.Err() != nil)
+
+	r = do(prepare("POST", cmdPath, JSON(CommandAPI{CommandLine: "block 10.0.0.1 10.0.0.2"}), map[string]string{api.QpIncident: iuuid}))
+	tt.CheckErr(r.Err())
+	cmd, err := mc.FetchCommand()
+	tt.CheckErr(err)
+	tt.CheckErr(mc.PostCommand(cmd))
+
+	// actions of failed commands are not tracked
+	r = do(prepare("POST", cmdPath, JSON(CommandAPI{CommandLine: "disable-user bob"}), map[string]string{api.QpIncident: iuuid}))
+	tt.CheckErr(r.Err())
+	cmd, err = mc.FetchCommand()
+	tt.CheckErr(err)
+	cmd.Error = "user not found"
+	tt.CheckErr(mc.PostCommand(cmd))
+
+	incident = getIncident(iuuid)
+	tt.Assert(len(incident.Actions) == 2)
+	for _, a := range incident.Actions {
+		tt.Assert(a.Type == api.ActionFirewallBlock)
+		tt.Assert(a.Status == api.ActionStatusActive)
+		tt.Assert(a.EndpointUUID == euuid)
+	}
+
+	// remediate and restore
+	restore := api.IncidentRestore{}
+	r = post(format("%s/%s%s", api.AdmAPIIncidentsPath, iuuid, api.AdmAPIIncidentRestoreSuffix), nil)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&restore))
+	tt.Assert(restore.Incident.IsClosed())
+	tt.Assert(len(restore.Reverting) == 1 && restore.Reverting[0] == euuid)
+
+	// actions cannot be linked to a closed incident
+	r = do(prepare("POST", cmdPath, JSON(CommandAPI{CommandLine: "contain"}), map[string]string{api.QpIncident: iuuid}))
+	tt.Assert(r.Err() != nil)
+
+	cmd, err = mc.FetchCommand()
+	tt.CheckErr(err)
+	tt.Assert(cmd.Name == api.RevertCommand)
+	tt.Assert(cmd.Signature != "")
+	tt.Assert(len(cmd.Args) == 2)
+	cmd.Json = []*api.RevertResult{
+		{Action: cmd.Args[0]},
+		{Action: cmd.Args[1], Error: "firewall rule not found"},
+	}
+	tt.CheckErr(mc.PostCommand(cmd))
+
+	incident = getIncident(iuuid)
+	tt.Assert(incident.Actions[0].Status == api.ActionStatusReverted)
+	tt.Assert(incident.Actions[1].Status == api.ActionStatusFailed)
+	tt.Assert(incident.Actions[1].Error == "firewall rule not found")
+	tt.Assert(!incident.Restored())
+
+	// only failed actions are reverted again
+	r = post(format("%s/%s%s", api.AdmAPIIncidentsPath, iuuid, api.AdmAPIIncidentRestoreSuffix), nil)
+	tt.CheckErr(r.Err())
+	cmd, err = mc.FetchCommand()
+	tt.CheckErr(err)
+	tt.Assert(len(cmd.Args) == 1 && cmd.Args[0] == "firewall-block:10.0.0.2")
+	cmd.Json = []*api.RevertResult{{Action: cmd.Args[0]}}
+	tt.CheckErr(mc.PostCommand(cmd))
+	tt.Assert(getIncident(iuuid).Restored())
+
+	r = get(api.AdmAPIIncidentsPath + "?" + url.Values{api.QpStatus: {api.IncidentOpen}}.Encode())
+	tt.CheckErr(r.Err())
+	tt.Assert(len(r.Data.([]interface{})) == 0)
+
+	r = do(prepare("DELETE", format("%s/%s", api.AdmAPIIncidentsPath, iuuid), nil, nil))
+	tt.CheckErr(r.Err())
+	r = get(api.AdmAPIIncidentsPath)
+	tt.CheckErr(r.Err())
+	tt.Assert(len(r.Data.([]interface{})) == 0)
+}
+
+func TestAdminAPIRuleEffectiveness(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	findEff := func(effs []*api.RuleEffectiveness, kind, name string) *api.RuleEffectiveness {
+		for _, e := range effs {
+			if e.Kind == kind && e.Name == name {
+				return e
+			}
+		}
+		return nil
+	}
+
+	report := api.NewUsageReport()
+	report.Hit("SomeRule", "blacklist")
+	report.Hit("SomeRule", "blacklist")
+	report.Hit("OtherRule")
+	tt.CheckErr(mc.PostUsageReport(report))
+
+	// reports are accumulated
+	report = api.NewUsageReport()
+	report.Hit("SomeRule")
+	tt.CheckErr(mc.PostUsageReport(report))
+
+	effs := make([]*api.RuleEffectiveness, 0)
+	r := get(api.AdmAPIRulesEffectivenessPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&effs))
+
+	e := findEff(effs, api.UsageKindRule, "SomeRule")
+	tt.Assert(e != nil)
+	tt.Assert(e.Hits == 3)
+	tt.Assert(e.Endpoints == 1)
+	tt.Assert(!e.Dead)
+
+	e = findEff(effs, api.UsageKindContainer, "blacklist")
+	tt.Assert(e != nil)
+	tt.Assert(e.Hits == 2)
+
+	// IOC container never hit
+	e = findEff(effs, api.UsageKindContainer, IoCContainerName)
+	tt.Assert(e != nil)
+	tt.Assert(e.Dead)
+
+	// filtering on dead containers
+	r = get(format("%s?%s=%s&%s=true", api.AdmAPIRulesEffectivenessPath, api.QpType, api.UsageKindContainer, api.QpDead))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&effs))
+	tt.Assert(len(effs) > 0)
+	for _, e := range effs {
+		tt.Assert(e.Kind == api.UsageKindContainer)
+		tt.Assert(e.Dead)
+	}
+	tt.Assert(findEff(effs, api.UsageKindContainer, "blacklist") == nil)
+
+	// every rule is dead if window is null
+	r = get(format("%s?%s=0s&%s=true", api.AdmAPIRulesEffectivenessPath, api.QpLast, api.QpDead))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&effs))
+	tt.Assert(findEff(effs, api.UsageKindRule, "SomeRule") != nil)
+
+	// bad window
+	r = get(format("%s?%s=foo", api.AdmAPIRulesEffectivenessPath, api.QpLast))
+	tt.Assert(r.Err() != nil)
+}
+
+func TestAdminAPIRulesDiff(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	diff := func(from, to string) (d *api.RulesDiff) {
+		d = &api.RulesDiff{}
+		r := get(format("%s?%s=%s&%s=%s", api.AdmAPIRulesDiffPath, api.QpFrom, from, api.QpTo, to))
+		tt.CheckErr(r.Err())
+		tt.CheckErr(r.UnmarshalData(d))
+		return
+	}
+
+	candidate := []engine.Rule{
+		{
+			Name: "CandidateRule",
+			Meta: engine.MetaSection{
+				Events:      map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+				Criticality: 5,
+				Schema:      engine.ParseVersion("2.0.0"),
+			},
+			Matches:   []string{`$foo: Image ~= 'C:\\Malware.exe'`},
+			Condition: "$foo",
+		},
+	}
+
+	r := post(api.AdmAPICandidateRulesPath, JSON(candidate))
+	tt.CheckErr(r.Err())
+
+	// candidate rule set
+	d := diff(api.CohortStable, api.CohortCandidate)
+	tt.Assert(d.FromRulesSha256 != d.ToRulesSha256)
+	tt.Assert(len(d.Rules) == 1)
+	tt.Assert(d.Rules[0].Name == "CandidateRule" && d.Rules[0].Change == api.DiffAdded)
+	tt.Assert(d.Rules[0].From == nil && d.Rules[0].To != nil)
+	tt.Assert(d.FromContainersSha256 == d.ToContainersSha256)
+	tt.Assert(len(d.Containers) == 0)
+
+	d = diff(api.CohortCandidate, api.CohortStable)
+	tt.Assert(len(d.Rules) == 1 && d.Rules[0].Change == api.DiffRemoved)
+
+	// candidate rule set follows stable rules updates
+	stable := candidate[0]
+	stable.Name = "StableRule"
+	r = post(api.AdmAPIRulesPath, JSON([]engine.Rule{stable}))
+	tt.CheckErr(r.Err())
+	d = diff(api.CohortStable, api.CohortCandidate)
+	tt.Assert(len(d.Rules) == 1 && d.Rules[0].Name == "CandidateRule")
+
+	// endpoint is in stable cohort
+	d = diff(api.CohortStable, mc.Config.UUID)
+	tt.Assert(len(d.Rules) == 0)
+	tt.Assert(d.FromRulesSha256 == d.ToRulesSha256)
+
+	// endpoint moved to candidate cohort
+	r = post(api.AdmAPIRulesRolloutPath, JSON(api.RulesRollout{Percentage: 100}))
+	tt.CheckErr(r.Err())
+	d = diff(api.CohortStable, mc.Config.UUID)
+	tt.Assert(len(d.Rules) == 1 && d.Rules[0].Change == api.DiffAdded)
+	tt.Assert(len(diff(api.CohortCandidate, mc.Config.UUID).Rules) == 0)
+
+	// unknown endpoint
+	r = get(format("%s?%s=%s&%s=%s", api.AdmAPIRulesDiffPath, api.QpFrom, api.CohortStable, api.QpTo, utils.UnsafeUUID()))
+	tt.Assert(r.Err() != nil)
+
+	// missing target
+	r = get(format("%s?%s=%s", api.AdmAPIRulesDiffPath, api.QpFrom, api.CohortStable))
+	tt.Assert(r.Err() != nil)
+}
+
+func TestDiffRawRules(t *testing.T) {
+	tt := toast.FromT(t)
+
+	rule := func(name string, crit int) string {
+		r := engine.Rule{
+			Name: name,
+			Meta: engine.MetaSection{
+				Events:      map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+				Criticality: crit,
+			},
+			Matches:   []string{`$foo: Image ~= 'C:\\Malware.exe'`},
+			Condition: "$foo",
+		}
+		return string(JSON(r)) + "\n"
+	}
+
+	from := rule("Modified", 5) + rule("Removed", 5) + rule("Same", 5)
+	to := rule("Added", 5) + rule("Modified", 8) + rule("Same", 5)
+
+	diffs, err := api.DiffRawRules(from, to)
+	tt.CheckErr(err)
+	tt.Assert(len(diffs) == 3)
+	tt.Assert(diffs[0].Name == "Added" && diffs[0].Change == api.DiffAdded)
+	tt.Assert(diffs[1].Name == "Modified" && diffs[1].Change == api.DiffModified)
+	tt.Assert(diffs[1].From.Meta.Criticality == 5 && diffs[1].To.Meta.Criticality == 8)
+	tt.Assert(diffs[2].Name == "Removed" && diffs[2].Change == api.DiffRemoved)
+
+	diffs, err = api.DiffRawRules(from, from)
+	tt.CheckErr(err)
+	tt.Assert(len(diffs) == 0)
+
+	_, err = api.DiffRawRules("{not json", to)
+	tt.Assert(err != nil)
+}
+
+func TestDiffContainers(t *testing.T) {
+	tt := toast.FromT(t)
+
+	from := []*api.EdrContainer{
+		{Name: "blacklist", Entries: []string{"a", "b"}},
+		{Name: "removed", Entries: []string{"x"}},
+	}
+	to := []*api.EdrContainer{
+		{Name: "added", Entries: []string{"y"}},
+		{Name: "blacklist", Entries: []string{"b", "c"}},
+	}
+
+	for _, c := range append(from, to...) {
+		c.Update()
+	}
+
+	diffs := api.DiffContainers(from, to)
+	tt.Assert(len(diffs) == 3)
+	tt.Assert(diffs[0].Name == "added" && diffs[0].Change == api.DiffAdded)
+	tt.Assert(diffs[1].Name == "blacklist" && diffs[1].Change == api.DiffModified)
+	tt.Assert(len(diffs[1].Added) == 1 && diffs[1].Added[0] == "c")
+	tt.Assert(len(diffs[1].Removed) == 1 && diffs[1].Removed[0] == "a")
+	tt.Assert(diffs[2].Name == "removed" && diffs[2].Change == api.DiffRemoved)
+
+	tt.Assert(len(api.DiffContainers(from, from)) == 0)
+}
+
+func TestAdminAPIEndpointStats(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	stats := &api.AgentStats{
+		Since:      time.Now().Add(-48 * time.Hour).UTC(),
+		Boot:       time.Now().UTC(),
+		Boots:      3,
+		SinceBoot:  api.NewStatsCounters(),
+		Cumulative: api.NewStatsCounters(),
+	}
+	stats.SinceBoot.Events = 10
+	stats.SinceBoot.Rules["SomeRule"] = 1
+	stats.Cumulative.Merge(&stats.SinceBoot)
+	stats.Cumulative.Events += 1000
+
+	// statistics are sent along with usage, even if nothing hit
+	report := api.NewUsageReport()
+	report.Stats = stats
+	tt.CheckErr(mc.PostUsageReport(report))
+
+	r := get(format("%s/%s", api.AdmAPIEndpointsPath, mc.Config.UUID))
+	tt.CheckErr(r.Err())
+
+	endpt := api.Endpoint{}
+	tt.CheckErr(r.UnmarshalData(&endpt))
+	tt.Assert(endpt.Stats != nil)
+	tt.Assert(endpt.Stats.Boots == 3)
+	tt.Assert(endpt.Stats.SinceBoot.Events == 10)
+	tt.Assert(endpt.Stats.Cumulative.Events == 1010)
+	tt.Assert(endpt.Stats.Cumulative.Rules["SomeRule"] == 1)
+}
+
+func TestAdminAPIEndpointTap(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+	tapPath := format("%s/%s%s", api.AdmAPIEndpointsPath, euuid, api.AdmAPITapSuffix)
+
+	tt.CheckErr(mc.PostTap(readerFromEvents(10)))
+
+	events := make([]*event.EdrEvent, 0)
+	r := get(tapPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&events))
+	tt.Assert(len(events) == 10)
+	tt.Assert(events[0].Event.EdrData.Endpoint.UUID == euuid)
+
+	// tapped events must not end up in event logs
+	tt.Assert(m.eventLogger.CountFiles() == 0)
+
+	// only getting events received since last one
+	last := events[len(events)-1].Event.EdrData.Event.ReceiptTime
+	tt.CheckErr(mc.PostTap(readerFromEvents(5)))
+	r = get(format("%s?%s=%s", tapPath, api.QpSince, last.Format(time.RFC3339Nano)))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&events))
+	tt.Assert(len(events) == 5)
+
+	// clearing tapped events
+	r = do(prepare("DELETE", tapPath, nil, nil))
+	tt.CheckErr(r.Err())
+	r = get(tapPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&events))
+	tt.Assert(len(events) == 0)
+
+	// buffer size is bounded
+	tap := NewEventTap(10)
+	for e := range emitEvents(25, false) {
+		e.InitEdrData()
+		e.Event.EdrData.Event.ReceiptTime = time.Now()
+		tap.Push(euuid, e)
+	}
+	tt.Assert(len(tap.Get(euuid, time.Time{})) == 10)
+}
+
+func TestAdminAPIContainment(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+
+	// containment state is reported with every request
+	mc.SetContainment(api.NewContainmentState("soft", time.Hour))
+	tt.Assert(mc.IsServerUp())
+
+	endpt, ok := m.Endpoint(euuid)
+	tt.Assert(ok)
+	tt.Assert(endpt.Containment != nil)
+	tt.Assert(endpt.Containment.Profile == "soft")
+	tt.Assert(!endpt.Containment.ReleaseAt.IsZero())
+
+	mc.SetContainment(&api.ContainmentState{})
+	tt.Assert(mc.IsServerUp())
+	endpt, _ = m.Endpoint(euuid)
+	tt.Assert(endpt.Containment == nil)
+
+	// emergency release token
+	var key, token string
+
+	r := get(api.AdmAPIContainmentKeyPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&key))
+
+	r = get(format("%s/%s%s?%s=1h", api.AdmAPIEndpointsPath, euuid, api.AdmAPIReleaseTokenSuffix, api.QpValidity))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&token))
+
+	now := time.Now()
+	_, err := api.VerifyReleaseToken(token, key, euuid, now)
+	tt.CheckErr(err)
+	_, err = api.VerifyReleaseToken(token, key, "other-endpoint", now)
+	tt.ExpectErr(err, api.ErrReleaseTokenEndpoint)
+	_, err = api.VerifyReleaseToken(token, key, euuid, now.Add(2*time.Hour))
+	tt.ExpectErr(err, api.ErrReleaseTokenExpired)
+	_, err = api.VerifyReleaseToken("A"+token, key, euuid, now)
+	tt.Assert(err != nil)
+
+	// token must not verify with another key
+	other, err := api.NewContainmentKey()
+	tt.CheckErr(err)
+	_, err = api.VerifyReleaseToken(token, other.PublicKey(), euuid, now)
+	tt.ExpectErr(err, api.ErrReleaseTokenSig)
+
+	// bad validity
+	r = get(format("%s/%s%s?%s=-1h", api.AdmAPIEndpointsPath, euuid, api.AdmAPIReleaseTokenSuffix, api.QpValidity))
+	tt.Assert(r.Err() != nil)
+}
+
+func TestAdminAPISignedCommand(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+
+	var key string
+	r := get(api.AdmAPIContainmentKeyPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&key))
+
+	r = post(format("%s/%s/command", api.AdmAPIEndpointsPath, euuid), JSON(CommandAPI{CommandLine: "acquire-memory"}))
+	tt.CheckErr(r.Err())
+
+	cmd, err := mc.FetchCommand()
+	tt.CheckErr(err)
+	tt.Assert(cmd.Signature != "")
+
+	now := time.Now()
+	tt.CheckErr(cmd.VerifySignature(key, euuid, now))
+	tt.ExpectErr(cmd.VerifySignature(key, "other-endpoint", now), api.ErrCommandSigMismatch)
+	tt.ExpectErr(cmd.VerifySignature(key, euuid, now.Add(2*DefaultCommandSignatureValidity)), api.ErrCommandSigExpired)
+
+	// signature must not verify with another key
+	other, err := api.NewContainmentKey()
+	tt.CheckErr(err)
+	tt.ExpectErr(cmd.VerifySignature(other.PublicKey(), euuid, now), api.ErrCommandSignature)
+
+	// command arguments tampered with
+	cmd.Args = append(cmd.Args, "status")
+	tt.ExpectErr(cmd.VerifySignature(key, euuid, now), api.ErrCommandSigMismatch)
+
+	cmd.Signature = "A" + cmd.Signature
+	tt.Assert(cmd.VerifySignature(key, euuid, now) != nil)
+
+	cmd.Signature = ""
+	tt.ExpectErr(cmd.VerifySignature(key, euuid, now), api.ErrBadCommandSignature)
+}
+
+func TestAdminAPIContainers(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	sha256 := func(s string) string {
+		return data.Sha256([]byte(s))
+	}
+
+	containers := []*api.EdrContainer{
+		{Name: "blacklist", Type: api.ContainerTypeSha256, Entries: []string{strings.ToUpper(sha256("a")), sha256("b")}},
+		{Name: "fp_domains", Type: api.ContainerTypeDomain, Entries: []string{"example.com"}},
+	}
+
+	r := post(api.AdmAPIContainersPath, JSON(containers))
+	tt.CheckErr(r.Err())
+
+	remote, err := mc.GetContainers()
+	tt.CheckErr(err)
+	tt.Assert(len(remote) == 2)
+	remoteSha256, err := mc.GetContainersSha256()
+	tt.CheckErr(err)
+	tt.Assert(api.ContainersSha256(remote) == remoteSha256)
+	// entries are normalized and sorted
+	tt.Assert(remote[0].Entries[1] == sha256("a"))
+
+	// invalid entries
+	r = post(api.AdmAPIContainersPath, JSON([]*api.EdrContainer{{Name: "bad", Type: api.ContainerTypeMd5, Entries: []string{"not a hash"}}}))
+	tt.Assert(r.Err() != nil)
+	r = post(api.AdmAPIContainersPath, JSON([]*api.EdrContainer{{Name: "bad.name"}}))
+	tt.Assert(r.Err() != nil)
+	// reserved container
+	r = post(api.AdmAPIContainersPath, JSON([]*api.EdrContainer{{Name: IoCContainerName}}))
+	tt.Assert(r.Err() != nil)
+
+	// editing container
+	change := api.ContainerChange{}
+	edit := api.ContainerEdit{Name: "blacklist", Add: []string{sha256("c")}, Remove: []string{sha256("b")}, Comment: "FP"}
+	r = post(api.AdmAPIContainersEditPath, JSON(edit))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&change))
+	tt.Assert(len(change.Added) == 1 && change.Added[0] == sha256("c"))
+	tt.Assert(len(change.Removed) == 1 && change.Removed[0] == sha256("b"))
+
+	// sha256 must have been recomputed
+	newSha256, err := mc.GetContainersSha256()
+	tt.CheckErr(err)
+	tt.Assert(newSha256 != remoteSha256)
+	remote, err = mc.GetContainers()
+	tt.CheckErr(err)
+	tt.Assert(remote[0].Sha256 == change.Sha256)
+	tt.Assert(remote[0].Sha256 == utils.Sha256StringSlice([]string{sha256("c"), sha256("a")}))
+
+	// invalid edit
+	r = post(api.AdmAPIContainersEditPath, JSON(api.ContainerEdit{Name: "blacklist", Add: []string{"not a hash"}}))
+	tt.Assert(r.Err() != nil)
+	r = post(api.AdmAPIContainersEditPath, JSON(api.ContainerEdit{Name: "unknown"}))
+	tt.Assert(r.Err() != nil)
+
+	// history
+	history := make([]*api.ContainerChange, 0)
+	r = get(api.AdmAPIContainersHistoryPath + "?" + url.Values{api.QpName: {"blacklist"}}.Encode())
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&history))
+	tt.Assert(len(history) == 2)
+	tt.Assert(history[0].Action == "edit")
+	tt.Assert(history[0].User == testAdminUser.Identifier)
+	tt.Assert(history[1].Action == "create")
+
+	// deleting container
+	r = do(prepare("DELETE", api.AdmAPIContainersPath, nil, map[string]string{api.QpName: "fp_domains"}))
+	tt.CheckErr(r.Err())
+	remote, err = mc.GetContainers()
+	tt.CheckErr(err)
+	tt.Assert(len(remote) == 1)
+}
+
+func TestAdminAPIAgentErrors(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+	errorsPath := format("%s/%s%s", api.AdmAPIEndpointsPath, euuid, api.AdmAPIErrorsSuffix)
+
+	report := api.NewAgentErrorReport()
+	for i := 0; i < 2*api.MaxAgentErrorSamples; i++ {
+		report.Add(api.AgentErrorRule, "BadRule", fmt.Errorf("syntax error %d", i))
+	}
+	report.Add(api.AgentErrorUpload, "memdump.dmp", fmt.Errorf("upload failed"))
+	tt.CheckErr(mc.PostAgentErrorReport(report))
+
+	// reports are accumulated
+	report = api.NewAgentErrorReport()
+	report.Add(api.AgentErrorRule, "BadRule", fmt.Errorf("syntax error"))
+	tt.CheckErr(mc.PostAgentErrorReport(report))
+
+	errs := make([]*api.AgentError, 0)
+	r := get(errorsPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&errs))
+	tt.Assert(len(errs) == 2)
+	// most recent first
+	tt.Assert(errs[0].Source == "BadRule")
+	tt.Assert(errs[0].Count == 2*api.MaxAgentErrorSamples+1)
+	tt.Assert(len(errs[0].Samples) == api.MaxAgentErrorSamples)
+	tt.Assert(errs[0].Samples[len(errs[0].Samples)-1] == "syntax error")
+
+	// filtering on kind
+	r = get(format("%s?%s=%s", errorsPath, api.QpKind, api.AgentErrorUpload))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&errs))
+	tt.Assert(len(errs) == 1)
+	tt.Assert(errs[0].Source == "memdump.dmp")
+
+	// filtering on time
+	r = get(format("%s?%s=%s", errorsPath, api.QpSince, url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&errs))
+	tt.Assert(len(errs) == 0)
+
+	// bad since parameter
+	r = get(format("%s?%s=foo", errorsPath, api.QpSince))
+	tt.Assert(r.Err() != nil)
+
+	// unknown endpoint
+	r = get(format("%s/%s%s", api.AdmAPIEndpointsPath, utils.UnsafeUUID().String(), api.AdmAPIErrorsSuffix))
+	tt.Assert(r.Err() != nil)
+}
+
+func TestAdminAPINoise(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	var detection *event.EdrEvent
+	for _, e := range events {
+		if e.IsDetection() {
+			detection = &e
+			break
+		}
+	}
+	tt.Assert(detection != nil)
+
+	noisy := `C:\Program Files\Backup\agent.exe`
+	pImage := engine.Path("/Event/EventData/Image")
+	pCommandLine := engine.Path("/Event/EventData/CommandLine")
+
+	buf := new(bytes.Buffer)
+	for i := 0; i < DefaultNoiseMinHits+10; i++ {
+		// deep copy of the detection
+		e := event.EdrEvent{}
+		tt.CheckErr(json.Unmarshal(utils.JsonOrPanic(detection), &e))
+
+		image := noisy
+		if i%6 == 0 {
+			image = format(`C:\Tools\tool%d.exe`, i)
+		}
+		tt.CheckErr(e.Set(pImage, image))
+		// making every alert unique
+		tt.CheckErr(e.Set(pCommandLine, format("%s --run %d", image, i)))
+		e.Event.System.TimeCreated.SystemTime = time.Now()
+
+		buf.Write(utils.JsonOrPanic(e))
+		buf.WriteByte('\n')
+	}
+	tt.CheckErr(mc.PostLogs(buf))
+
+	time.Sleep(1 * time.Second)
+
+	// no report generated yet
+	r := get(api.AdmAPINoisePath)
+	tt.Assert(r.Err() != nil)
+
+	r = post(format("%s?%s=1d", api.AdmAPINoisePath, api.QpLast), nil)
+	tt.CheckErr(r.Err())
+
+	report := api.NoiseReport{}
+	tt.CheckErr(r.UnmarshalData(&report))
+	tt.Assert(report.Alerts == DefaultNoiseMinHits+10, format("Wrong number of alerts %d", report.Alerts))
+	tt.Assert(len(report.Rules) > 0)
+	tt.Assert(report.Rules[0].Hits == report.Alerts)
+	tt.Assert(report.Rules[0].Endpoints[mc.Config.UUID] == report.Alerts)
+
+	var suppression, refinement *api.NoiseSuggestion
+	for _, s := range report.Suggestions {
+		switch {
+		case s.Kind == api.NoiseSuggestionSuppression && s.Field == "Image":
+			suppression = s
+		case s.Kind == api.NoiseSuggestionRefinement && s.Field == "CommandLine":
+			refinement = s
+		}
+	}
+	tt.Assert(suppression != nil)
+	tt.Assert(suppression.Value == noisy)
+	tt.Assert(suppression.Endpoints == 1)
+	// every command line is different
+	tt.Assert(refinement != nil)
+
+	// refinements cannot be accepted
+	r = post(api.AdmAPINoiseAcceptPath, utils.JsonOrPanic([]string{refinement.Uuid}))
+	tt.Assert(r.Err() != nil)
+
+	// unknown suggestion
+	r = post(api.AdmAPINoiseAcceptPath, utils.JsonOrPanic([]string{"unknown"}))
+	tt.Assert(r.Err() != nil)
+
+	r = post(api.AdmAPINoiseAcceptPath, utils.JsonOrPanic([]string{suppression.Uuid}))
+	tt.CheckErr(r.Err())
+
+	suppressions := make([]*api.Suppression, 0)
+	r = get(api.AdmAPISuppressionsPath)
+	tt.CheckErr(r.UnmarshalData(&suppressions))
+	tt.Assert(len(suppressions) == 1)
+	tt.Assert(suppressions[0].Enabled)
+	tt.Assert(suppressions[0].Field == "Image")
+	tt.Assert(suppressions[0].Value == noisy)
+
+	// accepted suggestion is removed from report
+	r = get(api.AdmAPINoisePath)
+	tt.CheckErr(r.UnmarshalData(&report))
+	_, ok := report.Suggestion(suppression.Uuid)
+	tt.Assert(!ok)
+
+	// suggestion is not made again once accepted
+	r = post(format("%s?%s=1d", api.AdmAPINoisePath, api.QpLast), nil)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&report))
+	_, ok = report.Suggestion(suppression.Uuid)
+	tt.Assert(!ok)
+}
+
+func TestAdminAPICanaries(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	var detection *event.EdrEvent
+	for _, e := range events {
+		if e.IsDetection() {
+			detection = &e
+			break
+		}
+	}
+	tt.Assert(detection != nil)
+
+	canary := `C:\Users\Public\Documents\passwords.xlsx`
+	pImage := engine.Path("/Event/EventData/Image")
+	pTargetFilename := engine.Path("/Event/EventData/TargetFilename")
+
+	buf := new(bytes.Buffer)
+	for i := 0; i < 10; i++ {
+		// deep copy of the detection
+		e := event.EdrEvent{}
+		tt.CheckErr(json.Unmarshal(utils.JsonOrPanic(detection), &e))
+
+		image := `C:\Windows\explorer.exe`
+		if i%2 == 0 {
+			image = `C:\Tools\ransom.exe`
+		}
+
+		d := engine.NewDetection(false, false)
+		d.Criticality = 10
+		d.Signature.Add("Builtin:CanaryModified")
+		// odd alerts are not canary related
+		if i%2 == 1 {
+			d = engine.NewDetection(false, false)
+			d.Criticality = 5
+			d.Signature.Add("NotACanary")
+		}
+		e.SetDetection(d)
+
+		tt.CheckErr(e.Set(pImage, image))
+		tt.CheckErr(e.Set(pTargetFilename, canary))
+		e.Event.System.TimeCreated.SystemTime = time.Now()
+
+		buf.Write(utils.JsonOrPanic(e))
+		buf.WriteByte('\n')
+	}
+	tt.CheckErr(mc.PostLogs(buf))
+
+	time.Sleep(1 * time.Second)
+
+	report := api.CanaryReport{}
+	r := get(api.AdmAPICanariesPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&report))
+
+	tt.Assert(report.Alerts == 5, format("Wrong number of alerts %d", report.Alerts))
+	tt.Assert(len(report.Triggers) == 1)
+	tt.Assert(report.Triggers[0].Endpoint == mc.Config.UUID)
+	tt.Assert(report.Triggers[0].Canary == canary)
+	tt.Assert(report.Triggers[0].Image == `C:\Tools\ransom.exe`)
+	tt.Assert(report.Triggers[0].HasRule("Builtin:CanaryModified"))
+	tt.Assert(len(report.Canaries) == 1)
+	tt.Assert(report.Canaries[0].Hits == 5)
+	tt.Assert(report.Canaries[0].Endpoints == 1)
+	tt.Assert(len(report.Endpoints) == 1)
+	tt.Assert(len(report.Processes) == 1)
+
+	// alerts out of time range
+	r = get(format("%s?%s=%s", api.AdmAPICanariesPath, api.QpSince, time.Now().Add(time.Hour).Format(time.RFC3339)))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&report))
+	tt.Assert(report.Alerts == 0)
+	tt.Assert(len(report.Triggers) == 0)
+}
+
+func TestAdminAPIRuleHits(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	var detection *event.EdrEvent
+	for _, e := range events {
+		if e.IsDetection() {
+			detection = &e
+			break
+		}
+	}
+	tt.Assert(detection != nil)
+
+	rule := "RuleUnderReview"
+	now := time.Now().UTC()
+
+	buf := new(bytes.Buffer)
+	for i := 0; i < 10; i++ {
+		// deep copy of the detection
+		e := event.EdrEvent{}
+		tt.CheckErr(json.Unmarshal(utils.JsonOrPanic(detection), &e))
+
+		d := engine.NewDetection(false, false)
+		d.Criticality = 5
+		d.Signature.Add(rule)
+		// odd alerts are raised by another rule
+		if i%2 == 1 {
+			d = engine.NewDetection(false, false)
+			d.Signature.Add("AnotherRule")
+		}
+		e.SetDetection(d)
+
+		// two hits three hours ago, the others now
+		ts := now
+		if i < 4 {
+			ts = now.Add(-3 * time.Hour)
+		}
+		e.Event.System.TimeCreated.SystemTime = ts
+
+		buf.Write(utils.JsonOrPanic(e))
+		buf.WriteByte('\n')
+	}
+	tt.CheckErr(mc.PostLogs(buf))
+
+	time.Sleep(1 * time.Second)
+
+	path := format("%s/%s/hits", api.AdmAPIRulesPath, rule)
+
+	hits := api.RuleHits{}
+	r := get(format("%s?%s=1d", path, api.QpLast))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&hits))
+
+	tt.Assert(hits.Rule == rule)
+	tt.Assert(hits.Hits == 5, format("Wrong number of hits %d", hits.Hits))
+	tt.Assert(hits.Bucket == DefaultRuleHitsBucket.String())
+	tt.Assert(len(hits.Buckets) == 25, format("Wrong number of buckets %d", len(hits.Buckets)))
+	tt.Assert(len(hits.Endpoints) == 1)
+	tt.Assert(hits.Endpoints[0].Endpoint == mc.Config.UUID)
+	tt.Assert(hits.Endpoints[0].Hits == 5)
+	tt.Assert(hits.Endpoints[0].LastHit.Sub(hits.Endpoints[0].FirstHit) >= 3*time.Hour-time.Second)
+
+	nonEmpty := 0
+	for _, b := range hits.Buckets {
+		if b.Hits > 0 {
+			nonEmpty++
+			tt.Assert(b.Endpoints[mc.Config.UUID] == b.Hits)
+		}
+	}
+	tt.Assert(nonEmpty == 2)
+
+	// single bucket
+	r = get(format("%s?%s=1d&%s=48h", path, api.QpLast, api.QpBucket))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&hits))
+	tt.Assert(hits.Hits == 5)
+	tt.Assert(len(hits.Buckets) <= 2)
+
+	// alerts out of time range
+	r = get(format("%s?%s=1h", path, api.QpLast))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&hits))
+	tt.Assert(hits.Hits == 3)
+
+	// rule never hit
+	r = get(format("%s/%s/hits", api.AdmAPIRulesPath, "NeverHit"))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&hits))
+	tt.Assert(hits.Hits == 0)
+	tt.Assert(len(hits.Endpoints) == 0)
+
+	// bad bucket parameters
+	for _, params := range []string{"bucket=1x", "bucket=-1h", "last=30d&bucket=1m"} {
+		r = get(format("%s?%s", path, params))
+		tt.Assert(r.Err() != nil, params)
+	}
+}
+
+func TestAdminAPIResponderSessions(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup previous data
+	clean(&mconf, &fconf)
+
+	m, mc := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	euuid := mc.Config.UUID
+	commandPath := format("%s/%s/command", api.AdmAPIEndpointsPath, euuid)
+
+	var key string
+	r := get(api.AdmAPIContainmentKeyPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&key))
+
+	// destructive commands are not approved without session
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "terminate 4242"}))
+	tt.CheckErr(r.Err())
+	cmd, err := mc.FetchCommand()
+	tt.CheckErr(err)
+	tt.Assert(cmd.Approval == "")
+	_, err = cmd.VerifyApproval(key, euuid, time.Now())
+	tt.ExpectErr(err, api.ErrApprovalRequired)
+
+	// suspension is as destructive as termination
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "suspend 4242"}))
+	tt.CheckErr(r.Err())
+	cmd, err = mc.FetchCommand()
+	tt.CheckErr(err)
+	_, err = cmd.VerifyApproval(key, euuid, time.Now())
+	tt.ExpectErr(err, api.ErrApprovalRequired)
+
+	// enforcing sessions, manager configuration is shared across tests
+	defer func(c ResponderConfig) { m.Config.Responder = c }(m.Config.Responder)
+	m.Config.Responder = ResponderConfig{
+		Enforce:      true,
+		Group:        "responders",
+		SecondFactor: true,
+		MaxValidity:  time.Hour,
+		MaxEndpoints: 1,
+	}
+
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "terminate 4242"}))
+	tt.Assert(r.Err() != nil)
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "suspend 4242"}))
+	tt.Assert(r.Err() != nil)
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "resume 4242"}))
+	tt.Assert(r.Err() != nil)
+
+	// commands undoing containment and remediation are destructive too
+	for _, cl := range []string{
+		"uncontain",
+		"release-process 4242",
+		"block 198.51.100.7",
+		"disable-user jdoe",
+		"revert quarantine:C:\\Users\\jdoe\\payload.exe",
+	} {
+		r = post(commandPath, JSON(CommandAPI{CommandLine: cl}))
+		tt.Assert(r.Err() != nil, cl)
+	}
+
+	// non destructive commands are not impacted
+	r = post(commandPath, JSON(CommandAPI{CommandLine: "processes"}))
+	tt.CheckErr(r.Err())
+
+	sr := ResponderSessionRequest{Endpoints: []string{euuid}, Reason: "ransomware on host"}
+
+	// user is not in responders group
+	r = post(api.AdmAPIResponderSessionsPath, JSON(sr))
+	tt.Assert(r.Err() != nil)
+
+	o, err := m.db.Search(&AdminAPIUser{}, "Key", "=", testAdminUser.Key).One()
+	tt.CheckErr(err)
+	user := o.(*AdminAPIUser)
+	user.Group = "responders"
+	tt.CheckErr(m.db.InsertOrUpdate(user))
+
+	// user has no TOTP secret
+	r = post(api.AdmAPIResponderSessionsPath, JSON(sr))
+	tt.Assert(r.Err() != nil)
+
+	user.TOTPSecret, err = newTOTPSecret()
+	tt.CheckErr(err)
+	tt.CheckErr(m.db.InsertOrUpdate(user))
+
+	// TOTP secret is not shown
+	r = get(api.AdmAPIUsers)
+	tt.CheckErr(r.Err())
+	users := make([]*AdminAPIUser, 0)
+	tt.CheckErr(r.UnmarshalData(&users))
+	for _, u := range users {
+		tt.Assert(u.TOTPSecret == "")
+	}
+
+	// bad TOTP code
+	sr.Code = "000000"
+	if verifyTOTP(user.TOTPSecret, sr.Code, time.Now()) {
+		sr.Code = "111111"
+	}
+	r = post(api.AdmAPIResponderSessionsPath, JSON(sr))
+	tt.Assert(r.Err() != nil)
+
+	secret, err := totpEncoding.DecodeString(user.TOTPSecret)
+	tt.CheckErr(err)
+	sr.Code = totpCode(secret, uint64(time.Now().Unix()/totpPeriod))
+
+	// validity above maximum
+	r = post(format("%s?%s=2h", api.AdmAPIResponderSessionsPath, api.QpValidity), JSON(sr))
+	tt.Assert(r.Err() != nil)
+
+	// too many endpoints
+	sr.Endpoints = append(sr.Endpoints, euuid)
+	r = post(api.AdmAPIResponderSessionsPath, JSON(sr))
+	tt.Assert(r.Err() != nil)
+	sr.Endpoints = sr.Endpoints[:1]
+
+	session := api.ResponderSession{}
+	r = post(format("%s?%s=10m", api.AdmAPIResponderSessionsPath, api.QpValidity), JSON(sr))
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&session))
+	tt.Assert(session.User == user.Identifier)
+	tt.Assert(session.Covers(euuid, time.Now()))
+	tt.Assert(!session.Covers(euuid, time.Now().Add(time.Hour)))
+
+	sessions := make([]*api.ResponderSession, 0)
+	r = get(api.AdmAPIResponderSessionsPath)
+	tt.CheckErr(r.Err())
+	tt.CheckErr(r.UnmarshalData(&sessions))
+	tt.Assert(len(sessions) == 1)
+
+	// unknown session
+	r = post(format("%s?%s=%s", commandPath, api.QpSession, utils.UnsafeUUID()), JSON(CommandAPI{CommandLine: "terminate 4242"}))
+	tt.Assert(r.Err() != nil)
+
+	r = post(format("%s?%s=%s", commandPath, api.QpSession, session.Uuid), JSON(CommandAPI{CommandLine: "terminate 4242"}))
+	tt.CheckErr(r.Err())
+
+	cmd, err = mc.FetchCommand()
+	tt.CheckErr(err)
+
+	now := time.Now()
+	approval, err := cmd.VerifyApproval(key, euuid, now)
+	tt.CheckErr(err)
+	tt.Assert(approval.Session == session.Uuid)
+	tt.Assert(approval.User == user.Identifier)
+
+	_, err = cmd.VerifyApproval(key, "other-endpoint", now)
+	tt.ExpectErr(err, api.ErrApprovalMismatch)
+	_, err = cmd.VerifyApproval(key, euuid, now.Add(time.Hour))
+	tt.ExpectErr(err, api.ErrApprovalExpired)
+
+	// approval must not verify with another key
+	other, err := api.NewContainmentKey()
+	tt.CheckErr(err)
+	_, err = cmd.VerifyApproval(other.PublicKey(), euuid, now)
+	tt.ExpectErr(err, api.ErrApprovalSig)
+
+	// approval cannot be used as a release token
+	_, err = api.VerifyReleaseToken(cmd.Approval, key, euuid, now)
+	tt.ExpectErr(err, api.ErrBadReleaseToken)
+
+	// approval cannot be used with other arguments
+	cmd.Args = []string{"1337"}
+	_, err = cmd.VerifyApproval(key, euuid, now)
+	tt.ExpectErr(err, api.ErrApprovalMismatch)
+
+	// approval cannot be used for another command
+	cmd.Args = []string{"4242"}
+	cmd.Name = "quarantine"
+	_, err = cmd.VerifyApproval(key, euuid, now)
+	tt.ExpectErr(err, api.ErrApprovalMismatch)
+}
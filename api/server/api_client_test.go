@@ -1,15 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"math/rand"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-utils/crypto/data"
 	"github.com/0xrawsec/golang-utils/crypto/file"
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
@@ -36,6 +40,96 @@ func TestClientGetRules(t *testing.T) {
 	tt.Assert(sha256 == data.Sha256([]byte(rules)))
 }
 
+func TestClientGetCandidateRules(t *testing.T) {
+
+	tt := toast.FromT(t)
+	m, c := prep()
+	defer cleanup(m)
+
+	stable, err := c.GetRulesSha256()
+	tt.CheckErr(err)
+
+	rule := &api.EdrCandidateRule{Rule: engine.Rule{
+		Name: "CandidateRule",
+		Meta: engine.MetaSection{
+			Events: map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+			Schema: engine.ParseVersion("2.0.0"),
+		},
+		Matches:   []string{`$foo: Image ~= 'C:\\Malware.exe'`},
+		Condition: "$foo",
+	}}
+
+	tt.CheckErr(m.db.InsertOrUpdate(rule))
+	tt.CheckErr(m.initializeCandidateFromDB())
+
+	// no endpoint is part of the candidate cohort
+	sha256, err := c.GetRulesSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 == stable)
+
+	tt.CheckErr(m.UpdateRulesRollout(&api.RulesRollout{Percentage: 100}))
+
+	rules, err := c.GetRules()
+	tt.CheckErr(err)
+	tt.Assert(strings.Contains(rules, rule.Name))
+
+	sha256, err = c.GetRulesSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 != stable)
+	tt.Assert(sha256 == data.Sha256([]byte(rules)))
+
+	stats, err := m.RolloutStats()
+	tt.CheckErr(err)
+	tt.Assert(stats.Cohorts[api.CohortCandidate].Endpoints == 1)
+}
+
+func TestClientGetSuppressions(t *testing.T) {
+
+	tt := toast.FromT(t)
+	m, c := prep()
+	defer cleanup(m)
+
+	empty, err := c.GetSuppressionsSha256()
+	tt.CheckErr(err)
+
+	verdict := &api.AlertVerdict{
+		EventHash: utils.UnsafeUUID().String(),
+		Endpoint:  c.Config.UUID,
+		Verdict:   api.VerdictFalsePositive,
+		Signature: []string{"FalsePositiveRule"},
+		Image:     `C:\Windows\System32\legit.exe`,
+	}
+
+	proposals, err := m.AddVerdict(verdict, true)
+	tt.CheckErr(err)
+	tt.Assert(len(proposals) == 1)
+	tt.Assert(!proposals[0].Enabled)
+
+	// a similar verdict must not generate duplicate proposals
+	verdict.EventHash = utils.UnsafeUUID().String()
+	dup, err := m.AddVerdict(verdict, true)
+	tt.CheckErr(err)
+	tt.Assert(len(dup) == 0)
+
+	// proposals are not pushed to endpoints until enabled
+	suppressions, err := c.GetSuppressions()
+	tt.CheckErr(err)
+	tt.Assert(len(suppressions) == 0)
+
+	proposals[0].Enabled = true
+	tt.CheckErr(m.db.InsertOrUpdate(proposals[0]))
+	tt.CheckErr(m.updateSuppressionsCache())
+
+	sha256, err := c.GetSuppressionsSha256()
+	tt.CheckErr(err)
+	tt.Assert(sha256 != empty)
+
+	suppressions, err = c.GetSuppressions()
+	tt.CheckErr(err)
+	tt.Assert(len(suppressions) == 1)
+	tt.Assert(suppressions[0].Key() == proposals[0].Key())
+}
+
 func TestClientPostDump(t *testing.T) {
 	var err error
 
@@ -62,6 +156,37 @@ func TestClientPostDump(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadShrinkerChunkSize(t *testing.T) {
+	tt := toast.FromT(t)
+
+	guid := "{00000000-0000-0000-0000-000000000000}"
+	ehash := strings.Repeat("a", 32)
+	root := t.TempDir()
+	content := []byte(strings.Repeat("whids", 1000))
+	path := filepath.Join(t.TempDir(), "file.bin")
+	tt.CheckErr(os.WriteFile(path, content, 0600))
+
+	_, err := client.NewUploadShrinkerWithChunkSize(path, guid, ehash, 0)
+	tt.Assert(err != nil)
+
+	shrink, err := client.NewUploadShrinkerWithChunkSize(path, guid, ehash, 1024)
+	tt.CheckErr(err)
+	defer shrink.Close()
+
+	chunks := 0
+	for fu := shrink.Next(); fu != nil; fu = shrink.Next() {
+		tt.Assert(len(fu.Content) <= 1024)
+		tt.CheckErr(fu.Dump(root))
+		chunks++
+	}
+	tt.CheckErr(shrink.Err())
+	tt.Assert(chunks == len(content)/1024+1)
+
+	dumped, err := os.ReadFile(filepath.Join(root, guid, ehash, "file.bin"))
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(dumped, content))
+}
 func TestClientContainer(t *testing.T) {
 
 	tt := toast.FromT(t)
@@ -118,6 +243,62 @@ func TestClientContainer(t *testing.T) {
 	tt.Assert(rsha256 == utils.Sha256StringSlice(strIocs))
 }
 
+func TestClientIoCEntries(t *testing.T) {
+
+	tt := toast.FromT(t)
+	m, c := prep()
+	defer cleanup(m)
+
+	feed := "TestFeed"
+	iocs := []ioc.IOC{
+		{
+			Uuid:       utils.UUIDOrPanic().String(),
+			GroupUuid:  utils.UUIDOrPanic().String(),
+			Source:     feed,
+			Value:      "expiring.random.com",
+			Type:       "domain",
+			Confidence: 80,
+			Expiry:     time.Now().Add(time.Second),
+		},
+		{
+			Uuid:       utils.UUIDOrPanic().String(),
+			GroupUuid:  utils.UUIDOrPanic().String(),
+			Source:     feed,
+			Value:      "forever.random.com",
+			Type:       "domain",
+			Confidence: 20,
+		},
+	}
+
+	r := post(api.AdmAPIIocsPath, JSON(iocs))
+	tt.CheckErr(r.Err())
+
+	entries, err := c.GetIoCEntries()
+	tt.CheckErr(err)
+	tt.Assert(len(entries) == len(iocs))
+
+	values := make([]string, 0, len(entries))
+	for _, e := range entries {
+		tt.Assert(e.Source == feed)
+		values = append(values, e.Value)
+	}
+
+	rsha256, err := c.GetIoCsSha256()
+	tt.CheckErr(err)
+	tt.Assert(rsha256 == utils.Sha256StringSlice(values))
+
+	time.Sleep(time.Second)
+	expired, err := m.iocs.Prune(m.db)
+	tt.CheckErr(err)
+	tt.Assert(len(expired) == 1)
+
+	entries, err = c.GetIoCEntries()
+	tt.CheckErr(err)
+	tt.Assert(len(entries) == 1)
+	tt.Assert(entries[0].Value == "forever.random.com")
+	tt.Assert(entries[0].Confidence == 20)
+}
+
 func TestClientExecuteCommand(t *testing.T) {
 	var cmdline string
 	var cmd *api.EndpointCommand
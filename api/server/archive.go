@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// archiveFilter selects the artifact bundles of the cold storage index
+type archiveFilter struct {
+	endpoints map[string]bool
+	hash      string
+	since     time.Time
+	until     time.Time
+}
+
+func (f *archiveFilter) match(a *api.ArchivedArtifact) bool {
+	if len(f.endpoints) > 0 && !f.endpoints[a.Endpoint] {
+		return false
+	}
+
+	if f.hash != "" && f.hash != a.EventHash {
+		return false
+	}
+
+	if !f.since.IsZero() && a.Modification.Before(f.since) {
+		return false
+	}
+
+	if !f.until.IsZero() && a.Modification.After(f.until) {
+		return false
+	}
+
+	return true
+}
+
+// bundleDir returns the directory of an artifact bundle under root
+func bundleDir(root, euuid, pguid, ehash string) string {
+	return filepath.Join(root, euuid, format("{%s}", strings.Trim(pguid, "{}")), ehash)
+}
+
+func copyFile(src, dst string) (err error) {
+	var in, out *os.File
+
+	if in, err = os.Open(src); err != nil {
+		return
+	}
+	defer in.Close()
+
+	if out, err = os.Create(dst); err != nil {
+		return
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return
+	}
+
+	return out.Close()
+}
+
+// moveDir moves a directory (not containing any sub-directory), the
+// content is copied when src and dst are not on the same file system
+func moveDir(src, dst string) (err error) {
+	var entries []os.DirEntry
+
+	if err = os.MkdirAll(filepath.Dir(dst), utils.DefaultFilePerm); err != nil {
+		return
+	}
+
+	if err = os.Rename(src, dst); err == nil {
+		return
+	}
+
+	if entries, err = os.ReadDir(src); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(dst, utils.DefaultFilePerm); err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if err = copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return
+		}
+	}
+
+	return os.RemoveAll(src)
+}
+
+// removeIfEmpty removes a directory if it is empty
+func removeIfEmpty(dir string) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+	}
+}
+
+// archivedArtifact returns the index entry of an artifact bundle
+// and whether it exists
+func (m *Manager) archivedArtifact(euuid, pguid, ehash string) (a *api.ArchivedArtifact, ok bool) {
+	pguid = strings.Trim(pguid, "{}")
+	o, err := m.db.GetByUUID(&api.ArchivedArtifact{}, api.ArchivedArtifactUUID(euuid, pguid, ehash))
+	if err != nil {
+		return
+	}
+	return o.(*api.ArchivedArtifact), true
+}
+
+// ArchiveArtifacts moves to cold storage the artifact bundles which have
+// not been modified, nor restored, for more than the configured maximum age.
+// It returns the number of bundles archived.
+func (m *Manager) ArchiveArtifacts() (n int, err error) {
+	var endpoints []os.DirEntry
+	var dumps []EndpointDumps
+
+	if !m.Config.Archive.Enabled() {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(m.Config.Archive.MaxAge) * 24 * time.Hour)
+
+	if endpoints, err = os.ReadDir(m.Config.DumpDir); err != nil {
+		return
+	}
+
+	for _, endpt := range endpoints {
+		euuid := endpt.Name()
+		if !endpt.IsDir() {
+			continue
+		}
+
+		if dumps, err = listEndpointDumps(m.Config.DumpDir, euuid, time.Time{}); err != nil {
+			return
+		}
+
+		for _, d := range dumps {
+			if d.Modification.After(cutoff) {
+				continue
+			}
+
+			a, ok := m.archivedArtifact(euuid, d.ProcessGUID, d.EventHash)
+			if !ok {
+				a = api.NewArchivedArtifact(euuid, d.ProcessGUID, d.EventHash)
+			}
+
+			// bundle brought back recently
+			if a.Restored.After(cutoff) {
+				continue
+			}
+
+			src := bundleDir(m.Config.DumpDir, euuid, d.ProcessGUID, d.EventHash)
+			dst := bundleDir(m.Config.Archive.Dir, euuid, d.ProcessGUID, d.EventHash)
+			if err = moveDir(src, dst); err != nil {
+				return n, fmt.Errorf("failed to archive %s: %w", src, err)
+			}
+			removeIfEmpty(filepath.Dir(src))
+
+			a.Files = a.Files[:0]
+			a.Size = 0
+			for _, f := range d.Files {
+				a.Files = append(a.Files, f.Name)
+				a.Size += f.Size
+			}
+			a.Modification = d.Modification
+			a.Archived = time.Now().UTC()
+			a.Cold = true
+
+			if err = m.db.InsertOrUpdate(a); err != nil {
+				return
+			}
+			n++
+		}
+	}
+
+	return
+}
+
+// archivedArtifacts returns the index entries of the artifact bundles
+// matching filter, most recently modified first
+func (m *Manager) archivedArtifacts(f *archiveFilter, coldOnly bool) (archived []*api.ArchivedArtifact, err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.ArchivedArtifact{}); err != nil {
+		return
+	}
+
+	archived = make([]*api.ArchivedArtifact, 0, len(objs))
+	for _, o := range objs {
+		a := o.(*api.ArchivedArtifact)
+		if coldOnly && !a.Cold {
+			continue
+		}
+
+		if f.match(a) {
+			archived = append(archived, a)
+		}
+	}
+
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].Modification.After(archived[j].Modification)
+	})
+
+	return
+}
+
+// restoreArtifacts brings back from cold storage the artifact bundles
+// matching filter, restored bundles are archived again only after
+// the configured maximum age
+func (m *Manager) restoreArtifacts(f *archiveFilter) (restored []*api.ArchivedArtifact, err error) {
+	var archived []*api.ArchivedArtifact
+
+	restored = make([]*api.ArchivedArtifact, 0)
+
+	if archived, err = m.archivedArtifacts(f, true); err != nil {
+		return
+	}
+
+	for _, a := range archived {
+		src := bundleDir(m.Config.Archive.Dir, a.Endpoint, a.ProcessGUID, a.EventHash)
+		dst := bundleDir(m.Config.DumpDir, a.Endpoint, a.ProcessGUID, a.EventHash)
+		if err = moveDir(src, dst); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", src, err)
+		}
+		removeIfEmpty(filepath.Dir(src))
+
+		a.Cold = false
+		a.Restored = time.Now().UTC()
+		if err = m.db.InsertOrUpdate(a); err != nil {
+			return
+		}
+		restored = append(restored, a)
+	}
+
+	return
+}
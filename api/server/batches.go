@@ -0,0 +1,71 @@
+package server
+
+import (
+	"sync"
+)
+
+const (
+	// DefaultBatchHistorySize number of batches of events remembered per
+	// endpoint to detect batches sent several times
+	DefaultBatchHistorySize = 256
+)
+
+type batchHistory struct {
+	ids   map[string]bool
+	order []string
+}
+
+// BatchHistory remembers the most recent batches of events ingested
+// for every endpoint so that batches sent again by agents, after a
+// timeout for instance, are not ingested twice
+type BatchHistory struct {
+	sync.Mutex
+	size      int
+	endpoints map[string]*batchHistory
+}
+
+// NewBatchHistory creates a new BatchHistory remembering at most
+// size batches per endpoint
+func NewBatchHistory(size int) *BatchHistory {
+	return &BatchHistory{
+		size:      size,
+		endpoints: make(map[string]*batchHistory),
+	}
+}
+
+// Seen returns true if batch id has already been ingested for endpoint
+func (h *BatchHistory) Seen(endpoint, id string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if bh, ok := h.endpoints[endpoint]; ok {
+		return bh.ids[id]
+	}
+
+	return false
+}
+
+// Add records batch id as ingested for endpoint, it must be called only
+// once the batch has been entirely ingested so that a batch partially
+// received can be sent again
+func (h *BatchHistory) Add(endpoint, id string) {
+	h.Lock()
+	defer h.Unlock()
+
+	bh, ok := h.endpoints[endpoint]
+	if !ok {
+		bh = &batchHistory{ids: make(map[string]bool)}
+		h.endpoints[endpoint] = bh
+	}
+
+	if bh.ids[id] {
+		return
+	}
+
+	bh.ids[id] = true
+	bh.order = append(bh.order, id)
+	if len(bh.order) > h.size {
+		delete(bh.ids, bh.order[0])
+		bh.order = bh.order[1:]
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultCanaryWindow number of days of alerts canary
+	// reports are built from when no time range is given
+	DefaultCanaryWindow = 7
+)
+
+var (
+	// fields holding the path of the canary file accessed, depending
+	// on the event (Security 4663, Sysmon file events, Kernel-File)
+	canaryPathFields = []string{"ObjectName", "TargetFilename", "FileName"}
+	// fields holding the image of the process accessing the canary file
+	canaryImageFields = []string{"Image", "ProcessName"}
+)
+
+func eventFirstString(e *event.EdrEvent, fields []string) string {
+	for _, f := range fields {
+		if v, ok := e.Event.Event.GetPropertyString(f); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// canaryHits accumulates the hits of a canary, an endpoint or a process
+type canaryHits struct {
+	api.CanaryHits
+	endpoints map[string]bool
+}
+
+func (h *canaryHits) add(euuid string, ts time.Time) {
+	h.Hits++
+	h.endpoints[euuid] = true
+	if h.FirstSeen.IsZero() || ts.Before(h.FirstSeen) {
+		h.FirstSeen = ts
+	}
+	if ts.After(h.LastSeen) {
+		h.LastSeen = ts
+	}
+}
+
+type canaryHitsMap map[string]*canaryHits
+
+// add accounts for a hit of value, values are case insensitive
+func (m canaryHitsMap) add(value, euuid string, ts time.Time) *canaryHits {
+	key := strings.ToLower(value)
+	h, ok := m[key]
+	if !ok {
+		h = &canaryHits{
+			CanaryHits: api.CanaryHits{Value: value},
+			endpoints:  make(map[string]bool),
+		}
+		m[key] = h
+	}
+	h.add(euuid, ts)
+	return h
+}
+
+func (m canaryHitsMap) slice() (s []*api.CanaryHits) {
+	s = make([]*api.CanaryHits, 0, len(m))
+	for _, h := range m {
+		h.Endpoints = len(h.endpoints)
+		s = append(s, &h.CanaryHits)
+	}
+
+	// most hit first
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Hits == s[j].Hits {
+			return s[i].Value < s[j].Value
+		}
+		return s[i].Hits > s[j].Hits
+	})
+
+	return
+}
+
+// canaryModel aggregates canary alerts across endpoints
+type canaryModel struct {
+	alerts    int
+	triggers  map[string]*api.CanaryTrigger
+	canaries  canaryHitsMap
+	endpoints canaryHitsMap
+	processes canaryHitsMap
+}
+
+func newCanaryModel() *canaryModel {
+	return &canaryModel{
+		triggers:  make(map[string]*api.CanaryTrigger),
+		canaries:  make(canaryHitsMap),
+		endpoints: make(canaryHitsMap),
+		processes: make(canaryHitsMap),
+	}
+}
+
+// canaryRules returns the canary rules an alert matched
+func canaryRules(e *event.EdrEvent) (rules []string) {
+	d := e.GetDetection()
+	if d == nil || d.Signature == nil {
+		return
+	}
+
+	for _, s := range d.Signature.Slice() {
+		if sig := s.(string); strings.HasPrefix(sig, api.CanaryRulePrefix) {
+			rules = append(rules, sig)
+		}
+	}
+
+	return
+}
+
+// learn accounts for an alert, alerts not raised by canary rules are ignored
+func (m *canaryModel) learn(e *event.EdrEvent) {
+	var euuid, hostname string
+
+	rules := canaryRules(e)
+	if len(rules) == 0 {
+		return
+	}
+
+	if e.Event.EdrData != nil {
+		euuid = e.Event.EdrData.Endpoint.UUID
+		hostname = e.Event.EdrData.Endpoint.Hostname
+	}
+
+	ts := e.Timestamp().UTC()
+	canary := eventFirstString(e, canaryPathFields)
+	image := eventFirstString(e, canaryImageFields)
+
+	m.alerts++
+	m.canaries.add(canary, euuid, ts)
+	m.endpoints.add(euuid, euuid, ts).Hostname = hostname
+	m.processes.add(image, euuid, ts)
+
+	key := strings.ToLower(strings.Join([]string{euuid, canary, image}, "|"))
+	t, ok := m.triggers[key]
+	if !ok {
+		t = &api.CanaryTrigger{
+			Endpoint:  euuid,
+			Hostname:  hostname,
+			Canary:    canary,
+			Image:     image,
+			Rules:     make([]string, 0),
+			FirstSeen: ts,
+		}
+		m.triggers[key] = t
+	}
+
+	t.Hits++
+	for _, r := range rules {
+		if !t.HasRule(r) {
+			t.Rules = append(t.Rules, r)
+		}
+	}
+	if ts.Before(t.FirstSeen) {
+		t.FirstSeen = ts
+	}
+	if ts.After(t.LastSeen) {
+		t.LastSeen = ts
+	}
+}
+
+// report builds a canary report out of the alerts learnt between start and end
+func (m *canaryModel) report(start, end time.Time) (r *api.CanaryReport) {
+	r = api.NewCanaryReport(start, end)
+	r.Alerts = m.alerts
+
+	for _, t := range m.triggers {
+		sort.Strings(t.Rules)
+		r.Triggers = append(r.Triggers, t)
+	}
+
+	// most recent first
+	sort.Slice(r.Triggers, func(i, j int) bool {
+		return r.Triggers[i].LastSeen.After(r.Triggers[j].LastSeen)
+	})
+
+	r.Canaries = m.canaries.slice()
+	r.Endpoints = m.endpoints.slice()
+	r.Processes = m.processes.slice()
+
+	return
+}
+
+// CanaryReport aggregates the canary alerts received between start and end,
+// by endpoint, canary file and process accessing it
+func (m *Manager) CanaryReport(start, end time.Time) (r *api.CanaryReport, err error) {
+	model := newCanaryModel()
+
+	for rawEvent := range m.detectionSearcher.Events(start, end, "", math.MaxInt, 0) {
+		e, err := rawEvent.Event()
+		if err != nil {
+			m.Logger.Errorf("failed to decode alert: %s", err)
+			continue
+		}
+		model.learn(e)
+	}
+
+	if err = m.detectionSearcher.Err(); err != nil {
+		return
+	}
+
+	return model.report(start, end), nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultTapBufferSize maximum number of tapped events kept per endpoint
+	DefaultTapBufferSize = 10000
+)
+
+// EventTap holds the raw events live streamed by endpoints for
+// interactive investigation. Tapped events are not stored in event
+// logs and only the most recent ones are kept in memory.
+type EventTap struct {
+	sync.RWMutex
+	size   int
+	events map[string][]*event.EdrEvent
+}
+
+// NewEventTap creates a new EventTap keeping at most size events per endpoint
+func NewEventTap(size int) *EventTap {
+	return &EventTap{
+		size:   size,
+		events: make(map[string][]*event.EdrEvent),
+	}
+}
+
+// Push adds events tapped on endpoint
+func (t *EventTap) Push(endpoint string, events ...*event.EdrEvent) {
+	t.Lock()
+	defer t.Unlock()
+
+	buf := append(t.events[endpoint], events...)
+	if len(buf) > t.size {
+		// we copy not to keep growing the underlying array
+		buf = append(make([]*event.EdrEvent, 0, t.size), buf[len(buf)-t.size:]...)
+	}
+	t.events[endpoint] = buf
+}
+
+// Get returns events tapped on endpoint and received after since
+func (t *EventTap) Get(endpoint string, since time.Time) (events []*event.EdrEvent) {
+	t.RLock()
+	defer t.RUnlock()
+
+	events = make([]*event.EdrEvent, 0)
+	for _, e := range t.events[endpoint] {
+		if e.Event.EdrData.Event.ReceiptTime.After(since) {
+			events = append(events, e)
+		}
+	}
+
+	return
+}
+
+// Clear deletes events tapped on endpoint
+func (t *EventTap) Clear(endpoint string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.events, endpoint)
+}
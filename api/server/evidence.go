@@ -0,0 +1,348 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// EvidenceFormat identifies the format of evidence bundles
+	EvidenceFormat = "whids-sigma-evidence/1"
+
+	// prefix of pseudonymized values
+	evidencePseudoPrefix = "redacted-"
+)
+
+var (
+	// DefaultEvidenceRedactedFields event fields pseudonymized in evidence
+	// bundles when no field is configured, they identify accounts, hosts
+	// or network addresses of the organization
+	DefaultEvidenceRedactedFields = []string{
+		"User",
+		"SourceUser",
+		"TargetUser",
+		"SubjectUserName",
+		"SubjectUserSid",
+		"SubjectDomainName",
+		"TargetUserName",
+		"TargetUserSid",
+		"TargetDomainName",
+		"AccountName",
+		"WorkstationName",
+		"IpAddress",
+		"SourceIp",
+		"SourceHostname",
+		"DestinationIp",
+		"DestinationHostname",
+	}
+
+	// user profile directories appearing in paths and command lines
+	evidenceUserPathRe = regexp.MustCompile(`(?i)(\\Users\\)([^\\"\s]+)`)
+
+	// Sigma log source services of the channels
+	evidenceServices = map[string]string{
+		"Microsoft-Windows-Sysmon/Operational": "sysmon",
+		"Security":                             "security",
+		"System":                               "system",
+		"Application":                          "application",
+		"Microsoft-Windows-PowerShell/Operational":                           "powershell",
+		"Microsoft-Windows-Windows Defender/Operational":                     "windefend",
+		"Microsoft-Windows-TaskScheduler/Operational":                        "taskscheduler",
+		"Microsoft-Windows-WMI-Activity/Operational":                         "wmi",
+		"Microsoft-Windows-DNS-Client/Operational":                           "dns-client",
+		"Microsoft-Windows-Windows Firewall With Advanced Security/Firewall": "firewall-as",
+	}
+
+	// Sigma log source categories of Sysmon events
+	evidenceSysmonCategories = map[int64]string{
+		1:  "process_creation",
+		2:  "file_change",
+		3:  "network_connection",
+		5:  "process_termination",
+		6:  "driver_load",
+		7:  "image_load",
+		8:  "create_remote_thread",
+		9:  "raw_access_thread",
+		10: "process_access",
+		11: "file_event",
+		12: "registry_event",
+		13: "registry_event",
+		14: "registry_event",
+		15: "create_stream_hash",
+		17: "pipe_created",
+		18: "pipe_created",
+		19: "wmi_event",
+		20: "wmi_event",
+		21: "wmi_event",
+		22: "dns_query",
+		23: "file_delete",
+		24: "clipboard_capture",
+		25: "process_tampering",
+		26: "file_delete",
+	}
+)
+
+// EvidenceLogSource is the Sigma log source an evidence event comes from
+type EvidenceLogSource struct {
+	Product  string `json:"product"`
+	Service  string `json:"service,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+// EvidenceDetection describes the detection an evidence is about
+type EvidenceDetection struct {
+	Signatures  []string        `json:"signatures"`
+	Criticality int             `json:"criticality"`
+	ATTACK      []engine.Attack `json:"attack,omitempty"`
+}
+
+// EvidenceEvent is the anonymized event which triggered the alert
+type EvidenceEvent struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Channel   string                 `json:"channel"`
+	EventID   int64                  `json:"event-id"`
+	Provider  string                 `json:"provider"`
+	Computer  string                 `json:"computer"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+// EvidenceBundle is a shareable and anonymized representation of an alert,
+// its metadata follows Sigma conventions (level, tags, logsource)
+type EvidenceBundle struct {
+	Format    string            `json:"format"`
+	ID        string            `json:"id"`
+	Created   time.Time         `json:"created"`
+	Title     string            `json:"title"`
+	Level     string            `json:"level"`
+	Tags      []string          `json:"tags"`
+	LogSource EvidenceLogSource `json:"logsource"`
+	Detection EvidenceDetection `json:"detection"`
+	Event     EvidenceEvent     `json:"event"`
+	// fields pseudonymized
+	Redacted []string `json:"redacted"`
+	// fields removed
+	Dropped []string `json:"dropped"`
+}
+
+// evidencePolicy defines how alerts are anonymized
+type evidencePolicy struct {
+	salt   []byte
+	redact map[string]bool
+	drop   map[string]bool
+}
+
+func newEvidencePolicy(c *EvidenceConfig, salt []byte) *evidencePolicy {
+	p := &evidencePolicy{
+		salt:   salt,
+		redact: make(map[string]bool),
+		drop:   make(map[string]bool),
+	}
+
+	redact := c.Redact
+	if len(redact) == 0 {
+		redact = DefaultEvidenceRedactedFields
+	}
+
+	for _, f := range redact {
+		p.redact[f] = true
+	}
+
+	for _, f := range c.Drop {
+		p.drop[f] = true
+	}
+
+	return p
+}
+
+// pseudonym returns a pseudonym of value, the same value always
+// gets the same pseudonym so that evidences can be correlated
+func (p *evidencePolicy) pseudonym(value string) string {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(strings.ToLower(value)))
+	return evidencePseudoPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// anonymize replaces user names found in paths with their pseudonyms
+func (p *evidencePolicy) anonymize(value string) string {
+	return evidenceUserPathRe.ReplaceAllStringFunc(value, func(s string) string {
+		m := evidenceUserPathRe.FindStringSubmatch(s)
+		switch strings.ToLower(m[2]) {
+		// well known directories do not identify anyone
+		case "public", "default", "all users":
+			return s
+		}
+		return m[1] + p.pseudonym(m[2])
+	})
+}
+
+// evidenceLevel converts a criticality into a Sigma level
+func evidenceLevel(criticality int) string {
+	switch {
+	case criticality <= 2:
+		return "informational"
+	case criticality <= 4:
+		return "low"
+	case criticality <= 6:
+		return "medium"
+	case criticality <= 8:
+		return "high"
+	default:
+		return "critical"
+	}
+}
+
+// evidenceTags converts ATT&CK information into Sigma tags
+func evidenceTags(attack []engine.Attack) (tags []string) {
+	tags = make([]string, 0)
+	for _, a := range attack {
+		if a.Tactic != "" {
+			tags = append(tags, "attack."+strings.ReplaceAll(strings.ToLower(a.Tactic), "-", "_"))
+		}
+		if a.ID != "" {
+			tags = append(tags, "attack."+strings.ToLower(a.ID))
+		}
+	}
+	tags = utils.DedupStringSlice(tags)
+	sort.Strings(tags)
+	return
+}
+
+// evidenceLogSource returns the Sigma log source of an event
+func evidenceLogSource(e *event.EdrEvent) (ls EvidenceLogSource) {
+	ls.Product = "windows"
+
+	channel := e.Channel()
+	if s, ok := evidenceServices[channel]; ok {
+		ls.Service = s
+	} else {
+		ls.Service = channel
+	}
+
+	if ls.Service == "sysmon" {
+		ls.Category = evidenceSysmonCategories[e.EventID()]
+	}
+
+	return
+}
+
+// bundle converts an alert into an evidence bundle
+// anonymized according to policy
+func (p *evidencePolicy) bundle(e *event.EdrEvent) (b *EvidenceBundle, err error) {
+	d := e.GetDetection()
+	if d == nil {
+		return nil, fmt.Errorf("event is not an alert")
+	}
+
+	b = &EvidenceBundle{
+		Format:    EvidenceFormat,
+		ID:        p.pseudonym(e.Hash()),
+		Created:   time.Now().UTC(),
+		LogSource: evidenceLogSource(e),
+		Detection: EvidenceDetection{
+			Signatures:  d.Names(),
+			Criticality: d.Criticality,
+			ATTACK:      d.ATTACK,
+		},
+		Event: EvidenceEvent{
+			Timestamp: e.Timestamp().UTC(),
+			Channel:   e.Channel(),
+			EventID:   e.EventID(),
+			Provider:  e.Event.System.Provider.Name,
+			Computer:  e.Computer(),
+			Fields:    make(map[string]interface{}),
+		},
+		Redacted: make([]string, 0),
+		Dropped:  make([]string, 0),
+	}
+
+	sort.Strings(b.Detection.Signatures)
+	b.Title = strings.Join(b.Detection.Signatures, ", ")
+	b.Level = evidenceLevel(d.Criticality)
+	b.Tags = evidenceTags(d.ATTACK)
+
+	// computer name always identifies the organization
+	if b.Event.Computer != "" {
+		b.Event.Computer = p.pseudonym(b.Event.Computer)
+	}
+
+	for field, value := range e.Event.EventData {
+		switch {
+		case p.drop[field]:
+			b.Dropped = append(b.Dropped, field)
+		case p.redact[field]:
+			b.Event.Fields[field] = p.pseudonym(fmt.Sprint(value))
+			b.Redacted = append(b.Redacted, field)
+		default:
+			if s, ok := value.(string); ok {
+				b.Event.Fields[field] = p.anonymize(s)
+			} else {
+				b.Event.Fields[field] = value
+			}
+		}
+	}
+
+	sort.Strings(b.Redacted)
+	sort.Strings(b.Dropped)
+
+	return
+}
+
+// evidenceHashMatch returns true if event is identified by hash, either the
+// hash committed when event was received or the one of the event as stored
+func evidenceHashMatch(e *event.EdrEvent, hash string) bool {
+	if e.Event.EdrData != nil && e.Event.EdrData.Event.Hash == hash {
+		return true
+	}
+	return e.Hash() == hash
+}
+
+// evidenceSalt returns the salt pseudonyms are computed with, it is derived
+// from the containment key if not configured so that it is stable across
+// restarts without being known by anyone
+func (m *Manager) evidenceSalt() []byte {
+	if m.Config.Evidence.Salt != "" {
+		return []byte(m.Config.Evidence.Salt)
+	}
+
+	mac := hmac.New(sha256.New, m.containmentKey.PrivateKey)
+	mac.Write([]byte("evidence"))
+	return mac.Sum(nil)
+}
+
+// EvidenceBundle searches alert identified by its hash, for endpoint euuid
+// within time range, and converts it into an anonymized evidence bundle
+func (m *Manager) EvidenceBundle(euuid, ehash string, start, stop time.Time) (b *EvidenceBundle, err error) {
+	var alert *event.EdrEvent
+
+	for raw := range m.detectionSearcher.Events(start, stop, euuid, math.MaxInt, 0) {
+		// channel must be drained
+		if alert != nil {
+			continue
+		}
+
+		if e, err := raw.Event(); err == nil && evidenceHashMatch(e, ehash) {
+			alert = e
+		}
+	}
+
+	if err = m.detectionSearcher.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search alert: %w", err)
+	}
+
+	if alert == nil {
+		return nil, fmt.Errorf("alert %s not found", ehash)
+	}
+
+	return newEvidencePolicy(&m.Config.Evidence, m.evidenceSalt()).bundle(alert)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils/parquet"
+)
+
+const (
+	// ExportFormatCSV exports alerts as CSV
+	ExportFormatCSV = "csv"
+	// ExportFormatParquet exports alerts as Apache Parquet
+	ExportFormatParquet = "parquet"
+)
+
+var (
+	exportColumns = []parquet.Column{
+		{Name: "Timestamp", Type: parquet.String},
+		{Name: "EndpointUUID", Type: parquet.String},
+		{Name: "Hostname", Type: parquet.String},
+		{Name: "Group", Type: parquet.String},
+		{Name: "Channel", Type: parquet.String},
+		{Name: "EventID", Type: parquet.Int64},
+		{Name: "Criticality", Type: parquet.Int64},
+		{Name: "Signatures", Type: parquet.String},
+		{Name: "Image", Type: parquet.String},
+		{Name: "CommandLine", Type: parquet.String},
+		{Name: "Data", Type: parquet.String},
+	}
+
+	exportContentTypes = map[string]string{
+		ExportFormatCSV:     "text/csv",
+		ExportFormatParquet: "application/vnd.apache.parquet",
+	}
+)
+
+// exportFilter filters alerts to export
+type exportFilter struct {
+	group          string
+	minCriticality int
+	signature      string
+}
+
+func (f *exportFilter) match(e *event.EdrEvent) bool {
+	d := e.GetDetection()
+	if d == nil {
+		return false
+	}
+
+	if d.Criticality < f.minCriticality {
+		return false
+	}
+
+	if f.group != "" {
+		if e.Event.EdrData == nil || e.Event.EdrData.Endpoint.Group != f.group {
+			return false
+		}
+	}
+
+	if f.signature != "" {
+		for _, s := range d.Names() {
+			if s == f.signature {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func exportEventDataString(e *event.EdrEvent, field string) string {
+	if v, ok := e.Event.EventData[field]; ok {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// exportRow converts an alert into a row of values ordered as exportColumns
+func exportRow(e *event.EdrEvent) (row []interface{}, err error) {
+	var data []byte
+	var uuid, hostname, group string
+
+	if data, err = json.Marshal(e.Event.EventData); err != nil {
+		return
+	}
+
+	if e.Event.EdrData != nil {
+		uuid = e.Event.EdrData.Endpoint.UUID
+		hostname = e.Event.EdrData.Endpoint.Hostname
+		group = e.Event.EdrData.Endpoint.Group
+	}
+
+	d := e.GetDetection()
+
+	return []interface{}{
+		e.Timestamp().Format(time.RFC3339Nano),
+		uuid,
+		hostname,
+		group,
+		e.Channel(),
+		e.EventID(),
+		int64(d.Criticality),
+		strings.Join(d.Names(), ";"),
+		exportEventDataString(e, "Image"),
+		exportEventDataString(e, "CommandLine"),
+		string(data),
+	}, nil
+}
+
+// alertExporter writes alerts to an io.Writer in a given format
+type alertExporter struct {
+	w       io.Writer
+	csv     *csv.Writer
+	parquet *parquet.Writer
+}
+
+func newAlertExporter(w io.Writer, format string) (e *alertExporter, err error) {
+	e = &alertExporter{w: w}
+
+	switch format {
+	case ExportFormatCSV:
+		e.csv = csv.NewWriter(w)
+		header := make([]string, 0, len(exportColumns))
+		for _, c := range exportColumns {
+			header = append(header, c.Name)
+		}
+		err = e.csv.Write(header)
+	case ExportFormatParquet:
+		// parquet files are written at once when closing exporter
+		e.parquet = parquet.NewWriter(exportColumns...)
+	default:
+		err = fmt.Errorf("unknown export format %s", format)
+	}
+
+	return
+}
+
+func (e *alertExporter) Write(evt *event.EdrEvent) (err error) {
+	var row []interface{}
+
+	if row, err = exportRow(evt); err != nil {
+		return
+	}
+
+	if e.parquet != nil {
+		return e.parquet.Append(row...)
+	}
+
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = fmt.Sprint(v)
+	}
+	return e.csv.Write(record)
+}
+
+func (e *alertExporter) Close() (err error) {
+	if e.parquet != nil {
+		_, err = e.parquet.WriteTo(e.w)
+		return
+	}
+
+	e.csv.Flush()
+	return e.csv.Error()
+}
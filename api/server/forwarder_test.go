@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,12 +9,17 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/readers"
 	"github.com/0xrawsec/golang-utils/sync/semaphore"
 	"github.com/0xrawsec/golog"
@@ -159,6 +165,10 @@ func TestForwarderBasic(t *testing.T) {
 	}
 	time.Sleep(5 * time.Second)
 
+	if s := f.Sequence(); s != uint64(nevents) {
+		t.Errorf("Wrong sequence number %d instead of %d", s, nevents)
+	}
+
 	// shuts down the receiver before counting lines
 	r.Shutdown()
 	if n := countEvents(r.eventSearcher); n != nevents {
@@ -265,6 +275,10 @@ func TestCollectorAuthSuccess(t *testing.T) {
 	}
 	time.Sleep(5 * time.Second)
 
+	if s := f.Sequence(); s != uint64(nevents) {
+		t.Errorf("Wrong sequence number %d instead of %d", s, nevents)
+	}
+
 	// shuts down the receiver before counting lines
 	r.Shutdown()
 	if n := countEvents(r.eventSearcher); n != nevents {
@@ -468,3 +482,385 @@ func TestForwarderCleanup(t *testing.T) {
 	expected := numberOfQueuedFiles + 1
 	tt.Assert(len(files) == expected, format("Expecting %d remaining in directory but got %d", expected, len(files)))
 }
+
+func TestForwarderRetention(t *testing.T) {
+
+	tt := toast.FromT(t)
+
+	// cleanup
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	conf := fconf
+	conf.Local = true
+	conf.Logging.MaxAge = time.Hour
+	conf.Logging.MaxSize = 3 * 4096
+	conf.Logging.Compress = true
+
+	f, err := client.NewForwarder(context.Background(), &conf, golog.FromStdout())
+	tt.CheckErr(err)
+	defer f.Close()
+
+	write := func(name string, size int, mtime time.Time) string {
+		fp := filepath.Join(conf.Logging.Dir, name)
+		buf := make([]byte, size)
+		rand.Read(buf)
+		tt.CheckErr(os.WriteFile(fp, buf, 0600))
+		tt.CheckErr(os.Chtimes(fp, mtime, mtime))
+		return fp
+	}
+
+	now := time.Now()
+	expired := write("alerts.log.5.gz", 10, now.Add(-2*time.Hour))
+	rotated := write("alerts.log.1", 10, now)
+	for i := 2; i < 6; i++ {
+		write(fmt.Sprintf("alerts.log.%d.gz", i+10), 4096, now.Add(-time.Duration(i)*time.Minute))
+	}
+
+	tt.CheckErr(f.EnforceRetention())
+
+	tt.Assert(!fsutil.Exists(expired))
+	tt.Assert(!fsutil.Exists(rotated))
+	tt.Assert(fsutil.IsFile(rotated + ".gz"))
+	tt.Assert(f.DiskSpaceQueue() <= conf.Logging.MaxSize)
+	// older files are deleted first
+	tt.Assert(!fsutil.Exists(filepath.Join(conf.Logging.Dir, "alerts.log.15.gz")))
+	tt.Assert(fsutil.IsFile(filepath.Join(conf.Logging.Dir, "alerts.log.12.gz")))
+}
+
+func TestForwarderSyslog(t *testing.T) {
+	tt := toast.FromT(t)
+
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	for _, format := range []string{client.SyslogFormatCEF, client.SyslogFormatLEEF} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		tt.CheckErr(err)
+
+		conf := fconf
+		conf.Local = true
+		conf.Syslog = config.ForwarderSyslog{
+			Enable:   true,
+			Proto:    client.SyslogProtoTCP,
+			Address:  ln.Addr().String(),
+			Format:   format,
+			Facility: "local4",
+		}
+
+		f, err := client.NewForwarder(context.Background(), &conf, golog.FromStdout())
+		tt.CheckErr(err)
+
+		ndetections := 10
+		for e := range emitMixedEvents(20, ndetections) {
+			tt.CheckErr(f.PipeEvent(e))
+		}
+		f.Close()
+
+		conn, err := ln.Accept()
+		tt.CheckErr(err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		prefix := "CEF:0|0xrawsec|WHIDS|"
+		if format == client.SyslogFormatLEEF {
+			prefix = "LEEF:1.0|0xrawsec|WHIDS|"
+		}
+
+		n := 0
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// local4 facility
+			tt.Assert(strings.HasPrefix(line, "<16"))
+			tt.Assert(strings.Contains(line, " whids: "+prefix), line)
+			n++
+		}
+
+		// only alerts are sent to syslog
+		tt.Assert(n == ndetections)
+
+		conn.Close()
+		ln.Close()
+	}
+}
+
+func TestForwarderSplunk(t *testing.T) {
+	tt := toast.FromT(t)
+
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	client.BatchBackoff = 100 * time.Millisecond
+
+	token := "b6a1d5e5-6b2e-4d0a-9a61-4a0cf6b0e3f2"
+	sysmonChannel := "Microsoft-Windows-Sysmon/Operational"
+
+	var mut sync.Mutex
+	requests, n := 0, 0
+	indexes := make(map[string]int)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		requests++
+		tt.Assert(r.URL.Path == client.SplunkHECPath)
+		tt.Assert(r.Header.Get("Authorization") == "Splunk "+token)
+
+		// first request fails so that it is retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		dec := json.NewDecoder(r.Body)
+		for {
+			e := struct {
+				Time       float64        `json:"time"`
+				Sourcetype string         `json:"sourcetype"`
+				Index      string         `json:"index"`
+				Event      event.EdrEvent `json:"event"`
+			}{}
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else {
+				tt.CheckErr(err)
+			}
+			tt.Assert(e.Time > 0)
+			tt.Assert(e.Sourcetype == "whids")
+			indexes[e.Index]++
+			n++
+		}
+	}))
+	defer srv.Close()
+
+	conf := fconf
+	conf.Local = true
+	conf.Splunk = config.ForwarderSplunk{
+		Enable:        true,
+		URL:           srv.URL,
+		Token:         token,
+		Index:         "whids",
+		Sourcetype:    "whids",
+		BatchSize:     10,
+		FlushInterval: time.Second,
+		MaxRetries:    3,
+		Mappings: []*config.SplunkMapping{
+			{Channel: sysmonChannel, Index: "sysmon"},
+		},
+	}
+
+	f, err := client.NewForwarder(context.Background(), &conf, golog.FromStdout())
+	tt.CheckErr(err)
+
+	nevents, ndetections := 20, 10
+	nsysmon := 0
+	for e := range emitMixedEvents(nevents, ndetections) {
+		if e.Channel() == sysmonChannel {
+			nsysmon++
+		}
+		tt.CheckErr(f.PipeEvent(e))
+	}
+	f.Close()
+
+	mut.Lock()
+	defer mut.Unlock()
+
+	tt.Assert(n == nevents+ndetections, n)
+	tt.Assert(indexes["sysmon"] == nsysmon)
+	tt.Assert(indexes["whids"] == n-nsysmon)
+	// 3 batches + 1 retry
+	tt.Assert(requests == 4, requests)
+}
+
+func TestForwarderElastic(t *testing.T) {
+	tt := toast.FromT(t)
+
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	client.BatchBackoff = 100 * time.Millisecond
+
+	var mut sync.Mutex
+	templates, bulks := 0, 0
+	docs := make(map[string]bool)
+	alerts := 0
+	index := fmt.Sprintf("whids-%s", time.Now().UTC().Format("2006.01.02"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		user, pass, ok := r.BasicAuth()
+		tt.Assert(ok && user == "whids" && pass == "secret")
+
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == client.ElasticTemplatePath+"whids":
+			templates++
+			tmpl := struct {
+				Patterns []string `json:"index_patterns"`
+			}{}
+			tt.CheckErr(json.NewDecoder(r.Body).Decode(&tmpl))
+			tt.Assert(len(tmpl.Patterns) == 1 && tmpl.Patterns[0] == "whids-*")
+
+		case r.Method == http.MethodPost && r.URL.Path == client.ElasticBulkPath:
+			bulks++
+			tt.Assert(r.Header.Get("Content-Type") == "application/x-ndjson")
+
+			items := make([]string, 0)
+			scanner := bufio.NewScanner(r.Body)
+			scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+			for scanner.Scan() {
+				action := struct {
+					Index struct {
+						Index string `json:"_index"`
+						ID    string `json:"_id"`
+					} `json:"index"`
+				}{}
+				tt.CheckErr(json.Unmarshal(scanner.Bytes(), &action))
+				tt.Assert(action.Index.Index == index)
+				tt.Assert(action.Index.ID != "")
+
+				tt.Assert(scanner.Scan())
+				doc := struct {
+					Timestamp time.Time `json:"@timestamp"`
+					Gene      struct {
+						Alert       bool     `json:"alert"`
+						Criticality int      `json:"criticality"`
+						Signatures  []string `json:"signatures"`
+					} `json:"gene"`
+				}{}
+				tt.CheckErr(json.Unmarshal(scanner.Bytes(), &doc))
+				tt.Assert(!doc.Timestamp.IsZero())
+
+				// first bulk request is partially rejected because of load
+				if bulks > 1 && !docs[action.Index.ID] {
+					docs[action.Index.ID] = true
+					if doc.Gene.Alert {
+						tt.Assert(len(doc.Gene.Signatures) > 0)
+						alerts++
+					}
+				}
+				items = append(items, fmt.Sprintf(`{"index":{"status":%d}}`, http.StatusCreated))
+			}
+
+			if bulks == 1 {
+				items[0] = fmt.Sprintf(`{"index":{"status":%d}}`, http.StatusTooManyRequests)
+			}
+
+			fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, bulks == 1, strings.Join(items, ","))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	conf := fconf
+	conf.Local = true
+	conf.Elastic = config.ForwarderElastic{
+		Enable:          true,
+		URL:             srv.URL,
+		Username:        "whids",
+		Password:        "secret",
+		Index:           "whids",
+		DailyIndices:    true,
+		InstallTemplate: true,
+		BatchSize:       100,
+		FlushInterval:   time.Second,
+		MaxRetries:      3,
+	}
+
+	f, err := client.NewForwarder(context.Background(), &conf, golog.FromStdout())
+	tt.CheckErr(err)
+
+	nevents, ndetections := 20, 10
+	for e := range emitMixedEvents(nevents, ndetections) {
+		tt.CheckErr(f.PipeEvent(e))
+	}
+	f.Close()
+
+	mut.Lock()
+	defer mut.Unlock()
+
+	tt.Assert(templates == 1)
+	tt.Assert(bulks == 2, bulks)
+	tt.Assert(len(docs) == nevents+ndetections, len(docs))
+	tt.Assert(alerts == ndetections)
+}
+
+func TestForwarderOutputs(t *testing.T) {
+	tt := toast.FromT(t)
+
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.CheckErr(err)
+	defer ln.Close()
+
+	dir := t.TempDir()
+	conf := fconf
+	conf.Local = true
+	conf.File = config.ForwarderFile{
+		Enable: true,
+		Path:   filepath.Join(dir, "events.json"),
+	}
+	conf.Syslog = config.ForwarderSyslog{
+		Enable:   true,
+		Proto:    client.SyslogProtoTCP,
+		Address:  ln.Addr().String(),
+		Format:   client.SyslogFormatCEF,
+		Facility: "local4",
+	}
+	// unreachable output must not affect the others
+	conf.Splunk = config.ForwarderSplunk{
+		Enable:        true,
+		URL:           "http://127.0.0.1:1",
+		Token:         "token",
+		BatchSize:     100,
+		FlushInterval: time.Second,
+	}
+
+	f, err := client.NewForwarder(context.Background(), &conf, golog.FromStdout())
+	tt.CheckErr(err)
+
+	nevents, ndetections := 20, 10
+	for e := range emitMixedEvents(nevents, ndetections) {
+		tt.CheckErr(f.PipeEvent(e))
+	}
+	f.Close()
+
+	// every event is written to file
+	fd, err := os.Open(conf.File.Path)
+	tt.CheckErr(err)
+	defer fd.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		e := event.EdrEvent{}
+		tt.CheckErr(json.Unmarshal(scanner.Bytes(), &e))
+		n++
+	}
+	tt.Assert(n == nevents+ndetections, n)
+
+	// only alerts are sent to syslog
+	conn, err := ln.Accept()
+	tt.CheckErr(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n = 0
+	scanner = bufio.NewScanner(conn)
+	for scanner.Scan() {
+		n++
+	}
+	tt.Assert(n == ndetections, n)
+
+	dropped := f.OutputsDropped()
+	tt.Assert(dropped["file"] == 0)
+	tt.Assert(dropped["syslog"] == 0)
+	tt.Assert(dropped["splunk"] == uint64(nevents+ndetections))
+}
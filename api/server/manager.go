@@ -19,6 +19,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -45,6 +47,23 @@ const (
 )
 
 var (
+	// IoCsPruningInterval interval at which expired IoCs are pruned
+	IoCsPruningInterval = time.Minute
+
+	// MetricsFlushInterval interval at which metrics rollups are saved to database
+	MetricsFlushInterval = time.Minute
+	// MetricsRetention how long metrics rollups are kept
+	MetricsRetention = 365 * 24 * time.Hour
+
+	// DynamicGroupsInterval interval at which dynamic groups are re-evaluated
+	DynamicGroupsInterval = time.Minute
+
+	// ArchiveInterval interval at which old artifacts are moved to cold storage
+	ArchiveInterval = time.Hour
+
+	// NoiseInterval interval at which alerts noise is modeled
+	NoiseInterval = 24 * time.Hour
+
 	noBracketGuidRe = regexp.MustCompile(`(?i:[a-f0-9]{8}-([a-f0-9]{4}-){3}[a-f0-9]{12})`)
 )
 
@@ -121,6 +140,46 @@ type EndpointAPIConfig struct {
 	ServerKey string `toml:"server-key" comment:"Server key used to do basic authentication of the server on clients.\n Configure certificate pinning on client offers better security."`
 }
 
+// ArchiveConfig structure holding configuration of artifacts cold storage
+type ArchiveConfig struct {
+	Dir    string `toml:"dir" comment:"Cold storage directory artifacts are moved to (i.e. mount point of a cheaper storage class)"`
+	MaxAge int    `toml:"max-age" comment:"Number of days after which artifacts are moved to cold storage, 0 disables archiving"`
+}
+
+// Enabled returns true if artifacts archiving is enabled
+func (c *ArchiveConfig) Enabled() bool {
+	return c.Dir != "" && c.MaxAge > 0
+}
+
+// NoiseConfig structure holding configuration of alerts noise modeling
+type NoiseConfig struct {
+	Window  int `toml:"window" comment:"Number of days of alerts noise is learnt from, 0 disables periodic noise modeling"`
+	MinHits int `toml:"min-hits" comment:"Minimum number of alerts of a rule before making tuning suggestions"`
+}
+
+// Enabled returns true if periodic noise modeling is enabled
+func (c *NoiseConfig) Enabled() bool {
+	return c.Window > 0
+}
+
+// ResponderConfig structure holding configuration of responder sessions
+// destructive commands are sent within
+type ResponderConfig struct {
+	Enforce      bool          `toml:"enforce" comment:"Refuse destructive commands (terminate, suspend, resume, quarantine, contain, uncontain,\n isolate-process, release-process, block, disable-user, revert) sent outside of a responder session"`
+	Group        string        `toml:"group" comment:"Group admin API users must belong to in order to open responder sessions\n (empty allows any user)"`
+	SecondFactor bool          `toml:"second-factor" comment:"Require a TOTP code to open responder sessions"`
+	MaxValidity  time.Duration `toml:"max-validity" comment:"Maximum validity of a responder session"`
+	MaxEndpoints int           `toml:"max-endpoints" comment:"Maximum number of endpoints a responder session covers (0 means unlimited)"`
+}
+
+// EvidenceConfig structure holding the policy applied to alerts
+// exported as evidence bundles
+type EvidenceConfig struct {
+	Redact []string `toml:"redact" comment:"Event fields whose values are replaced by a pseudonym in evidence bundles,\n accounts, hosts and network addresses fields are redacted when empty"`
+	Drop   []string `toml:"drop" comment:"Event fields removed from evidence bundles"`
+	Salt   string   `toml:"salt" comment:"Secret pseudonyms are computed with, a given value always gets the same pseudonym\n so that evidences can be correlated (derived from manager's keys when empty)"`
+}
+
 // ManagerLogConfig structure to hold manager's logging configuration
 type ManagerLogConfig struct {
 	Root        string `toml:"root" comment:"Root directory where logfiles are stored"`
@@ -138,6 +197,10 @@ type ManagerConfig struct {
 	EndpointAPI EndpointAPIConfig `toml:"endpoint-api" comment:"Settings to configure API used by endpoints"`
 	Logging     ManagerLogConfig  `toml:"logging" comment:"Logging settings"`
 	TLS         TLSConfig         `toml:"tls" comment:"TLS settings. Leave empty, not to use TLS"`
+	Archive     ArchiveConfig     `toml:"archive" comment:"Settings to move old artifacts to cold storage"`
+	Noise       NoiseConfig       `toml:"noise" comment:"Settings to model alerts noise and suggest rules tuning"`
+	Responder   ResponderConfig   `toml:"responder" comment:"Settings of responder sessions required to send destructive commands"`
+	Evidence    EvidenceConfig    `toml:"evidence" comment:"Anonymization policy of alerts exported as shareable evidence bundles"`
 	path        string
 }
 
@@ -165,7 +228,7 @@ func (mc *ManagerConfig) EndpointAPIUrl() string {
 		proto = "http"
 	}
 
-	return fmt.Sprintf("%s://%s:%d", proto, mc.EndpointAPI.Host, mc.EndpointAPI.Port)
+	return fmt.Sprintf("%s://%s", proto, net.JoinHostPort(mc.EndpointAPI.Host, strconv.Itoa(mc.EndpointAPI.Port)))
 }
 
 // EndpointAPIUrl returns the URL of the Admin API
@@ -175,7 +238,7 @@ func (mc *ManagerConfig) AdminAPIUrl() string {
 		proto = "http"
 	}
 
-	return fmt.Sprintf("%s://%s:%d", proto, mc.AdminAPI.Host, mc.AdminAPI.Port)
+	return fmt.Sprintf("%s://%s", proto, net.JoinHostPort(mc.AdminAPI.Host, strconv.Itoa(mc.AdminAPI.Port)))
 }
 
 // Save saves the configuration to a path specified by the path member of the structure
@@ -193,6 +256,8 @@ type Manager struct {
 	/* Private */
 	db                *sod.DB
 	eventStreamer     *EventStreamer
+	eventTap          *EventTap
+	batches           *BatchHistory
 	eventLogger       *logger.EventLogger
 	eventSearcher     *logger.EventSearcher
 	detectionLogger   *logger.EventLogger
@@ -208,6 +273,61 @@ type Manager struct {
 		reducer *reducer.Reducer
 		rules   string // to cache the rules concatenated
 		sha256  string // rules integrity check and update
+		// candidate rules pushed to the candidate cohort of a rollout
+		candidate struct {
+			names  []string
+			rules  string
+			sha256 string
+		}
+	}
+
+	// false positive suppressions pushed to endpoints
+	suppressions struct {
+		list   []*api.Suppression
+		sha256 string
+	}
+
+	// response playbooks pushed to endpoints
+	playbooks struct {
+		list   []*api.Playbook
+		sha256 string
+	}
+
+	// trusted processes pushed to endpoints
+	trusted struct {
+		list   []*api.TrustedProcess
+		sha256 string
+	}
+
+	// Gene containers pushed to endpoints
+	containers struct {
+		list   []*api.EdrContainer
+		sha256 string
+	}
+
+	// rules staged rollout
+	rollout      *api.RulesRollout
+	rolloutStats *api.RolloutStats
+	rolloutMutex sync.Mutex
+
+	// serializes incidents modifications
+	incidentsMutex sync.Mutex
+
+	// dynamic groups of endpoints
+	groups struct {
+		sync.RWMutex
+		list []*api.EndpointGroup
+	}
+
+	// key used to sign containment release tokens
+	containmentKey *api.ContainmentKey
+
+	// daily telemetry rollups not yet flushed to database
+	metrics struct {
+		sync.Mutex
+		rollups map[string]*api.MetricsRollup
+		// event sequences by endpoint
+		sequences map[string]*api.EventSequence
 	}
 
 	iocs *ioc.IoCs
@@ -226,6 +346,9 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 		Logger: golog.FromStdout(),
 		Config: c}
 
+	m.metrics.rollups = make(map[string]*api.MetricsRollup)
+	m.metrics.sequences = make(map[string]*api.EventSequence)
+
 	eventDir := filepath.Join(c.Logging.Root, "events")
 	m.eventLogger = logger.NewEventLogger(eventDir, c.Logging.LogBasename, utils.Giga)
 	m.eventSearcher = logger.NewEventSearcher(eventDir)
@@ -238,6 +361,8 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 
 	// Create a new streamer
 	m.eventStreamer = NewEventStreamer()
+	m.eventTap = NewEventTap(DefaultTapBufferSize)
+	m.batches = NewBatchHistory(DefaultBatchHistorySize)
 
 	if c.EndpointAPI.Port <= 0 || c.EndpointAPI.Port > 65535 {
 		return nil, fmt.Errorf("manager Endpoint API Error: invalid port to listen to %d", c.EndpointAPI.Port)
@@ -258,6 +383,41 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 	// initialize IoCs from db
 	m.iocs.FromDB(m.db)
 
+	// initialize suppressions from db
+	if err := m.updateSuppressionsCache(); err != nil {
+		return nil, fmt.Errorf("failed to initialize suppressions: %w", err)
+	}
+
+	// initialize playbooks from db
+	if err := m.updatePlaybooksCache(); err != nil {
+		return nil, fmt.Errorf("failed to initialize playbooks: %w", err)
+	}
+
+	// initialize trusted processes from db
+	if err := m.updateTrustedCache(); err != nil {
+		return nil, fmt.Errorf("failed to initialize trusted processes: %w", err)
+	}
+
+	// initialize containers from db
+	if err := m.updateContainersCache(); err != nil {
+		return nil, fmt.Errorf("failed to initialize containers: %w", err)
+	}
+
+	// initialize dynamic groups from db
+	if err := m.updateGroupsCache(); err != nil {
+		return nil, fmt.Errorf("failed to initialize dynamic groups: %w", err)
+	}
+
+	// initialize rules rollout from db
+	if err := m.initializeRolloutFromDB(); err != nil {
+		return nil, fmt.Errorf("failed to initialize rules rollout: %w", err)
+	}
+
+	// initialize containment release key from db
+	if err := m.initializeContainmentKey(); err != nil {
+		return nil, fmt.Errorf("failed to initialize containment key: %w", err)
+	}
+
 	m.stop = make(chan bool)
 	if err = c.TLS.Verify(); err != nil && !c.TLS.Empty() {
 		return nil, err
@@ -274,6 +434,13 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 			return &m, fmt.Errorf("failed to created dump directory (%s): %s", m.Config.DumpDir, err)
 		}
 	}
+
+	// Cold storage directory initialization
+	if m.Config.Archive.Enabled() && !fsutil.IsDir(m.Config.Archive.Dir) {
+		if err := os.MkdirAll(m.Config.Archive.Dir, utils.DefaultFilePerm); err != nil {
+			return &m, fmt.Errorf("failed to created archive directory (%s): %s", m.Config.Archive.Dir, err)
+		}
+	}
 	return &m, nil
 }
 
@@ -346,6 +513,100 @@ func (m *Manager) initializeDB() (err error) {
 		return
 	}
 
+	// Create schema for EdrCandidateRule
+	candRulesDesc := sod.FieldDescriptors(&api.EdrCandidateRule{})
+	candRulesDesc.Constraint("Name", sod.Constraints{Index: true, Unique: true})
+	candRulesSchema := sod.NewCustomSchema(candRulesDesc, ".gen")
+
+	if err = m.createTableOrRepair(&api.EdrCandidateRule{}, candRulesSchema); err != nil {
+		return
+	}
+
+	// Creating RulesRollout table
+	if err = m.createTableOrRepair(&api.RulesRollout{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating AlertVerdict table
+	if err = m.createTableOrRepair(&api.AlertVerdict{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating Suppression table
+	if err = m.createTableOrRepair(&api.Suppression{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating EndpointGroup table
+	if err = m.createTableOrRepair(&api.EndpointGroup{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating Playbook table
+	if err = m.createTableOrRepair(&api.Playbook{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating PlaybookRun table
+	if err = m.createTableOrRepair(&api.PlaybookRun{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating TrustedProcess table
+	if err = m.createTableOrRepair(&api.TrustedProcess{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating ArchivedArtifact table
+	if err = m.createTableOrRepair(&api.ArchivedArtifact{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating Incident table
+	if err = m.createTableOrRepair(&api.Incident{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating MetricsRollup table
+	if err = m.createTableOrRepair(&api.MetricsRollup{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating EdrContainer table
+	if err = m.createTableOrRepair(&api.EdrContainer{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating ContainerChange table
+	if err = m.createTableOrRepair(&api.ContainerChange{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating RuleUsage table
+	if err = m.createTableOrRepair(&api.RuleUsage{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating AgentError table
+	if err = m.createTableOrRepair(&api.AgentError{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating ContainmentKey table
+	if err = m.createTableOrRepair(&api.ContainmentKey{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating NoiseReport table
+	if err = m.createTableOrRepair(&api.NoiseReport{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
+	// Creating ResponderSession table
+	if err = m.createTableOrRepair(&api.ResponderSession{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
 	return
 }
 
@@ -371,177 +632,1083 @@ func (m *Manager) initializeGeneFromDB() error {
 	m.gene.reducer = reducer
 	m.updateRulesCache()
 
-	return nil
+	// candidate rule set is made of stable rules so it must be
+	// rebuilt every time stable rules are updated
+	return m.initializeCandidateFromDB()
 
 }
 
-func (m *Manager) updateRulesCache() {
+// initializeCandidateFromDB builds the candidate rule set, made of
+// stable rules overwritten by candidate rules
+func (m *Manager) initializeCandidateFromDB() error {
+	eng := engine.NewEngine()
+	eng.SetDumpRaw(true)
+
+	objs, err := m.db.All(&api.EdrCandidateRule{})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(objs))
+	candidates := make(map[string]bool)
+	for _, o := range objs {
+		rule := o.(*api.EdrCandidateRule)
+		if err := eng.LoadRule(&rule.Rule); err != nil {
+			return fmt.Errorf("fail to load candidate rule %s: %s", rule.Name, err)
+		}
+		names = append(names, rule.Name)
+		candidates[rule.Name] = true
+	}
+
+	if objs, err = m.db.All(&api.EdrRule{}); err != nil {
+		return err
+	}
+
+	for _, o := range objs {
+		rule := o.(*api.EdrRule)
+		// stable rule is overwritten by candidate
+		if candidates[rule.Name] {
+			continue
+		}
+		if err := eng.LoadRule(&rule.Rule); err != nil {
+			return fmt.Errorf("fail to load rule %s: %s", rule.Name, err)
+		}
+	}
+
+	rules, sha256 := rulesCache(eng)
+
+	// candidate rule set is read by endpoints under rollout lock
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
+
+	m.gene.candidate.names = names
+	m.gene.candidate.rules, m.gene.candidate.sha256 = rules, sha256
+
+	return nil
+}
+
+func rulesCache(e *engine.Engine) (rules string, sum string) {
 	sha256 := sha256.New()
 	buf := new(bytes.Buffer)
-	for rr := range m.gene.engine.GetRawRule(".*") {
+	for rr := range e.GetRawRule(".*") {
 		chunk := []byte(rr + "\n")
 		buf.Write(chunk)
 		sha256.Write(chunk)
 	}
-	m.gene.rules = buf.String()
-	m.gene.sha256 = hex.EncodeToString(sha256.Sum(nil))
+	return buf.String(), hex.EncodeToString(sha256.Sum(nil))
 }
 
-// AddCommand sets a command to be executed on endpoint specified by UUID
-func (m *Manager) AddCommand(uuid string, c *api.EndpointCommand) error {
-	if endpt, ok := m.Endpoint(uuid); ok {
-		endpt.Command = c
-		return m.db.InsertOrUpdate(endpt)
-	}
-	return ErrUnkEndpoint
-}
+func (m *Manager) updateRulesCache() {
+	rules, sha256 := rulesCache(m.gene.engine)
 
-// GetCommand gets the command set for an endpoint specified by UUID
-func (m *Manager) GetCommand(uuid string) (*api.EndpointCommand, error) {
-	if endpt, ok := m.Endpoint(uuid); ok {
-		// We return the command of an unmutable endpoint struct
-		// so if Command is modified this will not affect Endpoint
-		return endpt.Command, nil
-	}
-	return nil, ErrUnkEndpoint
-}
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
 
-// Endpoint returns an Endpoint pointer from database
-// Result must be handled with care as any change to the Endpoint
-// might be commited to the database. If an Endpoint needs to be
-// modified but changes don't need to be commited, use Endpoint.Copy()
-// to work on a copy
-func (m *Manager) Endpoint(uuid string) (*api.Endpoint, bool) {
-	if o, err := m.db.GetByUUID(&api.Endpoint{}, uuid); err == nil {
-		// we return copy to endpoints not to modify cached structures
-		return o.(*api.Endpoint), true
-	}
-	return nil, false
+	m.gene.rules, m.gene.sha256 = rules, sha256
 }
 
-// Endpoints returns a slice of Endpoint pointers from database
-// Result must be handled with care as any change to the Endpoint
-// might be commited to the database. If an Endpoint needs to be
-// modified but changes don't need to be commited, use Endpoint.Copy()
-// to work on a copy
-func (m *Manager) Endpoints() (endpoints []*api.Endpoint, err error) {
-	var all []sod.Object
+func (m *Manager) initializeRolloutFromDB() (err error) {
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
 
-	if all, err = m.db.All(&api.Endpoint{}); err != nil {
+	var objs []sod.Object
+
+	m.rollout = &api.RulesRollout{}
+	if objs, err = m.db.All(&api.RulesRollout{}); err != nil {
 		return
 	}
 
-	endpoints = make([]*api.Endpoint, 0, len(all))
-	for _, o := range all {
-		// we return copy to endpoints not to modify cached structures
-		endpoints = append(endpoints, o.(*api.Endpoint))
+	// there is only one rollout configuration
+	if len(objs) > 0 {
+		m.rollout = objs[0].(*api.RulesRollout)
 	}
 
+	m.rolloutStats = api.NewRolloutStats()
 	return
 }
 
-func (m *Manager) ImportRules(directory string) (err error) {
-	engine := engine.NewEngine()
-	engine.SetDumpRaw(true)
+// initializeContainmentKey loads the key used to sign containment
+// release tokens, it is generated at first start
+func (m *Manager) initializeContainmentKey() (err error) {
+	var objs []sod.Object
 
-	if err = engine.LoadDirectory(directory); err != nil {
+	if objs, err = m.db.All(&api.ContainmentKey{}); err != nil {
 		return
 	}
 
-	rules := make([]*api.EdrRule, 0, engine.Count())
-	for rr := range engine.GetRawRule(".*") {
-		rule := &api.EdrRule{}
-		if err = json.Unmarshal([]byte(rr), &rule); err != nil {
-			return
-		}
-		rules = append(rules, rule)
+	// there is only one containment key
+	if len(objs) > 0 {
+		m.containmentKey = objs[0].(*api.ContainmentKey)
+		return
 	}
 
-	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(rules)...); err != nil {
-		return err
+	if m.containmentKey, err = api.NewContainmentKey(); err != nil {
+		return
 	}
 
-	return
+	return m.db.InsertOrUpdate(m.containmentKey)
 }
 
-// CreateNewAdminAPIUser creates a new user in the user able to access admin API in database.
-func (m *Manager) CreateNewAdminAPIUser(user *AdminAPIUser) (err error) {
-	if err = m.db.InsertOrUpdate(user); err != nil && !sod.IsUnique(err) {
-		return err
-	} else if sod.IsUnique(err) {
-		err = errors.New("user with same Identifier or Uuid or Key already exists in database")
+// UpdateRulesRollout updates the rules rollout configuration and
+// resets rollout statistics
+func (m *Manager) UpdateRulesRollout(r *api.RulesRollout) (err error) {
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
+
+	// there is only one rollout configuration
+	r.Initialize(m.rollout.UUID())
+	if err = m.db.InsertOrUpdate(r); err != nil {
 		return
 	}
 
+	m.rollout = r
+	m.rolloutStats = api.NewRolloutStats()
 	return
+}
+
+// RulesCohort returns the rollout cohort an endpoint belongs to
+func (m *Manager) RulesCohort(endpt *api.Endpoint) string {
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
 
+	return m.rulesCohort(endpt)
 }
 
-// AddEndpoint adds new endpoint to the manager
-func (m *Manager) AddEndpoint(uuid, key string) {
-	m.db.InsertOrUpdate(api.NewEndpoint(uuid, key))
+// rulesCohort returns the rollout cohort an endpoint belongs to,
+// it must be called with rollout lock held
+func (m *Manager) rulesCohort(endpt *api.Endpoint) string {
+	if endpt == nil || len(m.gene.candidate.names) == 0 {
+		return api.CohortStable
+	}
+
+	return m.rollout.Cohort(endpt)
 }
 
-func (m *Manager) AddIoCs(iocs []*ioc.IOC) (err error) {
+// endpointRules returns the rules and their sha256 to push to an endpoint
+func (m *Manager) endpointRules(endpt *api.Endpoint) (rules string, sha256 string) {
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
 
-	// we preprocess to update existing IOCs
-	insert := make([]*ioc.IOC, 0, len(iocs))
-	for _, i := range iocs {
-		// we need to apply transformation before searching otherwise we
-		// might not find some values which have been transformed
-		i.Transform()
-		search := m.db.Search(&ioc.IOC{},
-			"Uuid", "=", i.Uuid)
-		if o, err := search.One(); err == nil {
-			// in order to update existing IOCs
-			i.Initialize(o.UUID())
-		}
+	if m.rulesCohort(endpt) == api.CohortCandidate {
+		return m.gene.candidate.rules, m.gene.candidate.sha256
+	}
+	return m.gene.rules, m.gene.sha256
+}
 
-		insert = append(insert, i)
+// RulesDiff returns the differences between the rules and containers
+// deployed to two targets, a target being either a rule set (stable or
+// candidate) or the UUID of an endpoint
+func (m *Manager) RulesDiff(from, to string) (diff *api.RulesDiff, err error) {
+	var fromRules, toRules string
+	var fromContainers, toContainers []*api.EdrContainer
+
+	diff = &api.RulesDiff{From: from, To: to}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	if fromRules, diff.FromRulesSha256, fromContainers, err = m.diffTarget(from); err != nil {
+		return nil, err
 	}
 
-	// Do bulk insertion
-	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(insert)...); err != nil {
-		//wt.Write(admErr(err))
-		return
+	if toRules, diff.ToRulesSha256, toContainers, err = m.diffTarget(to); err != nil {
+		return nil, err
 	}
 
-	// Add IoCs to sync with endpoints
-	m.iocs.Add(insert...)
+	if diff.Rules, err = api.DiffRawRules(fromRules, toRules); err != nil {
+		return nil, err
+	}
+
+	diff.FromContainersSha256 = api.ContainersSha256(fromContainers)
+	diff.ToContainersSha256 = api.ContainersSha256(toContainers)
+	diff.Containers = api.DiffContainers(fromContainers, toContainers)
 
 	return
 }
 
-// UpdateReducer updates the reducer member of the Manager
-func (m *Manager) UpdateReducer(identifier string, e *event.EdrEvent) {
-	if e.Event.Detection != nil {
-		isigs := e.Event.Detection.Signature.Slice()
-		sigs := make([]string, 0, len(isigs))
-
-		for _, s := range isigs {
-			sigs = append(sigs, s.(string))
+// diffTarget returns the rules and containers deployed to a rules
+// diff target, it must be called with manager lock held
+func (m *Manager) diffTarget(target string) (rules, sha256 string, containers []*api.EdrContainer, err error) {
+	switch target {
+	case api.CohortStable:
+		m.rolloutMutex.Lock()
+		rules, sha256 = m.gene.rules, m.gene.sha256
+		m.rolloutMutex.Unlock()
+	case api.CohortCandidate:
+		m.rolloutMutex.Lock()
+		rules, sha256 = m.gene.candidate.rules, m.gene.candidate.sha256
+		m.rolloutMutex.Unlock()
+	default:
+		endpt, ok := m.Endpoint(target)
+		if !ok {
+			return "", "", nil, fmt.Errorf("%w: %s", ErrUnkEndpoint, target)
 		}
+		rules, sha256 = m.endpointRules(endpt)
+	}
 
-		if len(sigs) > 0 {
-			m.gene.reducer.Update(e.Timestamp(), identifier, sigs)
+	// containers are pushed to all endpoints whatever their rules cohort
+	return rules, sha256, m.containers.list, nil
+}
+
+// updateRolloutStats updates statistics of the cohort endpoint belongs to
+func (m *Manager) updateRolloutStats(endpt *api.Endpoint, e *event.EdrEvent) {
+	cohort := m.RulesCohort(endpt)
+
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
+
+	stats := m.rolloutStats.Cohorts[cohort]
+	stats.Events++
+	if e.IsDetection() {
+		stats.Detections++
+		for _, s := range e.Event.Detection.Signature.Slice() {
+			stats.Signatures[s.(string)]++
 		}
 	}
 }
 
-func (m *Manager) logAPIErrorf(fmt string, i ...interface{}) {
-	funcName := "unk.UnknownFunc"
-	if pc, _, _, ok := runtime.Caller(1); ok {
-		split := strings.Split(runtime.FuncForPC(pc).Name(), "/")
-		funcName = split[len(split)-1]
+// metricsRollup returns the in memory rollup of an endpoint for the day t belongs
+// to, it must be called with metrics lock held
+func (m *Manager) metricsRollup(euuid string, t time.Time) *api.MetricsRollup {
+	r := api.NewMetricsRollup(euuid, t)
+	if cur, ok := m.metrics.rollups[r.Key()]; ok {
+		return cur
 	}
+	m.metrics.rollups[r.Key()] = r
+	return r
+}
 
-	msg := format("%s: %s", funcName, format(fmt, i...))
+// updateMetrics updates daily metrics of an endpoint with an event received
+func (m *Manager) updateMetrics(euuid string, e *event.EdrEvent) {
+	m.metrics.Lock()
+	defer m.metrics.Unlock()
 
-	m.Logger.Error(msg)
+	// rollups are made on reception day as event timestamps
+	// may be wrong or events may be received late
+	m.metricsRollup(euuid, time.Now()).Update(e)
 }
 
-// Wait the Manager to Shutdown
-func (m *Manager) Wait() {
+// updateSequenceMetrics accounts events of an endpoint lost or received
+// several times given the sequence number of an event received. It
+// returns true if the event has already been received.
+func (m *Manager) updateSequenceMetrics(euuid string, e *event.EdrEvent) (duplicate bool) {
+	if e.Event.EdrData == nil {
+		return
+	}
+
+	m.metrics.Lock()
+	defer m.metrics.Unlock()
+
+	seq, ok := m.metrics.sequences[euuid]
+	if !ok {
+		seq = api.NewEventSequence()
+		m.metrics.sequences[euuid] = seq
+	}
+
+	lost, dup := seq.Update(e.Event.EdrData.Event.Stream, e.Event.EdrData.Event.Sequence)
+	if lost != 0 || dup != 0 {
+		r := m.metricsRollup(euuid, time.Now())
+		r.Lost += lost
+		r.Duplicates += dup
+	}
+
+	return dup > 0
+}
+
+// updateDroppedMetrics accounts n events of an endpoint dropped by the manager
+func (m *Manager) updateDroppedMetrics(euuid string, n int64) {
+	m.metrics.Lock()
+	defer m.metrics.Unlock()
+
+	m.metricsRollup(euuid, time.Now()).Dropped += n
+}
+
+// FlushMetrics merges in memory metrics rollups into the ones saved in database
+func (m *Manager) FlushMetrics() (err error) {
+	m.metrics.Lock()
+	rollups := m.metrics.rollups
+	m.metrics.rollups = make(map[string]*api.MetricsRollup)
+	m.metrics.Unlock()
+
+	now := time.Now()
+	for _, r := range rollups {
+		saved := r
+		o, err := m.db.Search(&api.MetricsRollup{}, "Endpoint", "=", r.Endpoint).
+			And("Day", "=", r.Day).One()
+
+		switch {
+		case err == nil:
+			saved = o.(*api.MetricsRollup)
+			saved.Merge(r)
+		case !sod.IsNoObjectFound(err):
+			return err
+		}
+
+		saved.UpdateEPS(now)
+		if err = m.db.InsertOrUpdate(saved); err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+// PruneMetrics deletes metrics rollups older than MetricsRetention
+func (m *Manager) PruneMetrics() (err error) {
+	return m.db.Search(&api.MetricsRollup{}, "Day", "<", api.MetricsDay(time.Now().Add(-MetricsRetention))).Delete()
+}
+
+// MetricsRollups returns the metrics rollups between since and until (both
+// included). If aggregate is true, rollups of all endpoints are summed by day.
+func (m *Manager) MetricsRollups(since, until time.Time, endpoints []string, aggregate bool) (rollups []*api.MetricsRollup, err error) {
+	var objs []sod.Object
+
+	// we want to have up to date metrics
+	if err = m.FlushMetrics(); err != nil {
+		return
+	}
+
+	if objs, err = m.db.Search(&api.MetricsRollup{}, "Day", ">=", api.MetricsDay(since)).
+		And("Day", "<=", until).Collect(); err != nil {
+		return
+	}
+
+	filter := make(map[string]bool)
+	for _, euuid := range endpoints {
+		filter[euuid] = true
+	}
+
+	now := time.Now()
+	rollups = make([]*api.MetricsRollup, 0, len(objs))
+	byDay := make(map[time.Time]*api.MetricsRollup)
+	for _, o := range objs {
+		r := o.(*api.MetricsRollup)
+
+		if len(filter) > 0 && !filter[r.Endpoint] {
+			continue
+		}
+
+		if !aggregate {
+			rollups = append(rollups, r)
+			continue
+		}
+
+		if _, ok := byDay[r.Day]; !ok {
+			byDay[r.Day] = api.NewMetricsRollup("", r.Day)
+			rollups = append(rollups, byDay[r.Day])
+		}
+		byDay[r.Day].Merge(r)
+		byDay[r.Day].UpdateEPS(now)
+	}
+
+	sort.SliceStable(rollups, func(i, j int) bool {
+		return rollups[i].Day.Before(rollups[j].Day)
+	})
+
+	return
+}
+
+// updateRuleUsage updates rules and containers usage of an endpoint from a report
+func (m *Manager) updateRuleUsage(endpoint string, report *api.UsageReport) (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.Search(&api.RuleUsage{}, "Endpoint", "=", endpoint).Collect(); err != nil {
+		return
+	}
+
+	// we rely on manager's clock rather than on endpoint's one
+	now := time.Now().UTC()
+	usages := make(map[string]*api.RuleUsage)
+	for _, o := range objs {
+		u := o.(*api.RuleUsage)
+		usages[u.Key()] = u
+	}
+
+	update := func(kind string, hits map[string]int64) {
+		for name, n := range hits {
+			u := &api.RuleUsage{Endpoint: endpoint, Kind: kind, Name: name}
+			if old, ok := usages[u.Key()]; ok {
+				u = old
+			}
+			u.Hits += n
+			if n > 0 {
+				u.LastHit = now
+			}
+			usages[u.Key()] = u
+		}
+	}
+
+	update(api.UsageKindRule, report.Rules)
+	update(api.UsageKindContainer, report.Containers)
+
+	list := make([]*api.RuleUsage, 0, len(usages))
+	for _, u := range usages {
+		list = append(list, u)
+	}
+
+	_, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(list)...)
+	return
+}
+
+// updateAgentErrors updates the error history of an endpoint from a report
+func (m *Manager) updateAgentErrors(endpoint string, report *api.AgentErrorReport) (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.Search(&api.AgentError{}, "Endpoint", "=", endpoint).Collect(); err != nil {
+		return
+	}
+
+	errors := make(map[string]*api.AgentError)
+	for _, o := range objs {
+		e := o.(*api.AgentError)
+		errors[e.Key()] = e
+	}
+
+	list := make([]*api.AgentError, 0, len(report.Errors))
+	for _, rec := range report.Errors {
+		e := &api.AgentError{Endpoint: endpoint, Kind: rec.Kind, Source: rec.Source}
+		if old, ok := errors[e.Key()]; ok {
+			e = old
+		}
+		e.Update(rec)
+		list = append(list, e)
+	}
+
+	_, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(list)...)
+	return
+}
+
+// AgentErrors returns the error history of an endpoint, most recent errors first.
+// Errors can be filtered by kind and by time of last occurrence.
+func (m *Manager) AgentErrors(endpoint, kind string, since time.Time) (out []*api.AgentError, err error) {
+	var objs []sod.Object
+
+	search := m.db.Search(&api.AgentError{}, "Endpoint", "=", endpoint)
+	if kind != "" {
+		search = search.And("Kind", "=", kind)
+	}
+
+	if objs, err = search.Collect(); err != nil {
+		return
+	}
+
+	out = make([]*api.AgentError, 0, len(objs))
+	for _, o := range objs {
+		if e := o.(*api.AgentError); !e.Last.Before(since) {
+			out = append(out, e)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Last.After(out[j].Last) })
+
+	return
+}
+
+// RuleEffectiveness returns fleet wide usage of rules and containers. Rules
+// and containers which did not hit for a period of time are flagged as dead.
+func (m *Manager) RuleEffectiveness(dead time.Duration) (out []*api.RuleEffectiveness, err error) {
+	var objs []sod.Object
+
+	effs := make(map[string]*api.RuleEffectiveness)
+	eff := func(kind, name string) *api.RuleEffectiveness {
+		key := kind + "|" + name
+		if _, ok := effs[key]; !ok {
+			effs[key] = &api.RuleEffectiveness{Kind: kind, Name: name}
+		}
+		return effs[key]
+	}
+
+	// rules and containers never hit must be reported
+	if objs, err = m.db.All(&api.EdrRule{}); err != nil {
+		return
+	}
+	for _, o := range objs {
+		eff(api.UsageKindRule, o.(*api.EdrRule).Name)
+	}
+
+	eff(api.UsageKindContainer, IoCContainerName)
+	m.RLock()
+	for _, c := range m.containers.list {
+		eff(api.UsageKindContainer, c.Name)
+	}
+	m.RUnlock()
+
+	if objs, err = m.db.All(&api.RuleUsage{}); err != nil {
+		return
+	}
+
+	for _, o := range objs {
+		u := o.(*api.RuleUsage)
+		eff(u.Kind, u.Name).Update(u)
+	}
+
+	limit := time.Now().UTC().Add(-dead)
+	out = make([]*api.RuleEffectiveness, 0, len(effs))
+	for _, e := range effs {
+		e.Dead = e.Hits == 0 || e.LastHit.Before(limit)
+		out = append(out, e)
+	}
+
+	// least effective first
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Hits == out[j].Hits {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Hits < out[j].Hits
+	})
+
+	return
+}
+
+// updateRolloutVerdicts updates verdict statistics of the cohort endpoint belongs to
+func (m *Manager) updateRolloutVerdicts(endpt *api.Endpoint, v *api.AlertVerdict) {
+	cohort := m.RulesCohort(endpt)
+
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
+
+	stats := m.rolloutStats.Cohorts[cohort]
+	if v.IsFalsePositive() {
+		stats.FalsePositives++
+	} else {
+		stats.TruePositives++
+	}
+}
+
+// RolloutStats returns comparative statistics of rollout cohorts
+func (m *Manager) RolloutStats() (stats api.RolloutStats, err error) {
+	var endpts []*api.Endpoint
+
+	if endpts, err = m.Endpoints(); err != nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, endpt := range endpts {
+		counts[m.RulesCohort(endpt)]++
+	}
+
+	m.rolloutMutex.Lock()
+	defer m.rolloutMutex.Unlock()
+
+	stats = *m.rolloutStats
+	stats.Rollout = m.rollout
+	stats.Candidate = m.gene.candidate.names
+	stats.Cohorts = make(map[string]*api.CohortStats)
+	for cohort, cs := range m.rolloutStats.Cohorts {
+		c := *cs
+		c.Endpoints = counts[cohort]
+		c.Signatures = make(map[string]uint64)
+		for k, v := range cs.Signatures {
+			c.Signatures[k] = v
+		}
+		stats.Cohorts[cohort] = &c
+	}
+
+	return
+}
+
+// updateSuppressionsCache updates the list of enabled suppressions
+// pushed to endpoints
+func (m *Manager) updateSuppressionsCache() (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.Suppression{}); err != nil {
+		return
+	}
+
+	list := make([]*api.Suppression, 0, len(objs))
+	for _, o := range objs {
+		if s := o.(*api.Suppression); s.Enabled {
+			list = append(list, s)
+		}
+	}
+
+	// sorting to have a stable sha256
+	sort.Slice(list, func(i, j int) bool { return list[i].Key() < list[j].Key() })
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.suppressions.list = list
+	m.suppressions.sha256 = utils.Sha256StringSlice(suppressionKeys(list))
+
+	return
+}
+
+func suppressionKeys(list []*api.Suppression) (keys []string) {
+	keys = make([]string, 0, len(list))
+	for _, s := range list {
+		keys = append(keys, s.Key())
+	}
+	return
+}
+
+// updatePlaybooksCache updates the list of enabled playbooks
+// pushed to endpoints
+func (m *Manager) updatePlaybooksCache() (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.Playbook{}); err != nil {
+		return
+	}
+
+	list := make([]*api.Playbook, 0, len(objs))
+	for _, o := range objs {
+		if p := o.(*api.Playbook); p.Enabled {
+			list = append(list, p)
+		}
+	}
+
+	// playbooks are matched in order by endpoints
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.playbooks.list = list
+	m.playbooks.sha256 = api.PlaybooksSha256(list)
+
+	return
+}
+
+// updateTrustedCache updates the list of enabled trusted
+// processes pushed to endpoints
+func (m *Manager) updateTrustedCache() (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.TrustedProcess{}); err != nil {
+		return
+	}
+
+	list := make([]*api.TrustedProcess, 0, len(objs))
+	for _, o := range objs {
+		if t := o.(*api.TrustedProcess); t.Enabled {
+			list = append(list, t)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.trusted.list = list
+	m.trusted.sha256 = api.TrustedProcessesSha256(list)
+
+	return
+}
+
+// linkIncidentActions links the reversible actions run by a command
+// sent to an endpoint to an open incident
+func (m *Manager) linkIncidentActions(iuuid, euuid string, c *api.EndpointCommand) (err error) {
+	var o sod.Object
+
+	m.incidentsMutex.Lock()
+	defer m.incidentsMutex.Unlock()
+
+	if o, err = m.db.GetByUUID(&api.Incident{}, iuuid); err != nil {
+		return fmt.Errorf("failed to get incident %s: %w", iuuid, err)
+	}
+
+	i := o.(*api.Incident)
+	if i.IsClosed() {
+		return fmt.Errorf("incident %s is closed", iuuid)
+	}
+
+	actions := api.ActionsFromCommand(euuid, c)
+	if len(actions) == 0 {
+		return fmt.Errorf("command %s cannot be reverted", c.Name)
+	}
+
+	i.AddActions(actions...)
+
+	return m.db.InsertOrUpdate(i)
+}
+
+// incidentsCommandCompleted updates incidents with a command completed by an endpoint
+func (m *Manager) incidentsCommandCompleted(c *api.EndpointCommand) (err error) {
+	var objs []sod.Object
+
+	m.incidentsMutex.Lock()
+	defer m.incidentsMutex.Unlock()
+
+	if objs, err = m.db.All(&api.Incident{}); err != nil {
+		return
+	}
+
+	for _, o := range objs {
+		if i := o.(*api.Incident); i.CommandCompleted(c) {
+			if err = m.db.InsertOrUpdate(i); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// restoreIncident closes an incident and sends endpoints the commands
+// reverting the response actions linked to it. As revert is a destructive
+// command, commands are approved within responder session suuid of user.
+func (m *Manager) restoreIncident(user, suuid, iuuid string) (r *api.IncidentRestore, err error) {
+	var o sod.Object
+
+	m.incidentsMutex.Lock()
+	defer m.incidentsMutex.Unlock()
+
+	if o, err = m.db.GetByUUID(&api.Incident{}, iuuid); err != nil {
+		return nil, fmt.Errorf("failed to get incident %s: %w", iuuid, err)
+	}
+
+	i := o.(*api.Incident)
+	i.Close()
+	r = api.NewIncidentRestore(i)
+
+	for euuid, actions := range i.ToRevert() {
+		endpt, ok := m.Endpoint(euuid)
+		if !ok {
+			r.Errors[euuid] = "unknown endpoint"
+			continue
+		}
+
+		// an endpoint runs a single command at a time
+		if endpt.Command != nil && !endpt.Command.Completed {
+			r.Busy = append(r.Busy, euuid)
+			continue
+		}
+
+		cmd := api.NewEndpointCommand()
+		cmd.Name = api.RevertCommand
+		cmd.Timeout = CommandTimeout
+		for _, a := range actions {
+			cmd.Args = append(cmd.Args, a.Spec())
+		}
+
+		if err = cmd.Sign(m.containmentKey, euuid, time.Now().Add(DefaultCommandSignatureValidity)); err != nil {
+			r.Errors[euuid] = fmt.Sprintf("failed to sign command: %s", err)
+			continue
+		}
+
+		if err = m.approveCommand(user, suuid, euuid, cmd); err != nil {
+			r.Errors[euuid] = fmt.Sprintf("failed to approve command: %s", err)
+			continue
+		}
+
+		endpt.Command = cmd
+		if err = m.db.InsertOrUpdate(endpt); err != nil {
+			return
+		}
+
+		for _, a := range actions {
+			a.Status = api.ActionStatusReverting
+			a.RevertCommand = cmd.UUID
+			a.Error = ""
+		}
+		r.Reverting = append(r.Reverting, euuid)
+	}
+
+	sort.Strings(r.Reverting)
+	sort.Strings(r.Busy)
+
+	err = m.db.InsertOrUpdate(i)
+	return
+}
+
+// updateContainersCache updates the list of containers served to endpoints
+func (m *Manager) updateContainersCache() (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.EdrContainer{}); err != nil {
+		return
+	}
+
+	list := make([]*api.EdrContainer, 0, len(objs))
+	for _, o := range objs {
+		list = append(list, o.(*api.EdrContainer))
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.containers.list = list
+	m.containers.sha256 = api.ContainersSha256(list)
+
+	return
+}
+
+// updateGroupsCache updates the list of dynamic groups from database
+func (m *Manager) updateGroupsCache() (err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.EndpointGroup{}); err != nil {
+		return
+	}
+
+	list := make([]*api.EndpointGroup, 0, len(objs))
+	for _, o := range objs {
+		g := o.(*api.EndpointGroup)
+		// compiles group query
+		if err := g.Validate(); err != nil {
+			m.Logger.Errorf("Failed to load dynamic group %s: %s", g.Name, err)
+			continue
+		}
+		list = append(list, g)
+	}
+
+	// groups are sorted so that endpoints' dynamic groups are too
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	m.groups.Lock()
+	defer m.groups.Unlock()
+	m.groups.list = list
+
+	return
+}
+
+// updateDynamicGroups updates the dynamic groups an endpoint belongs to
+// and returns true if they changed
+func (m *Manager) updateDynamicGroups(endpt *api.Endpoint) (changed bool) {
+	m.groups.RLock()
+	defer m.groups.RUnlock()
+
+	groups := make([]string, 0)
+	doc := endpt.Document()
+	for _, g := range m.groups.list {
+		if g.Match(doc) {
+			groups = append(groups, g.Name)
+		}
+	}
+
+	if len(groups) == len(endpt.DynamicGroups) {
+		for i := range groups {
+			if groups[i] != endpt.DynamicGroups[i] {
+				changed = true
+				break
+			}
+		}
+	} else {
+		changed = true
+	}
+
+	endpt.DynamicGroups = groups
+	return
+}
+
+// EvaluateDynamicGroups re-evaluates the dynamic groups of all endpoints
+func (m *Manager) EvaluateDynamicGroups() (err error) {
+	var endpts []*api.Endpoint
+
+	if endpts, err = m.Endpoints(); err != nil {
+		return
+	}
+
+	for _, endpt := range endpts {
+		if m.updateDynamicGroups(endpt) {
+			if err = m.db.InsertOrUpdate(endpt); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// AddVerdict adds an alert verdict and generates the associated
+// suppression proposals if needed. Proposals are not enabled.
+func (m *Manager) AddVerdict(v *api.AlertVerdict, propose bool) (proposals []*api.Suppression, err error) {
+	proposals = make([]*api.Suppression, 0)
+
+	v.FromEvent()
+	v.Timestamp = time.Now().UTC()
+
+	if err = m.db.InsertOrUpdate(v); err != nil {
+		return
+	}
+
+	if endpt, ok := m.Endpoint(v.Endpoint); ok {
+		m.updateRolloutVerdicts(endpt, v)
+	}
+
+	if !propose {
+		return
+	}
+
+	for _, s := range v.Suppressions() {
+		// we skip if a similar suppression already exists
+		if m.db.Search(&api.Suppression{}, "Signature", "=", s.Signature).
+			And("Field", "=", s.Field).
+			And("Value", "=", s.Value).Len() > 0 {
+			continue
+		}
+		proposals = append(proposals, s)
+	}
+
+	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(proposals)...); err != nil {
+		return
+	}
+
+	return
+}
+
+// AddCommand sets a command to be executed on endpoint specified by UUID
+func (m *Manager) AddCommand(uuid string, c *api.EndpointCommand) error {
+	if endpt, ok := m.Endpoint(uuid); ok {
+		endpt.Command = c
+		return m.db.InsertOrUpdate(endpt)
+	}
+	return ErrUnkEndpoint
+}
+
+// GetCommand gets the command set for an endpoint specified by UUID
+func (m *Manager) GetCommand(uuid string) (*api.EndpointCommand, error) {
+	if endpt, ok := m.Endpoint(uuid); ok {
+		// We return the command of an unmutable endpoint struct
+		// so if Command is modified this will not affect Endpoint
+		return endpt.Command, nil
+	}
+	return nil, ErrUnkEndpoint
+}
+
+// Endpoint returns an Endpoint pointer from database
+// Result must be handled with care as any change to the Endpoint
+// might be commited to the database. If an Endpoint needs to be
+// modified but changes don't need to be commited, use Endpoint.Copy()
+// to work on a copy
+func (m *Manager) Endpoint(uuid string) (*api.Endpoint, bool) {
+	if o, err := m.db.GetByUUID(&api.Endpoint{}, uuid); err == nil {
+		// we return copy to endpoints not to modify cached structures
+		return o.(*api.Endpoint), true
+	}
+	return nil, false
+}
+
+// Endpoints returns a slice of Endpoint pointers from database
+// Result must be handled with care as any change to the Endpoint
+// might be commited to the database. If an Endpoint needs to be
+// modified but changes don't need to be commited, use Endpoint.Copy()
+// to work on a copy
+func (m *Manager) Endpoints() (endpoints []*api.Endpoint, err error) {
+	var all []sod.Object
+
+	if all, err = m.db.All(&api.Endpoint{}); err != nil {
+		return
+	}
+
+	endpoints = make([]*api.Endpoint, 0, len(all))
+	for _, o := range all {
+		// we return copy to endpoints not to modify cached structures
+		endpoints = append(endpoints, o.(*api.Endpoint))
+	}
+
+	return
+}
+
+func (m *Manager) ImportRules(directory string) (err error) {
+	engine := engine.NewEngine()
+	engine.SetDumpRaw(true)
+
+	if err = engine.LoadDirectory(directory); err != nil {
+		return
+	}
+
+	rules := make([]*api.EdrRule, 0, engine.Count())
+	for rr := range engine.GetRawRule(".*") {
+		rule := &api.EdrRule{}
+		if err = json.Unmarshal([]byte(rr), &rule); err != nil {
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(rules)...); err != nil {
+		return err
+	}
+
+	// stable and candidate rule sets are rebuilt with imported rules
+	return m.initializeGeneFromDB()
+}
+
+// CreateNewAdminAPIUser creates a new user in the user able to access admin API in database.
+func (m *Manager) CreateNewAdminAPIUser(user *AdminAPIUser) (err error) {
+	if err = m.db.InsertOrUpdate(user); err != nil && !sod.IsUnique(err) {
+		return err
+	} else if sod.IsUnique(err) {
+		err = errors.New("user with same Identifier or Uuid or Key already exists in database")
+		return
+	}
+
+	return
+
+}
+
+// AddEndpoint adds new endpoint to the manager
+func (m *Manager) AddEndpoint(uuid, key string) {
+	m.db.InsertOrUpdate(api.NewEndpoint(uuid, key))
+}
+
+func (m *Manager) AddIoCs(iocs []*ioc.IOC) (err error) {
+
+	// we preprocess to update existing IOCs
+	insert := make([]*ioc.IOC, 0, len(iocs))
+	for _, i := range iocs {
+		// we need to apply transformation before searching otherwise we
+		// might not find some values which have been transformed
+		i.Transform()
+		search := m.db.Search(&ioc.IOC{},
+			"Uuid", "=", i.Uuid)
+		if o, err := search.One(); err == nil {
+			// in order to update existing IOCs
+			i.Initialize(o.UUID())
+		}
+
+		insert = append(insert, i)
+	}
+
+	// Do bulk insertion
+	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(insert)...); err != nil {
+		//wt.Write(admErr(err))
+		return
+	}
+
+	// Add IoCs to sync with endpoints
+	m.iocs.Add(insert...)
+
+	return
+}
+
+// UpdateReducer updates the reducer member of the Manager
+func (m *Manager) UpdateReducer(identifier string, e *event.EdrEvent) {
+	if e.Event.Detection != nil {
+		isigs := e.Event.Detection.Signature.Slice()
+		sigs := make([]string, 0, len(isigs))
+
+		for _, s := range isigs {
+			sigs = append(sigs, s.(string))
+		}
+
+		if len(sigs) > 0 {
+			m.gene.reducer.Update(e.Timestamp(), identifier, sigs)
+		}
+	}
+}
+
+func (m *Manager) logAPIErrorf(fmt string, i ...interface{}) {
+	funcName := "unk.UnknownFunc"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		split := strings.Split(runtime.FuncForPC(pc).Name(), "/")
+		funcName = split[len(split)-1]
+	}
+
+	msg := format("%s: %s", funcName, format(fmt, i...))
+
+	m.Logger.Error(msg)
+}
+
+// Wait the Manager to Shutdown
+func (m *Manager) Wait() {
 	<-m.stop
 }
 
@@ -572,6 +1739,10 @@ func (m *Manager) Shutdown() (lastErr error) {
 		lastErr = err
 	}
 
+	if err := m.FlushMetrics(); err != nil {
+		lastErr = err
+	}
+
 	if err := m.db.Close(); err != nil {
 		lastErr = err
 	}
@@ -579,8 +1750,78 @@ func (m *Manager) Shutdown() (lastErr error) {
 	return
 }
 
+// iocsPruningRoutine periodically removes expired IoCs
+func (m *Manager) iocsPruningRoutine() {
+	for !m.IsDone() {
+		if expired, err := m.iocs.Prune(m.db); err != nil {
+			m.Logger.Errorf("Failed to prune expired IoCs: %s", err)
+		} else if len(expired) > 0 {
+			m.Logger.Infof("Pruned %d expired IoCs", len(expired))
+		}
+		time.Sleep(IoCsPruningInterval)
+	}
+}
+
+// dynamicGroupsRoutine periodically re-evaluates endpoints' dynamic groups
+func (m *Manager) dynamicGroupsRoutine() {
+	for !m.IsDone() {
+		if err := m.EvaluateDynamicGroups(); err != nil {
+			m.Logger.Errorf("Failed to evaluate dynamic groups: %s", err)
+		}
+		time.Sleep(DynamicGroupsInterval)
+	}
+}
+
+// metricsRoutine periodically saves metrics rollups and deletes old ones
+func (m *Manager) metricsRoutine() {
+	for !m.IsDone() {
+		time.Sleep(MetricsFlushInterval)
+		if err := m.FlushMetrics(); err != nil {
+			m.Logger.Errorf("Failed to flush metrics: %s", err)
+		}
+		if err := m.PruneMetrics(); err != nil {
+			m.Logger.Errorf("Failed to prune metrics: %s", err)
+		}
+	}
+}
+
+// archiveRoutine periodically moves old artifacts to cold storage
+func (m *Manager) archiveRoutine() {
+	for !m.IsDone() {
+		if n, err := m.ArchiveArtifacts(); err != nil {
+			m.Logger.Errorf("Failed to archive artifacts: %s", err)
+		} else if n > 0 {
+			m.Logger.Infof("Moved %d artifact bundles to cold storage", n)
+		}
+		time.Sleep(ArchiveInterval)
+	}
+}
+
+// noiseRoutine periodically models alerts noise
+func (m *Manager) noiseRoutine() {
+	for !m.IsDone() {
+		end := time.Now()
+		start := end.Add(-time.Duration(m.Config.Noise.Window) * 24 * time.Hour)
+		if r, err := m.ModelNoise(start, end, m.noiseMinHits()); err != nil {
+			m.Logger.Errorf("Failed to model alerts noise: %s", err)
+		} else {
+			m.Logger.Infof("Alerts noise modeled, %d tuning suggestions made", len(r.Suggestions))
+		}
+		time.Sleep(NoiseInterval)
+	}
+}
+
 // Run starts a new thread spinning the receiver
 func (m *Manager) Run() {
 	m.runEndpointAPI()
 	m.runAdminAPI()
+	go m.iocsPruningRoutine()
+	go m.metricsRoutine()
+	go m.dynamicGroupsRoutine()
+	if m.Config.Archive.Enabled() {
+		go m.archiveRoutine()
+	}
+	if m.Config.Noise.Enabled() {
+		go m.noiseRoutine()
+	}
 }
@@ -8,9 +8,11 @@ import (
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +33,23 @@ import (
 
 const (
 	MaxLimitLogAPI = 10000
+
+	// Default time range of metrics trends
+	DefaultMetricsLast = 30 * 24 * time.Hour
+
+	// Sysmon configuration recommendations
+	DefaultSysmonRecommendationsLast  = 24 * time.Hour
+	DefaultSysmonRecommendationsShare = 0.1
+	MaxSysmonRecommendationsEvents    = 100000
+
+	// Default period after which a rule (or container) not hit is considered dead
+	DefaultRuleDeadAfter = 30 * 24 * time.Hour
+
+	// Default validity of containment release tokens
+	DefaultReleaseTokenValidity = 24 * time.Hour
+	// Default validity of command signatures, privileged commands
+	// not received by endpoints within this period are not run
+	DefaultCommandSignatureValidity = time.Hour
 )
 
 var (
@@ -135,6 +154,24 @@ var (
 	upgrader = websocket.Upgrader{} // use default options
 )
 
+// admAuthUser returns the admin user issuing the request
+func (m *Manager) admAuthUser(rq *http.Request) (*AdminAPIUser, bool) {
+	auth := rq.Header.Get(api.AuthKeyHeader)
+	o, err := m.db.Search(&AdminAPIUser{}, "Key", "=", auth).One()
+	if err == nil {
+		return o.(*AdminAPIUser), true
+	}
+	return nil, false
+}
+
+// admUser returns the identifier of the admin user issuing the request
+func (m *Manager) admUser(rq *http.Request) string {
+	if user, ok := m.admAuthUser(rq); ok {
+		return user.Identifier
+	}
+	return ""
+}
+
 func (m *Manager) adminAuthorizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(wt http.ResponseWriter, rq *http.Request) {
 
@@ -177,10 +214,14 @@ func (m *Manager) admAPIUsers(wt http.ResponseWriter, rq *http.Request) {
 
 	switch rq.Method {
 	case "GET":
-		if users, err := m.db.All(&AdminAPIUser{}); err != nil {
+		if objs, err := m.db.All(&AdminAPIUser{}); err != nil {
 			wt.Write(admErr(err))
 			return
 		} else {
+			users := make([]*AdminAPIUser, 0, len(objs))
+			for _, o := range objs {
+				users = append(users, o.(*AdminAPIUser).withoutTOTP())
+			}
 			wt.Write(admJSONResp(users))
 		}
 	case "PUT":
@@ -256,6 +297,8 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 	var uuid string
 
 	newKey, _ := strconv.ParseBool(rq.URL.Query().Get(api.QpNewKey))
+	newTOTP, _ := strconv.ParseBool(rq.URL.Query().Get(api.QpNewTOTP))
+	code := rq.URL.Query().Get(api.QpTOTP)
 
 	if uuid, err = muxGetVar(rq, "uuuid"); err == nil {
 		if o, err := m.db.Search(&AdminAPIUser{}, "Uuid", "=", uuid).One(); err == nil {
@@ -283,6 +326,19 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 					}
 				}
 
+				if newTOTP {
+					// replacing an existing secret requires a valid code
+					if user.TOTPSecret != "" && !verifyTOTP(user.TOTPSecret, code, time.Now()) {
+						wt.Write(admErr("a valid TOTP code is required to replace user's TOTP secret"))
+						return
+					}
+
+					if user.TOTPSecret, err = newTOTPSecret(); err != nil {
+						wt.Write(admErr(format("failed to generate TOTP secret: %s", err)))
+						return
+					}
+				}
+
 				if new.Key != "" {
 					user.Key = new.Key
 				}
@@ -301,8 +357,12 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 					return
 				}
 			}
-			// return user anyway
-			wt.Write(admJSONResp(user))
+			// return user anyway, TOTP secret is shown only once
+			if rq.Method == "POST" && newTOTP {
+				wt.Write(admJSONResp(user))
+			} else {
+				wt.Write(admJSONResp(user.withoutTOTP()))
+			}
 		} else if sod.IsNoObjectFound(err) {
 			wt.Write(admErr(format("unknown user for uuid: %s", uuid)))
 		} else {
@@ -320,6 +380,7 @@ func (m *Manager) admAPIEndpoints(wt http.ResponseWriter, rq *http.Request) {
 	showKey, _ := strconv.ParseBool(rq.URL.Query().Get(api.QpShowKey))
 	group := rq.URL.Query().Get(api.QpGroup)
 	status := rq.URL.Query().Get(api.QpStatus)
+	tag := rq.URL.Query().Get(api.QpTag)
 	criticality, _ := strconv.ParseInt(rq.URL.Query().Get(api.QpCriticality), 10, 8)
 
 	switch {
@@ -331,13 +392,17 @@ func (m *Manager) admAPIEndpoints(wt http.ResponseWriter, rq *http.Request) {
 			out := make([]*api.Endpoint, 0, len(endpoints))
 			for _, endpt := range endpoints {
 				// filter on group
-				if group != "" && endpt.Group != group {
+				if group != "" && !endpt.InGroup(group) {
 					continue
 				}
 				// filter on status
 				if status != "" && endpt.Status != status {
 					continue
 				}
+				// filter on tags set with tag commands
+				if tag != "" && !endpt.HasTag(tag) {
+					continue
+				}
 				if endpt.Criticality < int(criticality) {
 					continue
 				}
@@ -489,6 +554,13 @@ func (m *Manager) admAPIEndpointConfig(wt http.ResponseWriter, rq *http.Request)
 					return
 				}
 
+				// configuration is fully serialized so the new preset must
+				// be applied for a profile change to take effect
+				if err = c.SwitchProfile(endpt.Config); err != nil {
+					wt.Write(admErr(err))
+					return
+				}
+
 				endpt.Config = &c
 
 				if err = m.db.InsertOrUpdate(endpt); err != nil {
@@ -580,6 +652,26 @@ func (m *Manager) admAPIEndpointCommand(wt http.ResponseWriter, rq *http.Request
 			return
 		}
 
+		// commands are signed so that endpoints can verify privileged ones
+		if err = tmpCmd.Sign(m.containmentKey, euuid, time.Now().Add(DefaultCommandSignatureValidity)); err != nil {
+			wt.Write(admErrorf("failed to sign command: %s", err))
+			return
+		}
+
+		// destructive commands need an approval issued within a responder session
+		if err = m.approveCommand(m.admUser(rq), rq.URL.Query().Get(api.QpSession), euuid, tmpCmd); err != nil {
+			wt.Write(admErrorf("failed to approve command: %s", err))
+			return
+		}
+
+		// reversible actions are tracked to be reverted once incident is closed
+		if iuuid := rq.URL.Query().Get(api.QpIncident); iuuid != "" {
+			if err = m.linkIncidentActions(iuuid, euuid, tmpCmd); err != nil {
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
 		endpt.Command = tmpCmd
 		// save modification
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
@@ -628,17 +720,12 @@ func (m *Manager) admAPIEndpointCommandField(wt http.ResponseWriter, rq *http.Re
 	}
 }
 
-func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
-	var err error
-	var euuid string
-	var start, stop, pivot time.Time
+// admAPIParseTimeRange parses the time range parameters (since, until, last,
+// pivot and delta) of a request. If none is set the range is the last hour.
+func admAPIParseTimeRange(rq *http.Request) (start, stop time.Time, err error) {
+	var pivot time.Time
 	var last, delta time.Duration
-	var skip int64
-
-	// default limit
-	limit := 1000
 
-	logs := make([]*event.EdrEvent, 0)
 	pStart := rq.URL.Query().Get(api.QpSince)
 	pStop := rq.URL.Query().Get(api.QpUntil)
 	pLast := rq.URL.Query().Get(api.QpLast)
@@ -646,68 +733,48 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 	pPivot := rq.URL.Query().Get(api.QpPivot)
 	pDelta := rq.URL.Query().Get(api.QpDelta)
 
-	pLimit := rq.URL.Query().Get(api.QpLimit)
-	pSkip := rq.URL.Query().Get(api.QpSkip)
-
 	now := time.Now()
 
 	// Parsing parameters
 	if pStart != "" {
 		if start, err = admApiParseTime(pStart); err != nil {
-			wt.Write(admErr("Failed to parse start parameter, it must be RFC3339 formated"))
+			err = fmt.Errorf("Failed to parse start parameter, it must be RFC3339 formated")
 			return
 		}
 	}
 
 	if pStop != "" {
 		if stop, err = admApiParseTime(pStop); err != nil {
-			wt.Write(admErr("Failed to parse stop parameter, it must be RFC3339 formated"))
+			err = fmt.Errorf("Failed to parse stop parameter, it must be RFC3339 formated")
 			return
 		}
 	}
 
 	if pLast != "" {
 		if last, err = time.ParseDuration(pLast); err != nil {
-			if n, err := fmt.Sscanf(pLast, "%dd", &last); n != 1 || err != nil {
-				wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
+			if n, err2 := fmt.Sscanf(pLast, "%dd", &last); n != 1 || err2 != nil {
+				err = fmt.Errorf("Failed to parse last parameter, it must be a valid Go time.Duration format")
 				return
 			}
+			err = nil
 			last *= 24 * time.Hour
 		}
 	}
 
 	if pPivot != "" {
 		if pivot, err = admApiParseTime(pPivot); err != nil {
-			wt.Write(admErr("Failed to parse pivot parameter, it must be RFC3339 formated"))
+			err = fmt.Errorf("Failed to parse pivot parameter, it must be RFC3339 formated")
 			return
 		}
 	}
 
 	if pDelta != "" {
 		if delta, err = time.ParseDuration(pDelta); err != nil {
-			wt.Write(admErr("Failed to parse delta parameter, it must be a valid Go time.Duration format"))
+			err = fmt.Errorf("Failed to parse delta parameter, it must be a valid Go time.Duration format")
 			return
 		}
 	}
 
-	if pSkip != "" {
-		if skip, err = strconv.ParseInt(pSkip, 10, 64); err != nil {
-			wt.Write(admErr(format("Failed to parse skip parameter: %s", err)))
-			return
-		}
-	}
-
-	if pLimit != "" {
-		// we don't raise error here on bad conversion
-		if l, err := strconv.Atoi(pLimit); err == nil {
-			if l <= MaxLimitLogAPI {
-				limit = l
-			} else {
-				limit = MaxLimitLogAPI
-			}
-		}
-	}
-
 	// Default settings last hour
 	if pStart == "" && pStop == "" && pPivot == "" && pDelta == "" && pLast == "" {
 		last = time.Hour
@@ -717,7 +784,7 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 	if last != 0 {
 		start = now.Add(-last)
 		stop = now
-		goto searchLogs
+		goto control
 	}
 
 	// 10 min delta if delta is not provided
@@ -735,12 +802,63 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 		stop = now
 	}
 
-searchLogs:
+control:
 	// Controlling parameters
 	if start.After(stop) {
-		wt.Write(admErr("Start date must be before stop date"))
+		err = fmt.Errorf("Start date must be before stop date")
+	}
+
+	return
+}
+
+// admAPIParseLimitSkip parses limit and skip parameters of a request
+func admAPIParseLimitSkip(rq *http.Request) (limit int, skip int64, err error) {
+	// default limit
+	limit = 1000
+
+	pLimit := rq.URL.Query().Get(api.QpLimit)
+	pSkip := rq.URL.Query().Get(api.QpSkip)
+
+	if pSkip != "" {
+		if skip, err = strconv.ParseInt(pSkip, 10, 64); err != nil {
+			err = fmt.Errorf("Failed to parse skip parameter: %s", err)
+			return
+		}
+	}
+
+	if pLimit != "" {
+		// we don't raise error here on bad conversion
+		if l, err := strconv.Atoi(pLimit); err == nil {
+			if l <= MaxLimitLogAPI {
+				limit = l
+			} else {
+				limit = MaxLimitLogAPI
+			}
+		}
+	}
+
+	return
+}
+
+func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid string
+	var start, stop time.Time
+	var limit int
+	var skip int64
+
+	logs := make([]*event.EdrEvent, 0)
+
+	if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if limit, skip, err = admAPIParseLimitSkip(rq); err != nil {
+		wt.Write(admErr(err))
 		return
 	}
+
 	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
 		wt.Write(admErr(err))
 	} else {
@@ -768,6 +886,108 @@ searchLogs:
 	}
 }
 
+func (m *Manager) admAPIDetectionsExport(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var start, stop time.Time
+	var limit int
+	var skip int64
+	var exp *alertExporter
+
+	qformat := rq.URL.Query().Get(api.QpFormat)
+	euuid := rq.URL.Query().Get(api.QpUuid)
+	criticality, _ := strconv.ParseInt(rq.URL.Query().Get(api.QpCriticality), 10, 8)
+
+	filter := exportFilter{
+		group:          rq.URL.Query().Get(api.QpGroup),
+		minCriticality: int(criticality),
+		signature:      rq.URL.Query().Get(api.QpName),
+	}
+
+	if qformat == "" {
+		qformat = ExportFormatCSV
+	}
+
+	if _, ok := exportContentTypes[qformat]; !ok {
+		wt.Write(admErrorf("unknown export format %s", qformat))
+		return
+	}
+
+	if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if limit, skip, err = admAPIParseLimitSkip(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	alerts := make([]*event.EdrEvent, 0)
+	for rawEvent := range m.detectionSearcher.Events(start, stop, euuid, limit, int(skip)) {
+		if e, err := rawEvent.Event(); err != nil {
+			m.logAPIErrorf("failed to decode event: %s", err)
+		} else if filter.match(e) {
+			alerts = append(alerts, e)
+		}
+	}
+
+	// we had an issue doing the search
+	if m.detectionSearcher.Err() != nil {
+		wt.Write(admErr(format("failed to search events: %s", m.detectionSearcher.Err())))
+		return
+	}
+
+	// headers must be set before anything is written
+	wt.Header().Set("Content-Type", exportContentTypes[qformat])
+	wt.Header().Set("Content-Disposition",
+		format(`attachment; filename="detections-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), qformat))
+
+	if exp, err = newAlertExporter(wt, qformat); err != nil {
+		m.logAPIErrorf("failed to create alert exporter: %s", err)
+		return
+	}
+
+	for _, e := range alerts {
+		if err = exp.Write(e); err != nil {
+			m.logAPIErrorf("failed to export alert: %s", err)
+			return
+		}
+	}
+
+	if err = exp.Close(); err != nil {
+		m.logAPIErrorf("failed to export alerts: %s", err)
+	}
+}
+
+func (m *Manager) admAPIEndpointDetectionEvidence(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid, ehash string
+	var start, stop time.Time
+	var bundle *EvidenceBundle
+
+	if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if ehash, err = muxGetVar(rq, "ehash"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if bundle, err = m.EvidenceBundle(euuid, ehash, start, stop); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(bundle))
+}
+
 func (m *Manager) admAPIEndpointReport(wt http.ResponseWriter, rq *http.Request) {
 	var euuid string
 	var err error
@@ -1088,6 +1308,8 @@ func (m *Manager) admAPIEndpointArtifact(wt http.ResponseWriter, rq *http.Reques
 							}
 						}
 						wt.Write(admErr("File not found"))
+					} else if a, ok := m.archivedArtifact(euuid, pguid, ehash); ok && a.Cold {
+						wt.Write(admErr("Artifact moved to cold storage, it must be restored first"))
 					} else {
 						wt.Write(admErr(format("Failed at listing dump directory: %s", err)))
 					}
@@ -1178,6 +1400,106 @@ func (m *Manager) admAPIEndpointSysmonConfig(wt http.ResponseWriter, rq *http.Re
 	}
 }
 
+func (m *Manager) admAPIEndpointSysmonRecommendations(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid string
+	var config *sysmon.Config
+
+	last := DefaultSysmonRecommendationsLast
+	share := DefaultSysmonRecommendationsShare
+
+	if pLast := rq.URL.Query().Get(api.QpLast); pLast != "" {
+		if last, err = time.ParseDuration(pLast); err != nil {
+			wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
+			return
+		}
+	}
+
+	if pShare := rq.URL.Query().Get(api.QpShare); pShare != "" {
+		if share, err = strconv.ParseFloat(pShare, 64); err != nil || share < 0 || share > 1 {
+			wt.Write(admErr("Failed to parse share parameter, it must be a float in [0;1]"))
+			return
+		}
+	}
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	endpt, ok := m.Endpoint(euuid)
+	if !ok {
+		wt.Write(admErr(ErrUnkEndpoint))
+		return
+	}
+
+	if endpt.SystemInfo == nil || endpt.SystemInfo.Sysmon == nil {
+		wt.Write(admErr("Endpoint did not report Sysmon information"))
+		return
+	}
+
+	os := endpt.SystemInfo.OS.Name
+	sversion := endpt.SystemInfo.Sysmon.Config.Version.Schema
+
+	// we compare against the configuration deployed by the manager or
+	// against the default one if there is none
+	if err = m.db.Search(&sysmon.Config{}, "OS", "=", os).
+		And("SchemaVersion", "=", sversion).AssignOne(&config); err != nil {
+		if !sod.IsNoObjectFound(err) {
+			wt.Write(admErr(err))
+			return
+		}
+		if config, err = sysmon.AgnosticConfig(sversion); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+		config.OS = os
+	}
+
+	now := time.Now()
+	r := sysmon.NewRecommender()
+	for rawEvent := range m.eventSearcher.Events(now.Add(-last), now, euuid, MaxSysmonRecommendationsEvents, 0) {
+		e, err := rawEvent.Event()
+		if err != nil {
+			m.logAPIErrorf("failed to decode event: %s", err)
+			continue
+		}
+
+		if e.Channel() != sysmon.Channel {
+			continue
+		}
+
+		id := int(e.EventID())
+		value := ""
+		if rf, ok := sysmon.RecommendFields[id]; ok {
+			value, _ = e.GetString(engine.Path("/Event/EventData/" + rf.Field))
+		}
+		r.Add(id, value, e.IsDetection())
+	}
+
+	if m.eventSearcher.Err() != nil {
+		wt.Write(admErr(format("failed to search events: %s", m.eventSearcher.Err())))
+		return
+	}
+
+	recs := &api.SysmonRecommendations{
+		Events:          r.Total(),
+		Recommendations: r.Recommend(share),
+	}
+
+	if recs.Config, err = config.Apply(recs.Recommendations); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if recs.Diff, err = config.Diff(recs.Config); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(recs))
+}
+
 func (m *Manager) admAPIEndpointToolMgmt(toolName string, wt http.ResponseWriter, rq *http.Request) {
 	var err error
 	var os string
@@ -1290,6 +1612,60 @@ func (m *Manager) admAPIStats(wt http.ResponseWriter, rq *http.Request) {
 	}
 }
 
+func (m *Manager) admAPIMetrics(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var since, until time.Time
+	var rollups []*api.MetricsRollup
+
+	query := rq.URL.Query()
+	euuid := query.Get(api.QpUuid)
+	group := query.Get(api.QpGroup)
+	aggregate, _ := strconv.ParseBool(query.Get(api.QpAggregate))
+
+	// trends are computed over the last month by default
+	if query.Get(api.QpSince) == "" && query.Get(api.QpUntil) == "" &&
+		query.Get(api.QpLast) == "" && query.Get(api.QpPivot) == "" && query.Get(api.QpDelta) == "" {
+		until = time.Now()
+		since = until.Add(-DefaultMetricsLast)
+	} else if since, until, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	endpoints := make([]string, 0)
+	if euuid != "" {
+		endpoints = append(endpoints, euuid)
+	}
+
+	if group != "" {
+		var endpts []*api.Endpoint
+
+		if endpts, err = m.Endpoints(); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, endpt := range endpts {
+			if endpt.InGroup(group) && (euuid == "" || endpt.Uuid == euuid) {
+				endpoints = append(endpoints, endpt.Uuid)
+			}
+		}
+
+		// no endpoint in group
+		if len(endpoints) == 0 {
+			wt.Write(admJSONResp(make([]*api.MetricsRollup, 0)))
+			return
+		}
+	}
+
+	if rollups, err = m.MetricsRollups(since, until, endpoints, aggregate); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(rollups))
+}
+
 func (m *Manager) admAPIIocs(wt http.ResponseWriter, rq *http.Request) {
 
 	source := rq.URL.Query().Get(api.QpSource)
@@ -1499,6 +1875,1237 @@ func (m *Manager) admAPIRules(wt http.ResponseWriter, rq *http.Request) {
 
 }
 
+func (m *Manager) admAPICandidateRules(wt http.ResponseWriter, rq *http.Request) {
+
+	name := rq.URL.Query().Get(api.QpName)
+	update, _ := strconv.ParseBool(rq.URL.Query().Get(api.QpUpdate))
+
+	switch rq.Method {
+	case "GET":
+
+		if name == "" {
+			name = ".*"
+		}
+
+		if objs, err := m.db.Search(&api.EdrCandidateRule{}, "Name", "~=", name).Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "DELETE":
+
+		search := m.db.Search(&api.EdrCandidateRule{}, "Name", "=", name)
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			if err := search.Delete(); err != nil {
+				wt.Write(admErr(err))
+			} else if err := m.initializeCandidateFromDB(); err != nil {
+				wt.Write(admErr(err))
+			} else {
+				wt.Write(admJSONResp(objs))
+			}
+		}
+
+	case "POST":
+		defer rq.Body.Close()
+
+		var rules []*api.EdrCandidateRule
+
+		dec := json.NewDecoder(rq.Body)
+		if err := dec.Decode(&rules); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, rule := range rules {
+			// we verify that we can compile rule
+			if _, err := rule.Compile(engine.NewEngine()); err != nil {
+				wt.Write(admErr(err))
+				return
+			}
+
+			o, err := m.db.Search(&api.EdrCandidateRule{}, "Name", "=", rule.Name).One()
+			switch {
+			case err == nil:
+				if !update {
+					wt.Write(admErrorf(`candidate rule %s already exist, use update URL parameter to force update`, rule.Name))
+					return
+				}
+				rule.Initialize(o.UUID())
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(rules)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if err := m.initializeCandidateFromDB(); err != nil {
+			wt.Write(admErrorf("failed to re-initialize candidate rules due to error: %s", err))
+			return
+		}
+
+		wt.Write(admJSONResp(rules))
+	}
+}
+
+// admAPIPromoteRules promotes candidate rules to stable
+func (m *Manager) admAPIPromoteRules(wt http.ResponseWriter, rq *http.Request) {
+	search := m.db.Search(&api.EdrCandidateRule{}, "Name", "~=", ".*")
+	candidates, err := search.Collect()
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	promoted := make([]*api.EdrRule, 0, len(candidates))
+	for _, o := range candidates {
+		rule := &api.EdrRule{Rule: o.(*api.EdrCandidateRule).Rule}
+		o, err := m.db.Search(&api.EdrRule{}, "Name", "=", rule.Name).One()
+		switch {
+		case err == nil:
+			rule.Initialize(o.UUID())
+		case !sod.IsNoObjectFound(err):
+			wt.Write(admErr(err))
+			return
+		}
+		promoted = append(promoted, rule)
+	}
+
+	if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(promoted)...); err != nil {
+		wt.Write(admErrorf("partial promotion due to error: %s", err))
+		return
+	}
+
+	if err := search.Delete(); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if err := m.initializeGeneFromDB(); err != nil {
+		wt.Write(admErrorf("failed to re-initialize engine due to error: %s", err))
+		return
+	}
+
+	wt.Write(admJSONResp(promoted))
+}
+
+func (m *Manager) admAPIRulesRollout(wt http.ResponseWriter, rq *http.Request) {
+	switch rq.Method {
+	case "POST":
+		rollout := &api.RulesRollout{}
+
+		if err := readPostAsJSON(rq, rollout); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if err := m.UpdateRulesRollout(rollout); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+	}
+
+	if stats, err := m.RolloutStats(); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(stats))
+	}
+}
+
+func (m *Manager) admAPIVerdicts(wt http.ResponseWriter, rq *http.Request) {
+
+	hash := rq.URL.Query().Get(api.QpHash)
+	euuid := rq.URL.Query().Get(api.QpUuid)
+	propose, _ := strconv.ParseBool(rq.URL.Query().Get(api.QpPropose))
+
+	switch rq.Method {
+	case "GET":
+		var search *sod.Search
+
+		switch {
+		case hash != "":
+			search = m.db.Search(&api.AlertVerdict{}, "EventHash", "=", hash)
+		case euuid != "":
+			search = m.db.Search(&api.AlertVerdict{}, "Endpoint", "=", euuid)
+		default:
+			search = m.db.Search(&api.AlertVerdict{}, "EventHash", "~=", ".*")
+		}
+
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		verdict := &api.AlertVerdict{}
+
+		if err := readPostAsJSON(rq, verdict); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if proposals, err := m.AddVerdict(verdict, propose); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(struct {
+				Verdict   *api.AlertVerdict  `json:"verdict"`
+				Proposals []*api.Suppression `json:"proposals"`
+			}{verdict, proposals}))
+		}
+	}
+}
+
+func (m *Manager) admAPISuppressions(wt http.ResponseWriter, rq *http.Request) {
+
+	signature := rq.URL.Query().Get(api.QpName)
+	value := rq.URL.Query().Get(api.QpValue)
+
+	switch rq.Method {
+	case "GET":
+
+		if objs, err := m.db.All(&api.Suppression{}); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		var suppressions []*api.Suppression
+
+		if err := readPostAsJSON(rq, &suppressions); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, s := range suppressions {
+			// suppressions are updated if they already exist
+			o, err := m.db.Search(&api.Suppression{}, "Signature", "=", s.Signature).
+				And("Field", "=", s.Field).
+				And("Value", "=", s.Value).One()
+			switch {
+			case err == nil:
+				s.Initialize(o.UUID())
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(suppressions)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if err := m.updateSuppressionsCache(); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		wt.Write(admJSONResp(suppressions))
+
+	case "DELETE":
+		if signature == "" {
+			wt.Write(admErrorf("%s parameter is mandatory", api.QpName))
+			return
+		}
+
+		search := m.db.Search(&api.Suppression{}, "Signature", "=", signature)
+		if value != "" {
+			search = search.And("Value", "=", value)
+		}
+
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := search.Delete(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.updateSuppressionsCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+	}
+}
+
+func (m *Manager) admAPINoise(wt http.ResponseWriter, rq *http.Request) {
+	switch rq.Method {
+	case "GET":
+		if r, err := m.NoiseReport(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(r))
+		}
+
+	case "POST":
+		var start, stop time.Time
+		var err error
+
+		query := rq.URL.Query()
+		if query.Get(api.QpSince) == "" && query.Get(api.QpLast) == "" && query.Get(api.QpPivot) == "" {
+			// default to the configured learning window
+			window := m.Config.Noise.Window
+			if window <= 0 {
+				window = DefaultNoiseWindow
+			}
+			stop = time.Now()
+			start = stop.Add(-time.Duration(window) * 24 * time.Hour)
+		} else if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if r, err := m.ModelNoise(start, stop, m.noiseMinHits()); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(r))
+		}
+	}
+}
+
+func (m *Manager) admAPINoiseAccept(wt http.ResponseWriter, rq *http.Request) {
+	var uuids []string
+
+	if err := readPostAsJSON(rq, &uuids); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if suppressions, err := m.AcceptNoiseSuggestions(uuids...); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(suppressions))
+	}
+}
+
+func (m *Manager) admAPICanaries(wt http.ResponseWriter, rq *http.Request) {
+	var start, stop time.Time
+	var err error
+
+	query := rq.URL.Query()
+	if query.Get(api.QpSince) == "" && query.Get(api.QpLast) == "" && query.Get(api.QpPivot) == "" {
+		stop = time.Now()
+		start = stop.Add(-DefaultCanaryWindow * 24 * time.Hour)
+	} else if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if r, err := m.CanaryReport(start, stop); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(r))
+	}
+}
+
+// admAPIContainer returns a container given its name
+func (m *Manager) admAPIContainer(name string) (c *api.EdrContainer, err error) {
+	var o sod.Object
+
+	if o, err = m.db.Search(&api.EdrContainer{}, "Name", "=", name).One(); err != nil {
+		return
+	}
+
+	return o.(*api.EdrContainer), nil
+}
+
+func (m *Manager) admAPIContainers(wt http.ResponseWriter, rq *http.Request) {
+
+	name := rq.URL.Query().Get(api.QpName)
+	user := m.admUser(rq)
+
+	switch rq.Method {
+	case "GET":
+		var objs []sod.Object
+		var err error
+
+		if name == "" {
+			objs, err = m.db.All(&api.EdrContainer{})
+		} else {
+			objs, err = m.db.Search(&api.EdrContainer{}, "Name", "=", name).Collect()
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		var containers []*api.EdrContainer
+
+		if err := readPostAsJSON(rq, &containers); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		changes := make([]*api.ContainerChange, 0, len(containers))
+		for _, c := range containers {
+			if c.Name == IoCContainerName {
+				wt.Write(admErrorf("container %s is reserved, use IoC API", c.Name))
+				return
+			}
+
+			c.Update()
+			change := api.NewContainerChange(c, user, "create")
+			change.Added = c.Entries
+
+			// containers are replaced if they already exist
+			old, err := m.admAPIContainer(c.Name)
+			switch {
+			case err == nil:
+				c.Initialize(old.UUID())
+				change.Action = "replace"
+				change.Added, change.Removed = api.DiffEntries(old.Entries, c.Entries)
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+
+			changes = append(changes, change)
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(containers)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(changes)...); err != nil {
+			m.logAPIErrorf("failed to save containers history: %s", err)
+		}
+
+		if err := m.updateContainersCache(); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		wt.Write(admJSONResp(containers))
+
+	case "DELETE":
+		if name == "" {
+			wt.Write(admErrorf("%s parameter is mandatory", api.QpName))
+			return
+		}
+
+		c, err := m.admAPIContainer(name)
+		if err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		change := api.NewContainerChange(c, user, "delete")
+		change.Removed = c.Entries
+		change.Sha256 = ""
+
+		if err := m.db.Delete(c); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.db.InsertOrUpdate(change); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.updateContainersCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(c))
+		}
+	}
+}
+
+func (m *Manager) admAPIContainersEdit(wt http.ResponseWriter, rq *http.Request) {
+	var edit api.ContainerEdit
+	var c *api.EdrContainer
+	var err error
+
+	if err = readPostAsJSON(rq, &edit); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if c, err = m.admAPIContainer(edit.Name); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	change := api.NewContainerChange(c, m.admUser(rq), "edit")
+	change.Comment = edit.Comment
+	if change.Added, change.Removed, err = c.Edit(edit.Add, edit.Remove); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+	change.Sha256 = c.Sha256
+
+	if err = m.db.InsertOrUpdate(c); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if err = m.db.InsertOrUpdate(change); err != nil {
+		m.logAPIErrorf("failed to save container history: %s", err)
+	}
+
+	if err = m.updateContainersCache(); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(change))
+}
+
+func (m *Manager) admAPIPlaybooks(wt http.ResponseWriter, rq *http.Request) {
+
+	name := rq.URL.Query().Get(api.QpName)
+
+	switch rq.Method {
+	case "GET":
+		var objs []sod.Object
+		var err error
+
+		if name == "" {
+			objs, err = m.db.All(&api.Playbook{})
+		} else {
+			objs, err = m.db.Search(&api.Playbook{}, "Name", "=", name).Collect()
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		var playbooks []*api.Playbook
+
+		if err := readPostAsJSON(rq, &playbooks); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, p := range playbooks {
+			// playbooks cannot be approved within a responder session
+			if p.Destructive() && m.Config.Responder.Enforce {
+				wt.Write(admErrorf("playbook %s: destructive steps are refused when responder sessions are enforced", p.Name))
+				return
+			}
+
+			// playbooks are updated if they already exist
+			o, err := m.db.Search(&api.Playbook{}, "Name", "=", p.Name).One()
+			switch {
+			case err == nil:
+				p.Initialize(o.UUID())
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(playbooks)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if err := m.updatePlaybooksCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(playbooks))
+		}
+
+	case "DELETE":
+		if name == "" {
+			wt.Write(admErrorf("%s parameter is mandatory", api.QpName))
+			return
+		}
+
+		search := m.db.Search(&api.Playbook{}, "Name", "=", name)
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := search.Delete(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.updatePlaybooksCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+	}
+}
+
+func (m *Manager) admAPITrusted(wt http.ResponseWriter, rq *http.Request) {
+
+	name := rq.URL.Query().Get(api.QpName)
+
+	switch rq.Method {
+	case "GET":
+		var objs []sod.Object
+		var err error
+
+		if name == "" {
+			objs, err = m.db.All(&api.TrustedProcess{})
+		} else {
+			objs, err = m.db.Search(&api.TrustedProcess{}, "Name", "=", name).Collect()
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		var trusted []*api.TrustedProcess
+
+		if err := readPostAsJSON(rq, &trusted); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, t := range trusted {
+			// trusted processes are updated if they already exist
+			o, err := m.db.Search(&api.TrustedProcess{}, "Name", "=", t.Name).One()
+			switch {
+			case err == nil:
+				t.Initialize(o.UUID())
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(trusted)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if err := m.updateTrustedCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(trusted))
+		}
+
+	case "DELETE":
+		if name == "" {
+			wt.Write(admErrorf("%s parameter is mandatory", api.QpName))
+			return
+		}
+
+		search := m.db.Search(&api.TrustedProcess{}, "Name", "=", name)
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := search.Delete(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.updateTrustedCache(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+	}
+}
+
+func (m *Manager) admAPIPlaybookRuns(wt http.ResponseWriter, rq *http.Request) {
+	var since time.Time
+	var limit int
+	var err error
+
+	name := rq.URL.Query().Get(api.QpName)
+	euuid := rq.URL.Query().Get(api.QpUuid)
+
+	if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+		if since, err = time.Parse(time.RFC3339, pSince); err != nil {
+			wt.Write(admErrorf("failed to parse %s parameter: %s", api.QpSince, err))
+			return
+		}
+	}
+
+	if limit, _, err = admAPIParseLimitSkip(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	search := m.db.Search(&api.PlaybookRun{}, "Start", ">=", since)
+	if name != "" {
+		search = search.And("Playbook", "=", name)
+	}
+	if euuid != "" {
+		search = search.And("EndpointUUID", "=", euuid)
+	}
+
+	objs, err := search.Collect()
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	// most recent runs first
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].(*api.PlaybookRun).Start.After(objs[j].(*api.PlaybookRun).Start)
+	})
+
+	if limit > 0 && len(objs) > limit {
+		objs = objs[:limit]
+	}
+
+	wt.Write(admJSONResp(objs))
+}
+
+func (m *Manager) admAPIIncidents(wt http.ResponseWriter, rq *http.Request) {
+
+	switch rq.Method {
+	case "GET":
+		var objs []sod.Object
+		var err error
+
+		if status := rq.URL.Query().Get(api.QpStatus); status == "" {
+			objs, err = m.db.All(&api.Incident{})
+		} else {
+			objs, err = m.db.Search(&api.Incident{}, "Status", "=", status).Collect()
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// most recent incidents first
+		sort.Slice(objs, func(i, j int) bool {
+			return objs[i].(*api.Incident).Created.After(objs[j].(*api.Incident).Created)
+		})
+
+		wt.Write(admJSONResp(objs))
+
+	case "POST":
+		var i api.Incident
+
+		if err := readPostAsJSON(rq, &i); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// only title and description can be set at creation
+		incident := api.NewIncident(i.Title)
+		incident.Description = i.Description
+
+		if err := m.db.InsertOrUpdate(incident); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(incident))
+		}
+	}
+}
+
+func (m *Manager) admAPIIncident(wt http.ResponseWriter, rq *http.Request) {
+	var iuuid string
+	var o sod.Object
+	var err error
+
+	if iuuid, err = muxGetVar(rq, "iuuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	m.incidentsMutex.Lock()
+	defer m.incidentsMutex.Unlock()
+
+	if o, err = m.db.GetByUUID(&api.Incident{}, iuuid); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	incident := o.(*api.Incident)
+
+	switch rq.Method {
+	case "GET":
+		wt.Write(admJSONResp(incident))
+
+	case "POST":
+		var i api.Incident
+
+		if err = readPostAsJSON(rq, &i); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// actions are only modified by commands
+		if i.Title != "" {
+			incident.Title = i.Title
+		}
+		if i.Description != "" {
+			incident.Description = i.Description
+		}
+
+		switch i.Status {
+		case api.IncidentClosed:
+			incident.Close()
+		case api.IncidentOpen:
+			incident.Status = api.IncidentOpen
+			incident.Closed = time.Time{}
+		}
+
+		if err = m.db.InsertOrUpdate(incident); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(incident))
+		}
+
+	case "DELETE":
+		if err = m.db.Delete(incident); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(incident))
+		}
+	}
+}
+
+func (m *Manager) admAPIIncidentRestore(wt http.ResponseWriter, rq *http.Request) {
+	var iuuid string
+	var err error
+
+	if iuuid, err = muxGetVar(rq, "iuuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if r, err := m.restoreIncident(m.admUser(rq), rq.URL.Query().Get(api.QpSession), iuuid); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(r))
+	}
+}
+
+// admAPIParseArchiveFilter parses the parameters selecting artifact bundles
+// in cold storage index
+func (m *Manager) admAPIParseArchiveFilter(rq *http.Request) (f *archiveFilter, err error) {
+	f = &archiveFilter{endpoints: make(map[string]bool)}
+
+	if euuid := rq.URL.Query().Get(api.QpUuid); euuid != "" {
+		f.endpoints[euuid] = true
+	}
+
+	f.hash = rq.URL.Query().Get(api.QpHash)
+
+	// bundles of the endpoints involved in an incident
+	if iuuid := rq.URL.Query().Get(api.QpIncident); iuuid != "" {
+		var o sod.Object
+
+		if o, err = m.db.GetByUUID(&api.Incident{}, iuuid); err != nil {
+			return nil, fmt.Errorf("unknown incident %s: %w", iuuid, err)
+		}
+
+		for _, a := range o.(*api.Incident).Actions {
+			f.endpoints[a.EndpointUUID] = true
+		}
+	}
+
+	if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+		if f.since, err = admApiParseTime(pSince); err != nil {
+			return nil, fmt.Errorf("failed to parse %s parameter: %w", api.QpSince, err)
+		}
+	}
+
+	if pUntil := rq.URL.Query().Get(api.QpUntil); pUntil != "" {
+		if f.until, err = admApiParseTime(pUntil); err != nil {
+			return nil, fmt.Errorf("failed to parse %s parameter: %w", api.QpUntil, err)
+		}
+	}
+
+	return
+}
+
+func (m *Manager) admAPIArtifactsArchive(wt http.ResponseWriter, rq *http.Request) {
+	f, err := m.admAPIParseArchiveFilter(rq)
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if archived, err := m.archivedArtifacts(f, false); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(archived))
+	}
+}
+
+func (m *Manager) admAPIArtifactsRestore(wt http.ResponseWriter, rq *http.Request) {
+	f, err := m.admAPIParseArchiveFilter(rq)
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	// we don't want to restore the whole cold storage by mistake
+	if len(f.endpoints) == 0 && f.hash == "" {
+		wt.Write(admErrorf("one of %s, %s or %s parameter is mandatory", api.QpUuid, api.QpHash, api.QpIncident))
+		return
+	}
+
+	if restored, err := m.restoreArtifacts(f); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(restored))
+	}
+}
+
+func (m *Manager) admAPIContainersHistory(wt http.ResponseWriter, rq *http.Request) {
+	var since, until time.Time
+	var limit int
+	var err error
+
+	name := rq.URL.Query().Get(api.QpName)
+
+	until = time.Now()
+	if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+		if since, err = time.Parse(time.RFC3339, pSince); err != nil {
+			wt.Write(admErrorf("failed to parse %s parameter: %s", api.QpSince, err))
+			return
+		}
+	}
+
+	if limit, _, err = admAPIParseLimitSkip(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	search := m.db.Search(&api.ContainerChange{}, "Timestamp", ">=", since).
+		And("Timestamp", "<=", until)
+	if name != "" {
+		search = search.And("Container", "=", name)
+	}
+
+	objs, err := search.Collect()
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	// most recent changes first
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].(*api.ContainerChange).Timestamp.After(objs[j].(*api.ContainerChange).Timestamp)
+	})
+
+	if limit > 0 && len(objs) > limit {
+		objs = objs[:limit]
+	}
+
+	wt.Write(admJSONResp(objs))
+}
+
+func (m *Manager) admAPIGroups(wt http.ResponseWriter, rq *http.Request) {
+
+	name := rq.URL.Query().Get(api.QpName)
+
+	switch rq.Method {
+	case "GET":
+		var objs []sod.Object
+		var err error
+
+		if name == "" {
+			objs, err = m.db.All(&api.EndpointGroup{})
+		} else {
+			objs, err = m.db.Search(&api.EndpointGroup{}, "Name", "=", name).Collect()
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "POST":
+		var groups []*api.EndpointGroup
+
+		if err := readPostAsJSON(rq, &groups); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		for _, g := range groups {
+			// groups are updated if they already exist
+			o, err := m.db.Search(&api.EndpointGroup{}, "Name", "=", g.Name).One()
+			switch {
+			case err == nil:
+				g.Initialize(o.UUID())
+			case !sod.IsNoObjectFound(err):
+				wt.Write(admErr(err))
+				return
+			}
+		}
+
+		if _, err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(groups)...); err != nil {
+			wt.Write(admErrorf("partial insert/update due to error: %s", err))
+			return
+		}
+
+		if err := m.updateGroupsCache(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.EvaluateDynamicGroups(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(groups))
+		}
+
+	case "DELETE":
+		if name == "" {
+			wt.Write(admErrorf("%s parameter is mandatory", api.QpName))
+			return
+		}
+
+		search := m.db.Search(&api.EndpointGroup{}, "Name", "=", name)
+		if objs, err := search.Collect(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := search.Delete(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.updateGroupsCache(); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.EvaluateDynamicGroups(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+	}
+}
+
+func (m *Manager) admAPIRulesEffectiveness(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var effs []*api.RuleEffectiveness
+
+	last := DefaultRuleDeadAfter
+	kind := rq.URL.Query().Get(api.QpType)
+	dead := rq.URL.Query().Get(api.QpDead) == "true"
+
+	if pLast := rq.URL.Query().Get(api.QpLast); pLast != "" {
+		if last, err = time.ParseDuration(pLast); err != nil {
+			wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
+			return
+		}
+	}
+
+	if effs, err = m.RuleEffectiveness(last); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	out := make([]*api.RuleEffectiveness, 0, len(effs))
+	for _, e := range effs {
+		if (kind == "" || e.Kind == kind) && (!dead || e.Dead) {
+			out = append(out, e)
+		}
+	}
+
+	wt.Write(admJSONResp(out))
+}
+
+func (m *Manager) admAPIRulesDiff(wt http.ResponseWriter, rq *http.Request) {
+	from := rq.URL.Query().Get(api.QpFrom)
+	to := rq.URL.Query().Get(api.QpTo)
+
+	if from == "" || to == "" {
+		wt.Write(admErrorf("%s and %s parameters are mandatory", api.QpFrom, api.QpTo))
+		return
+	}
+
+	if diff, err := m.RulesDiff(from, to); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(diff))
+	}
+}
+
+func (m *Manager) admAPIRuleHits(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var name string
+	var start, stop time.Time
+
+	query := rq.URL.Query()
+	bucket := DefaultRuleHitsBucket
+
+	if name, err = muxGetVar(rq, "name"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if query.Get(api.QpSince) == "" && query.Get(api.QpLast) == "" && query.Get(api.QpPivot) == "" {
+		stop = time.Now()
+		start = stop.Add(-DefaultRuleHitsWindow * 24 * time.Hour)
+	} else if start, stop, err = admAPIParseTimeRange(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if pBucket := query.Get(api.QpBucket); pBucket != "" {
+		if bucket, err = time.ParseDuration(pBucket); err != nil {
+			wt.Write(admErrorf("failed to parse %s parameter, it must be a valid Go time.Duration format", api.QpBucket))
+			return
+		}
+	}
+
+	if h, err := m.RuleHits(name, start, stop, bucket); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(h))
+	}
+}
+
+func (m *Manager) admAPIEndpointTap(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid string
+	var since time.Time
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if _, ok := m.Endpoint(euuid); !ok {
+		wt.Write(admErr(ErrUnkEndpoint))
+		return
+	}
+
+	switch rq.Method {
+	case "GET":
+		if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+			if since, err = time.Parse(time.RFC3339Nano, pSince); err != nil {
+				wt.Write(admErrorf("failed to parse %s parameter: %s", api.QpSince, err))
+				return
+			}
+		}
+		wt.Write(admJSONResp(m.eventTap.Get(euuid, since)))
+	case "DELETE":
+		m.eventTap.Clear(euuid)
+		wt.Write(admJSONResp(nil))
+	}
+}
+
+func (m *Manager) admAPIContainmentKey(wt http.ResponseWriter, rq *http.Request) {
+	wt.Write(admJSONResp(m.containmentKey.PublicKey()))
+}
+
+func (m *Manager) admAPIEndpointReleaseToken(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid, token string
+
+	validity := DefaultReleaseTokenValidity
+
+	if pValidity := rq.URL.Query().Get(api.QpValidity); pValidity != "" {
+		if validity, err = time.ParseDuration(pValidity); err != nil || validity <= 0 {
+			wt.Write(admErr("Failed to parse validity parameter, it must be a valid positive Go time.Duration"))
+			return
+		}
+	}
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if _, ok := m.Endpoint(euuid); !ok {
+		wt.Write(admErr(ErrUnkEndpoint))
+		return
+	}
+
+	t := api.ReleaseToken{
+		Endpoint: euuid,
+		Expires:  time.Now().UTC().Add(validity),
+	}
+
+	if token, err = t.Sign(m.containmentKey); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(token))
+}
+
+// admAPIResponderSessions lists and opens responder sessions
+func (m *Manager) admAPIResponderSessions(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+
+	switch rq.Method {
+	case "GET":
+		var since time.Time
+		var sessions []*api.ResponderSession
+
+		if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+			if since, err = admApiParseTime(pSince); err != nil {
+				wt.Write(admErr(format("Failed to parse since parameter: %s", err)))
+				return
+			}
+		}
+
+		if sessions, err = m.ResponderSessions(since); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		wt.Write(admJSONResp(sessions))
+
+	case "POST":
+		var r ResponderSessionRequest
+		var s *api.ResponderSession
+
+		validity := DefaultResponderSessionValidity
+
+		if pValidity := rq.URL.Query().Get(api.QpValidity); pValidity != "" {
+			if validity, err = time.ParseDuration(pValidity); err != nil || validity <= 0 {
+				wt.Write(admErr("Failed to parse validity parameter, it must be a valid positive Go time.Duration"))
+				return
+			}
+		}
+
+		if err = readPostAsJSON(rq, &r); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		user, ok := m.admAuthUser(rq)
+		if !ok {
+			wt.Write(admErr("unknown user"))
+			return
+		}
+
+		if s, err = m.OpenResponderSession(user, &r, validity); err != nil {
+			m.Logger.Warnf("user %s failed to open responder session: %s", user.Identifier, err)
+			wt.Write(admErr(err))
+			return
+		}
+
+		m.Logger.Infof("user %s opened responder session %s on %d endpoint(s) until %s: %s",
+			s.User, s.Uuid, len(s.Endpoints), s.Expires, s.Reason)
+		wt.Write(admJSONResp(s))
+	}
+}
+
+// admAPIEndpointErrors serves the error history of an endpoint
+func (m *Manager) admAPIEndpointErrors(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var euuid string
+	var since time.Time
+	var errors []*api.AgentError
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if _, ok := m.Endpoint(euuid); !ok {
+		wt.Write(admErr(ErrUnkEndpoint))
+		return
+	}
+
+	if pSince := rq.URL.Query().Get(api.QpSince); pSince != "" {
+		if since, err = admApiParseTime(pSince); err != nil {
+			wt.Write(admErr(format("Failed to parse since parameter: %s", err)))
+			return
+		}
+	}
+
+	if errors, err = m.AgentErrors(euuid, rq.URL.Query().Get(api.QpKind), since); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	wt.Write(admJSONResp(errors))
+}
+
 func (m *Manager) wsHandleControlMessage(c *websocket.Conn) {
 	for {
 		if _, _, err := c.NextReader(); err != nil {
@@ -1592,20 +3199,52 @@ func (m *Manager) runAdminAPI() {
 		rt.HandleFunc(api.AdmAPIEndpointReportArchivePath, m.admAPIEndpointReportArchive).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointLogsPath, m.admAPIEndpointLogs).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointDetectionsPath, m.admAPIEndpointLogs).Methods("GET")
+		rt.HandleFunc(api.AdmAPIDetectionsExportPath, m.admAPIDetectionsExport).Methods("GET")
+		rt.HandleFunc(api.AdmAPIEndpointDetectionEvidencePath, m.admAPIEndpointDetectionEvidence).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointsArtifactsPath, m.admAPIArtifacts).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointArtifacts, m.admAPIEndpointArtifacts).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointArtifact, m.admAPIEndpointArtifact).Methods("GET")
+		rt.HandleFunc(api.AdmAPIArtifactsArchivePath, m.admAPIArtifactsArchive).Methods("GET")
+		rt.HandleFunc(api.AdmAPIArtifactsRestorePath, m.admAPIArtifactsRestore).Methods("POST")
+		rt.HandleFunc(api.AdmAPIEndpointSysmonRecommendationsPath, m.admAPIEndpointSysmonRecommendations).Methods("GET")
+		rt.HandleFunc(api.AdmAPIEndpointReleaseTokenPath, m.admAPIEndpointReleaseToken).Methods("GET")
+		rt.HandleFunc(api.AdmAPIEndpointTapPath, m.admAPIEndpointTap).Methods("GET", "DELETE")
+		rt.HandleFunc(api.AdmAPIEndpointErrorsPath, m.admAPIEndpointErrors).Methods("GET")
 		rt.HandleFunc(api.AdmAPIEndpointsSysmonConfig, m.admAPIEndpointSysmonConfig).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(api.AdmAPIEndpointsSysmonBinary, m.admAPIEndpointSysmonBinary).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(api.AdmAPIEndpointsOSQueryiBinary, m.admAPIEndpointOSQueryiBinary).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(api.AdmAPIIocsPath, m.admAPIIocs).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(api.AdmAPIRulesPath, m.admAPIRules).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPICandidateRulesPath, m.admAPICandidateRules).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIPromoteRulesPath, m.admAPIPromoteRules).Methods("POST")
+		rt.HandleFunc(api.AdmAPIRulesRolloutPath, m.admAPIRulesRollout).Methods("GET", "POST")
+		rt.HandleFunc(api.AdmAPIVerdictsPath, m.admAPIVerdicts).Methods("GET", "POST")
+		rt.HandleFunc(api.AdmAPISuppressionsPath, m.admAPISuppressions).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPINoisePath, m.admAPINoise).Methods("GET", "POST")
+		rt.HandleFunc(api.AdmAPINoiseAcceptPath, m.admAPINoiseAccept).Methods("POST")
+		rt.HandleFunc(api.AdmAPICanariesPath, m.admAPICanaries).Methods("GET")
+		rt.HandleFunc(api.AdmAPIGroupsPath, m.admAPIGroups).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIPlaybooksPath, m.admAPIPlaybooks).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIPlaybookRunsPath, m.admAPIPlaybookRuns).Methods("GET")
+		rt.HandleFunc(api.AdmAPITrustedPath, m.admAPITrusted).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIIncidentsPath, m.admAPIIncidents).Methods("GET", "POST")
+		rt.HandleFunc(api.AdmAPIIncidentByIDPath, m.admAPIIncident).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIIncidentRestorePath, m.admAPIIncidentRestore).Methods("POST")
+		rt.HandleFunc(api.AdmAPIContainersPath, m.admAPIContainers).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(api.AdmAPIContainersEditPath, m.admAPIContainersEdit).Methods("POST")
+		rt.HandleFunc(api.AdmAPIContainersHistoryPath, m.admAPIContainersHistory).Methods("GET")
 		rt.HandleFunc(api.AdmAPIStatsPath, m.admAPIStats).Methods("GET")
+		rt.HandleFunc(api.AdmAPIMetricsPath, m.admAPIMetrics).Methods("GET")
+		rt.HandleFunc(api.AdmAPIRulesEffectivenessPath, m.admAPIRulesEffectiveness).Methods("GET")
+		rt.HandleFunc(api.AdmAPIRulesDiffPath, m.admAPIRulesDiff).Methods("GET")
+		rt.HandleFunc(api.AdmAPIRuleHitsPath, m.admAPIRuleHits).Methods("GET")
+		rt.HandleFunc(api.AdmAPIContainmentKeyPath, m.admAPIContainmentKey).Methods("GET")
+		rt.HandleFunc(api.AdmAPIResponderSessionsPath, m.admAPIResponderSessions).Methods("GET", "POST")
 		// WebSocket handlers
 		rt.HandleFunc(api.AdmAPIStreamEvents, m.admAPIStreamEvents)
 		rt.HandleFunc(api.AdmAPIStreamDetections, m.admAPIStreamDetections)
 
-		uri := format("%s:%d", m.Config.AdminAPI.Host, m.Config.AdminAPI.Port)
+		uri := net.JoinHostPort(m.Config.AdminAPI.Host, strconv.Itoa(m.Config.AdminAPI.Port))
 		m.adminAPI = &http.Server{
 			Handler:      rt,
 			Addr:         uri,
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -18,6 +19,7 @@ import (
 	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/sysmon"
 	"github.com/0xrawsec/whids/tools"
+	"github.com/0xrawsec/whids/utils"
 
 	"github.com/gorilla/mux"
 )
@@ -73,6 +75,26 @@ func (m *Manager) endpointAuthorizationMiddleware(next http.Handler) http.Handle
 			return
 		}
 
+		// containment state reported by endpoint, older
+		// endpoints do not report it
+		if h := rq.Header.Get(api.EndpointContainmentHeader); h != "" {
+			if state, err := api.ParseContainmentState(h); err != nil {
+				m.logAPIErrorf("failed to parse containment state of %s: %s", endpt.Uuid, err)
+			} else if state.Contained() {
+				endpt.Containment = state
+			} else {
+				endpt.Containment = nil
+			}
+		}
+
+		// tags reported by endpoint, older endpoints do not report them
+		if h := rq.Header.Values(api.EndpointTagsHeader); len(h) > 0 {
+			if tags := api.ParseTagsHeader(h[0]); api.FormatTagsHeader(tags) != api.FormatTagsHeader(endpt.Tags) {
+				endpt.Tags = tags
+				m.updateDynamicGroups(endpt)
+			}
+		}
+
 		// update last connection timestamp
 		endpt.UpdateLastConnection()
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
@@ -141,6 +163,10 @@ func (m *Manager) runEndpointAPI() {
 		rt.HandleFunc(api.EptAPIPostLogsPath, m.eptAPICollect).Methods("POST")
 		rt.HandleFunc(api.EptAPIPostDumpPath, m.eptAPIUploadDump).Methods("POST")
 		rt.HandleFunc(api.EptAPIPostSystemInfo, m.eptAPISystemInfo).Methods("POST")
+		rt.HandleFunc(api.EptAPIPostUsagePath, m.eptAPIUsage).Methods("POST")
+		rt.HandleFunc(api.EptAPIPostErrorsPath, m.eptAPIErrors).Methods("POST")
+		rt.HandleFunc(api.EptAPIPostTapPath, m.eptAPITap).Methods("POST")
+		rt.HandleFunc(api.EptAPIPostPlaybookRunPath, m.eptAPIPlaybookRun).Methods("POST")
 
 		// GET based
 		rt.HandleFunc(api.EptAPIServerKeyPath, m.eptAPIServerKey).Methods("GET")
@@ -148,6 +174,15 @@ func (m *Manager) runEndpointAPI() {
 		rt.HandleFunc(api.EptAPIRulesSha256Path, m.eptAPIRulesSha256).Methods("GET")
 		rt.HandleFunc(api.EptAPIIoCsPath, m.eptAPIIoCs).Methods("GET")
 		rt.HandleFunc(api.EptAPIIoCsSha256Path, m.eptAPIIoCsSha256).Methods("GET")
+		rt.HandleFunc(api.EptAPIIoCsEntriesPath, m.eptAPIIoCsEntries).Methods("GET")
+		rt.HandleFunc(api.EptAPISuppressionsPath, m.eptAPISuppressions).Methods("GET")
+		rt.HandleFunc(api.EptAPISuppressionsSha256Path, m.eptAPISuppressionsSha256).Methods("GET")
+		rt.HandleFunc(api.EptAPIPlaybooksPath, m.eptAPIPlaybooks).Methods("GET")
+		rt.HandleFunc(api.EptAPIPlaybooksSha256Path, m.eptAPIPlaybooksSha256).Methods("GET")
+		rt.HandleFunc(api.EptAPITrustedPath, m.eptAPITrusted).Methods("GET")
+		rt.HandleFunc(api.EptAPITrustedSha256Path, m.eptAPITrustedSha256).Methods("GET")
+		rt.HandleFunc(api.EptAPIContainersPath, m.eptAPIContainers).Methods("GET")
+		rt.HandleFunc(api.EptAPIContainersSha256Path, m.eptAPIContainersSha256).Methods("GET")
 		rt.HandleFunc(api.EptAPISysmonConfigPath, m.eptAPISysmonConfig).Methods("GET")
 		rt.HandleFunc(api.EptAPISysmonConfigSha256Path, m.eptAPISysmonConfigSha256).Methods("GET")
 		rt.HandleFunc(api.EptAPITools, m.eptAPITools).Methods("GET")
@@ -157,7 +192,7 @@ func (m *Manager) runEndpointAPI() {
 		rt.HandleFunc(api.EptAPICommandPath, m.eptAPICommand).Methods("GET", "POST")
 		rt.HandleFunc(api.EptAPIConfigPath, m.eptAPIConfig).Methods("GET", "POST")
 
-		uri := fmt.Sprintf("%s:%d", m.Config.EndpointAPI.Host, m.Config.EndpointAPI.Port)
+		uri := net.JoinHostPort(m.Config.EndpointAPI.Host, strconv.Itoa(m.Config.EndpointAPI.Port))
 		m.endpointAPI = &http.Server{
 			Handler:      rt,
 			Addr:         uri,
@@ -190,7 +225,8 @@ func (m *Manager) eptAPIServerKey(wt http.ResponseWriter, rq *http.Request) {
 func (m *Manager) eptAPIRules(wt http.ResponseWriter, rq *http.Request) {
 	m.RLock()
 	defer m.RUnlock()
-	wt.Write([]byte(m.gene.rules))
+	rules, _ := m.endpointRules(m.eptAPIMutEndpointFromRequest(rq))
+	wt.Write([]byte(rules))
 }
 
 // eptAPIConfigSha256 HTTP handler
@@ -262,6 +298,8 @@ func (m *Manager) eptAPIConfig(wt http.ResponseWriter, rq *http.Request) {
 		}
 
 		endpt.Config = &config
+		// configuration holds endpoint tags
+		m.updateDynamicGroups(endpt)
 
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
 			m.logAPIErrorf("failed to update client config: %s", err)
@@ -275,7 +313,8 @@ func (m *Manager) eptAPIConfig(wt http.ResponseWriter, rq *http.Request) {
 func (m *Manager) eptAPIRulesSha256(wt http.ResponseWriter, rq *http.Request) {
 	m.RLock()
 	defer m.RUnlock()
-	wt.Write([]byte(m.gene.sha256))
+	_, sha256 := m.endpointRules(m.eptAPIMutEndpointFromRequest(rq))
+	wt.Write([]byte(sha256))
 }
 
 func (m *Manager) eptAPIIoCs(wt http.ResponseWriter, rq *http.Request) {
@@ -287,10 +326,116 @@ func (m *Manager) eptAPIIoCs(wt http.ResponseWriter, rq *http.Request) {
 	}
 }
 
+// eptAPIIoCsEntries serves IoCs along with their provenance and expiry
+func (m *Manager) eptAPIIoCsEntries(wt http.ResponseWriter, rq *http.Request) {
+	if data, err := json.Marshal(m.iocs.Entries()); err != nil {
+		m.logAPIErrorf("failed to marshal IoCs: %s", err)
+		http.Error(wt, "failed to marshal IoCs", http.StatusInternalServerError)
+	} else {
+		wt.Write(data)
+	}
+}
+
 func (m *Manager) eptAPIIoCsSha256(wt http.ResponseWriter, rq *http.Request) {
 	wt.Write([]byte(m.iocs.Hash()))
 }
 
+// eptAPISuppressions serves false positive suppressions enabled on manager
+func (m *Manager) eptAPISuppressions(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if data, err := json.Marshal(m.suppressions.list); err != nil {
+		m.logAPIErrorf("failed to marshal suppressions: %s", err)
+		http.Error(wt, "failed to marshal suppressions", http.StatusInternalServerError)
+	} else {
+		wt.Write(data)
+	}
+}
+
+func (m *Manager) eptAPISuppressionsSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.suppressions.sha256))
+}
+
+// eptAPIPlaybooks serves response playbooks enabled on manager
+func (m *Manager) eptAPIPlaybooks(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if data, err := json.Marshal(m.playbooks.list); err != nil {
+		m.logAPIErrorf("failed to marshal playbooks: %s", err)
+		http.Error(wt, "failed to marshal playbooks", http.StatusInternalServerError)
+	} else {
+		wt.Write(data)
+	}
+}
+
+func (m *Manager) eptAPIPlaybooksSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.playbooks.sha256))
+}
+
+// eptAPITrusted serves trusted processes enabled on manager
+func (m *Manager) eptAPITrusted(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if data, err := json.Marshal(m.trusted.list); err != nil {
+		m.logAPIErrorf("failed to marshal trusted processes: %s", err)
+		http.Error(wt, "failed to marshal trusted processes", http.StatusInternalServerError)
+	} else {
+		wt.Write(data)
+	}
+}
+
+func (m *Manager) eptAPITrustedSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.trusted.sha256))
+}
+
+// eptAPIPlaybookRun receives the results of playbooks run by endpoints
+func (m *Manager) eptAPIPlaybookRun(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		run := api.PlaybookRun{}
+		if err := readPostAsJSON(rq, &run); err != nil {
+			m.logAPIErrorf("failed to receive playbook run for %s", endpt.Uuid)
+			http.Error(wt, "failed to unmarshal data", http.StatusInternalServerError)
+			return
+		}
+
+		// run is identified by manager not to trust endpoint
+		run.Initialize(utils.UnsafeUUID().String())
+		run.EndpointUUID = endpt.Uuid
+		if err := m.db.InsertOrUpdate(&run); err != nil {
+			m.logAPIErrorf("failed to insert playbook run of %s: %s", endpt.Uuid, err)
+			http.Error(wt, "failed to insert playbook run", http.StatusInternalServerError)
+		}
+	}
+}
+
+// eptAPIContainers serves Gene containers managed by the manager
+func (m *Manager) eptAPIContainers(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if data, err := json.Marshal(m.containers.list); err != nil {
+		m.logAPIErrorf("failed to marshal containers: %s", err)
+		http.Error(wt, "failed to marshal containers", http.StatusInternalServerError)
+	} else {
+		wt.Write(data)
+	}
+}
+
+func (m *Manager) eptAPIContainersSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.containers.sha256))
+}
+
 // eptAPIUploadDump HTTP handler used to upload dump files from client to manager
 func (m *Manager) eptAPIUploadDump(wt http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
@@ -322,14 +467,20 @@ func (m *Manager) eptAPIUploadDump(wt http.ResponseWriter, rq *http.Request) {
 	}
 }
 
-// Container HTTP handler serves Gene containers to clients
-
 // eptAPICollect HTTP handler
 func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
 
 	cnt := 0
 	uuid := rq.Header.Get(api.EndpointUUIDHeader)
+
+	// batches sent again by agents (i.e. after a timeout) are not ingested twice
+	bid := rq.Header.Get(api.BatchIDHeader)
+	if bid != "" && m.batches.Seen(uuid, bid) {
+		m.Logger.Debugf("batch %s from endpoint %s already ingested", bid, uuid)
+		return
+	}
+
 	endpt, _ := m.Endpoint(uuid)
 
 	etid := m.eventLogger.InitTransaction()
@@ -341,11 +492,29 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 
 		if err := json.Unmarshal(tok, &e); err != nil {
 			m.logAPIErrorf("failed to unmarshal: %s", tok)
+			m.updateDroppedMetrics(uuid, 1)
 		} else {
 
+			// timestamps are stored in UTC, original timezone is kept
+			// in EdrData (it may already have been set by the agent)
+			e.NormalizeTime()
+
+			// detecting lost and duplicated events, duplicates are not
+			// ingested not to be accounted twice in statistics, events
+			// received too late to be tracked are ingested though
+			if m.updateSequenceMetrics(uuid, &e) {
+				cnt++
+				continue
+			}
+
 			// building up EdrData
 			edrData := event.EdrData{}
 			edrData.Event.ReceiptTime = time.Now().UTC()
+			edrData.Event.Timezone = e.Event.EdrData.Event.Timezone
+			edrData.Event.TzOffset = e.Event.EdrData.Event.TzOffset
+			edrData.Event.Stream = e.Event.EdrData.Event.Stream
+			edrData.Event.Sequence = e.Event.EdrData.Event.Sequence
+			edrData.Agent = e.Event.EdrData.Agent
 
 			edrData.Endpoint.UUID = uuid
 			if endpt != nil {
@@ -353,10 +522,14 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 				edrData.Endpoint.IP = endpt.IP
 				edrData.Endpoint.Hostname = endpt.Hostname
 				edrData.Endpoint.Group = endpt.Group
+				edrData.Endpoint.Tags = endpt.Tags
 
 				// updating reducer
 				m.UpdateReducer(endpt.Uuid, &e)
 
+				// updating rules rollout statistics
+				m.updateRolloutStats(endpt, &e)
+
 				// updating last event
 				endpt.LastEvent = e.Timestamp()
 				// updating last detection
@@ -381,6 +554,9 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 
 			if _, err := m.eventLogger.WriteEvent(etid, uuid, &e); err != nil {
 				m.logAPIErrorf("failed to write event: %s", err)
+				m.updateDroppedMetrics(uuid, 1)
+			} else {
+				m.updateMetrics(uuid, &e)
 			}
 
 			// we queue event for streaming
@@ -389,6 +565,12 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 		cnt++
 	}
 
+	// a batch partially read is not recorded so that it can be sent again
+	err := s.Err()
+	if err != nil {
+		m.logAPIErrorf("failed to read events from endpoint UUID=%s: %s", uuid, err)
+	}
+
 	if endpt != nil {
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
 			m.logAPIErrorf("failed to update endpoint UUID=%s: %s", endpt.Uuid, err)
@@ -404,6 +586,55 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 	}
 	m.Logger.Debugf("count Event Received: %d", cnt)
 
+	if err != nil {
+		http.Error(wt, "failed to read events", http.StatusInternalServerError)
+		return
+	}
+
+	if bid != "" {
+		m.batches.Add(uuid, bid)
+	}
+
+}
+
+// eptAPITap receives events live tapped on endpoint, those
+// are neither stored in event logs nor go through metrics
+func (m *Manager) eptAPITap(wt http.ResponseWriter, rq *http.Request) {
+	defer rq.Body.Close()
+
+	endpt := m.eptAPIMutEndpointFromRequest(rq)
+	if endpt == nil {
+		return
+	}
+
+	events := make([]*event.EdrEvent, 0)
+	s := bufio.NewScanner(rq.Body)
+	for s.Scan() {
+		e := event.EdrEvent{}
+
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			m.logAPIErrorf("failed to unmarshal tapped event: %s", err)
+			continue
+		}
+
+		e.NormalizeTime()
+
+		edrData := event.EdrData{}
+		edrData.Event.ReceiptTime = time.Now().UTC()
+		edrData.Event.Timezone = e.Event.EdrData.Event.Timezone
+		edrData.Event.TzOffset = e.Event.EdrData.Event.TzOffset
+		edrData.Agent = e.Event.EdrData.Agent
+		edrData.Endpoint.UUID = endpt.Uuid
+		edrData.Endpoint.IP = endpt.IP
+		edrData.Endpoint.Hostname = endpt.Hostname
+		edrData.Endpoint.Group = endpt.Group
+		edrData.Endpoint.Tags = endpt.Tags
+		e.Event.EdrData = &edrData
+
+		events = append(events, &e)
+	}
+
+	m.eventTap.Push(endpt.Uuid, events...)
 }
 
 // eptAPICommand HTTP handler
@@ -452,6 +683,11 @@ func (m *Manager) eptAPICommand(wt http.ResponseWriter, rq *http.Request) {
 							if err := m.db.InsertOrUpdate(endpt); err != nil {
 								m.logAPIErrorf("to update endpoint data: %s", err)
 							}
+							if endpt.Command.Completed {
+								if err := m.incidentsCommandCompleted(endpt.Command); err != nil {
+									m.logAPIErrorf("failed to update incidents: %s", err)
+								}
+							}
 						}
 					}
 				} else {
@@ -473,7 +709,7 @@ func (m *Manager) eptAPISystemInfo(wt http.ResponseWriter, rq *http.Request) {
 				http.Error(wt, "failed to unmarshal data", http.StatusInternalServerError)
 			} else {
 				endpt.SystemInfo = &info
-				m.db.InsertOrUpdate(endpt)
+				m.updateDynamicGroups(endpt)
 				if err := m.db.InsertOrUpdate(endpt); err != nil {
 					m.logAPIErrorf("failed to update endpoint data: %s", err)
 				}
@@ -482,6 +718,39 @@ func (m *Manager) eptAPISystemInfo(wt http.ResponseWriter, rq *http.Request) {
 	}
 }
 
+// eptAPIUsage receives rules and containers usage reports from endpoints
+func (m *Manager) eptAPIUsage(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		report := api.UsageReport{}
+		if err := readPostAsJSON(rq, &report); err != nil {
+			m.logAPIErrorf("failed to receive usage report for %s", endpt.Uuid)
+			http.Error(wt, "failed to unmarshal data", http.StatusInternalServerError)
+		} else if err := m.updateRuleUsage(endpt.Uuid, &report); err != nil {
+			m.logAPIErrorf("failed to update usage of %s: %s", endpt.Uuid, err)
+			http.Error(wt, "failed to update usage", http.StatusInternalServerError)
+		} else if report.Stats != nil {
+			endpt.Stats = report.Stats
+			if err := m.db.InsertOrUpdate(endpt); err != nil {
+				m.logAPIErrorf("failed to update endpoint statistics: %s", err)
+			}
+		}
+	}
+}
+
+// eptAPIErrors receives agent error reports from endpoints
+func (m *Manager) eptAPIErrors(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		report := api.AgentErrorReport{}
+		if err := readPostAsJSON(rq, &report); err != nil {
+			m.logAPIErrorf("failed to receive error report for %s", endpt.Uuid)
+			http.Error(wt, "failed to unmarshal data", http.StatusInternalServerError)
+		} else if err := m.updateAgentErrors(endpt.Uuid, &report); err != nil {
+			m.logAPIErrorf("failed to update errors of %s: %s", endpt.Uuid, err)
+			http.Error(wt, "failed to update errors", http.StatusInternalServerError)
+		}
+	}
+}
+
 func (m *Manager) eptAPISysmonConfig(wt http.ResponseWriter, rq *http.Request) {
 	var config *sysmon.Config
 
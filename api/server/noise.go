@@ -0,0 +1,429 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultNoiseWindow number of days of alerts noise is learnt from
+	// when it is not configured
+	DefaultNoiseWindow = 7
+	// DefaultNoiseMinHits minimum number of alerts of a rule before
+	// making suggestions when it is not configured
+	DefaultNoiseMinHits = 50
+
+	// maximum number of distinct values tracked per rule and field
+	noiseMaxValues = 1000
+	// values accounting for at least this ratio of the alerts
+	// of a rule are suppression candidates
+	noiseDominantRatio = 0.5
+	// fields having more distinct values than this ratio of the
+	// alerts of a rule are refinement candidates
+	noiseCardinalityRatio = 0.5
+)
+
+var (
+	// NoiseFields event fields noise is modeled on, suggestions
+	// are made only on these fields
+	NoiseFields = []string{
+		"Image",
+		"ParentImage",
+		"SourceImage",
+		"TargetImage",
+		"ImageLoaded",
+		"CommandLine",
+		"ParentCommandLine",
+		"TargetFilename",
+		"TargetObject",
+		"QueryName",
+		"DestinationHostname",
+		"User",
+		"ServiceName",
+		"PipeName",
+	}
+)
+
+// noiseField learns the values a field takes in the alerts of a rule
+type noiseField struct {
+	// values are case insensitive
+	values    map[string]int
+	original  map[string]string
+	endpoints map[string]map[string]bool
+	saturated bool
+}
+
+func newNoiseField() *noiseField {
+	return &noiseField{
+		values:    make(map[string]int),
+		original:  make(map[string]string),
+		endpoints: make(map[string]map[string]bool),
+	}
+}
+
+func (f *noiseField) add(value, euuid string) {
+	key := strings.ToLower(value)
+
+	if _, ok := f.values[key]; !ok {
+		if len(f.values) >= noiseMaxValues {
+			f.saturated = true
+			return
+		}
+		f.original[key] = value
+		f.endpoints[key] = make(map[string]bool)
+	}
+
+	f.values[key]++
+	f.endpoints[key][euuid] = true
+}
+
+func (f *noiseField) top() (value string, hits int) {
+	for k, n := range f.values {
+		// ties are broken to get a stable result
+		if n > hits || (n == hits && k < strings.ToLower(value)) {
+			value, hits = f.original[k], n
+		}
+	}
+	return
+}
+
+// ruleNoise learns base rates of a rule
+type ruleNoise struct {
+	api.RuleNoise
+	fields map[string]*noiseField
+	// values seen in alerts qualified as true positive,
+	// never suggested for suppression
+	malicious map[string]bool
+}
+
+func newRuleNoise(signature string) *ruleNoise {
+	return &ruleNoise{
+		RuleNoise: api.RuleNoise{
+			Signature: signature,
+			Endpoints: make(map[string]int),
+			Fields:    make([]*api.FieldNoise, 0),
+		},
+		fields:    make(map[string]*noiseField),
+		malicious: make(map[string]bool),
+	}
+}
+
+func maliciousKey(field, value string) string {
+	return strings.ToLower(field + "|" + value)
+}
+
+// noiseModel learns per rule and per endpoint alerts base rates
+type noiseModel struct {
+	minHits int
+	// verdicts by event hash
+	verdicts map[string]string
+	rules    map[string]*ruleNoise
+	alerts   int
+}
+
+func newNoiseModel(minHits int, verdicts map[string]string) *noiseModel {
+	return &noiseModel{
+		minHits:  minHits,
+		verdicts: verdicts,
+		rules:    make(map[string]*ruleNoise),
+	}
+}
+
+// learn accounts for an alert raised on endpoint euuid
+func (m *noiseModel) learn(euuid string, e *event.EdrEvent) {
+	d := e.GetDetection()
+	if d == nil || d.Signature == nil {
+		return
+	}
+
+	m.alerts++
+	verdict := m.verdicts[e.Hash()]
+
+	for _, s := range d.Signature.Slice() {
+		sig := s.(string)
+
+		r, ok := m.rules[sig]
+		if !ok {
+			r = newRuleNoise(sig)
+			m.rules[sig] = r
+		}
+
+		r.Hits++
+		r.Endpoints[euuid]++
+
+		switch verdict {
+		case api.VerdictTruePositive:
+			r.TruePositives++
+		case api.VerdictFalsePositive:
+			r.FalsePositives++
+		}
+
+		for _, field := range NoiseFields {
+			value, ok := e.Event.Event.GetPropertyString(field)
+			if !ok || value == "" {
+				continue
+			}
+
+			if _, ok := r.fields[field]; !ok {
+				r.fields[field] = newNoiseField()
+			}
+			r.fields[field].add(value, euuid)
+
+			if verdict == api.VerdictTruePositive {
+				r.malicious[maliciousKey(field, value)] = true
+			}
+		}
+	}
+}
+
+// suggest makes tuning suggestions for rule r
+func (m *noiseModel) suggest(r *ruleNoise) (suggestions []*api.NoiseSuggestion) {
+	suggestions = make([]*api.NoiseSuggestion, 0)
+
+	if r.Hits < m.minHits {
+		return
+	}
+
+	for _, field := range NoiseFields {
+		f, ok := r.fields[field]
+		if !ok {
+			continue
+		}
+
+		dominant := false
+		for key, n := range f.values {
+			value := f.original[key]
+			ratio := float64(n) / float64(r.Hits)
+
+			if ratio < noiseDominantRatio {
+				continue
+			}
+
+			dominant = true
+			if r.malicious[maliciousKey(field, value)] {
+				continue
+			}
+
+			s := api.NewNoiseSuggestion(api.NoiseSuggestionSuppression, r.Signature, field, value)
+			s.Hits = n
+			s.Ratio = ratio
+			s.Endpoints = len(f.endpoints[key])
+			s.Reason = fmt.Sprintf("%s=%s accounts for %.0f%% of the alerts on %d endpoint(s) and was never qualified as true positive",
+				field, value, ratio*100, s.Endpoints)
+			suggestions = append(suggestions, s)
+		}
+
+		// a rule matching many distinct values may be too broad
+		if !dominant && r.TruePositives == 0 && len(f.values) > 1 &&
+			(f.saturated || float64(len(f.values)) >= noiseCardinalityRatio*float64(r.Hits)) {
+			s := api.NewNoiseSuggestion(api.NoiseSuggestionRefinement, r.Signature, field, "")
+			s.Hits = r.Hits
+			s.Ratio = 1
+			s.Endpoints = len(r.Endpoints)
+			s.Reason = fmt.Sprintf("%s takes %d distinct values over %d alerts without any true positive, consider refining the rule on this field",
+				field, len(f.values), r.Hits)
+			suggestions = append(suggestions, s)
+		}
+	}
+
+	return
+}
+
+// report builds a noise report out of the alerts learnt between start and end
+func (m *noiseModel) report(start, end time.Time) (r *api.NoiseReport) {
+	r = api.NewNoiseReport(start, end)
+	r.Alerts = m.alerts
+
+	days := math.Max(end.Sub(start).Hours()/24, 1)
+
+	for _, rule := range m.rules {
+		for _, field := range NoiseFields {
+			if f, ok := rule.fields[field]; ok {
+				value, hits := f.top()
+				rule.Fields = append(rule.Fields, &api.FieldNoise{
+					Field:       field,
+					Cardinality: len(f.values),
+					Saturated:   f.saturated,
+					TopValue:    value,
+					TopHits:     hits,
+				})
+			}
+		}
+
+		rule.DailyRate = float64(rule.Hits) / (float64(len(rule.Endpoints)) * days)
+
+		r.Rules = append(r.Rules, &rule.RuleNoise)
+		r.Suggestions = append(r.Suggestions, m.suggest(rule)...)
+	}
+
+	// noisiest first
+	sort.Slice(r.Rules, func(i, j int) bool {
+		if r.Rules[i].Hits == r.Rules[j].Hits {
+			return r.Rules[i].Signature < r.Rules[j].Signature
+		}
+		return r.Rules[i].Hits > r.Rules[j].Hits
+	})
+
+	sort.Slice(r.Suggestions, func(i, j int) bool {
+		if r.Suggestions[i].Hits == r.Suggestions[j].Hits {
+			return r.Suggestions[i].Uuid < r.Suggestions[j].Uuid
+		}
+		return r.Suggestions[i].Hits > r.Suggestions[j].Hits
+	})
+
+	return
+}
+
+// suppressionExists returns true if a suppression similar to s already exists
+func (m *Manager) suppressionExists(s *api.Suppression) bool {
+	return m.db.Search(&api.Suppression{}, "Signature", "=", s.Signature).
+		And("Field", "=", s.Field).
+		And("Value", "=", s.Value).Len() > 0
+}
+
+// ModelNoise learns per rule and per endpoint base rates of the alerts
+// received between start and end and saves the resulting report. Rules
+// having less than minHits alerts do not get any suggestion.
+func (m *Manager) ModelNoise(start, end time.Time, minHits int) (r *api.NoiseReport, err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.AlertVerdict{}); err != nil {
+		return
+	}
+
+	verdicts := make(map[string]string)
+	for _, o := range objs {
+		v := o.(*api.AlertVerdict)
+		verdicts[v.EventHash] = v.Verdict
+	}
+
+	model := newNoiseModel(minHits, verdicts)
+	for rawEvent := range m.detectionSearcher.Events(start, end, "", math.MaxInt, 0) {
+		e, err := rawEvent.Event()
+		if err != nil {
+			m.Logger.Errorf("failed to decode alert: %s", err)
+			continue
+		}
+
+		euuid := ""
+		if e.Event.EdrData != nil {
+			euuid = e.Event.EdrData.Endpoint.UUID
+		}
+		model.learn(euuid, e)
+	}
+
+	if err = m.detectionSearcher.Err(); err != nil {
+		return
+	}
+
+	r = model.report(start, end)
+
+	// suggestions already turned into suppressions
+	suggestions := make([]*api.NoiseSuggestion, 0, len(r.Suggestions))
+	for _, s := range r.Suggestions {
+		if sup := s.Suppression(); sup != nil && m.suppressionExists(sup) {
+			continue
+		}
+		suggestions = append(suggestions, s)
+	}
+	r.Suggestions = suggestions
+	r.Generated = time.Now().UTC()
+
+	return r, m.saveNoiseReport(r)
+}
+
+// saveNoiseReport replaces the last noise report saved
+func (m *Manager) saveNoiseReport(r *api.NoiseReport) (err error) {
+	if err = m.db.DeleteAll(&api.NoiseReport{}); err != nil {
+		return
+	}
+	return m.db.InsertOrUpdate(r)
+}
+
+// NoiseReport returns the last noise report generated
+func (m *Manager) NoiseReport() (r *api.NoiseReport, err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.NoiseReport{}); err != nil {
+		return
+	}
+
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("no noise report generated yet")
+	}
+
+	return objs[0].(*api.NoiseReport), nil
+}
+
+// AcceptNoiseSuggestions turns suggestions of the last noise report into
+// enabled suppressions. Accepted suggestions are removed from the report.
+func (m *Manager) AcceptNoiseSuggestions(uuids ...string) (suppressions []*api.Suppression, err error) {
+	var r *api.NoiseReport
+
+	if r, err = m.NoiseReport(); err != nil {
+		return
+	}
+
+	accepted := make(map[string]bool)
+	suppressions = make([]*api.Suppression, 0, len(uuids))
+	for _, uuid := range uuids {
+		s, ok := r.Suggestion(uuid)
+		if !ok {
+			return nil, fmt.Errorf("unknown suggestion %s", uuid)
+		}
+
+		sup := s.Suppression()
+		if sup == nil {
+			return nil, fmt.Errorf("%s suggestion %s cannot be turned into a suppression", s.Kind, uuid)
+		}
+
+		// suppressions are updated if they already exist
+		o, err := m.db.Search(&api.Suppression{}, "Signature", "=", sup.Signature).
+			And("Field", "=", sup.Field).
+			And("Value", "=", sup.Value).One()
+		switch {
+		case err == nil:
+			sup.Initialize(o.UUID())
+		case !sod.IsNoObjectFound(err):
+			return nil, err
+		}
+
+		sup.Enabled = true
+		suppressions = append(suppressions, sup)
+		accepted[uuid] = true
+	}
+
+	if _, err = m.db.InsertOrUpdateMany(sod.ToObjectSlice(suppressions)...); err != nil {
+		return
+	}
+
+	if err = m.updateSuppressionsCache(); err != nil {
+		return
+	}
+
+	remaining := make([]*api.NoiseSuggestion, 0, len(r.Suggestions))
+	for _, s := range r.Suggestions {
+		if !accepted[s.Uuid] {
+			remaining = append(remaining, s)
+		}
+	}
+	r.Suggestions = remaining
+
+	return suppressions, m.db.InsertOrUpdate(r)
+}
+
+// noiseMinHits returns the minimum number of alerts of a rule
+// before making tuning suggestions
+func (m *Manager) noiseMinHits() int {
+	if m.Config.Noise.MinHits > 0 {
+		return m.Config.Noise.MinHits
+	}
+	return DefaultNoiseMinHits
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/api/client"
 	"github.com/0xrawsec/whids/api/openapi"
+	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/ioc"
 	"github.com/0xrawsec/whids/sysmon"
 	"github.com/0xrawsec/whids/utils"
@@ -170,6 +171,10 @@ func init() {
 	openAPI.TestingServer = &openapi.Server{URL: mconf.AdminAPIUrl()}
 	client.Hostname = "OpenHappy"
 
+	systemInfo.OS.Name = "windows"
+	systemInfo.Sysmon = &sysmon.Info{}
+	systemInfo.Sysmon.Config.Version.Schema = "4.70"
+
 	if err := json.Unmarshal([]byte(fakeSystemInfo), systemInfo); err != nil {
 		panic(err)
 	}
@@ -363,6 +368,8 @@ func TestOpenApiUserManagement(t *testing.T) {
 				Parameters: []*openapi.Parameter{
 					openapi.PathParameter("uuid", guid),
 					openapi.QueryParameter(api.QpNewKey, true, "Generate a new random key for user").Skip(),
+					openapi.QueryParameter(api.QpNewTOTP, true, "Generate a new TOTP secret for user, shown only once in the response").Skip(),
+					openapi.QueryParameter(api.QpTOTP, "123456", "Current TOTP code, required to replace an existing TOTP secret").Skip(),
 				},
 				RequestBody: openapi.JsonRequestBody(
 					"Data to update user with",
@@ -403,6 +410,7 @@ func TestOpenApiEndpointManagement(t *testing.T) {
 				openapi.QueryParameter(api.QpGroup, "", "Filter by group"),
 				openapi.QueryParameter(api.QpStatus, "", "Filter by status"),
 				openapi.QueryParameter(api.QpCriticality, 0, "Filter by criticality"),
+				openapi.QueryParameter(api.QpTag, "", "Filter by tag set with tag commands"),
 			},
 			Output: AdminAPIResponse{},
 		})
@@ -548,6 +556,12 @@ func TestOpenApiEndpointCommands(t *testing.T) {
 			Summary: "Send a command to be executed by the endpoint",
 			Parameters: []*openapi.Parameter{
 				openapi.PathParameter("uuid", cconf.UUID).Suffix("/command"),
+				openapi.QueryParameter(api.QpIncident,
+					"a7d0e5bb-1d2d-4a0b-8f5c-0e4b7e4f6a3c",
+					"Open incident to link the reversible actions of the command to (contain, block, disable-user, quarantine)").Skip(),
+				openapi.QueryParameter(api.QpSession,
+					"0c4e2a6b-5f3d-4d8e-9b1a-7e2f8c9d0a1b",
+					"Responder session within which destructive commands (terminate, suspend, quarantine, contain, revert ...) are approved").Skip(),
 			},
 			RequestBody: openapi.JsonRequestBody(
 				`Command to be executed. One can also specify files 
@@ -693,6 +707,28 @@ func TestOpenApiLogs(t *testing.T) {
 			Output: AdminAPIResponse{},
 		})
 
+		// getting the hash of an alert to document evidence export
+		ehash := eventHash
+		alerts := make([]*event.EdrEvent, 0)
+		r := get(api.AdmAPIEndpointsPath + "/" + cconf.UUID + api.AdmAPIDetectionSuffix + "?" + api.QpLast + "=1d")
+		if err := r.UnmarshalData(&alerts); err == nil && len(alerts) > 0 {
+			ehash = alerts[0].Hash()
+		}
+
+		openAPI.Do(logsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Export an alert as an anonymized evidence bundle, with Sigma metadata, to share it with third parties",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpSince, nowStr, "Search alert since date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpUntil, nowStr, "Search alert until date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpLast, "1d", "Search alert within last duration (ex: `1d` for last day)"),
+				openapi.PathParameter("uuid",
+					cconf.UUID).Suffix(api.AdmAPIDetectionSuffix),
+				openapi.PathParameter("ehash", ehash, "Hash of the alert").Suffix(api.AdmAPIEvidenceSuffix),
+			},
+			Output: AdminAPIResponse{},
+		})
+
 	}
 
 	runAdminApiTest(t, f)
@@ -881,6 +917,697 @@ func TestOpenApiRules(t *testing.T) {
 	t.Log(prettyJSON(openAPI))
 }
 
+func TestOpenApiRulesRollout(t *testing.T) {
+	f := func(t *testing.T) {
+
+		sum := "Rules Staged Rollout"
+		candidatePath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPICandidateRulesPath,
+		}
+
+		rolloutPath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPIRulesRolloutPath,
+		}
+
+		promotePath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPIPromoteRulesPath,
+		}
+
+		name := "CandidateRule"
+		openAPI.Do(candidatePath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Add or modify a candidate rule",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpUpdate, true, "Update candidate rule if already existing"),
+			},
+			RequestBody: openapi.JsonRequestBody(
+				"Candidate rule to add to the manager",
+				[]engine.Rule{
+					{
+						Name: name,
+						Meta: engine.MetaSection{
+							Events:      map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+							Criticality: 5,
+							Schema:      engine.ParseVersion("2.0.0"),
+						},
+						Matches: []string{
+							fmt.Sprintf("$foo: Image ~= '%s'", `C:\\Malware.exe`),
+						},
+						Condition: "$foo",
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(candidatePath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get candidate rules",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, name, "Regex matching the names of the candidate rules to retrieve"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(rolloutPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Configure rollout of candidate rules",
+			RequestBody: openapi.JsonRequestBody(
+				"Percentage of endpoints and groups of endpoints receiving candidate rules",
+				api.RulesRollout{Percentage: 10, Groups: []string{"test"}},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(rolloutPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get rollout configuration and comparative statistics of cohorts",
+			Output:  AdminAPIResponse{},
+		})
+
+		openAPI.Do(promotePath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Promote candidate rules to stable rules",
+			Output:  AdminAPIResponse{},
+		})
+
+		openAPI.Do(candidatePath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete candidate rules from manager",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, name, `Name of the candidate rule to delete. To avoid mistakes, this
+				parameter cannot be a regex.`),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiVerdicts(t *testing.T) {
+	f := func(t *testing.T) {
+
+		sum := "Alert Verdicts"
+		verdictsPath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPIVerdictsPath,
+		}
+
+		suppressionsPath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPISuppressionsPath,
+		}
+
+		openAPI.Do(verdictsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Mark an alert as true or false positive",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpPropose, true, "Generate suppression proposals out of a false positive verdict"),
+			},
+			RequestBody: openapi.JsonRequestBody(
+				"Verdict to apply to the alert",
+				api.AlertVerdict{
+					EventHash: "bf1ba6ddfe1ed4e5db0b1b1fbf21a5a7a0b1b2a2",
+					Verdict:   api.VerdictFalsePositive,
+					Comment:   "legitimate administration tool",
+					Signature: []string{"SuspiciousTool"},
+					Image:     `C:\Windows\System32\admin.exe`,
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(verdictsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get alert verdicts",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpHash, "bf1ba6ddfe1ed4e5db0b1b1fbf21a5a7a0b1b2a2", "Hash of the alert"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get false positive suppressions and proposals",
+			Output:  AdminAPIResponse{},
+		})
+
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Add, modify or enable false positive suppressions",
+			RequestBody: openapi.JsonRequestBody(
+				"Suppressions to add, only enabled suppressions are pushed to endpoints",
+				[]api.Suppression{
+					{
+						Signature: "SuspiciousTool",
+						Field:     "Image",
+						Value:     `C:\Windows\System32\admin.exe`,
+						Enabled:   true,
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete false positive suppressions",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "SuspiciousTool", "Signature of the suppressions to delete"),
+				openapi.QueryParameter(api.QpValue, `C:\Windows\System32\admin.exe`, "Value of the suppression to delete"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiNoise(t *testing.T) {
+	f := func(t *testing.T) {
+
+		sum := "Alerts noise modeling"
+		noisePath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPINoisePath,
+		}
+
+		acceptPath := openapi.PathItem{
+			Summary: sum,
+			Value:   api.AdmAPINoiseAcceptPath,
+		}
+
+		openAPI.Do(noisePath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Model alerts noise and make tuning suggestions",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-7*24*time.Hour).Format(time.RFC3339), "Learn from alerts received since date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpUntil, time.Now().Format(time.RFC3339), "Learn from alerts received until date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpLast, "7d", "Learn from alerts received over the last period (Go duration or days), defaults to the configured window"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(noisePath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get last noise report",
+			Output:  AdminAPIResponse{},
+		})
+
+		openAPI.Do(acceptPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Turn suppression suggestions into enabled suppressions",
+			RequestBody: openapi.JsonRequestBody(
+				"UUIDs of the suggestions of the last noise report to accept",
+				[]string{},
+				true),
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiCanaries(t *testing.T) {
+	f := func(t *testing.T) {
+
+		canariesPath := openapi.PathItem{
+			Summary: "Canary triggers",
+			Value:   api.AdmAPICanariesPath,
+		}
+
+		openAPI.Do(canariesPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Aggregate canary alerts by endpoint, canary file and process",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-7*24*time.Hour).Format(time.RFC3339), "Aggregate alerts received since date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpUntil, time.Now().Format(time.RFC3339), "Aggregate alerts received until date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpLast, "7d", "Aggregate alerts received over the last period (Go duration or days), defaults to seven days"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiGroups(t *testing.T) {
+	f := func(t *testing.T) {
+
+		groupsPath := openapi.PathItem{
+			Summary: "Dynamic groups of endpoints",
+			Value:   api.AdmAPIGroupsPath,
+		}
+
+		openAPI.Do(groupsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Add or modify dynamic groups",
+			RequestBody: openapi.JsonRequestBody(
+				"Groups to add, an endpoint belongs to a group as long as it matches group's query",
+				[]api.EndpointGroup{
+					{
+						Name:        "recent-laptops",
+						Query:       "os.build >= 22000 AND tag:laptop",
+						Description: "Laptops running Windows 11",
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(groupsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get dynamic groups",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "recent-laptops", "Name of the group"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(groupsPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete a dynamic group",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "recent-laptops", "Name of the group to delete"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiPlaybooks(t *testing.T) {
+	f := func(t *testing.T) {
+
+		playbooksPath := openapi.PathItem{
+			Summary: "Response playbooks run by endpoints",
+			Value:   api.AdmAPIPlaybooksPath,
+		}
+
+		openAPI.Do(playbooksPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Add or modify response playbooks",
+			RequestBody: openapi.JsonRequestBody(
+				"Playbooks to add, only enabled playbooks are pushed to endpoints",
+				[]api.Playbook{
+					{
+						Name:        "ransomware",
+						Description: "Contain ransomware activity",
+						Tags:        []string{"ransomware"},
+						Steps: []*api.PlaybookStep{
+							{Action: api.PlaybookStepSuspend},
+							{Action: api.PlaybookStepMemdump, ContinueOnError: true},
+							{Action: api.PlaybookStepIsolate, Args: []string{"soft", "4h"}},
+							{Action: api.PlaybookStepNotify, Args: []string{"ransomware contained"}},
+						},
+						Enabled: true,
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(playbooksPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get response playbooks",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "ransomware", "Name of the playbook"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(playbooksPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete a response playbook",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "ransomware", "Name of the playbook to delete"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		runsPath := openapi.PathItem{
+			Summary: "Playbooks run by endpoints",
+			Value:   api.AdmAPIPlaybookRunsPath,
+		}
+
+		openAPI.Do(runsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get playbook runs with per step results, most recent first",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "ransomware", "Name of the playbook"),
+				openapi.QueryParameter(api.QpUuid, "5a92baeb-9384-47d3-92b4-a0db6f9b8c6d", "Endpoint UUID"),
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-time.Hour).Format(time.RFC3339), "Only runs started after this time"),
+				openapi.QueryParameter(api.QpLimit, 10, "Maximum number of runs to return"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiIncidents(t *testing.T) {
+	f := func(t *testing.T) {
+		tt := toast.FromT(t)
+
+		incidentsPath := openapi.PathItem{
+			Summary: "Incidents response actions are linked to",
+			Value:   api.AdmAPIIncidentsPath,
+		}
+
+		incident := api.Incident{}
+		r := post(api.AdmAPIIncidentsPath, JSON(api.Incident{Title: "Ransomware on finance"}))
+		tt.CheckErr(r.Err())
+		tt.CheckErr(r.UnmarshalData(&incident))
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Create a new incident",
+			RequestBody: openapi.JsonRequestBody(
+				"Incident to create, only title and description are used",
+				api.Incident{
+					Title:       "Lateral movement",
+					Description: "PsExec used from a compromised workstation",
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get incidents, most recent first",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpStatus, api.IncidentOpen, "Filter by incident status"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get an incident along with the response actions linked to it",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", incident.Uuid),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Modify an incident",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", incident.Uuid),
+			},
+			RequestBody: openapi.JsonRequestBody(
+				"Fields to modify, setting status to closed closes incident without reverting its actions",
+				api.Incident{
+					Description: "Ransomware detonated on finance workstations",
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Close an incident and revert all the response actions linked to it",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", incident.Uuid).Suffix(api.AdmAPIIncidentRestoreSuffix),
+				openapi.QueryParameter(api.QpSession,
+					"0c4e2a6b-5f3d-4d8e-9b1a-7e2f8c9d0a1b",
+					"Responder session within which revert commands are approved").Skip(),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(incidentsPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete an incident",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", incident.Uuid),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiContainers(t *testing.T) {
+	f := func(t *testing.T) {
+
+		containersPath := openapi.PathItem{
+			Summary: "Gene containers pushed to endpoints",
+			Value:   api.AdmAPIContainersPath,
+		}
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Create or replace containers",
+			RequestBody: openapi.JsonRequestBody(
+				"Containers to create, entries are validated according to container type",
+				[]api.EdrContainer{
+					{
+						Name:        "blacklist",
+						Type:        api.ContainerTypeMd5,
+						Description: "Blacklisted binaries",
+						Entries:     []string{"d41d8cd98f00b204e9800998ecf8427e"},
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		editPath := openapi.PathItem{
+			Summary: "Edit containers",
+			Value:   api.AdmAPIContainersEditPath,
+		}
+
+		openAPI.Do(editPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Add or remove entries from a container",
+			RequestBody: openapi.JsonRequestBody(
+				"Entries to add or remove",
+				api.ContainerEdit{
+					Name:    "blacklist",
+					Add:     []string{"0cc175b9c0f1b6a831c399e269772661"},
+					Remove:  []string{"d41d8cd98f00b204e9800998ecf8427e"},
+					Comment: "false positive",
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get containers",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "blacklist", "Name of the container"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		historyPath := openapi.PathItem{
+			Summary: "Containers history",
+			Value:   api.AdmAPIContainersHistoryPath,
+		}
+
+		openAPI.Do(historyPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get history of container changes, most recent first",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "blacklist", "Name of the container"),
+				openapi.QueryParameter(api.QpLimit, 10, "Maximum number of changes to return"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete a container",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpName, "blacklist", "Name of the container to delete"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiRuleEffectiveness(t *testing.T) {
+	f := func(t *testing.T) {
+
+		path := openapi.PathItem{
+			Summary: "Rules effectiveness",
+			Value:   api.AdmAPIRulesEffectivenessPath,
+		}
+
+		openAPI.Do(path, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get fleet wide hits of rules and containers, to identify the ones to prune",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpType, api.UsageKindRule, "Kind of object to get effectiveness of (rule or container)"),
+				openapi.QueryParameter(api.QpDead, false, "Only return rules and containers not hit for the last period"),
+				openapi.QueryParameter(api.QpLast, "720h", "Period after which a rule (or container) not hit is considered dead (Go time.Duration format)"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiRulesDiff(t *testing.T) {
+	f := func(t *testing.T) {
+
+		path := openapi.PathItem{
+			Summary: "Rules drift",
+			Value:   api.AdmAPIRulesDiffPath,
+		}
+
+		openAPI.Do(path, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get rules and containers added, removed or modified between two endpoints or rule sets",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpFrom, api.CohortStable, "Endpoint UUID or rule set (stable or candidate) to diff from"),
+				openapi.QueryParameter(api.QpTo, api.CohortCandidate, "Endpoint UUID or rule set (stable or candidate) to diff to"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiRuleHits(t *testing.T) {
+	f := func(t *testing.T) {
+
+		path := openapi.PathItem{
+			Summary: "Rule hits timeline",
+			Value:   api.AdmAPIRulesPath,
+		}
+
+		openAPI.Do(path, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get a time bucketed histogram of the alerts raised by a rule across endpoints, with per endpoint breakdown",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("name", "DefenderConfigChanged").Suffix("/hits"),
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-7*24*time.Hour).Format(time.RFC3339), "Count alerts received since date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpUntil, time.Now().Format(time.RFC3339), "Count alerts received until date (RFC3339)").Skip(),
+				openapi.QueryParameter(api.QpLast, "7d", "Count alerts received over the last period (Go duration or days), defaults to seven days"),
+				openapi.QueryParameter(api.QpBucket, "1h", "Size of the buckets (Go duration), defaults to one hour"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiEndpointTap(t *testing.T) {
+	f := func(t *testing.T) {
+
+		path := openapi.PathItem{
+			Summary: "Live event tap",
+			Value:   api.AdmAPIEndpointsPath,
+		}
+
+		openAPI.Do(path, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get raw events live tapped on endpoint (tap is turned on with tap EDR command)",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(api.AdmAPITapSuffix),
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-time.Minute).Format(time.RFC3339Nano), "Only get events received after this time (RFC3339 format)"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(path, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete events live tapped on endpoint",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(api.AdmAPITapSuffix),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiContainment(t *testing.T) {
+	f := func(t *testing.T) {
+
+		keyPath := openapi.PathItem{
+			Summary: "Containment release key",
+			Value:   api.AdmAPIContainmentKeyPath,
+		}
+
+		openAPI.Do(keyPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get the public key (hex encoded) to configure on endpoints to verify emergency release tokens",
+			Output:  AdminAPIResponse{},
+		})
+
+		endpointPath := openapi.PathItem{
+			Summary: "Containment emergency release",
+			Value:   api.AdmAPIEndpointsPath,
+		}
+
+		openAPI.Do(endpointPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get a signed token to release containment of an endpoint when manager is unreachable",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(api.AdmAPIReleaseTokenSuffix),
+				openapi.QueryParameter(api.QpValidity, "24h", "Validity of the token (Go time.Duration format)"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
+func TestOpenApiResponderSessions(t *testing.T) {
+	f := func(t *testing.T) {
+
+		sessionsPath := openapi.PathItem{
+			Summary: "Responder sessions",
+			Value:   api.AdmAPIResponderSessionsPath,
+		}
+
+		openAPI.Do(sessionsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "List responder sessions",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpSince, time.Now().Add(-24*time.Hour).Format(time.RFC3339), "List sessions opened since date (RFC3339)").Skip(),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(sessionsPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Open a responder session within which destructive commands can be sent",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpValidity, "15m", "Validity of the session (Go time.Duration format)"),
+			},
+			RequestBody: openapi.JsonRequestBody(
+				"Endpoints covered by the session, reason and TOTP code of the user (if second factor is required)",
+				ResponderSessionRequest{
+					Endpoints: []string{cconf.UUID},
+					Reason:    "Ransomware spreading on host",
+				}, true),
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
 func TestOpenApiSysmon(t *testing.T) {
 
 	f := func(t *testing.T) {
@@ -933,6 +1660,20 @@ func TestOpenApiSysmon(t *testing.T) {
 			Output: AdminAPIResponse{},
 		})
 
+		openAPI.Do(path, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get Sysmon configuration recommendations for an endpoint",
+			Description: `Analyzes events observed on an endpoint and recommends exclusions for
+event sources accounting for a large share of the volume and matching no rule.
+Returns a candidate Sysmon configuration and its diff with the current one.`,
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(api.AdmAPISysmonRecommendationsSuffix),
+				openapi.QueryParameter(api.QpLast, "24h", "Analyze events from duration (ex: `24h` for last day)"),
+				openapi.QueryParameter(api.QpShare, 0.1, "Minimum share of the volume an event source must represent to be excluded"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
 		// Manage Sysmon installer
 
 		openAPI.Do(path, openapi.Operation{
@@ -1036,6 +1777,23 @@ func TestOpenApiStatistics(t *testing.T) {
 			Output:  AdminAPIResponse{},
 		})
 
+		metricsPath := openapi.PathItem{
+			Summary: "Statistics about the manager",
+			Value:   api.AdmAPIMetricsPath,
+		}
+
+		openAPI.Do(metricsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get daily metrics rollups of endpoints",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(api.QpLast, "30d", "Return rollups of the last days (default `30d`)"),
+				openapi.QueryParameter(api.QpUuid, cconf.UUID, "Filter by endpoint UUID"),
+				openapi.QueryParameter(api.QpGroup, "HR", "Filter by endpoint group").Skip(),
+				openapi.QueryParameter(api.QpAggregate, false, "Sum up rollups of all endpoints by day"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
 	}
 
 	runAdminApiTest(t, f)
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/api"
+)
+
+const (
+	// DefaultResponderSessionValidity validity of a responder
+	// session when not specified
+	DefaultResponderSessionValidity = 15 * time.Minute
+	// DefaultResponderMaxValidity maximum validity of a responder
+	// session when it is not configured
+	DefaultResponderMaxValidity = time.Hour
+)
+
+// ResponderSessionRequest is the request made to open a responder session
+type ResponderSessionRequest struct {
+	Endpoints []string `json:"endpoints"`
+	Reason    string   `json:"reason"`
+	// TOTP code of the user opening the session
+	Code string `json:"code,omitempty"`
+}
+
+// responderMaxValidity returns the maximum validity of a responder session
+func (m *Manager) responderMaxValidity() time.Duration {
+	if m.Config.Responder.MaxValidity > 0 {
+		return m.Config.Responder.MaxValidity
+	}
+	return DefaultResponderMaxValidity
+}
+
+// OpenResponderSession opens a responder session for user after having checked
+// its role, its second factor and that the session request is within limits
+func (m *Manager) OpenResponderSession(user *AdminAPIUser, r *ResponderSessionRequest, validity time.Duration) (s *api.ResponderSession, err error) {
+	c := m.Config.Responder
+
+	if c.Group != "" && user.Group != c.Group {
+		return nil, fmt.Errorf("user %s is not allowed to open responder sessions", user.Identifier)
+	}
+
+	if c.SecondFactor {
+		if user.TOTPSecret == "" {
+			return nil, fmt.Errorf("user %s has no TOTP secret configured", user.Identifier)
+		}
+
+		if !verifyTOTP(user.TOTPSecret, r.Code, time.Now()) {
+			return nil, fmt.Errorf("invalid TOTP code")
+		}
+	}
+
+	if r.Reason == "" {
+		return nil, fmt.Errorf("a reason is required to open a responder session")
+	}
+
+	if len(r.Endpoints) == 0 {
+		return nil, fmt.Errorf("responder session must cover at least one endpoint")
+	}
+
+	if c.MaxEndpoints > 0 && len(r.Endpoints) > c.MaxEndpoints {
+		return nil, fmt.Errorf("responder session cannot cover more than %d endpoints", c.MaxEndpoints)
+	}
+
+	for _, euuid := range r.Endpoints {
+		if _, ok := m.Endpoint(euuid); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnkEndpoint, euuid)
+		}
+	}
+
+	if validity <= 0 || validity > m.responderMaxValidity() {
+		return nil, fmt.Errorf("responder session validity must be in ]0; %s]", m.responderMaxValidity())
+	}
+
+	s = api.NewResponderSession(user.Identifier, r.Endpoints, r.Reason, validity)
+	return s, m.db.InsertOrUpdate(s)
+}
+
+// ResponderSessions returns the responder sessions opened since
+func (m *Manager) ResponderSessions(since time.Time) (sessions []*api.ResponderSession, err error) {
+	var objs []sod.Object
+
+	if objs, err = m.db.All(&api.ResponderSession{}); err != nil {
+		return
+	}
+
+	sessions = make([]*api.ResponderSession, 0, len(objs))
+	for _, o := range objs {
+		s := o.(*api.ResponderSession)
+		if s.Issued.Before(since) {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	return
+}
+
+// approveCommand attaches to a destructive command, sent by user to endpoint
+// euuid, an approval issued within responder session suuid. An error is
+// returned if an approval is needed but cannot be issued.
+func (m *Manager) approveCommand(user, suuid, euuid string, cmd *api.EndpointCommand) (err error) {
+	var o sod.Object
+
+	if !api.IsDestructiveCommand(cmd.Name) {
+		return
+	}
+
+	if suuid == "" {
+		if m.Config.Responder.Enforce {
+			return api.ErrApprovalRequired
+		}
+		return
+	}
+
+	if o, err = m.db.GetByUUID(&api.ResponderSession{}, suuid); err != nil {
+		return fmt.Errorf("failed to get responder session %s: %w", suuid, err)
+	}
+
+	s := o.(*api.ResponderSession)
+	if s.User != user {
+		return fmt.Errorf("responder session %s belongs to another user", suuid)
+	}
+
+	if !s.Covers(euuid, time.Now()) {
+		return fmt.Errorf("responder session %s expired or does not cover endpoint %s", suuid, euuid)
+	}
+
+	return cmd.Approve(m.containmentKey, s, euuid)
+}
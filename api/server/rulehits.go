@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultRuleHitsWindow number of days of alerts rule hits
+	// timelines are built from when no time range is given
+	DefaultRuleHitsWindow = 7
+	// DefaultRuleHitsBucket size of the buckets of rule hits
+	// timelines when it is not given
+	DefaultRuleHitsBucket = time.Hour
+	// MaxRuleHitsBuckets maximum number of buckets of a rule hits timeline
+	MaxRuleHitsBuckets = 1000
+)
+
+// ruleHitsModel builds the timeline of the hits of a rule
+type ruleHitsModel struct {
+	hits      *api.RuleHits
+	bucket    time.Duration
+	endpoints map[string]*api.RuleHitsEndpoint
+}
+
+// newRuleHitsModel creates a model of the hits of rule between start and end,
+// buckets are aligned on multiples of bucket size (in UTC)
+func newRuleHitsModel(rule string, start, end time.Time, bucket time.Duration) (m *ruleHitsModel, err error) {
+	if bucket <= 0 {
+		return nil, fmt.Errorf("bucket size must be positive")
+	}
+
+	start, end = start.UTC(), end.UTC()
+	first := start.Truncate(bucket)
+	n := int64(end.Sub(first)/bucket) + 1
+	if n > MaxRuleHitsBuckets {
+		return nil, fmt.Errorf("too many buckets (%d > %d), increase bucket size or reduce time range", n, MaxRuleHitsBuckets)
+	}
+
+	m = &ruleHitsModel{
+		hits: &api.RuleHits{
+			Rule:      rule,
+			Start:     start,
+			End:       end,
+			Bucket:    bucket.String(),
+			Buckets:   make([]*api.RuleHitsBucket, 0, n),
+			Endpoints: make([]*api.RuleHitsEndpoint, 0),
+		},
+		bucket:    bucket,
+		endpoints: make(map[string]*api.RuleHitsEndpoint),
+	}
+
+	for i := int64(0); i < n; i++ {
+		m.hits.Buckets = append(m.hits.Buckets, &api.RuleHitsBucket{
+			Start:     first.Add(time.Duration(i) * bucket),
+			Endpoints: make(map[string]int64),
+		})
+	}
+
+	return
+}
+
+// matches returns true if the alert has been raised by the rule
+func (m *ruleHitsModel) matches(e *event.EdrEvent) bool {
+	if d := e.GetDetection(); d != nil {
+		for _, s := range d.Names() {
+			if s == m.hits.Rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// learn accounts for an alert, alerts not raised by the rule
+// or out of the timeline are ignored
+func (m *ruleHitsModel) learn(e *event.EdrEvent) {
+	var euuid, hostname string
+
+	if !m.matches(e) {
+		return
+	}
+
+	ts := e.Timestamp().UTC()
+	if ts.Before(m.hits.Start) || ts.After(m.hits.End) {
+		return
+	}
+
+	if e.Event.EdrData != nil {
+		euuid = e.Event.EdrData.Endpoint.UUID
+		hostname = e.Event.EdrData.Endpoint.Hostname
+	}
+
+	b := m.hits.Buckets[ts.Sub(m.hits.Buckets[0].Start)/m.bucket]
+	b.Hits++
+	b.Endpoints[euuid]++
+	m.hits.Hits++
+
+	h, ok := m.endpoints[euuid]
+	if !ok {
+		h = &api.RuleHitsEndpoint{Endpoint: euuid, Hostname: hostname, FirstHit: ts}
+		m.endpoints[euuid] = h
+	}
+
+	h.Hits++
+	if ts.Before(h.FirstHit) {
+		h.FirstHit = ts
+	}
+	if ts.After(h.LastHit) {
+		h.LastHit = ts
+	}
+}
+
+// timeline returns the timeline of the hits of the rule
+func (m *ruleHitsModel) timeline() *api.RuleHits {
+	m.hits.Endpoints = m.hits.Endpoints[:0]
+	for _, h := range m.endpoints {
+		m.hits.Endpoints = append(m.hits.Endpoints, h)
+	}
+
+	// most hit first
+	sort.Slice(m.hits.Endpoints, func(i, j int) bool {
+		if m.hits.Endpoints[i].Hits == m.hits.Endpoints[j].Hits {
+			return m.hits.Endpoints[i].Endpoint < m.hits.Endpoints[j].Endpoint
+		}
+		return m.hits.Endpoints[i].Hits > m.hits.Endpoints[j].Hits
+	})
+
+	return m.hits
+}
+
+// RuleHits returns the timeline of the alerts raised by a rule across all
+// endpoints between start and end, alerts are counted by time bucket and endpoint
+func (m *Manager) RuleHits(rule string, start, end time.Time, bucket time.Duration) (h *api.RuleHits, err error) {
+	var model *ruleHitsModel
+
+	if model, err = newRuleHitsModel(rule, start, end, bucket); err != nil {
+		return
+	}
+
+	for rawEvent := range m.detectionSearcher.Events(start, end, "", math.MaxInt, 0) {
+		e, err := rawEvent.Event()
+		if err != nil {
+			m.Logger.Errorf("failed to decode alert: %s", err)
+			continue
+		}
+		model.learn(e)
+	}
+
+	if err = m.detectionSearcher.Err(); err != nil {
+		return
+	}
+
+	return model.timeline(), nil
+}
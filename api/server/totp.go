@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTP parameters (RFC 6238) compatible with common authenticator apps
+	totpSecretSize = 20
+	totpPeriod     = 30
+	totpDigits     = 6
+	// number of periods tolerated before and after current one
+	totpSkew = 1
+)
+
+var (
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// newTOTPSecret generates a new base32 encoded TOTP secret
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCode computes the TOTP code of secret for a given counter
+func totpCode(secret []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// verifyTOTP returns true if code is a valid TOTP code of secret at time now
+func verifyTOTP(secret, code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	counter := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if hmac.Equal([]byte(totpCode(key, uint64(counter+i))), []byte(code)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestTOTP(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// RFC 6238 test vector (truncated to 6 digits)
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tt.Assert(totpCode([]byte("12345678901234567890"), 59/totpPeriod) == "287082")
+
+	tt.Assert(verifyTOTP(secret, "287082", time.Unix(59, 0)))
+	// tolerated skew
+	tt.Assert(verifyTOTP(secret, "287082", time.Unix(59+totpPeriod, 0)))
+	tt.Assert(!verifyTOTP(secret, "287082", time.Unix(59+3*totpPeriod, 0)))
+	tt.Assert(!verifyTOTP(secret, "28708", time.Unix(59, 0)))
+	tt.Assert(!verifyTOTP("not base32!", "287082", time.Unix(59, 0)))
+
+	s, err := newTOTPSecret()
+	tt.CheckErr(err)
+	tt.Assert(s != secret)
+}
@@ -12,4 +12,14 @@ type AdminAPIUser struct {
 	Key         string `json:"key,omitempty" sod:"unique"`
 	Group       string `json:"group" sod:"index"`
 	Description string `json:"description"`
+	// secret used to generate second factor codes
+	TOTPSecret string `json:"totp-secret,omitempty"`
+}
+
+// withoutTOTP returns a copy of the user without its TOTP secret, the
+// secret is only shown once when it is generated
+func (u *AdminAPIUser) withoutTOTP() *AdminAPIUser {
+	c := *u
+	c.TOTPSecret = ""
+	return &c
 }
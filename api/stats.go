@@ -0,0 +1,62 @@
+package api
+
+import (
+	"time"
+
+	"github.com/0xrawsec/sod"
+)
+
+// StatsCounters holds agent's event processing counters
+type StatsCounters struct {
+	Events     int64 `json:"events"`
+	Detections int64 `json:"detections"`
+	// detections suppressed by maintenance windows
+	Suppressed int64 `json:"suppressed"`
+	// events by channel
+	Channels map[string]int64 `json:"channels"`
+	// detections by rule
+	Rules map[string]int64 `json:"rules"`
+}
+
+// NewStatsCounters creates new empty StatsCounters
+func NewStatsCounters() StatsCounters {
+	return StatsCounters{
+		Channels: make(map[string]int64),
+		Rules:    make(map[string]int64),
+	}
+}
+
+// Merge adds other counters to c
+func (c *StatsCounters) Merge(other *StatsCounters) {
+	c.Events += other.Events
+	c.Detections += other.Detections
+	c.Suppressed += other.Suppressed
+
+	if c.Channels == nil {
+		c.Channels = make(map[string]int64)
+	}
+	for channel, n := range other.Channels {
+		c.Channels[channel] += n
+	}
+
+	if c.Rules == nil {
+		c.Rules = make(map[string]int64)
+	}
+	for rule, n := range other.Rules {
+		c.Rules[rule] += n
+	}
+}
+
+// AgentStats holds agent's statistics since boot and cumulated
+// across restarts. It is persisted by the agent in its local database.
+type AgentStats struct {
+	sod.Item
+	// time cumulative counters started
+	Since time.Time `json:"since"`
+	// time agent booted
+	Boot time.Time `json:"boot"`
+	// number of agent boots accounted in cumulative counters
+	Boots      int           `json:"boots"`
+	SinceBoot  StatsCounters `json:"since-boot"`
+	Cumulative StatsCounters `json:"cumulative"`
+}
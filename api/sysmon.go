@@ -0,0 +1,15 @@
+package api
+
+import "github.com/0xrawsec/whids/sysmon"
+
+// SysmonRecommendations holds Sysmon configuration changes recommended
+// after analyzing the events observed on an endpoint
+type SysmonRecommendations struct {
+	// number of Sysmon events analyzed
+	Events          int                      `json:"events"`
+	Recommendations []*sysmon.Recommendation `json:"recommendations"`
+	// candidate configuration with recommendations applied
+	Config *sysmon.Config `json:"config"`
+	// line diff between current and candidate configuration XML
+	Diff string `json:"diff"`
+}
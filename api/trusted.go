@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// TrustedProcess identifies, by signer and path, a process (i.e. AV or backup
+// agent) exempted from expensive hooks, ProcessAccess heuristics and dumps
+type TrustedProcess struct {
+	sod.Item
+	Name        string `json:"name" sod:"unique"`
+	Description string `json:"description"`
+	// signer of the image, signature must be valid
+	Signer string `json:"signer"`
+	// full path of the image or directory (ending with \) it must be in
+	Path string `json:"path"`
+	// only enabled trusted processes are pushed to endpoints
+	Enabled bool `json:"enabled"`
+}
+
+// Validate overwrite sod.Item function
+func (t *TrustedProcess) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name field is mandatory")
+	}
+
+	// trusting a process by path or signer only is too permissive
+	if t.Signer == "" || t.Path == "" {
+		return fmt.Errorf("signer and path fields are mandatory")
+	}
+
+	return nil
+}
+
+// Match returns true if an image signed by signer matches the trusted process
+func (t *TrustedProcess) Match(image, signer string) bool {
+	if !strings.EqualFold(t.Signer, signer) {
+		return false
+	}
+
+	// directory
+	if strings.HasSuffix(t.Path, `\`) {
+		return strings.HasPrefix(strings.ToLower(image), strings.ToLower(t.Path))
+	}
+
+	return strings.EqualFold(t.Path, image)
+}
+
+// Key returns a key identifying a trusted process and its content
+func (t *TrustedProcess) Key() string {
+	return strings.ToLower(fmt.Sprintf("%s|%s|%s", t.Name, t.Signer, t.Path))
+}
+
+// TrustedProcessesSha256 computes the sha256 of a list of trusted
+// processes, the same for a given set whatever their order
+func TrustedProcessesSha256(trusted []*TrustedProcess) string {
+	keys := make([]string, 0, len(trusted))
+	for _, t := range trusted {
+		keys = append(keys, t.Key())
+	}
+	sort.Strings(keys)
+	return utils.Sha256StringSlice(keys)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/sod"
+)
+
+const (
+	UsageKindRule      = "rule"
+	UsageKindContainer = "container"
+)
+
+// RuleContainers returns the containers used by a rule
+func RuleContainers(r *engine.Rule) (containers []string) {
+	containers = make([]string, 0)
+	for _, m := range r.Matches {
+		if engine.IsContainerMatch(m) {
+			if cm, err := engine.ParseContainerMatch(m); err == nil {
+				containers = append(containers, cm.Container)
+			}
+		}
+	}
+	return
+}
+
+// UsageReport is sent by endpoints to report rules and
+// containers hits since last report
+type UsageReport struct {
+	Since      time.Time        `json:"since"`
+	Until      time.Time        `json:"until"`
+	Rules      map[string]int64 `json:"rules"`
+	Containers map[string]int64 `json:"containers"`
+	// agent's statistics when report was sent
+	Stats *AgentStats `json:"stats,omitempty"`
+}
+
+// NewUsageReport creates a new empty UsageReport starting now
+func NewUsageReport() *UsageReport {
+	return &UsageReport{
+		Since:      time.Now().UTC(),
+		Rules:      make(map[string]int64),
+		Containers: make(map[string]int64),
+	}
+}
+
+// Hit accounts for a rule match and for the containers used by the rule
+func (r *UsageReport) Hit(rule string, containers ...string) {
+	r.Rules[rule]++
+	for _, c := range containers {
+		r.Containers[c]++
+	}
+}
+
+// Merge merges other report into r
+func (r *UsageReport) Merge(other *UsageReport) {
+	if other.Since.Before(r.Since) {
+		r.Since = other.Since
+	}
+	for rule, n := range other.Rules {
+		r.Rules[rule] += n
+	}
+	for c, n := range other.Containers {
+		r.Containers[c] += n
+	}
+}
+
+// Empty returns true if nothing has been reported
+func (r *UsageReport) Empty() bool {
+	return len(r.Rules) == 0 && len(r.Containers) == 0
+}
+
+// RuleUsage holds the hits of a rule or container on an endpoint
+type RuleUsage struct {
+	sod.Item
+	Endpoint string    `json:"endpoint-uuid" sod:"index"`
+	Kind     string    `json:"kind" sod:"index"`
+	Name     string    `json:"name" sod:"index"`
+	Hits     int64     `json:"hits"`
+	LastHit  time.Time `json:"last-hit"`
+}
+
+// Validate overwrite sod.Item function
+func (u *RuleUsage) Validate() error {
+	switch u.Kind {
+	case UsageKindRule, UsageKindContainer:
+	default:
+		return fmt.Errorf("unknown usage kind %s", u.Kind)
+	}
+	return nil
+}
+
+// Key returns a key uniquely identifying usage of an endpoint
+func (u *RuleUsage) Key() string {
+	return fmt.Sprintf("%s|%s|%s", u.Endpoint, u.Kind, u.Name)
+}
+
+// RuleEffectiveness is the fleet wide usage of a rule or container
+type RuleEffectiveness struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Hits int64  `json:"hits"`
+	// number of endpoints the rule (or container) hit on
+	Endpoints int       `json:"endpoints"`
+	LastHit   time.Time `json:"last-hit"`
+	// true if rule (or container) did not hit for a given period of time
+	Dead bool `json:"dead"`
+}
+
+// Update updates effectiveness with the usage of an endpoint
+func (e *RuleEffectiveness) Update(u *RuleUsage) {
+	e.Hits += u.Hits
+	if u.Hits > 0 {
+		e.Endpoints++
+	}
+	if u.LastHit.After(e.LastHit) {
+		e.LastHit = u.LastHit
+	}
+}
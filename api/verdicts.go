@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	VerdictTruePositive  = "true-positive"
+	VerdictFalsePositive = "false-positive"
+)
+
+// AlertVerdict structure used by analysts to qualify an alert
+type AlertVerdict struct {
+	sod.Item
+	EventHash string    `json:"event-hash" sod:"index"`
+	Endpoint  string    `json:"endpoint-uuid" sod:"index"`
+	Verdict   string    `json:"verdict"`
+	Comment   string    `json:"comment"`
+	Signature []string  `json:"signature"`
+	Image     string    `json:"image"`
+	Timestamp time.Time `json:"timestamp"`
+	// alert the verdict applies to, only used to fill in
+	// other fields and is not stored
+	Event *event.EdrEvent `json:"event,omitempty"`
+}
+
+// Validate overwrite sod.Item function
+func (v *AlertVerdict) Validate() error {
+	if v.EventHash == "" {
+		return fmt.Errorf("event-hash field is mandatory")
+	}
+
+	switch v.Verdict {
+	case VerdictTruePositive, VerdictFalsePositive:
+	default:
+		return fmt.Errorf("verdict field must be one of %s, %s", VerdictTruePositive, VerdictFalsePositive)
+	}
+
+	return nil
+}
+
+// IsFalsePositive returns true if verdict is a false positive
+func (v *AlertVerdict) IsFalsePositive() bool {
+	return v.Verdict == VerdictFalsePositive
+}
+
+// FromEvent fills in verdict fields from the alert it applies to
+// and strips out the alert from the verdict
+func (v *AlertVerdict) FromEvent() {
+	if v.Event == nil {
+		return
+	}
+
+	if v.EventHash == "" {
+		v.EventHash = v.Event.Hash()
+	}
+
+	if d := v.Event.GetDetection(); d != nil && d.Signature != nil {
+		v.Signature = make([]string, 0, d.Signature.Len())
+		for _, s := range d.Signature.Slice() {
+			v.Signature = append(v.Signature, s.(string))
+		}
+	}
+
+	if image, ok := v.Event.Event.GetPropertyString("Image"); ok {
+		v.Image = image
+	}
+
+	v.Event = nil
+}
+
+// Suppressions generates suppression proposals out of a
+// false positive verdict
+func (v *AlertVerdict) Suppressions() (s []*Suppression) {
+	s = make([]*Suppression, 0, len(v.Signature))
+
+	if !v.IsFalsePositive() || v.Image == "" {
+		return
+	}
+
+	for _, sig := range v.Signature {
+		s = append(s, &Suppression{
+			Signature: sig,
+			Field:     "Image",
+			Value:     v.Image,
+			Verdict:   v.UUID(),
+		})
+	}
+
+	return
+}
+
+// Suppression structure used to suppress recurring false positive
+// detections on endpoints. A detection is suppressed if it matched
+// Signature and if event's Field equals Value.
+type Suppression struct {
+	sod.Item
+	Signature string `json:"signature" sod:"index"`
+	Field     string `json:"field"`
+	Value     string `json:"value"`
+	// only enabled suppressions are pushed to endpoints
+	Enabled bool `json:"enabled"`
+	// verdict at the origin of the suppression
+	Verdict string `json:"verdict-uuid,omitempty"`
+}
+
+// Validate overwrite sod.Item function
+func (s *Suppression) Validate() error {
+	if s.Signature == "" || s.Field == "" {
+		return fmt.Errorf("signature and field fields are mandatory")
+	}
+	return nil
+}
+
+// Key returns a key uniquely identifying a suppression
+func (s *Suppression) Key() string {
+	return strings.ToLower(fmt.Sprintf("%s|%s|%s", s.Signature, s.Field, s.Value))
+}
+
+// Match returns true if the suppression applies to the event
+func (s *Suppression) Match(e *event.EdrEvent) bool {
+	d := e.GetDetection()
+
+	if d == nil || d.Signature == nil || !d.Signature.Contains(s.Signature) {
+		return false
+	}
+
+	if value, ok := e.Event.Event.GetPropertyString(s.Field); ok {
+		return strings.EqualFold(value, s.Value)
+	}
+
+	return false
+}
@@ -11,25 +11,47 @@ import (
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-etw/etw"
 	"github.com/0xrawsec/golang-utils/crypto/data"
-	"github.com/0xrawsec/whids/utils"
 )
 
 var (
 	emptySha1 = strings.Repeat("0", crypto.SHA1.Size()*2)
 )
 
+// AgentData is the state of the agent when an event was processed
+type AgentData struct {
+	Version string `json:",omitempty"`
+	// sha256 of the rule database loaded in engine
+	RulesSha256 string `json:",omitempty"`
+	// hash of the configuration Sysmon was running with
+	SysmonConfigHash string `json:",omitempty"`
+}
+
 type EdrData struct {
 	Endpoint struct {
 		UUID     string
 		IP       string
 		Hostname string
 		Group    string
+		// tags set on the endpoint with tag commands
+		Tags []string `json:",omitempty"`
 	}
 	Event struct {
 		Hash        string
 		Detection   bool
 		ReceiptTime time.Time
+		// original timezone of the event timestamp, as timestamps
+		// get normalized to UTC
+		Timezone string `json:",omitempty"`
+		// offset of original timezone in seconds east of UTC
+		TzOffset int `json:",omitempty"`
+		// identifies the sequence of events forwarded by
+		// an agent, it changes every time agent starts
+		Stream string `json:",omitempty"`
+		// monotonic sequence number of the event in its stream
+		Sequence uint64 `json:",omitempty"`
 	}
+	// it must not be modified as it is shared between events
+	Agent *AgentData `json:",omitempty"`
 }
 
 type InnerEvent struct {
@@ -51,6 +73,29 @@ func (e *EdrEvent) InitEdrData() {
 	e.Event.EdrData = &EdrData{}
 }
 
+// NormalizeTime converts event timestamp to UTC and records
+// the original timezone into EdrData. Original timezone is recorded
+// only once so it is safe to call this function several times.
+func (e *EdrEvent) NormalizeTime() {
+	t := e.Event.System.TimeCreated.SystemTime
+
+	if e.Event.EdrData == nil {
+		e.InitEdrData()
+	}
+
+	if e.Event.EdrData.Event.Timezone == "" {
+		name, offset := t.Zone()
+		// timestamps parsed from JSON may have an unnamed zone
+		if name == "" {
+			name = t.Format("-07:00")
+		}
+		e.Event.EdrData.Event.Timezone = name
+		e.Event.EdrData.Event.TzOffset = offset
+	}
+
+	e.Event.System.TimeCreated.SystemTime = t.UTC()
+}
+
 func (e *EdrEvent) Commit() {
 	if e.Event.EdrData != nil {
 		e.Event.EdrData.Event.Hash = e.Hash()
@@ -61,11 +106,14 @@ func (e *EdrEvent) Hash() string {
 	var b []byte
 	var err error
 
+	enc := GetEncoder()
+	defer PutEncoder(enc)
+
 	edrData := e.Event.EdrData
 	// null out EdrData as it does not come into hash calculation
 	e.Event.EdrData = nil
 
-	b, err = utils.Json(e)
+	b, err = enc.Encode(e)
 	// we restore EdrData
 	e.Event.EdrData = edrData
 	if err != nil {
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/crypto/data"
 	"github.com/0xrawsec/golang-utils/readers"
 	"github.com/0xrawsec/toast"
 )
@@ -228,3 +230,230 @@ func TestEventGetters(t *testing.T) {
 	event.InitEdrData()
 	tt.Assert(event.Event.EdrData.Endpoint.Hostname == "", "Computer name must be empty")
 }
+
+func TestEventProjection(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	p := NewProjection([]string{"TargetFilename"})
+	p.StripATTACK = true
+
+	for e := range emitEvents(1000, false) {
+		h := e.Hash()
+		pe := p.Project(e)
+		// original event must not be modified
+		tt.Assert(h == e.Hash())
+		for k := range pe.Event.EventData {
+			tt.Assert(p.fields[k])
+		}
+		for _, f := range append(CoreFields, "TargetFilename") {
+			_, inOrig := e.Event.EventData[f]
+			_, inProj := pe.Event.EventData[f]
+			tt.Assert(inOrig == inProj)
+		}
+		if pe.Event.Detection != nil {
+			tt.Assert(len(pe.Event.Detection.ATTACK) == 0)
+		}
+		p.Release(pe)
+		// releasing projected event must not modify the original one
+		tt.Assert(h == e.Hash())
+	}
+}
+
+func TestEventNormalizeTime(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	loc := time.FixedZone("", 2*3600)
+
+	for e := range emitEvents(100, true) {
+		ts := e.Timestamp().In(loc)
+		e.Event.System.TimeCreated.SystemTime = ts
+		e.Event.EdrData = nil
+
+		e.NormalizeTime()
+		tt.Assert(e.Timestamp().Location() == time.UTC)
+		tt.Assert(e.Timestamp().Equal(ts))
+		tt.Assert(e.Event.EdrData.Event.Timezone == "+02:00")
+		tt.Assert(e.Event.EdrData.Event.TzOffset == 2*3600)
+
+		// original timezone must be kept
+		e.NormalizeTime()
+		tt.Assert(e.Event.EdrData.Event.Timezone == "+02:00")
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	channel := "Microsoft-Windows-PowerShell/Operational"
+
+	newEvent := func(id uint16, data map[string]interface{}) *EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = channel
+		e.System.EventID = id
+		e.EventData = data
+		return NewEdrEvent(e)
+	}
+
+	r := NewSchemaRegistry(19045)
+	tt.Assert(r.Add(channel, []int64{4104}, 10240, 0, map[string]string{"ScriptBlock": "ScriptBlockText"}))
+	tt.Assert(r.Add(channel, nil, 0, 0, map[string]string{"Host": "HostApplication"}))
+	// not applicable to this build
+	tt.Assert(!r.Add(channel, []int64{4104}, 0, 9600, map[string]string{"Text": "ScriptBlockText"}))
+	tt.Assert(r.Len() == 1)
+
+	e := newEvent(4104, map[string]interface{}{"ScriptBlock": "iex foo", "Host": "powershell.exe"})
+	tt.Assert(r.Apply(e) == 2)
+	tt.Assert(e.GetStringOr(engine.Path("/Event/EventData/ScriptBlockText"), "") == "iex foo")
+	tt.Assert(e.GetStringOr(engine.Path("/Event/EventData/HostApplication"), "") == "powershell.exe")
+	// original fields are kept
+	tt.Assert(e.GetStringOr(engine.Path("/Event/EventData/ScriptBlock"), "") == "iex foo")
+
+	// existing fields are not overwritten
+	e = newEvent(4104, map[string]interface{}{"ScriptBlock": "foo", "ScriptBlockText": "bar"})
+	tt.Assert(r.Apply(e) == 0)
+	tt.Assert(e.GetStringOr(engine.Path("/Event/EventData/ScriptBlockText"), "") == "bar")
+
+	// event ID specific aliases do not apply to other events
+	e = newEvent(4103, map[string]interface{}{"ScriptBlock": "foo"})
+	tt.Assert(r.Apply(e) == 0)
+}
+
+func TestSink(t *testing.T) {
+	tt := toast.FromT(t)
+
+	newEvent := func(channel string, id uint16, crit int) *EdrEvent {
+		e := etw.NewEvent()
+		e.System.Channel = channel
+		e.System.EventID = id
+		e.System.TimeCreated.SystemTime = time.Now()
+		e.EventData["Image"] = `C:\Windows\System32\cmd.exe`
+		ee := NewEdrEvent(e)
+		if crit > 0 {
+			d := engine.NewDetection(false, false)
+			d.Signature.Add("TestRule")
+			d.Criticality = crit
+			ee.SetDetection(d)
+		}
+		return ee
+	}
+
+	sysmon := "Microsoft-Windows-Sysmon/Operational"
+	buf := new(bytes.Buffer)
+
+	s, err := NewSink(buf, SinkFormatJSON, SinkFilter{
+		Channels:       []string{sysmon},
+		EventIDs:       []int64{1},
+		MinCriticality: 5,
+	})
+	tt.CheckErr(err)
+
+	tt.CheckErr(s.Write(newEvent(sysmon, 1, 8)))
+	// filtered out
+	tt.CheckErr(s.Write(newEvent(sysmon, 1, 2)))
+	tt.CheckErr(s.Write(newEvent(sysmon, 1, 0)))
+	tt.CheckErr(s.Write(newEvent(sysmon, 3, 8)))
+	tt.CheckErr(s.Write(newEvent("Security", 1, 8)))
+
+	tt.Assert(bytes.Count(buf.Bytes(), []byte("\n")) == 1)
+	e := EdrEvent{}
+	tt.CheckErr(json.Unmarshal(buf.Bytes(), &e))
+	tt.Assert(e.EventID() == 1)
+
+	// no filter
+	buf.Reset()
+	s, err = NewSink(buf, SinkFormatCSV, SinkFilter{})
+	tt.CheckErr(err)
+	tt.CheckErr(s.Write(newEvent(sysmon, 1, 8)))
+	tt.CheckErr(s.Write(newEvent("Security", 4688, 0)))
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	tt.Assert(len(lines) == 3)
+	tt.Assert(bytes.HasPrefix(lines[0], []byte("Timestamp,Channel")))
+	tt.Assert(bytes.Contains(lines[1], []byte(",8,TestRule,")))
+
+	_, err = NewSink(buf, "xml", SinkFilter{})
+	tt.Assert(err != nil)
+}
+
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	enc := GetEncoder()
+	defer PutEncoder(enc)
+
+	for i := range events {
+		e := &events[i]
+		exp, err := json.Marshal(e)
+		tt.CheckErr(err)
+
+		// JSON shape must be the one of json.Marshal
+		b, err := enc.Encode(e)
+		tt.CheckErr(err)
+		tt.Assert(bytes.Equal(b, append(exp, '\n')))
+
+		// hash must not change
+		edrData := e.Event.EdrData
+		e.Event.EdrData = nil
+		exp, err = json.Marshal(e)
+		e.Event.EdrData = edrData
+		tt.CheckErr(err)
+		tt.Assert(e.Hash() == data.Sha1(exp))
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	e := events[0].Copy()
+
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = append(buf, '\n')
+		}
+	})
+
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc := GetEncoder()
+			if _, err := enc.Encode(e); err != nil {
+				b.Fatal(err)
+			}
+			PutEncoder(enc)
+		}
+	})
+}
+
+func BenchmarkHash(b *testing.B) {
+	e := events[0].Copy()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.Hash()
+	}
+}
+
+func BenchmarkProjection(b *testing.B) {
+	e := events[0].Copy()
+	p := NewProjection([]string{"TargetFilename"})
+
+	b.Run("NoRelease", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.Project(e)
+		}
+	})
+
+	b.Run("Release", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.Release(p.Project(e))
+		}
+	})
+}
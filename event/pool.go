@@ -0,0 +1,54 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+const (
+	// buffers grown above this size are not put back into the pool
+	// so that a few big events do not keep memory allocated
+	maxPooledBufferSize = 64 * 1024
+)
+
+// Encoder encodes events to JSON into a reusable buffer
+type Encoder struct {
+	buf *bytes.Buffer
+	enc *json.Encoder
+}
+
+var (
+	encoderPool = sync.Pool{
+		New: func() interface{} {
+			buf := new(bytes.Buffer)
+			return &Encoder{buf: buf, enc: json.NewEncoder(buf)}
+		},
+	}
+)
+
+// GetEncoder returns an Encoder from the pool, it must be
+// put back with PutEncoder once encoded data is not used anymore
+func GetEncoder() *Encoder {
+	return encoderPool.Get().(*Encoder)
+}
+
+// PutEncoder puts an Encoder back into the pool
+func PutEncoder(e *Encoder) {
+	if e.buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	encoderPool.Put(e)
+}
+
+// Encode encodes i, the same way json.Marshal does, and returns the
+// encoded data followed by a newline. Returned slice is only valid
+// until the next call to Encode or until the Encoder is put back into
+// the pool.
+func (e *Encoder) Encode(i interface{}) ([]byte, error) {
+	e.buf.Reset()
+	if err := e.enc.Encode(i); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
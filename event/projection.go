@@ -0,0 +1,114 @@
+package event
+
+import "sync"
+
+var (
+	// CoreFields are the EventData fields always kept by a Projection, these
+	// are the minimum fields needed to correlate an event on the manager side
+	CoreFields = []string{
+		"ProcessGuid",
+		"ProcessId",
+		"Image",
+		"CommandLine",
+		"User",
+		"Hashes",
+		"ParentProcessGuid",
+		"ParentImage",
+		"UtcTime",
+		// provenance of IoC matched by IoC detections
+		"IoCValue",
+		"IoCSource",
+		"IoCConfidence",
+	}
+)
+
+var (
+	// maps of projected events, they are put back into the pool by Release
+	mapPool = sync.Pool{
+		New: func() interface{} {
+			return make(map[string]interface{})
+		},
+	}
+)
+
+// Projection strips out unwanted fields from events
+type Projection struct {
+	fields      map[string]bool
+	StripATTACK bool
+	StripExtra  bool
+}
+
+// NewProjection creates a new Projection keeping only fields (in addition
+// to CoreFields) in event's EventData and UserData sections. If fields
+// is empty all EventData and UserData fields are kept.
+func NewProjection(fields []string) *Projection {
+	p := &Projection{}
+	if len(fields) > 0 {
+		p.fields = make(map[string]bool)
+		for _, f := range CoreFields {
+			p.fields[f] = true
+		}
+		for _, f := range fields {
+			p.fields[f] = true
+		}
+	}
+	return p
+}
+
+func (p *Projection) project(m map[string]interface{}) map[string]interface{} {
+	if p.fields == nil || m == nil {
+		return m
+	}
+
+	out := mapPool.Get().(map[string]interface{})
+	for k, v := range m {
+		if p.fields[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Project returns a projected copy of e, e is not modified
+func (p *Projection) Project(e *EdrEvent) *EdrEvent {
+	etwEvent := *e.Event.Event
+
+	etwEvent.EventData = p.project(e.Event.EventData)
+	etwEvent.UserData = p.project(e.Event.UserData)
+
+	// ExtendedData is redundant with other fields
+	if p.StripExtra {
+		etwEvent.ExtendedData = nil
+	}
+
+	new := &EdrEvent{InnerEvent{Event: &etwEvent, EdrData: e.Event.EdrData}}
+
+	if d := e.Event.Detection; d != nil {
+		// we copy detection not to modify the original one
+		det := *d
+		if p.StripATTACK {
+			det.ATTACK = nil
+		}
+		new.Event.Detection = &det
+	}
+
+	return new
+}
+
+// Release puts back into the pool the structures allocated for an event
+// returned by Project, the event must not be used afterwards
+func (p *Projection) Release(e *EdrEvent) {
+	// original maps are kept when there is no field to strip out
+	if p.fields == nil {
+		return
+	}
+
+	for _, m := range []map[string]interface{}{e.Event.EventData, e.Event.UserData} {
+		if m != nil {
+			for k := range m {
+				delete(m, k)
+			}
+			mapPool.Put(m)
+		}
+	}
+}
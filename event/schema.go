@@ -0,0 +1,115 @@
+package event
+
+import (
+	"sort"
+)
+
+type alias struct {
+	from string
+	to   string
+}
+
+// SchemaRegistry holds field aliases to apply to events of given channels
+// and event IDs so that a single rule can match events whose field names
+// differ across OS versions
+type SchemaRegistry struct {
+	build int
+	// channel -> event id -> aliases
+	aliases map[string]map[int64][]alias
+	// channel -> aliases applying to any event id
+	any map[string][]alias
+}
+
+// NewSchemaRegistry creates a new SchemaRegistry for an OS build number, a
+// build number of 0 means unknown build
+func NewSchemaRegistry(build int) *SchemaRegistry {
+	return &SchemaRegistry{
+		build:   build,
+		aliases: make(map[string]map[int64][]alias),
+		any:     make(map[string][]alias),
+	}
+}
+
+// Add adds field aliases (alias -> canonical name) applying to events of a
+// channel with given event IDs (any event if empty). Aliases are discarded
+// if registry build number is not in [minBuild; maxBuild], a zero bound is
+// not checked. It returns true if aliases are applicable.
+func (r *SchemaRegistry) Add(channel string, eventIDs []int64, minBuild, maxBuild int, aliases map[string]string) bool {
+	if r.build != 0 {
+		if minBuild != 0 && r.build < minBuild {
+			return false
+		}
+		if maxBuild != 0 && r.build > maxBuild {
+			return false
+		}
+	}
+
+	// sorting aliases so that they are applied in a deterministic order
+	sorted := make([]alias, 0, len(aliases))
+	for from, to := range aliases {
+		sorted = append(sorted, alias{from, to})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].from < sorted[j].from })
+
+	if len(eventIDs) == 0 {
+		r.any[channel] = append(r.any[channel], sorted...)
+		return true
+	}
+
+	if _, ok := r.aliases[channel]; !ok {
+		r.aliases[channel] = make(map[int64][]alias)
+	}
+
+	for _, id := range eventIDs {
+		r.aliases[channel][id] = append(r.aliases[channel][id], sorted...)
+	}
+
+	return true
+}
+
+// Len returns the number of channels with aliases
+func (r *SchemaRegistry) Len() int {
+	channels := make(map[string]bool)
+	for c := range r.aliases {
+		channels[c] = true
+	}
+	for c := range r.any {
+		channels[c] = true
+	}
+	return len(channels)
+}
+
+func apply(m map[string]interface{}, aliases []alias) (n int) {
+	if m == nil {
+		return
+	}
+
+	for _, a := range aliases {
+		if v, ok := m[a.from]; ok {
+			// we never overwrite an existing field
+			if _, ok := m[a.to]; !ok {
+				m[a.to] = v
+				n++
+			}
+		}
+	}
+
+	return
+}
+
+// Apply sets canonical fields of e from their aliases, original fields are
+// kept. It returns the number of fields set.
+func (r *SchemaRegistry) Apply(e *EdrEvent) (n int) {
+	channel := e.Channel()
+
+	// event ID specific aliases take precedence
+	if ids, ok := r.aliases[channel]; ok {
+		n += apply(e.Event.EventData, ids[e.EventID()])
+		n += apply(e.Event.UserData, ids[e.EventID()])
+	}
+
+	n += apply(e.Event.EventData, r.any[channel])
+	n += apply(e.Event.UserData, r.any[channel])
+
+	return
+}
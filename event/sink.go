@@ -0,0 +1,164 @@
+package event
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SinkFormatJSON writes one JSON event per line
+	SinkFormatJSON = "json"
+	// SinkFormatPretty writes indented JSON events
+	SinkFormatPretty = "pretty"
+	// SinkFormatCSV writes one CSV record per event
+	SinkFormatCSV = "csv"
+)
+
+var (
+	// SinkCSVHeader header of CSV formatted sinks
+	SinkCSVHeader = []string{"Timestamp", "Channel", "EventID", "Computer", "Criticality", "Signatures", "Data"}
+)
+
+// SinkFilter controls which events are written to a Sink, an empty
+// filter lets all events through
+type SinkFilter struct {
+	Channels       []string
+	EventIDs       []int64
+	MinCriticality int
+}
+
+// Sink writes events matching a filter to a writer, it is safe for
+// concurrent use
+type Sink struct {
+	sync.Mutex
+	w        io.Writer
+	csv      *csv.Writer
+	format   string
+	channels map[string]bool
+	ids      map[int64]bool
+	minCrit  int
+}
+
+// NewSink creates a new Sink writing events in format to w
+func NewSink(w io.Writer, format string, f SinkFilter) (s *Sink, err error) {
+	s = &Sink{
+		w:        w,
+		format:   strings.ToLower(format),
+		channels: make(map[string]bool),
+		ids:      make(map[int64]bool),
+		minCrit:  f.MinCriticality,
+	}
+
+	switch s.format {
+	case "":
+		s.format = SinkFormatJSON
+	case SinkFormatJSON, SinkFormatPretty:
+	case SinkFormatCSV:
+		s.csv = csv.NewWriter(w)
+		if err = s.csv.Write(SinkCSVHeader); err != nil {
+			return
+		}
+		s.csv.Flush()
+	default:
+		return nil, fmt.Errorf("unknown sink format %s", format)
+	}
+
+	for _, c := range f.Channels {
+		s.channels[c] = true
+	}
+
+	for _, id := range f.EventIDs {
+		s.ids[id] = true
+	}
+
+	return
+}
+
+// Match returns true if the event passes sink filter
+func (s *Sink) Match(e *EdrEvent) bool {
+	if len(s.channels) > 0 && !s.channels[e.Channel()] {
+		return false
+	}
+
+	if len(s.ids) > 0 && !s.ids[e.EventID()] {
+		return false
+	}
+
+	if s.minCrit > 0 {
+		if d := e.GetDetection(); d == nil || d.Criticality < s.minCrit {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Sink) record(e *EdrEvent) (r []string, err error) {
+	var data []byte
+	var crit int
+	var sigs []string
+
+	if d := e.GetDetection(); d != nil {
+		crit = d.Criticality
+		sigs = d.Names()
+	}
+
+	if data, err = json.Marshal(e.Event.EventData); err != nil {
+		return
+	}
+
+	return []string{
+		e.Timestamp().Format(time.RFC3339Nano),
+		e.Channel(),
+		fmt.Sprint(e.EventID()),
+		e.Computer(),
+		fmt.Sprint(crit),
+		strings.Join(sigs, ";"),
+		string(data),
+	}, nil
+}
+
+// Write writes an event to the sink if it matches sink filter
+func (s *Sink) Write(e *EdrEvent) (err error) {
+	var b []byte
+
+	if !s.Match(e) {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch s.format {
+	case SinkFormatCSV:
+		var r []string
+		if r, err = s.record(e); err != nil {
+			return
+		}
+		if err = s.csv.Write(r); err != nil {
+			return
+		}
+		s.csv.Flush()
+		return s.csv.Error()
+	case SinkFormatPretty:
+		if b, err = json.MarshalIndent(e, "", "    "); err == nil {
+			b = append(b, '\n')
+		}
+	default:
+		enc := GetEncoder()
+		defer PutEncoder(enc)
+		b, err = enc.Encode(e)
+	}
+
+	if err != nil {
+		return
+	}
+
+	_, err = s.w.Write(b)
+	return
+}
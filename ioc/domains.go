@@ -0,0 +1,114 @@
+package ioc
+
+import (
+	"strings"
+)
+
+const (
+	// WildcardPrefix prefix of domain IoCs matching subdomains only (i.e. *.evil.com)
+	WildcardPrefix = "*."
+)
+
+// NormalizeDomain returns the canonical form of a domain name
+func NormalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+type domainNode struct {
+	children map[string]*domainNode
+	// IoC value matching the domain itself
+	exact string
+	// IoC value matching any subdomain of the domain
+	sub string
+}
+
+func newDomainNode() *domainNode {
+	return &domainNode{children: make(map[string]*domainNode)}
+}
+
+// DomainTree is a tree of domain labels stored in reverse order (i.e.
+// com -> evil -> www) allowing to find domain IoCs matching a name or
+// one of its parent domains in a number of steps bounded by the number
+// of labels of the name, whatever the number of IoCs
+type DomainTree struct {
+	root *domainNode
+	len  int
+}
+
+// NewDomainTree creates a new empty DomainTree
+func NewDomainTree() *DomainTree {
+	return &DomainTree{root: newDomainNode()}
+}
+
+func labels(domain string) []string {
+	return strings.Split(domain, ".")
+}
+
+// Insert inserts a domain IoC value. Wildcard values (i.e. *.evil.com)
+// match subdomains only. Other values match the domain itself and also
+// its subdomains if subdomains is true.
+func (t *DomainTree) Insert(value string, subdomains bool) {
+	domain := NormalizeDomain(value)
+	wildcard := strings.HasPrefix(domain, WildcardPrefix)
+	domain = strings.TrimPrefix(domain, WildcardPrefix)
+
+	if domain == "" {
+		return
+	}
+
+	n := t.root
+	l := labels(domain)
+	for i := len(l) - 1; i >= 0; i-- {
+		child, ok := n.children[l[i]]
+		if !ok {
+			child = newDomainNode()
+			n.children[l[i]] = child
+		}
+		n = child
+	}
+
+	if n.exact == "" && n.sub == "" {
+		t.len++
+	}
+
+	if !wildcard {
+		n.exact = value
+	}
+
+	if wildcard || subdomains {
+		n.sub = value
+	}
+}
+
+// Match returns the most specific IoC value matching name
+func (t *DomainTree) Match(name string) (value string, ok bool) {
+	name = NormalizeDomain(name)
+
+	if name == "" {
+		return
+	}
+
+	n := t.root
+	l := labels(name)
+	for i := len(l) - 1; i >= 0; i-- {
+		// deepest parent domain matching subdomains
+		if n.sub != "" {
+			value, ok = n.sub, true
+		}
+
+		if n = n.children[l[i]]; n == nil {
+			return
+		}
+	}
+
+	if n.exact != "" {
+		return n.exact, true
+	}
+
+	return
+}
+
+// Len returns the number of domains in the tree
+func (t *DomainTree) Len() int {
+	return t.len
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"net"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/sod"
@@ -38,6 +40,8 @@ const (
 	TypeDomain   = "domain"
 	TypeHostname = "hostname"
 	TypeIpDst    = "ip-dst"
+	TypeJA3      = "ja3"
+	TypeJA3S     = "ja3s"
 )
 
 type IOC struct {
@@ -45,9 +49,14 @@ type IOC struct {
 	Uuid string `json:"uuid" sod:"unique,lower"`
 	// GroupUuid can be used to group IoCs
 	GroupUuid string `json:"guuid" sod:"index,lower"`
-	Source    string `json:"source" sod:"index"`
-	Value     string `json:"value" sod:"index"`
-	Type      string `json:"type" sod:"index,lower"`
+	// Source is the feed the IoC comes from
+	Source string `json:"source" sod:"index"`
+	Value  string `json:"value" sod:"index"`
+	Type   string `json:"type" sod:"index,lower"`
+	// Confidence in the IoC in [0;100]
+	Confidence int `json:"confidence"`
+	// Expiry time of the IoC, zero value means it never expires
+	Expiry time.Time `json:"expiry"`
 }
 
 func HasValidType(ioc *IOC) bool {
@@ -57,7 +66,8 @@ func HasValidType(ioc *IOC) bool {
 		TypeImphash,
 		TypeDomain,
 		TypeHostname,
-		TypeIpDst:
+		TypeIpDst,
+		TypeJA3, TypeJA3S:
 		return true
 	default:
 		return false
@@ -69,8 +79,13 @@ func (ioc *IOC) Transform() {
 	ioc.GroupUuid = strings.ToLower(ioc.GroupUuid)
 
 	switch ioc.Type {
-	case TypeMd5, TypeSha1, TypeSha256, TypeImphash:
+	case TypeMd5, TypeSha1, TypeSha256, TypeImphash, TypeJA3, TypeJA3S:
 		ioc.Value = strings.ToLower(ioc.Value)
+	case TypeIpDst:
+		// IPv6 addresses have several representations
+		ioc.Value = utils.CanonicalIP(ioc.Value)
+	case TypeDomain, TypeHostname:
+		ioc.Value = NormalizeDomain(ioc.Value)
 	}
 }
 
@@ -87,6 +102,9 @@ func (ioc *IOC) Validate() error {
 	if ioc.Value == "" {
 		return fmt.Errorf("value must not be empty")
 	}
+	if ioc.Confidence < 0 || ioc.Confidence > 100 {
+		return fmt.Errorf("confidence must be in [0;100]")
+	}
 
 	// validating IoC types
 	if !HasValidType(ioc) {
@@ -95,7 +113,7 @@ func (ioc *IOC) Validate() error {
 		validRe := reAny
 
 		switch ioc.Type {
-		case TypeMd5, TypeImphash:
+		case TypeMd5, TypeImphash, TypeJA3, TypeJA3S:
 			validRe = reMd5
 		case TypeSha1:
 			validRe = reSha1
@@ -105,20 +123,56 @@ func (ioc *IOC) Validate() error {
 		if !validRe.MatchString(ioc.Value) {
 			return fmt.Errorf("%s not valid", ioc.Type)
 		}
+		if ioc.Type == TypeIpDst && net.ParseIP(ioc.Value) == nil {
+			return fmt.Errorf("%s not valid", ioc.Type)
+		}
+		// wildcard is only allowed as first label
+		if (ioc.Type == TypeDomain || ioc.Type == TypeHostname) &&
+			strings.Contains(strings.TrimPrefix(ioc.Value, WildcardPrefix), "*") {
+			return fmt.Errorf("%s not valid, wildcard must be the first label", ioc.Type)
+		}
 	}
 	return nil
 }
 
+// ExpiredAt returns true if IoC is expired at time t
+func (ioc *IOC) ExpiredAt(t time.Time) bool {
+	return !ioc.Expiry.IsZero() && t.After(ioc.Expiry)
+}
+
+// Expired returns true if IoC is expired
+func (ioc *IOC) Expired() bool {
+	return ioc.ExpiredAt(time.Now())
+}
+
+// expiresAfter returns true if ioc expires after other
+func (ioc *IOC) expiresAfter(other *IOC) bool {
+	switch {
+	case ioc.Expiry.IsZero():
+		return !other.Expiry.IsZero()
+	case other.Expiry.IsZero():
+		return false
+	}
+	return ioc.Expiry.After(other.Expiry)
+}
+
 type IoCs struct {
 	sync.Mutex
-	iocs   *datastructs.Set
-	sha256 hash.Hash
+	iocs *datastructs.Set
+	// IoC entries by value, used to retrieve
+	// provenance information of a value
+	entries map[string]*IOC
+	// domain IoCs, used to match subdomains
+	domains *DomainTree
+	sha256  hash.Hash
 }
 
 func NewIocs() *IoCs {
 	return &IoCs{
-		iocs:   datastructs.NewSet(),
-		sha256: sha256.New(),
+		iocs:    datastructs.NewSet(),
+		entries: make(map[string]*IOC),
+		domains: NewDomainTree(),
+		sha256:  sha256.New(),
 	}
 }
 
@@ -130,6 +184,25 @@ func (i *IoCs) reHash() {
 	}
 }
 
+// rebuild replaces the content of the container, it
+// must be called with IoCs locked
+func (i *IoCs) rebuild(iocs []*IOC) {
+	i.iocs = datastructs.NewSet()
+	i.entries = make(map[string]*IOC)
+	i.domains = NewDomainTree()
+	for _, ioc := range iocs {
+		i.add(ioc)
+	}
+	i.reHash()
+}
+
+// Replace replaces the content of the container with iocs
+func (i *IoCs) Replace(iocs ...*IOC) {
+	i.Lock()
+	defer i.Unlock()
+	i.rebuild(iocs)
+}
+
 func (i *IoCs) FromDB(db *sod.DB) error {
 	if objects, err := db.All(&IOC{}); err != nil {
 		return err
@@ -142,6 +215,44 @@ func (i *IoCs) FromDB(db *sod.DB) error {
 	return nil
 }
 
+// Prune deletes expired IoCs from db and rebuilds
+// the container out of the remaining ones
+func (i *IoCs) Prune(db *sod.DB) (expired []*IOC, err error) {
+	var objects []sod.Object
+
+	if objects, err = db.All(&IOC{}); err != nil {
+		return
+	}
+
+	now := time.Now()
+	expired = make([]*IOC, 0)
+	valid := make([]*IOC, 0, len(objects))
+	for _, o := range objects {
+		ioc := o.(*IOC)
+		if ioc.ExpiredAt(now) {
+			expired = append(expired, ioc)
+			continue
+		}
+		valid = append(valid, ioc)
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, ioc := range expired {
+		if err = db.Delete(ioc); err != nil {
+			return
+		}
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	i.rebuild(valid)
+
+	return
+}
+
 func (i *IoCs) StringSlice() (s []string) {
 	i.Lock()
 	defer i.Unlock()
@@ -152,17 +263,82 @@ func (i *IoCs) StringSlice() (s []string) {
 	return
 }
 
+// Entries returns IoC entries, in the same order as StringSlice
+func (i *IoCs) Entries() (s []*IOC) {
+	i.Lock()
+	defer i.Unlock()
+	s = make([]*IOC, 0, len(i.entries))
+	for _, ii := range i.iocs.SortSlice() {
+		s = append(s, i.entries[ii.(string)])
+	}
+	return
+}
+
+// Get returns the IoC entry matching value
+func (i *IoCs) Get(value string) (ioc *IOC, ok bool) {
+	i.Lock()
+	defer i.Unlock()
+	ioc, ok = i.entries[value]
+	return
+}
+
+// MatchDomain returns the domain IoC entry matching name,
+// either exactly or as one of its parent domains
+func (i *IoCs) MatchDomain(name string) (ioc *IOC, ok bool) {
+	var value string
+
+	i.Lock()
+	defer i.Unlock()
+
+	if value, ok = i.domains.Match(name); ok {
+		ioc, ok = i.entries[value]
+	}
+	return
+}
+
+// Len returns the number of IoC values in the container
+func (i *IoCs) Len() int {
+	i.Lock()
+	defer i.Unlock()
+	return i.iocs.Len()
+}
+
+func (i *IoCs) add(ioc *IOC) {
+	// expired IoCs are never added
+	if ioc.Expired() {
+		return
+	}
+
+	// if we don't make this check Add method
+	// changes the order of insertion which is not
+	// in line anymore with sha256 computation
+	if !i.iocs.Contains(ioc.Value) {
+		i.iocs.Add(ioc.Value)
+		i.entries[ioc.Value] = ioc
+		i.sha256.Write([]byte(ioc.Value))
+		i.addDomain(ioc)
+	} else if ioc.expiresAfter(i.entries[ioc.Value]) {
+		// we keep the entry living the longest
+		i.entries[ioc.Value] = ioc
+	}
+}
+
+// addDomain inserts ioc in the domain tree, domains match their subdomains
+// while hostnames only match themselves (unless given as wildcard)
+func (i *IoCs) addDomain(ioc *IOC) {
+	switch ioc.Type {
+	case TypeDomain:
+		i.domains.Insert(ioc.Value, true)
+	case TypeHostname:
+		i.domains.Insert(ioc.Value, false)
+	}
+}
+
 func (i *IoCs) Add(iocs ...*IOC) {
 	i.Lock()
 	defer i.Unlock()
 	for _, ioc := range iocs {
-		// if we don't make this check Add method
-		// changes the order of insertion which is not
-		// in line anymore with sha256 computation
-		if !i.iocs.Contains(ioc.Value) {
-			i.iocs.Add(ioc.Value)
-			i.sha256.Write([]byte(ioc.Value))
-		}
+		i.add(ioc)
 	}
 }
 
@@ -171,6 +347,12 @@ func (i *IoCs) Del(iocs ...*IOC) {
 	defer i.Unlock()
 	for _, ioc := range iocs {
 		i.iocs.Del(ioc.Value)
+		delete(i.entries, ioc.Value)
+	}
+	// domain tree does not support deletion
+	i.domains = NewDomainTree()
+	for _, ioc := range i.entries {
+		i.addDomain(ioc)
 	}
 	i.reHash()
 }
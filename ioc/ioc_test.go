@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/0xrawsec/sod"
 	"github.com/0xrawsec/toast"
@@ -112,3 +113,163 @@ func TestIocs(t *testing.T) {
 	hashSlice = utils.Sha256StringSlice(iocs.StringSlice())
 	tt.Assert(iocs.Hash() == hashSlice, format("hash is not stable: iocs.Hash=%s hashSlice=%s", iocs.Hash(), hashSlice))
 }
+
+func TestIocsPrune(t *testing.T) {
+	var db *sod.DB
+	tt := toast.FromT(t)
+
+	iocs := NewIocs()
+
+	db = createIocDB(t, 100)
+	defer db.Drop()
+
+	feed := "ExpiringFeed"
+	expiring := &IOC{
+		Uuid:       uuidGen(),
+		GroupUuid:  uuidGen(),
+		Source:     feed,
+		Value:      "expiring.some.domain",
+		Type:       TypeDomain,
+		Confidence: 50,
+		Expiry:     time.Now().Add(time.Second),
+	}
+	expired := &IOC{
+		Uuid:      uuidGen(),
+		GroupUuid: uuidGen(),
+		Source:    feed,
+		Value:     "expired.some.domain",
+		Type:      TypeDomain,
+		Expiry:    time.Now().Add(-time.Second),
+	}
+	_, err := db.InsertOrUpdateMany(expiring, expired)
+	tt.CheckErr(err)
+	tt.CheckErr(iocs.FromDB(db))
+
+	// expired IoCs are never added
+	_, ok := iocs.Get(expired.Value)
+	tt.Assert(!ok)
+
+	// provenance information is kept
+	entry, ok := iocs.Get(expiring.Value)
+	tt.Assert(ok)
+	tt.Assert(entry.Source == feed && entry.Confidence == 50)
+
+	pruned, err := iocs.Prune(db)
+	tt.CheckErr(err)
+	tt.Assert(len(pruned) == 1)
+
+	time.Sleep(time.Second)
+
+	pruned, err = iocs.Prune(db)
+	tt.CheckErr(err)
+	tt.Assert(len(pruned) == 1)
+	tt.Assert(pruned[0].Value == expiring.Value)
+
+	_, ok = iocs.Get(expiring.Value)
+	tt.Assert(!ok)
+
+	n, err := db.Count(&IOC{})
+	tt.CheckErr(err)
+	tt.Assert(n == 100)
+	tt.Assert(iocs.Len() == len(iocs.Entries()))
+
+	hashSlice := utils.Sha256StringSlice(iocs.StringSlice())
+	tt.Assert(iocs.Hash() == hashSlice, format("hash is not stable: iocs.Hash=%s hashSlice=%s", iocs.Hash(), hashSlice))
+}
+
+func TestIocIPv6(t *testing.T) {
+	tt := toast.FromT(t)
+
+	ioc := IOC{
+		Uuid:      uuidGen(),
+		GroupUuid: uuidGen(),
+		Source:    "Whatever",
+		Value:     "2001:0DB8:0:0:0:0:0:1",
+		Type:      TypeIpDst,
+	}
+
+	ioc.Transform()
+	tt.CheckErr(ioc.Validate())
+	tt.Assert(ioc.Value == "2001:db8::1")
+
+	ioc.Value = "not an ip"
+	tt.Assert(ioc.Validate() != nil)
+}
+
+func TestDomainTree(t *testing.T) {
+	tt := toast.FromT(t)
+
+	tree := NewDomainTree()
+	tree.Insert("evil.com", true)
+	tree.Insert("*.rotating.net", false)
+	tree.Insert("c2.legit.org", false)
+	tree.Insert("*.cdn.legit.org", false)
+
+	tt.Assert(tree.Len() == 4)
+
+	for name, expected := range map[string]string{
+		// domain matches itself and its subdomains
+		"evil.com":            "evil.com",
+		"a.b.c.d.evil.com":    "evil.com",
+		"WWW.Evil.Com.":       "evil.com",
+		"x1f3.rotating.net":   "*.rotating.net",
+		"a.x1f3.rotating.net": "*.rotating.net",
+		"c2.legit.org":        "c2.legit.org",
+		"abc.cdn.legit.org":   "*.cdn.legit.org",
+	} {
+		value, ok := tree.Match(name)
+		tt.Assert(ok, name)
+		tt.Assert(value == expected, format("%s matched %s instead of %s", name, value, expected))
+	}
+
+	for _, name := range []string{
+		"notevil.com",
+		"com",
+		// wildcard does not match the domain itself
+		"rotating.net",
+		// hostnames do not match subdomains
+		"x.c2.legit.org",
+		"legit.org",
+		"cdn.legit.org",
+		"",
+	} {
+		_, ok := tree.Match(name)
+		tt.Assert(!ok, name)
+	}
+}
+
+func TestIocDomains(t *testing.T) {
+	tt := toast.FromT(t)
+
+	iocs := NewIocs()
+	domains := []*IOC{
+		{Uuid: uuidGen(), GroupUuid: uuidGen(), Source: "Feed", Value: "Evil.COM.", Type: TypeDomain},
+		{Uuid: uuidGen(), GroupUuid: uuidGen(), Source: "Feed", Value: "*.rotating.net", Type: TypeDomain},
+		{Uuid: uuidGen(), GroupUuid: uuidGen(), Source: "Feed", Value: "c2.legit.org", Type: TypeHostname},
+	}
+
+	for _, ioc := range domains {
+		ioc.Transform()
+		tt.CheckErr(ioc.Validate())
+	}
+	tt.Assert(domains[0].Value == "evil.com")
+
+	iocs.Add(domains...)
+
+	i, ok := iocs.MatchDomain("www.evil.com")
+	tt.Assert(ok && i.Source == "Feed")
+	_, ok = iocs.MatchDomain("x7ae.rotating.net")
+	tt.Assert(ok)
+	_, ok = iocs.MatchDomain("www.c2.legit.org")
+	tt.Assert(!ok)
+
+	iocs.Del(domains[0])
+	_, ok = iocs.MatchDomain("www.evil.com")
+	tt.Assert(!ok)
+	_, ok = iocs.MatchDomain("x7ae.rotating.net")
+	tt.Assert(ok)
+
+	// wildcard only allowed as first label
+	bad := IOC{Uuid: uuidGen(), GroupUuid: uuidGen(), Source: "Feed", Value: "www.*.evil.com", Type: TypeDomain}
+	tt.Assert(bad.Validate() != nil)
+}
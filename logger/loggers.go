@@ -127,14 +127,20 @@ func (l *EventLogger) InitTransaction() (id TransactionId) {
 // WriteEvent writes an event to an IndexedLogfile chosen according to
 // the internal algorithm of the EventLogger
 func (l *EventLogger) WriteEvent(id TransactionId, key string, evt *event.EdrEvent) (n int, err error) {
-	var il *IndexedLogfile
 	var re *RawEvent
 
-	if l.transacting == id {
-		if re, err = NewRawEvent(evt); err != nil {
-			return
-		}
+	if re, err = NewRawEvent(evt); err != nil {
+		return
+	}
 
+	return l.WriteRawEvent(id, key, re)
+}
+
+// WriteRawEvent writes an already encoded event, it works as WriteEvent
+func (l *EventLogger) WriteRawEvent(id TransactionId, key string, re *RawEvent) (n int, err error) {
+	var il *IndexedLogfile
+
+	if l.transacting == id {
 		if il, err = l.openLogfile(re.Timestamp, key); err != nil {
 			return 0, fmt.Errorf("failed to open logfile: %w", err)
 		}
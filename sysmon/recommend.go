@@ -0,0 +1,296 @@
+package sysmon
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// RecommendedRuleGroup name of the rule group used to hold recommended
+	// exclusions when they cannot be added to an existing exclude filter
+	RecommendedRuleGroup = "Recommended exclusions"
+
+	recommendCondition = "begin with"
+)
+
+// RecommendField describes on which event field recommendations are made
+// for a given Sysmon event ID
+type RecommendField struct {
+	// name of the event filter in Sysmon configuration
+	Filter string
+	// name of the event field (and filter field) grouped on
+	Field string
+}
+
+var (
+	// RecommendFields maps Sysmon event IDs to the field we group events on
+	RecommendFields = map[int]RecommendField{
+		1:  {"ProcessCreate", "Image"},
+		2:  {"FileCreateTime", "TargetFilename"},
+		3:  {"NetworkConnect", "Image"},
+		5:  {"ProcessTerminate", "Image"},
+		6:  {"DriverLoad", "ImageLoaded"},
+		7:  {"ImageLoad", "ImageLoaded"},
+		8:  {"CreateRemoteThread", "SourceImage"},
+		9:  {"RawAccessRead", "Image"},
+		10: {"ProcessAccess", "SourceImage"},
+		11: {"FileCreate", "TargetFilename"},
+		12: {"RegistryEvent", "TargetObject"},
+		13: {"RegistryEvent", "TargetObject"},
+		14: {"RegistryEvent", "TargetObject"},
+		15: {"FileCreateStreamHash", "TargetFilename"},
+		17: {"PipeEvent", "Image"},
+		18: {"PipeEvent", "Image"},
+		22: {"DnsQuery", "Image"},
+		23: {"FileDelete", "TargetFilename"},
+		26: {"FileDeleteDetected", "TargetFilename"},
+	}
+)
+
+// directory returns the parent directory of a Windows path or registry key
+func directory(path string) string {
+	if i := strings.LastIndex(path, `\`); i > 0 {
+		return path[:i+1]
+	}
+	return ""
+}
+
+// Recommendation is a Sysmon configuration change recommended after
+// analyzing observed events
+type Recommendation struct {
+	EventID   int     `json:"event-id"`
+	Filter    string  `json:"filter"`
+	Field     string  `json:"field"`
+	Condition string  `json:"condition"`
+	Value     string  `json:"value"`
+	Count     int     `json:"count"`
+	Share     float64 `json:"share"`
+	Message   string  `json:"message"`
+}
+
+type group struct {
+	count    int
+	detected int
+}
+
+// Recommender analyzes event volumes and rule coverage to recommend
+// Sysmon configuration exclusions
+type Recommender struct {
+	total  int
+	groups map[int]map[string]*group
+}
+
+// NewRecommender creates a new Recommender
+func NewRecommender() *Recommender {
+	return &Recommender{groups: make(map[int]map[string]*group)}
+}
+
+// Total returns the number of events accounted
+func (r *Recommender) Total() int {
+	return r.total
+}
+
+// Add accounts an event with its ID, the value of the field corresponding
+// to the event ID in RecommendFields and whether it matched a rule
+func (r *Recommender) Add(eventID int, value string, detected bool) {
+	r.total++
+
+	if _, ok := RecommendFields[eventID]; !ok {
+		return
+	}
+
+	dir := strings.ToLower(directory(value))
+	if dir == "" {
+		return
+	}
+
+	if _, ok := r.groups[eventID]; !ok {
+		r.groups[eventID] = make(map[string]*group)
+	}
+
+	g, ok := r.groups[eventID][dir]
+	if !ok {
+		g = &group{}
+		r.groups[eventID][dir] = g
+	}
+
+	g.count++
+	if detected {
+		g.detected++
+	}
+}
+
+// Recommend returns exclusions for groups of events representing at least
+// minShare of the total volume and matching no rule, sorted by decreasing
+// volume
+func (r *Recommender) Recommend(minShare float64) (recs []*Recommendation) {
+	recs = make([]*Recommendation, 0)
+
+	if r.total == 0 {
+		return
+	}
+
+	for id, groups := range r.groups {
+		rf := RecommendFields[id]
+		for dir, g := range groups {
+			share := float64(g.count) / float64(r.total)
+			if g.detected > 0 || share < minShare {
+				continue
+			}
+			recs = append(recs, &Recommendation{
+				EventID:   id,
+				Filter:    rf.Filter,
+				Field:     rf.Field,
+				Condition: recommendCondition,
+				Value:     dir,
+				Count:     g.count,
+				Share:     share,
+				Message: fmt.Sprintf("EventID %d from directory %s is %.0f%% of volume and matches no rules",
+					id, dir, share*100),
+			})
+		}
+	}
+
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Count == recs[j].Count {
+			return recs[i].Value < recs[j].Value
+		}
+		return recs[i].Count > recs[j].Count
+	})
+
+	return
+}
+
+// addExclusion adds an exclusion filter to filters, it returns false if
+// the exclusion cannot be added because the event filter is not an exclude one
+func addExclusion(filters *Filters, r *Recommendation) (ok bool, err error) {
+	v := reflect.ValueOf(filters).Elem().FieldByName(r.Filter)
+	if !v.IsValid() {
+		return false, fmt.Errorf("unknown event filter %s", r.Filter)
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.New(v.Type().Elem()))
+		v.Elem().FieldByName("OnMatch").SetString("exclude")
+	}
+
+	if v.Elem().FieldByName("OnMatch").String() != "exclude" {
+		return false, nil
+	}
+
+	field := v.Elem().FieldByName(r.Field)
+	if !field.IsValid() {
+		return false, fmt.Errorf("unknown field %s in %s filter", r.Field, r.Filter)
+	}
+
+	// Filter value is inner XML so it needs to be escaped
+	value := new(strings.Builder)
+	if err = xml.EscapeText(value, []byte(r.Value)); err != nil {
+		return
+	}
+
+	filter := Filter{Condition: r.Condition, Value: value.String()}
+	field.Set(reflect.Append(field, reflect.ValueOf(filter)))
+
+	return true, nil
+}
+
+// Apply returns a copy of c with recommended exclusions added. Exclusions
+// are added to top level exclude filters when possible, otherwise they
+// go in a dedicated rule group.
+func (c *Config) Apply(recs []*Recommendation) (new *Config, err error) {
+	var b []byte
+	var ok bool
+
+	// deep copy through XML serialization
+	if b, err = c.XML(); err != nil {
+		return
+	}
+
+	new = &Config{}
+	if err = xml.Unmarshal(b, new); err != nil {
+		return
+	}
+	new.OS = c.OS
+
+	group := RuleGroup{Name: RecommendedRuleGroup, Relation: "or"}
+	grouped := false
+
+	for _, r := range recs {
+		if ok, err = addExclusion(&new.EventFiltering.Filters, r); err != nil {
+			return
+		}
+
+		if !ok {
+			if _, err = addExclusion(&group.Filters, r); err != nil {
+				return
+			}
+			grouped = true
+		}
+	}
+
+	if grouped {
+		new.EventFiltering.RuleGroup = append(new.EventFiltering.RuleGroup, group)
+	}
+
+	return
+}
+
+// Diff returns a line diff between the XML of c and other, lines prefixed
+// with "-" are removed from c and lines prefixed with "+" are added
+func (c *Config) Diff(other *Config) (diff string, err error) {
+	var a, b []byte
+
+	if a, err = c.XML(); err != nil {
+		return
+	}
+
+	if b, err = other.XML(); err != nil {
+		return
+	}
+
+	return lineDiff(strings.Split(string(a), "\n"), strings.Split(string(b), "\n")), nil
+}
+
+// lineDiff computes a diff of two sets of lines based on their longest
+// common subsequence
+func lineDiff(a, b []string) string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	sb := strings.Builder{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString(" " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			sb.WriteString("+" + b[j] + "\n")
+			j++
+		default:
+			sb.WriteString("-" + a[i] + "\n")
+			i++
+		}
+	}
+
+	return sb.String()
+}
@@ -2,6 +2,10 @@ package sysmon
 
 import "fmt"
 
+const (
+	Channel = "Microsoft-Windows-Sysmon/Operational"
+)
+
 var (
 	ErrSysmonNotInstalled = fmt.Errorf("sysmon is not installed")
 )
@@ -3,6 +3,7 @@ package sysmon
 import (
 	"encoding/json"
 	"encoding/xml"
+	"strings"
 	"testing"
 
 	"github.com/0xrawsec/toast"
@@ -150,3 +151,45 @@ func TestValidation(t *testing.T) {
 		tt.CheckErr(c.Validate())
 	}
 }
+
+func TestRecommend(t *testing.T) {
+	tt := toast.FromT(t)
+
+	c := Config{}
+	c.OS = los.OS
+	tt.CheckErr(xml.Unmarshal([]byte(config), &c))
+	c.EventFiltering.ProcessCreate.OnMatch = "include"
+
+	r := NewRecommender()
+	for i := 0; i < 80; i++ {
+		r.Add(7, `C:\Program Files\Noisy\lib.dll`, false)
+	}
+	for i := 0; i < 15; i++ {
+		r.Add(1, `C:\Tools\tool.exe`, false)
+	}
+	for i := 0; i < 5; i++ {
+		r.Add(1, `C:\Windows\System32\cmd.exe`, i == 0)
+	}
+	tt.Assert(r.Total() == 100)
+
+	recs := r.Recommend(0.1)
+	tt.Assert(len(recs) == 2)
+	tt.Assert(recs[0].EventID == 7)
+	tt.Assert(recs[0].Value == `c:\program files\noisy\`)
+	tt.Assert(recs[0].Share == 0.8)
+	t.Log(recs[0].Message)
+
+	new, err := c.Apply(recs)
+	tt.CheckErr(err)
+	tt.CheckErr(new.Validate())
+	// ProcessCreate filter is an include one so exclusion goes in a rule group
+	tt.Assert(len(new.EventFiltering.RuleGroup) == len(c.EventFiltering.RuleGroup)+1)
+	tt.Assert(len(new.EventFiltering.ImageLoad.ImageLoaded) == 1)
+	// original config must not be modified
+	tt.Assert(c.EventFiltering.ImageLoad == nil)
+
+	diff, err := c.Diff(new)
+	tt.CheckErr(err)
+	t.Log(diff)
+	tt.Assert(strings.Contains(diff, `+      <ImageLoaded condition="begin with">c:\program files\noisy\</ImageLoaded>`))
+}
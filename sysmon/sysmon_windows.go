@@ -189,6 +189,24 @@ func findMatchingSysmonServiceKeys() (keys []string) {
 	return
 }
 
+// CurrentConfigHash returns the hash of the configuration Sysmon is
+// running with, unlike NewSysmonInfo it does not run Sysmon binary
+func CurrentConfigHash() (hash string, err error) {
+	keys := findMatchingSysmonServiceKeys()
+
+	switch {
+	case len(keys) == 0:
+		return "", ErrSysmonNotInstalled
+	case len(keys) > 1:
+		return "", fmt.Errorf("more than one key looking like Sysmon: %v", keys)
+	}
+
+	i := &Info{}
+	i.Service.Name = keys[0]
+
+	return i.ConfigHash(), nil
+}
+
 func NewSysmonInfo() (i *Info, err error) {
 	var sysmonRegPath string
 
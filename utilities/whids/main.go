@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -11,12 +12,16 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golog"
 	"github.com/0xrawsec/whids/agent"
 	"github.com/0xrawsec/whids/agent/config"
 	"github.com/0xrawsec/whids/agent/sysinfo"
+	"github.com/0xrawsec/whids/api/client"
+	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 	"github.com/pelletier/go-toml/v2"
 	"golang.org/x/sys/windows/svc"
@@ -62,8 +67,13 @@ var (
 	flagProfile    bool
 	flagRestore    bool
 	flagAutologger bool
+	flagProcessor  bool
+	flagReplay     string
+	flagReplayEvtx string
+	flagRelease    string
 
-	edrAgent *agent.Agent
+	edrAgent  *agent.Agent
+	collector *agent.Collector
 
 	importRules string
 
@@ -76,7 +86,7 @@ var (
 )
 
 func printInfo(writer io.Writer) {
-	fmt.Fprintf(writer, "%s\nVersion: %s (commit: %s)\nCopyright: %s\nLicense: %s\n\n", banner, version, commitID, copyright, license)
+	fmt.Fprintf(writer, "%s\nVersion: %s (commit: %s, edition: %s)\nCopyright: %s\nLicense: %s\n\n", banner, version, commitID, config.Edition, copyright, license)
 }
 
 func configure() error {
@@ -96,6 +106,60 @@ func configure() error {
 	return nil
 }
 
+// configShow implements config show subcommand printing agent's configuration
+func configShow(args []string) (err error) {
+	var conf config.Agent
+	var c *config.Agent
+	var b []byte
+
+	var effective bool
+	var format string
+
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	fs.BoolVar(&effective, "effective", effective, "Print configuration as used by the agent (environment variables expanded)")
+	fs.StringVar(&format, "format", "toml", "Output format (toml or json)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-c CONFIG] config show [OPTIONS]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	if conf, err = config.LoadAgentConfig(configFile); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// secrets are masked in any case
+	if effective {
+		c, err = conf.Effective()
+	} else {
+		c, err = conf.Masked()
+	}
+
+	if err != nil {
+		return
+	}
+
+	switch format {
+	case "toml":
+		b, err = utils.Toml(c)
+	case "json":
+		b, err = json.MarshalIndent(c, "", "  ")
+		b = append(b, '\n')
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+
+	if err != nil {
+		return
+	}
+
+	_, err = os.Stdout.Write(b)
+	return
+}
+
 func updateAutologger(c *config.Agent) error {
 	if err := c.EtwConfig.ConfigureAutologger(); err != nil {
 		return err
@@ -150,13 +214,39 @@ func runHids(service bool) {
 		logger.Abort(exitFail, fmt.Errorf("failed to load configuration: %s", err))
 	}
 
-	edrAgent, err = agent.NewAgent(&hidsConf)
+	// printing all events overwrites sink configuration
+	if flagPrintAll {
+		hidsConf.SinkConfig = config.Sink{Enable: true, Format: event.SinkFormatJSON}
+	}
+
+	// privileges are separated, this process is the privileged collector
+	if hidsConf.PrivSepConfig.Enable && !flagProcessor {
+		runCollector(&hidsConf, service)
+		return
+	}
+
+	opts := make([]agent.Option, 0)
+	if flagProcessor {
+		if !hidsConf.PrivSepConfig.Enable {
+			logger.Abort(exitFail, "privilege separation must be enabled to run processing component")
+		}
+		// collector and processing component must not share the same logfile
+		hidsConf.Logfile = processorLogfile(hidsConf.Logfile)
+		opts = append(opts,
+			agent.WithEventProvider(agent.NewPipeProvider(hidsConf.PrivSepConfig.Pipe, logger)),
+			agent.WithLowPrivilege())
+	}
+
+	edrAgent, err = agent.NewAgent(&hidsConf, opts...)
 	if err != nil {
 		logger.Abort(exitFail, fmt.Errorf("failed to create EDR: %s", err))
 	}
 
 	edrAgent.DryRun = flagDryRun
-	edrAgent.PrintAll = flagPrintAll
+	edrAgent.Replay = flagReplay
+	if flagReplayEvtx != "" {
+		edrAgent.ReplayEvtx = strings.Split(flagReplayEvtx, ",")
+	}
 
 	// If not a service we need to be able to stop the HIDS
 	if !service {
@@ -180,7 +270,76 @@ func runHids(service bool) {
 	}
 }
 
-func proctectDir(dir string) {
+// processorLogfile returns the logfile of the processing component
+func processorLogfile(logfile string) string {
+	if logfile == "" {
+		return ""
+	}
+	ext := filepath.Ext(logfile)
+	return fmt.Sprintf("%s-processor%s", strings.TrimSuffix(logfile, ext), ext)
+}
+
+// runCollector runs the privileged collector sending events
+// to the processing component over a local pipe
+func runCollector(c *config.Agent, service bool) {
+	var err error
+
+	if collector, err = agent.NewCollector(c, logger); err != nil {
+		logger.Abort(exitFail, fmt.Errorf("failed to create collector: %s", err))
+	}
+
+	if !service {
+		signal.Notify(osSignals, os.Interrupt)
+		go func() {
+			<-osSignals
+			logger.Infof("Received SIGINT")
+			collector.Stop()
+		}()
+	}
+
+	if err = collector.Run(); err != nil {
+		logger.Abort(exitFail, fmt.Errorf("failed to run collector: %s", err))
+	}
+
+	if !service {
+		collector.Wait()
+	}
+}
+
+// privSepDataDirs returns the top level subdirectories of the installation
+// directory dir holding the logs, databases and dumps of the processing component
+func privSepDataDirs(dir string, c *config.Agent) (dirs []string) {
+	seen := make(map[string]bool)
+
+	for _, path := range []string{
+		filepath.Dir(c.Logfile),
+		c.FwdConfig.Logging.Dir,
+		c.DatabasePath,
+		c.RulesConfig.RulesDB,
+		c.EventDBConfig.Dir,
+		c.Dump.Dir,
+	} {
+		if path == "" {
+			continue
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		// directory outside of installation directory or installation directory itself
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		sub := filepath.Join(dir, strings.Split(rel, string(filepath.Separator))[0])
+		if !seen[sub] {
+			seen[sub] = true
+			dirs = append(dirs, sub)
+		}
+	}
+
+	return
+}
+
+func proctectDir(dir string, c *config.Agent) {
 	var out []byte
 	var err error
 
@@ -200,6 +359,34 @@ func proctectDir(dir string) {
 		return
 	}
 
+	if c.PrivSepConfig.Enable {
+		account := fmt.Sprintf("*%s", c.PrivSepConfig.Account)
+
+		// processing component runs the binary and reads the configuration
+		cmd = []string{"icacls", dir, "/grant", account + ":(OI)(CI)RX"}
+		if out, err = exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+			logger.Errorf("failed to grant processing component access to installation directory: %s", err)
+			logger.Errorf("icacls output: %s", string(out))
+			return
+		}
+
+		// it can only write its logs, databases and dumps, binary and configuration
+		// must not be modified by a compromised processing component
+		for _, sub := range privSepDataDirs(dir, c) {
+			if err = os.MkdirAll(sub, 0600); err != nil {
+				logger.Errorf("failed to create processing component directory: %s", err)
+				return
+			}
+
+			cmd = []string{"icacls", sub, "/grant", account + ":(OI)(CI)M"}
+			if out, err = exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+				logger.Errorf("failed to grant processing component access to %s: %s", sub, err)
+				logger.Errorf("icacls output: %s", string(out))
+				return
+			}
+		}
+	}
+
 	logger.Infof("Successfully protected installation directory with ACLs")
 }
 
@@ -210,17 +397,22 @@ func main() {
 	flag.BoolVar(&flagAutologger, "autologger", flagAutologger, "Update EDR's ETW autologger configuration")
 	flag.BoolVar(&flagUninstall, "uninstall", flagUninstall, "Uninstall EDR")
 	flag.BoolVar(&flagDryRun, "dry", flagDryRun, "Dry run (do everything except listening on channels)")
-	flag.BoolVar(&flagPrintAll, "all", flagPrintAll, "Print all events passing through HIDS")
+	flag.BoolVar(&flagPrintAll, "all", flagPrintAll, "Print all events passing through HIDS (see sink configuration to filter events)")
 	flag.BoolVar(&flagVersion, "v", flagVersion, "Print version information and exit")
 	flag.BoolVar(&flagProfile, "prof", flagProfile, "Profile program")
 	flag.BoolVar(&flagDebug, "d", flagDebug, "Enable debugging messages")
 	flag.BoolVar(&flagRestore, "restore", flagRestore, "Restore Audit Policies and File System Audit ACLs according to configuration file")
 	flag.StringVar(&configFile, "c", configFile, "Configuration file")
 	flag.StringVar(&importRules, "import", importRules, "Import rules")
+	flag.StringVar(&flagRelease, "release", flagRelease, "Emergency release of host containment with a release token signed by the manager (when manager is unreachable)")
+	flag.StringVar(&flagReplay, "replay", flagReplay, "Replay events (one JSON event per line) from a file or named pipe instead of listening on ETW (test mode)")
+	flag.BoolVar(&flagProcessor, "processor", flagProcessor, "Run the lower-privileged processing component receiving events from the collector (privilege separation must be enabled)")
+	flag.StringVar(&flagReplayEvtx, "replay-evtx", flagReplayEvtx, "Replay events from saved EVTX files or directories (comma separated) instead of listening on ETW")
 
 	flag.Usage = func() {
 		printInfo(os.Stderr)
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s [-c CONFIG] config show [-effective] [-format toml|json]\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 		os.Exit(exitSuccess)
 	}
@@ -231,9 +423,12 @@ func main() {
 	i := &sysinfo.EdrInfo{
 		Version: version,
 		Commit:  commitID,
+		Arch:    runtime.GOARCH,
+		Edition: config.Edition,
 	}
 
 	sysinfo.RegisterEdrInfo(i)
+	client.ProductVersion = version
 
 	isIntSess, err := svc.IsAnInteractiveSession()
 	if err != nil {
@@ -295,6 +490,20 @@ func main() {
 		os.Exit(rc)
 	}
 
+	if flagRelease != "" {
+		conf, err := config.LoadAgentConfig(configFile)
+		if err != nil {
+			logger.Abort(exitFail, fmt.Errorf("failed to load configuration: %s", err))
+		}
+
+		if err := agent.EmergencyRelease(&conf, flagRelease); err != nil {
+			logger.Abort(exitFail, fmt.Errorf("failed to release containment: %s", err))
+		}
+
+		logger.Infof("Host containment released")
+		os.Exit(exitSuccess)
+	}
+
 	// profile the program
 	if flagProfile {
 		go func() {
@@ -308,6 +517,19 @@ func main() {
 		os.Exit(exitSuccess)
 	}
 
+	// subcommands
+	if flag.NArg() > 0 {
+		switch {
+		case flag.NArg() > 1 && flag.Arg(0) == "config" && flag.Arg(1) == "show":
+			if err := configShow(flag.Args()[2:]); err != nil {
+				logger.Abort(exitFail, err)
+			}
+			os.Exit(exitSuccess)
+		default:
+			flag.Usage()
+		}
+	}
+
 	if flagDumpConfig || flagConfigure {
 		writer := os.Stdout
 		enc := toml.NewEncoder(writer)
@@ -333,6 +555,11 @@ func main() {
 		os.Exit(exitSuccess)
 	}
 
+	// collector and processing component must not share the same logfile
+	if flagProcessor {
+		agentCfg.Logfile = processorLogfile(agentCfg.Logfile)
+	}
+
 	// has to be there so that we print logs to stdout
 	if importRules != "" {
 		// in order not to write logs into file
@@ -369,7 +596,9 @@ func main() {
 	// if we run from command line (interactive session)
 	if isIntSess {
 		runHids(false)
-		edrAgent.LogStats()
+		if edrAgent != nil {
+			edrAgent.LogStats()
+		}
 		return
 	}
 
@@ -391,8 +620,8 @@ func main() {
 	}
 
 	// if running as service we protect installation directory with appropriate ACLs
-	if fsutil.IsDir(abs) {
-		proctectDir(abs)
+	if fsutil.IsDir(abs) && !flagProcessor {
+		proctectDir(abs, &agentCfg)
 	}
 
 	runService(svcName, false)
@@ -26,9 +26,14 @@ loop:
 		case svc.Stop:
 			changes <- svc.Status{State: svc.StopPending}
 			// Stop WHIDS there
-			edrAgent.Stop()
-			edrAgent.Wait()
-			edrAgent.LogStats()
+			if collector != nil {
+				collector.Stop()
+				collector.Wait()
+			} else {
+				edrAgent.Stop()
+				edrAgent.Wait()
+				edrAgent.LogStats()
+			}
 			break loop
 		}
 	}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"regexp"
+)
+
+// Image file machine types used to identify process and system architectures
+const (
+	ImageFileMachineUnknown = 0x0000
+	ImageFileMachineI386    = 0x014c
+	ImageFileMachineARMNT   = 0x01c4
+	ImageFileMachineAMD64   = 0x8664
+	ImageFileMachineARM64   = 0xaa64
+)
+
+// Architecture names, following GOARCH naming
+const (
+	Arch386     = "386"
+	ArchAMD64   = "amd64"
+	ArchARM     = "arm"
+	ArchARM64   = "arm64"
+	ArchUnknown = "unknown"
+)
+
+var (
+	sysnativeRe = regexp.MustCompile(`(?i)^(.*\\windows\\)sysnative(\\|$)`)
+	system32Re  = regexp.MustCompile(`(?i)^(.*\\windows\\)system32(\\|$)`)
+)
+
+// MachineArch returns the architecture name of an image file machine type
+func MachineArch(machine uint16) string {
+	switch machine {
+	case ImageFileMachineI386:
+		return Arch386
+	case ImageFileMachineARMNT:
+		return ArchARM
+	case ImageFileMachineAMD64:
+		return ArchAMD64
+	case ImageFileMachineARM64:
+		return ArchARM64
+	}
+	return ArchUnknown
+}
+
+// CanonicalSystemPath returns path with the Sysnative alias, only
+// meaningful to WOW64 processes, replaced by System32
+func CanonicalSystemPath(path string) string {
+	return sysnativeRe.ReplaceAllString(path, "${1}System32${2}")
+}
+
+// NativeSystemPath returns a path to access path without WOW64 file system
+// redirection. If wow64 is false, meaning the accessing process is not a
+// WOW64 process, path is returned unchanged.
+func NativeSystemPath(path string, wow64 bool) string {
+	if !wow64 {
+		return path
+	}
+	return system32Re.ReplaceAllString(path, "${1}Sysnative${2}")
+}
+
+// RedirectedSystemPath returns the path actually accessed by a WOW64 process
+// of machine type machine when using path. System32 is redirected to SysWOW64
+// for x86 processes and to SysArm32 for ARM32 processes.
+func RedirectedSystemPath(path string, machine uint16) string {
+	switch machine {
+	case ImageFileMachineI386:
+		return system32Re.ReplaceAllString(path, "${1}SysWOW64${2}")
+	case ImageFileMachineARMNT:
+		return system32Re.ReplaceAllString(path, "${1}SysArm32${2}")
+	}
+	return path
+}
@@ -0,0 +1,121 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/0xrawsec/golang-win32/win32"
+	"github.com/0xrawsec/golang-win32/win32/kernel32"
+)
+
+var (
+	modKernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procIsWow64Process  = modKernel32.NewProc("IsWow64Process")
+	procIsWow64Process2 = modKernel32.NewProc("IsWow64Process2")
+
+	selfOnce    sync.Once
+	selfMachine uint16
+	selfNative  uint16
+)
+
+// ProcessMachine returns the machine type of a process, ImageFileMachineUnknown
+// if it is not a WOW64 process, and the native machine type of the system
+func ProcessMachine(hProcess win32.HANDLE) (process, native uint16, err error) {
+	// IsWow64Process2 is available from Windows 10 1511
+	if procIsWow64Process2.Find() == nil {
+		r, _, e := procIsWow64Process2.Call(
+			uintptr(hProcess),
+			uintptr(unsafe.Pointer(&process)),
+			uintptr(unsafe.Pointer(&native)))
+		if r == 0 {
+			err = e
+		}
+		return
+	}
+
+	// fallback on IsWow64Process which only works for x86 on x64
+	var wow64 int32
+	if r, _, e := procIsWow64Process.Call(uintptr(hProcess), uintptr(unsafe.Pointer(&wow64))); r == 0 {
+		return 0, 0, e
+	}
+
+	native = ImageFileMachineAMD64
+	if unsafe.Sizeof(uintptr(0)) == 4 && wow64 == 0 {
+		// 32-bit process on a 32-bit system
+		native = ImageFileMachineI386
+	}
+
+	if wow64 != 0 {
+		process = ImageFileMachineI386
+	}
+
+	return
+}
+
+// PidMachine returns the machine type of a process given its PID, see
+// ProcessMachine for details
+func PidMachine(pid int) (process, native uint16, err error) {
+	var hProcess win32.HANDLE
+
+	if hProcess, err = kernel32.OpenProcess(kernel32.PROCESS_QUERY_LIMITED_INFORMATION, win32.FALSE, win32.DWORD(pid)); err != nil {
+		return
+	}
+	defer kernel32.CloseHandle(hProcess)
+
+	return ProcessMachine(hProcess)
+}
+
+func initSelfMachine() {
+	selfOnce.Do(func() {
+		// pseudo handle, does not need to be closed
+		h, _ := kernel32.GetCurrentProcess()
+		selfMachine, selfNative, _ = ProcessMachine(h)
+	})
+}
+
+// IsWow64Self returns true if current process runs under WOW64
+func IsWow64Self() bool {
+	initSelfMachine()
+	return selfMachine != ImageFileMachineUnknown
+}
+
+// NativeArch returns the native architecture of the system
+func NativeArch() string {
+	initSelfMachine()
+	return MachineArch(selfNative)
+}
+
+// IsWow64Pid returns true if process identified by pid runs under WOW64
+func IsWow64Pid(pid int) bool {
+	process, _, err := PidMachine(pid)
+	return err == nil && process != ImageFileMachineUnknown
+}
+
+// NativePath returns a path usable by current process to access path
+// without being subject to WOW64 file system redirection
+func NativePath(path string) string {
+	return NativeSystemPath(path, IsWow64Self())
+}
+
+// effective returns the machine type a process actually runs with
+func effective(process, native uint16) uint16 {
+	if process == ImageFileMachineUnknown {
+		return native
+	}
+	return process
+}
+
+// SameArchPid returns true if process identified by pid runs with the
+// same architecture as current process
+func SameArchPid(pid int) bool {
+	process, native, err := PidMachine(pid)
+	if err != nil {
+		return false
+	}
+	initSelfMachine()
+	return effective(process, native) == effective(selfMachine, selfNative)
+}
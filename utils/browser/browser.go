@@ -0,0 +1,510 @@
+// Package browser implements collectors of Chromium based browsers and Firefox
+// artifacts (history, downloads and extensions) out of users' profiles.
+package browser
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/utils/sqlite"
+)
+
+const (
+	KindChromium = "chromium"
+	KindFirefox  = "firefox"
+
+	// microseconds between 1601-01-01 and 1970-01-01
+	webkitEpochDelta = 11644473600000000
+)
+
+var (
+	// MaxEntries maximum number of history entries and downloads (most recent first)
+	// collected per profile, history databases can be very large
+	MaxEntries = 1000
+
+	// Chromium based browsers' user data directories, relative to user's home
+	chromiumBrowsers = map[string]string{
+		"chrome":   `AppData\Local\Google\Chrome\User Data`,
+		"edge":     `AppData\Local\Microsoft\Edge\User Data`,
+		"brave":    `AppData\Local\BraveSoftware\Brave-Browser\User Data`,
+		"chromium": `AppData\Local\Chromium\User Data`,
+		"vivaldi":  `AppData\Local\Vivaldi\User Data`,
+		"opera":    `AppData\Roaming\Opera Software\Opera Stable`,
+	}
+
+	firefoxProfiles = `AppData\Roaming\Mozilla\Firefox\Profiles`
+
+	ErrUnknownKind = errors.New("unknown browser kind")
+)
+
+func winPath(p string) string {
+	return filepath.Join(strings.Split(p, `\`)...)
+}
+
+func webkitTime(us int64) time.Time {
+	if us <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us - webkitEpochDelta).UTC()
+}
+
+func unixMicroTime(us int64) time.Time {
+	if us <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us).UTC()
+}
+
+// Profile is a browser profile of a user
+type Profile struct {
+	Browser string `json:"browser"`
+	Kind    string `json:"kind"`
+	User    string `json:"user"`
+	Path    string `json:"path"`
+}
+
+// HistoryPath returns the path of the database holding profile's history
+func (p *Profile) HistoryPath() string {
+	if p.Kind == KindFirefox {
+		return filepath.Join(p.Path, "places.sqlite")
+	}
+	return filepath.Join(p.Path, "History")
+}
+
+// Files returns the paths of profile's files worth collecting as artifacts
+func (p *Profile) Files() (files []string) {
+	h := p.HistoryPath()
+	files = []string{h, h + "-wal"}
+
+	if p.Kind == KindFirefox {
+		files = append(files, filepath.Join(p.Path, "extensions.json"))
+	} else {
+		manifests, _ := filepath.Glob(filepath.Join(p.Path, "Extensions", "*", "*", "manifest.json"))
+		files = append(files, manifests...)
+	}
+
+	return
+}
+
+// Profiles returns browser profiles of all the users found in usersDir
+// (i.e. C:\Users)
+func Profiles(usersDir string) (profiles []*Profile) {
+	profiles = make([]*Profile, 0)
+
+	users, _ := os.ReadDir(usersDir)
+	for _, u := range users {
+		if !u.IsDir() {
+			continue
+		}
+
+		home := filepath.Join(usersDir, u.Name())
+
+		for browser, dir := range chromiumBrowsers {
+			udd := filepath.Join(home, winPath(dir))
+			// profile directories are Default and Profile N, Opera
+			// stores its profile in user data directory
+			candidates, _ := filepath.Glob(filepath.Join(udd, "Profile *"))
+			candidates = append(candidates, filepath.Join(udd, "Default"), udd)
+
+			for _, c := range candidates {
+				p := &Profile{Browser: browser, Kind: KindChromium, User: u.Name(), Path: c}
+				if st, err := os.Stat(p.HistoryPath()); err == nil && st.Mode().IsRegular() {
+					profiles = append(profiles, p)
+				}
+			}
+		}
+
+		candidates, _ := filepath.Glob(filepath.Join(home, winPath(firefoxProfiles), "*"))
+		for _, c := range candidates {
+			p := &Profile{Browser: "firefox", Kind: KindFirefox, User: u.Name(), Path: c}
+			if st, err := os.Stat(p.HistoryPath()); err == nil && st.Mode().IsRegular() {
+				profiles = append(profiles, p)
+			}
+		}
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Path < profiles[j].Path
+	})
+
+	return
+}
+
+// HistoryEntry is an URL visited
+type HistoryEntry struct {
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	VisitCount int64     `json:"visit-count"`
+	LastVisit  time.Time `json:"last-visit"`
+}
+
+// Download is a file downloaded
+type Download struct {
+	URL      string    `json:"url"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	MimeType string    `json:"mime-type,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitempty"`
+}
+
+// Extension is a browser extension installed
+type Extension struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	Path        string   `json:"path"`
+	Permissions []string `json:"permissions"`
+}
+
+// Artifacts are the artifacts collected from a profile
+type Artifacts struct {
+	Profile    Profile         `json:"profile"`
+	History    []*HistoryEntry `json:"history"`
+	Downloads  []*Download     `json:"downloads"`
+	Extensions []*Extension    `json:"extensions"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+func (a *Artifacts) addErr(err error) {
+	if err != nil {
+		a.Errors = append(a.Errors, err.Error())
+	}
+}
+
+// Collect collects artifacts from a browser profile
+func Collect(p *Profile) (a *Artifacts) {
+	var db *sqlite.DB
+	var err error
+
+	a = &Artifacts{
+		Profile:    *p,
+		History:    make([]*HistoryEntry, 0),
+		Downloads:  make([]*Download, 0),
+		Extensions: make([]*Extension, 0),
+	}
+
+	if db, err = sqlite.Open(p.HistoryPath()); err != nil {
+		a.addErr(err)
+	} else {
+		switch p.Kind {
+		case KindChromium:
+			a.History, err = ChromiumHistory(db)
+			a.addErr(err)
+			a.Downloads, err = ChromiumDownloads(db)
+			a.addErr(err)
+		case KindFirefox:
+			a.History, err = FirefoxHistory(db)
+			a.addErr(err)
+			a.Downloads, err = FirefoxDownloads(db)
+			a.addErr(err)
+		default:
+			a.addErr(ErrUnknownKind)
+		}
+	}
+
+	switch p.Kind {
+	case KindChromium:
+		a.Extensions, err = ChromiumExtensions(p.Path)
+	case KindFirefox:
+		a.Extensions, err = FirefoxExtensions(p.Path)
+	}
+	a.addErr(err)
+
+	return
+}
+
+func limitHistory(h []*HistoryEntry) []*HistoryEntry {
+	sort.SliceStable(h, func(i, j int) bool { return h[i].LastVisit.After(h[j].LastVisit) })
+	if len(h) > MaxEntries {
+		h = h[:MaxEntries]
+	}
+	return h
+}
+
+func limitDownloads(d []*Download) []*Download {
+	sort.SliceStable(d, func(i, j int) bool { return d[i].Start.After(d[j].Start) })
+	if len(d) > MaxEntries {
+		d = d[:MaxEntries]
+	}
+	return d
+}
+
+// ChromiumHistory returns history entries from a Chromium History database
+func ChromiumHistory(db *sqlite.DB) (h []*HistoryEntry, err error) {
+	var t *sqlite.Table
+
+	h = make([]*HistoryEntry, 0)
+	if t, err = db.Table("urls"); err != nil {
+		return
+	}
+
+	err = t.Rows(func(r sqlite.Row) error {
+		h = append(h, &HistoryEntry{
+			URL:        r.String("url"),
+			Title:      r.String("title"),
+			VisitCount: r.Int("visit_count"),
+			LastVisit:  webkitTime(r.Int("last_visit_time")),
+		})
+		return nil
+	})
+
+	return limitHistory(h), err
+}
+
+// ChromiumDownloads returns downloads from a Chromium History database
+func ChromiumDownloads(db *sqlite.DB) (d []*Download, err error) {
+	var t *sqlite.Table
+
+	d = make([]*Download, 0)
+
+	// last URL of the redirection chain of every download
+	urls := make(map[int64]string)
+	index := make(map[int64]int64)
+	if t, err = db.Table("downloads_url_chains"); err == nil {
+		err = t.Rows(func(r sqlite.Row) error {
+			id, i := r.Int("id"), r.Int("chain_index")
+			if cur, ok := index[id]; !ok || i >= cur {
+				index[id] = i
+				urls[id] = r.String("url")
+			}
+			return nil
+		})
+	}
+
+	if err != nil {
+		return
+	}
+
+	if t, err = db.Table("downloads"); err != nil {
+		return
+	}
+
+	err = t.Rows(func(r sqlite.Row) error {
+		d = append(d, &Download{
+			URL:      urls[r.Int("id")],
+			Path:     r.String("target_path"),
+			Size:     r.Int("total_bytes"),
+			MimeType: r.String("mime_type"),
+			Start:    webkitTime(r.Int("start_time")),
+			End:      webkitTime(r.Int("end_time")),
+		})
+		return nil
+	})
+
+	return limitDownloads(d), err
+}
+
+// FirefoxHistory returns history entries from a Firefox places.sqlite database
+func FirefoxHistory(db *sqlite.DB) (h []*HistoryEntry, err error) {
+	var t *sqlite.Table
+
+	h = make([]*HistoryEntry, 0)
+	if t, err = db.Table("moz_places"); err != nil {
+		return
+	}
+
+	err = t.Rows(func(r sqlite.Row) error {
+		// places which have never been visited (i.e. bookmarks)
+		if r.Int("visit_count") == 0 && r.Int("last_visit_date") == 0 {
+			return nil
+		}
+
+		h = append(h, &HistoryEntry{
+			URL:        r.String("url"),
+			Title:      r.String("title"),
+			VisitCount: r.Int("visit_count"),
+			LastVisit:  unixMicroTime(r.Int("last_visit_date")),
+		})
+		return nil
+	})
+
+	return limitHistory(h), err
+}
+
+// FirefoxDownloads returns downloads from a Firefox places.sqlite database,
+// downloads are stored as annotations of places
+func FirefoxDownloads(db *sqlite.DB) (d []*Download, err error) {
+	var t *sqlite.Table
+	var destAttr int64 = -1
+
+	d = make([]*Download, 0)
+
+	if t, err = db.Table("moz_anno_attributes"); err != nil {
+		return
+	}
+
+	if err = t.Rows(func(r sqlite.Row) error {
+		if r.String("name") == "downloads/destinationFileURI" {
+			destAttr = r.Int("id")
+		}
+		return nil
+	}); err != nil || destAttr == -1 {
+		return
+	}
+
+	places := make(map[int64]string)
+	if t, err = db.Table("moz_places"); err != nil {
+		return
+	}
+
+	if err = t.Rows(func(r sqlite.Row) error {
+		places[r.Int("id")] = r.String("url")
+		return nil
+	}); err != nil {
+		return
+	}
+
+	if t, err = db.Table("moz_annos"); err != nil {
+		return
+	}
+
+	err = t.Rows(func(r sqlite.Row) error {
+		if r.Int("anno_attribute_id") == destAttr {
+			d = append(d, &Download{
+				URL:   places[r.Int("place_id")],
+				Path:  fileURIToPath(r.String("content")),
+				Start: unixMicroTime(r.Int("dateAdded")),
+			})
+		}
+		return nil
+	})
+
+	return limitDownloads(d), err
+}
+
+// fileURIToPath converts a file URI (file:///C:/Users/...) to a path
+func fileURIToPath(uri string) string {
+	p := strings.TrimPrefix(uri, "file:///")
+	if p == uri {
+		return uri
+	}
+
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+
+	return strings.ReplaceAll(p, "/", `\`)
+}
+
+type chromiumManifest struct {
+	Name            string        `json:"name"`
+	Version         string        `json:"version"`
+	Description     string        `json:"description"`
+	Permissions     []interface{} `json:"permissions"`
+	HostPermissions []string      `json:"host_permissions"`
+}
+
+// ChromiumExtensions returns extensions installed in a Chromium profile
+func ChromiumExtensions(profile string) (exts []*Extension, err error) {
+	exts = make([]*Extension, 0)
+
+	manifests, _ := filepath.Glob(filepath.Join(profile, "Extensions", "*", "*", "manifest.json"))
+	for _, path := range manifests {
+		var b []byte
+
+		m := chromiumManifest{}
+		if b, err = os.ReadFile(path); err != nil {
+			return
+		}
+
+		if err = json.Unmarshal(b, &m); err != nil {
+			return
+		}
+
+		e := &Extension{
+			ID:          filepath.Base(filepath.Dir(filepath.Dir(path))),
+			Name:        m.Name,
+			Version:     m.Version,
+			Description: m.Description,
+			// disabled extensions are only known from Preferences
+			Enabled:     true,
+			Path:        filepath.Dir(path),
+			Permissions: make([]string, 0),
+		}
+
+		for _, p := range m.Permissions {
+			// permissions may also be objects
+			if s, ok := p.(string); ok {
+				e.Permissions = append(e.Permissions, s)
+			}
+		}
+		e.Permissions = append(e.Permissions, m.HostPermissions...)
+
+		exts = append(exts, e)
+	}
+
+	return
+}
+
+type firefoxExtensions struct {
+	Addons []struct {
+		ID            string `json:"id"`
+		Version       string `json:"version"`
+		Type          string `json:"type"`
+		Active        bool   `json:"active"`
+		Path          string `json:"path"`
+		Location      string `json:"location"`
+		DefaultLocale struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"defaultLocale"`
+		UserPermissions *struct {
+			Permissions []string `json:"permissions"`
+			Origins     []string `json:"origins"`
+		} `json:"userPermissions"`
+	} `json:"addons"`
+}
+
+// FirefoxExtensions returns extensions installed in a Firefox profile
+func FirefoxExtensions(profile string) (exts []*Extension, err error) {
+	var b []byte
+
+	exts = make([]*Extension, 0)
+
+	if b, err = os.ReadFile(filepath.Join(profile, "extensions.json")); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	fe := firefoxExtensions{}
+	if err = json.Unmarshal(b, &fe); err != nil {
+		return
+	}
+
+	for _, a := range fe.Addons {
+		// themes, dictionaries and builtin extensions are not relevant
+		if a.Type != "extension" || a.Location == "app-builtin" || a.Location == "app-system-defaults" {
+			continue
+		}
+
+		e := &Extension{
+			ID:          a.ID,
+			Name:        a.DefaultLocale.Name,
+			Version:     a.Version,
+			Description: a.DefaultLocale.Description,
+			Enabled:     a.Active,
+			Path:        a.Path,
+			Permissions: make([]string, 0),
+		}
+
+		if a.UserPermissions != nil {
+			e.Permissions = append(e.Permissions, a.UserPermissions.Permissions...)
+			e.Permissions = append(e.Permissions, a.UserPermissions.Origins...)
+		}
+
+		exts = append(exts, e)
+	}
+
+	return
+}
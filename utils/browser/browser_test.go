@@ -0,0 +1,119 @@
+package browser
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+var (
+	usersDir = filepath.Join("testdata", "Users")
+	visit    = time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+func profileOf(t *testing.T, kind string) *Profile {
+	for _, p := range Profiles(usersDir) {
+		if p.Kind == kind {
+			return p
+		}
+	}
+	t.Fatalf("no %s profile found", kind)
+	return nil
+}
+
+func TestProfiles(t *testing.T) {
+	tt := toast.FromT(t)
+
+	profiles := Profiles(usersDir)
+	tt.Assert(len(profiles) == 2)
+
+	tt.Assert(profiles[0].Browser == "chrome")
+	tt.Assert(profiles[0].User == "alice")
+	tt.Assert(filepath.Base(profiles[0].Path) == "Default")
+
+	tt.Assert(profiles[1].Browser == "firefox")
+	tt.Assert(profiles[1].User == "bob")
+
+	tt.Assert(len(Profiles(filepath.Join("testdata", "nonexistent"))) == 0)
+}
+
+func TestChromium(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := Collect(profileOf(t, KindChromium))
+	tt.Assert(len(a.Errors) == 0, a.Errors)
+
+	tt.Assert(len(a.History) == 2)
+	// most recent first
+	tt.Assert(a.History[0].URL == "https://evil.test/payload")
+	tt.Assert(a.History[1].Title == "Example")
+	tt.Assert(a.History[1].VisitCount == 3)
+	tt.Assert(a.History[1].LastVisit.Equal(visit), a.History[1].LastVisit)
+
+	tt.Assert(len(a.Downloads) == 1)
+	d := a.Downloads[0]
+	// last URL of redirection chain
+	tt.Assert(d.URL == "https://cdn.evil.test/payload.exe")
+	tt.Assert(d.Path == `C:\Users\alice\Downloads\payload.exe`)
+	tt.Assert(d.Size == 1024)
+	tt.Assert(d.MimeType == "application/octet-stream")
+	tt.Assert(d.Start.Equal(visit.Add(time.Minute)))
+	tt.Assert(d.End.Equal(visit.Add(61 * time.Second)))
+
+	tt.Assert(len(a.Extensions) == 1)
+	e := a.Extensions[0]
+	tt.Assert(e.ID == "abcdefghijklmnopabcdefghijklmnop")
+	tt.Assert(e.Name == "Evil Ext")
+	tt.Assert(e.Version == "1.2.3")
+	tt.Assert(len(e.Permissions) == 3, e.Permissions)
+	tt.Assert(e.Permissions[2] == "<all_urls>")
+}
+
+func TestFirefox(t *testing.T) {
+	tt := toast.FromT(t)
+
+	a := Collect(profileOf(t, KindFirefox))
+	tt.Assert(len(a.Errors) == 0, a.Errors)
+
+	// bookmark never visited must not be reported
+	tt.Assert(len(a.History) == 2)
+	tt.Assert(a.History[0].URL == "https://evil.test/doc.zip")
+	tt.Assert(a.History[1].LastVisit.Equal(visit))
+
+	tt.Assert(len(a.Downloads) == 1)
+	tt.Assert(a.Downloads[0].URL == "https://evil.test/doc.zip")
+	tt.Assert(a.Downloads[0].Path == `C:\Users\bob\Downloads\doc final.zip`, a.Downloads[0].Path)
+	tt.Assert(a.Downloads[0].Start.Equal(visit.Add(time.Second)))
+
+	// builtin extensions and themes are skipped
+	tt.Assert(len(a.Extensions) == 1)
+	tt.Assert(a.Extensions[0].ID == "evil@ext")
+	tt.Assert(a.Extensions[0].Name == "Evil FF")
+	tt.Assert(a.Extensions[0].Enabled)
+	tt.Assert(len(a.Extensions[0].Permissions) == 2)
+}
+
+func TestMaxEntries(t *testing.T) {
+	tt := toast.FromT(t)
+
+	defer func(m int) { MaxEntries = m }(MaxEntries)
+	MaxEntries = 1
+
+	a := Collect(profileOf(t, KindChromium))
+	tt.Assert(len(a.History) == 1)
+	tt.Assert(a.History[0].URL == "https://evil.test/payload")
+}
+
+func TestFiles(t *testing.T) {
+	tt := toast.FromT(t)
+
+	files := profileOf(t, KindChromium).Files()
+	tt.Assert(len(files) == 3)
+	tt.Assert(filepath.Base(files[2]) == "manifest.json")
+
+	files = profileOf(t, KindFirefox).Files()
+	tt.Assert(len(files) == 3)
+	tt.Assert(filepath.Base(files[2]) == "extensions.json")
+}
@@ -30,19 +30,18 @@ func publicKey(priv interface{}) interface{} {
 	}
 }
 
-func pemBlockForKey(priv interface{}) *pem.Block {
+func pemBlockForKey(priv interface{}) (*pem.Block, error) {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
-		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
 	case *ecdsa.PrivateKey:
 		b, err := x509.MarshalECPrivateKey(k)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to marshal ECDSA private key: %v", err)
-			os.Exit(2)
+			return nil, fmt.Errorf("unable to marshal ECDSA private key: %w", err)
 		}
-		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
 	default:
-		return nil
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
 	}
 }
 
@@ -110,7 +109,12 @@ func GenerateCert(org string, hosts []string, validity time.Duration) (key, cert
 
 	keyOut := new(bytes.Buffer)
 
-	if err = pem.Encode(keyOut, pemBlockForKey(priv)); err != nil {
+	block, err := pemBlockForKey(priv)
+	if err != nil {
+		return
+	}
+
+	if err = pem.Encode(keyOut, block); err != nil {
 		return
 	}
 
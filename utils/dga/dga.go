@@ -0,0 +1,163 @@
+package dga
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	// N size of the n-grams used by models
+	N = 2
+
+	// minimum length of a domain label to be scored
+	minLen = 6
+)
+
+var (
+	// most frequent english bigrams, used as a model of
+	// human readable domain names
+	defaultNgrams = []string{
+		"th", "he", "in", "er", "an", "re", "on", "at", "en", "nd",
+		"ti", "es", "or", "te", "of", "ed", "is", "it", "al", "ar",
+		"st", "to", "nt", "ng", "se", "ha", "as", "ou", "io", "le",
+		"ve", "co", "me", "de", "hi", "ri", "ro", "ic", "ne", "ea",
+		"ra", "ce", "li", "ch", "ll", "be", "ma", "si", "om", "ur",
+		"ca", "el", "ta", "la", "ns", "di", "fo", "ho", "pe", "ec",
+		"pr", "no", "ct", "us", "ac", "ot", "il", "tr", "ly", "nc",
+		"et", "ut", "ss", "so", "rs", "un", "lo", "wa", "ge", "ie",
+		"wh", "ee", "wi", "em", "ad", "ol", "rt", "po", "we", "na",
+		"ul", "ni", "ts", "mo", "ow", "pa", "im", "mi", "ai", "sh",
+		"ir", "su", "id", "os", "iv", "ia", "am", "fi", "ci", "vi",
+		"pl", "ig", "tu", "ev", "ld", "ry", "mp", "fe", "bl", "ab",
+		"gh", "ty", "op", "wo", "sa", "ay", "ex", "ke", "fr", "oo",
+		"av", "ag", "if", "ap", "gr", "od", "bo", "sp", "rd", "do",
+		"uc", "bu", "ei", "ov", "by", "rm", "ep", "tt", "oc", "fa",
+		"ef", "cu", "rn", "sc", "gi", "da", "yo", "cr", "cl", "du",
+		"ga", "qu", "ue", "ff", "ba", "ey", "ls", "va", "um", "pp",
+		"ua", "up", "lu", "go", "ht", "ru", "ug", "ds", "lt", "pi",
+		"rc", "rr", "eg", "au", "ck", "ew", "mu", "br", "bi", "pt",
+		"ak", "pu", "ui", "rg", "ib", "tl", "ny", "ki", "rk", "ys",
+		"ob", "mm", "fu", "ph", "og", "ms", "ye", "ud", "mb", "ip",
+		"ub", "oi", "rl", "gu", "dr", "hr", "cc", "tw", "ft", "wn",
+		"nu", "af", "hu", "nn", "eo", "vo", "rv", "nf", "xp", "gn",
+		"sm", "fl", "iz", "ok", "nl", "my", "gl", "aw", "ju", "oa",
+		"eq", "sy", "sl", "ps", "jo", "ja", "nk", "ze", "za", "ks",
+		"yp", "eb", "ka", "ko", "ku", "ya", "sk", "sw", "sn", "wr",
+		"ix", "xt", "oy", "ax", "ox", "lf", "lv", "db", "ao",
+	}
+)
+
+// Model is a n-gram model of legitimate domain names
+type Model struct {
+	ngrams map[string]bool
+}
+
+// NewModel creates a new Model out of a list of n-grams
+func NewModel(ngrams []string) (m *Model) {
+	m = &Model{ngrams: make(map[string]bool)}
+	for _, ngram := range ngrams {
+		if ngram = strings.ToLower(strings.TrimSpace(ngram)); len(ngram) == N {
+			m.ngrams[ngram] = true
+		}
+	}
+	return
+}
+
+// DefaultModel returns the model built from the most frequent english n-grams
+func DefaultModel() *Model {
+	return NewModel(defaultNgrams)
+}
+
+// DefaultNgrams returns the n-grams of the default model
+func DefaultNgrams() []string {
+	ngrams := make([]string, len(defaultNgrams))
+	copy(ngrams, defaultNgrams)
+	return ngrams
+}
+
+// ModelFromReader creates a new Model reading one n-gram per line
+func ModelFromReader(r io.Reader) (m *Model, err error) {
+	ngrams := make([]string, 0, len(defaultNgrams))
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ngrams = append(ngrams, scanner.Text())
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	return NewModel(ngrams), nil
+}
+
+// Len returns the number of n-grams in the model
+func (m *Model) Len() int {
+	return len(m.ngrams)
+}
+
+// label returns the part of the domain to score, dropping the top level
+// domain. Single label names (i.e. local hostnames) are not scored.
+func label(domain string) string {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	i := strings.LastIndexByte(domain, '.')
+	if i <= 0 {
+		return ""
+	}
+	return strings.NewReplacer(".", "", "-", "").Replace(domain[:i])
+}
+
+// entropy computes the Shannon entropy of s normalized in [0;1]
+func entropy(s string) float64 {
+	var h float64
+
+	freq := make(map[rune]float64)
+	for _, r := range s {
+		freq[r]++
+	}
+
+	// maximum entropy achievable for a string of that length
+	max := math.Log2(math.Min(float64(len(s)), 36))
+	if max == 0 {
+		return 0
+	}
+
+	for _, c := range freq {
+		p := c / float64(len(s))
+		h -= p * math.Log2(p)
+	}
+
+	return math.Min(h/max, 1)
+}
+
+// Score returns a score in [0;100], the higher the score the more
+// likely domain has been generated by an algorithm
+func (m *Model) Score(domain string) int {
+	var unknown, digits, total float64
+
+	s := label(domain)
+	if len(s) < minLen {
+		return 0
+	}
+
+	for i := 0; i+N <= len(s); i++ {
+		if !m.ngrams[s[i:i+N]] {
+			unknown++
+		}
+		total++
+	}
+
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+
+	score := 0.35*entropy(s) +
+		0.5*(unknown/total) +
+		0.15*(digits/float64(len(s)))
+
+	return int(math.Round(score * 100))
+}
@@ -0,0 +1,60 @@
+package dga
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestDGAScore(t *testing.T) {
+	tt := toast.FromT(t)
+
+	m := DefaultModel()
+	tt.Assert(m.Len() == len(DefaultNgrams()))
+
+	legit := []string{
+		"www.google.com",
+		"login.microsoftonline.com",
+		"github.com",
+		"update.windowsupdate.com",
+		"settings-win.data.microsoft.com",
+	}
+
+	dga := []string{
+		"xjw7qk2vbz9p.com",
+		"qzkxvbwpfj.net",
+		"a8f3kq0zx1vw7y.info",
+		"kjhzqwxvbnmcpl.biz",
+	}
+
+	for _, d := range legit {
+		score := m.Score(d)
+		t.Logf("%s: %d", d, score)
+		tt.Assert(score < 50)
+	}
+
+	for _, d := range dga {
+		score := m.Score(d)
+		t.Logf("%s: %d", d, score)
+		tt.Assert(score >= 70)
+	}
+
+	// too short to be scored
+	tt.Assert(m.Score("x1z.com") == 0)
+	// local hostnames are not scored
+	tt.Assert(m.Score("DESKTOP-LJRVE06") == 0)
+}
+
+func TestDGAModelFromReader(t *testing.T) {
+	tt := toast.FromT(t)
+
+	m, err := ModelFromReader(strings.NewReader(strings.Join(DefaultNgrams(), "\n")))
+	tt.CheckErr(err)
+	tt.Assert(m.Len() == DefaultModel().Len())
+
+	// invalid n-grams are skipped
+	m, err = ModelFromReader(strings.NewReader("abc\nTH\n\nx"))
+	tt.CheckErr(err)
+	tt.Assert(m.Len() == 1)
+}
@@ -0,0 +1,165 @@
+package ese
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	compress7BitASCII   = 1
+	compress7BitUnicode = 2
+	compressXpress      = 3
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+)
+
+// decompress decompresses a compressed column value, compression
+// algorithm is stored in upper bits of the first byte
+func decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrCorrupted
+	}
+
+	switch data[0] >> 3 {
+	case compress7BitASCII:
+		return decompress7Bit(data, false), nil
+	case compress7BitUnicode:
+		return decompress7Bit(data, true), nil
+	case compressXpress:
+		if len(data) < 3 {
+			return nil, ErrCorrupted
+		}
+		return decompressXpress(data[3:], int(binary.LittleEndian.Uint16(data[1:])))
+	}
+
+	return nil, ErrUnsupportedCompression
+}
+
+// decompress7Bit decompresses data made of 7 bits characters packed together,
+// lower bits of first byte hold the number of bits used in the last byte
+func decompress7Bit(data []byte, unicode bool) (out []byte) {
+	if len(data) < 2 {
+		return []byte{}
+	}
+
+	nbits := (len(data)-2)*8 + int(data[0]&0x7) + 1
+	out = make([]byte, 0, nbits/7*2)
+
+	var acc uint32
+	var n int
+	for _, b := range data[1:] {
+		acc |= uint32(b) << n
+		n += 8
+		for n >= 7 && nbits >= 7 {
+			out = append(out, byte(acc&0x7f))
+			if unicode {
+				out = append(out, 0)
+			}
+			acc >>= 7
+			n -= 7
+			nbits -= 7
+		}
+	}
+
+	return
+}
+
+// decompressXpress implements plain LZ77 Xpress decompression
+// as described in [MS-XCA] section 2.4
+func decompressXpress(in []byte, size int) (out []byte, err error) {
+	var flags uint32
+	var nflags int
+	var lastHalfByte int
+
+	out = make([]byte, 0, size)
+	i := 0
+
+	for len(out) < size {
+		if nflags == 0 {
+			if i+4 > len(in) {
+				return nil, ErrCorrupted
+			}
+			flags = binary.LittleEndian.Uint32(in[i:])
+			i += 4
+			nflags = 32
+		}
+		nflags--
+
+		if flags&(1<<nflags) == 0 {
+			if i >= len(in) {
+				return nil, ErrCorrupted
+			}
+			out = append(out, in[i])
+			i++
+			continue
+		}
+
+		if i+2 > len(in) {
+			return nil, ErrCorrupted
+		}
+		match := int(binary.LittleEndian.Uint16(in[i:]))
+		i += 2
+
+		length := match % 8
+		offset := match/8 + 1
+
+		if length == 7 {
+			if lastHalfByte == 0 {
+				if i >= len(in) {
+					return nil, ErrCorrupted
+				}
+				length = int(in[i] % 16)
+				lastHalfByte = i
+				i++
+			} else {
+				length = int(in[lastHalfByte] / 16)
+				lastHalfByte = 0
+			}
+
+			if length == 15 {
+				if i >= len(in) {
+					return nil, ErrCorrupted
+				}
+				length = int(in[i])
+				i++
+
+				if length == 255 {
+					if i+2 > len(in) {
+						return nil, ErrCorrupted
+					}
+					length = int(binary.LittleEndian.Uint16(in[i:]))
+					i += 2
+
+					if length == 0 {
+						if i+4 > len(in) {
+							return nil, ErrCorrupted
+						}
+						length = int(binary.LittleEndian.Uint32(in[i:]))
+						i += 4
+					}
+
+					if length < 15+7 {
+						return nil, ErrCorrupted
+					}
+					length -= 15 + 7
+				}
+				length += 15
+			}
+			length += 7
+		}
+		length += 3
+
+		if offset > len(out) || len(out)+length > size {
+			return nil, ErrCorrupted
+		}
+
+		// copy byte per byte as source and destination may overlap
+		for j := 0; j < length; j++ {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+
+	return
+}
@@ -0,0 +1,692 @@
+// Package ese implements a minimal, read-only, Extensible Storage Engine
+// (ESE, also known as JET Blue) database reader.
+//
+// It only aims at reading records of tables (i.e. SRUM database) without
+// relying on esent.dll, so that databases can be parsed from a copy or a
+// shadow copy, even when they are in a dirty state. Indexes are not used,
+// records are read by walking table B+ trees. Long values stored out of
+// records (separated long values) are not supported and read as nil.
+package ese
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	signature = 0x89abcdef
+
+	// offsets in database header
+	offSignature = 4
+	offVersion   = 8
+	offRevision  = 232
+	offPageSize  = 236
+
+	// catalog (MSysObjects) root page
+	catalogPage = 4
+
+	pageHeaderSize         = 40
+	extendedPageHeaderSize = 80
+
+	pageFlagLeaf      = 0x0002
+	pageFlagEmpty     = 0x0008
+	pageFlagSpaceTree = 0x0020
+	pageFlagIndex     = 0x0040
+	pageFlagLongValue = 0x0080
+
+	tagFlagDeleted = 0x2
+	tagFlagCommon  = 0x4
+
+	taggedFlagCompressed = 0x02
+	taggedFlagSeparated  = 0x04
+	taggedFlagMultiValue = 0x08
+
+	catalogTypeTable  = 1
+	catalogTypeColumn = 2
+
+	// column identifiers ranges
+	firstVarColumn    = 128
+	firstTaggedColumn = 256
+
+	// maximum depth of a B+ tree, protects against corrupted files
+	maxDepth = 64
+)
+
+// JET column types
+const (
+	ColtypBit           = 1
+	ColtypUnsignedByte  = 2
+	ColtypShort         = 3
+	ColtypLong          = 4
+	ColtypCurrency      = 5
+	ColtypIEEESingle    = 6
+	ColtypIEEEDouble    = 7
+	ColtypDateTime      = 8
+	ColtypBinary        = 9
+	ColtypText          = 10
+	ColtypLongBinary    = 11
+	ColtypLongText      = 12
+	ColtypUnsignedLong  = 14
+	ColtypLongLong      = 15
+	ColtypGUID          = 16
+	ColtypUnsignedShort = 17
+
+	codePageUnicode = 1200
+)
+
+var (
+	ErrNotESE          = errors.New("not an ESE database")
+	ErrCorrupted       = errors.New("corrupted database")
+	ErrNoSuchTable     = errors.New("no such table")
+	ErrUnsupportedPage = errors.New("unsupported page size")
+
+	// OLE automation dates epoch
+	oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// DB is an ESE database opened read-only
+type DB struct {
+	r      io.ReaderAt
+	closer io.Closer
+
+	pageSize int
+	// Windows 7 and later format for pages larger than 8KB
+	largePages bool
+
+	tables map[string]*Table
+}
+
+// Open opens a database file
+func Open(path string) (db *DB, err error) {
+	var f *os.File
+
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+
+	if db, err = FromReaderAt(f); err != nil {
+		f.Close()
+		return
+	}
+
+	db.closer = f
+	return
+}
+
+// FromReaderAt opens a database from a ReaderAt
+func FromReaderAt(r io.ReaderAt) (db *DB, err error) {
+	hdr := make([]byte, offPageSize+4)
+
+	if _, err = r.ReadAt(hdr, 0); err != nil {
+		return nil, ErrNotESE
+	}
+
+	if binary.LittleEndian.Uint32(hdr[offSignature:]) != signature {
+		return nil, ErrNotESE
+	}
+
+	db = &DB{r: r, pageSize: int(binary.LittleEndian.Uint32(hdr[offPageSize:]))}
+
+	switch db.pageSize {
+	case 2048, 4096, 8192, 16384, 32768:
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedPage, db.pageSize)
+	}
+
+	version := binary.LittleEndian.Uint32(hdr[offVersion:])
+	revision := binary.LittleEndian.Uint32(hdr[offRevision:])
+	db.largePages = version == 0x620 && revision >= 0x11 && db.pageSize > 8192
+
+	if err = db.loadCatalog(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	if db.closer != nil {
+		return db.closer.Close()
+	}
+	return nil
+}
+
+type page struct {
+	data  []byte
+	flags uint32
+	ntags int
+	hsize int
+	large bool
+}
+
+// page reads a page, pages are numbered from 1 and first
+// two pages of the file are database header and its shadow
+func (db *DB) page(n uint32) (p *page, err error) {
+	p = &page{data: make([]byte, db.pageSize), hsize: pageHeaderSize, large: db.largePages}
+
+	if _, err = db.r.ReadAt(p.data, int64(n+1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("%w: cannot read page %d: %s", ErrCorrupted, n, err)
+	}
+
+	if p.large {
+		p.hsize = extendedPageHeaderSize
+	}
+
+	p.flags = binary.LittleEndian.Uint32(p.data[36:])
+	p.ntags = int(binary.LittleEndian.Uint16(p.data[34:]))
+
+	if p.hsize+p.ntags*4 > len(p.data) {
+		return nil, fmt.Errorf("%w: bad tag count on page %d", ErrCorrupted, n)
+	}
+
+	return
+}
+
+// tag returns the flags and value of the ith tag of a page
+func (p *page) tag(i int) (flags uint8, value []byte, err error) {
+	var size, off int
+
+	t := p.data[len(p.data)-4*(i+1):]
+	if p.large {
+		size = int(binary.LittleEndian.Uint16(t) & 0x7fff)
+		off = int(binary.LittleEndian.Uint16(t[2:]) & 0x7fff)
+	} else {
+		size = int(binary.LittleEndian.Uint16(t) & 0x1fff)
+		off = int(binary.LittleEndian.Uint16(t[2:]) & 0x1fff)
+		flags = uint8(binary.LittleEndian.Uint16(t[2:]) >> 13)
+	}
+
+	start := p.hsize + off
+	if start+size > len(p.data) {
+		return 0, nil, fmt.Errorf("%w: tag out of page", ErrCorrupted)
+	}
+
+	value = p.data[start : start+size]
+	// with large pages, flags are stored in the upper bits of the value
+	if p.large && size >= 2 {
+		value = append([]byte{}, value...)
+		flags = value[1] >> 5
+		value[1] &= 0x1f
+	}
+
+	return
+}
+
+// entry returns the data of a leaf entry, or the child page number
+// of a branch entry, skipping entry key
+func entry(flags uint8, value []byte, leaf bool) (data []byte, child uint32, err error) {
+	if flags&tagFlagCommon != 0 {
+		// common key size
+		if len(value) < 2 {
+			return nil, 0, ErrCorrupted
+		}
+		value = value[2:]
+	}
+
+	if len(value) < 2 {
+		return nil, 0, ErrCorrupted
+	}
+
+	ksize := int(binary.LittleEndian.Uint16(value))
+	if 2+ksize > len(value) {
+		return nil, 0, ErrCorrupted
+	}
+	data = value[2+ksize:]
+
+	if !leaf {
+		if len(data) < 4 {
+			return nil, 0, ErrCorrupted
+		}
+		child = binary.LittleEndian.Uint32(data[len(data)-4:])
+	}
+
+	return
+}
+
+// walk calls fn on data of every entry of the B+ tree rooted at page root
+func (db *DB) walk(root uint32, depth int, fn func(data []byte) error) (err error) {
+	var p *page
+
+	if depth > maxDepth {
+		return fmt.Errorf("%w: tree too deep", ErrCorrupted)
+	}
+
+	if p, err = db.page(root); err != nil {
+		return
+	}
+
+	if p.flags&(pageFlagEmpty|pageFlagSpaceTree|pageFlagIndex|pageFlagLongValue) != 0 {
+		return
+	}
+
+	leaf := p.flags&pageFlagLeaf != 0
+
+	// tag 0 holds page's common key
+	for i := 1; i < p.ntags; i++ {
+		var flags uint8
+		var value, data []byte
+		var child uint32
+
+		if flags, value, err = p.tag(i); err != nil {
+			return
+		}
+
+		if flags&tagFlagDeleted != 0 {
+			continue
+		}
+
+		if data, child, err = entry(flags, value, leaf); err != nil {
+			return
+		}
+
+		if leaf {
+			err = fn(data)
+		} else {
+			err = db.walk(child, depth+1, fn)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Column is a column of a table
+type Column struct {
+	ID       uint32
+	Name     string
+	Type     uint32
+	Size     uint32
+	CodePage uint32
+}
+
+// Table is a table of the database
+type Table struct {
+	db    *DB
+	root  uint32
+	objid uint32
+
+	Name string
+	// columns sorted by identifier
+	Columns []*Column
+}
+
+func (db *DB) loadCatalog() error {
+	db.tables = make(map[string]*Table)
+	byID := make(map[uint32]*Table)
+	columns := make([]struct {
+		objid uint32
+		c     *Column
+	}, 0)
+
+	err := db.walk(catalogPage, 0, func(data []byte) error {
+		// header + ObjidTable, Type, Id, ColtypOrPgnoFDP, SpaceUsage, Flags, PagesOrLocale
+		if len(data) < 30 || data[0] < 7 || data[1] < firstVarColumn {
+			return fmt.Errorf("%w: bad catalog entry", ErrCorrupted)
+		}
+
+		objid := binary.LittleEndian.Uint32(data[4:])
+		typ := binary.LittleEndian.Uint16(data[8:])
+		id := binary.LittleEndian.Uint32(data[10:])
+		coltypOrFDP := binary.LittleEndian.Uint32(data[14:])
+		size := binary.LittleEndian.Uint32(data[18:])
+		locale := binary.LittleEndian.Uint32(data[26:])
+
+		// name is the first variable size column
+		varOff := int(binary.LittleEndian.Uint16(data[2:]))
+		nvar := int(data[1]) - firstVarColumn + 1
+		if varOff+2*nvar > len(data) {
+			return fmt.Errorf("%w: bad catalog entry", ErrCorrupted)
+		}
+		end := int(binary.LittleEndian.Uint16(data[varOff:]) & 0x7fff)
+		start := varOff + 2*nvar
+		if start+end > len(data) {
+			return fmt.Errorf("%w: bad catalog entry", ErrCorrupted)
+		}
+		name := string(data[start : start+end])
+
+		switch typ {
+		case catalogTypeTable:
+			t := &Table{db: db, root: coltypOrFDP, objid: objid, Name: name}
+			db.tables[strings.ToLower(name)] = t
+			byID[objid] = t
+		case catalogTypeColumn:
+			columns = append(columns, struct {
+				objid uint32
+				c     *Column
+			}{objid, &Column{ID: id, Name: name, Type: coltypOrFDP, Size: size, CodePage: locale}})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range columns {
+		if t, ok := byID[c.objid]; ok {
+			t.Columns = append(t.Columns, c.c)
+		}
+	}
+
+	for _, t := range db.tables {
+		sortColumns(t.Columns)
+	}
+
+	return nil
+}
+
+func sortColumns(cols []*Column) {
+	// insertion sort, catalog is already sorted by name
+	for i := 1; i < len(cols); i++ {
+		for j := i; j > 0 && cols[j].ID < cols[j-1].ID; j-- {
+			cols[j], cols[j-1] = cols[j-1], cols[j]
+		}
+	}
+}
+
+// Tables returns the names of the tables of the database
+func (db *DB) Tables() (names []string) {
+	names = make([]string, 0, len(db.tables))
+	for _, t := range db.tables {
+		names = append(names, t.Name)
+	}
+	return
+}
+
+// Table returns a table given its name (case insensitive)
+func (db *DB) Table(name string) (*Table, error) {
+	if t, ok := db.tables[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, name)
+}
+
+// Row is a record of a table, values are indexed by column name
+type Row map[string]interface{}
+
+// Int returns the value of an integer column, 0 if not an integer
+func (r Row) Int(col string) int64 {
+	if i, ok := r[col].(int64); ok {
+		return i
+	}
+	return 0
+}
+
+// String returns the value of a text column, empty string if not a text
+func (r Row) String(col string) string {
+	if s, ok := r[col].(string); ok {
+		return s
+	}
+	return ""
+}
+
+// Bytes returns the value of a binary column, nil if not a binary
+func (r Row) Bytes(col string) []byte {
+	if b, ok := r[col].([]byte); ok {
+		return b
+	}
+	return nil
+}
+
+// Time returns the value of a date time column, zero time if not a date time
+func (r Row) Time(col string) time.Time {
+	if t, ok := r[col].(time.Time); ok {
+		return t
+	}
+	return time.Time{}
+}
+
+// Rows calls fn on every record of the table, iteration stops if fn returns an error
+func (t *Table) Rows(fn func(r Row) error) error {
+	return t.db.walk(t.root, 0, func(data []byte) error {
+		r, err := t.record(data)
+		if err != nil {
+			return err
+		}
+		return fn(r)
+	})
+}
+
+// record decodes a record of the table
+func (t *Table) record(data []byte) (r Row, err error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: record too short", ErrCorrupted)
+	}
+
+	lastFixed := uint32(data[0])
+	lastVar := uint32(data[1])
+	varOff := int(binary.LittleEndian.Uint16(data[2:]))
+	nvar := 0
+	if lastVar >= firstVarColumn {
+		nvar = int(lastVar) - firstVarColumn + 1
+	}
+
+	if varOff < 4 || varOff+2*nvar > len(data) {
+		return nil, fmt.Errorf("%w: bad variable size data offset", ErrCorrupted)
+	}
+
+	// fixed size columns are stored one after the other in identifier order
+	fixed := make(map[uint32][]byte)
+	off := 4
+	for _, c := range t.Columns {
+		if c.ID > lastFixed {
+			break
+		}
+		if off+int(c.Size) > varOff {
+			return nil, fmt.Errorf("%w: fixed size data overflow", ErrCorrupted)
+		}
+		fixed[c.ID] = data[off : off+int(c.Size)]
+		off += int(c.Size)
+	}
+
+	// followed by a bitmap of null fixed size columns, which we only
+	// trust if it is where we expect it
+	nulls := data[off:varOff]
+	if len(nulls) != (int(lastFixed)+7)/8 {
+		nulls = nil
+	}
+
+	variable := make(map[uint32][]byte)
+	varData := varOff + 2*nvar
+	prev := 0
+	for i := 0; i < nvar; i++ {
+		ib := binary.LittleEndian.Uint16(data[varOff+2*i:])
+		end := int(ib & 0x7fff)
+		if end < prev || varData+end > len(data) {
+			return nil, fmt.Errorf("%w: bad variable size data", ErrCorrupted)
+		}
+		if ib&0x8000 == 0 {
+			variable[uint32(firstVarColumn+i)] = data[varData+prev : varData+end]
+		}
+		prev = end
+	}
+
+	tagged, err := t.tagged(data[varData+prev:])
+	if err != nil {
+		return
+	}
+
+	r = make(Row, len(t.Columns))
+	for _, c := range t.Columns {
+		var raw []byte
+		var ok bool
+
+		switch {
+		case c.ID < firstVarColumn:
+			if nulls != nil && nulls[(c.ID-1)/8]&(1<<((c.ID-1)%8)) != 0 {
+				break
+			}
+			raw, ok = fixed[c.ID]
+		case c.ID < firstTaggedColumn:
+			raw, ok = variable[c.ID]
+		default:
+			raw, ok = tagged[c.ID]
+		}
+
+		if !ok {
+			r[c.Name] = nil
+			continue
+		}
+
+		r[c.Name] = decode(c, raw)
+	}
+
+	return
+}
+
+// tagged decodes tagged columns area of a record
+func (t *Table) tagged(data []byte) (values map[uint32][]byte, err error) {
+	values = make(map[uint32][]byte)
+
+	if len(data) < 4 {
+		return
+	}
+
+	mask := uint16(0x3fff)
+	if t.db.largePages {
+		mask = 0x7fff
+	}
+
+	// array of (identifier, offset) ends where first value starts
+	first := int(binary.LittleEndian.Uint16(data[2:]) & mask)
+	if first < 4 || first > len(data) || first%4 != 0 {
+		return nil, fmt.Errorf("%w: bad tagged data", ErrCorrupted)
+	}
+
+	n := first / 4
+	for i := 0; i < n; i++ {
+		id := uint32(binary.LittleEndian.Uint16(data[4*i:]))
+		ib := binary.LittleEndian.Uint16(data[4*i+2:])
+		start := int(ib & mask)
+
+		end := len(data)
+		if i+1 < n {
+			end = int(binary.LittleEndian.Uint16(data[4*(i+1)+2:]) & mask)
+		}
+
+		if start > end || end > len(data) {
+			return nil, fmt.Errorf("%w: bad tagged data", ErrCorrupted)
+		}
+
+		v := data[start:end]
+		// values are prefixed with a flags byte
+		if t.db.largePages || ib&0x4000 != 0 {
+			if len(v) == 0 {
+				continue
+			}
+
+			flags := v[0]
+			v = v[1:]
+
+			switch {
+			case flags&(taggedFlagSeparated|taggedFlagMultiValue) != 0:
+				// not supported
+				continue
+			case flags&taggedFlagCompressed != 0:
+				if v, err = decompress(v); err != nil {
+					// value is skipped but record is still valid
+					err = nil
+					continue
+				}
+			}
+		}
+
+		values[id] = v
+	}
+
+	return
+}
+
+// decode decodes a raw column value according to column type
+func decode(c *Column, raw []byte) interface{} {
+	le := binary.LittleEndian
+
+	switch c.Type {
+	case ColtypBit:
+		if len(raw) >= 1 {
+			return raw[0] != 0
+		}
+	case ColtypUnsignedByte:
+		if len(raw) >= 1 {
+			return int64(raw[0])
+		}
+	case ColtypShort:
+		if len(raw) >= 2 {
+			return int64(int16(le.Uint16(raw)))
+		}
+	case ColtypUnsignedShort:
+		if len(raw) >= 2 {
+			return int64(le.Uint16(raw))
+		}
+	case ColtypLong:
+		if len(raw) >= 4 {
+			return int64(int32(le.Uint32(raw)))
+		}
+	case ColtypUnsignedLong:
+		if len(raw) >= 4 {
+			return int64(le.Uint32(raw))
+		}
+	case ColtypCurrency, ColtypLongLong:
+		if len(raw) >= 8 {
+			return int64(le.Uint64(raw))
+		}
+	case ColtypIEEESingle:
+		if len(raw) >= 4 {
+			return float64(math.Float32frombits(le.Uint32(raw)))
+		}
+	case ColtypIEEEDouble:
+		if len(raw) >= 8 {
+			return math.Float64frombits(le.Uint64(raw))
+		}
+	case ColtypDateTime:
+		if len(raw) >= 8 {
+			return OLETime(math.Float64frombits(le.Uint64(raw)))
+		}
+	case ColtypGUID:
+		if len(raw) >= 16 {
+			return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", le.Uint32(raw), le.Uint16(raw[4:]), le.Uint16(raw[6:]), raw[8:10], raw[10:16])
+		}
+	case ColtypText, ColtypLongText:
+		if c.CodePage == codePageUnicode {
+			return UTF16ToString(raw)
+		}
+		return strings.TrimRight(string(raw), "\x00")
+	default:
+		return raw
+	}
+
+	return nil
+}
+
+// OLETime converts an OLE automation date (days since 1899-12-30) to time
+func OLETime(days float64) time.Time {
+	if days == 0 || math.IsNaN(days) || math.IsInf(days, 0) {
+		return time.Time{}
+	}
+	return oleEpoch.Add(time.Duration(days * float64(24*time.Hour))).Round(time.Millisecond)
+}
+
+// UTF16ToString decodes a NUL terminated (or not) UTF-16LE string
+func UTF16ToString(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
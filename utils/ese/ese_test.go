@@ -0,0 +1,472 @@
+package ese
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/toast"
+)
+
+// minimal ESE database builder, only producing what the reader needs
+
+type tcol struct {
+	id, typ, size, cp uint32
+	name              string
+}
+
+type tentry struct {
+	flags uint8
+	value []byte
+}
+
+type builder struct {
+	pageSize int
+	large    bool
+	pages    map[uint32][]byte
+}
+
+func newBuilder(pageSize int) *builder {
+	return &builder{pageSize: pageSize, large: pageSize > 8192, pages: make(map[uint32][]byte)}
+}
+
+func (b *builder) page(n uint32, flags uint32, next uint32, entries []tentry) {
+	hsize := pageHeaderSize
+	if b.large {
+		hsize = extendedPageHeaderSize
+	}
+
+	p := make([]byte, b.pageSize)
+	binary.LittleEndian.PutUint32(p[20:], next)
+	binary.LittleEndian.PutUint32(p[36:], flags)
+
+	// tag 0 is an empty common key
+	entries = append([]tentry{{}}, entries...)
+	binary.LittleEndian.PutUint16(p[34:], uint16(len(entries)))
+
+	off := 0
+	for i, e := range entries {
+		v := append([]byte{}, e.value...)
+		t := p[len(p)-4*(i+1):]
+		if b.large {
+			if len(v) >= 2 {
+				v[1] |= e.flags << 5
+			}
+			binary.LittleEndian.PutUint16(t, uint16(len(v)))
+			binary.LittleEndian.PutUint16(t[2:], uint16(off))
+		} else {
+			binary.LittleEndian.PutUint16(t, uint16(len(v)))
+			binary.LittleEndian.PutUint16(t[2:], uint16(off)|uint16(e.flags)<<13)
+		}
+		copy(p[hsize+off:], v)
+		off += len(v)
+	}
+
+	b.pages[n] = p
+}
+
+func (b *builder) bytes() []byte {
+	var max uint32
+	for n := range b.pages {
+		if n > max {
+			max = n
+		}
+	}
+
+	out := make([]byte, int(max+2)*b.pageSize)
+	binary.LittleEndian.PutUint32(out[offSignature:], signature)
+	binary.LittleEndian.PutUint32(out[offVersion:], 0x620)
+	binary.LittleEndian.PutUint32(out[offRevision:], 0x14)
+	binary.LittleEndian.PutUint32(out[offPageSize:], uint32(b.pageSize))
+
+	for n, p := range b.pages {
+		copy(out[int(n+1)*b.pageSize:], p)
+	}
+	return out
+}
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func le64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func leafEntry(key string, data []byte, common bool) (e tentry) {
+	if common {
+		e.flags = tagFlagCommon
+		e.value = []byte{0, 0}
+	}
+	e.value = append(e.value, byte(len(key)), 0)
+	e.value = append(e.value, key...)
+	e.value = append(e.value, data...)
+	return
+}
+
+func branchEntry(key string, child uint32) tentry {
+	c := make([]byte, 4)
+	binary.LittleEndian.PutUint32(c, child)
+	return leafEntry(key, c, false)
+}
+
+// tagged value, flags < 0 means no flags byte
+type tval struct {
+	flags int
+	data  []byte
+}
+
+// record encodes a record, fixed values must have the size of their column
+// and nil values are null
+func (b *builder) record(cols []tcol, values map[uint32][]byte, tagged map[uint32]tval) []byte {
+	var lastFixed, lastVar uint32
+	for _, c := range cols {
+		if _, ok := values[c.id]; !ok {
+			if _, ok := tagged[c.id]; !ok {
+				continue
+			}
+		}
+		if c.id < firstVarColumn && c.id > lastFixed {
+			lastFixed = c.id
+		}
+		if c.id >= firstVarColumn && c.id < firstTaggedColumn && c.id > lastVar {
+			lastVar = c.id
+		}
+	}
+	if lastVar == 0 {
+		lastVar = firstVarColumn - 1
+	}
+
+	fixed := new(bytes.Buffer)
+	nulls := make([]byte, (lastFixed+7)/8)
+	for _, c := range cols {
+		if c.id <= lastFixed {
+			v := values[c.id]
+			if v == nil {
+				v = make([]byte, c.size)
+				nulls[(c.id-1)/8] |= 1 << ((c.id - 1) % 8)
+			}
+			fixed.Write(v)
+		}
+	}
+	fixed.Write(nulls)
+
+	offsets := new(bytes.Buffer)
+	vdata := new(bytes.Buffer)
+	for id := uint32(firstVarColumn); id <= lastVar; id++ {
+		v, ok := values[id]
+		vdata.Write(v)
+		ib := uint16(vdata.Len())
+		if !ok || v == nil {
+			ib |= 0x8000
+		}
+		binary.Write(offsets, binary.LittleEndian, ib)
+	}
+
+	tdata := new(bytes.Buffer)
+	if len(tagged) > 0 {
+		ids := make([]uint32, 0)
+		for _, c := range cols {
+			if _, ok := tagged[c.id]; ok {
+				ids = append(ids, c.id)
+			}
+		}
+
+		hdr := new(bytes.Buffer)
+		vals := new(bytes.Buffer)
+		for _, id := range ids {
+			tv := tagged[id]
+			ib := uint16(4*len(ids) + vals.Len())
+			if tv.flags >= 0 {
+				if !b.large {
+					ib |= 0x4000
+				}
+				vals.WriteByte(byte(tv.flags))
+			} else if b.large {
+				// flags byte is always present with large pages
+				vals.WriteByte(0)
+			}
+			vals.Write(tv.data)
+			binary.Write(hdr, binary.LittleEndian, uint16(id))
+			binary.Write(hdr, binary.LittleEndian, ib)
+		}
+		tdata.Write(hdr.Bytes())
+		tdata.Write(vals.Bytes())
+	}
+
+	rec := []byte{byte(lastFixed), byte(lastVar), 0, 0}
+	binary.LittleEndian.PutUint16(rec[2:], uint16(4+fixed.Len()))
+	rec = append(rec, fixed.Bytes()...)
+	rec = append(rec, offsets.Bytes()...)
+	rec = append(rec, vdata.Bytes()...)
+	rec = append(rec, tdata.Bytes()...)
+	return rec
+}
+
+var catalogCols = []tcol{
+	{id: 1, size: 4}, {id: 2, size: 2}, {id: 3, size: 4}, {id: 4, size: 4},
+	{id: 5, size: 4}, {id: 6, size: 4}, {id: 7, size: 4}, {id: 128},
+}
+
+func (b *builder) catalogEntry(objid uint32, typ uint16, id, coltypOrFDP, size, cp uint32, name string) tentry {
+	values := map[uint32][]byte{
+		1:   le32(objid),
+		2:   le16(typ),
+		3:   le32(id),
+		4:   le32(coltypOrFDP),
+		5:   le32(size),
+		6:   le32(0),
+		7:   le32(cp),
+		128: []byte(name),
+	}
+	return leafEntry(name, b.record(catalogCols, values, nil), false)
+}
+
+func utf16le(s string) []byte {
+	out := make([]byte, 0)
+	for _, c := range utf16.Encode([]rune(s)) {
+		out = append(out, le16(c)...)
+	}
+	return append(out, 0, 0)
+}
+
+func pack7Bit(s string) []byte {
+	out := []byte{0}
+	var acc uint32
+	var n int
+	for i := 0; i < len(s); i++ {
+		acc |= uint32(s[i]&0x7f) << n
+		n += 7
+		for n >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			n -= 8
+		}
+	}
+	last := 8
+	if n > 0 {
+		out = append(out, byte(acc))
+		last = n
+	}
+	out[0] = compress7BitASCII<<3 | byte(last-1)
+	return out
+}
+
+var (
+	dataCols = []tcol{
+		{id: 1, typ: ColtypLong, size: 4, name: "Id"},
+		{id: 2, typ: ColtypBit, size: 1, name: "Flag"},
+		{id: 3, typ: ColtypDateTime, size: 8, name: "Stamp"},
+		{id: 4, typ: ColtypLongLong, size: 8, name: "Count"},
+		{id: 5, typ: ColtypLong, size: 4, name: "Maybe"},
+		{id: 128, typ: ColtypText, cp: 1252, name: "Name"},
+		{id: 129, typ: ColtypBinary, name: "Blob"},
+		{id: 256, typ: ColtypLongText, cp: codePageUnicode, name: "Path"},
+		{id: 257, typ: ColtypLongText, cp: 1252, name: "Comp"},
+		{id: 258, typ: ColtypLongBinary, name: "Sep"},
+	}
+
+	stamp = time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+func oleDays(t time.Time) []byte {
+	d := float64(t.Sub(oleEpoch)) / float64(24*time.Hour)
+	return le64(math.Float64bits(d))
+}
+
+func buildTestDB(pageSize int) []byte {
+	b := newBuilder(pageSize)
+
+	// catalog, column entries are not in identifier order
+	catalog := []tentry{b.catalogEntry(10, catalogTypeTable, 10, 5, 0, 0, "Data")}
+	for i := len(dataCols) - 1; i >= 0; i-- {
+		c := dataCols[i]
+		catalog = append(catalog, b.catalogEntry(10, catalogTypeColumn, c.id, c.typ, c.size, c.cp, c.name))
+	}
+	catalog = append(catalog, b.catalogEntry(11, catalogTypeTable, 11, 8, 0, 0, "Empty"))
+	b.page(catalogPage, pageFlagLeaf, 0, catalog)
+
+	// Data table, a root branch page with two leaves
+	b.page(5, 0, 0, []tentry{branchEntry("k", 6), branchEntry("z", 7)})
+
+	rows := make([][]tentry, 2)
+	for i := 0; i < 10; i++ {
+		values := map[uint32][]byte{
+			1:   le32(uint32(i)),
+			2:   {byte(i % 2)},
+			3:   oleDays(stamp.Add(time.Duration(i) * time.Hour)),
+			4:   le64(uint64(i) << 40),
+			128: []byte(strings.Repeat("n", i)),
+		}
+		if i%2 == 0 {
+			values[5] = le32(uint32(0xffffffff))
+			values[129] = []byte{0xde, 0xad}
+		}
+
+		tagged := map[uint32]tval{
+			256: {flags: -1, data: utf16le(`C:\Windows\notepad.exe`)},
+		}
+		if i == 3 {
+			tagged[257] = tval{flags: taggedFlagCompressed, data: pack7Bit("compressed value")}
+			tagged[258] = tval{flags: taggedFlagSeparated, data: []byte{1, 0, 0, 0}}
+		}
+
+		e := leafEntry(string(rune('a'+i)), b.record(dataCols, values, tagged), i%3 == 0)
+		rows[i/5] = append(rows[i/5], e)
+	}
+	// deleted record
+	del := leafEntry("x", b.record(dataCols, map[uint32][]byte{1: {42, 0, 0, 0}}, nil), false)
+	del.flags |= tagFlagDeleted
+	rows[1] = append(rows[1], del)
+
+	b.page(6, pageFlagLeaf, 7, rows[0])
+	b.page(7, pageFlagLeaf, 0, rows[1])
+
+	// Empty table
+	b.page(8, pageFlagLeaf|pageFlagEmpty, 0, nil)
+
+	return b.bytes()
+}
+
+func TestReadTable(t *testing.T) {
+	for _, ps := range []int{4096, 32768} {
+		tt := toast.FromT(t)
+
+		db, err := FromReaderAt(bytes.NewReader(buildTestDB(ps)))
+		tt.CheckErr(err)
+		tt.Assert(len(db.Tables()) == 2)
+
+		_, err = db.Table("unknown")
+		tt.Assert(errors.Is(err, ErrNoSuchTable))
+
+		tbl, err := db.Table("data")
+		tt.CheckErr(err)
+		tt.Assert(len(tbl.Columns) == len(dataCols))
+		tt.Assert(tbl.Columns[0].Name == "Id")
+
+		n := 0
+		tt.CheckErr(tbl.Rows(func(r Row) error {
+			i := r.Int("Id")
+			tt.Assert(i == int64(n), "page size", ps)
+			tt.Assert(r["Flag"] == (i%2 == 1))
+			tt.Assert(r.Time("Stamp").Equal(stamp.Add(time.Duration(i)*time.Hour)), r.Time("Stamp"))
+			tt.Assert(r.Int("Count") == i<<40)
+			tt.Assert(r.String("Name") == strings.Repeat("n", int(i)))
+			tt.Assert(r.String("Path") == `C:\Windows\notepad.exe`, r["Path"])
+
+			if i%2 == 0 {
+				tt.Assert(r.Int("Maybe") == -1)
+				tt.Assert(bytes.Equal(r.Bytes("Blob"), []byte{0xde, 0xad}))
+			} else {
+				tt.Assert(r["Maybe"] == nil)
+				tt.Assert(r["Blob"] == nil)
+			}
+
+			if i == 3 {
+				tt.Assert(r.String("Comp") == "compressed value", r["Comp"])
+			} else {
+				tt.Assert(r["Comp"] == nil)
+			}
+			// separated long values are not supported
+			tt.Assert(r["Sep"] == nil)
+
+			n++
+			return nil
+		}))
+		tt.Assert(n == 10)
+
+		tbl, err = db.Table("Empty")
+		tt.CheckErr(err)
+		tt.CheckErr(tbl.Rows(func(r Row) error {
+			t.Error("no row expected")
+			return nil
+		}))
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	tt := toast.FromT(t)
+
+	_, err := FromReaderAt(bytes.NewReader([]byte("not an ESE database")))
+	tt.Assert(errors.Is(err, ErrNotESE))
+
+	data := buildTestDB(4096)
+
+	// truncated database
+	_, err = FromReaderAt(bytes.NewReader(data[:catalogPage*4096]))
+	tt.Assert(errors.Is(err, ErrCorrupted))
+
+	// bad page size
+	bad := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(bad[offPageSize:], 1234)
+	_, err = FromReaderAt(bytes.NewReader(bad))
+	tt.Assert(errors.Is(err, ErrUnsupportedPage))
+
+	// garbage must not make reader panic
+	for i := 0; i < 256; i++ {
+		bad := append([]byte{}, data...)
+		for j := 6 * 4096; j < 8*4096; j += 7 + i {
+			bad[j] ^= byte(i + 1)
+		}
+		if db, err := FromReaderAt(bytes.NewReader(bad)); err == nil {
+			tbl, _ := db.Table("Data")
+			tbl.Rows(func(r Row) error { return nil })
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// examples from [MS-XCA] section 3.1
+	alphabet := []byte("abcdefghijklmnopqrstuvwxyz")
+	in := append([]byte{0x3f, 0, 0, 0}, alphabet...)
+	out, err := decompressXpress(in, len(alphabet))
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(out, alphabet))
+
+	abc := bytes.Repeat([]byte("abc"), 100)
+	out, err = decompressXpress([]byte{0xff, 0xff, 0xff, 0x1f, 0x61, 0x62, 0x63, 0x17, 0x00, 0x0f, 0xff, 0x26, 0x01}, len(abc))
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(out, abc))
+
+	// through ESE compression header
+	hdr := []byte{compressXpress << 3, 0, 0}
+	binary.LittleEndian.PutUint16(hdr[1:], uint16(len(abc)))
+	out, err = decompress(append(hdr, 0xff, 0xff, 0xff, 0x1f, 0x61, 0x62, 0x63, 0x17, 0x00, 0x0f, 0xff, 0x26, 0x01))
+	tt.CheckErr(err)
+	tt.Assert(bytes.Equal(out, abc))
+
+	_, err = decompressXpress([]byte{0xff, 0xff, 0xff, 0x1f, 0x61}, 10)
+	tt.Assert(errors.Is(err, ErrCorrupted))
+
+	for _, s := range []string{"", "a", "abcdefg", "abcdefgh", "Hello World!"} {
+		out, err = decompress(pack7Bit(s))
+		tt.CheckErr(err)
+		tt.Assert(string(out) == s, out)
+	}
+
+	p := pack7Bit("hi")
+	p[0] = compress7BitUnicode<<3 | p[0]&7
+	out, err = decompress(p)
+	tt.CheckErr(err)
+	tt.Assert(UTF16ToString(out) == "hi")
+
+	_, err = decompress([]byte{0xf8})
+	tt.Assert(errors.Is(err, ErrUnsupportedCompression))
+}
@@ -0,0 +1,235 @@
+package ja3
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	recordTypeHandshake = 0x16
+
+	handshakeClientHello = 0x01
+	handshakeServerHello = 0x02
+
+	extSupportedGroups = 0x000a
+	extPointFormats    = 0x000b
+)
+
+var (
+	ErrTruncated    = errors.New("truncated TLS message")
+	ErrNotHandshake = errors.New("not a TLS handshake message")
+)
+
+// reader is a minimal big endian reader over a byte slice
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) (b []byte) {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = ErrTruncated
+		return nil
+	}
+	b, r.b = r.b[:n], r.b[n:]
+	return
+}
+
+func (r *reader) u8() int {
+	if b := r.next(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *reader) u16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) u24() int {
+	if b := r.next(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// vector returns a sub reader over a vector prefixed by its length
+func (r *reader) vector(lenSize int) *reader {
+	var n int
+
+	switch lenSize {
+	case 1:
+		n = r.u8()
+	case 2:
+		n = r.u16()
+	case 3:
+		n = r.u24()
+	}
+
+	return &reader{b: r.next(n), err: r.err}
+}
+
+// isGrease returns true if value is a GREASE value (RFC 8701)
+func isGrease(v int) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func join(values []int) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGrease(v) {
+			s = append(s, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(s, "-")
+}
+
+// handshake strips out the record layer if any and returns a
+// reader positioned after the handshake header of type typ
+func handshake(b []byte, typ int) (r *reader, err error) {
+	r = &reader{b: b}
+
+	if len(b) > 0 && b[0] == recordTypeHandshake {
+		// content type and protocol version
+		r.next(3)
+		r = r.vector(2)
+	}
+
+	if r.u8() != typ {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, ErrNotHandshake
+	}
+
+	r = r.vector(3)
+	return r, r.err
+}
+
+// Fingerprint holds a JA3 or JA3S fingerprint
+type Fingerprint struct {
+	// full fingerprint string
+	String string
+	// md5 of fingerprint string
+	Hash string
+}
+
+func newFingerprint(fields ...string) *Fingerprint {
+	s := strings.Join(fields, ",")
+	h := md5.Sum([]byte(s))
+	return &Fingerprint{String: s, Hash: hex.EncodeToString(h[:])}
+}
+
+// extensions parses extensions and returns their types, supported
+// groups and point formats
+func extensions(r *reader) (types, groups, formats []int) {
+	types = make([]int, 0)
+	groups = make([]int, 0)
+	formats = make([]int, 0)
+
+	// extensions are optional
+	if len(r.b) == 0 {
+		return
+	}
+
+	exts := r.vector(2)
+	for len(exts.b) > 0 && exts.err == nil {
+		typ := exts.u16()
+		data := exts.vector(2)
+		types = append(types, typ)
+
+		switch typ {
+		case extSupportedGroups:
+			list := data.vector(2)
+			for len(list.b) > 1 {
+				groups = append(groups, list.u16())
+			}
+		case extPointFormats:
+			list := data.vector(1)
+			for len(list.b) > 0 {
+				formats = append(formats, list.u8())
+			}
+		}
+	}
+
+	r.err = exts.err
+	return
+}
+
+// ClientHello computes the JA3 fingerprint of a TLS ClientHello message,
+// with or without its record layer
+func ClientHello(b []byte) (f *Fingerprint, err error) {
+	var r *reader
+
+	if r, err = handshake(b, handshakeClientHello); err != nil {
+		return
+	}
+
+	version := r.u16()
+	// random
+	r.next(32)
+	// session id
+	r.vector(1)
+
+	ciphers := make([]int, 0)
+	cr := r.vector(2)
+	for len(cr.b) > 1 {
+		ciphers = append(ciphers, cr.u16())
+	}
+
+	// compression methods
+	r.vector(1)
+
+	types, groups, formats := extensions(r)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return newFingerprint(
+		fmt.Sprint(version),
+		join(ciphers),
+		join(types),
+		join(groups),
+		join(formats)), nil
+}
+
+// ServerHello computes the JA3S fingerprint of a TLS ServerHello message,
+// with or without its record layer
+func ServerHello(b []byte) (f *Fingerprint, err error) {
+	var r *reader
+
+	if r, err = handshake(b, handshakeServerHello); err != nil {
+		return
+	}
+
+	version := r.u16()
+	// random
+	r.next(32)
+	// session id
+	r.vector(1)
+	cipher := r.u16()
+	// compression method
+	r.u8()
+
+	types, _, _ := extensions(r)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return newFingerprint(
+		fmt.Sprint(version),
+		fmt.Sprint(cipher),
+		join(types)), nil
+}
@@ -0,0 +1,122 @@
+package ja3
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func u16(v int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func vec(lenSize int, data ...[]byte) (out []byte) {
+	b := make([]byte, 0)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+
+	switch lenSize {
+	case 1:
+		out = []byte{byte(len(b))}
+	case 2:
+		out = u16(len(b))
+	case 3:
+		out = []byte{byte(len(b) >> 16), byte(len(b) >> 8), byte(len(b))}
+	}
+
+	return append(out, b...)
+}
+
+func ext(typ int, data ...[]byte) []byte {
+	return append(u16(typ), vec(2, data...)...)
+}
+
+func record(typ byte, body ...[]byte) []byte {
+	hs := append([]byte{typ}, vec(3, body...)...)
+	return append([]byte{recordTypeHandshake, 0x03, 0x01}, vec(2, hs)...)
+}
+
+func TestClientHello(t *testing.T) {
+	tt := toast.FromT(t)
+
+	hello := record(handshakeClientHello,
+		u16(0x0303),
+		make([]byte, 32),
+		vec(1),
+		// ciphers with a GREASE value
+		vec(2, u16(0x0a0a), u16(0x1301), u16(0xc02b)),
+		vec(1, []byte{0}),
+		vec(2,
+			ext(0x1a1a),
+			ext(0x0000),
+			ext(extSupportedGroups, vec(2, u16(0x2a2a), u16(0x001d), u16(0x0017))),
+			ext(extPointFormats, vec(1, []byte{0})),
+		),
+	)
+
+	f, err := ClientHello(hello)
+	tt.CheckErr(err)
+	tt.Assert(f.String == "771,4865-49195,0-10-11,29-23,0", f.String)
+	tt.Assert(len(f.Hash) == 32)
+
+	// without record layer
+	g, err := ClientHello(hello[5:])
+	tt.CheckErr(err)
+	tt.Assert(*f == *g)
+
+	// truncated
+	_, err = ClientHello(hello[:len(hello)-3])
+	tt.Assert(err != nil)
+
+	// not a client hello
+	_, err = ServerHello(hello)
+	tt.Assert(err == ErrNotHandshake)
+}
+
+func TestServerHello(t *testing.T) {
+	tt := toast.FromT(t)
+
+	hello := record(handshakeServerHello,
+		u16(0x0303),
+		make([]byte, 32),
+		vec(1),
+		u16(0x1301),
+		[]byte{0},
+		vec(2,
+			ext(0x002b, u16(0x0304)),
+			ext(0x0033),
+		),
+	)
+
+	f, err := ServerHello(hello)
+	tt.CheckErr(err)
+	tt.Assert(f.String == "771,4865,43-51", f.String)
+}
+
+func TestGoClientHello(t *testing.T) {
+	tt := toast.FromT(t)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		tls.Client(client, &tls.Config{ServerName: "example.com"}).Handshake()
+	}()
+
+	buf := make([]byte, 1<<14)
+	n, err := server.Read(buf)
+	tt.CheckErr(err)
+
+	f, err := ClientHello(buf[:n])
+	tt.CheckErr(err)
+	t.Log(f.String)
+	tt.Assert(strings.HasPrefix(f.String, "771,"))
+}
@@ -1,9 +1,29 @@
 package utils
 
-import "net"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ipBytes returns the shortest representation of ip, IPv4 addresses
+// are 4 bytes long so that computations do not overflow in IPv4-mapped
+// IPv6 prefix
+func ipBytes(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
 
 // derived from: https://gist.github.com/kotakanbe/d3059af990252ba89a82
+// NextIP returns the IP following ip, works with both IPv4 and IPv6
 func NextIP(ip net.IP) net.IP {
+	ip = ipBytes(ip)
 	nip := net.IP(make(net.IP, len(ip)))
 	copy(nip, ip)
 	for j := len(nip) - 1; j >= 0; j-- {
@@ -16,7 +36,9 @@ func NextIP(ip net.IP) net.IP {
 }
 
 // derived from: https://gist.github.com/kotakanbe/d3059af990252ba89a82
+// PrevIP returns the IP preceding ip, works with both IPv4 and IPv6
 func PrevIP(ip net.IP) net.IP {
+	ip = ipBytes(ip)
 	nip := net.IP(make(net.IP, len(ip)))
 	copy(nip, ip)
 	for j := len(nip) - 1; j >= 0; j-- {
@@ -27,3 +49,109 @@ func PrevIP(ip net.IP) net.IP {
 	}
 	return nip
 }
+
+// CanonicalIP returns the canonical string representation of an IP address
+// (i.e. compressed lower case IPv6, dotted IPv4 for IPv4-mapped IPv6) so that
+// IPs formatted differently can be compared. Non IP strings are returned as is.
+func CanonicalIP(s string) string {
+	if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+		return ip.String()
+	}
+	return s
+}
+
+// BlockIPv4Ranges returns the IPv4 ranges (formatted as FIRST-LAST, or
+// IP for single addresses) to block in order to only allow ips. Non IPv4
+// addresses are ignored.
+func BlockIPv4Ranges(ips []net.IP) (ranges []string) {
+	allowed := make([]uint32, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			allowed = append(allowed, binary.BigEndian.Uint32(ip4))
+		}
+	}
+
+	sort.Slice(allowed, func(i, j int) bool { return allowed[i] < allowed[j] })
+
+	format := func(first, last uint32) string {
+		var f, l [4]byte
+		binary.BigEndian.PutUint32(f[:], first)
+		binary.BigEndian.PutUint32(l[:], last)
+		if first == last {
+			return net.IP(f[:]).String()
+		}
+		return fmt.Sprintf("%s-%s", net.IP(f[:]), net.IP(l[:]))
+	}
+
+	ranges = make([]string, 0, len(allowed)+1)
+	// next address to block
+	next := uint64(0)
+	for _, a := range allowed {
+		if uint64(a) > next {
+			ranges = append(ranges, format(uint32(next), a-1))
+		}
+		if uint64(a) >= next {
+			next = uint64(a) + 1
+		}
+	}
+
+	if next <= math.MaxUint32 {
+		ranges = append(ranges, format(uint32(next), math.MaxUint32))
+	}
+
+	return
+}
+
+// BlockIPv6Ranges returns the IPv6 ranges (formatted as FIRST-LAST, or
+// IP for single addresses) to block in order to only allow ips. IPv4
+// addresses are ignored.
+func BlockIPv6Ranges(ips []net.IP) (ranges []string) {
+	allowed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip.To4() == nil && ip.To16() != nil {
+			allowed = append(allowed, ip.To16())
+		}
+	}
+
+	sort.Slice(allowed, func(i, j int) bool { return bytes.Compare(allowed[i], allowed[j]) < 0 })
+
+	format := func(first, last net.IP) string {
+		if first.Equal(last) {
+			return first.String()
+		}
+		return fmt.Sprintf("%s-%s", first, last)
+	}
+
+	first, last := net.IPv6zero, net.IP(bytes.Repeat([]byte{0xff}, net.IPv6len))
+
+	ranges = make([]string, 0, len(allowed)+1)
+	// next address to block, nil once address space is exhausted
+	next := first
+	for _, a := range allowed {
+		if next == nil {
+			break
+		}
+		if bytes.Compare(a, next) > 0 {
+			ranges = append(ranges, format(next, PrevIP(a)))
+		}
+		if bytes.Compare(a, next) >= 0 {
+			if a.Equal(last) {
+				next = nil
+			} else {
+				next = NextIP(a)
+			}
+		}
+	}
+
+	if next != nil {
+		ranges = append(ranges, format(next, last))
+	}
+
+	return
+}
+
+// BlockIPRanges returns both the IPv4 and IPv6 ranges to block
+// in order to only allow ips
+func BlockIPRanges(ips []net.IP) (ranges []string) {
+	return append(BlockIPv4Ranges(ips), BlockIPv6Ranges(ips)...)
+}
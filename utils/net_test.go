@@ -14,4 +14,85 @@ func TestPrevNextIP(t *testing.T) {
 	ip := net.ParseIP("192.168.1.42")
 	tt.Assert(PrevIP(ip).String() == "192.168.1.41")
 	tt.Assert(NextIP(ip).String() == "192.168.1.43")
+
+	// does not overflow in IPv4-mapped prefix
+	ip = net.ParseIP("255.255.255.255")
+	tt.Assert(NextIP(ip).String() == "0.0.0.0")
+
+	ip = net.ParseIP("2001:db8::ffff")
+	tt.Assert(PrevIP(ip).String() == "2001:db8::fffe")
+	tt.Assert(NextIP(ip).String() == "2001:db8::1:0")
+}
+
+func TestCanonicalIP(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	tt.Assert(CanonicalIP("2001:0DB8:0:0:0:0:0:1") == "2001:db8::1")
+	tt.Assert(CanonicalIP("::ffff:192.168.1.42") == "192.168.1.42")
+	tt.Assert(CanonicalIP("10.0.0.1") == "10.0.0.1")
+	tt.Assert(CanonicalIP("not an ip") == "not an ip")
+}
+
+func TestBlockIPv6Ranges(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	ranges := BlockIPv6Ranges([]net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("10.0.0.1"),
+	})
+	t.Log(ranges)
+	tt.Assert(len(ranges) == 3)
+	tt.Assert(ranges[0] == "::-2001:db8::")
+	tt.Assert(ranges[1] == "2001:db8::3-fe80::")
+	tt.Assert(ranges[2] == "fe80::2-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+
+	// edges of address space
+	ranges = BlockIPv6Ranges([]net.IP{net.ParseIP("::"), net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")})
+	tt.Assert(len(ranges) == 1)
+	tt.Assert(ranges[0] == "::1-ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe")
+
+	// nothing allowed
+	ranges = BlockIPv6Ranges(nil)
+	tt.Assert(len(ranges) == 1)
+	tt.Assert(ranges[0] == "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+
+	// both address families
+	ranges = BlockIPRanges([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")})
+	tt.Assert(len(ranges) == 4)
+}
+
+func TestBlockIPv4Ranges(t *testing.T) {
+	t.Parallel()
+	tt := toast.FromT(t)
+
+	ranges := BlockIPv4Ranges([]net.IP{
+		net.ParseIP("192.168.1.42"),
+		net.ParseIP("10.0.0.1"),
+		net.ParseIP("10.0.0.2"),
+		net.ParseIP("192.168.1.42"),
+		net.ParseIP("::1"),
+	})
+	t.Log(ranges)
+	tt.Assert(len(ranges) == 3)
+	tt.Assert(ranges[0] == "0.0.0.0-10.0.0.0")
+	tt.Assert(ranges[1] == "10.0.0.3-192.168.1.41")
+	tt.Assert(ranges[2] == "192.168.1.43-255.255.255.255")
+
+	// edges of address space
+	ranges = BlockIPv4Ranges([]net.IP{net.ParseIP("0.0.0.0"), net.ParseIP("255.255.255.255")})
+	tt.Assert(len(ranges) == 1)
+	tt.Assert(ranges[0] == "0.0.0.1-255.255.255.254")
+
+	// single address
+	ranges = BlockIPv4Ranges([]net.IP{net.ParseIP("0.0.0.1")})
+	tt.Assert(ranges[0] == "0.0.0.0")
+
+	// nothing allowed
+	ranges = BlockIPv4Ranges(nil)
+	tt.Assert(len(ranges) == 1)
+	tt.Assert(ranges[0] == "0.0.0.0-255.255.255.255")
 }
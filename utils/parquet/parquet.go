@@ -0,0 +1,234 @@
+// Package parquet implements a minimal Apache Parquet file writer. Files
+// are made of a single row group of required, flat, PLAIN encoded and
+// uncompressed columns which is enough to export tabular data to be
+// processed by common data analysis tools.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Type is the type of a column
+type Type int32
+
+// Supported column types
+const (
+	Int64  = Type(2)
+	String = Type(6)
+)
+
+const (
+	magic     = "PAR1"
+	createdBy = "whids parquet writer"
+
+	// Parquet enums
+	pageTypeData        = 0
+	encodingPlain       = 0
+	encodingRLE         = 3
+	codecUncompressed   = 0
+	repetitionRequired  = 0
+	convertedTypeUTF8   = 0
+	fileMetadataVersion = 1
+)
+
+var (
+	ErrColumnCount = fmt.Errorf("wrong number of values in row")
+)
+
+// Column describes a column of a Parquet file
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Writer accumulates rows and writes them as a Parquet file
+type Writer struct {
+	columns []Column
+	pages   []*bytes.Buffer
+	rows    int64
+}
+
+// NewWriter creates a new Writer for the given columns
+func NewWriter(columns ...Column) *Writer {
+	w := &Writer{
+		columns: columns,
+		pages:   make([]*bytes.Buffer, len(columns)),
+	}
+
+	for i := range w.pages {
+		w.pages[i] = new(bytes.Buffer)
+	}
+
+	return w
+}
+
+// Rows returns the number of rows appended
+func (w *Writer) Rows() int64 {
+	return w.rows
+}
+
+// Append appends a row, values must be in the order of the columns
+// and of type int64 (or int) for Int64 columns and string for String ones
+func (w *Writer) Append(values ...interface{}) (err error) {
+	var b [8]byte
+
+	if len(values) != len(w.columns) {
+		return ErrColumnCount
+	}
+
+	// checking types first not to append partial rows
+	for i, v := range values {
+		switch v.(type) {
+		case int, int64:
+			if w.columns[i].Type == Int64 {
+				continue
+			}
+		case string:
+			if w.columns[i].Type == String {
+				continue
+			}
+		}
+		return fmt.Errorf("bad value type %T for column %s", v, w.columns[i].Name)
+	}
+
+	for i, v := range values {
+		page := w.pages[i]
+		switch v := v.(type) {
+		case int:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			page.Write(b[:])
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			page.Write(b[:])
+		case string:
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+			page.Write(b[:4])
+			page.WriteString(v)
+		}
+	}
+
+	w.rows++
+	return
+}
+
+func (w *Writer) pageHeader(size int) []byte {
+	c := compact{}
+	c.begin()
+	c.i32(1, pageTypeData)
+	c.i32(2, int32(size))
+	c.i32(3, int32(size))
+	c.structure(5, func() {
+		c.i32(1, int32(w.rows))
+		c.i32(2, encodingPlain)
+		c.i32(3, encodingRLE)
+		c.i32(4, encodingRLE)
+	})
+	c.end()
+	return c.buf.Bytes()
+}
+
+type chunk struct {
+	offset int64
+	size   int64
+}
+
+func (w *Writer) footer(chunks []chunk) []byte {
+	var total int64
+
+	c := compact{}
+	c.begin()
+	c.i32(1, fileMetadataVersion)
+
+	// schema
+	c.list(2, tStruct, len(w.columns)+1)
+	c.elemStruct(func() {
+		c.binary(4, "schema")
+		c.i32(5, int32(len(w.columns)))
+	})
+	for _, col := range w.columns {
+		col := col
+		c.elemStruct(func() {
+			c.i32(1, int32(col.Type))
+			c.i32(3, repetitionRequired)
+			c.binary(4, col.Name)
+			if col.Type == String {
+				c.i32(6, convertedTypeUTF8)
+			}
+		})
+	}
+
+	c.i64(3, w.rows)
+
+	// row groups
+	for _, ch := range chunks {
+		total += ch.size
+	}
+	c.list(4, tStruct, 1)
+	c.elemStruct(func() {
+		c.list(1, tStruct, len(w.columns))
+		for i, col := range w.columns {
+			col, ch := col, chunks[i]
+			c.elemStruct(func() {
+				c.i64(2, ch.offset)
+				c.structure(3, func() {
+					c.i32(1, int32(col.Type))
+					c.list(2, tI32, 1)
+					c.elemI32(encodingPlain)
+					c.list(3, tBinary, 1)
+					c.elemBinary(col.Name)
+					c.i32(4, codecUncompressed)
+					c.i64(5, w.rows)
+					c.i64(6, ch.size)
+					c.i64(7, ch.size)
+					c.i64(9, ch.offset)
+				})
+			})
+		}
+		c.i64(2, total)
+		c.i64(3, w.rows)
+	})
+
+	c.binary(6, createdBy)
+	c.end()
+
+	return c.buf.Bytes()
+}
+
+// WriteTo writes the Parquet file to out
+func (w *Writer) WriteTo(out io.Writer) (n int64, err error) {
+	var m int
+	var b [4]byte
+
+	write := func(p []byte) bool {
+		m, err = out.Write(p)
+		n += int64(m)
+		return err == nil
+	}
+
+	chunks := make([]chunk, len(w.columns))
+
+	if !write([]byte(magic)) {
+		return
+	}
+
+	for i, page := range w.pages {
+		header := w.pageHeader(page.Len())
+		chunks[i] = chunk{offset: n, size: int64(len(header) + page.Len())}
+		if !write(header) || !write(page.Bytes()) {
+			return
+		}
+	}
+
+	footer := w.footer(chunks)
+	binary.LittleEndian.PutUint32(b[:], uint32(len(footer)))
+
+	if !write(footer) || !write(b[:]) {
+		return
+	}
+
+	write([]byte(magic))
+	return
+}
@@ -0,0 +1,59 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+func TestWriter(t *testing.T) {
+	tt := toast.FromT(t)
+
+	w := NewWriter(
+		Column{Name: "Name", Type: String},
+		Column{Name: "Value", Type: Int64},
+	)
+
+	for i := 0; i < 100; i++ {
+		tt.CheckErr(w.Append("name", i))
+	}
+	tt.Assert(w.Rows() == 100)
+
+	// wrong number of values
+	tt.ExpectErr(w.Append("name"), ErrColumnCount)
+	// wrong value type
+	tt.Assert(w.Append(42, "name") != nil)
+	// failed appends must not add rows
+	tt.Assert(w.Rows() == 100)
+
+	buf := new(bytes.Buffer)
+	n, err := w.WriteTo(buf)
+	tt.CheckErr(err)
+	tt.Assert(n == int64(buf.Len()))
+
+	b := buf.Bytes()
+	tt.Assert(string(b[:4]) == magic)
+	tt.Assert(string(b[len(b)-4:]) == magic)
+
+	// footer length must point within file
+	flen := binary.LittleEndian.Uint32(b[len(b)-8 : len(b)-4])
+	tt.Assert(int(flen) < len(b)-12)
+	footer := b[len(b)-8-int(flen) : len(b)-8]
+	tt.Assert(bytes.Contains(footer, []byte(createdBy)))
+	tt.Assert(bytes.Contains(footer, []byte("Name")))
+	tt.Assert(bytes.Contains(footer, []byte("Value")))
+}
+
+func TestWriterEmpty(t *testing.T) {
+	tt := toast.FromT(t)
+
+	w := NewWriter(Column{Name: "Name", Type: String})
+
+	buf := new(bytes.Buffer)
+	_, err := w.WriteTo(buf)
+	tt.CheckErr(err)
+	tt.Assert(bytes.HasPrefix(buf.Bytes(), []byte(magic)))
+	tt.Assert(bytes.HasSuffix(buf.Bytes(), []byte(magic)))
+}
@@ -0,0 +1,101 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// compact is a minimal Thrift compact protocol encoder, it only
+// implements what is needed to encode Parquet metadata
+type compact struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (c *compact) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	c.buf.Write(b[:n])
+}
+
+func (c *compact) zigzag(v int64) {
+	c.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compact) field(id int16, typ byte) {
+	last := c.last[len(c.last)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.zigzag(int64(id))
+	}
+	c.last[len(c.last)-1] = id
+}
+
+func (c *compact) begin() {
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) end() {
+	c.buf.WriteByte(0)
+	c.last = c.last[:len(c.last)-1]
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, tI32)
+	c.zigzag(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, tI64)
+	c.zigzag(v)
+}
+
+func (c *compact) binary(id int16, s string) {
+	c.field(id, tBinary)
+	c.varint(uint64(len(s)))
+	c.buf.WriteString(s)
+}
+
+func (c *compact) structure(id int16, f func()) {
+	c.field(id, tStruct)
+	c.begin()
+	f()
+	c.end()
+}
+
+func (c *compact) list(id int16, typ byte, size int) {
+	c.field(id, tList)
+	if size < 15 {
+		c.buf.WriteByte(byte(size)<<4 | typ)
+	} else {
+		c.buf.WriteByte(0xf0 | typ)
+		c.varint(uint64(size))
+	}
+}
+
+// list elements
+func (c *compact) elemI32(v int32) {
+	c.zigzag(int64(v))
+}
+
+func (c *compact) elemBinary(s string) {
+	c.varint(uint64(len(s)))
+	c.buf.WriteString(s)
+}
+
+func (c *compact) elemStruct(f func()) {
+	c.begin()
+	f()
+	c.end()
+}
@@ -0,0 +1,114 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procGetProcessMemoryInfo  = modKernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessIoCounters  = modKernel32.NewProc("GetProcessIoCounters")
+	procGetProcessHandleCount = modKernel32.NewProc("GetProcessHandleCount")
+)
+
+// processMemoryCountersEx is PROCESS_MEMORY_COUNTERS_EX structure
+type processMemoryCountersEx struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+	PrivateUsage               uintptr
+}
+
+// ioCounters is IO_COUNTERS structure
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+// ProcessResources holds the resources used by a process since it started
+type ProcessResources struct {
+	UserTime       time.Duration
+	KernelTime     time.Duration
+	WorkingSet     uint64
+	PeakWorkingSet uint64
+	PrivateBytes   uint64
+	PageFaults     uint32
+	ReadOps        uint64
+	WriteOps       uint64
+	ReadBytes      uint64
+	WriteBytes     uint64
+	Handles        uint32
+}
+
+// CPUTime returns the total CPU time used by the process
+func (r *ProcessResources) CPUTime() time.Duration {
+	return r.UserTime + r.KernelTime
+}
+
+func filetimeDuration(ft *syscall.Filetime) time.Duration {
+	// FILETIME durations are expressed in 100ns intervals
+	return time.Duration((int64(ft.HighDateTime)<<32)|int64(ft.LowDateTime)) * 100
+}
+
+// ProcessResourcesFromHandle returns the resources used by process hProcess
+func ProcessResourcesFromHandle(hProcess syscall.Handle) (r ProcessResources, err error) {
+	var creation, exit, kernel, user syscall.Filetime
+	var mem processMemoryCountersEx
+	var io ioCounters
+	var handles uint32
+
+	if err = syscall.GetProcessTimes(hProcess, &creation, &exit, &kernel, &user); err != nil {
+		return
+	}
+	r.UserTime = filetimeDuration(&user)
+	r.KernelTime = filetimeDuration(&kernel)
+
+	mem.Cb = uint32(unsafe.Sizeof(mem))
+	if ok, _, e := procGetProcessMemoryInfo.Call(uintptr(hProcess), uintptr(unsafe.Pointer(&mem)), uintptr(mem.Cb)); ok == 0 {
+		return r, e
+	}
+	r.WorkingSet = uint64(mem.WorkingSetSize)
+	r.PeakWorkingSet = uint64(mem.PeakWorkingSetSize)
+	r.PrivateBytes = uint64(mem.PrivateUsage)
+	r.PageFaults = mem.PageFaultCount
+
+	if ok, _, e := procGetProcessIoCounters.Call(uintptr(hProcess), uintptr(unsafe.Pointer(&io))); ok == 0 {
+		return r, e
+	}
+	r.ReadOps = io.ReadOperationCount
+	r.WriteOps = io.WriteOperationCount
+	r.ReadBytes = io.ReadTransferCount
+	r.WriteBytes = io.WriteTransferCount
+
+	if ok, _, e := procGetProcessHandleCount.Call(uintptr(hProcess), uintptr(unsafe.Pointer(&handles))); ok == 0 {
+		return r, e
+	}
+	r.Handles = handles
+
+	return
+}
+
+// SelfResources returns the resources used by current process
+func SelfResources() (ProcessResources, error) {
+	// pseudo handle, does not need to be closed
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return ProcessResources{}, err
+	}
+	return ProcessResourcesFromHandle(h)
+}
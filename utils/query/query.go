@@ -0,0 +1,375 @@
+// Package query implements a small boolean query language used to
+// select documents (i.e. endpoints) out of their fields and tags.
+//
+// Example: os.build >= 22000 AND (tag:laptop OR hostname ~ "^ws-")
+//
+// Supported operators are =, !=, <, <=, >, >= and ~ (regular expression
+// match). Values are compared as numbers if both sides are numbers and
+// as case insensitive strings otherwise. Expressions can be combined
+// with AND, OR, NOT and parenthesis.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Document is the interface a document must implement to be matched by a Query
+type Document interface {
+	// Field returns the value of a field and true if the field exists
+	Field(name string) (string, bool)
+	// HasTag returns true if the document has tag
+	HasTag(tag string) bool
+}
+
+type node interface {
+	match(Document) bool
+}
+
+type and struct{ left, right node }
+
+func (n *and) match(d Document) bool { return n.left.match(d) && n.right.match(d) }
+
+type or struct{ left, right node }
+
+func (n *or) match(d Document) bool { return n.left.match(d) || n.right.match(d) }
+
+type not struct{ n node }
+
+func (n *not) match(d Document) bool { return !n.n.match(d) }
+
+type tag struct{ name string }
+
+func (n *tag) match(d Document) bool { return d.HasTag(n.name) }
+
+type comparison struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func compare(a, b string) int {
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func (n *comparison) match(d Document) bool {
+	value, ok := d.Field(n.field)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "~":
+		return n.re.MatchString(value)
+	case "=":
+		return compare(value, n.value) == 0
+	case "!=":
+		return compare(value, n.value) != 0
+	case "<":
+		return compare(value, n.value) < 0
+	case "<=":
+		return compare(value, n.value) <= 0
+	case ">":
+		return compare(value, n.value) > 0
+	case ">=":
+		return compare(value, n.value) >= 0
+	}
+
+	return false
+}
+
+// Query is a compiled query
+type Query struct {
+	root node
+	raw  string
+}
+
+// Parse compiles a query
+func Parse(s string) (q *Query, err error) {
+	var p *parser
+
+	if p, err = newParser(s); err != nil {
+		return
+	}
+
+	q = &Query{raw: s}
+	if q.root, err = p.parse(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// MustParse compiles a query and panics on error
+func MustParse(s string) *Query {
+	q, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Match returns true if the document matches the query
+func (q *Query) Match(d Document) bool {
+	return q.root.match(d)
+}
+
+// String returns the query as it was parsed
+func (q *Query) String() string {
+	return q.raw
+}
+
+/////////////////// Parser
+
+const (
+	tokWord = iota
+	tokString
+	tokOperator
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func isWordChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("._-:/\\*", c) != -1
+}
+
+func tokenize(s string) (tokens []token, err error) {
+	tokens = make([]token, 0)
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '"':
+			sb := strings.Builder{}
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), i})
+			i = j + 1
+		case strings.IndexByte("=!<>~", c) != -1:
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' && c != '=' && c != '~' {
+				op += "="
+			}
+			n := len(op)
+			switch op {
+			case "!":
+				return nil, fmt.Errorf("unknown operator at position %d", i)
+			case "=":
+				// == is an alias for =
+				if i+1 < len(s) && s[i+1] == '=' {
+					n++
+				}
+			}
+			tokens = append(tokens, token{tokOperator, op, i})
+			i += n
+		case isWordChar(c):
+			j := i
+			for ; j < len(s) && isWordChar(s[j]); j++ {
+			}
+			tokens = append(tokens, token{tokWord, s[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+
+	return
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func newParser(s string) (p *parser, err error) {
+	p = &parser{}
+	p.tokens, err = tokenize(s)
+	return
+}
+
+func (p *parser) peek() *token {
+	if p.i < len(p.tokens) {
+		return &p.tokens[p.i]
+	}
+	return nil
+}
+
+func (p *parser) next() *token {
+	t := p.peek()
+	if t != nil {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t != nil && t.kind == tokWord && strings.EqualFold(t.value, kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parse() (n node, err error) {
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	if n, err = p.or(); err != nil {
+		return
+	}
+
+	if t := p.peek(); t != nil {
+		return nil, fmt.Errorf("unexpected token %q at position %d", t.value, t.pos)
+	}
+
+	return
+}
+
+func (p *parser) or() (n node, err error) {
+	var right node
+
+	if n, err = p.and(); err != nil {
+		return
+	}
+
+	for p.keyword("OR") {
+		if right, err = p.and(); err != nil {
+			return
+		}
+		n = &or{n, right}
+	}
+
+	return
+}
+
+func (p *parser) and() (n node, err error) {
+	var right node
+
+	if n, err = p.not(); err != nil {
+		return
+	}
+
+	for p.keyword("AND") {
+		if right, err = p.not(); err != nil {
+			return
+		}
+		n = &and{n, right}
+	}
+
+	return
+}
+
+func (p *parser) not() (n node, err error) {
+	if p.keyword("NOT") {
+		if n, err = p.not(); err != nil {
+			return
+		}
+		return &not{n}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (n node, err error) {
+	t := p.next()
+
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	switch t.kind {
+	case tokLParen:
+		if n, err = p.or(); err != nil {
+			return
+		}
+		if t = p.next(); t == nil || t.kind != tokRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return
+	case tokWord:
+		if strings.HasPrefix(strings.ToLower(t.value), "tag:") {
+			return p.tag(t)
+		}
+		return p.comparison(t)
+	}
+
+	return nil, fmt.Errorf("unexpected token %q at position %d", t.value, t.pos)
+}
+
+func (p *parser) tag(t *token) (n node, err error) {
+	name := t.value[len("tag:"):]
+
+	// tag:"some tag"
+	if name == "" {
+		if v := p.next(); v != nil && v.kind == tokString {
+			name = v.value
+		}
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("missing tag name at position %d", t.pos)
+	}
+
+	return &tag{name}, nil
+}
+
+func (p *parser) comparison(field *token) (n node, err error) {
+	op := p.next()
+	if op == nil || op.kind != tokOperator {
+		return nil, fmt.Errorf("expecting operator after %q at position %d", field.value, field.pos)
+	}
+
+	value := p.next()
+	if value == nil || (value.kind != tokWord && value.kind != tokString) {
+		return nil, fmt.Errorf("expecting value after %q at position %d", op.value, op.pos)
+	}
+
+	c := &comparison{field: field.value, op: op.value, value: value.value}
+	if c.op == "~" {
+		if c.re, err = regexp.Compile("(?i:" + c.value + ")"); err != nil {
+			return nil, fmt.Errorf("bad regular expression %q: %w", c.value, err)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+type doc struct {
+	fields map[string]string
+	tags   []string
+}
+
+func (d *doc) Field(name string) (s string, ok bool) {
+	s, ok = d.fields[name]
+	return
+}
+
+func (d *doc) HasTag(tag string) bool {
+	for _, t := range d.tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQuery(t *testing.T) {
+	tt := toast.FromT(t)
+
+	d := &doc{
+		fields: map[string]string{
+			"hostname":  "WS-0042",
+			"os.build":  "22621",
+			"os.name":   "windows",
+			"cpu.count": "8",
+		},
+		tags: []string{"laptop", "finance"},
+	}
+
+	match := []string{
+		"os.build >= 22000 AND tag:laptop",
+		"os.build > 9000",
+		"os.name = Windows",
+		"os.name == windows",
+		"hostname ~ \"^ws-\"",
+		"tag:laptop AND (cpu.count < 4 OR cpu.count >= 8)",
+		"NOT tag:server",
+		"NOT os.build < 22000",
+		"tag:\"finance\"",
+		"os.name != linux",
+		"hostname = \"WS-0042\" or tag:server",
+	}
+
+	nomatch := []string{
+		"os.build < 22000",
+		"tag:server",
+		"tag:laptop AND cpu.count < 8",
+		"NOT (tag:laptop OR tag:server)",
+		"missing = value",
+		"missing != value",
+		"hostname ~ srv",
+	}
+
+	for _, s := range match {
+		q, err := Parse(s)
+		tt.CheckErr(err)
+		tt.Assert(q.Match(d), s)
+		tt.Assert(q.String() == s)
+	}
+
+	for _, s := range nomatch {
+		tt.Assert(!MustParse(s).Match(d), s)
+	}
+
+	for _, s := range []string{
+		"",
+		"os.build >=",
+		"os.build 22000",
+		"(tag:laptop",
+		"tag:laptop)",
+		"tag:laptop AND",
+		"hostname ! ws",
+		"hostname = \"unterminated",
+		"hostname ~ \"[\"",
+		"tag:",
+		"$field = 1",
+	} {
+		_, err := Parse(s)
+		tt.Assert(err != nil, s)
+	}
+}
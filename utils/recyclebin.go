@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// number of 100ns intervals between 1601-01-01 and 1970-01-01
+	filetimeEpochDelta = 116444736000000000
+
+	recycleBinHeaderSize = 24
+	recycleBinV1PathSize = 520
+)
+
+var (
+	ErrBadRecycleBinIndex = errors.New("malformed recycle bin index file")
+)
+
+// RecycleBinIndex is the metadata of a file moved to the
+// Recycle Bin, as stored in $I files
+type RecycleBinIndex struct {
+	Version      int64     `json:"version"`
+	Size         int64     `json:"size"`
+	DeletionTime time.Time `json:"deletion-time"`
+	OriginalPath string    `json:"original-path"`
+}
+
+// FiletimeToTime converts a Windows FILETIME to time.Time (UTC)
+func FiletimeToTime(ft int64) time.Time {
+	return time.Unix(0, (ft-filetimeEpochDelta)*100).UTC()
+}
+
+func utf16ToString(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// ParseRecycleBinIndex parses the content of a Recycle Bin $I file,
+// both Vista/7/8 (version 1) and Windows 10+ (version 2) formats
+// are supported
+func ParseRecycleBinIndex(b []byte) (idx *RecycleBinIndex, err error) {
+	if len(b) < recycleBinHeaderSize {
+		return nil, ErrBadRecycleBinIndex
+	}
+
+	idx = &RecycleBinIndex{
+		Version:      int64(binary.LittleEndian.Uint64(b[0:])),
+		Size:         int64(binary.LittleEndian.Uint64(b[8:])),
+		DeletionTime: FiletimeToTime(int64(binary.LittleEndian.Uint64(b[16:]))),
+	}
+
+	b = b[recycleBinHeaderSize:]
+	switch idx.Version {
+	case 1:
+		if len(b) < recycleBinV1PathSize {
+			return nil, ErrBadRecycleBinIndex
+		}
+		idx.OriginalPath = utf16ToString(b[:recycleBinV1PathSize])
+	case 2:
+		if len(b) < 4 {
+			return nil, ErrBadRecycleBinIndex
+		}
+		// length in characters, including terminating null character
+		n := int(binary.LittleEndian.Uint32(b)) * 2
+		if b = b[4:]; n > len(b) {
+			return nil, ErrBadRecycleBinIndex
+		}
+		idx.OriginalPath = utf16ToString(b[:n])
+	default:
+		return nil, fmt.Errorf("unknown recycle bin index version %d", idx.Version)
+	}
+
+	return
+}
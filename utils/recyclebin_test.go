@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/toast"
+)
+
+func buildRecycleBinIndex(version int64, size int64, deleted time.Time, path string) []byte {
+	b := make([]byte, recycleBinHeaderSize)
+	binary.LittleEndian.PutUint64(b[0:], uint64(version))
+	binary.LittleEndian.PutUint64(b[8:], uint64(size))
+	binary.LittleEndian.PutUint64(b[16:], uint64(deleted.UnixNano()/100+filetimeEpochDelta))
+
+	u := append(utf16.Encode([]rune(path)), 0)
+	p := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(p[i*2:], c)
+	}
+
+	switch version {
+	case 1:
+		fixed := make([]byte, recycleBinV1PathSize)
+		copy(fixed, p)
+		b = append(b, fixed...)
+	case 2:
+		n := make([]byte, 4)
+		binary.LittleEndian.PutUint32(n, uint32(len(u)))
+		b = append(append(b, n...), p...)
+	}
+
+	return b
+}
+
+func TestParseRecycleBinIndex(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	path := `C:\Users\Jöhn\Documents\invoice.docx`
+	deleted := time.Date(2022, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	for _, v := range []int64{1, 2} {
+		idx, err := ParseRecycleBinIndex(buildRecycleBinIndex(v, 4242, deleted, path))
+		tt.CheckErr(err)
+		tt.Assert(idx.Version == v)
+		tt.Assert(idx.Size == 4242)
+		tt.Assert(idx.DeletionTime.Equal(deleted))
+		tt.Assert(idx.OriginalPath == path)
+	}
+
+	// truncated files
+	b := buildRecycleBinIndex(2, 4242, deleted, path)
+	_, err := ParseRecycleBinIndex(b[:len(b)-10])
+	tt.ExpectErr(err, ErrBadRecycleBinIndex)
+	_, err = ParseRecycleBinIndex(b[:10])
+	tt.ExpectErr(err, ErrBadRecycleBinIndex)
+
+	// unknown version
+	_, err = ParseRecycleBinIndex(buildRecycleBinIndex(3, 4242, deleted, path))
+	tt.Assert(err != nil)
+}
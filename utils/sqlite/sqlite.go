@@ -0,0 +1,602 @@
+// Package sqlite implements a minimal, read-only, SQLite database reader.
+//
+// It only aims at reading rows of tables (i.e. browser history databases)
+// without any dependency on cgo. Indexes, views and virtual tables are not
+// supported, neither is querying. Committed frames of a write-ahead log
+// (-wal file) found next to the database are taken into account, as
+// applications keeping databases open (browsers) often have most recent
+// data in the WAL.
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	headerSize = 100
+	magic      = "SQLite format 3\x00"
+
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+
+	pageTableInterior = 0x05
+	pageTableLeaf     = 0x0d
+
+	// maximum depth of a b-tree, protects against corrupted files
+	maxDepth = 64
+)
+
+var (
+	ErrNotSQLite       = errors.New("not a SQLite database")
+	ErrCorrupted       = errors.New("corrupted database")
+	ErrNoSuchTable     = errors.New("no such table")
+	ErrUnsupportedText = errors.New("unsupported text encoding")
+)
+
+// DB is a SQLite database opened read-only
+type DB struct {
+	data       []byte
+	pageSize   int
+	usableSize int
+	// pages overwritten by the WAL
+	wal map[uint32][]byte
+
+	tables map[string]*Table
+}
+
+// Open opens a database file, the WAL file (path suffixed with -wal)
+// is also read if it exists
+func Open(path string) (db *DB, err error) {
+	var data, wal []byte
+
+	if data, err = os.ReadFile(path); err != nil {
+		return
+	}
+
+	if wal, err = os.ReadFile(path + "-wal"); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	return FromBytes(data, wal)
+}
+
+// FromBytes opens a database from its content and the content of
+// its WAL file, wal can be nil
+func FromBytes(data, wal []byte) (db *DB, err error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, ErrNotSQLite
+	}
+
+	db = &DB{data: data, wal: make(map[uint32][]byte)}
+
+	db.pageSize = int(binary.BigEndian.Uint16(data[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, ErrCorrupted
+	}
+
+	db.usableSize = db.pageSize - int(data[20])
+
+	if enc := binary.BigEndian.Uint32(data[56:]); enc > 1 {
+		return nil, ErrUnsupportedText
+	}
+
+	if len(wal) > 0 {
+		db.loadWAL(wal)
+	}
+
+	if err = db.loadSchema(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// loadWAL indexes pages of committed transactions of a WAL. Frames are
+// only validated with salts, so checksums are not verified.
+func (db *DB) loadWAL(wal []byte) {
+	if len(wal) < walHeaderSize {
+		return
+	}
+
+	if m := binary.BigEndian.Uint32(wal); m != 0x377f0682 && m != 0x377f0683 {
+		return
+	}
+
+	pageSize := int(binary.BigEndian.Uint32(wal[8:]))
+	if pageSize != db.pageSize {
+		return
+	}
+
+	salt1 := binary.BigEndian.Uint32(wal[16:])
+	salt2 := binary.BigEndian.Uint32(wal[20:])
+
+	pending := make(map[uint32][]byte)
+	for off := walHeaderSize; off+walFrameHeaderSize+pageSize <= len(wal); off += walFrameHeaderSize + pageSize {
+		fh := wal[off : off+walFrameHeaderSize]
+
+		// frame from a previous WAL generation
+		if binary.BigEndian.Uint32(fh[8:]) != salt1 || binary.BigEndian.Uint32(fh[12:]) != salt2 {
+			break
+		}
+
+		pgno := binary.BigEndian.Uint32(fh)
+		pending[pgno] = wal[off+walFrameHeaderSize : off+walFrameHeaderSize+pageSize]
+
+		// commit frame
+		if binary.BigEndian.Uint32(fh[4:]) != 0 {
+			for n, p := range pending {
+				db.wal[n] = p
+			}
+			pending = make(map[uint32][]byte)
+		}
+	}
+}
+
+// page returns the content of a page, pages are numbered from 1
+func (db *DB) page(n uint32) ([]byte, error) {
+	if p, ok := db.wal[n]; ok {
+		return p, nil
+	}
+
+	off := int(n-1) * db.pageSize
+	if n == 0 || off+db.pageSize > len(db.data) {
+		return nil, ErrCorrupted
+	}
+
+	return db.data[off : off+db.pageSize], nil
+}
+
+// walk calls fn on every row of the table b-tree rooted at page root
+func (db *DB) walk(root uint32, depth int, fn func(rowid int64, payload []byte) error) (err error) {
+	var p []byte
+
+	if depth > maxDepth {
+		return ErrCorrupted
+	}
+
+	if p, err = db.page(root); err != nil {
+		return
+	}
+
+	// page 1 starts with database header
+	hdr := 0
+	if root == 1 {
+		hdr = headerSize
+	}
+
+	if len(p) < hdr+8 {
+		return ErrCorrupted
+	}
+
+	typ := p[hdr]
+	ncells := int(binary.BigEndian.Uint16(p[hdr+3:]))
+	ptrs := hdr + 8
+	if typ == pageTableInterior {
+		ptrs = hdr + 12
+	}
+
+	if ptrs+ncells*2 > len(p) {
+		return ErrCorrupted
+	}
+
+	for i := 0; i < ncells; i++ {
+		off := int(binary.BigEndian.Uint16(p[ptrs+i*2:]))
+		if off >= len(p) {
+			return ErrCorrupted
+		}
+
+		switch typ {
+		case pageTableInterior:
+			if off+4 > len(p) {
+				return ErrCorrupted
+			}
+			if err = db.walk(binary.BigEndian.Uint32(p[off:]), depth+1, fn); err != nil {
+				return
+			}
+		case pageTableLeaf:
+			var rowid int64
+			var payload []byte
+
+			if rowid, payload, err = db.leafCell(p[off:]); err != nil {
+				return
+			}
+
+			if err = fn(rowid, payload); err != nil {
+				return
+			}
+		default:
+			return ErrCorrupted
+		}
+	}
+
+	if typ == pageTableInterior {
+		return db.walk(binary.BigEndian.Uint32(p[hdr+8:]), depth+1, fn)
+	}
+
+	return
+}
+
+// leafCell decodes a table leaf cell, following overflow pages if needed
+func (db *DB) leafCell(c []byte) (rowid int64, payload []byte, err error) {
+	var n, m int
+	var size uint64
+
+	if size, n = uvarint(c); n == 0 {
+		return 0, nil, ErrCorrupted
+	}
+
+	u, m := uvarint(c[n:])
+	if m == 0 {
+		return 0, nil, ErrCorrupted
+	}
+	rowid = int64(u)
+	c = c[n+m:]
+
+	// computation of the amount of payload stored on the page
+	// see https://www.sqlite.org/fileformat.html#b_tree_pages
+	usable := db.usableSize
+	x := usable - 35
+	local := int(size)
+	if int(size) > x {
+		min := ((usable - 12) * 32 / 255) - 23
+		k := min + ((int(size) - min) % (usable - 4))
+		if k <= x {
+			local = k
+		} else {
+			local = min
+		}
+	}
+
+	if local > len(c) || int(size) < 0 {
+		return 0, nil, ErrCorrupted
+	}
+
+	payload = make([]byte, 0, size)
+	payload = append(payload, c[:local]...)
+
+	if local < int(size) {
+		if local+4 > len(c) {
+			return 0, nil, ErrCorrupted
+		}
+
+		next := binary.BigEndian.Uint32(c[local:])
+		for visited := 0; len(payload) < int(size); visited++ {
+			var p []byte
+
+			if next == 0 || visited > len(db.data)/db.pageSize+len(db.wal) {
+				return 0, nil, ErrCorrupted
+			}
+
+			if p, err = db.page(next); err != nil {
+				return
+			}
+
+			chunk := p[4:usable]
+			if rem := int(size) - len(payload); rem < len(chunk) {
+				chunk = chunk[:rem]
+			}
+			payload = append(payload, chunk...)
+			next = binary.BigEndian.Uint32(p)
+		}
+	}
+
+	return
+}
+
+// uvarint decodes a SQLite varint, it returns the number
+// of bytes read, 0 if buffer is too small
+func uvarint(b []byte) (v uint64, n int) {
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return
+}
+
+// record decodes a record into values of type nil, int64,
+// float64, string or []byte
+func record(payload []byte) (values []interface{}, err error) {
+	hsize, n := uvarint(payload)
+	if n == 0 || int(hsize) > len(payload) {
+		return nil, ErrCorrupted
+	}
+
+	types := make([]uint64, 0)
+	for off := n; off < int(hsize); {
+		t, m := uvarint(payload[off:hsize])
+		if m == 0 {
+			return nil, ErrCorrupted
+		}
+		types = append(types, t)
+		off += m
+	}
+
+	body := payload[hsize:]
+	values = make([]interface{}, 0, len(types))
+	for _, t := range types {
+		var v interface{}
+		var size int
+
+		switch {
+		case t == 0:
+			size = 0
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t == 8, t == 9:
+			v = int64(t - 8)
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, ErrCorrupted
+		}
+
+		if size > len(body) {
+			return nil, ErrCorrupted
+		}
+
+		b := body[:size]
+		switch {
+		case t >= 1 && t <= 6:
+			// big-endian two's complement integers
+			i := int64(int8(b[0]))
+			for _, c := range b[1:] {
+				i = i<<8 | int64(c)
+			}
+			v = i
+		case t == 7:
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+		case t >= 12 && t%2 == 0:
+			v = append([]byte{}, b...)
+		case t >= 13:
+			v = string(b)
+		}
+
+		values = append(values, v)
+		body = body[size:]
+	}
+
+	return
+}
+
+// Table is a table of the database
+type Table struct {
+	db   *DB
+	root uint32
+
+	Name    string
+	Columns []string
+	// columns with REAL affinity
+	real []bool
+	// index of the column aliasing rowid (INTEGER PRIMARY KEY), -1 if none
+	rowidAlias int
+}
+
+// Row is a row of a table, values are indexed by column name
+type Row map[string]interface{}
+
+// Int returns the value of an integer column, 0 if not an integer
+func (r Row) Int(col string) int64 {
+	if i, ok := r[col].(int64); ok {
+		return i
+	}
+	return 0
+}
+
+// String returns the value of a text column, empty string if not a text
+func (r Row) String(col string) string {
+	switch v := r[col].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Rows calls fn on every row of the table, iteration stops if fn returns an error
+func (t *Table) Rows(fn func(r Row) error) error {
+	return t.db.walk(t.root, 0, func(rowid int64, payload []byte) error {
+		values, err := record(payload)
+		if err != nil {
+			return err
+		}
+
+		r := make(Row, len(t.Columns))
+		for i, c := range t.Columns {
+			// columns added with ALTER TABLE may be missing from old records
+			if i >= len(values) {
+				r[c] = nil
+				continue
+			}
+
+			// REAL values without fractional part are stored as integers
+			if v, ok := values[i].(int64); ok && t.real[i] {
+				r[c] = float64(v)
+			} else {
+				r[c] = values[i]
+			}
+		}
+
+		if t.rowidAlias >= 0 {
+			r[t.Columns[t.rowidAlias]] = rowid
+		}
+
+		return fn(r)
+	})
+}
+
+func (db *DB) loadSchema() error {
+	db.tables = make(map[string]*Table)
+
+	return db.walk(1, 0, func(rowid int64, payload []byte) error {
+		values, err := record(payload)
+		if err != nil {
+			return err
+		}
+
+		// type, name, tbl_name, rootpage, sql
+		if len(values) < 5 {
+			return ErrCorrupted
+		}
+
+		typ, _ := values[0].(string)
+		name, _ := values[1].(string)
+		root, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+
+		// virtual tables have no root page
+		if typ != "table" || root == 0 {
+			return nil
+		}
+
+		t := &Table{db: db, root: uint32(root), Name: name}
+		if t.Columns, t.real, t.rowidAlias, err = parseColumns(sql); err != nil {
+			return fmt.Errorf("failed to parse schema of table %s: %w", name, err)
+		}
+
+		db.tables[strings.ToLower(name)] = t
+		return nil
+	})
+}
+
+// Tables returns the names of the tables of the database
+func (db *DB) Tables() (names []string) {
+	names = make([]string, 0, len(db.tables))
+	for _, t := range db.tables {
+		names = append(names, t.Name)
+	}
+	return
+}
+
+// Table returns a table given its name (case insensitive)
+func (db *DB) Table(name string) (*Table, error) {
+	if t, ok := db.tables[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, name)
+}
+
+// splitName splits a column definition into the column name,
+// unquoted, and the rest of the definition
+func splitName(def string) (name, rest string) {
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return
+	}
+
+	end := byte(0)
+	switch def[0] {
+	case '"', '`', '\'':
+		end = def[0]
+	case '[':
+		end = ']'
+	}
+
+	if end != 0 {
+		if i := strings.IndexByte(def[1:], end); i >= 0 {
+			return def[1 : i+1], def[i+2:]
+		}
+	}
+
+	if i := strings.IndexAny(def, " \t\r\n"); i >= 0 {
+		return def[:i], def[i:]
+	}
+	return def, ""
+}
+
+// realAffinity returns true if declared type of a column has REAL affinity
+// see https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func realAffinity(decl string) bool {
+	decl = strings.ToUpper(decl)
+	switch {
+	case strings.Contains(decl, "INT"), strings.Contains(decl, "CHAR"),
+		strings.Contains(decl, "CLOB"), strings.Contains(decl, "TEXT"),
+		strings.Contains(decl, "BLOB"):
+		return false
+	}
+	return strings.Contains(decl, "REAL") || strings.Contains(decl, "FLOA") || strings.Contains(decl, "DOUB")
+}
+
+// parseColumns extracts column names and affinities out of a CREATE TABLE statement
+func parseColumns(sql string) (cols []string, real []bool, rowidAlias int, err error) {
+	rowidAlias = -1
+
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, nil, -1, ErrCorrupted
+	}
+
+	// splitting column definitions on top level commas
+	defs := make([]string, 0)
+	depth, last := 0, start+1
+	var quote byte
+	for i := start + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote || (quote == '[' && c == ']') {
+				quote = 0
+			}
+		case c == '"' || c == '`' || c == '\'' || c == '[':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[last:i])
+			last = i + 1
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	cols = make([]string, 0, len(defs))
+	real = make([]bool, 0, len(defs))
+	for _, d := range defs {
+		name, rest := splitName(d)
+		if name == "" {
+			continue
+		}
+
+		// table constraints
+		switch strings.ToUpper(name) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue
+		}
+
+		fields := strings.Fields(strings.ToUpper(rest))
+		if strings.Contains(strings.Join(fields, " "), "INTEGER PRIMARY KEY") {
+			rowidAlias = len(cols)
+		}
+
+		cols = append(cols, name)
+		real = append(real, len(fields) > 0 && realAffinity(fields[0]))
+	}
+
+	return
+}
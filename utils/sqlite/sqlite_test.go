@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/toast"
+)
+
+// testdata databases are generated with python sqlite3 module, with
+// a page size of 512 so that they contain interior and overflow pages
+
+func TestReadTable(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	db, err := Open(filepath.Join("testdata", "test.db"))
+	tt.CheckErr(err)
+
+	tbl, err := db.Table("URLS")
+	tt.CheckErr(err)
+	tt.Assert(strings.Join(tbl.Columns, ",") == "id,url,title,visit_count,last_visit_time,score,data,hidden")
+
+	n := 0
+	tt.CheckErr(tbl.Rows(func(r Row) error {
+		n++
+		id := r.Int("id")
+		tt.Assert(id == int64(n))
+
+		if id <= 300 {
+			tt.Assert(r.String("url") == format("https://example.com/%d", id))
+			tt.Assert(r.String("title") == format("Title %d", id))
+			tt.Assert(r.Int("visit_count") == id)
+			tt.Assert(r.Int("last_visit_time") == 13300000000000000+id)
+			tt.Assert(r["score"].(float64) == float64(id)/2)
+			tt.Assert(bytes.Equal(r["data"].([]byte), bytes.Repeat([]byte{byte(id % 256)}, 3)))
+		} else {
+			// row with overflow pages
+			tt.Assert(r.String("url") == "https://long.example.com/"+strings.Repeat("a", 5000))
+			tt.Assert(r["title"] == nil)
+			tt.Assert(r.Int("visit_count") == -42)
+			tt.Assert(r["score"].(float64) == -1.5)
+		}
+
+		// column added after rows were inserted
+		tt.Assert(r["hidden"] == nil)
+		return nil
+	}))
+	tt.Assert(n == 301)
+
+	// quoted names and table constraints
+	tbl, err = db.Table("quoted table")
+	tt.CheckErr(err)
+	tt.Assert(strings.Join(tbl.Columns, ",") == "first col,second")
+
+	_, err = db.Table("unknown")
+	tt.ExpectErr(err, ErrNoSuchTable)
+}
+
+func TestReadWAL(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	count := func(db *DB) (n int) {
+		tbl, err := db.Table("t")
+		tt.CheckErr(err)
+		tt.CheckErr(tbl.Rows(func(r Row) error {
+			n++
+			tt.Assert(r.String("v") == format("value %d", r.Int("id")))
+			return nil
+		}))
+		return
+	}
+
+	// rows are only in the WAL
+	db, err := Open(filepath.Join("testdata", "wal.db"))
+	tt.CheckErr(err)
+	tt.Assert(count(db) == 20)
+
+	data, err := os.ReadFile(filepath.Join("testdata", "wal.db"))
+	tt.CheckErr(err)
+	db, err = FromBytes(data, nil)
+	tt.CheckErr(err)
+	tt.Assert(count(db) == 0)
+}
+
+func TestCorrupted(t *testing.T) {
+	t.Parallel()
+
+	tt := toast.FromT(t)
+
+	_, err := FromBytes([]byte("not a database"), nil)
+	tt.ExpectErr(err, ErrNotSQLite)
+
+	data, err := os.ReadFile(filepath.Join("testdata", "test.db"))
+	tt.CheckErr(err)
+
+	// truncated database must not panic
+	for _, size := range []int{100, 512, 1024, 4096, len(data) / 2} {
+		if db, err := FromBytes(data[:size], nil); err == nil {
+			for _, name := range db.Tables() {
+				tbl, _ := db.Table(name)
+				tbl.Rows(func(r Row) error { return nil })
+			}
+		}
+	}
+
+	// garbage pages must not panic
+	garbage := append([]byte{}, data...)
+	for i := 512; i < len(garbage); i += 7 {
+		garbage[i] ^= 0xff
+	}
+	if db, err := FromBytes(garbage, nil); err == nil {
+		for _, name := range db.Tables() {
+			tbl, _ := db.Table(name)
+			tbl.Rows(func(r Row) error { return nil })
+		}
+	}
+}
+
+func format(f string, a ...interface{}) string {
+	return fmt.Sprintf(f, a...)
+}
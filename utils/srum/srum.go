@@ -0,0 +1,217 @@
+// Package srum extracts per application usage history out of the System
+// Resource Usage Monitor (SRUM) database (C:\Windows\System32\sru\SRUDB.dat)
+package srum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/whids/utils/ese"
+)
+
+const (
+	IDMapTable            = "SruDbIdMapTable"
+	NetworkUsageTable     = "{973F5D5C-1D90-4944-BE8E-24B94231A174}"
+	AppResourceUsageTable = "{D10CA2FE-6FCF-4F6D-848E-B2E99266FA89}"
+
+	// IdBlob of SruDbIdMapTable is a SID for this IdType,
+	// an UTF-16 string otherwise
+	idTypeSID = 3
+)
+
+// SIDToString converts a binary SID to its string representation
+func SIDToString(b []byte) string {
+	if len(b) < 8 || len(b) < 8+4*int(b[1]) {
+		return ""
+	}
+
+	var auth uint64
+	for _, c := range b[2:8] {
+		auth = auth<<8 | uint64(c)
+	}
+
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "S-%d-%d", b[0], auth)
+	for i := 0; i < int(b[1]); i++ {
+		fmt.Fprintf(&sb, "-%d", binary.LittleEndian.Uint32(b[8+4*i:]))
+	}
+
+	return sb.String()
+}
+
+// IDMap maps identifiers used in SRUM tables to application names and user SIDs
+type IDMap map[int64]string
+
+// Add adds a record of SruDbIdMapTable to the map
+func (m IDMap) Add(r ese.Row) {
+	blob := r.Bytes("IdBlob")
+	if blob == nil {
+		return
+	}
+
+	if r.Int("IdType") == idTypeSID {
+		m[r.Int("IdIndex")] = SIDToString(blob)
+	} else {
+		m[r.Int("IdIndex")] = ese.UTF16ToString(blob)
+	}
+}
+
+// Name returns the name corresponding to an identifier, the identifier
+// itself if it is unknown
+func (m IDMap) Name(id int64) string {
+	if n, ok := m[id]; ok {
+		return n
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+// AppUsage is the resource usage of an application run by a user
+type AppUsage struct {
+	App  string `json:"app"`
+	User string `json:"user"`
+	// network usage
+	BytesSent     int64 `json:"bytes-sent"`
+	BytesReceived int64 `json:"bytes-received"`
+	// execution times
+	ForegroundCycleTime int64 `json:"foreground-cycle-time"`
+	BackgroundCycleTime int64 `json:"background-cycle-time"`
+	FaceTime            int64 `json:"face-time"`
+	// disk usage
+	BytesRead    int64 `json:"bytes-read"`
+	BytesWritten int64 `json:"bytes-written"`
+	// SRUM records are written about every hour
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+	Records   int       `json:"records"`
+
+	appID  int64
+	userID int64
+}
+
+func (u *AppUsage) seen(t time.Time) {
+	if u.FirstSeen.IsZero() || t.Before(u.FirstSeen) {
+		u.FirstSeen = t
+	}
+	if t.After(u.LastSeen) {
+		u.LastSeen = t
+	}
+	u.Records++
+}
+
+// Report aggregates SRUM records by application and user
+type Report struct {
+	Since time.Time   `json:"since"`
+	Apps  []*AppUsage `json:"apps"`
+
+	usage map[[2]int64]*AppUsage
+}
+
+// NewReport creates a new Report, only records more recent than since are
+// taken into account
+func NewReport(since time.Time) *Report {
+	return &Report{Since: since, Apps: make([]*AppUsage, 0), usage: make(map[[2]int64]*AppUsage)}
+}
+
+func (r *Report) get(row ese.Row) (u *AppUsage) {
+	var ok bool
+
+	k := [2]int64{row.Int("AppId"), row.Int("UserId")}
+	if u, ok = r.usage[k]; !ok {
+		u = &AppUsage{appID: k[0], userID: k[1]}
+		r.usage[k] = u
+		r.Apps = append(r.Apps, u)
+	}
+
+	return
+}
+
+// AddNetwork adds a record of the network data usage table
+func (r *Report) AddNetwork(row ese.Row) {
+	ts := row.Time("TimeStamp")
+	if ts.Before(r.Since) {
+		return
+	}
+
+	u := r.get(row)
+	u.BytesSent += row.Int("BytesSent")
+	u.BytesReceived += row.Int("BytesRecvd")
+	u.seen(ts)
+}
+
+// AddResource adds a record of the application resource usage table
+func (r *Report) AddResource(row ese.Row) {
+	ts := row.Time("TimeStamp")
+	if ts.Before(r.Since) {
+		return
+	}
+
+	u := r.get(row)
+	u.ForegroundCycleTime += row.Int("ForegroundCycleTime")
+	u.BackgroundCycleTime += row.Int("BackgroundCycleTime")
+	u.FaceTime += row.Int("FaceTime")
+	u.BytesRead += row.Int("ForegroundBytesRead") + row.Int("BackgroundBytesRead")
+	u.BytesWritten += row.Int("ForegroundBytesWritten") + row.Int("BackgroundBytesWritten")
+	u.seen(ts)
+}
+
+// Resolve resolves application and user names and sorts applications
+// by bytes sent, most likely the ones interesting to spot exfiltration
+func (r *Report) Resolve(m IDMap) {
+	for _, u := range r.Apps {
+		u.App = m.Name(u.appID)
+		u.User = m.Name(u.userID)
+	}
+
+	sort.SliceStable(r.Apps, func(i, j int) bool {
+		if r.Apps[i].BytesSent == r.Apps[j].BytesSent {
+			return r.Apps[i].BytesReceived > r.Apps[j].BytesReceived
+		}
+		return r.Apps[i].BytesSent > r.Apps[j].BytesSent
+	})
+}
+
+// Parse builds a Report out of a SRUM database
+func Parse(db *ese.DB, since time.Time) (r *Report, err error) {
+	var t *ese.Table
+
+	r = NewReport(since)
+	m := make(IDMap)
+
+	if t, err = db.Table(IDMapTable); err != nil {
+		return
+	}
+
+	if err = t.Rows(func(row ese.Row) error {
+		m.Add(row)
+		return nil
+	}); err != nil {
+		return
+	}
+
+	tables := []struct {
+		name string
+		add  func(ese.Row)
+	}{
+		{NetworkUsageTable, r.AddNetwork},
+		{AppResourceUsageTable, r.AddResource},
+	}
+
+	for _, tbl := range tables {
+		if t, err = db.Table(tbl.name); err != nil {
+			return
+		}
+
+		if err = t.Rows(func(row ese.Row) error {
+			tbl.add(row)
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+
+	r.Resolve(m)
+	return
+}
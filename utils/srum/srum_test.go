@@ -0,0 +1,71 @@
+package srum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+	"github.com/0xrawsec/whids/utils/ese"
+)
+
+func utf16le(s string) (b []byte) {
+	for _, c := range s {
+		b = append(b, byte(c), 0)
+	}
+	return append(b, 0, 0)
+}
+
+func TestSIDToString(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// S-1-5-18
+	tt.Assert(SIDToString([]byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}) == "S-1-5-18")
+	// S-1-5-21-1-2-3-1001
+	sid := []byte{1, 5, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 0xe9, 3, 0, 0}
+	tt.Assert(SIDToString(sid) == "S-1-5-21-1-2-3-1001", SIDToString(sid))
+	// truncated
+	tt.Assert(SIDToString(sid[:20]) == "")
+}
+
+func TestReport(t *testing.T) {
+	tt := toast.FromT(t)
+
+	now := time.Date(2023, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	m := make(IDMap)
+	m.Add(ese.Row{"IdType": int64(0), "IdIndex": int64(1), "IdBlob": utf16le(`\Device\HarddiskVolume2\Windows\System32\curl.exe`)})
+	m.Add(ese.Row{"IdType": int64(0), "IdIndex": int64(2), "IdBlob": utf16le(`\Device\HarddiskVolume2\Windows\explorer.exe`)})
+	m.Add(ese.Row{"IdType": int64(3), "IdIndex": int64(3), "IdBlob": []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}})
+	m.Add(ese.Row{"IdType": int64(0), "IdIndex": int64(4), "IdBlob": nil})
+
+	r := NewReport(now.Add(-24 * time.Hour))
+
+	for i := 0; i < 3; i++ {
+		r.AddNetwork(ese.Row{"TimeStamp": now.Add(-time.Duration(i) * time.Hour), "AppId": int64(1), "UserId": int64(3), "BytesSent": int64(1 << 30), "BytesRecvd": int64(10)})
+		r.AddNetwork(ese.Row{"TimeStamp": now, "AppId": int64(2), "UserId": int64(3), "BytesSent": int64(100), "BytesRecvd": int64(1000)})
+	}
+	// too old
+	r.AddNetwork(ese.Row{"TimeStamp": now.Add(-48 * time.Hour), "AppId": int64(2), "UserId": int64(3), "BytesSent": int64(1 << 40)})
+	r.AddResource(ese.Row{"TimeStamp": now, "AppId": int64(1), "UserId": int64(3), "ForegroundCycleTime": int64(42), "FaceTime": int64(7), "BackgroundBytesWritten": int64(5)})
+	// unknown application
+	r.AddResource(ese.Row{"TimeStamp": now, "AppId": int64(5), "UserId": int64(3), "ForegroundCycleTime": int64(1)})
+
+	r.Resolve(m)
+
+	tt.Assert(len(r.Apps) == 3)
+
+	curl := r.Apps[0]
+	tt.Assert(curl.App == `\Device\HarddiskVolume2\Windows\System32\curl.exe`)
+	tt.Assert(curl.User == "S-1-5-18")
+	tt.Assert(curl.BytesSent == 3<<30)
+	tt.Assert(curl.BytesReceived == 30)
+	tt.Assert(curl.ForegroundCycleTime == 42)
+	tt.Assert(curl.FaceTime == 7)
+	tt.Assert(curl.BytesWritten == 5)
+	tt.Assert(curl.Records == 4)
+	tt.Assert(curl.FirstSeen.Equal(now.Add(-2 * time.Hour)))
+	tt.Assert(curl.LastSeen.Equal(now))
+
+	tt.Assert(r.Apps[1].BytesSent == 300)
+	tt.Assert(r.Apps[2].App == "5")
+}
@@ -34,6 +34,21 @@ func ExpandEnvs(s ...string) (o []string) {
 	return
 }
 
+// ExpandKnownEnvs expands several strings with environment variables,
+// unlike ExpandEnvs variables not set are left as is (i.e. $MFT)
+func ExpandKnownEnvs(s ...string) (o []string) {
+	o = make([]string, len(s))
+	for i := range s {
+		o[i] = os.Expand(s[i], func(name string) string {
+			if v, ok := os.LookupEnv(name); ok {
+				return v
+			}
+			return "$" + name
+		})
+	}
+	return
+}
+
 func gobBytes(i any) (b []byte, err error) {
 	buf := new(bytes.Buffer)
 	enc := gob.NewEncoder(buf)
@@ -84,6 +84,16 @@ func TestExpandEnvs(t *testing.T) {
 	}
 }
 
+func TestExpandKnownEnvs(t *testing.T) {
+	tt := toast.FromT(t)
+
+	t.Setenv("WHIDS_TEST_DRIVE", "C:")
+
+	exp := ExpandKnownEnvs(`$WHIDS_TEST_DRIVE\$MFT`, `${WHIDS_TEST_DRIVE}\Windows`)
+	tt.Assert(exp[0] == `C:\$MFT`)
+	tt.Assert(exp[1] == `C:\Windows`)
+}
+
 func TestSha256StringSlice(t *testing.T) {
 	t.Parallel()
 
@@ -149,3 +159,22 @@ func TestUtf16ToUtf8(t *testing.T) {
 	t.Log(string(utf8))
 
 }
+
+func TestSystemPaths(t *testing.T) {
+	tt := toast.FromT(t)
+
+	tt.Assert(MachineArch(ImageFileMachineARM64) == ArchARM64)
+	tt.Assert(MachineArch(0x1234) == ArchUnknown)
+
+	tt.Assert(CanonicalSystemPath(`C:\Windows\Sysnative\cmd.exe`) == `C:\Windows\System32\cmd.exe`)
+	tt.Assert(CanonicalSystemPath(`C:\Windows\sysnative`) == `C:\Windows\System32`)
+	tt.Assert(CanonicalSystemPath(`C:\Windows\SysnativeFoo\cmd.exe`) == `C:\Windows\SysnativeFoo\cmd.exe`)
+
+	tt.Assert(NativeSystemPath(`C:\Windows\System32\cmd.exe`, false) == `C:\Windows\System32\cmd.exe`)
+	tt.Assert(NativeSystemPath(`C:\WINDOWS\system32\cmd.exe`, true) == `C:\WINDOWS\Sysnative\cmd.exe`)
+	tt.Assert(NativeSystemPath(`C:\Program Files\System32\foo.exe`, true) == `C:\Program Files\System32\foo.exe`)
+
+	tt.Assert(RedirectedSystemPath(`C:\Windows\System32\cmd.exe`, ImageFileMachineI386) == `C:\Windows\SysWOW64\cmd.exe`)
+	tt.Assert(RedirectedSystemPath(`C:\Windows\System32\cmd.exe`, ImageFileMachineARMNT) == `C:\Windows\SysArm32\cmd.exe`)
+	tt.Assert(RedirectedSystemPath(`C:\Windows\System32\cmd.exe`, ImageFileMachineUnknown) == `C:\Windows\System32\cmd.exe`)
+}
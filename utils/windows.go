@@ -11,12 +11,25 @@ import (
 
 	"github.com/0xrawsec/golang-win32/win32/advapi32"
 	"github.com/0xrawsec/golang-win32/win32/kernel32"
+	"golang.org/x/sys/windows/registry"
 )
 
 var (
 	cDriveDeviceRe *regexp.Regexp
 )
 
+// EnableProcessCmdLineAudit makes process creation audit
+// events (Security 4688) include process command line
+func EnableProcessCmdLineAudit() error {
+	k, _, err := registry.CreateKey(registry.LOCAL_MACHINE, `Software\Microsoft\Windows\CurrentVersion\Policies\System\Audit`, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	return k.SetDWordValue("ProcessCreationIncludeCmdLine_Enabled", 1)
+}
+
 // ArgvFromCommandLine returns an argv slice given a command line
 // provided in argument
 func ArgvFromCommandLine(cl string) (argv []string, err error) {